@@ -0,0 +1,178 @@
+/*
+ * Awards achievements to users based on their song history. Achievements are
+ * stored once earned, so they stick around after the history that earned them
+ * is pruned.
+ */
+
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	achievementFirstSong   = "first_song"   // submitted the first song of a night
+	achievementCentury     = "century"      // submitted 100 songs
+	achievementMostSkipped = "most_skipped" // has the most skipped songs
+	achievementMarathon    = "marathon_dj"  // submitted a lot of songs in one night
+
+	centurySongs          = 100 // songs needed for the century achievement
+	marathonSongs         = 20  // songs in one night needed for the marathon achievement
+	defaultLeaderboardLen = 10  // users returned when the client doesn't ask
+)
+
+/*
+ * Display information about an achievement
+ */
+type achievementInfo struct {
+	name        string // display name
+	description string // what the user did to earn it
+}
+
+var achievementInfos = map[string]achievementInfo{
+	achievementFirstSong:   {"Opening Act", "Submitted the first song of the night."},
+	achievementCentury:     {"Century", "Submitted 100 songs."},
+	achievementMostSkipped: {"Tough Crowd", "Had more songs skipped than anyone else."},
+	achievementMarathon:    {"Marathon DJ", "Submitted 20 songs in a single night."},
+}
+
+/*
+ * Computes and stores the achievements earned by users
+ */
+type achievementTracker struct {
+	dbManager db.DbManager // database holding the history and achievements
+	lock      sync.Mutex   // only one refresh at a time
+}
+
+/*
+ * Initialize the achievement tracker
+ */
+func (t *achievementTracker) init(dbManager db.DbManager) {
+	t.dbManager = dbManager
+}
+
+/*
+ * Award the achievements users have earned since the last refresh
+ */
+func (t *achievementTracker) refresh() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	totals, err := t.dbManager.GetHistoryTotals()
+	if err != nil {
+		return err
+	}
+
+	for userId, earned := range evaluateAchievements(totals) {
+		for _, achievementId := range earned {
+			added, err := t.dbManager.AddAchievement(userId, achievementId)
+			if err != nil {
+				return err
+			}
+
+			if added {
+				log.Printf("User %d earned achievement %s", userId, achievementId)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the achievements earned by a user
+ */
+func (t *achievementTracker) list(userId uint32) ([]*bepb.Achievement, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+
+	earned, err := t.dbManager.GetAchievements(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	achievements := make([]*bepb.Achievement, 0, len(earned))
+	for _, data := range earned {
+		info := achievementInfos[data.AchievementId]
+		achievements = append(achievements, &bepb.Achievement{
+			Id:          data.AchievementId,
+			Name:        info.name,
+			Description: info.description,
+			EarnDate:    data.EarnDate.Format(time.RFC3339),
+		})
+	}
+
+	return achievements, nil
+}
+
+/*
+ * Returns the users with the most submitted songs
+ */
+func (t *achievementTracker) leaderboard(limit int) ([]*bepb.LeaderboardEntry, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+
+	totals, err := t.dbManager.GetHistoryTotals()
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultLeaderboardLen
+	}
+
+	entries := make([]*bepb.LeaderboardEntry, 0, limit)
+	for i := 0; i < len(totals) && i < limit; i++ {
+		entries = append(entries, &bepb.LeaderboardEntry{
+			UserId:           totals[i].UserId,
+			Username:         totals[i].Username,
+			SongCount:        totals[i].Songs,
+			SkipCount:        totals[i].Skips,
+			AchievementCount: totals[i].Achievements,
+		})
+	}
+
+	return entries, nil
+}
+
+/*
+ * Work out which achievements each user has earned from their history totals.
+ * Returns a map of user id to achievement ids.
+ */
+func evaluateAchievements(totals []*db.HistoryTotalsData) map[uint32][]string {
+	earned := make(map[uint32][]string)
+
+	var mostSkips uint32
+	for _, total := range totals {
+		if total.Skips > mostSkips {
+			mostSkips = total.Skips
+		}
+	}
+
+	for _, total := range totals {
+		if total.NightOpeners > 0 {
+			earned[total.UserId] = append(earned[total.UserId], achievementFirstSong)
+		}
+
+		if total.Songs >= centurySongs {
+			earned[total.UserId] = append(earned[total.UserId], achievementCentury)
+		}
+
+		if mostSkips > 0 && total.Skips == mostSkips {
+			earned[total.UserId] = append(earned[total.UserId], achievementMostSkipped)
+		}
+
+		if total.BestNight >= marathonSongs {
+			earned[total.UserId] = append(earned[total.UserId], achievementMarathon)
+		}
+	}
+
+	return earned
+}
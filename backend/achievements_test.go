@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"testing"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+)
+
+/*
+ * Returns true if the list contains the achievement
+ */
+func hasAchievement(earned []string, achievementId string) bool {
+	for _, id := range earned {
+		if id == achievementId {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEvaluateAchievements_when_success(t *testing.T) {
+	totals := []*db.HistoryTotalsData{
+		{UserId: 1, Songs: 120, Skips: 3, NightOpeners: 2, BestNight: 25},
+		{UserId: 2, Songs: 10, Skips: 5, NightOpeners: 0, BestNight: 10},
+		{UserId: 3, Songs: 1, Skips: 0, NightOpeners: 0, BestNight: 1},
+	}
+
+	earned := evaluateAchievements(totals)
+
+	for _, id := range []string{achievementFirstSong, achievementCentury, achievementMarathon} {
+		if !hasAchievement(earned[1], id) {
+			t.Errorf("User 1 should have earned %s, but earned %v", id, earned[1])
+		}
+	}
+
+	if hasAchievement(earned[1], achievementMostSkipped) {
+		t.Errorf("User 1 should not have the most skipped songs")
+	}
+
+	if len(earned[2]) != 1 || !hasAchievement(earned[2], achievementMostSkipped) {
+		t.Errorf("User 2 should have only earned %s, but earned %v", achievementMostSkipped, earned[2])
+	}
+
+	if len(earned[3]) != 0 {
+		t.Errorf("User 3 should not have earned anything, but earned %v", earned[3])
+	}
+}
+
+func TestEvaluateAchievements_whenNoSkips_noMostSkipped(t *testing.T) {
+	totals := []*db.HistoryTotalsData{
+		{UserId: 1, Songs: 2},
+		{UserId: 2, Songs: 3},
+	}
+
+	for userId, earned := range evaluateAchievements(totals) {
+		if hasAchievement(earned, achievementMostSkipped) {
+			t.Errorf("User %d should not be most skipped when nothing was skipped", userId)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Keeps track of which users are still around. Users count as active for a
+ * while after they submit a song, vote or send a heartbeat from a client. The
+ * active users decide how many votes it takes to skip a song, and users who
+ * went inactive have their turns in the round robin queue forgotten.
+ */
+
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultInactiveAfter = 15 * time.Minute // how long users stay active without doing anything
+
+/*
+ * Tracks when each user was last seen
+ */
+type activityTracker struct {
+	timeout  time.Duration        // users not seen within this long are inactive
+	lastSeen map[uint32]time.Time // user id -> when the user last did something
+	lock     sync.Mutex           // lock on the last seen times
+}
+
+/*
+ * A user who was seen recently
+ */
+type activeUser struct {
+	userId   uint32    // id of the user
+	lastSeen time.Time // when the user last did something
+}
+
+/*
+ * Initialize the tracker. Users are inactive after the timeout, which falls
+ * back to a default if it isn't positive.
+ */
+func (t *activityTracker) init(timeout time.Duration) {
+	t.timeout = timeout
+	if t.timeout <= 0 {
+		t.timeout = defaultInactiveAfter
+	}
+
+	t.lastSeen = make(map[uint32]time.Time)
+}
+
+/*
+ * Record that a user did something. Returns the ids of the users who went
+ * inactive since the last time a user was recorded.
+ */
+func (t *activityTracker) touch(userId uint32, now time.Time) []uint32 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var expired []uint32
+	for id, seen := range t.lastSeen {
+		if id != userId && now.Sub(seen) >= t.timeout {
+			expired = append(expired, id)
+			delete(t.lastSeen, id)
+		}
+	}
+
+	t.lastSeen[userId] = now
+	return expired
+}
+
+/*
+ * Returns the users seen within the timeout, most recently seen first
+ */
+func (t *activityTracker) active(now time.Time) []activeUser {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	users := make([]activeUser, 0, len(t.lastSeen))
+	for id, seen := range t.lastSeen {
+		if now.Sub(seen) < t.timeout {
+			users = append(users, activeUser{userId: id, lastSeen: seen})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].lastSeen.Equal(users[j].lastSeen) {
+			return users[i].userId < users[j].userId
+		}
+		return users[i].lastSeen.After(users[j].lastSeen)
+	})
+
+	return users
+}
+
+/*
+ * Returns the number of users seen within the timeout
+ */
+func (t *activityTracker) count(now time.Time) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	count := 0
+	for _, seen := range t.lastSeen {
+		if now.Sub(seen) < t.timeout {
+			count++
+		}
+	}
+
+	return count
+}
+
+/*
+ * Record that a user did something and forget the queue turns of the users
+ * who went inactive
+ */
+func (s *BackendServer) touchUser(userId uint32) {
+	for _, left := range s.activity.touch(userId, time.Now()) {
+		s.zones.forgetUser(left)
+	}
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityTracker_whenTimedOut_expiresUsers(t *testing.T) {
+	start := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	tracker := new(activityTracker)
+	tracker.init(10 * time.Minute)
+
+	tracker.touch(1, start)
+	tracker.touch(2, start.Add(5*time.Minute))
+
+	if count := tracker.count(start.Add(9 * time.Minute)); count != 2 {
+		t.Errorf("Expected 2 active users, got %d", count)
+	}
+
+	expired := tracker.touch(3, start.Add(12*time.Minute))
+	if len(expired) != 1 || expired[0] != 1 {
+		t.Fatalf("Expected user 1 to expire, got %v", expired)
+	}
+
+	active := tracker.active(start.Add(12 * time.Minute))
+	if len(active) != 2 || active[0].userId != 3 || active[1].userId != 2 {
+		t.Errorf("Expected users 3 and 2 to be active, got %v", active)
+	}
+}
+
+func TestActivityTracker_whenTouchedAgain_staysActive(t *testing.T) {
+	start := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	tracker := new(activityTracker)
+	tracker.init(0)
+
+	tracker.touch(1, start)
+	if expired := tracker.touch(1, start.Add(defaultInactiveAfter)); len(expired) != 0 {
+		t.Errorf("User touching again shouldn't expire, got %v", expired)
+	}
+
+	if count := tracker.count(start.Add(defaultInactiveAfter + time.Minute)); count != 1 {
+		t.Errorf("Expected 1 active user, got %d", count)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Anonymous submissions are shown as submitted by "Anonymous" in the queue,
+ * in events and in the recaps and highlights built from the history. Every
+ * copy of the song sent to clients also loses its submitter's and recipient's
+ * user ids, which would otherwise give the submitter away when matched with
+ * their other songs. The queued song keeps its ids, and the database records
+ * who submitted it, so the submitter can still remove it, the queue still
+ * takes turns by it and the host can still moderate it.
+ */
+
+package backend
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const anonymousName = "Anonymous" // name shown in place of an anonymous song's submitter
+
+/*
+ * Replace the submitter's name with "Anonymous" if the song was submitted
+ * anonymously. The ids are left alone, so this is for the song in the queue.
+ */
+func hideSubmitterName(song *cmpb.Song) {
+	if song != nil && song.Anonymous {
+		song.Username = anonymousName
+	}
+}
+
+/*
+ * Replace the submitter's name with "Anonymous" and clear the submitter's
+ * and recipient's ids if the song was submitted anonymously. Only for copies
+ * of the song sent to clients.
+ */
+func hideSubmitter(song *cmpb.Song) {
+	if song != nil && song.Anonymous {
+		song.Username = anonymousName
+		song.UserId = 0
+		song.ForUserId = 0
+	}
+}
+
+/*
+ * Returns the song as clients may see it: a copy with its submitter hidden if
+ * it was submitted anonymously, or the song itself otherwise
+ */
+func publicSong(song *cmpb.Song) *cmpb.Song {
+	if song == nil || !song.Anonymous {
+		return song
+	}
+
+	public := proto.Clone(song).(*cmpb.Song)
+	hideSubmitter(public)
+	return public
+}
+
+/*
+ * Returns the playlist as clients may see it. Playlists are cached by the
+ * queue, so a copy is made rather than hiding the submitters in place.
+ */
+func publicPlaylist(playlist *bepb.Playlist) *bepb.Playlist {
+	for _, song := range playlist.GetSongs() {
+		if song.Anonymous {
+			public := proto.Clone(playlist).(*bepb.Playlist)
+			for _, song := range public.Songs {
+				hideSubmitter(song)
+			}
+			return public
+		}
+	}
+	return playlist
+}
+
+/*
+ * Returns the event as clients may see it: a copy with its song's submitter
+ * hidden if the song was submitted anonymously, or the event itself otherwise
+ */
+func publicEvent(event *bepb.Event) *bepb.Event {
+	if event.Song == nil || !event.Song.Anonymous {
+		return event
+	}
+
+	public := proto.Clone(event).(*bepb.Event)
+	hideSubmitter(public.Song)
+	return public
+}
+
+/*
+ * Returns a copy of the song with its submitter's name filled back in if it
+ * was submitted anonymously, such as for saving it in a snapshot that's
+ * restored into another database
+ */
+func (s *BackendServer) revealSubmitter(song *cmpb.Song) *cmpb.Song {
+	if song == nil || !song.Anonymous {
+		return song
+	}
+
+	revealed := proto.Clone(song).(*cmpb.Song)
+	revealed.Username, _ = s.getUserFromId(song.UserId)
+	return revealed
+}
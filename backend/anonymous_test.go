@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestSendSong_whenAnonymousNotAllowed_rejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId,
+		Link: "https://www.youtube.com/watch?v=SilKjJ0S904", Anonymous: true})
+	if response.Success || response.Message != ErrAnonymousDisabled.Error() {
+		t.Errorf("Expected the anonymous submission to be turned away, got %v", response)
+	}
+}
+
+func TestEnqueueSong_whenAnonymous_hidesSubmitter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	song := queuedSong(0, bob.User.UserId, "PT3M")
+	song.Username = "Bob"
+	song.RoomId = room.Room.Id
+	song.ServiceId = "SilKjJ0S904"
+	song.ForUserId = bob.User.UserId
+	song.Anonymous = true
+	server.queueSong(server.zones.defaultZone, song)
+
+	playlist, _ := server.GetPlaylist(context.Background(), &cmpb.Empty{})
+	shown := playlist.Songs[0]
+	if shown.Username != anonymousName || shown.UserId != 0 || shown.ForUserId != 0 {
+		t.Errorf("Expected the song shown as Anonymous without any ids, got %v", shown)
+	}
+
+	// the queue keeps the ids to itself, so it still takes turns by them
+	queued := server.queueMgr.GetPlaylist().Songs[0]
+	if queued.Username != anonymousName || queued.UserId != bob.User.UserId {
+		t.Errorf("Expected the song queued as Anonymous under Bob's id, got %v", queued)
+	}
+
+	// snapshots keep the submitter, so the song goes back to Bob when restored
+	snapshot, err := server.takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved := snapshot.Songs[0]; saved.Username != "Bob" || !saved.Anonymous {
+		t.Errorf("Expected the snapshot to name Bob, got %v", saved)
+	}
+
+	if queued.Username != anonymousName {
+		t.Errorf("Expected the queued song to stay anonymous, got %v", queued)
+	}
+
+	response, _ := server.RemoveSong(context.Background(), &bepb.Eviction{SongId: queued.SongId,
+		UserId: bob.User.UserId})
+	if !response.Success {
+		t.Errorf("Expected Bob to still be able to remove his song, got %v", response)
+	}
+}
+
+func TestAnonymousSongs_keptOutOfQueuesAndReportsByUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.policy, _ = newAccessPolicy(map[string]string{"user": "fe"}, nil)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	events := server.events.subscribe()
+	defer server.events.unsubscribe(events)
+
+	song := queuedSong(0, bob.User.UserId, "PT3M")
+	song.Username = "Bob"
+	song.RoomId = room.Room.Id
+	song.ServiceId = "SilKjJ0S904"
+	song.Anonymous = true
+	server.queueSong(server.zones.defaultZone, song)
+
+	select {
+	case event := <-events:
+		if event.Song.UserId != 0 || event.Song.Username != anonymousName {
+			t.Errorf("Expected the event to hide the submitter, got %v", event.Song)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an event for the queued song")
+	}
+
+	request := &bepb.UserQueueRequest{UserId: bob.User.UserId}
+	if queue, _ := server.ListQueuedByUser(context.Background(), request); len(queue.Songs) != 0 {
+		t.Errorf("Expected Bob's anonymous song hidden from strangers, got %v", queue.Songs)
+	}
+
+	queue, _ := server.ListQueuedByUser(tokenContext("fe"), request)
+	if len(queue.Songs) != 1 || queue.Songs[0].Song.UserId != 0 {
+		t.Errorf("Expected the frontend to see Bob's song without his id, got %v", queue.Songs)
+	}
+
+	report := server.fairnessReport(server.zones.defaultZone, time.Now())
+	for _, user := range report.Users {
+		if user.UserId == bob.User.UserId && user.Pending != 0 {
+			t.Errorf("Expected Bob's anonymous song not to be counted, got %v", user)
+		}
+	}
+}
+
+func TestGetSongDetails_whenAnonymous_hidesSubmitter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.policy, _ = newAccessPolicy(map[string]string{"admin": "host"}, nil)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	song := queuedSong(0, bob.User.UserId, "PT3M")
+	song.Username = "Bob"
+	song.RoomId = room.Room.Id
+	song.ServiceId = "SilKjJ0S904"
+	song.Anonymous = true
+	server.queueSong(server.zones.defaultZone, song)
+	server.dbManager.AddSongDetails(song.Service, song.ServiceId, &bepb.SongDetails{Channel: "Tears for Fears"})
+
+	request := &bepb.SongDetailsRequest{SongId: song.SongId}
+	details, _ := server.GetSongDetails(context.Background(), request)
+	if !details.Err.Success {
+		t.Fatalf("Expected the song's details, got %v", details.Err)
+	}
+
+	if shown := details.Song; shown.UserId != 0 || shown.Username != anonymousName {
+		t.Errorf("Expected the details to hide the submitter, got %v", shown)
+	}
+
+	if queued := server.queueMgr.FindSong(song.SongId); queued.UserId != bob.User.UserId {
+		t.Errorf("Expected the queued song to keep Bob's id, got %v", queued)
+	}
+
+	details, _ = server.GetSongDetails(tokenContext("host"), request)
+	if details.Song.UserId != bob.User.UserId {
+		t.Errorf("Expected the host to see who submitted the song, got %v", details.Song)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Api keys let clients that aren't people, like a Discord bot, a REST gateway
+ * or a kiosk, call the backend without being handed the token of a whole
+ * role. Every key has a scope limiting what it can call: read-only keys get
+ * the rpcs open to anyone, submit-only keys can also log users in and submit
+ * songs for them, kiosk keys can also submit songs for guests and player keys
+ * can do what players do. Only a hash of each key is saved, so a copy of the
+ * database doesn't give the keys away.
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const apiKeyBytes = 32 // random bytes in an api key
+
+var (
+	ErrApiKeyAbsent  = errors.New("No api key has that name.")
+	ErrApiKeyTaken   = errors.New("An api key already has that name.")
+	ErrInvalidApiKey = errors.New("The api key isn't valid.")
+)
+
+/*
+ * Rpcs submit-only keys can call on top of the ones open to anyone
+ */
+var submitScopeMethods = map[string]bool{
+	"LoginUser":        true,
+	"SendSong":         true,
+	"SearchCandidates": true,
+	"QueueTagged":      true,
+	"LinkIdentity":     true,
+	"UnlinkIdentity":   true,
+	"ResolveIdentity":  true,
+}
+
+/*
+ * Rpcs kiosk keys can call on top of the ones open to anyone
+ */
+var kioskScopeMethods = map[string]bool{
+	"SendSong": true,
+}
+
+/*
+ * Creates api keys and checks the ones clients send
+ */
+type apiKeyring struct {
+	dbManager db.DbManager // database the key hashes are saved in
+}
+
+/*
+ * Initialize the keyring with the database the keys are saved in
+ */
+func (k *apiKeyring) init(dbManager db.DbManager) {
+	k.dbManager = dbManager
+}
+
+/*
+ * Create a new key with a name and scope. The returned key is the only copy
+ * of it.
+ */
+func (k *apiKeyring) create(name string, scope bepb.ApiKeyScope) (*bepb.ApiKey, error) {
+	secret := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	key := &bepb.ApiKey{Name: name, Scope: scope, Key: hex.EncodeToString(secret)}
+	saved, err := k.dbManager.SaveApiKey(key, hashApiKey(key.Key))
+	if err != nil {
+		return nil, err
+	} else if !saved {
+		return nil, ErrApiKeyTaken
+	}
+
+	return key, nil
+}
+
+/*
+ * Revoke the key with the name
+ */
+func (k *apiKeyring) revoke(name string) error {
+	removed, err := k.dbManager.RemoveApiKey(name)
+	if err != nil {
+		return err
+	} else if !removed {
+		return ErrApiKeyAbsent
+	}
+
+	return nil
+}
+
+/*
+ * Returns the keys ordered by name
+ */
+func (k *apiKeyring) list() ([]*bepb.ApiKey, error) {
+	return k.dbManager.GetApiKeys()
+}
+
+/*
+ * Returns the scope of a key a client sent, or ErrInvalidApiKey if it isn't
+ * one of the keys
+ */
+func (k *apiKeyring) scopeOf(key string) (bepb.ApiKeyScope, error) {
+	saved, err := k.dbManager.GetApiKeyByHash(hashApiKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return bepb.ApiKeyScope_ReadOnlyKey, ErrInvalidApiKey
+	} else if err != nil {
+		return bepb.ApiKeyScope_ReadOnlyKey, err
+	}
+
+	return saved.Scope, nil
+}
+
+/*
+ * Check that the api key in the request metadata lets the client call the
+ * rpc, which requires the role. Returns false if the request has no key.
+ */
+func (k *apiKeyring) authorize(ctx context.Context, method string, required role) (bool, error) {
+	key := sentApiKey(ctx)
+	if key == "" {
+		return false, nil
+	}
+
+	scope, err := k.scopeOf(key)
+	if errors.Is(err, ErrInvalidApiKey) {
+		return true, status.Error(codes.Unauthenticated, err.Error())
+	} else if err != nil {
+		return true, status.Error(codes.Internal, "Failed to check the api key.")
+	}
+
+	if !scopeAllows(scope, method, required) {
+		return true, status.Errorf(codes.PermissionDenied, "%s can't be called with a %v api key.", method, scope)
+	}
+
+	return true, nil
+}
+
+/*
+ * Returns the name of the kiosk whose key the request was sent with, or false
+ * if it wasn't sent with a kiosk key
+ */
+func (k *apiKeyring) kioskOf(ctx context.Context) (string, bool) {
+	key := sentApiKey(ctx)
+	if key == "" {
+		return "", false
+	}
+
+	saved, err := k.dbManager.GetApiKeyByHash(hashApiKey(key))
+	if err != nil || saved.Scope != bepb.ApiKeyScope_KioskKey {
+		return "", false
+	}
+
+	return saved.Name, true
+}
+
+/*
+ * Returns the api key in the request metadata, or an empty string if there's
+ * none
+ */
+func sentApiKey(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(common.ApiKeyMetadataKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+/*
+ * Returns true if a key with the scope may call the rpc, which requires the
+ * role
+ */
+func scopeAllows(scope bepb.ApiKeyScope, method string, required role) bool {
+	switch scope {
+	case bepb.ApiKeyScope_PlayerKey:
+		return required <= rolePlayer
+	case bepb.ApiKeyScope_SubmitOnlyKey:
+		return required == roleAnonymous || (submitScopeMethods[method] && required <= roleUser)
+	case bepb.ApiKeyScope_KioskKey:
+		return required == roleAnonymous || (kioskScopeMethods[method] && required <= roleUser)
+	}
+
+	return required == roleAnonymous
+}
+
+/*
+ * Returns the hash a key is saved under. Keys are long and random, so a plain
+ * hash is enough to keep them from being read back.
+ */
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
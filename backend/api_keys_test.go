@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func apiKeyContext(key string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(common.ApiKeyMetadataKey, key))
+}
+
+func TestApiKeyring_authorize_byScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_api_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	policy, err := newAccessPolicy(map[string]string{"admin": "s3cret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.keys = new(apiKeyring)
+	policy.keys.init(server.dbManager)
+
+	bot, err := policy.keys.create("discord bot", bepb.ApiKeyScope_SubmitOnlyKey)
+	if err != nil || bot.Key == "" {
+		t.Fatalf("Expected a key, got %v and %v", bot, err)
+	}
+
+	if _, err = policy.keys.create("discord bot", bepb.ApiKeyScope_ReadOnlyKey); err != ErrApiKeyTaken {
+		t.Errorf("Expected the name to be taken, got %v", err)
+	}
+
+	ctx := apiKeyContext(bot.Key)
+	for _, method := range []string{"GetPlaylist", "SendSong", "LoginUser"} {
+		if err := policy.authorize(ctx, "/backend_pb.YtbBackend/"+method); err != nil {
+			t.Errorf("Expected a submit-only key to call %s, got %v", method, err)
+		}
+	}
+
+	for _, method := range []string{"NextSong", "PopQueue", "CreateApiKey"} {
+		if err := policy.authorize(ctx, "/backend_pb.YtbBackend/"+method); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected a submit-only key to be turned away from %s, got %v", method, err)
+		}
+	}
+
+	err = policy.authorize(apiKeyContext("guessed"), "/backend_pb.YtbBackend/GetPlaylist")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected an unknown key to be turned away, got %v", err)
+	}
+
+	keys, err := policy.keys.list()
+	if err != nil || len(keys) != 1 || keys[0].Key != "" || keys[0].Created == 0 {
+		t.Errorf("Expected the key to be listed without its secret, got %v and %v", keys, err)
+	}
+
+	if err = policy.keys.revoke("discord bot"); err != nil {
+		t.Fatalf("Expected the key to be revoked, got %v", err)
+	}
+
+	if err = policy.authorize(ctx, "/backend_pb.YtbBackend/GetPlaylist"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a revoked key to be turned away, got %v", err)
+	}
+
+	if err = policy.keys.revoke("discord bot"); err != ErrApiKeyAbsent {
+		t.Errorf("Expected the key to be gone, got %v", err)
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	if !scopeAllows(bepb.ApiKeyScope_PlayerKey, "PopQueue", rolePlayer) {
+		t.Errorf("Expected a player key to pop songs")
+	}
+
+	if scopeAllows(bepb.ApiKeyScope_PlayerKey, "SavePlaylist", roleAdmin) {
+		t.Errorf("Expected a player key to be turned away from admin rpcs")
+	}
+
+	if scopeAllows(bepb.ApiKeyScope_ReadOnlyKey, "SendSong", roleUser) {
+		t.Errorf("Expected a read-only key to be turned away from submitting")
+	}
+
+	if scopeAllows(bepb.ApiKeyScope_SubmitOnlyKey, "SendSong", roleAdmin) {
+		t.Errorf("Expected a submit-only key to follow a policy making submitting admin only")
+	}
+}
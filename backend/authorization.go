@@ -0,0 +1,308 @@
+/*
+ * Decides which callers may call each rpc. Clients send an access token in
+ * the request metadata, and the token grants them a role. Each rpc requires a
+ * role, and callers may call the rpcs requiring their role or any role below
+ * it. The default policy can be overridden for each rpc, such as to let
+ * anyone skip songs. Without any tokens configured, every caller is an admin,
+ * as before the policy existed.
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+)
+
+/*
+ * Roles of callers, from least to most trusted
+ */
+type role int
+
+const (
+	roleAnonymous role = iota // anyone who can reach the backend
+	roleUser                  // clients acting for users, like the frontend, bots and other backends
+	rolePlayer                // players, who can also do what users do
+	roleAdmin                 // the host, who can call every rpc
+)
+
+var roleNames = []string{"anonymous", "user", "player", "admin"}
+
+var (
+	ErrUnknownRole   = errors.New("Roles must be anonymous, user, player or admin.")
+	ErrUnknownMethod = errors.New("The policy names an rpc that doesn't exist.")
+	ErrTokenlessRole = errors.New("Tokens can't be given to the anonymous role.")
+)
+
+/*
+ * Role required by each rpc unless the config overrides it. Rpcs left out
+ * require an admin.
+ */
+var defaultPolicy = map[string]role{
+	"GetNowPlaying":         roleAnonymous,
+	"GetPlaylist":           roleAnonymous,
+	"ListQueuedByUser":      roleAnonymous,
+	"GetRoom":               roleAnonymous,
+	"GetSongDetails":        roleAnonymous,
+	"GetSongs":              roleAnonymous,
+	"ListZones":             roleAnonymous,
+	"GetZonePlaylist":       roleAnonymous,
+	"GetStats":              roleAnonymous,
+	"GetAchievements":       roleAnonymous,
+	"GetHistory":            roleAnonymous,
+	"GetSkipReport":         roleAnonymous,
+	"Leaderboard":           roleAnonymous,
+	"Events":                roleAnonymous,
+	"GetPlaybackPosition":   roleAnonymous,
+	"GetLyrics":             roleAnonymous,
+	"ListPresets":           roleAnonymous,
+	"ActiveUsers":           roleAnonymous,
+	"GetPartyRecap":         roleAnonymous,
+	"GetPlaylistIfChanged":  roleAnonymous,
+	"GetFairnessReport":     roleAnonymous,
+	"SendSong":              roleUser,
+	"SearchCandidates":      roleUser,
+	"RemoveSong":            roleUser,
+	"LoginUser":             roleUser,
+	"CreateLoginCode":       roleUser,
+	"ConfirmLoginCode":      roleUser,
+	"RedeemLoginCode":       roleUser,
+	"NextSong":              roleUser,
+	"PreviousSong":          roleUser,
+	"PauseSong":             roleUser,
+	"CreateRoom":            roleUser,
+	"SharePlaylist":         roleUser,
+	"ImportShared":          roleUser,
+	"Federate":              roleUser,
+	"ForwardSong":           roleUser,
+	"React":                 roleUser,
+	"Heartbeat":             roleUser,
+	"VoteSkip":              roleUser,
+	"RequestPlayNext":       roleUser,
+	"ApprovePlayNext":       roleUser,
+	"WhoAmI":                roleUser,
+	"SetPreferences":        roleUser,
+	"Duck":                  roleUser,
+	"Unduck":                roleUser,
+	"PopQueue":              rolePlayer,
+	"ConfirmPlayback":       rolePlayer,
+	"SongPlayer":            rolePlayer,
+	"SavePlaylist":          roleAdmin,
+	"RestorePlaylist":       roleAdmin,
+	"MigrateQueue":          roleAdmin,
+	"CreateZone":            roleAdmin,
+	"RemoveZone":            roleAdmin,
+	"RunMaintenance":        roleAdmin,
+	"ListOutputDevices":     roleAdmin,
+	"SetOutputDevice":       roleAdmin,
+	"ListBluetoothDevices":  roleAdmin,
+	"ScanBluetooth":         roleAdmin,
+	"PairBluetooth":         roleAdmin,
+	"ConnectBluetooth":      roleAdmin,
+	"SetExemption":          roleAdmin,
+	"ListExemptions":        roleAdmin,
+	"SavePreset":            roleAdmin,
+	"ApplyPreset":           roleAdmin,
+	"EndParty":              roleAdmin,
+	"ListPendingSongs":      roleAdmin,
+	"ReviewSong":            roleAdmin,
+	"TakeDecks":             roleAdmin,
+	"HandBackDecks":         roleAdmin,
+	"ListSuggestions":       roleAdmin,
+	"ReviewSuggestion":      roleAdmin,
+	"AddJingle":             roleAdmin,
+	"RemoveJingle":          roleAdmin,
+	"ListJingles":           roleAdmin,
+	"RegisterPlayer":        roleAdmin,
+	"UnregisterPlayer":      roleAdmin,
+	"ListRegisteredPlayers": roleAdmin,
+	"TimeOutUser":           roleAdmin,
+	"EndTimeOut":            roleAdmin,
+	"ListTimeOuts":          roleAdmin,
+	"RemoveByServiceId":     roleAdmin,
+	"UnblockSong":           roleAdmin,
+	"ComparePlaylists":      roleAdmin,
+	"GetServerInfo":         roleAnonymous,
+	"GetJoinCode":           roleAnonymous,
+	"SetDisplaySettings":    roleAdmin,
+	"CreateApiKey":          roleAdmin,
+	"RevokeApiKey":          roleAdmin,
+	"ListApiKeys":           roleAdmin,
+	"SetPlaybackQuality":    roleAdmin,
+	"TagSong":               roleUser,
+	"UntagSong":             roleUser,
+	"FindTagged":            roleAnonymous,
+	"QueueTagged":           roleUser,
+	"SetAutoDjTag":          roleAdmin,
+	"SetLogLevel":           roleAdmin,
+	"DumpState":             roleAdmin,
+	"ListClients":           roleAdmin,
+	"ScheduleSong":          roleAdmin,
+	"CancelScheduled":       roleAdmin,
+	"ListScheduled":         roleAnonymous,
+	"ListChapters":          roleUser,
+	"LinkIdentity":          roleUser,
+	"UnlinkIdentity":        roleUser,
+	"ResolveIdentity":       roleUser,
+}
+
+/*
+ * Roles required by the rpcs and the tokens granting them
+ */
+type accessPolicy struct {
+	required map[string]role // rpc name -> role required to call it
+	tokens   map[string]role // token -> role it grants
+	keys     *apiKeyring     // checks the api keys clients send. Nil ignores them
+	password string          // party password clients acting for users must send. Empty if there's none
+}
+
+/*
+ * Create the access policy from the tokens of each role and the roles the
+ * config requires for some rpcs in place of the defaults
+ */
+func newAccessPolicy(tokens map[string]string, overrides map[string]string) (*accessPolicy, error) {
+	policy := &accessPolicy{
+		required: make(map[string]role, len(defaultPolicy)),
+		tokens:   make(map[string]role, len(tokens)),
+	}
+
+	for method, required := range defaultPolicy {
+		policy.required[method] = required
+	}
+
+	for method, name := range overrides {
+		if _, exists := defaultPolicy[method]; !exists {
+			return nil, fmt.Errorf("%w Got %q", ErrUnknownMethod, method)
+		}
+
+		required, err := parseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		policy.required[method] = required
+	}
+
+	for name, token := range tokens {
+		granted, err := parseRole(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if granted == roleAnonymous {
+			return nil, ErrTokenlessRole
+		}
+		policy.tokens[token] = granted
+	}
+
+	return policy, nil
+}
+
+/*
+ * Returns the role with the name, ignoring case
+ */
+func parseRole(name string) (role, error) {
+	for value, roleName := range roleNames {
+		if strings.EqualFold(roleName, strings.TrimSpace(name)) {
+			return role(value), nil
+		}
+	}
+
+	return roleAnonymous, fmt.Errorf("%w Got %q", ErrUnknownRole, name)
+}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+/*
+ * Returns the role granted by the token in the request metadata. Callers
+ * without a valid token are anonymous.
+ */
+func (p *accessPolicy) callerRole(ctx context.Context) role {
+	if len(p.tokens) == 0 {
+		return roleAdmin
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return roleAnonymous
+	}
+
+	values := md.Get(common.TokenMetadataKey)
+	if len(values) == 0 {
+		return roleAnonymous
+	}
+
+	// compare every token in constant time so the tokens can't be guessed
+	// from how long the check takes
+	granted := roleAnonymous
+	for token, tokenRole := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(values[0])) == 1 {
+			granted = tokenRole
+		}
+	}
+
+	return granted
+}
+
+/*
+ * Check that the caller may call the rpc. Returns a PermissionDenied status
+ * error if they may not. Callers sending an api key are held to its scope
+ * instead of the role of any token they send.
+ */
+func (p *accessPolicy) authorize(ctx context.Context, fullMethod string) error {
+	method := path.Base(fullMethod)
+	required, exists := p.required[method]
+	if !exists {
+		required = roleAdmin
+	}
+
+	if p.keys != nil {
+		if sent, err := p.keys.authorize(ctx, method, required); sent {
+			return err
+		}
+	}
+
+	if p.callerRole(ctx) < required {
+		return status.Errorf(codes.PermissionDenied, "%s requires the %v role.", method, required)
+	}
+
+	return p.checkPartyPassword(ctx, method, required)
+}
+
+/*
+ * Turn away unary rpcs the caller may not call
+ */
+func (p *accessPolicy) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := p.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+/*
+ * Turn away streams the caller may not open
+ */
+func (p *accessPolicy) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	if err := p.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+)
+
+func tokenContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(common.TokenMetadataKey, token))
+}
+
+func TestAccessPolicy_rolesFromTokens(t *testing.T) {
+	policy, err := newAccessPolicy(map[string]string{"user": "fe", "player": "tv", "admin": "host"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []struct {
+		ctx     context.Context
+		method  string
+		allowed bool
+	}{
+		{context.Background(), "/backend.YtbBackend/GetPlaylist", true},
+		{context.Background(), "/backend.YtbBackend/SendSong", false},
+		{tokenContext("guess"), "/backend.YtbBackend/SendSong", false},
+		{tokenContext("fe"), "/backend.YtbBackend/SendSong", true},
+		{tokenContext("fe"), "/backend.YtbBePlayer/SongPlayer", false},
+		{tokenContext("tv"), "/backend.YtbBePlayer/SongPlayer", true},
+		{tokenContext("tv"), "/backend.YtbBackend/ApplyPreset", false},
+		{tokenContext("host"), "/backend.YtbBackend/ApplyPreset", true},
+		{tokenContext("host"), "/backend.YtbBackend/NotAnRpc", true},
+		{tokenContext("tv"), "/backend.YtbBackend/NotAnRpc", false},
+	}
+
+	for _, call := range calls {
+		err := policy.authorize(call.ctx, call.method)
+		if call.allowed && err != nil {
+			t.Errorf("Expected %s to be allowed, but got %v", call.method, err)
+		} else if !call.allowed && status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected %s to be denied, but got %v", call.method, err)
+		}
+	}
+}
+
+func TestAccessPolicy_withoutTokens_allowsEverything(t *testing.T) {
+	policy, err := newAccessPolicy(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policy.authorize(context.Background(), "/backend.YtbBackend/RunMaintenance"); err != nil {
+		t.Errorf("Expected every rpc to be allowed without tokens, but got %v", err)
+	}
+}
+
+func TestAccessPolicy_overrides(t *testing.T) {
+	policy, err := newAccessPolicy(map[string]string{"admin": "host"},
+		map[string]string{"NextSong": "Anonymous", "GetPlaylist": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policy.authorize(context.Background(), "/backend.YtbBackend/NextSong"); err != nil {
+		t.Errorf("Expected anyone to be able to skip, but got %v", err)
+	}
+
+	if err := policy.authorize(context.Background(), "/backend.YtbBackend/GetPlaylist"); err == nil {
+		t.Errorf("Expected the playlist to require an admin")
+	}
+}
+
+func TestNewAccessPolicy_rejectsBadConfig(t *testing.T) {
+	configs := []struct {
+		tokens    map[string]string
+		overrides map[string]string
+		expected  error
+	}{
+		{map[string]string{"dj": "secret"}, nil, ErrUnknownRole},
+		{map[string]string{"anonymous": "secret"}, nil, ErrTokenlessRole},
+		{nil, map[string]string{"NextSong": "everyone"}, ErrUnknownRole},
+		{nil, map[string]string{"ClearQueue": "admin"}, ErrUnknownMethod},
+	}
+
+	for _, config := range configs {
+		if _, err := newAccessPolicy(config.tokens, config.overrides); !errors.Is(err, config.expected) {
+			t.Errorf("Expected %v for %v and %v, but got %v", config.expected, config.tokens, config.overrides, err)
+		}
+	}
+}
+
+func TestDefaultPolicy_coversEveryRpc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	for name, service := range server.beServer.GetServiceInfo() {
+		for _, method := range service.Methods {
+			if _, exists := defaultPolicy[method.Name]; !exists {
+				t.Errorf("%s/%s has no role in the default policy", name, method.Name)
+			}
+		}
+	}
+}
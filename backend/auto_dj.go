@@ -0,0 +1,232 @@
+/*
+ * Keeps the music going when the queue runs dry by drawing songs from the
+ * history. The auto dj skips songs that were played recently and avoids
+ * playing two songs from the same channel back to back.
+ */
+
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	autoDjCandidates   = 20            // songs drawn from the history per pick
+	defaultAutoDjAvoid = 4 * time.Hour // default time before a song can be picked again
+)
+
+/*
+ * Picks songs from the history to play when nobody has queued anything
+ */
+type autoDj struct {
+	enabled          bool            // true if the auto dj should pick songs
+	dbManager        db.DbManager    // database holding the history
+	avoidRecent      time.Duration   // songs played within this long ago aren't picked
+	allowSameChannel bool            // true to allow back to back songs from one channel
+	playlist         string          // share code of a playlist to pick from instead of the history
+	tag              string          // tag the songs picked from the history should have, if any
+	lastServiceId    string          // service id of the last picked song
+	lastChannel      string          // channel of the last picked song
+	held             map[uint32]bool // zones whose decks a dj has, which the auto dj leaves alone
+	lock             sync.Mutex      // only one pick at a time
+}
+
+/*
+ * Initialize the auto dj. A zero avoidRecent falls back to the default.
+ */
+func (dj *autoDj) init(dbManager db.DbManager, enabled bool, avoidRecent time.Duration, allowSameChannel bool) {
+	dj.enabled = enabled
+	dj.dbManager = dbManager
+	dj.avoidRecent = orDefaultDuration(avoidRecent, defaultAutoDjAvoid)
+	dj.allowSameChannel = allowSameChannel
+}
+
+/*
+ * Pick songs from a shared playlist instead of the history, even if the auto
+ * dj is otherwise disabled. An empty share code goes back to the history.
+ */
+func (dj *autoDj) usePlaylist(code string) {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+	dj.playlist = code
+}
+
+/*
+ * Pick songs with a tag from the history while the auto dj is enabled. An
+ * empty tag goes back to the whole history.
+ */
+func (dj *autoDj) useTag(tag string) {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+	dj.tag = tag
+}
+
+/*
+ * Stop picking songs for a zone while a dj has its decks, or start again
+ */
+func (dj *autoDj) hold(zoneId uint32, held bool) {
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+
+	if dj.held == nil {
+		dj.held = make(map[uint32]bool)
+	}
+
+	if held {
+		dj.held[zoneId] = true
+	} else {
+		delete(dj.held, zoneId)
+	}
+}
+
+/*
+ * Returns true if a dj has the zone's decks
+ */
+func (dj *autoDj) holding(zoneId uint32) bool {
+	if dj == nil {
+		return false
+	}
+
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+	return dj.held[zoneId]
+}
+
+/*
+ * Pick a song from the history or fallback playlist to follow the previous
+ * song and record it as an auto dj submission. Returns nil if the auto dj is
+ * disabled or nothing can be played.
+ */
+func (dj *autoDj) pick(previous *cmpb.Song) *cmpb.Song {
+	if dj == nil {
+		return nil
+	}
+
+	dj.lock.Lock()
+	defer dj.lock.Unlock()
+
+	if !dj.enabled && dj.playlist == "" {
+		return nil
+	}
+
+	candidates, err := dj.candidates()
+	if err != nil {
+		log.Printf("Auto dj failed to get candidates: %v", err)
+		return nil
+	}
+
+	choice := chooseFallback(candidates, previous, dj.channelOf(previous), !dj.allowSameChannel)
+	if choice == nil {
+		log.Printf("Auto dj has nothing to play")
+		return nil
+	}
+
+	song := &cmpb.Song{
+		Title:     choice.Song.Title,
+		Service:   choice.Song.Service,
+		ServiceId: choice.Song.ServiceId,
+		UserId:    choice.Song.UserId,
+		Username:  choice.Song.Username,
+		RoomId:    choice.Song.RoomId,
+		Source:    cmpb.SubmissionSource_AutoDj,
+		Metadata:  &cmpb.Metadata{},
+	}
+
+	if song.Service == cmpb.ServiceType_Youtube {
+		song.Metadata.Thumbnail = youtubeThumbnail(song.ServiceId)
+	}
+
+	if err = dj.dbManager.AddSong(song); err != nil {
+		log.Printf("Auto dj failed to record %s: %v", song.ServiceId, err)
+		return nil
+	}
+
+	dj.lastServiceId = song.ServiceId
+	dj.lastChannel = choice.Channel
+	log.Printf("Auto dj picked: {id: %s, channel: %s}", song.ServiceId, choice.Channel)
+	return song
+}
+
+/*
+ * Get songs that weren't played recently from the fallback playlist, or from
+ * the history if there isn't one. A playlist that was all played recently
+ * starts over. Songs with the auto dj's tag are preferred from the history,
+ * falling back to any song once they were all played recently. Assumes the
+ * caller holds the lock.
+ */
+func (dj *autoDj) candidates() ([]*db.FallbackSongData, error) {
+	playedBefore := time.Now().Add(-dj.avoidRecent)
+	if dj.playlist == "" && dj.tag != "" {
+		candidates, err := dj.dbManager.GetTaggedFallbackCandidates(dj.tag, playedBefore, autoDjCandidates)
+		if err != nil || len(candidates) > 0 {
+			return candidates, err
+		}
+		log.Printf("Auto dj has no songs tagged %s left to play", dj.tag)
+	}
+
+	if dj.playlist == "" {
+		return dj.dbManager.GetFallbackCandidates(playedBefore, autoDjCandidates)
+	}
+
+	candidates, err := dj.dbManager.GetPlaylistFallbackCandidates(dj.playlist, playedBefore, autoDjCandidates)
+	if err == nil && len(candidates) == 0 {
+		candidates, err = dj.dbManager.GetPlaylistFallbackCandidates(dj.playlist, time.Now(), autoDjCandidates)
+	}
+
+	return candidates, err
+}
+
+/*
+ * Returns the channel of a song, or an empty string if it isn't known.
+ * Assumes the caller holds the lock.
+ */
+func (dj *autoDj) channelOf(song *cmpb.Song) string {
+	if song == nil {
+		return ""
+	}
+
+	if song.ServiceId == dj.lastServiceId {
+		return dj.lastChannel
+	}
+
+	details, err := dj.dbManager.GetSongDetails(song.Service, song.ServiceId)
+	if err != nil {
+		return ""
+	}
+
+	return details.Details.Channel
+}
+
+/*
+ * Choose the first candidate that isn't the previous song, even as uploaded
+ * to another service, and, if asked to, isn't from the previous song's
+ * channel. Songs with an unknown channel are never treated as a repeat. Falls
+ * back to ignoring the channel rather than playing nothing. Returns nil if
+ * there's nothing to choose.
+ */
+func chooseFallback(candidates []*db.FallbackSongData, previous *cmpb.Song, previousChannel string,
+	avoidSameChannel bool) *db.FallbackSongData {
+
+	var fallback *db.FallbackSongData
+
+	for _, candidate := range candidates {
+		if previous != nil && sameSong(&candidate.Song, previous) {
+			continue
+		}
+
+		if !avoidSameChannel || previousChannel == "" || candidate.Channel != previousChannel {
+			return candidate
+		}
+
+		if fallback == nil {
+			fallback = candidate
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"testing"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func fallbackSong(serviceId string, channel string) *db.FallbackSongData {
+	candidate := &db.FallbackSongData{Channel: channel}
+	candidate.Song.Service = cmpb.ServiceType_Youtube
+	candidate.Song.ServiceId = serviceId
+	return candidate
+}
+
+func TestChooseFallback_whenSameChannel_picksOtherChannel(t *testing.T) {
+	previous := &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "a"}
+	candidates := []*db.FallbackSongData{
+		fallbackSong("a", "daft punk"),
+		fallbackSong("b", "daft punk"),
+		fallbackSong("c", "justice"),
+	}
+
+	choice := chooseFallback(candidates, previous, "daft punk", true)
+	if choice == nil || choice.Song.ServiceId != "c" {
+		t.Errorf("Expected song c from another channel, but got %v", choice)
+	}
+}
+
+func TestChooseFallback_whenOnlySameChannel_fallsBack(t *testing.T) {
+	previous := &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "a"}
+	candidates := []*db.FallbackSongData{
+		fallbackSong("a", "daft punk"),
+		fallbackSong("b", "daft punk"),
+	}
+
+	choice := chooseFallback(candidates, previous, "daft punk", true)
+	if choice == nil || choice.Song.ServiceId != "b" {
+		t.Errorf("Expected to fall back to song b, but got %v", choice)
+	}
+}
+
+func TestChooseFallback_whenSameChannelAllowed_picksFirst(t *testing.T) {
+	candidates := []*db.FallbackSongData{
+		fallbackSong("b", "daft punk"),
+		fallbackSong("c", "justice"),
+	}
+
+	choice := chooseFallback(candidates, nil, "daft punk", false)
+	if choice == nil || choice.Song.ServiceId != "b" {
+		t.Errorf("Expected the first song b, but got %v", choice)
+	}
+}
+
+func TestChooseFallback_whenOnlyPrevious_returnsNil(t *testing.T) {
+	previous := &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "a"}
+	candidates := []*db.FallbackSongData{fallbackSong("a", "")}
+
+	if choice := chooseFallback(candidates, previous, "", true); choice != nil {
+		t.Errorf("Should not replay the previous song, but got %v", choice)
+	}
+}
+
+func TestAutoDjPick_whenDisabled_returnsNil(t *testing.T) {
+	var dj *autoDj
+	if song := dj.pick(nil); song != nil {
+		t.Errorf("Missing auto dj should not pick, but picked %v", song)
+	}
+
+	dj = new(autoDj)
+	dj.init(nil, false, 0, false)
+	if song := dj.pick(nil); song != nil {
+		t.Errorf("Disabled auto dj should not pick, but picked %v", song)
+	}
+}
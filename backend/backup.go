@@ -0,0 +1,396 @@
+/*
+ * Backs up everything the backend needs to pick up where it left off into a
+ * single archive and restores it on another machine, for moving off a Pi
+ * whose SD card is dying. The archive is a gzipped tar holding a manifest, a
+ * consistent copy of the database, the queue snapshot and playlist from the
+ * snapshot store and the config with its secrets left out. Restoring checks
+ * that this build understands the archive, never writes over an existing
+ * database and reports on what was restored.
+ */
+
+package backend
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/database"
+)
+
+const (
+	backupFormat       = 1                            // version of the archive's layout
+	backupManifestName = "manifest.json"              // entry holding the manifest, always first
+	backupDbName       = "ytbox.db"                   // entry holding the copy of the database
+	backupConfigName   = "config.json"                // entry holding the config without secrets
+	backupQueueName    = "queue.snapshot"             // entry holding the queue snapshot
+	backupPlaylistName = "playlist.queue"             // entry holding the playlist loaded on start up
+	restoredConfigName = "ytbox-restored-config.json" // file the config is restored to, next to the database
+)
+
+var (
+	ErrBackupManifest  = errors.New("The archive doesn't start with a backup manifest.")
+	ErrBackupTooNew    = errors.New("The archive was made by a newer version of the backend.")
+	ErrBackupNoDb      = errors.New("The archive has no database.")
+	ErrDatabaseExists  = errors.New("A database already exists where the backup would be restored.")
+	ErrBackupDbMissing = errors.New("There's no database to back up.")
+)
+
+/*
+ * Describes what's in a backup archive
+ */
+type backupManifest struct {
+	Format   int       `json:"format"`             // version of the archive's layout
+	Schema   int       `json:"schema"`             // version of the database schema
+	Created  time.Time `json:"created"`            // when the backup was made
+	Host     string    `json:"host"`               // machine the backup was made on
+	Queue    bool      `json:"queue"`              // whether the queue snapshot is in the archive
+	Playlist string    `json:"playlist,omitempty"` // name the playlist was loaded from. Empty if it isn't in the archive
+}
+
+/*
+ * Back up the database, snapshots and config to an archive at the path. The
+ * database is copied consistently, so the server may keep running.
+ */
+func Backup(config *ServerConfig, archivePath string) error {
+	if _, err := os.Stat(config.DbPath); os.IsNotExist(err) {
+		return ErrBackupDbMissing
+	}
+
+	schema, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	store, err := newSnapshotStore(config)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	manifest := &backupManifest{Format: backupFormat, Schema: schema, Created: time.Now(), Host: host}
+	snapshots := make(map[string][]byte)
+
+	queue, err := store.Load(queuer.QueueSnapshot)
+	if err == nil {
+		manifest.Queue = true
+		snapshots[backupQueueName] = queue
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to load the queue snapshot: %w", err)
+	}
+
+	if config.LoadFile != "" {
+		playlist, err := store.Load(config.LoadFile)
+		if err == nil {
+			manifest.Playlist = config.LoadFile
+			snapshots[backupPlaylistName] = playlist
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to load %s: %w", config.LoadFile, err)
+		}
+	}
+
+	// the database is copied next to the archive, where there's room for it
+	dbCopy := archivePath + ".db"
+	os.Remove(dbCopy)
+	if err = db.CopyDatabase(config.DbPath, dbCopy); err != nil {
+		return fmt.Errorf("failed to copy the database: %w", err)
+	}
+	defer os.Remove(dbCopy)
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zipped := gzip.NewWriter(out)
+	archive := tar.NewWriter(zipped)
+
+	if err = writeBackupJson(archive, backupManifestName, manifest); err != nil {
+		return err
+	}
+
+	if err = writeBackupFile(archive, backupDbName, dbCopy); err != nil {
+		return err
+	}
+
+	for _, name := range []string{backupQueueName, backupPlaylistName} {
+		if data, ok := snapshots[name]; ok {
+			if err = writeBackupEntry(archive, name, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = writeBackupJson(archive, backupConfigName, backupConfig(config)); err != nil {
+		return err
+	}
+
+	if err = archive.Close(); err != nil {
+		return err
+	}
+
+	if err = zipped.Close(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+/*
+ * Restore a backup archive to the database and snapshot store in the config.
+ * The config in the archive is written next to the database for reference.
+ * Returns a report checking what was restored.
+ */
+func Restore(config *ServerConfig, archivePath string) ([]CheckResult, error) {
+	if _, err := os.Stat(config.DbPath); err == nil {
+		return nil, ErrDatabaseExists
+	}
+
+	schema, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newSnapshotStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	zipped, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewReader(zipped)
+
+	manifest, err := readBackupManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Format > backupFormat || manifest.Schema > schema {
+		return nil, fmt.Errorf("%w It has format %d and schema %d, but this backend reads up to format %d and schema %d.",
+			ErrBackupTooNew, manifest.Format, manifest.Schema, backupFormat, schema)
+	}
+
+	// the database is only moved into place once it's all been read
+	restoredDb, err := ioutil.TempFile(filepath.Dir(config.DbPath), ".ytbox-restore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(restoredDb.Name())
+	defer restoredDb.Close()
+
+	hasDb := false
+	entries := make(map[string][]byte)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch header.Name {
+		case backupDbName:
+			if _, err = io.Copy(restoredDb, archive); err != nil {
+				return nil, err
+			}
+			hasDb = true
+		case backupQueueName, backupPlaylistName, backupConfigName:
+			if entries[header.Name], err = ioutil.ReadAll(archive); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !hasDb {
+		return nil, ErrBackupNoDb
+	}
+
+	if err = restoredDb.Close(); err != nil {
+		return nil, err
+	}
+
+	if err = os.Rename(restoredDb.Name(), config.DbPath); err != nil {
+		return nil, err
+	}
+
+	// the playlist is restored to where the new config loads it from
+	playlistPath := config.LoadFile
+	if playlistPath == "" {
+		playlistPath = manifest.Playlist
+	}
+
+	restored := map[string]string{backupQueueName: queuer.QueueSnapshot, backupPlaylistName: playlistPath}
+	for _, name := range []string{backupQueueName, backupPlaylistName} {
+		if data, ok := entries[name]; ok {
+			if err = store.Save(restored[name], data); err != nil {
+				return nil, fmt.Errorf("failed to restore %s: %w", restored[name], err)
+			}
+		}
+	}
+
+	configPath := filepath.Join(filepath.Dir(config.DbPath), restoredConfigName)
+	if data, ok := entries[backupConfigName]; ok {
+		if err = ioutil.WriteFile(configPath, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return restoreReport(config, manifest, schema, store, restored, configPath, entries), nil
+}
+
+/*
+ * Check what was restored from an archive
+ */
+func restoreReport(config *ServerConfig, manifest *backupManifest, schema int, store SnapshotStore,
+	restored map[string]string, configPath string, entries map[string][]byte) []CheckResult {
+
+	var results []CheckResult
+	check := func(name string, run func() (string, error)) {
+		detail, err := run()
+		results = append(results, CheckResult{Name: name, Detail: detail, Err: err})
+	}
+
+	check("archive", func() (string, error) {
+		detail := fmt.Sprintf("made on %s at %s with schema %d", manifest.Host,
+			manifest.Created.Format(time.RFC1123), manifest.Schema)
+		if manifest.Schema < schema {
+			detail += fmt.Sprintf(", which is upgraded to %d on start up", schema)
+		}
+		return detail, nil
+	})
+
+	check("database", func() (string, error) { return config.DbPath, db.CheckDatabase(config.DbPath) })
+
+	check("database rows", func() (string, error) {
+		counts, err := db.CountRows(config.DbPath)
+		if err != nil {
+			return "", err
+		}
+
+		var tables []string
+		for table := range counts {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+
+		for i, table := range tables {
+			tables[i] = fmt.Sprintf("%d %s", counts[table], table)
+		}
+		return strings.Join(tables, ", "), nil
+	})
+
+	if _, ok := entries[backupQueueName]; ok {
+		check("queue snapshot", func() (string, error) { return checkPlaylist(store, restored[backupQueueName]) })
+	}
+
+	if _, ok := entries[backupPlaylistName]; ok {
+		check("playlist", func() (string, error) { return checkPlaylist(store, restored[backupPlaylistName]) })
+	}
+
+	if _, ok := entries[backupConfigName]; ok {
+		check("config", func() (string, error) {
+			return fmt.Sprintf("saved to %s without its secrets", configPath), nil
+		})
+	}
+
+	return results
+}
+
+/*
+ * Returns a copy of the config without the api keys, tokens and credentials,
+ * which shouldn't travel in a backup
+ */
+func backupConfig(config *ServerConfig) *ServerConfig {
+	stripped := *config
+	stripped.YtApiKey = ""
+	stripped.S3AccessKey = ""
+	stripped.S3SecretKey = ""
+	stripped.FederationToken = ""
+	stripped.Tokens = nil
+	return &stripped
+}
+
+/*
+ * Read the manifest, which must be the first entry of the archive
+ */
+func readBackupManifest(archive *tar.Reader) (*backupManifest, error) {
+	header, err := archive.Next()
+	if err != nil || header.Name != backupManifestName {
+		return nil, ErrBackupManifest
+	}
+
+	manifest := new(backupManifest)
+	if err = json.NewDecoder(archive).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("%w %v", ErrBackupManifest, err)
+	}
+
+	return manifest, nil
+}
+
+/*
+ * Write a value to the archive as json
+ */
+func writeBackupJson(archive *tar.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeBackupEntry(archive, name, data)
+}
+
+/*
+ * Write the contents of a file to the archive
+ */
+func writeBackupFile(archive *tar.Writer, name string, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}
+	if err = archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, in)
+	return err
+}
+
+/*
+ * Write data to the archive
+ */
+func writeBackupEntry(archive *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := archive.Write(data)
+	return err
+}
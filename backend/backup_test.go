@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestRestore_onAnotherMachine_keepsDatabaseAndPlaylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbManager := new(db.SqliteManager)
+	if err = dbManager.Init(filepath.Join(dir, "old.db")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dbManager.AddRoom("Basement"); err != nil {
+		t.Fatal(err)
+	}
+	dbManager.Close()
+
+	playlist, _ := proto.Marshal(&bepb.Playlist{Songs: []*cmpb.Song{{SongId: 1}, {SongId: 2}}})
+	ioutil.WriteFile(filepath.Join(dir, "party.queue"), playlist, 0644)
+
+	archive := filepath.Join(dir, "ytbox.tar.gz")
+	err = Backup(&ServerConfig{
+		DbPath:   filepath.Join(dir, "old.db"),
+		LoadFile: filepath.Join(dir, "party.queue"),
+		YtApiKey: "yt-secret",
+		Tokens:   map[string]string{"admin": "s3cret"},
+	}, archive)
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	os.Mkdir(filepath.Join(dir, "new"), 0755)
+	restored := &ServerConfig{
+		DbPath:   filepath.Join(dir, "new", "ytbox.db"),
+		LoadFile: filepath.Join(dir, "new", "party.queue"),
+	}
+
+	results, err := Restore(restored, archive)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	errs := checkErrors(results)
+	for _, name := range []string{"archive", "database", "database rows", "playlist", "config"} {
+		if err, checked := errs[name]; !checked || err != nil {
+			t.Errorf("Expected the %s check to pass, got %v", name, err)
+		}
+	}
+
+	for _, result := range results {
+		if result.Name == "database rows" && !strings.Contains(result.Detail, "1 rooms") {
+			t.Errorf("Expected the room to be restored, got %s", result.Detail)
+		} else if result.Name == "playlist" && !strings.Contains(result.Detail, "has 2 songs") {
+			t.Errorf("Expected the playlist to be restored, got %s", result.Detail)
+		}
+	}
+
+	config, err := ioutil.ReadFile(filepath.Join(dir, "new", restoredConfigName))
+	if err != nil || strings.Contains(string(config), "secret") {
+		t.Errorf("Expected the config to be restored without its secrets, got %s and %v", config, err)
+	}
+
+	if _, err = Restore(restored, archive); err != ErrDatabaseExists {
+		t.Errorf("Expected restoring over the database to be refused, got %v", err)
+	}
+}
+
+func TestRestore_whenArchiveIsNewer_refuses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "ytbox.tar.gz")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipped := gzip.NewWriter(out)
+	writer := tar.NewWriter(zipped)
+	writeBackupJson(writer, backupManifestName, &backupManifest{Format: backupFormat + 1})
+	writer.Close()
+	zipped.Close()
+	out.Close()
+
+	config := &ServerConfig{DbPath: filepath.Join(dir, "ytbox.db")}
+	if _, err = Restore(config, archive); !errors.Is(err, ErrBackupTooNew) {
+		t.Errorf("Expected a newer archive to be refused, got %v", err)
+	}
+
+	if _, err = os.Stat(config.DbPath); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be restored, got %v", err)
+	}
+}
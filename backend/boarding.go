@@ -0,0 +1,104 @@
+/*
+ * Priority boarding gives everyone a song early in the party. For the first
+ * minutes of a party each user may only queue one song, so the people who
+ * show up first can't fill the queue before everyone else has picked
+ * something. Once boarding is over the usual limits apply.
+ */
+
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+const boardingSongs = 1 // songs each user may queue while boarding
+
+/*
+ * Counts the songs each user queued while boarding the current party
+ */
+type boardingWindow struct {
+	length  time.Duration     // how long boarding lasts. Zero disables it
+	started time.Time         // when the server started or a preset was last applied
+	party   time.Time         // start of the party the counts belong to
+	queued  map[uint32]uint32 // user id -> songs queued while boarding
+	lock    sync.Mutex        // lock on the party and counts
+}
+
+/*
+ * Initialize the boarding window
+ */
+func (b *boardingWindow) init(length time.Duration, now time.Time) {
+	b.length = length
+	b.started = now
+	b.queued = make(map[uint32]uint32)
+}
+
+/*
+ * Start a new party, such as when a preset for another event is applied
+ */
+func (b *boardingWindow) restart(now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.started = now
+}
+
+/*
+ * Returns when the current party started. That's when the server started or
+ * a preset was applied, or when the queue last opened if it keeps hours and
+ * opened later than that.
+ */
+func (b *boardingWindow) partyStart(limits queueLimits, now time.Time) time.Time {
+	start := b.started
+	if limits.openAt != limits.closeAt && limits.isOpen(now) {
+		if opened := limits.nextOpen(now).AddDate(0, 0, -1); opened.After(start) {
+			start = opened
+		}
+	}
+
+	return start
+}
+
+/*
+ * Count a song the user is queueing. Returns false if the user already
+ * queued their song while boarding, along with when boarding ends.
+ */
+func (b *boardingWindow) board(userId uint32, limits queueLimits, now time.Time) (time.Time, bool) {
+	if b.length <= 0 {
+		return time.Time{}, true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	party := b.partyStart(limits, now)
+	end := party.Add(b.length)
+	if !now.Before(end) {
+		return end, true
+	}
+
+	if !party.Equal(b.party) {
+		b.party = party
+		b.queued = make(map[uint32]uint32)
+	}
+
+	if b.queued[userId] >= boardingSongs {
+		return end, false
+	}
+
+	b.queued[userId]++
+	return end, true
+}
+
+/*
+ * Give back the song counted for the user, such as when it was turned away
+ * after boarding for another reason
+ */
+func (b *boardingWindow) unboard(userId uint32) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.queued[userId] > 0 {
+		b.queued[userId]--
+	}
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoardingWindow_oneSongEachUntilBoardingEnds(t *testing.T) {
+	start := time.Date(2024, 6, 7, 20, 0, 0, 0, time.Local)
+	boarding := new(boardingWindow)
+	boarding.init(20*time.Minute, start)
+	limits := queueLimits{}
+
+	if _, ok := boarding.board(1, limits, start.Add(time.Minute)); !ok {
+		t.Fatalf("Expected user 1's first song to board")
+	}
+
+	if end, ok := boarding.board(1, limits, start.Add(2*time.Minute)); ok || !end.Equal(start.Add(20*time.Minute)) {
+		t.Errorf("Expected user 1's second song to wait until boarding ends, but got %v, %t", end, ok)
+	}
+
+	if _, ok := boarding.board(2, limits, start.Add(3*time.Minute)); !ok {
+		t.Errorf("Expected user 2's first song to board")
+	}
+
+	if _, ok := boarding.board(1, limits, start.Add(20*time.Minute)); !ok {
+		t.Errorf("Expected user 1's second song to be accepted once boarding ended")
+	}
+}
+
+func TestBoardingWindow_restartsWhenQueueOpens(t *testing.T) {
+	started := time.Date(2024, 6, 7, 12, 0, 0, 0, time.Local)
+	boarding := new(boardingWindow)
+	boarding.init(30*time.Minute, started)
+	limits := queueLimits{openAt: 17 * 60, closeAt: 23 * 60}
+
+	friday := time.Date(2024, 6, 7, 17, 10, 0, 0, time.Local)
+	boarding.board(1, limits, friday)
+	if _, ok := boarding.board(1, limits, friday.Add(time.Minute)); ok {
+		t.Fatalf("Expected user 1 to be held to one song after the queue opened")
+	}
+
+	saturday := friday.AddDate(0, 0, 1)
+	if _, ok := boarding.board(1, limits, saturday); !ok {
+		t.Errorf("Expected user 1's song to board when the queue opened the next day")
+	}
+}
+
+func TestBoardingWindow_restart(t *testing.T) {
+	start := time.Now()
+	boarding := new(boardingWindow)
+	boarding.init(10*time.Minute, start)
+
+	boarding.board(1, queueLimits{}, start)
+	boarding.restart(start.Add(time.Hour))
+	if _, ok := boarding.board(1, queueLimits{}, start.Add(time.Hour)); !ok {
+		t.Errorf("Expected user 1's song to board at the start of the new party")
+	}
+}
+
+func TestBoardingWindow_disabled(t *testing.T) {
+	start := time.Now()
+	boarding := new(boardingWindow)
+	boarding.init(0, start)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := boarding.board(1, queueLimits{}, start); !ok {
+			t.Errorf("Expected every song to be accepted without a boarding window")
+		}
+	}
+}
+
+func TestBoardingWindow_unboard_givesSongBack(t *testing.T) {
+	start := time.Now()
+	boarding := new(boardingWindow)
+	boarding.init(10*time.Minute, start)
+
+	boarding.board(1, queueLimits{}, start)
+	boarding.unboard(1)
+	if _, ok := boarding.board(1, queueLimits{}, start.Add(time.Minute)); !ok {
+		t.Fatalf("Expected user 1's song to board after the first was given back")
+	}
+
+	if _, ok := boarding.board(1, queueLimits{}, start.Add(2*time.Minute)); ok {
+		t.Errorf("Expected user 1 to still be held to one song")
+	}
+
+	// giving back a song nobody boarded does nothing
+	boarding.unboard(2)
+	boarding.board(2, queueLimits{}, start)
+	if _, ok := boarding.board(2, queueLimits{}, start); ok {
+		t.Errorf("Expected user 2 to be held to one song")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Keeps a directory of the clients streaming from the backend, so an admin
+ * can work out why a client stopped updating, like a TV that froze on an old
+ * song. Each stream is listed with where it connects from, who it is, when it
+ * connected and when it was last active: when a player last sent a status or
+ * a watcher was last sent an event.
+ */
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Directory of the clients streaming from the backend
+ */
+type clientDirectory struct {
+	clients map[uint32]*bepb.ConnectedClient // connection id -> the client
+	lastId  uint32                           // id given to the latest connection
+	lock    sync.Mutex                       // lock on the clients
+}
+
+/*
+ * Initialize the directory
+ */
+func (d *clientDirectory) init() {
+	d.clients = make(map[uint32]*bepb.ConnectedClient)
+}
+
+/*
+ * Add a client that opened a stream. The client must be disconnected once
+ * the stream ends.
+ */
+func (d *clientDirectory) connect(ctx context.Context, kind bepb.ClientKind, identity string) *bepb.ConnectedClient {
+	now := time.Now().Unix()
+	client := &bepb.ConnectedClient{
+		Kind:        kind,
+		Identity:    identity,
+		ConnectedAt: now,
+		LastActive:  now,
+	}
+
+	if caller, ok := peer.FromContext(ctx); ok && caller.Addr != nil {
+		client.Peer = caller.Addr.String()
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			client.UserAgent = values[0]
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.lastId++
+	client.Id = d.lastId
+	d.clients[client.Id] = client
+	return client
+}
+
+/*
+ * Note which zone a player joined and its id there
+ */
+func (d *clientDirectory) joined(client *bepb.ConnectedClient, zoneId uint32, playerId int, identity string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	client.ZoneId = zoneId
+	client.PlayerId = uint32(playerId)
+	if identity != "" {
+		client.Identity = identity
+	}
+}
+
+/*
+ * Note that a client did something just now
+ */
+func (d *clientDirectory) touch(client *bepb.ConnectedClient) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	client.LastActive = time.Now().Unix()
+}
+
+/*
+ * Remove a client whose stream ended
+ */
+func (d *clientDirectory) disconnect(client *bepb.ConnectedClient) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.clients, client.Id)
+}
+
+/*
+ * Returns copies of the clients, longest connected first
+ */
+func (d *clientDirectory) list() []*bepb.ConnectedClient {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	clients := make([]*bepb.ConnectedClient, 0, len(d.clients))
+	for _, client := range d.clients {
+		clients = append(clients, proto.Clone(client).(*bepb.ConnectedClient))
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Id < clients[j].Id
+	})
+
+	return clients
+}
+
+/*
+ * Returns who the caller is going by the credentials it sent: the name of its
+ * api key or the role its token grants
+ */
+func (p *accessPolicy) describeCaller(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && p.keys != nil {
+		if values := md.Get(common.ApiKeyMetadataKey); len(values) > 0 {
+			if key, err := p.keys.dbManager.GetApiKeyByHash(hashApiKey(values[0])); err == nil {
+				return fmt.Sprintf("api key %s", key.Name)
+			}
+		}
+	}
+
+	return p.callerRole(ctx).String()
+}
+
+/*
+ * Lists the clients streaming from the backend
+ */
+func (s *BackendServer) ListClients(con context.Context, empty *cmpb.Empty) (*bepb.ClientList, error) {
+	return &bepb.ClientList{Clients: s.clients.list(), Err: &bepb.Error{Success: true, Message: "Success"}}, nil
+}
@@ -0,0 +1,237 @@
+/*
+ * A dry run of starting the server, for catching a broken config before a
+ * restart takes down a live party. Everything the server needs on start up is
+ * checked without changing anything, so the checks can run next to the server
+ * they're about to replace: the database is opened read only and the
+ * addresses aren't listened on, since the running server has them.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const apiCheckTimeout = 10 * time.Second // how long checking the YouTube api key may take
+
+var ErrMissingApiKey = errors.New("No YouTube api key was given.")
+
+/*
+ * The outcome of one check of the config
+ */
+type CheckResult struct {
+	Name   string // what was checked
+	Detail string // what was found, such as the songs in a snapshot
+	Err    error  // why the check failed. Nil if it passed
+}
+
+/*
+ * Check everything the server needs to start with the config. Returns the
+ * result of each check in the order they ran.
+ */
+func CheckConfig(config *ServerConfig) []CheckResult {
+	var results []CheckResult
+	check := func(name string, run func() (string, error)) {
+		detail, err := run()
+		results = append(results, CheckResult{Name: name, Detail: detail, Err: err})
+	}
+
+	check("listen address", func() (string, error) { return config.Addr, checkAddr(config.Addr) })
+
+	if config.MetricsAddr != "" {
+		check("metrics address", func() (string, error) { return config.MetricsAddr, checkAddr(config.MetricsAddr) })
+	}
+
+	check("access policy", func() (string, error) {
+		_, err := newAccessPolicy(config.Tokens, config.Policy)
+		return fmt.Sprintf("%d tokens", len(config.Tokens)), err
+	})
+
+	check("lyrics provider", func() (string, error) {
+		_, err := newLyricsProvider(config.Lyrics)
+		return config.Lyrics, err
+	})
+
+	check("fetchers", func() (string, error) {
+		routes, err := fetcherRoutes(config.Fetchers)
+		if err != nil {
+			return "", err
+		}
+
+		// the server starts without yt-dlp, so it's only a warning
+		var missing []string
+		installed := new(ytDlpFetcher).init()
+		for service, fetcher := range routes {
+			if fetcher == FetcherYtDlp && !installed {
+				missing = append(missing, service)
+			}
+		}
+
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Sprintf("%s isn't installed, so links to %s can't be fetched", ytDlpCommand,
+				strings.Join(missing, " and ")), nil
+		}
+		return "", nil
+	})
+
+	check("database", func() (string, error) { return config.DbPath, db.CheckDatabase(config.DbPath) })
+	if config.ReadReplica != "" {
+		check("read replica", func() (string, error) {
+			return config.ReadReplica, db.CheckDatabase(config.ReadReplica)
+		})
+	}
+
+	// the snapshots can't be read without their store
+	snapshots, err := newSnapshotStore(config)
+	check("snapshot store", func() (string, error) {
+		if err != nil {
+			return config.SnapshotStore, err
+		} else if remote, ok := snapshots.(fmt.Stringer); ok {
+			return remote.String(), nil
+		}
+		return "local files", nil
+	})
+
+	if err == nil && config.LoadFile != "" {
+		check("playlist", func() (string, error) { return checkPlaylist(snapshots, config.LoadFile) })
+	}
+
+	if err == nil {
+		check("queue snapshot", func() (string, error) {
+			detail, err := checkPlaylist(snapshots, queuer.QueueSnapshot)
+			if errors.Is(err, os.ErrNotExist) {
+				return "none saved", nil
+			}
+			return detail, err
+		})
+	}
+
+	if config.CacheDir != "" {
+		check("audio cache", func() (string, error) { return config.CacheDir, checkDir(config.CacheDir) })
+	}
+
+	if config.RecapWebhook != "" {
+		check("recap webhook", func() (string, error) {
+			if !webhooksBuilt {
+				return config.RecapWebhook, ErrWebhooksNotBuilt
+			}
+			return config.RecapWebhook, checkUrl(config.RecapWebhook)
+		})
+	}
+
+	if config.FederationPeer != "" {
+		check("federation peer", func() (string, error) { return config.FederationPeer, checkAddr(config.FederationPeer) })
+	}
+
+	check("YouTube api key", func() (string, error) {
+		if config.YtApiKey == "" {
+			return "", ErrMissingApiKey
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), apiCheckTimeout)
+		defer cancel()
+
+		fetcher := new(SongFetcher)
+		fetcher.init(config.YtApiKey, config.Region, config.FetchTimeout)
+		return "", fetcher.checkApiKey(ctx)
+	})
+
+	return results
+}
+
+/*
+ * Check that an address has a host, which may be empty, and a port
+ */
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if _, err = net.LookupPort("tcp", port); err != nil || port == "" {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+/*
+ * Check that a saved playlist can be read from the store. Returns the number
+ * of songs in it and how many start up will drop for malformed ids.
+ */
+func checkPlaylist(store SnapshotStore, path string) (string, error) {
+	in, err := store.Load(path)
+	if err != nil {
+		return "", err
+	}
+
+	playlist := &bepb.Playlist{}
+	if err = proto.Unmarshal(in, playlist); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// the rest of the repairs need the database, so they're left to start up
+	malformed := 0
+	for _, song := range playlist.Songs {
+		if !validServiceId(song.Service, song.ServiceId) {
+			malformed++
+		}
+	}
+
+	if malformed > 0 {
+		return fmt.Sprintf("%s has %d songs, %d with malformed ids that will be dropped", path,
+			len(playlist.Songs), malformed), nil
+	}
+	return fmt.Sprintf("%s has %d songs", path, len(playlist.Songs)), nil
+}
+
+/*
+ * Check that a directory can be written to. A missing directory passes, since
+ * it's created on start up.
+ */
+func checkDir(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	probe, err := ioutil.TempFile(dir, ".ytbox-check")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+/*
+ * Check that a url is an absolute http or https url
+ */
+func checkUrl(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an http or https url", raw)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func checkErrors(results []CheckResult) map[string]error {
+	errs := make(map[string]error)
+	for _, result := range results {
+		errs[result.Name] = result.Err
+	}
+	return errs
+}
+
+func TestCheckConfig_reportsEachProblem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	playlist, _ := proto.Marshal(&bepb.Playlist{Songs: []*cmpb.Song{{SongId: 1}, {SongId: 2}}})
+	ioutil.WriteFile(filepath.Join(dir, "good.queue"), playlist, 0644)
+
+	results := CheckConfig(&ServerConfig{
+		Addr:         "127.0.0.1:9009",
+		DbPath:       filepath.Join(dir, "new.db"),
+		LoadFile:     filepath.Join(dir, "good.queue"),
+		Lyrics:       "nope",
+		Policy:       map[string]string{"NoSuchRpc": "admin"},
+		RecapWebhook: "discord",
+		MetricsAddr:  "9100",
+	})
+
+	errs := checkErrors(results)
+	for _, name := range []string{"listen address", "database", "playlist", "fetchers"} {
+		if err, checked := errs[name]; !checked || err != nil {
+			t.Errorf("Expected the %s check to pass, got %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"lyrics provider", "access policy", "recap webhook", "metrics address"} {
+		if errs[name] == nil {
+			t.Errorf("Expected the %s check to fail", name)
+		}
+	}
+
+	if errs["YouTube api key"] != ErrMissingApiKey {
+		t.Errorf("Expected the missing api key to be reported, got %v", errs["YouTube api key"])
+	}
+
+	// the database isn't created by checking it
+	if _, err := os.Stat(filepath.Join(dir, "new.db")); !os.IsNotExist(err) {
+		t.Errorf("Expected the check not to create the database, got %v", err)
+	}
+}
+
+func TestCheckPlaylist_rejectsGarbage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bad.queue")
+	ioutil.WriteFile(path, []byte("not a playlist"), 0644)
+
+	if _, err := checkPlaylist(new(fileStore), path); err == nil {
+		t.Error("Expected a garbled playlist to fail the check")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Saves a snapshot of the party when asked to and when the backend is about to
+ * go down, so an unexpected termination loses at most a few seconds of the
+ * queue. ytb-be saves one when it gets SIGUSR1 and before it shuts down on
+ * SIGTERM. An rpc that panics dumps the queue on a best effort basis before
+ * the backend crashes. The snapshot can be loaded with ytb-be-cli restore.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+const crashDumpTimeout = 5 * time.Second // longest a panic waits for the queue to be dumped
+
+var ErrNoCrashSnapshot = errors.New("No crash snapshot file is set.")
+
+/*
+ * Save a snapshot of the queue, the now playing song and the recent history
+ * to the crash snapshot file, logging why it was saved
+ */
+func (s *BackendServer) SaveCrashSnapshot(reason string) error {
+	if s.crashPath == "" {
+		return ErrNoCrashSnapshot
+	}
+
+	log.Printf("Saving a snapshot to %s: %s", s.crashPath, reason)
+	if response := s.saveSnapshot(s.crashPath); !response.Success {
+		return errors.New(response.Message)
+	}
+	return nil
+}
+
+/*
+ * Dump the queue and now playing song to the crash snapshot file without the
+ * history, which would wait on the history writes. Gives up after a while,
+ * since whatever panicked may still hold the locks the dump needs.
+ */
+func (s *BackendServer) dumpQueue() {
+	if s.crashPath == "" {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		snapshot, err := s.snapshotWith(nil)
+		if err != nil {
+			log.Printf("Failed to take a snapshot of the queue: %v", err)
+			return
+		}
+
+		out, err := proto.Marshal(snapshot)
+		if err == nil {
+			err = s.snapshots.Save(s.crashPath, out)
+		}
+
+		if err != nil {
+			log.Printf("Failed to dump the queue to %s: %v", s.crashPath, err)
+			return
+		}
+		log.Printf("Dumped the queue to %s {songs: %d}", s.crashPath, len(snapshot.Songs))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(crashDumpTimeout):
+		log.Printf("Gave up dumping the queue after %v", crashDumpTimeout)
+	}
+}
+
+/*
+ * Dump the queue if the calling goroutine is panicking, then carry on
+ * panicking. Must be deferred.
+ */
+func (s *BackendServer) dumpOnPanic() {
+	if r := recover(); r != nil {
+		log.Printf("Panicked: %v\n%s", r, debug.Stack())
+		s.dumpQueue()
+		panic(r)
+	}
+}
+
+/*
+ * Dump the queue before a panicking unary rpc crashes the backend
+ */
+func (s *BackendServer) panicInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	defer s.dumpOnPanic()
+	return handler(ctx, req)
+}
+
+/*
+ * Dump the queue before a panicking streaming rpc crashes the backend
+ */
+func (s *BackendServer) panicStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	defer s.dumpOnPanic()
+	return handler(srv, stream)
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func readCrashSnapshot(t *testing.T, path string) *bepb.Snapshot {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected a crash snapshot, got %v", err)
+	}
+
+	snapshot := new(bepb.Snapshot)
+	if err = proto.Unmarshal(in, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestSaveCrashSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	if err = server.SaveCrashSnapshot("testing"); err != ErrNoCrashSnapshot {
+		t.Errorf("Expected nothing saved without a crash snapshot file, got %v", err)
+	}
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	zedd, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	for _, serviceId := range []string{"dQw4w9WgXcQ", "9bZkp7q19f0"} {
+		song := &cmpb.Song{Title: serviceId, ServiceId: serviceId, UserId: zedd.User.UserId, Username: "Zedd",
+			RoomId: room.Room.Id}
+		if err = server.queueSong(server.zones.defaultZone, song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.crashPath = filepath.Join(dir, "crash.snapshot")
+	if err = server.SaveCrashSnapshot("testing"); err != nil {
+		t.Fatalf("Expected the snapshot to be saved, got %v", err)
+	}
+
+	if snapshot := readCrashSnapshot(t, server.crashPath); len(snapshot.Songs) != 2 || len(snapshot.Rooms) == 0 {
+		t.Errorf("Expected both queued songs and their room in the snapshot, got %v", snapshot)
+	}
+}
+
+func TestPanicInterceptor_dumpsQueueAndPanics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.crashPath = filepath.Join(dir, "crash.snapshot")
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	zedd, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	song := &cmpb.Song{Title: "Song", ServiceId: "dQw4w9WgXcQ", UserId: zedd.User.UserId, Username: "Zedd",
+		RoomId: room.Room.Id}
+	if err = server.queueSong(server.zones.defaultZone, song); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Expected the panic to carry on, got %v", r)
+			}
+		}()
+
+		server.panicInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{},
+			func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") })
+	}()
+
+	if snapshot := readCrashSnapshot(t, server.crashPath); len(snapshot.Songs) != 1 || len(snapshot.History) != 0 {
+		t.Errorf("Expected the queue dumped without the history, got %v", snapshot)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Keeps long running installs from growing without bound. On a schedule, the
+ * maintainer prunes history older than the retention period and then vacuums
+ * the database.
+ */
+
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+)
+
+/*
+ * Runs database maintenance periodically or on demand
+ */
+type dbMaintainer struct {
+	dbManager    db.DbManager        // database to maintain
+	achievements *achievementTracker // awards achievements before history is pruned
+	retention    time.Duration       // how long to keep history. Zero keeps it forever
+	interval     time.Duration       // time between scheduled runs. Zero disables them
+	lock         sync.Mutex          // only one maintenance run at a time
+	done         chan struct{}       // closed to stop the scheduled runs
+}
+
+/*
+ * Initialize the maintainer. It still needs to be started to run on a
+ * schedule.
+ */
+func (m *dbMaintainer) init(dbManager db.DbManager, achievements *achievementTracker,
+	retention time.Duration, interval time.Duration) {
+	m.dbManager = dbManager
+	m.achievements = achievements
+	m.retention = retention
+	m.interval = interval
+	m.done = make(chan struct{})
+}
+
+/*
+ * Start running maintenance on a schedule
+ */
+func (m *dbMaintainer) start() {
+	if m.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.run()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+/*
+ * Stop the scheduled maintenance runs
+ */
+func (m *dbMaintainer) stop() {
+	close(m.done)
+}
+
+/*
+ * Prune the expired history and vacuum the database. Returns the number of
+ * songs pruned.
+ */
+func (m *dbMaintainer) run() (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var pruned int64
+	var err error
+
+	if m.retention > 0 {
+		// award what the expiring history earned before it's gone
+		if m.achievements != nil {
+			if err = m.achievements.refresh(); err != nil {
+				log.Printf("Database maintenance failed to award achievements: %v", err)
+			}
+		}
+
+		pruned, err = m.dbManager.PruneSongs(time.Now().Add(-m.retention))
+		if err != nil {
+			log.Printf("Database maintenance failed to prune history: %v", err)
+			return 0, err
+		}
+	}
+
+	if err = m.dbManager.Vacuum(); err != nil {
+		log.Printf("Database maintenance failed to vacuum: %v", err)
+		return pruned, err
+	}
+
+	log.Printf("Finished database maintenance: {pruned songs: %d}", pruned)
+	return pruned, nil
+}
@@ -0,0 +1,188 @@
+/*
+ * Helps diagnose problems during a party without restarting the backend and
+ * losing what's queued. Admins can turn on debug logging, which adds every
+ * rpc call and command sent to the players to the log, and dump the internal
+ * state of the backend: each zone's queue and players, the size of the
+ * in-memory caches and how many goroutines are running.
+ */
+
+package backend
+
+import (
+	"context"
+	"log"
+	"path"
+	"runtime"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Log levels by how they're asked for over rpc
+ */
+var logLevels = map[bepb.LogLevel]common.LogLevel{
+	bepb.LogLevel_InfoLevel:  common.InfoLevel,
+	bepb.LogLevel_DebugLevel: common.DebugLevel,
+}
+
+/*
+ * Changes how much the backend logs
+ */
+func (s *BackendServer) SetLogLevel(con context.Context, req *bepb.LogLevelRequest) (*bepb.Error, error) {
+	level, exists := logLevels[req.GetLevel()]
+	if !exists {
+		return &bepb.Error{Success: false, Message: "Unknown log level."}, nil
+	}
+
+	previous := common.SetLogLevel(level)
+	log.Printf("Changed the log level from %v to %v", previous, level)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Reports on the backend's internal state
+ */
+func (s *BackendServer) DumpState(con context.Context, empty *cmpb.Empty) (*bepb.DebugReport, error) {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	report := &bepb.DebugReport{
+		UptimeSeconds: uint64(time.Since(s.started).Seconds()),
+		Goroutines:    uint32(runtime.NumGoroutine()),
+		HeapBytes:     memory.HeapAlloc,
+		EventStreams:  uint32(s.events.count()),
+		PendingWrites: uint32(s.writes.backlog()),
+		Err:           &bepb.Error{Success: true, Message: "Success"},
+	}
+
+	for level, value := range logLevels {
+		if value == common.GetLogLevel() {
+			report.LogLevel = level
+		}
+	}
+
+	for _, zone := range s.zones.list() {
+		_, queue := zone.queueMgr.Snapshot()
+		report.Zones = append(report.Zones, &bepb.DebugZone{
+			Zone:       zone.toProto(),
+			Queue:      queue,
+			Dispatched: zone.queueMgr.Dispatched(),
+			Players:    zone.playerMgr.debugPlayers(),
+			QueueStats: debugQueue(zone.queueMgr.Stats()),
+		})
+	}
+
+	audioEntries, audioBytes := s.downloader.cacheSize()
+	report.Caches = []*bepb.DebugCache{
+		{Name: "users", Entries: uint64(s.userCache.Size())},
+		{Name: "audio", Entries: uint64(audioEntries), Bytes: uint64(audioBytes)},
+		{Name: "recent submissions", Entries: uint64(s.dedup.size())},
+	}
+
+	return report, nil
+}
+
+/*
+ * Log every rpc call, how long it took and how it failed when logging at the
+ * debug level
+ */
+func debugInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if common.GetLogLevel() < common.DebugLevel {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	response, err := handler(ctx, req)
+	common.Debugf("Call to %s took %v: {request: %v, error: %v}", path.Base(info.FullMethod),
+		time.Since(start), req, err)
+	return response, err
+}
+
+/*
+ * Describe the size of a queue
+ */
+func debugQueue(stats queuer.QueueStats) *bepb.DebugQueue {
+	queue := &bepb.DebugQueue{
+		Entries:      uint32(stats.Entries),
+		TrackedUsers: uint32(stats.Tracked),
+		Capacity:     uint32(stats.Capacity),
+	}
+
+	for userId, songs := range stats.Buckets {
+		queue.Buckets = append(queue.Buckets, &bepb.DebugBucket{UserId: userId, Songs: uint32(songs)})
+	}
+
+	sort.Slice(queue.Buckets, func(i, j int) bool {
+		return queue.Buckets[i].UserId < queue.Buckets[j].UserId
+	})
+
+	return queue
+}
+
+/*
+ * Describe the players connected to the zone, ordered by id
+ */
+func (mgr *playerManager) debugPlayers() []*bepb.DebugPlayer {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	players := make([]*bepb.DebugPlayer, 0, len(mgr.streams))
+	for id, state := range mgr.streams {
+		players = append(players, &bepb.DebugPlayer{
+			PlayerId:       uint32(id),
+			RegisteredName: state.registeredName,
+			OutputDevice:   state.outputDevice,
+			Ready:          mgr.ready[id],
+			SupportsVolume: state.supportsVolume,
+		})
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].PlayerId < players[j].PlayerId
+	})
+
+	return players
+}
+
+/*
+ * Returns the number of songs in the audio cache and the bytes they take up
+ */
+func (d *songDownloader) cacheSize() (int, int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.entries), d.usedBytes
+}
+
+/*
+ * Returns the number of submissions remembered within the window
+ */
+func (d *dedupWindow) size() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.recent)
+}
+
+/*
+ * Returns the number of clients streaming events
+ */
+func (b *eventBroadcaster) count() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subscribers)
+}
+
+/*
+ * Returns the number of writes waiting to be made
+ */
+func (q *writeQueue) backlog() int {
+	return len(q.writes)
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestDumpState_reportsQueuesAndPlayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	server.getUserFromId(bob.User.UserId)
+	for _, serviceId := range []string{"first", "second"} {
+		server.queueSong(server.zones.defaultZone, &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube,
+			ServiceId: serviceId, UserId: bob.User.UserId, RoomId: room.Room.Id})
+	}
+
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.playerMgr.add(&controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}, cancel)
+
+	defer common.SetLogLevel(common.InfoLevel)
+	response, _ := server.SetLogLevel(context.Background(), &bepb.LogLevelRequest{Level: bepb.LogLevel_DebugLevel})
+	if !response.Success || common.GetLogLevel() != common.DebugLevel {
+		t.Fatalf("Expected logging at the debug level, got %v", response)
+	}
+
+	report, _ := server.DumpState(context.Background(), &cmpb.Empty{})
+	if !report.Err.Success || report.LogLevel != bepb.LogLevel_DebugLevel || report.Goroutines == 0 {
+		t.Fatalf("Expected a report at the debug level, got %v", report)
+	}
+
+	if len(report.Zones) != 1 || len(report.Zones[0].Queue) != 2 || len(report.Zones[0].Players) != 1 {
+		t.Fatalf("Expected the default zone with its queue and player, got %v", report.Zones)
+	} else if report.Zones[0].Queue[0].ServiceId != "first" {
+		t.Errorf("Expected the queue in play order, got %v", report.Zones[0].Queue)
+	}
+
+	for _, cache := range report.Caches {
+		if cache.Name == "users" && cache.Entries != 1 {
+			t.Errorf("Expected the cached user to be counted, got %v", cache)
+		}
+	}
+}
+
+func TestQueueStats_compactAfterRemovingSongs(t *testing.T) {
+	for name, queue := range map[string]queuer.SongQueuer{
+		"round robin": queuer.NewRoundRobinQueuer(),
+		"hybrid":      queuer.NewHybridQueuer(queuer.DefaultHybridWeights),
+	} {
+		queueMgr := new(queuer.SongQueueManager)
+		queueMgr.Init(queue)
+		for songId := uint32(1); songId <= 300; songId++ {
+			queueMgr.AddSong(&cmpb.Song{SongId: songId, UserId: songId%3 + 1, Service: cmpb.ServiceType_Youtube,
+				ServiceId: "spam"})
+		}
+		queueMgr.AddSong(&cmpb.Song{SongId: 301, UserId: 1, ServiceId: "keeper"})
+
+		stats := queueMgr.Stats()
+		if stats.Entries != 301 || len(stats.Buckets) != 3 || stats.Buckets[1] != 101 || stats.Tracked != 3 {
+			t.Fatalf("%s: expected 301 songs from 3 users, got %+v", name, stats)
+		}
+
+		queueMgr.RemoveByServiceId(cmpb.ServiceType_Youtube, "spam")
+		stats = queueMgr.Stats()
+		if stats.Entries != 1 || stats.Buckets[1] != 1 || stats.Capacity > 64 {
+			t.Errorf("%s: expected the queue to shrink to fit its last song, got %+v", name, stats)
+		}
+	}
+}
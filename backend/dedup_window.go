@@ -0,0 +1,131 @@
+/*
+ * Turns repeat submissions into votes. When a song was submitted by anyone
+ * within the last few minutes, submitting it again counts as a vote for the
+ * earlier submission instead of queueing another copy, even once the earlier
+ * one played or was removed. Each user gets one vote for a song, and the
+ * window is turned off unless it's configured.
+ */
+
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * A song submitted within the window
+ */
+type recentSubmission struct {
+	song   *cmpb.Song      // the song as it was queued
+	zoneId uint32          // zone whose queue the song went into
+	at     time.Time       // when the song was queued
+	voters map[uint32]bool // users who submitted the song, its submitter included
+}
+
+/*
+ * Remembers the songs submitted within the window
+ */
+type dedupWindow struct {
+	window time.Duration       // how long submissions are remembered. Zero turns dedup off
+	recent []*recentSubmission // submissions within the window, oldest first
+	lock   sync.Mutex          // lock on the recent submissions
+}
+
+/*
+ * Initialize the window with how long submissions are remembered
+ */
+func (d *dedupWindow) init(window time.Duration) {
+	d.window = window
+}
+
+/*
+ * Remember a song that was just queued in a zone
+ */
+func (d *dedupWindow) record(song *cmpb.Song, zoneId uint32, now time.Time) {
+	if d.window <= 0 {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.prune(now)
+	d.recent = append(d.recent, &recentSubmission{
+		song:   song,
+		zoneId: zoneId,
+		at:     now,
+		voters: map[uint32]bool{song.UserId: true},
+	})
+}
+
+/*
+ * Returns the submission within the window that the song is another copy of,
+ * or nil if there's none
+ */
+func (d *dedupWindow) find(song *cmpb.Song, zoneId uint32, now time.Time) *recentSubmission {
+	if d.window <= 0 {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.prune(now)
+	for _, recent := range d.recent {
+		if recent.zoneId == zoneId && sameSong(recent.song, song) {
+			return recent
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Count a user's vote for a recent submission. Returns false if the user
+ * already submitted or voted for it.
+ */
+func (d *dedupWindow) vote(recent *recentSubmission, userId uint32) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if recent.voters[userId] {
+		return false
+	}
+
+	recent.voters[userId] = true
+	return true
+}
+
+/*
+ * Forget the submissions older than the window. Expects the lock to be held.
+ */
+func (d *dedupWindow) prune(now time.Time) {
+	expired := 0
+	for expired < len(d.recent) && now.Sub(d.recent[expired].at) > d.window {
+		expired++
+	}
+
+	d.recent = d.recent[expired:]
+}
+
+/*
+ * Count a submission of a recent song as a vote for it. The vote is added to
+ * the song if it's still queued.
+ */
+func (s *BackendServer) voteForRecent(zone *zone, recent *recentSubmission, userId uint32) *bepb.Error {
+	if !s.dedup.vote(recent, userId) {
+		return &bepb.Error{Success: false, Message: "You already queued or voted for that song."}
+	}
+
+	log.Printf("Counted a submission of %s from user %d as a vote", recent.song.ServiceId, userId)
+	if zone.queueMgr.VoteSong(recent.song.SongId) {
+		return &bepb.Error{Success: true, Message: "That song was just queued, so your submission counted as a vote for it."}
+	}
+
+	return &bepb.Error{Success: true, Message: "That song was queued a moment ago, so your submission counted as a vote for it."}
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestDedupWindow_find_onlyWithinWindow(t *testing.T) {
+	dedup := new(dedupWindow)
+	dedup.init(10 * time.Minute)
+
+	now := time.Now()
+	song := &cmpb.Song{SongId: 1, UserId: 1, Service: cmpb.ServiceType_Youtube, ServiceId: "abc", Title: "Bags!!"}
+	dedup.record(song, defaultZoneId, now)
+
+	again := &cmpb.Song{UserId: 2, Service: cmpb.ServiceType_Youtube, ServiceId: "abc", Title: "Bags!!"}
+	recent := dedup.find(again, defaultZoneId, now.Add(5*time.Minute))
+	if recent == nil || recent.song != song {
+		t.Fatalf("Expected the first submission within the window, but got %v", recent)
+	}
+
+	if dedup.find(again, 3, now.Add(5*time.Minute)) != nil {
+		t.Errorf("Expected submissions to other zones' queues not to match")
+	}
+
+	if dedup.vote(recent, 1) || !dedup.vote(recent, 2) || dedup.vote(recent, 2) {
+		t.Errorf("Expected one vote for each user who didn't submit the song")
+	}
+
+	if dedup.find(again, defaultZoneId, now.Add(11*time.Minute)) != nil {
+		t.Errorf("Expected the submission to be forgotten after the window")
+	}
+}
+
+func TestDedupWindow_whenOff_findsNothing(t *testing.T) {
+	dedup := new(dedupWindow)
+	dedup.init(0)
+
+	song := &cmpb.Song{SongId: 1, UserId: 1, ServiceId: "abc"}
+	dedup.record(song, defaultZoneId, time.Now())
+	if dedup.find(song, defaultZoneId, time.Now()) != nil {
+		t.Errorf("Expected nothing to be remembered without a window")
+	}
+}
+
+func TestVoteForRecent_countsVoteOnQueuedSong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.dedup.init(10 * time.Minute)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	zone := server.zones.defaultZone
+
+	song := queuedSong(0, bob.User.UserId, "PT3M")
+	song.RoomId = room.Room.Id
+	song.ServiceId = "SilKjJ0S904"
+	server.queueSong(zone, song)
+	server.dedup.record(song, zone.queueZoneId(), time.Now())
+
+	recent := server.dedup.find(song, zone.queueZoneId(), time.Now())
+	if response := server.voteForRecent(zone, recent, 7); !response.Success {
+		t.Fatalf("Expected the submission to count as a vote, but got %v", response)
+	}
+
+	if queued := server.queueMgr.GetPlaylist().Songs; len(queued) != 1 || queued[0].Votes != 1 {
+		t.Errorf("Expected the one queued song to have a vote, but got %v", queued)
+	}
+
+	if response := server.voteForRecent(zone, recent, 7); response.Success {
+		t.Errorf("Expected a second vote from the same user to be turned away, but got %v", response)
+	}
+
+	// the song played, but the submission still counts as a vote
+	server.queueMgr.PopQueue()
+	if response := server.voteForRecent(zone, recent, 8); !response.Success || server.queueMgr.Len() != 0 {
+		t.Errorf("Expected a vote without queueing the song again, but got %v", response)
+	}
+}
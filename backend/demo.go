@@ -0,0 +1,138 @@
+/*
+ * Demo mode fills the database with a room of sample users, a few hours of
+ * history and a queue, so new contributors and anyone trying out yt_box see a
+ * working UI right away. The sample room is marked as demo data in the
+ * database, and removing the demo data removes the room, its users and
+ * everything they did.
+ */
+
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	demoRoomName = "Demo"           // name of the room the sample users are in
+	demoHistory  = 6                // sample songs played before the queue
+	demoSongGap  = 20 * time.Minute // time between the submissions in the history
+	demoSkipped  = 4                // every this many songs in the history was skipped
+)
+
+/*
+ * Sample users, who take turns submitting the sample songs
+ */
+var demoUsers = []string{"Alice", "Bob", "Carol", "Dave"}
+
+/*
+ * Sample songs. The first ones make up the history and the rest the queue.
+ */
+var demoSongs = []struct {
+	videoId  string
+	title    string
+	duration string
+}{
+	{"dQw4w9WgXcQ", "Rick Astley - Never Gonna Give You Up (Official Music Video)", "PT3M33S"},
+	{"fJ9rUzIMcZQ", "Queen - Bohemian Rhapsody (Official Video Remastered)", "PT5M59S"},
+	{"hTWKbfoikeg", "Nirvana - Smells Like Teen Spirit (Official Music Video)", "PT5M1S"},
+	{"9bZkp7q19f0", "PSY - GANGNAM STYLE(강남스타일) M/V", "PT4M13S"},
+	{"OPf0YbXqDm0", "Mark Ronson - Uptown Funk (Official Video) ft. Bruno Mars", "PT4M31S"},
+	{"1w7OgIMMRc4", "Guns N' Roses - Sweet Child O' Mine (Official Music Video)", "PT5M56S"},
+	{"kJQP7kiw5Fk", "Luis Fonsi - Despacito ft. Daddy Yankee", "PT4M42S"},
+	{"JGwWNGJdvx8", "Ed Sheeran - Shape of You (Official Music Video)", "PT4M24S"},
+	{"YQHsXMglC9A", "Adele - Hello (Official Music Video)", "PT6M7S"},
+	{"CevxZvSJLk8", "Katy Perry - Roar (Official)", "PT4M30S"},
+}
+
+/*
+ * Add the sample room, users and history to the database and queue the
+ * sample songs in the default zone. Does nothing if the sample room already
+ * exists.
+ */
+func (s *BackendServer) seedDemo(now time.Time) error {
+	if _, err := s.dbManager.GetRoomByName(demoRoomName); err == nil {
+		log.Printf("Demo data was already added")
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	queue := make([]*cmpb.Song, 0, len(demoSongs)-demoHistory)
+	err := s.dbManager.WithTx(func(tx db.DbManager) error {
+		room, err := tx.AddRoom(demoRoomName)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.MarkDemoRoom(room.Room.Id); err != nil {
+			return err
+		}
+
+		users := make([]*db.UserData, 0, len(demoUsers))
+		for _, name := range demoUsers {
+			user, err := tx.AddUser(name, room.Room.Id)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+
+		// the users took turns over the last couple of hours
+		for i, sample := range demoSongs {
+			song := &cmpb.Song{
+				Title:     sample.title,
+				Service:   cmpb.ServiceType_Youtube,
+				ServiceId: sample.videoId,
+				UserId:    users[i%len(users)].User.UserId,
+				Username:  users[i%len(users)].User.Username,
+				RoomId:    room.Room.Id,
+				Source:    cmpb.SubmissionSource_WebUi,
+				Metadata:  &cmpb.Metadata{Thumbnail: youtubeThumbnail(sample.videoId), Duration: sample.duration},
+			}
+			applyTitle(song, s.rawTitles)
+
+			if i < demoHistory {
+				submitted := now.Add(-time.Duration(demoHistory-i) * demoSongGap)
+				skipped := (i+1)%demoSkipped == 0
+				if _, err := tx.AddHistorySong(song, submitted, skipped); err != nil {
+					return err
+				}
+
+				// skipped songs were voted off by the next two users
+				outcome, voters := bepb.SongOutcome_Completed, []uint32(nil)
+				if skipped {
+					outcome = bepb.SongOutcome_SkippedByVote
+					voters = []uint32{users[(i+1)%len(users)].User.UserId, users[(i+2)%len(users)].User.UserId}
+				}
+				if err := tx.SetSongOutcome(song.SongId, outcome, "", voters); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := tx.AddSong(song); err != nil {
+				return err
+			}
+			queue = append(queue, song)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, song := range queue {
+		s.enqueueSong(s.zones.defaultZone, song)
+	}
+
+	log.Printf("Added demo data: {users: %d, history: %d, queued: %d}", len(demoUsers), demoHistory, len(queue))
+	return nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSeedDemo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	now := time.Now()
+	if err = server.seedDemo(now); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := len(demoSongs) - demoHistory
+	if server.queueMgr.Len() != queued {
+		t.Errorf("Expected %d songs queued, got %d", queued, server.queueMgr.Len())
+	}
+
+	history, _ := server.dbManager.GetRecentSongs(len(demoSongs) + 1)
+	if len(history) != len(demoSongs) {
+		t.Errorf("Expected every sample song in the history, got %d", len(history))
+	}
+
+	// seeding again doesn't add a second copy
+	if err = server.seedDemo(now); err != nil || server.queueMgr.Len() != queued {
+		t.Errorf("Expected seeding twice to do nothing, got %d queued and %v", server.queueMgr.Len(), err)
+	}
+
+	if removed, err := server.dbManager.RemoveDemoData(); err != nil || removed != int64(len(demoUsers)) {
+		t.Errorf("Expected the sample users removed, got %d and %v", removed, err)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * Lets an admin hand a zone's decks to a dj for a set. The songs everyone
+ * else queued are set aside and only the dj's songs play, without the auto dj
+ * filling in between them. Everyone else's submissions are turned away, or
+ * held as suggestions the dj can accept into the queue. Handing the decks back
+ * returns the songs set aside to the queue and opens submissions again. The
+ * handoff isn't saved, so restarting the server hands the decks back.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var (
+	ErrDecksTaken = errors.New("A dj already has the decks.")
+	ErrDecksFree  = errors.New("Nobody has the decks.")
+)
+
+/*
+ * Keeps track of the dj who has the decks and the songs set aside for them
+ */
+type deckHandoff struct {
+	taken       bool         // true while a dj has the decks
+	userId      uint32       // id of the dj
+	username    string       // name of the dj
+	zoneId      uint32       // zone the dj has the decks of
+	suggestions bool         // true if everyone else's submissions are held as suggestions
+	since       time.Time    // when the dj took the decks
+	setAside    []*cmpb.Song // songs taken out of the queue until the decks are handed back
+	lock        sync.Mutex   // lock on the handoff
+}
+
+/*
+ * Hand the decks to a dj. Returns an error if another dj has them.
+ */
+func (d *deckHandoff) take(takeover *bepb.DeckTakeover, username string, now time.Time) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.taken {
+		return ErrDecksTaken
+	}
+
+	d.taken = true
+	d.userId = takeover.GetUserId()
+	d.username = username
+	d.zoneId = takeover.GetZoneId()
+	d.suggestions = takeover.GetSuggestions()
+	d.since = now
+	d.setAside = nil
+	return nil
+}
+
+/*
+ * Keep songs out of the queue until the decks are handed back
+ */
+func (d *deckHandoff) putAside(songs []*cmpb.Song) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.setAside = append(d.setAside, songs...)
+}
+
+/*
+ * Take the decks back from the dj. Returns the zone the dj had and the songs
+ * set aside, in the order they were queued.
+ */
+func (d *deckHandoff) handBack() (uint32, []*cmpb.Song, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.taken {
+		return 0, nil, ErrDecksFree
+	}
+
+	songs := d.setAside
+	d.taken = false
+	d.setAside = nil
+	return d.zoneId, songs, nil
+}
+
+/*
+ * Returns whether a submission to the zone by the user is held back because
+ * a dj has the decks, and whether it's held as a suggestion
+ */
+func (d *deckHandoff) holds(zoneId uint32, userId uint32) (bool, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.taken || d.zoneId != zoneId || d.userId == userId {
+		return false, false
+	}
+	return true, d.suggestions
+}
+
+/*
+ * Returns who has the decks, or nil if nobody has them
+ */
+func (d *deckHandoff) status() *bepb.DeckStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.taken {
+		return nil
+	}
+
+	return &bepb.DeckStatus{
+		UserId:      d.userId,
+		Username:    d.username,
+		ZoneId:      d.zoneId,
+		Suggestions: d.suggestions,
+		Since:       d.since.Unix(),
+		SetAside:    uint32(len(d.setAside)),
+	}
+}
+
+/*
+ * Returns the message turning away a submission to the zone by the user
+ * while a dj has the decks, or an empty string if it isn't turned away.
+ * Suggestions are only taken by SendSong, so others get turned away here.
+ */
+func (s *BackendServer) decksMessage(zoneId uint32, userId uint32) string {
+	if held, _ := s.decks.holds(zoneId, userId); held {
+		return "The dj has the decks. Submissions are paused until they hand them back."
+	}
+	return ""
+}
+
+/*
+ * Hands a zone's decks to a dj. The songs others queued are set aside until
+ * the decks are handed back.
+ */
+func (s *BackendServer) TakeDecks(con context.Context, takeover *bepb.DeckTakeover) (*bepb.Error, error) {
+	username, _ := s.getUserFromId(takeover.GetUserId())
+	if username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	zone, exists := s.zones.get(takeover.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if err := s.decks.take(takeover, username, time.Now()); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+	s.suggestions.clear()
+	s.autoDj.hold(zone.id, true)
+
+	// the decks are taken first so nothing queued meanwhile slips past
+	setAside := make([]*cmpb.Song, 0)
+	for _, song := range zone.queueMgr.GetPlaylist().Songs {
+		if song.UserId == takeover.GetUserId() {
+			continue
+		}
+
+		if err := zone.queueMgr.RemoveSong(song.SongId, song.UserId); err != nil {
+			continue
+		}
+		setAside = append(setAside, song)
+		s.bus.publish(&bepb.Event{Type: bepb.EventType_SongRemoved, ZoneId: zone.id,
+			Song: &cmpb.Song{SongId: song.SongId}})
+	}
+	s.decks.putAside(setAside)
+
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_DecksTaken, ZoneId: zone.id, UserId: takeover.GetUserId()})
+	log.Printf("User %d took the decks of zone %d: {set aside: %d, suggestions: %t}", takeover.GetUserId(), zone.id,
+		len(setAside), takeover.GetSuggestions())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Takes the decks back from the dj and returns the songs set aside to the
+ * queue
+ */
+func (s *BackendServer) HandBackDecks(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
+	zoneId, setAside, err := s.decks.handBack()
+	if err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+	s.autoDj.hold(zoneId, false)
+
+	dropped := s.suggestions.clear()
+
+	// the zone may have been removed while the dj had it
+	zone, exists := s.zones.get(zoneId)
+	if !exists {
+		zone = s.zones.defaultZone
+	}
+
+	for _, song := range setAside {
+		s.enqueueSong(zone, song)
+	}
+
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_DecksHandedBack, ZoneId: zone.id})
+	log.Printf("Decks of zone %d handed back: {returned: %d, suggestions dropped: %d}", zone.id, len(setAside),
+		dropped)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the songs suggested to the dj
+ */
+func (s *BackendServer) ListSuggestions(con context.Context, empty *cmpb.Empty) (*bepb.PendingSongList, error) {
+	return &bepb.PendingSongList{
+		Songs: s.suggestions.list(),
+		Err:   &bepb.Error{Success: true, Message: "Success"},
+	}, nil
+}
+
+/*
+ * Queues a song the dj accepted from the suggestions or drops one they
+ * turned away
+ */
+func (s *BackendServer) ReviewSuggestion(con context.Context, review *bepb.SongReview) (*bepb.Error, error) {
+	suggested, err := s.suggestions.take(review.GetId())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
+	if !review.GetApprove() {
+		log.Printf("Turned away suggestion %d: %s", review.GetId(), suggested.song.ServiceId)
+		return &bepb.Error{Success: true, Message: "Success"}, nil
+	}
+
+	zone, exists := s.zones.get(suggested.zoneId)
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if isQueued(zone.queueMgr, suggested.song) {
+		return &bepb.Error{Success: false, Message: "That song is already queued."}, nil
+	}
+
+	if err := s.queueSong(zone, suggested.song); err != nil {
+		return &bepb.Error{Success: false, Message: ErrSongNotRecorded.Error()}, nil
+	}
+	log.Printf("Accepted suggestion %d: { %v}", review.GetId(), suggested.song)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestDecks_playOnlyTheDjsSongsUntilHandedBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_decks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	fetcher := &titleFetcher{title: "Strobe"}
+	server.metadata = fetcher
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	dj, _ := server.dbManager.AddUser("Deadmau5", room.Room.Id)
+	guest, _ := server.dbManager.AddUser("Kahlan", room.Room.Id)
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ",
+		UserId: guest.User.UserId})
+	if !response.Success {
+		t.Fatalf("Expected the guest's song to be queued, got %v", response)
+	}
+
+	response, _ = server.TakeDecks(context.Background(), &bepb.DeckTakeover{UserId: dj.User.UserId, Suggestions: true})
+	if !response.Success || len(server.queueMgr.GetPlaylist().Songs) != 0 {
+		t.Fatalf("Expected the guest's song to be set aside, got %v", response)
+	}
+
+	if response, _ = server.TakeDecks(context.Background(), &bepb.DeckTakeover{UserId: guest.User.UserId}); response.Success {
+		t.Error("Expected only one dj to have the decks at a time")
+	}
+
+	response, _ = server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/tKi9Z-f6qX4",
+		UserId: dj.User.UserId})
+	if !response.Success {
+		t.Fatalf("Expected the dj's song to be queued, got %v", response)
+	}
+
+	fetcher.title = "Gangnam Style"
+	response, _ = server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/9bZkp7q19f0",
+		UserId: guest.User.UserId})
+	suggested := server.suggestions.list()
+	if response.Success || len(suggested) != 1 || len(server.queueMgr.GetPlaylist().Songs) != 1 {
+		t.Fatalf("Expected the guest's song held as a suggestion, got %v and %v", response, suggested)
+	}
+
+	info, _ := server.GetServerInfo(context.Background(), &bepb.User{})
+	if decks := info.Decks; decks == nil || decks.Username != "Deadmau5" || decks.Suggested != 1 || decks.SetAside != 1 {
+		t.Errorf("Expected the server info to show the dj, got %v", decks)
+	}
+
+	response, _ = server.ReviewSuggestion(context.Background(), &bepb.SongReview{Id: suggested[0].Id, Approve: true})
+	if !response.Success || len(server.queueMgr.GetPlaylist().Songs) != 2 {
+		t.Fatalf("Expected the accepted suggestion to be queued, got %v", response)
+	}
+
+	response, _ = server.HandBackDecks(context.Background(), &cmpb.Empty{})
+	playlist := server.queueMgr.GetPlaylist().Songs
+	if !response.Success || len(playlist) != 3 {
+		t.Fatalf("Expected the guest's song back in the queue, got %v and %v", response, playlist)
+	}
+
+	if info, _ = server.GetServerInfo(context.Background(), &bepb.User{}); info.Decks != nil {
+		t.Errorf("Expected nobody to have the decks, got %v", info.Decks)
+	}
+
+	if response, _ = server.HandBackDecks(context.Background(), &cmpb.Empty{}); response.Message != ErrDecksFree.Error() {
+		t.Errorf("Expected the decks to only be handed back once, got %v", response)
+	}
+}
+
+func TestDecks_withoutSuggestions_turnSubmissionsAway(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_decks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	fetcher := &titleFetcher{title: "Strobe"}
+	server.metadata = fetcher
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	dj, _ := server.dbManager.AddUser("Deadmau5", room.Room.Id)
+	guest, _ := server.dbManager.AddUser("Kahlan", room.Room.Id)
+
+	server.TakeDecks(context.Background(), &bepb.DeckTakeover{UserId: dj.User.UserId})
+	if !server.autoDj.holding(defaultZoneId) {
+		t.Error("Expected the auto dj to leave the dj's zone alone")
+	}
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ",
+		UserId: guest.User.UserId})
+	if response.Success || fetcher.calls != 0 || len(server.suggestions.list()) != 0 {
+		t.Errorf("Expected the guest's song to be turned away, got %v", response)
+	}
+
+	server.HandBackDecks(context.Background(), &cmpb.Empty{})
+	if server.autoDj.holding(defaultZoneId) {
+		t.Error("Expected the auto dj to pick songs again")
+	}
+}
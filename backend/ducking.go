@@ -0,0 +1,92 @@
+/*
+ * Ducking lowers the music for a moment, like for an announcement on the
+ * microphone or the doorbell ringing, and brings it back up afterwards. Only
+ * players that said they can change their volume are told to duck.
+ */
+
+package backend
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const defaultDuckVolume = 30 // percent of the normal volume played while ducked
+
+var ErrNoVolumeControl = errors.New("None of the zone's players can change their volume.")
+
+/*
+ * Tell the players that can change their volume to play at a percent of
+ * their normal volume. The volume comes back up after the duration, or once
+ * unduck is called if the duration is zero. Returns the number of players
+ * told.
+ */
+func (mgr *playerManager) duck(volume uint32, duration time.Duration) int {
+	if volume == 0 {
+		volume = defaultDuckVolume
+	}
+
+	mgr.playerLock.Lock()
+	if mgr.duckTimer != nil {
+		mgr.duckTimer.Stop()
+		mgr.duckTimer = nil
+	}
+
+	if duration > 0 {
+		mgr.duckTimer = time.AfterFunc(duration, func() { mgr.unduck() })
+	}
+	mgr.playerLock.Unlock()
+
+	return mgr.sendToVolumePlayers(&bepb.PlayerControl{Command: bepb.CommandType_Duck, DuckVolume: volume})
+}
+
+/*
+ * Tell the players that can change their volume to go back to their normal
+ * volume. Returns the number of players told.
+ */
+func (mgr *playerManager) unduck() int {
+	mgr.playerLock.Lock()
+	if mgr.duckTimer != nil {
+		mgr.duckTimer.Stop()
+		mgr.duckTimer = nil
+	}
+	mgr.playerLock.Unlock()
+
+	return mgr.sendToVolumePlayers(&bepb.PlayerControl{Command: bepb.CommandType_Unduck})
+}
+
+/*
+ * Record whether a player can change its volume, which it says when it's
+ * ready for a song
+ */
+func (mgr *playerManager) updateVolumeSupport(id int, status *bepb.PlayerStatus) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	if state, exists := mgr.streams[id]; exists && status.GetSupportsVolume() {
+		state.supportsVolume = true
+	}
+}
+
+/*
+ * Send a command to the players that can change their volume. Returns the
+ * number of players it was sent to.
+ */
+func (mgr *playerManager) sendToVolumePlayers(control *bepb.PlayerControl) int {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	sent := 0
+	for _, state := range mgr.streams {
+		if state.supportsVolume {
+			go sendToStream(control, state.out)
+			sent++
+		}
+	}
+
+	log.Printf("Sent %v to %d players in zone %d", control.GetCommand(), sent, mgr.zoneId)
+	return sent
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+/*
+ * Player stream that hands the controls sent to it to the test
+ */
+type controlRecorder struct {
+	bepb.YtbBePlayer_SongPlayerServer
+	controls chan *bepb.PlayerControl
+}
+
+func (r *controlRecorder) Send(control *bepb.PlayerControl) error {
+	r.controls <- control
+	return nil
+}
+
+func (r *controlRecorder) next(t *testing.T) *bepb.PlayerControl {
+	select {
+	case control := <-r.controls:
+		return control
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a control to be sent to the player")
+		return nil
+	}
+}
+
+func TestDuck_onlyDucksPlayersWithVolume(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	speaker := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	speakerId := playerMgr.add(speaker, cancel)
+	playerMgr.add(&controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}, cancel)
+
+	if told := playerMgr.duck(0, 0); told != 0 {
+		t.Fatalf("Expected no players to be ducked before they report volume support, but got %d", told)
+	}
+
+	playerMgr.updateVolumeSupport(speakerId, &bepb.PlayerStatus{Command: bepb.CommandType_Ready, SupportsVolume: true})
+	if told := playerMgr.duck(0, 0); told != 1 {
+		t.Fatalf("Expected one player to be ducked, but got %d", told)
+	}
+
+	if control := speaker.next(t); control.Command != bepb.CommandType_Duck || control.DuckVolume != defaultDuckVolume {
+		t.Errorf("Expected a duck to %d%%, but got %v", defaultDuckVolume, control)
+	}
+
+	if told := playerMgr.unduck(); told != 1 || speaker.next(t).Command != bepb.CommandType_Unduck {
+		t.Errorf("Expected the player to be unducked")
+	}
+}
+
+func TestDuck_forDuration_unducksOnItsOwn(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	speaker := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	id := playerMgr.add(speaker, cancel)
+	playerMgr.updateVolumeSupport(id, &bepb.PlayerStatus{SupportsVolume: true})
+
+	playerMgr.duck(50, 10*time.Millisecond)
+	if control := speaker.next(t); control.DuckVolume != 50 {
+		t.Errorf("Expected a duck to 50%%, but got %v", control)
+	}
+
+	if control := speaker.next(t); control.Command != bepb.CommandType_Unduck {
+		t.Errorf("Expected the volume to come back up after the duration, but got %v", control)
+	}
+}
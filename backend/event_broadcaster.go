@@ -0,0 +1,109 @@
+/*
+ * Fans out server events, such as songs being queued or reacted to, to the
+ * clients streaming them. The broadcaster subscribes to every event on the
+ * server's event bus. Events are never allowed to hold up the server, so
+ * a subscriber that falls behind misses events instead. Every event is given
+ * the next sequence number and the latest ones are kept, so a client that
+ * reconnects can resume after the last event it got instead of fetching the
+ * whole queue again.
+ */
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	eventBufferSize = 32  // events buffered for each subscriber
+	eventReplaySize = 256 // latest events kept for subscribers resuming
+)
+
+/*
+ * Broadcasts events to every subscriber
+ */
+type eventBroadcaster struct {
+	subscribers map[chan *bepb.Event]bool // channels of the subscribers
+	sequence    uint64                    // sequence of the last event published
+	replay      []*bepb.Event             // the latest events, oldest first
+	lock        sync.Mutex                // lock on the subscribers and the latest events
+}
+
+/*
+ * Initialize the event broadcaster. Sequences start from the clock, so a
+ * subscriber resuming from before a restart is always behind.
+ */
+func (b *eventBroadcaster) init() {
+	b.subscribers = make(map[chan *bepb.Event]bool)
+	b.sequence = uint64(time.Now().UnixNano())
+}
+
+/*
+ * Subscribe to the events. The returned channel must be unsubscribed when the
+ * subscriber is done with it.
+ */
+func (b *eventBroadcaster) subscribe() chan *bepb.Event {
+	events, _ := b.resume(0)
+	return events
+}
+
+/*
+ * Subscribe to the events after the one with the given sequence. Returns the
+ * channel along with the events the subscriber missed, which must be sent
+ * before the ones on the channel. If the missed events are no longer kept,
+ * the subscriber gets an EventsMissed event in their place. A zero sequence
+ * only subscribes to new events.
+ */
+func (b *eventBroadcaster) resume(after uint64) (chan *bepb.Event, []*bepb.Event) {
+	events := make(chan *bepb.Event, eventBufferSize)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subscribers[events] = true
+	if after == 0 || after == b.sequence {
+		return events, nil
+	}
+
+	if after > b.sequence || len(b.replay) == 0 || b.replay[0].Sequence > after+1 {
+		return events, []*bepb.Event{{Type: bepb.EventType_EventsMissed, Sequence: b.sequence}}
+	}
+
+	missed := b.replay[len(b.replay)-int(b.sequence-after):]
+	return events, append([]*bepb.Event(nil), missed...)
+}
+
+/*
+ * Stop sending events to a subscriber
+ */
+func (b *eventBroadcaster) unsubscribe(events chan *bepb.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.subscribers, events)
+}
+
+/*
+ * Give an event the next sequence and send it to every subscriber.
+ * Subscribers with a full buffer miss the event.
+ */
+func (b *eventBroadcaster) publish(event *bepb.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sequence++
+	event.Sequence = b.sequence
+	if b.replay = append(b.replay, event); len(b.replay) > eventReplaySize {
+		b.replay = b.replay[len(b.replay)-eventReplaySize:]
+	}
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
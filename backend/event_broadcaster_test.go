@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func setupEventBroadcaster() *eventBroadcaster {
+	broadcaster := new(eventBroadcaster)
+	broadcaster.init()
+	return broadcaster
+}
+
+func TestEventBroadcasterPublish_whenSubscriberBehind_dropsEvents(t *testing.T) {
+	broadcaster := setupEventBroadcaster()
+	events := broadcaster.subscribe()
+
+	finishesInTime(t, "publish", func() {
+		for i := 0; i < eventBufferSize*2; i++ {
+			broadcaster.publish(&bepb.Event{Type: bepb.EventType_SongPlaying})
+		}
+	})
+
+	if len(events) != eventBufferSize {
+		t.Errorf("Expected %d buffered events, but got %d", eventBufferSize, len(events))
+	}
+
+	broadcaster.unsubscribe(events)
+	broadcaster.publish(&bepb.Event{Type: bepb.EventType_SongPlaying})
+	if len(events) != eventBufferSize {
+		t.Errorf("Unsubscribed channel should not get events")
+	}
+}
+
+func TestEventBroadcasterResume_sendsMissedEvents(t *testing.T) {
+	broadcaster := setupEventBroadcaster()
+	for i := 0; i < 3; i++ {
+		broadcaster.publish(&bepb.Event{Type: bepb.EventType_SongQueued, ZoneId: uint32(i)})
+	}
+
+	first := broadcaster.replay[0].Sequence
+	if second := broadcaster.replay[1].Sequence; second != first+1 {
+		t.Fatalf("Expected each event to get the next sequence, got %d and %d", first, second)
+	}
+
+	events, missed := broadcaster.resume(first)
+	defer broadcaster.unsubscribe(events)
+	if len(missed) != 2 || missed[0].ZoneId != 1 || missed[1].ZoneId != 2 {
+		t.Fatalf("Expected the two events after the first, got %v", missed)
+	}
+
+	broadcaster.publish(&bepb.Event{Type: bepb.EventType_SongPlaying})
+	if event := <-events; event.Sequence != first+3 {
+		t.Errorf("Expected new events to follow the missed ones, got %v", event)
+	}
+
+	caughtUp, missed := broadcaster.resume(first + 3)
+	defer broadcaster.unsubscribe(caughtUp)
+	if len(missed) != 0 {
+		t.Errorf("Expected nothing missed by a subscriber that's caught up, got %v", missed)
+	}
+}
+
+func TestEventBroadcasterResume_whenEventsDropped_sendsEventsMissed(t *testing.T) {
+	broadcaster := setupEventBroadcaster()
+	for i := 0; i < eventReplaySize+1; i++ {
+		broadcaster.publish(&bepb.Event{Type: bepb.EventType_SongQueued})
+	}
+
+	if len(broadcaster.replay) != eventReplaySize {
+		t.Errorf("Expected %d events kept, but got %d", eventReplaySize, len(broadcaster.replay))
+	}
+
+	oldest := broadcaster.replay[0].Sequence
+	for _, after := range []uint64{oldest - 2, broadcaster.sequence + 1} {
+		events, missed := broadcaster.resume(after)
+		broadcaster.unsubscribe(events)
+		if len(missed) != 1 || missed[0].Type != bepb.EventType_EventsMissed ||
+			missed[0].Sequence != broadcaster.sequence {
+			t.Errorf("Expected resuming after %d to miss events, got %v", after, missed)
+		}
+	}
+
+	events, missed := broadcaster.resume(oldest - 1)
+	broadcaster.unsubscribe(events)
+	if len(missed) != eventReplaySize || missed[0].Sequence != oldest {
+		t.Errorf("Expected every kept event to be sent, got %d", len(missed))
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Internal pub/sub of what the server does. The parts of the backend that act
+ * on a song being queued, played or skipped, like the database writer, the
+ * lyrics finder, the event streams and the embedding program's hooks,
+ * subscribe to the bus instead of being called one by one by the code doing
+ * the work. New listeners, such as plugins, only need to subscribe.
+ */
+
+package backend
+
+import (
+	"sync"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+/*
+ * Handles an event published on the bus. Handlers run in the goroutine that
+ * published the event, so they should return quickly and hand slow work off
+ * to a goroutine of their own.
+ */
+type eventHandler func(event *bepb.Event)
+
+/*
+ * A handler and the types of events it's subscribed to
+ */
+type subscription struct {
+	types   map[bepb.EventType]bool // types of events handled. Empty handles every type
+	handler eventHandler            // called with each event
+}
+
+/*
+ * Passes published events to the handlers subscribed to them
+ */
+type eventBus struct {
+	subscriptions []*subscription // handlers in the order they subscribed
+	lock          sync.RWMutex    // lock on the subscriptions
+}
+
+/*
+ * Initialize the event bus
+ */
+func (b *eventBus) init() {
+	b.subscriptions = nil
+}
+
+/*
+ * Subscribe a handler to the given types of events, or to every event if no
+ * types are given
+ */
+func (b *eventBus) subscribe(handler eventHandler, types ...bepb.EventType) {
+	sub := &subscription{types: make(map[bepb.EventType]bool, len(types)), handler: handler}
+	for _, eventType := range types {
+		sub.types[eventType] = true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+/*
+ * Pass an event to its handlers in the order they subscribed. Handlers may
+ * publish events of their own. Publishing on a nil bus does nothing, so parts
+ * built without one in tests still work.
+ */
+func (b *eventBus) publish(event *bepb.Event) {
+	if b == nil {
+		return
+	}
+
+	b.lock.RLock()
+	subscriptions := b.subscriptions
+	b.lock.RUnlock()
+
+	for _, sub := range subscriptions {
+		if len(sub.types) == 0 || sub.types[event.Type] {
+			sub.handler(event)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func setupEventBus() *eventBus {
+	bus := new(eventBus)
+	bus.init()
+	return bus
+}
+
+func TestEventBusPublish_callsSubscribedHandlersInOrder(t *testing.T) {
+	bus := setupEventBus()
+
+	var calls []string
+	bus.subscribe(func(event *bepb.Event) { calls = append(calls, "skips") }, bepb.EventType_SongSkipped)
+	bus.subscribe(func(event *bepb.Event) { calls = append(calls, "all") })
+	bus.subscribe(func(event *bepb.Event) { calls = append(calls, "queued") }, bepb.EventType_SongQueued,
+		bepb.EventType_SongRemoved)
+
+	bus.publish(&bepb.Event{Type: bepb.EventType_SongQueued})
+	bus.publish(&bepb.Event{Type: bepb.EventType_SongSkipped})
+
+	expected := []string{"all", "queued", "skips", "all"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected handlers %v to be called, but got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Expected handlers %v to be called, but got %v", expected, calls)
+			break
+		}
+	}
+}
+
+func TestEventBusPublish_whenHandlerPublishes_doesNotDeadlock(t *testing.T) {
+	bus := setupEventBus()
+
+	played := false
+	bus.subscribe(func(event *bepb.Event) {
+		bus.publish(&bepb.Event{Type: bepb.EventType_SongPlaying})
+	}, bepb.EventType_SongSkipped)
+	bus.subscribe(func(event *bepb.Event) { played = true }, bepb.EventType_SongPlaying)
+
+	finishesInTime(t, "publish", func() {
+		bus.publish(&bepb.Event{Type: bepb.EventType_SongSkipped})
+	})
+
+	if !played {
+		t.Errorf("Expected the event published by a handler to be handled")
+	}
+
+	var nilBus *eventBus
+	nilBus.publish(&bepb.Event{Type: bepb.EventType_SongQueued})
+}
+
+func TestHooksSubscribe_callsUserHooks(t *testing.T) {
+	bus := setupEventBus()
+	broadcaster := setupEventBroadcaster()
+	bus.subscribe(broadcaster.publish)
+	events := broadcaster.subscribe()
+	defer broadcaster.unsubscribe(events)
+
+	var queued *cmpb.Song
+	var leftZone uint32
+	var leftPlayer int
+	hooks := &Hooks{
+		OnSongQueued: func(zoneId uint32, song *cmpb.Song) { queued = song },
+		OnPlayerLeft: func(zoneId uint32, playerId int) { leftZone, leftPlayer = zoneId, playerId },
+	}
+	hooks.subscribe(bus)
+
+	song := &cmpb.Song{SongId: 1}
+	bus.publish(&bepb.Event{Type: bepb.EventType_SongQueued, ZoneId: 2, Song: song})
+	bus.publish(&bepb.Event{Type: bepb.EventType_PlayerLeft, ZoneId: 2, PlayerId: 3})
+	bus.publish(&bepb.Event{Type: bepb.EventType_SongSkipped, Song: song})
+
+	if queued != song || leftZone != 2 || leftPlayer != 3 {
+		t.Errorf("The embedding program's hooks should be called")
+	}
+
+	if event := <-events; event.Type != bepb.EventType_SongQueued || event.ZoneId != 2 || event.Song != song {
+		t.Errorf("Unexpected song queued event: %v", event)
+	}
+
+	var noHooks *Hooks
+	noHooks.subscribe(bus)
+}
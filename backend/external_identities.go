@@ -0,0 +1,105 @@
+/*
+ * Lets accounts on other services, like Discord users, be linked to a user.
+ * A bot submitting songs for the people in its chat looks up the user each
+ * account is linked to, so songs sent through the bot are credited to the
+ * same user as the ones sent from the web ui. An account links to one user,
+ * but a user can have accounts on any number of services.
+ */
+
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+var ErrIdentityNotLinked = errors.New("That account isn't linked to a user.")
+
+/*
+ * Returns an identity the way it's stored, with the provider trimmed and in
+ * lower case and the external id trimmed
+ */
+func normalizeIdentity(identity *bepb.ExternalIdentity) *bepb.ExternalIdentity {
+	return &bepb.ExternalIdentity{
+		Provider:   strings.ToLower(strings.TrimSpace(identity.GetProvider())),
+		ExternalId: strings.TrimSpace(identity.GetExternalId()),
+		UserId:     identity.GetUserId(),
+	}
+}
+
+/*
+ * Links an account on another service to a user, moving it off of any user
+ * it was linked to before
+ */
+func (s *BackendServer) LinkIdentity(con context.Context, identity *bepb.ExternalIdentity) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(identity.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	linked := normalizeIdentity(identity)
+	previous, err := s.dbFor(con).GetIdentity(linked.Provider, linked.ExternalId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return &bepb.Error{Success: false, Message: "Failed to link the account."}, nil
+	}
+
+	if err = s.dbFor(con).LinkIdentity(linked); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to link the account."}, nil
+	}
+
+	if previous != nil && previous.UserId != linked.UserId {
+		log.Printf("Moved %s account %s from user %d to user %d", linked.Provider, linked.ExternalId,
+			previous.UserId, linked.UserId)
+	} else {
+		log.Printf("Linked %s account %s to user %d", linked.Provider, linked.ExternalId, linked.UserId)
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Removes the link between an account on another service and its user
+ */
+func (s *BackendServer) UnlinkIdentity(con context.Context, identity *bepb.ExternalIdentity) (*bepb.Error, error) {
+	linked := normalizeIdentity(identity)
+	removed, err := s.dbFor(con).UnlinkIdentity(linked.Provider, linked.ExternalId)
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to unlink the account."}, nil
+	} else if !removed {
+		return &bepb.Error{Success: false, Message: ErrIdentityNotLinked.Error()}, nil
+	}
+
+	log.Printf("Unlinked %s account %s", linked.Provider, linked.ExternalId)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Returns the user an account on another service is linked to
+ */
+func (s *BackendServer) ResolveIdentity(con context.Context, identity *bepb.ExternalIdentity) (*bepb.User, error) {
+	response := &bepb.User{Err: &bepb.Error{Success: false}}
+
+	search := normalizeIdentity(identity)
+	linked, err := s.dbFor(con).GetIdentity(search.Provider, search.ExternalId)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.Err.Message = ErrIdentityNotLinked.Error()
+		return response, nil
+	} else if err != nil {
+		response.Err.Message = "Failed to look up the account."
+		return response, nil
+	}
+
+	response.Username, response.RoomId = s.getUserFromId(linked.UserId)
+	if response.Username == "" {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
+
+	response.UserId = linked.UserId
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestResolveIdentity_findsTheLinkedUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_identities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	alice, _ := server.dbManager.AddUser("Alice", room.Room.Id)
+	discord := &bepb.ExternalIdentity{Provider: "discord", ExternalId: "80351110224678912"}
+
+	if user, _ := server.ResolveIdentity(context.Background(), discord); user.Err.Success ||
+		user.Err.Message != ErrIdentityNotLinked.Error() {
+		t.Errorf("Expected an account that isn't linked to be turned away, got %v", user)
+	}
+
+	response, _ := server.LinkIdentity(context.Background(), &bepb.ExternalIdentity{Provider: " Discord ",
+		ExternalId: discord.ExternalId, UserId: bob.User.UserId})
+	if !response.Success {
+		t.Fatalf("Expected the account to be linked to Bob, got %v", response)
+	}
+
+	if user, _ := server.ResolveIdentity(context.Background(), discord); !user.Err.Success ||
+		user.UserId != bob.User.UserId || user.Username != "Bob" || user.RoomId != room.Room.Id {
+		t.Errorf("Expected the account to resolve to Bob, got %v", user)
+	}
+
+	// linking it again moves it to the new user
+	server.LinkIdentity(context.Background(), &bepb.ExternalIdentity{Provider: "discord",
+		ExternalId: discord.ExternalId, UserId: alice.User.UserId})
+	if user, _ := server.ResolveIdentity(context.Background(), discord); user.UserId != alice.User.UserId {
+		t.Errorf("Expected the account to move to Alice, got %v", user)
+	}
+
+	profile, _ := server.WhoAmI(context.Background(), &bepb.User{UserId: alice.User.UserId})
+	if len(profile.Identities) != 1 || profile.Identities[0].Provider != "discord" {
+		t.Errorf("Expected Alice's profile to list the account, got %v", profile)
+	}
+
+	if profile, _ = server.WhoAmI(context.Background(), &bepb.User{UserId: bob.User.UserId}); len(profile.Identities) != 0 {
+		t.Errorf("Expected Bob to have no linked accounts, got %v", profile.Identities)
+	}
+
+	if response, _ = server.UnlinkIdentity(context.Background(), discord); !response.Success {
+		t.Errorf("Expected the account to be unlinked, got %v", response)
+	}
+
+	if response, _ = server.UnlinkIdentity(context.Background(), discord); response.Success {
+		t.Errorf("Expected unlinking it again to fail")
+	}
+}
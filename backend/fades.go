@@ -0,0 +1,81 @@
+/*
+ * Fades songs out as they end and the next ones in as they start, so players
+ * get smooth transitions without timing them on their own. The fade out is
+ * sent once a song's estimated position comes within the crossfade of its
+ * end, and the fade in follows every command that starts a song. Only players
+ * that said they can change their volume are told to fade.
+ */
+
+package backend
+
+import (
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+/*
+ * Tell the players that can change their volume to fade out if the now
+ * playing song is within the crossfade of its end and they weren't told to
+ * already. Assumes the caller holds the player lock.
+ */
+func (mgr *playerManager) fadeOut(now time.Time) {
+	report := mgr.position
+	if mgr.crossfade <= 0 || report == nil || report.paused || report.songId == mgr.fadedOut {
+		return
+	}
+
+	song := mgr.queueMgr.NowPlaying()
+	if song == nil || song.SongId != report.songId {
+		return
+	}
+
+	// songs too short to fade both ways play as they are
+	duration := songSeconds(song)
+	left := duration - report.elapsedAt(now)
+	if duration < 2*mgr.crossfade.Seconds() || left <= 0 || left > mgr.crossfade.Seconds() {
+		return
+	}
+
+	mgr.fadedOut = song.SongId
+	control := &bepb.PlayerControl{Command: bepb.CommandType_FadeOut, FadeSeconds: left}
+	for _, state := range mgr.streams {
+		if state.supportsVolume {
+			go sendToStream(control, state.out)
+		}
+	}
+}
+
+/*
+ * Returns the command fading in the song a command starts on a player, or nil
+ * if the command doesn't start a song or the player doesn't fade
+ */
+func (mgr *playerManager) fadeIn(control *bepb.PlayerControl, state *playerState) *bepb.PlayerControl {
+	if mgr.crossfade <= 0 || !state.supportsVolume || control.GetSong().GetSongId() == 0 {
+		return nil
+	}
+
+	switch control.GetCommand() {
+	case bepb.CommandType_Play, bepb.CommandType_Next, bepb.CommandType_Previous:
+		return &bepb.PlayerControl{Command: bepb.CommandType_FadeIn, FadeSeconds: mgr.crossfade.Seconds()}
+	}
+	return nil
+}
+
+/*
+ * Send a command to a player followed by the fade in of the song it starts,
+ * if any. They're sent from the same goroutine so the player gets them in
+ * order. Assumes the caller holds the player lock.
+ */
+func (mgr *playerManager) sendFading(control *bepb.PlayerControl, state *playerState) {
+	fadeIn := mgr.fadeIn(control, state)
+	if fadeIn == nil {
+		go sendToStream(control, state.out)
+		return
+	}
+
+	go func() {
+		sendToStream(control, state.out)
+		sendToStream(fadeIn, state.out)
+	}()
+}
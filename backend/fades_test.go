@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestFadeOut_whenSongEnding_fadesOnce(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.crossfade = 8 * time.Second
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	speaker := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	id := playerMgr.add(speaker, cancel)
+	playerMgr.updateVolumeSupport(id, &bepb.PlayerStatus{SupportsVolume: true})
+	playerMgr.queueMgr.SetNowPlaying(&cmpb.Song{SongId: 7, Metadata: &cmpb.Metadata{Duration: "PT3M"}})
+
+	now := time.Now()
+	playerMgr.position = &reportedPosition{songId: 7, elapsed: 160, reportedAt: now}
+	playerMgr.fadeOut(now)
+	if playerMgr.fadedOut != 0 {
+		t.Errorf("Expected no fade before the final %v of the song", playerMgr.crossfade)
+	}
+
+	playerMgr.position = &reportedPosition{songId: 7, elapsed: 174, reportedAt: now}
+	playerMgr.fadeOut(now)
+	control := speaker.next(t)
+	if control.Command != bepb.CommandType_FadeOut || control.FadeSeconds != 6 {
+		t.Fatalf("Expected a fade out over the last 6 seconds, but got %v", control)
+	}
+
+	playerMgr.fadeOut(now.Add(time.Second))
+	select {
+	case control := <-speaker.controls:
+		t.Errorf("Expected the song to fade out only once, but got %v", control)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSendFading_whenSongStarts_fadesInAfterIt(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.crossfade = 5 * time.Second
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	speaker := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	id := playerMgr.add(speaker, cancel)
+	playerMgr.updateVolumeSupport(id, &bepb.PlayerStatus{SupportsVolume: true})
+	state := playerMgr.streams[id]
+
+	play := &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: &cmpb.Song{SongId: 3}}
+	playerMgr.sendFading(play, state)
+	if control := speaker.next(t); control != play {
+		t.Fatalf("Expected the song to be sent first, but got %v", control)
+	}
+
+	if control := speaker.next(t); control.Command != bepb.CommandType_FadeIn || control.FadeSeconds != 5 {
+		t.Errorf("Expected a fade in over 5 seconds, but got %v", control)
+	}
+
+	if playerMgr.fadeIn(&bepb.PlayerControl{Command: bepb.CommandType_Pause}, state) != nil {
+		t.Errorf("Expected no fade in for commands that don't start a song")
+	}
+
+	state.supportsVolume = false
+	if playerMgr.fadeIn(play, state) != nil {
+		t.Errorf("Expected no fade in for players that can't change their volume")
+	}
+}
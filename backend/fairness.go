@@ -0,0 +1,161 @@
+/*
+ * Fairness reports show at a glance whether the queue is treating its users
+ * fairly: the songs each user has waiting, how long their songs played this
+ * session and where they are in the rotation. The time played is measured as
+ * songs play, so skipped songs only count for the part that played. Jingles,
+ * the auto dj's picks and scheduled songs aren't anyone's turn, so they aren't
+ * counted. Neither are anonymous songs, which would give their submitters
+ * away.
+ */
+
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * A song playing in a zone and when it started
+ */
+type playingSong struct {
+	userId uint32        // user who submitted the song
+	length time.Duration // length of the song. Zero if it isn't known
+	since  time.Time     // when the song started playing
+}
+
+/*
+ * Measures how long each user's songs played in each zone
+ */
+type playTracker struct {
+	played  map[uint32]map[uint32]time.Duration // zone id -> user id -> time the user's songs played
+	current map[uint32]*playingSong             // zone id -> the song playing in the zone
+	lock    sync.Mutex                          // lock on the times played
+}
+
+/*
+ * Initialize the tracker with nothing played
+ */
+func (p *playTracker) init() {
+	p.played = make(map[uint32]map[uint32]time.Duration)
+	p.current = make(map[uint32]*playingSong)
+}
+
+/*
+ * Record a song starting to play, which is published on the bus
+ */
+func (p *playTracker) record(event *bepb.Event) {
+	p.play(event.ZoneId, event.Song, time.Now())
+}
+
+/*
+ * Count the time the zone's last song played and start timing the song
+ * playing now. A nil song stops timing the zone.
+ */
+func (p *playTracker) play(zoneId uint32, song *cmpb.Song, now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if previous, exists := p.current[zoneId]; exists {
+		if p.played[zoneId] == nil {
+			p.played[zoneId] = make(map[uint32]time.Duration)
+		}
+		p.played[zoneId][previous.userId] += previous.elapsed(now)
+		delete(p.current, zoneId)
+	}
+
+	if song == nil || song.Jingle || song.Source == cmpb.SubmissionSource_AutoDj ||
+		song.Source == cmpb.SubmissionSource_Scheduled || song.Anonymous || song.UserId == 0 {
+		return
+	}
+
+	p.current[zoneId] = &playingSong{userId: song.UserId, length: songLength(song), since: now}
+}
+
+/*
+ * Returns how long each user's songs played in a zone, including the song
+ * playing now
+ */
+func (p *playTracker) playedIn(zoneId uint32, now time.Time) map[uint32]time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	played := make(map[uint32]time.Duration, len(p.played[zoneId]))
+	for userId, length := range p.played[zoneId] {
+		played[userId] = length
+	}
+
+	if playing, exists := p.current[zoneId]; exists {
+		played[playing.userId] += playing.elapsed(now)
+	}
+
+	return played
+}
+
+/*
+ * Returns how long the song played, which is no longer than the song if its
+ * length is known
+ */
+func (s *playingSong) elapsed(now time.Time) time.Duration {
+	elapsed := now.Sub(s.since)
+	if s.length > 0 && elapsed > s.length {
+		return s.length
+	}
+
+	return elapsed
+}
+
+/*
+ * Report how fairly a zone's queue is treating its users
+ */
+func (s *BackendServer) fairnessReport(zone *zone, now time.Time) *bepb.FairnessReport {
+	_, songs := zone.queueMgr.Snapshot()
+	round, rounds := zone.queueMgr.Rotation()
+	played := s.plays.playedIn(zone.id, now)
+
+	report := &bepb.FairnessReport{ZoneId: zone.id, Rotates: rounds != nil, Round: int32(round)}
+	users := make(map[uint32]*bepb.UserFairness)
+	user := func(userId uint32, username string) *bepb.UserFairness {
+		fairness, exists := users[userId]
+		if !exists {
+			if username == "" {
+				username, _ = s.getUserFromId(userId)
+			}
+			fairness = &bepb.UserFairness{UserId: userId, Username: username}
+			users[userId] = fairness
+		}
+		return fairness
+	}
+
+	for i, song := range songs {
+		// counting anonymous songs under their submitters would give them away
+		if song.Anonymous {
+			continue
+		}
+
+		fairness := user(song.UserId, song.Username)
+		fairness.Pending++
+		if fairness.NextPosition == 0 {
+			fairness.NextPosition = uint32(i + 1)
+		}
+	}
+
+	for userId, length := range played {
+		user(userId, "").PlayedSeconds = length.Seconds()
+	}
+
+	for userId, userRound := range rounds {
+		user(userId, "").Round = int32(userRound)
+	}
+
+	for _, fairness := range users {
+		report.Users = append(report.Users, fairness)
+	}
+
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].UserId < report.Users[j].UserId })
+	return report
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestPlayTracker_countsTimePlayed(t *testing.T) {
+	tracker := new(playTracker)
+	tracker.init()
+
+	start := time.Now()
+	tracker.play(1, queuedSong(1, 7, "PT3M"), start)
+
+	// skipped after a minute
+	tracker.play(1, &cmpb.Song{SongId: 2, Jingle: true}, start.Add(time.Minute))
+	tracker.play(1, queuedSong(3, 7, "PT2M"), start.Add(90*time.Second))
+
+	// the song stops counting once it's over
+	played := tracker.playedIn(1, start.Add(time.Hour))
+	if played[7] != 3*time.Minute {
+		t.Errorf("Expected three minutes played, got %v", played[7])
+	}
+
+	if played = tracker.playedIn(2, start.Add(time.Hour)); len(played) != 0 {
+		t.Errorf("Expected nothing played in another zone, got %v", played)
+	}
+}
+
+func TestFairnessReport_andMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_fairness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	now := time.Now()
+	server.plays.play(defaultZoneId, &cmpb.Song{SongId: 1, UserId: 2, Username: "Alice"}, now.Add(-time.Minute))
+
+	for id, userId := range []uint32{1, 1, 2} {
+		song := queuedSong(uint32(id+2), userId, "PT3M")
+		song.Username = map[uint32]string{1: "Bob", 2: "Alice"}[userId]
+		server.queueMgr.AddSong(song)
+	}
+
+	report := server.fairnessReport(server.zones.defaultZone, now)
+	if !report.Rotates || len(report.Users) != 2 {
+		t.Fatalf("Expected two users in the rotation, got %v", report)
+	}
+
+	bob, alice := report.Users[0], report.Users[1]
+	if bob.Pending != 2 || bob.NextPosition != 1 || bob.Round != 1 || bob.PlayedSeconds != 0 {
+		t.Errorf("Expected Bob to have two songs starting first, got %v", bob)
+	}
+
+	if alice.Pending != 1 || alice.NextPosition != 2 || alice.PlayedSeconds != 60 {
+		t.Errorf("Expected Alice to have played a minute with one song second, got %v", alice)
+	}
+
+	var metrics bytes.Buffer
+	server.writeMetrics(&metrics, now)
+	for _, line := range []string{
+		"# TYPE ytbox_user_played_seconds_total counter",
+		`ytbox_rotation_round{zone="default"} 0`,
+		`ytbox_user_pending_songs{zone="default",user_id="1",username="Bob"} 2`,
+		`ytbox_user_played_seconds_total{zone="default",user_id="2",username="Alice"} 60`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("Expected the metrics to have %q, got:\n%s", line, metrics.String())
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if escaped := escapeLabel("DJ \"Bob\"\\\n"); escaped != `DJ \"Bob\"\\\n` {
+		t.Errorf("Expected the label to be escaped, got %s", escaped)
+	}
+}
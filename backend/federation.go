@@ -0,0 +1,400 @@
+/*
+ * Experimental federation between backends running in different rooms of the
+ * same house. A follower links to a leader with a handshake and then forwards
+ * the songs submitted to its default zone into the leader's queue. A mirroring
+ * follower also plays whatever the leader is playing.
+ *
+ * Conflict rules:
+ *   - a backend can't follow itself and a follower can't be followed, so
+ *     links never form chains or loops
+ *   - both backends must speak the same federation version
+ *   - the leader only takes songs from backends that completed a handshake
+ *   - the leader refuses songs that are already playing or queued
+ *   - a follower queues songs locally while the leader can't be reached
+ *   - a mirroring follower's auto dj is turned off so the rooms don't fight
+ *     over what plays
+ */
+
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	"github.com/nguyenmq/ytbox-go/common"
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	federationVersion    uint32 = 1                // version of the federation protocol
+	federationRetry             = 30 * time.Second // time between handshake attempts
+	federationPoll              = 5 * time.Second  // how often a mirror checks the leader's now playing
+	federationTimeout           = 10 * time.Second // deadline on rpcs to the leader
+	federationRoomPrefix        = "federation:"    // prefix of the rooms holding a follower's users
+)
+
+var (
+	ErrFederationName     = errors.New("Federated backends need a name different from this one.")
+	ErrFederationMode     = errors.New("Unknown federation mode.")
+	ErrFederationVersion  = errors.New("Federation version does not match.")
+	ErrFederationChain    = errors.New("This backend follows another backend and can't be followed.")
+	ErrFederationUnlinked = errors.New("Backend has not completed a federation handshake.")
+)
+
+/*
+ * A follower that completed a handshake
+ */
+type federatedPeer struct {
+	name   string              // name of the follower
+	mode   bepb.FederationMode // how the follower follows this backend
+	roomId uint32              // room holding the users of the follower
+}
+
+/*
+ * Accepts links from backends that follow this one
+ */
+type federationHub struct {
+	name      string                    // name of this backend
+	following bool                      // true if this backend follows another one
+	dbManager db.DbManager              // database to add the followers' users to
+	peers     map[string]*federatedPeer // followers by name
+	lock      sync.Mutex                // lock on the followers
+}
+
+/*
+ * Initialize the federation hub
+ */
+func (hub *federationHub) init(name string, dbManager db.DbManager, following bool) {
+	hub.name = name
+	hub.following = following
+	hub.dbManager = dbManager
+	hub.peers = make(map[string]*federatedPeer)
+}
+
+/*
+ * Accept a handshake from a backend that wants to follow this one. A backend
+ * shaking hands again replaces its previous link.
+ */
+func (hub *federationHub) accept(hello *bepb.FederationHello) error {
+	if err := checkHandshake(hello, hub.name, hub.following); err != nil {
+		return err
+	}
+
+	roomName := federationRoomPrefix + hello.Name
+	roomData, err := hub.dbManager.GetRoomByName(roomName)
+	if errors.Is(err, sql.ErrNoRows) {
+		roomData, err = hub.dbManager.AddRoom(roomName)
+	}
+	if err != nil {
+		return err
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	hub.peers[hello.Name] = &federatedPeer{
+		name:   hello.Name,
+		mode:   hello.Mode,
+		roomId: roomData.Room.Id,
+	}
+
+	log.Printf("Federated with backend: {name: %s, mode: %v}", hello.Name, hello.Mode)
+	return nil
+}
+
+/*
+ * Fill in the local user and room of a song forwarded by a follower. Users of
+ * a follower are kept in a room named after it, so they take their own turns
+ * in the round robin.
+ */
+func (hub *federationHub) resolve(origin string, song *cmpb.Song) error {
+	hub.lock.Lock()
+	peer, exists := hub.peers[origin]
+	hub.lock.Unlock()
+
+	if !exists {
+		return ErrFederationUnlinked
+	}
+
+	userData, err := hub.peerUser(song.Username, peer.roomId)
+	if err != nil {
+		return err
+	}
+
+	if song.ForUsername != "" {
+		recipient, err := hub.peerUser(song.ForUsername, peer.roomId)
+		if err != nil {
+			return err
+		}
+		song.ForUserId = recipient.User.UserId
+	}
+
+	song.SongId = 0
+	song.UserId = userData.User.UserId
+	song.RoomId = peer.roomId
+	return nil
+}
+
+/*
+ * Find a follower's user in the follower's room, adding them if needed
+ */
+func (hub *federationHub) peerUser(username string, roomId uint32) (*db.UserData, error) {
+	userData, err := hub.dbManager.GetUserByName(username, roomId)
+	if errors.Is(err, sql.ErrNoRows) {
+		userData, err = hub.dbManager.AddUser(username, roomId)
+	}
+
+	return userData, err
+}
+
+/*
+ * Check a handshake against the conflict rules
+ */
+func checkHandshake(hello *bepb.FederationHello, name string, following bool) error {
+	switch {
+	case hello.GetVersion() != federationVersion:
+		return ErrFederationVersion
+	case hello.GetName() == "" || hello.GetName() == name:
+		return ErrFederationName
+	case hello.GetMode() != bepb.FederationMode_ForwardQueue && hello.GetMode() != bepb.FederationMode_MirrorNowPlaying:
+		return ErrFederationMode
+	case following:
+		return ErrFederationChain
+	}
+
+	return nil
+}
+
+/*
+ * Returns true if the song is playing or waiting in the queue
+ */
+func isQueued(queueMgr *queuer.SongQueueManager, song *cmpb.Song) bool {
+	if playing := queueMgr.NowPlaying(); playing != nil && sameSong(playing, song) {
+		return true
+	}
+
+	for _, queued := range queueMgr.GetPlaylist().Songs {
+		if sameSong(queued, song) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Link from this backend to the leader it follows
+ */
+type federationLink struct {
+	name    string                // name of this backend
+	addr    string                // address of the leader
+	mode    bepb.FederationMode   // how this backend follows the leader
+	conn    *grpc.ClientConn      // connection to the leader
+	client  bepb.YtbBackendClient // rpc client of the leader
+	leader  string                // name of the leader. Empty until linked
+	playing *cmpb.Song            // last song mirrored from the leader
+	lock    sync.Mutex            // lock on the link state
+	done    chan struct{}         // closed to stop the link
+	wg      sync.WaitGroup        // waits for the link to stop
+	mirror  func(song *cmpb.Song) // plays a song mirrored from the leader
+}
+
+/*
+ * Initialize the link to the leader. It still needs to be started to shake
+ * hands.
+ */
+func (link *federationLink) init(name string, addr string, mode bepb.FederationMode, token string) error {
+	opts := append([]grpc.DialOption{grpc.WithInsecure()}, common.TokenDialOptions(token)...)
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return err
+	}
+
+	link.name = name
+	link.addr = addr
+	link.mode = mode
+	link.conn = conn
+	link.client = bepb.NewYtbBackendClient(conn)
+	link.done = make(chan struct{})
+	return nil
+}
+
+/*
+ * Start shaking hands with the leader and, when mirroring, following what it
+ * plays. The mirror function is called with an empty song when the leader
+ * stops playing.
+ */
+func (link *federationLink) start(mirror func(song *cmpb.Song)) {
+	if link == nil {
+		return
+	}
+
+	link.mirror = mirror
+	link.wg.Add(1)
+	go link.run()
+}
+
+/*
+ * Stop following the leader
+ */
+func (link *federationLink) stop() {
+	if link == nil {
+		return
+	}
+
+	close(link.done)
+	link.wg.Wait()
+	link.conn.Close()
+}
+
+/*
+ * Keep the link to the leader up until stopped
+ */
+func (link *federationLink) run() {
+	defer link.wg.Done()
+
+	ticker := time.NewTicker(federationPoll)
+	defer ticker.Stop()
+
+	var lastAttempt time.Time
+	for {
+		if !link.linked() && time.Since(lastAttempt) >= federationRetry {
+			lastAttempt = time.Now()
+			if err := link.handshake(); err != nil {
+				log.Printf("Federation handshake with %s failed: %v", link.addr, err)
+			}
+		}
+
+		if link.linked() && link.mode == bepb.FederationMode_MirrorNowPlaying {
+			link.followNowPlaying()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-link.done:
+			return
+		}
+	}
+}
+
+/*
+ * Introduce this backend to the leader
+ */
+func (link *federationLink) handshake() error {
+	ctx, cancel := context.WithTimeout(context.Background(), federationTimeout)
+	defer cancel()
+
+	ack, err := link.client.Federate(ctx, &bepb.FederationHello{
+		Name:    link.name,
+		Mode:    link.mode,
+		Version: federationVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !ack.GetErr().GetSuccess() {
+		return errors.New(ack.GetErr().GetMessage())
+	}
+
+	link.lock.Lock()
+	link.leader = ack.Name
+	link.lock.Unlock()
+
+	log.Printf("Following backend: {name: %s, addr: %s, mode: %v}", ack.Name, link.addr, link.mode)
+	return nil
+}
+
+/*
+ * Returns true if the handshake with the leader succeeded
+ */
+func (link *federationLink) linked() bool {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+	return link.leader != ""
+}
+
+/*
+ * Drop the link after the leader stopped answering. The next run shakes
+ * hands again.
+ */
+func (link *federationLink) unlink(err error) {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	if link.leader != "" {
+		log.Printf("Lost federation link to %s: %v", link.leader, err)
+		link.leader = ""
+	}
+}
+
+/*
+ * Play the leader's now playing song if it changed
+ */
+func (link *federationLink) followNowPlaying() {
+	ctx, cancel := context.WithTimeout(context.Background(), federationTimeout)
+	defer cancel()
+
+	song, err := link.client.GetNowPlaying(ctx, &cmpb.Empty{})
+	if err != nil {
+		link.unlink(err)
+		return
+	}
+
+	if link.playing != nil && link.playing.SongId == song.SongId && link.playing.ServiceId == song.ServiceId {
+		return
+	}
+
+	link.playing = song
+	link.mirror(song)
+}
+
+/*
+ * Forward a song to the leader's queue. Returns false if the song couldn't be
+ * forwarded and should be queued locally instead.
+ */
+func (link *federationLink) forward(song *cmpb.Song) (*bepb.Error, bool) {
+	if link == nil || !link.linked() {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), federationTimeout)
+	defer cancel()
+
+	response, err := link.client.ForwardSong(ctx, &bepb.FederatedSong{Origin: link.name, Song: song})
+	if err != nil {
+		link.unlink(err)
+		return nil, false
+	}
+
+	// the leader forgot about this backend, probably after a restart
+	if !response.Success && response.Message == ErrFederationUnlinked.Error() {
+		link.unlink(errors.New(response.Message))
+		return nil, false
+	}
+
+	if response.Success {
+		response.Message = fmt.Sprintf("Queued on %s.", link.leaderName())
+	}
+
+	return response, true
+}
+
+/*
+ * Returns the name of the leader
+ */
+func (link *federationLink) leaderName() string {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+	return link.leader
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"testing"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func federationHello(name string, mode bepb.FederationMode) *bepb.FederationHello {
+	return &bepb.FederationHello{Name: name, Mode: mode, Version: federationVersion}
+}
+
+func TestCheckHandshake_whenValid_accepted(t *testing.T) {
+	for _, mode := range []bepb.FederationMode{bepb.FederationMode_ForwardQueue, bepb.FederationMode_MirrorNowPlaying} {
+		if err := checkHandshake(federationHello("downstairs", mode), "upstairs", false); err != nil {
+			t.Errorf("Expected %v handshake to be accepted, but got %v", mode, err)
+		}
+	}
+}
+
+func TestCheckHandshake_conflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		hello     *bepb.FederationHello
+		following bool
+		expected  error
+	}{
+		{"same name", federationHello("upstairs", bepb.FederationMode_ForwardQueue), false, ErrFederationName},
+		{"no name", federationHello("", bepb.FederationMode_ForwardQueue), false, ErrFederationName},
+		{"no mode", federationHello("downstairs", bepb.FederationMode_NoFederation), false, ErrFederationMode},
+		{"following", federationHello("downstairs", bepb.FederationMode_ForwardQueue), true, ErrFederationChain},
+		{"old version", &bepb.FederationHello{Name: "downstairs", Mode: bepb.FederationMode_ForwardQueue}, false,
+			ErrFederationVersion},
+	}
+
+	for _, test := range tests {
+		if err := checkHandshake(test.hello, "upstairs", test.following); err != test.expected {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestIsQueued_whenPlayingOrQueued_true(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+	queueMgr.AddSong(&cmpb.Song{UserId: 1, Service: cmpb.ServiceType_Youtube, ServiceId: "a"})
+	queueMgr.AddSong(&cmpb.Song{UserId: 2, Service: cmpb.ServiceType_Youtube, ServiceId: "b"})
+	queueMgr.PopQueue()
+
+	for _, serviceId := range []string{"a", "b"} {
+		if !isQueued(queueMgr, &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: serviceId}) {
+			t.Errorf("Expected song %s to be queued", serviceId)
+		}
+	}
+
+	if isQueued(queueMgr, &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "c"}) {
+		t.Errorf("Expected song c to not be queued")
+	}
+}
+
+func TestFederationLink_whenNotLinked_doesNotForward(t *testing.T) {
+	var link *federationLink
+	if _, ok := link.forward(&cmpb.Song{}); ok {
+		t.Errorf("A missing link should not forward songs")
+	}
+
+	link = new(federationLink)
+	if _, ok := link.forward(&cmpb.Song{}); ok {
+		t.Errorf("A link without a handshake should not forward songs")
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ * Guards calls to outside services, such as the YouTube api and yt-dlp, so an
+ * outage slows submissions down by seconds instead of hanging them. Each
+ * attempt is given a deadline, failed attempts are retried with backoff, and a
+ * circuit breaker per service fails calls fast once the service keeps
+ * failing. After a cool down the breaker lets a single call through to probe
+ * whether the service is back.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	defaultFetchTimeout    = 10 * time.Second       // how long each attempt may take
+	defaultBreakerAfter    = 5                      // failures in a row that open a breaker
+	defaultBreakerCooldown = 30 * time.Second       // how long an open breaker fails calls fast
+	fetchBackoff           = 500 * time.Millisecond // wait before the first retry, doubled for each one after
+
+	serviceDownMessage = "The song's service isn't responding right now. Please try again in a few minutes."
+)
+
+var (
+	ErrFetchTimeout       = errors.New("Timed out waiting on the service")
+	ErrServiceUnavailable = errors.New("Service unavailable")
+	ErrUnknownSong        = errors.New("Unknown song")
+)
+
+/*
+ * Returns true if retrying the call wouldn't help because the service
+ * answered, just not with a song that can be queued
+ */
+func isPermanentFetchError(err error) bool {
+	return errors.Is(err, ErrUnknownSong) || errors.Is(err, ErrNoSearchResults) || isRestricted(err)
+}
+
+/*
+ * Counts the failures of calls to one service
+ */
+type circuitBreaker struct {
+	failures  int       // failures in a row
+	openUntil time.Time // calls fail fast until then. Zero while closed
+	probing   bool      // true while the call probing a half open breaker is running
+	trips     uint64    // times the breaker opened
+	failed    uint64    // failed calls, including timeouts
+}
+
+/*
+ * The state of a service's breaker, for metrics
+ */
+type breakerStats struct {
+	service  string // service the breaker guards
+	open     bool   // true if calls to the service fail fast
+	trips    uint64 // times the breaker opened
+	failures uint64 // failed calls
+}
+
+/*
+ * Applies the timeout, retries and circuit breakers to calls
+ */
+type fetchGuard struct {
+	timeout  time.Duration              // how long each attempt may take
+	retries  int                        // attempts made after the first fails
+	backoff  time.Duration              // wait before the first retry
+	after    int                        // failures in a row that open a breaker
+	cooldown time.Duration              // how long an open breaker fails calls fast
+	breakers map[string]*circuitBreaker // service -> breaker
+	lock     sync.Mutex                 // lock on the breakers
+}
+
+/*
+ * Initialize the guard with breakers for the services. Zero values fall back
+ * to defaults, except for the retries.
+ */
+func (g *fetchGuard) init(timeout time.Duration, retries int, after int, cooldown time.Duration,
+	services ...string) {
+
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+
+	if after <= 0 {
+		after = defaultBreakerAfter
+	}
+
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	if retries < 0 {
+		retries = 0
+	}
+
+	g.timeout = timeout
+	g.retries = retries
+	g.backoff = fetchBackoff
+	g.after = after
+	g.cooldown = cooldown
+	g.breakers = make(map[string]*circuitBreaker, len(services))
+	for _, service := range services {
+		g.breakers[service] = new(circuitBreaker)
+	}
+}
+
+/*
+ * Call the service, retrying attempts that fail or time out. Returns what the
+ * last attempt returned, or an error wrapping ErrServiceUnavailable if the
+ * service's breaker is open or every attempt failed.
+ */
+func (g *fetchGuard) call(service string, fetch func() (interface{}, error)) (interface{}, error) {
+	var err error
+	for attempt := 0; attempt <= g.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(g.backoff << uint(attempt-1))
+		}
+
+		if !g.allow(service, time.Now()) {
+			if err == nil {
+				return nil, fmt.Errorf("%w: %s is failing", ErrServiceUnavailable, service)
+			}
+			break
+		}
+
+		var result interface{}
+		result, err = g.attempt(fetch)
+		if err == nil || isPermanentFetchError(err) {
+			g.record(service, true, time.Now())
+			return result, err
+		}
+
+		g.record(service, false, time.Now())
+		log.Printf("Attempt %d calling %s failed: %v", attempt+1, service, err)
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrServiceUnavailable, err)
+}
+
+/*
+ * Make a single attempt, giving up on it after the timeout. An attempt given
+ * up on still runs to the end, but its result is dropped.
+ */
+func (g *fetchGuard) attempt(fetch func() (interface{}, error)) (interface{}, error) {
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := fetch()
+		done <- outcome{result, err}
+	}()
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+
+	select {
+	case out := <-done:
+		return out.result, out.err
+	case <-timer.C:
+		return nil, ErrFetchTimeout
+	}
+}
+
+/*
+ * Returns true if a call to the service may go through. Once an open breaker
+ * cools down, one call at a time goes through to probe the service.
+ */
+func (g *fetchGuard) allow(service string, now time.Time) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	breaker := g.breaker(service)
+	if breaker.openUntil.IsZero() {
+		return true
+	}
+
+	if breaker.probing || now.Before(breaker.openUntil) {
+		return false
+	}
+
+	breaker.probing = true
+	return true
+}
+
+/*
+ * Record how a call to the service went. A failed probe or too many failures
+ * in a row open the breaker, and any success closes it.
+ */
+func (g *fetchGuard) record(service string, ok bool, now time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	breaker := g.breaker(service)
+	probe := breaker.probing
+	breaker.probing = false
+
+	if ok {
+		breaker.failures = 0
+		breaker.openUntil = time.Time{}
+		return
+	}
+
+	breaker.failures++
+	breaker.failed++
+	if probe || (breaker.openUntil.IsZero() && breaker.failures >= g.after) {
+		if !probe {
+			breaker.trips++
+		}
+		breaker.openUntil = now.Add(g.cooldown)
+		log.Printf("Calls to %s fail fast for %v after %d failures", service, g.cooldown, breaker.failures)
+	}
+}
+
+/*
+ * Returns the breaker of the service, adding it if needed. Expects the lock
+ * to be held.
+ */
+func (g *fetchGuard) breaker(service string) *circuitBreaker {
+	breaker, exists := g.breakers[service]
+	if !exists {
+		breaker = new(circuitBreaker)
+		g.breakers[service] = breaker
+	}
+
+	return breaker
+}
+
+/*
+ * Returns the state of each service's breaker, sorted by service
+ */
+func (g *fetchGuard) stats(now time.Time) []breakerStats {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	stats := make([]breakerStats, 0, len(g.breakers))
+	for service, breaker := range g.breakers {
+		stats = append(stats, breakerStats{
+			service:  service,
+			open:     !breaker.openUntil.IsZero() && (breaker.probing || now.Before(breaker.openUntil)),
+			trips:    breaker.trips,
+			failures: breaker.failed,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].service < stats[j].service })
+	return stats
+}
+
+/*
+ * Fetches metadata through the guard of its service
+ */
+type guardedFetcher struct {
+	guard   *fetchGuard     // guard on the calls
+	service string          // service the fetched links belong to
+	fetcher MetadataFetcher // fetcher doing the work
+}
+
+/*
+ * Fetch the metadata of a link. Each attempt fetches into a song of its own,
+ * so an attempt given up on can't write over the song.
+ */
+func (f *guardedFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	result, err := f.guard.call(f.service, func() (interface{}, error) {
+		fetched := new(cmpb.Song)
+		return fetched, f.fetcher.FetchSongData(link, fetched)
+	})
+
+	// restricted videos are still populated
+	if fetched, ok := result.(*cmpb.Song); ok && (err == nil || isRestricted(err)) {
+		proto.Merge(song, fetched)
+	}
+
+	return err
+}
+
+/*
+ * Send the links of the services through the guard
+ */
+func (r *fetcherRouter) guardServices(guard *fetchGuard, services ...string) {
+	for _, service := range services {
+		if fetcher := r.fetchers[service]; fetcher != nil {
+			r.fetchers[service] = &guardedFetcher{guard: guard, service: service, fetcher: fetcher}
+		}
+	}
+}
+
+/*
+ * Search YouTube through the guard
+ */
+func (s *BackendServer) searchYoutube(query string, maxResults int64) ([]*cmpb.Song, error) {
+	result, err := s.guard.call(ServiceYoutube, func() (interface{}, error) {
+		return s.fetcher.searchYoutube(query, maxResults)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*cmpb.Song), nil
+}
+
+/*
+ * Fetch the details of a song, through the guard for songs from YouTube. Each
+ * attempt fetches into details of its own, so an attempt given up on can't
+ * write over them.
+ */
+func (s *BackendServer) fetchSongDetails(song *cmpb.Song, details *bepb.SongDetails) error {
+	if song.Service != cmpb.ServiceType_Youtube {
+		return s.fetcher.fetchSongDetails(song, details)
+	}
+
+	result, err := s.guard.call(ServiceYoutube, func() (interface{}, error) {
+		fetched := new(bepb.SongDetails)
+		return fetched, s.fetcher.fetchSongDetails(song, fetched)
+	})
+	if err != nil {
+		return err
+	}
+
+	proto.Merge(details, result.(*bepb.SongDetails))
+	return nil
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Metadata fetcher that fails or hangs, like a service in an outage
+ */
+type outageFetcher struct {
+	calls int           // calls made
+	err   error         // error each call returns
+	hang  chan struct{} // calls wait on this before returning when set
+}
+
+func (f *outageFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	f.calls++
+	if f.hang != nil {
+		<-f.hang
+	}
+
+	song.Title = link
+	return f.err
+}
+
+func testFetchGuard(retries int, after int) *fetchGuard {
+	guard := new(fetchGuard)
+	guard.init(50*time.Millisecond, retries, after, time.Minute, ServiceYoutube)
+	guard.backoff = time.Millisecond
+	return guard
+}
+
+func TestFetchGuard_whenAttemptsFail_retries(t *testing.T) {
+	guard := testFetchGuard(2, 10)
+	fetcher := &outageFetcher{err: errors.New("Failed to fetch song metadata")}
+	guarded := &guardedFetcher{guard: guard, service: ServiceYoutube, fetcher: fetcher}
+
+	song := new(cmpb.Song)
+	err := guarded.FetchSongData("https://youtu.be/dQw4w9WgXcQ", song)
+	if !errors.Is(err, ErrServiceUnavailable) || fetcher.calls != 3 {
+		t.Errorf("Expected 3 attempts then %v, got %d attempts and %v", ErrServiceUnavailable, fetcher.calls, err)
+	}
+
+	if song.Title != "" {
+		t.Errorf("Expected the song to be left alone, got %v", song)
+	}
+}
+
+func TestFetchGuard_whenSongIsUnknown_doesNotRetry(t *testing.T) {
+	guard := testFetchGuard(2, 1)
+	fetcher := &outageFetcher{err: ErrUnknownSong}
+	guarded := &guardedFetcher{guard: guard, service: ServiceYoutube, fetcher: fetcher}
+
+	err := guarded.FetchSongData("https://youtu.be/dQw4w9WgXcQ", new(cmpb.Song))
+	if !errors.Is(err, ErrUnknownSong) || fetcher.calls != 1 {
+		t.Errorf("Expected a single attempt returning %v, got %d attempts and %v", ErrUnknownSong, fetcher.calls, err)
+	}
+
+	// the service answered, so the breaker stays closed
+	if stats := guard.stats(time.Now()); stats[0].open || stats[0].failures != 0 {
+		t.Errorf("Expected the breaker to stay closed, got %+v", stats[0])
+	}
+}
+
+func TestFetchGuard_whenAttemptHangs_timesOut(t *testing.T) {
+	guard := testFetchGuard(0, 10)
+	fetcher := &outageFetcher{hang: make(chan struct{})}
+	defer close(fetcher.hang)
+	guarded := &guardedFetcher{guard: guard, service: ServiceYoutube, fetcher: fetcher}
+
+	start := time.Now()
+	err := guarded.FetchSongData("https://youtu.be/dQw4w9WgXcQ", new(cmpb.Song))
+	if !errors.Is(err, ErrServiceUnavailable) || time.Since(start) > time.Second {
+		t.Errorf("Expected the attempt to time out, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestFetchGuard_afterFailures_failsFastUntilProbeSucceeds(t *testing.T) {
+	guard := testFetchGuard(0, 2)
+	failing := errors.New("Failed to fetch song metadata")
+	calls := 0
+	fetch := func() (interface{}, error) {
+		calls++
+		return nil, failing
+	}
+
+	guard.call(ServiceYoutube, fetch)
+	guard.call(ServiceYoutube, fetch)
+	if _, err := guard.call(ServiceYoutube, fetch); !errors.Is(err, ErrServiceUnavailable) || calls != 2 {
+		t.Errorf("Expected the open breaker to fail fast, got %v after %d calls", err, calls)
+	}
+
+	stats := guard.stats(time.Now())
+	if !stats[0].open || stats[0].trips != 1 || stats[0].failures != 2 {
+		t.Errorf("Expected an open breaker tripped once, got %+v", stats[0])
+	}
+
+	// once cooled down a probe goes through, and its success closes the breaker
+	guard.lock.Lock()
+	guard.breakers[ServiceYoutube].openUntil = time.Now().Add(-time.Second)
+	guard.lock.Unlock()
+
+	if _, err := guard.call(ServiceYoutube, func() (interface{}, error) { return "ok", nil }); err != nil {
+		t.Errorf("Expected the probe to go through, got %v", err)
+	}
+
+	if stats := guard.stats(time.Now()); stats[0].open {
+		t.Errorf("Expected the breaker to close, got %+v", stats[0])
+	}
+}
+
+func TestFetchGuard_whenProbeFails_reopens(t *testing.T) {
+	guard := testFetchGuard(0, 1)
+	failing := func() (interface{}, error) { return nil, errors.New("Failed to fetch song metadata") }
+
+	guard.call(ServiceYoutube, failing)
+	guard.lock.Lock()
+	guard.breakers[ServiceYoutube].openUntil = time.Now().Add(-time.Second)
+	guard.lock.Unlock()
+
+	guard.call(ServiceYoutube, failing)
+	if stats := guard.stats(time.Now()); !stats[0].open || stats[0].trips != 1 || stats[0].failures != 2 {
+		t.Errorf("Expected the failed probe to open the breaker again, got %+v", stats[0])
+	}
+}
+
+func TestSendSong_whenServiceIsDown_explains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_fetch_guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	server.guard = testFetchGuard(1, 10)
+	router := new(fetcherRouter)
+	router.init(defaultFetchers, nil,
+		map[string]MetadataFetcher{ServiceYoutube: &outageFetcher{err: errors.New("Failed to fetch song metadata")}})
+	router.guardServices(server.guard, ServiceYoutube)
+	server.metadata = router
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId,
+		Link: "https://www.youtube.com/watch?v=SilKjJ0S904"})
+	if response.Success || response.Message != serviceDownMessage {
+		t.Errorf("Expected the submission to explain the service is down, got %v", response)
+	}
+}
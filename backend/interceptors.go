@@ -0,0 +1,77 @@
+/*
+ * Interceptors that run around every unary RPC handled by the backend
+ */
+
+package backend
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	sourceMetadataKey = "ytbox-source" // request metadata naming the submission source
+)
+
+/*
+ * Fill in the source of a song submission from the request metadata when the
+ * client didn't set it on the submission itself
+ */
+func sourceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if sub, ok := req.(*bepb.Submission); ok && sub.Source == cmpb.SubmissionSource_UnknownSource {
+		sub.Source = sourceFromMetadata(ctx)
+	}
+
+	return handler(ctx, req)
+}
+
+/*
+ * Report a call that ran past the client's deadline as such, rather than with
+ * the failure the handler made of a database query giving up part way
+ */
+func deadlineInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	response, err := handler(ctx, req)
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Call to %s ran past its deadline", info.FullMethod)
+		return nil, status.Error(codes.DeadlineExceeded, "the call ran past its deadline")
+	}
+
+	return response, err
+}
+
+/*
+ * Parse the submission source out of the request metadata. The name of the
+ * source is matched without regard to case.
+ */
+func sourceFromMetadata(ctx context.Context) cmpb.SubmissionSource {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return cmpb.SubmissionSource_UnknownSource
+	}
+
+	values := md.Get(sourceMetadataKey)
+	if len(values) == 0 {
+		return cmpb.SubmissionSource_UnknownSource
+	}
+
+	for name, value := range cmpb.SubmissionSource_value {
+		if strings.EqualFold(name, values[0]) {
+			return cmpb.SubmissionSource(value)
+		}
+	}
+
+	return cmpb.SubmissionSource_UnknownSource
+}
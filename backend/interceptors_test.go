@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestDeadlineInterceptor_whenDeadlinePassed_returnsDeadlineExceeded(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/backend.YtbBackend/GetStats"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &bepb.Error{Success: false, Message: "Failed to read stats."}, nil
+	}
+
+	response, err := deadlineInterceptor(ctx, nil, info, handler)
+	if status.Code(err) != codes.DeadlineExceeded || response != nil {
+		t.Errorf("Expected the deadline to be exceeded, but got %v, %v", response, err)
+	}
+}
+
+func TestDeadlineInterceptor_whenInTime_returnsResponse(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/backend.YtbBackend/GetStats"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &bepb.Error{Success: true}, nil
+	}
+
+	response, err := deadlineInterceptor(ctx, nil, info, handler)
+	if err != nil || !response.(*bepb.Error).Success {
+		t.Errorf("Expected the handler's response, but got %v, %v", response, err)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Jingles are short clips, like a station ident, that the backend plays
+ * between songs every few songs or once the hour strikes. They're played in
+ * place of the next song in the queue, which stays put, and take turns in
+ * the order of their names. A jingle isn't anyone's submission, so it isn't
+ * recorded in the history and can't be voted off.
+ */
+
+package backend
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var ErrJingleNotFound = errors.New("No jingle by that name exists.")
+
+/*
+ * Holds the registered jingles and decides when each zone plays one
+ */
+type jingleBox struct {
+	dbManager db.DbManager         // database the jingles are saved in
+	every     uint32               // songs between jingles. Zero doesn't count songs
+	onHour    bool                 // play a jingle once each hour strikes
+	jingles   []*bepb.Jingle       // registered jingles in the order they take turns
+	turn      int                  // index of the jingle played next
+	played    map[uint32]uint32    // zone id -> songs played since the zone's last jingle
+	hours     map[uint32]time.Time // zone id -> hour the zone last played a jingle or started in
+	lock      sync.Mutex           // lock on the jingles and counts
+}
+
+/*
+ * Initialize the jingle box with the jingles saved in the database
+ */
+func (b *jingleBox) init(dbManager db.DbManager, every uint32, onHour bool) {
+	b.dbManager = dbManager
+	b.every = every
+	b.onHour = onHour
+	b.played = make(map[uint32]uint32)
+	b.hours = make(map[uint32]time.Time)
+
+	jingles, err := dbManager.GetJingles()
+	if err != nil {
+		log.Printf("Failed to load jingles: %v", err)
+		return
+	}
+	b.jingles = jingles
+}
+
+/*
+ * Register a jingle, replacing any jingle with the same name
+ */
+func (b *jingleBox) add(jingle *bepb.Jingle) error {
+	if err := b.dbManager.SaveJingle(jingle); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, registered := range b.jingles {
+		if registered.Name == jingle.Name {
+			b.jingles[i] = jingle
+			return nil
+		}
+	}
+
+	// keep the turns in name order, as they're loaded from the database
+	i := 0
+	for i < len(b.jingles) && b.jingles[i].Name < jingle.Name {
+		i++
+	}
+	b.jingles = append(b.jingles[:i], append([]*bepb.Jingle{jingle}, b.jingles[i:]...)...)
+	return nil
+}
+
+/*
+ * Stop playing the jingle with the name
+ */
+func (b *jingleBox) remove(name string) error {
+	removed, err := b.dbManager.RemoveJingle(name)
+	if err != nil {
+		return err
+	} else if !removed {
+		return ErrJingleNotFound
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, registered := range b.jingles {
+		if registered.Name == name {
+			b.jingles = append(b.jingles[:i], b.jingles[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the registered jingles
+ */
+func (b *jingleBox) list() []*bepb.Jingle {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]*bepb.Jingle(nil), b.jingles...)
+}
+
+/*
+ * Count a song played in a zone toward its next jingle
+ */
+func (b *jingleBox) count(zoneId uint32) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.played[zoneId]++
+}
+
+/*
+ * Returns the jingle a zone should play before its next song, or nil if it
+ * isn't time for one
+ */
+func (b *jingleBox) due(zoneId uint32, now time.Time) *cmpb.Song {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// hours strike on the wall clock, which is half past in some time zones
+	hour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	if _, seen := b.hours[zoneId]; !seen {
+		b.hours[zoneId] = hour
+	}
+
+	struck := b.onHour && hour.After(b.hours[zoneId])
+	counted := b.every > 0 && b.played[zoneId] >= b.every
+	if len(b.jingles) == 0 || (!struck && !counted) {
+		return nil
+	}
+
+	jingle := b.jingles[b.turn%len(b.jingles)]
+	b.turn = (b.turn + 1) % len(b.jingles)
+	b.played[zoneId] = 0
+	b.hours[zoneId] = hour
+	return jingleSong(jingle)
+}
+
+/*
+ * Returns the song players play for a jingle. Links to websites are streamed
+ * and anything else is a file on the players.
+ */
+func jingleSong(jingle *bepb.Jingle) *cmpb.Song {
+	service := cmpb.ServiceType_Local
+	if strings.HasPrefix(jingle.Link, "http://") || strings.HasPrefix(jingle.Link, "https://") {
+		service = cmpb.ServiceType_Web
+	}
+
+	return &cmpb.Song{
+		Title:     jingle.Name,
+		Service:   service,
+		ServiceId: jingle.Link,
+		Metadata:  &cmpb.Metadata{},
+		Jingle:    true,
+	}
+}
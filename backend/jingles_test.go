@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestJingleBox_due_everyFewSongsInTurn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_jingles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	box := new(jingleBox)
+	box.init(server.dbManager, 2, false)
+	box.add(&bepb.Jingle{Name: "station", Link: "/srv/jingles/station.mp3"})
+	box.add(&bepb.Jingle{Name: "ident", Link: "https://example.com/ident.mp3"})
+
+	now := time.Now()
+	if jingle := box.due(1, now); jingle != nil {
+		t.Fatalf("Expected no jingle before any songs, got %v", jingle)
+	}
+
+	box.count(1)
+	box.count(1)
+	box.count(2)
+
+	jingle := box.due(1, now)
+	if jingle == nil || !jingle.Jingle || jingle.Title != "ident" || jingle.Service != cmpb.ServiceType_Web {
+		t.Fatalf("Expected the ident jingle after two songs, got %v", jingle)
+	}
+
+	if jingle = box.due(2, now); jingle != nil {
+		t.Errorf("Expected each zone to count its own songs, got %v", jingle)
+	}
+
+	box.count(1)
+	box.count(1)
+	if jingle = box.due(1, now); jingle.GetTitle() != "station" || jingle.Service != cmpb.ServiceType_Local {
+		t.Errorf("Expected the station jingle to take its turn, got %v", jingle)
+	}
+
+	// the jingles are loaded again on start up
+	restarted := new(jingleBox)
+	restarted.init(server.dbManager, 2, false)
+	if err := restarted.remove("ident"); err != nil || len(restarted.list()) != 1 {
+		t.Errorf("Expected one jingle left, got %v and %v", restarted.list(), err)
+	}
+
+	if err := restarted.remove("ident"); err != ErrJingleNotFound {
+		t.Errorf("Expected a missing jingle, got %v", err)
+	}
+}
+
+func TestJingleBox_due_onTheHour(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_jingles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	box := new(jingleBox)
+	box.init(server.dbManager, 0, true)
+	box.add(&bepb.Jingle{Name: "chime", Link: "/srv/jingles/chime.mp3"})
+
+	start := time.Date(2020, time.March, 6, 20, 50, 0, 0, time.Local)
+	if jingle := box.due(1, start); jingle != nil {
+		t.Fatalf("Expected no jingle in the hour the zone started, got %v", jingle)
+	}
+
+	if jingle := box.due(1, start.Add(5*time.Minute)); jingle != nil {
+		t.Fatalf("Expected no jingle before the hour, got %v", jingle)
+	}
+
+	if jingle := box.due(1, start.Add(12*time.Minute)); jingle.GetTitle() != "chime" {
+		t.Fatalf("Expected the chime once the hour struck, got %v", jingle)
+	}
+
+	if jingle := box.due(1, start.Add(20*time.Minute)); jingle != nil {
+		t.Errorf("Expected one jingle per hour, got %v", jingle)
+	}
+}
+
+func TestJingleBox_due_onTheLocalHour(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("Time zone data isn't installed: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ytbox_jingles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	box := new(jingleBox)
+	box.init(server.dbManager, 0, true)
+	box.add(&bepb.Jingle{Name: "chime", Link: "/srv/jingles/chime.mp3"})
+
+	// Kolkata is five and a half hours ahead of UTC
+	start := time.Date(2020, time.March, 6, 20, 20, 0, 0, kolkata)
+	if jingle := box.due(1, start); jingle != nil {
+		t.Fatalf("Expected no jingle in the hour the zone started, got %v", jingle)
+	}
+
+	if jingle := box.due(1, start.Add(15*time.Minute)); jingle != nil {
+		t.Fatalf("Expected no jingle at half past, got %v", jingle)
+	}
+
+	if jingle := box.due(1, start.Add(45*time.Minute)); jingle.GetTitle() != "chime" {
+		t.Errorf("Expected the chime once the local hour struck, got %v", jingle)
+	}
+}
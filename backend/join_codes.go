@@ -0,0 +1,106 @@
+/*
+ * Builds the link guests join the party at and a QR code of it, so hosts can
+ * print it or put it on a screen and guests join by scanning it with their
+ * phone instead of typing an address. The link opens the frontend's login
+ * page with the room filled in.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	defaultJoinCodeSize = 256  // pixels across a QR code when no size is asked for
+	minJoinCodeSize     = 64   // smallest QR code that still scans from a printout
+	maxJoinCodeSize     = 1024 // largest QR code drawn, so callers can't ask for huge images
+)
+
+var ErrNoJoinUrl = errors.New("The backend wasn't given the address guests join at. Start it with --joinUrl.")
+
+/*
+ * Returns the link to the frontend's login page at the base address, with the
+ * room filled in if there is one
+ */
+func joinLink(base string, room string) (string, error) {
+	link, err := url.Parse(strings.TrimSpace(base))
+	if err != nil || link.Host == "" || (link.Scheme != "http" && link.Scheme != "https") {
+		return "", errors.New("The address guests join at must be an http or https link.")
+	}
+
+	link.Path = strings.TrimSuffix(link.Path, "/") + "/login"
+	link.RawQuery = ""
+	if room != "" {
+		link.RawQuery = url.Values{"room": {room}}.Encode()
+	}
+
+	return link.String(), nil
+}
+
+/*
+ * Returns the size of the QR code drawn for a request, in pixels
+ */
+func joinCodeSize(size uint32) int {
+	switch {
+	case size == 0:
+		return defaultJoinCodeSize
+	case size < minJoinCodeSize:
+		return minJoinCodeSize
+	case size > maxJoinCodeSize:
+		return maxJoinCodeSize
+	}
+	return int(size)
+}
+
+/*
+ * Gets the link guests join the party at along with a QR code of it. The
+ * address given to the backend wins over the one the caller sends.
+ */
+func (s *BackendServer) GetJoinCode(con context.Context, request *bepb.JoinCodeRequest) (*bepb.JoinCode, error) {
+	response := &bepb.JoinCode{Err: &bepb.Error{Success: false}}
+
+	base := s.joinUrl
+	if base == "" {
+		base = request.GetBaseUrl()
+	}
+
+	if base == "" {
+		response.Err.Message = ErrNoJoinUrl.Error()
+		return response, nil
+	}
+
+	room := strings.TrimSpace(request.GetRoom())
+	if room != "" {
+		if _, err := s.dbFor(con).GetRoomByName(room); err != nil {
+			response.Err.Message = "Room does not exist."
+			return response, nil
+		}
+	}
+
+	link, err := joinLink(base, room)
+	if err != nil {
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	png, err := qrcode.Encode(link, qrcode.Medium, joinCodeSize(request.GetSize()))
+	if err != nil {
+		log.Printf("Failed to draw a QR code of %s: %v", link, err)
+		response.Err.Message = "Failed to draw the QR code."
+		return response, nil
+	}
+
+	response.Url = link
+	response.Png = png
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestJoinLink(t *testing.T) {
+	tests := []struct {
+		base     string
+		room     string
+		expected string
+	}{
+		{"http://ytbox.local:8080", "", "http://ytbox.local:8080/login"},
+		{"http://ytbox.local:8080/", "Kitchen", "http://ytbox.local:8080/login?room=Kitchen"},
+		{"https://party.example.com/ytbox?x=1", "Back Yard", "https://party.example.com/ytbox/login?room=Back+Yard"},
+	}
+
+	for _, test := range tests {
+		if link, err := joinLink(test.base, test.room); err != nil || link != test.expected {
+			t.Errorf("Expected %q for %q in %q, but got %q and %v", test.expected, test.base, test.room, link, err)
+		}
+	}
+
+	for _, base := range []string{"ytbox.local:8080", "ftp://ytbox.local", "http://"} {
+		if link, err := joinLink(base, ""); err == nil {
+			t.Errorf("Expected %q to be turned away, but got %q", base, link)
+		}
+	}
+}
+
+func TestGetJoinCode_drawsQrCodeOfLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.dbManager.AddRoom("Kitchen")
+
+	response, _ := server.GetJoinCode(context.Background(), &bepb.JoinCodeRequest{Room: "Kitchen"})
+	if response.Err.Success || response.Err.Message != ErrNoJoinUrl.Error() {
+		t.Errorf("Expected a join code without an address to be turned away, got %v", response.Err)
+	}
+
+	request := &bepb.JoinCodeRequest{Room: "Kitchen", Size: 300, BaseUrl: "http://10.0.0.2:8080"}
+	if response, _ = server.GetJoinCode(context.Background(), request); !response.Err.Success {
+		t.Fatalf("Expected a join code, got %v", response.Err)
+	}
+
+	if response.Url != "http://10.0.0.2:8080/login?room=Kitchen" {
+		t.Errorf("Expected the caller's address to be used, got %s", response.Url)
+	}
+
+	image, err := png.Decode(bytes.NewReader(response.Png))
+	if err != nil || image.Bounds().Dx() != 300 {
+		t.Errorf("Expected a 300 pixel PNG, got %v and %v", image, err)
+	}
+
+	server.joinUrl = "http://ytbox.local"
+	if response, _ = server.GetJoinCode(context.Background(), request); response.Url != "http://ytbox.local/login?room=Kitchen" {
+		t.Errorf("Expected the backend's address to win, got %s", response.Url)
+	}
+
+	request.Room = "Attic"
+	if response, _ = server.GetJoinCode(context.Background(), request); response.Err.Success {
+		t.Error("Expected a room that doesn't exist to be turned away")
+	}
+}
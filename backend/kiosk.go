@@ -0,0 +1,137 @@
+/*
+ * Kiosk mode lets guests queue songs from a tablet at the door without
+ * logging in. The tablet calls the backend with a kiosk api key, which can
+ * only view the queue and submit songs. Guests enter a name with each song and
+ * the song is queued for a guest user named after them, like "Kiosk: Sam", so
+ * the guest takes turns like everyone else. Each kiosk can only queue so many
+ * songs an hour, so a tablet left unattended can't flood the queue.
+ */
+
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	kioskRoomName = "Kiosk"   // room guests at kiosks are added to
+	kioskPrefix   = "Kiosk: " // put in front of the names guests enter
+	kioskWindow   = time.Hour // window each kiosk's songs are counted over
+)
+
+var (
+	ErrGuestNameMissing = errors.New("Please enter your name before picking a song.")
+	ErrNotKiosk         = errors.New("Only kiosks can submit songs for guests.")
+)
+
+/*
+ * Counts the songs each kiosk queued within the last window
+ */
+type kioskLimiter struct {
+	songs  uint32                 // songs each kiosk may queue per window. Zero doesn't limit them
+	queued map[string][]time.Time // kiosk name -> when its songs in the window were queued
+	lock   sync.Mutex             // lock on the counts
+}
+
+/*
+ * Initialize the limiter with the songs each kiosk may queue an hour
+ */
+func (k *kioskLimiter) init(songs uint32) {
+	k.songs = songs
+	k.queued = make(map[string][]time.Time)
+}
+
+/*
+ * Count a song the kiosk is queueing. Returns false if the kiosk already
+ * queued its songs for the window, along with when it can queue another.
+ */
+func (k *kioskLimiter) take(kiosk string, now time.Time) (time.Time, bool) {
+	if k.songs == 0 {
+		return time.Time{}, true
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	recent := k.queued[kiosk]
+	for len(recent) > 0 && !now.Before(recent[0].Add(kioskWindow)) {
+		recent = recent[1:]
+	}
+
+	if uint32(len(recent)) >= k.songs {
+		k.queued[kiosk] = recent
+		return recent[0].Add(kioskWindow), false
+	}
+
+	k.queued[kiosk] = append(recent, now)
+	return time.Time{}, true
+}
+
+/*
+ * Returns the name guests entering the name at a kiosk are shown under
+ */
+func guestUsername(name string) string {
+	return kioskPrefix + strings.TrimSpace(name)
+}
+
+/*
+ * Returns the id of the user a submission is from. Kiosks submit for guests,
+ * who are added to the kiosk room the first time they enter their name, and
+ * the name of the kiosk is returned with them. Guest names from anyone else
+ * are turned away.
+ */
+func (s *BackendServer) submitterOf(con context.Context, sub *bepb.Submission) (uint32, string, error) {
+	kiosk, isKiosk := s.apiKeys.kioskOf(con)
+	if !isKiosk {
+		if sub.GetGuestName() != "" {
+			return 0, "", ErrNotKiosk
+		}
+		return sub.GetUserId(), "", nil
+	}
+
+	if strings.TrimSpace(sub.GetGuestName()) == "" {
+		return 0, kiosk, ErrGuestNameMissing
+	}
+
+	roomId, err := s.kioskRoom()
+	if err != nil {
+		return 0, kiosk, err
+	}
+
+	username := guestUsername(sub.GetGuestName())
+	userData, err := s.findOrAddUser(username, roomId)
+	if err != nil {
+		return 0, kiosk, err
+	}
+
+	s.userCache.AddUserToCache(userData.User.UserId, username, roomId)
+	return userData.User.UserId, kiosk, nil
+}
+
+/*
+ * Returns the id of the room guests at kiosks are added to, creating it the
+ * first time a guest submits a song
+ */
+func (s *BackendServer) kioskRoom() (uint32, error) {
+	var roomId uint32
+	err := s.dbManager.WithTx(func(tx db.DbManager) error {
+		room, err := tx.GetRoomByName(kioskRoomName)
+		if errors.Is(err, sql.ErrNoRows) {
+			room, err = tx.AddRoom(kioskRoomName)
+		}
+		if err == nil {
+			roomId = room.Room.Id
+		}
+		return err
+	})
+
+	return roomId, err
+}
@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestKioskLimiter_take_countsEachKioskOverTheWindow(t *testing.T) {
+	limiter := new(kioskLimiter)
+	limiter.init(2)
+	start := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.take("door", start.Add(time.Duration(i)*time.Minute)); !ok {
+			t.Fatalf("Expected song %d from the kiosk to be counted", i+1)
+		}
+	}
+
+	next, ok := limiter.take("door", start.Add(10*time.Minute))
+	if ok || !next.Equal(start.Add(kioskWindow)) {
+		t.Errorf("Expected the kiosk to wait until %v, got %v and %v", start.Add(kioskWindow), next, ok)
+	}
+
+	if _, ok = limiter.take("patio", start.Add(10*time.Minute)); !ok {
+		t.Errorf("Expected another kiosk to have songs of its own")
+	}
+
+	if _, ok = limiter.take("door", start.Add(kioskWindow)); !ok {
+		t.Errorf("Expected the kiosk to queue again once its first song left the window")
+	}
+}
+
+func TestKioskLimiter_take_whenUnlimited_countsNothing(t *testing.T) {
+	limiter := new(kioskLimiter)
+	limiter.init(0)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := limiter.take("door", time.Now()); !ok {
+			t.Fatalf("Expected an unlimited kiosk to queue song %d", i+1)
+		}
+	}
+}
+
+func TestSendSong_fromKiosk_queuesForGuest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_kiosk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	fetcher := &titleFetcher{title: "Never Gonna Give You Up"}
+	server.metadata = fetcher
+	server.kiosks.init(1)
+
+	key, err := server.apiKeys.create("door", bepb.ApiKeyScope_KioskKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := apiKeyContext(key.Key)
+
+	response, _ := server.SendSong(ctx, &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ"})
+	if response.Success || response.Message != ErrGuestNameMissing.Error() {
+		t.Errorf("Expected a guest without a name to be turned away, got %v", response)
+	}
+
+	response, _ = server.SendSong(ctx, &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ", GuestName: "Sam"})
+	if !response.Success {
+		t.Fatalf("Expected the guest's song to be queued, got %v", response)
+	}
+
+	song := server.queueMgr.GetPlaylist().Songs[0]
+	if song.Username != "Kiosk: Sam" || song.Source != cmpb.SubmissionSource_Kiosk {
+		t.Errorf("Expected the song to be queued for the guest from the kiosk, got %v", song)
+	}
+
+	room, err := server.dbManager.GetRoomByName(kioskRoomName)
+	if err != nil || room.Room.Id != song.RoomId {
+		t.Errorf("Expected the guest to be added to the kiosk room, got %v and %v", room, err)
+	}
+
+	fetcher.title = "Gangnam Style"
+	response, _ = server.SendSong(ctx, &bepb.Submission{Link: "https://youtu.be/9bZkp7q19f0", GuestName: "Alex"})
+	if response.Success || !strings.HasPrefix(response.Message, "This kiosk has queued all the songs it can") {
+		t.Errorf("Expected the kiosk to be held to its limit, got %v", response)
+	}
+
+	response, _ = server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/9bZkp7q19f0",
+		GuestName: "Alex"})
+	if response.Success || response.Message != ErrNotKiosk.Error() {
+		t.Errorf("Expected a guest name from outside a kiosk to be turned away, got %v", response)
+	}
+}
+
+func TestApiKeyring_authorize_kioskScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_kiosk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	policy, err := newAccessPolicy(map[string]string{"admin": "s3cret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.keys = server.apiKeys
+
+	key, err := server.apiKeys.create("door", bepb.ApiKeyScope_KioskKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := apiKeyContext(key.Key)
+	for _, method := range []string{"GetPlaylist", "GetNowPlaying", "SendSong"} {
+		if err := policy.authorize(ctx, "/backend_pb.YtbBackend/"+method); err != nil {
+			t.Errorf("Expected a kiosk key to call %s, got %v", method, err)
+		}
+	}
+
+	for _, method := range []string{"LoginUser", "RemoveSong", "NextSong", "CreateApiKey"} {
+		if err := policy.authorize(ctx, "/backend_pb.YtbBackend/"+method); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected a kiosk key to be turned away from %s, got %v", method, err)
+		}
+	}
+
+	if name, ok := server.apiKeys.kioskOf(ctx); !ok || name != "door" {
+		t.Errorf("Expected the request to come from the door kiosk, got %q", name)
+	}
+}
+
+func TestSendSong_turnedAwayAfterBoarding_keepsBoardingSong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_kiosk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.metadata = &titleFetcher{title: "Never Gonna Give You Up"}
+	server.boarding.init(time.Hour, time.Now())
+	server.kiosks.init(1)
+	server.kiosks.take("door", time.Now())
+
+	key, err := server.apiKeys.create("door", bepb.ApiKeyScope_KioskKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submission := &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ", GuestName: "Sam"}
+	response, _ := server.SendSong(apiKeyContext(key.Key), submission)
+	if response.Success || !strings.HasPrefix(response.Message, "This kiosk has queued all the songs it can") {
+		t.Fatalf("Expected the kiosk to be held to its limit, got %v", response)
+	}
+
+	server.kiosks.init(0)
+	if response, _ = server.SendSong(apiKeyContext(key.Key), submission); !response.Success {
+		t.Errorf("Expected the guest to still have their boarding song, got %v", response)
+	}
+}
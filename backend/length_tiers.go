@@ -0,0 +1,169 @@
+/*
+ * Length tiers keep long songs from hogging the queue without banning them.
+ * Short songs are always allowed, medium songs count double against the songs
+ * a user may have queued, and long songs wait for an admin to approve them.
+ * Long songs skip the length cap, since an admin decides on them instead.
+ */
+
+package backend
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var ErrPendingNotFound = errors.New("No song is waiting for approval with that id.")
+
+/*
+ * Lengths at which songs move up a tier and the songs a user may have queued
+ */
+type lengthTiers struct {
+	doubleAfter   time.Duration // songs at least this long count double. Zero disables the medium tier
+	approvalAfter time.Duration // songs at least this long need approval. Zero disables the long tier
+	userSongs     uint32        // songs a user may have queued, counting medium songs twice. Zero is unlimited
+}
+
+/*
+ * Returns true if any tier rules are configured
+ */
+func (t lengthTiers) enabled() bool {
+	return t.doubleAfter > 0 || t.approvalAfter > 0 || t.userSongs > 0
+}
+
+/*
+ * Returns the tier of a song of the given length
+ */
+func (t lengthTiers) tierOf(length time.Duration) bepb.LengthTier {
+	switch {
+	case !t.enabled():
+		return bepb.LengthTier_NoTier
+	case t.approvalAfter > 0 && length >= t.approvalAfter:
+		return bepb.LengthTier_LongSong
+	case t.doubleAfter > 0 && length >= t.doubleAfter:
+		return bepb.LengthTier_MediumSong
+	default:
+		return bepb.LengthTier_ShortSong
+	}
+}
+
+/*
+ * Returns how many songs a song of the given length counts as
+ */
+func (t lengthTiers) weight(length time.Duration) uint32 {
+	if t.tierOf(length) == bepb.LengthTier_MediumSong {
+		return 2
+	}
+
+	return 1
+}
+
+/*
+ * Returns true if the user may queue another song of the given length in
+ * addition to the songs they already have queued
+ */
+func (t lengthTiers) allows(queueMgr *queuer.SongQueueManager, userId uint32, length time.Duration) bool {
+	if t.userSongs == 0 {
+		return true
+	}
+
+	queued := uint32(0)
+	for _, entry := range queuedByUser(queueMgr, userId, true, time.Now()) {
+		queued += t.weight(songLength(entry.Song))
+	}
+
+	return queued+t.weight(length) <= t.userSongs
+}
+
+/*
+ * A long song and the zone it's waiting to be queued in
+ */
+type pendingSong struct {
+	song      *cmpb.Song
+	zoneId    uint32
+	submitted time.Time
+}
+
+/*
+ * Holds long songs until an admin approves or turns them away
+ */
+type approvalQueue struct {
+	pending map[uint32]*pendingSong // pending id -> song waiting for approval
+	nextId  uint32                  // id given to the next pending song
+	lock    sync.Mutex              // lock on the pending songs
+}
+
+/*
+ * Initialize the approval queue
+ */
+func (q *approvalQueue) init() {
+	q.pending = make(map[uint32]*pendingSong)
+	q.nextId = 1
+}
+
+/*
+ * Hold a song for approval. Returns the id to review it by.
+ */
+func (q *approvalQueue) hold(song *cmpb.Song, zoneId uint32, now time.Time) uint32 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	id := q.nextId
+	q.nextId++
+	q.pending[id] = &pendingSong{song: song, zoneId: zoneId, submitted: now}
+	return id
+}
+
+/*
+ * Remove a song from the approval queue to queue or turn it away
+ */
+func (q *approvalQueue) take(id uint32) (*pendingSong, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	pending, exists := q.pending[id]
+	if !exists {
+		return nil, ErrPendingNotFound
+	}
+
+	delete(q.pending, id)
+	return pending, nil
+}
+
+/*
+ * Drop every song waiting for approval. Returns how many were dropped.
+ */
+func (q *approvalQueue) clear() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	dropped := len(q.pending)
+	q.pending = make(map[uint32]*pendingSong)
+	return dropped
+}
+
+/*
+ * Returns the songs waiting for approval, oldest first
+ */
+func (q *approvalQueue) list() []*bepb.PendingSong {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	songs := make([]*bepb.PendingSong, 0, len(q.pending))
+	for id, pending := range q.pending {
+		songs = append(songs, &bepb.PendingSong{
+			Id:        id,
+			Song:      pending.song,
+			ZoneId:    pending.zoneId,
+			Submitted: pending.submitted.Unix(),
+		})
+	}
+
+	sort.Slice(songs, func(i, j int) bool { return songs[i].Id < songs[j].Id })
+	return songs
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestLengthTiers_tierOf(t *testing.T) {
+	tiers := lengthTiers{doubleAfter: 5 * time.Minute, approvalAfter: 10 * time.Minute}
+
+	tests := []struct {
+		tiers  lengthTiers
+		length time.Duration
+		tier   bepb.LengthTier
+	}{
+		{lengthTiers{}, time.Hour, bepb.LengthTier_NoTier},
+		{tiers, 4 * time.Minute, bepb.LengthTier_ShortSong},
+		{tiers, 5 * time.Minute, bepb.LengthTier_MediumSong},
+		{tiers, 10 * time.Minute, bepb.LengthTier_LongSong},
+		{lengthTiers{userSongs: 3}, time.Hour, bepb.LengthTier_ShortSong},
+		{lengthTiers{approvalAfter: 10 * time.Minute}, 7 * time.Minute, bepb.LengthTier_ShortSong},
+	}
+
+	for _, test := range tests {
+		if tier := test.tiers.tierOf(test.length); tier != test.tier {
+			t.Errorf("Expected a %v song to be %v with %+v, but got %v", test.length, test.tier, test.tiers, tier)
+		}
+	}
+}
+
+func TestLengthTiers_allows_countsMediumSongsDouble(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	queueMgr.AddSong(queuedSong(2, 1, "PT6M"))
+	queueMgr.AddSong(queuedSong(3, 2, "PT6M"))
+
+	tiers := lengthTiers{doubleAfter: 5 * time.Minute, userSongs: 4}
+	if !tiers.allows(queueMgr, 1, 3*time.Minute) {
+		t.Error("Expected a short song to fit in the user's last slot")
+	}
+
+	if tiers.allows(queueMgr, 1, 6*time.Minute) {
+		t.Error("Expected a medium song not to fit in the user's last slot")
+	}
+
+	if !tiers.allows(queueMgr, 2, 6*time.Minute) {
+		t.Error("Expected other users' songs not to count")
+	}
+}
+
+func TestReviewSong_queuesApprovedSongs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_tiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	user, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	long := &cmpb.Song{Title: "Long", Service: cmpb.ServiceType_Youtube, ServiceId: "long",
+		UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id}
+	other := &cmpb.Song{Title: "Longer", Service: cmpb.ServiceType_Youtube, ServiceId: "longer",
+		UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id}
+
+	now := time.Now()
+	approved := server.approvals.hold(long, defaultZoneId, now)
+	rejected := server.approvals.hold(other, defaultZoneId, now)
+	if pending := server.approvals.list(); len(pending) != 2 || pending[0].Song != long {
+		t.Fatalf("Expected both songs waiting in order, got %v", pending)
+	}
+
+	response, _ := server.ReviewSong(context.Background(), &bepb.SongReview{Id: rejected})
+	if !response.Success || len(server.queueMgr.GetPlaylist().Songs) != 0 {
+		t.Fatalf("Expected the rejected song to be dropped, got %v", response)
+	}
+
+	response, _ = server.ReviewSong(context.Background(), &bepb.SongReview{Id: approved, Approve: true})
+	playlist := server.queueMgr.GetPlaylist().Songs
+	if !response.Success || len(playlist) != 1 || playlist[0].ServiceId != "long" || playlist[0].SongId == 0 {
+		t.Fatalf("Expected the approved song to be queued, got %v and %v", response, playlist)
+	}
+
+	if response, _ = server.ReviewSong(context.Background(), &bepb.SongReview{Id: approved}); response.Success {
+		t.Error("Expected a song to only be reviewed once")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Links new devices, like a TV or a guest's phone, to a user signed in on
+ * another device so nobody has to type a username with a remote. The new
+ * device asks for a short code and shows it, along with a QR code, and the
+ * user confirms the code on a device they're already signed in on. The new
+ * device then redeems the code, with a secret only it knows, and is signed in
+ * as that user.
+ */
+
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginCodeTtl       = 5 * time.Minute // how long a code can be confirmed and redeemed
+	loginSecretLength  = 16              // random bytes in the secret kept by the new device
+	maxLoginCodeTrials = 5               // attempts at generating an unused code
+)
+
+var (
+	ErrLoginCodeNotFound     = errors.New("That code has expired or doesn't exist.")
+	ErrLoginCodeNotConfirmed = errors.New("The code hasn't been confirmed yet.")
+	ErrLoginCodeConfirmed    = errors.New("That code was already confirmed.")
+)
+
+/*
+ * A code handed out to a new device
+ */
+type loginCode struct {
+	secret  string    // secret the new device redeems the code with
+	userId  uint32    // user who confirmed the code. Zero until confirmed
+	expires time.Time // when the code stops working
+}
+
+/*
+ * Keeps track of the codes handed out to new devices
+ */
+type loginCodeTracker struct {
+	codes map[string]*loginCode // code -> what it was handed out for
+	lock  sync.Mutex            // lock on the codes
+}
+
+/*
+ * Initialize the tracker
+ */
+func (t *loginCodeTracker) init() {
+	t.codes = make(map[string]*loginCode)
+}
+
+/*
+ * Hand out a new code and the secret to redeem it with. Expired codes are
+ * dropped first.
+ */
+func (t *loginCodeTracker) create(now time.Time) (string, string, error) {
+	raw := make([]byte, loginSecretLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := hex.EncodeToString(raw)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for code, pending := range t.codes {
+		if !now.Before(pending.expires) {
+			delete(t.codes, code)
+		}
+	}
+
+	for attempt := 0; attempt < maxLoginCodeTrials; attempt++ {
+		code, err := generateShareCode()
+		if err != nil {
+			return "", "", err
+		}
+
+		if _, taken := t.codes[code]; !taken {
+			t.codes[code] = &loginCode{secret: secret, expires: now.Add(loginCodeTtl)}
+			return code, secret, nil
+		}
+	}
+
+	return "", "", errors.New("Failed to generate an unused login code.")
+}
+
+/*
+ * Confirm a code on behalf of the signed in user. A code can only be
+ * confirmed once, so nobody else who sees it on screen can take it over.
+ */
+func (t *loginCodeTracker) confirm(code string, userId uint32, now time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	pending := t.find(code, now)
+	if pending == nil {
+		return ErrLoginCodeNotFound
+	}
+
+	if pending.userId != 0 {
+		return ErrLoginCodeConfirmed
+	}
+
+	pending.userId = userId
+	return nil
+}
+
+/*
+ * Redeem a confirmed code with its secret. Returns the id of the user who
+ * confirmed it. The code is used up once redeemed.
+ */
+func (t *loginCodeTracker) redeem(code string, secret string, now time.Time) (uint32, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	pending := t.find(code, now)
+	if pending == nil || subtle.ConstantTimeCompare([]byte(pending.secret), []byte(secret)) != 1 {
+		return 0, ErrLoginCodeNotFound
+	}
+
+	if pending.userId == 0 {
+		return 0, ErrLoginCodeNotConfirmed
+	}
+
+	delete(t.codes, normalizeLoginCode(code))
+	return pending.userId, nil
+}
+
+/*
+ * Returns the code if it exists and hasn't expired. Codes are matched however
+ * they were typed in. Expects the lock to be held.
+ */
+func (t *loginCodeTracker) find(code string, now time.Time) *loginCode {
+	pending, exists := t.codes[normalizeLoginCode(code)]
+	if !exists || !now.Before(pending.expires) {
+		return nil
+	}
+
+	return pending
+}
+
+/*
+ * Returns the code the way it was handed out, in upper case without spaces
+ */
+func normalizeLoginCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestLoginCodes_redeemOnlyOnceConfirmed(t *testing.T) {
+	codes := new(loginCodeTracker)
+	codes.init()
+	now := time.Now()
+
+	code, secret, err := codes.create(now)
+	if err != nil || len(code) != shareCodeLength || secret == "" {
+		t.Fatalf("Expected a code and a secret, got %q, %q, %v", code, secret, err)
+	}
+
+	if _, err := codes.redeem(code, secret, now); err != ErrLoginCodeNotConfirmed {
+		t.Errorf("Expected the unconfirmed code to wait, got %v", err)
+	}
+
+	// codes can be typed in lower case with spaces
+	typed := " " + code[:3] + " " + code[3:]
+	if err := codes.confirm(typed, 7, now); err != nil {
+		t.Fatalf("Expected the code to be confirmed, got %v", err)
+	}
+
+	if _, err := codes.redeem(code, "guessed", now); err != ErrLoginCodeNotFound {
+		t.Errorf("Expected the wrong secret to be turned away, got %v", err)
+	}
+
+	if userId, err := codes.redeem(code, secret, now); err != nil || userId != 7 {
+		t.Errorf("Expected user 7 signed in, got %d, %v", userId, err)
+	}
+
+	if _, err := codes.redeem(code, secret, now); err != ErrLoginCodeNotFound {
+		t.Errorf("Expected the code to be used up, got %v", err)
+	}
+}
+
+func TestLoginCodes_confirmOnlyOnce(t *testing.T) {
+	codes := new(loginCodeTracker)
+	codes.init()
+	now := time.Now()
+
+	code, secret, _ := codes.create(now)
+	if err := codes.confirm(code, 7, now); err != nil {
+		t.Fatalf("Expected the code to be confirmed, got %v", err)
+	}
+
+	// someone else who saw the code can't take the device over
+	if err := codes.confirm(code, 8, now); err != ErrLoginCodeConfirmed {
+		t.Errorf("Expected the second confirmation to be turned away, got %v", err)
+	}
+
+	if userId, err := codes.redeem(code, secret, now); err != nil || userId != 7 {
+		t.Errorf("Expected user 7 signed in, got %d, %v", userId, err)
+	}
+}
+
+func TestLoginCodes_expire(t *testing.T) {
+	codes := new(loginCodeTracker)
+	codes.init()
+	now := time.Now()
+
+	code, _, _ := codes.create(now)
+	if err := codes.confirm(code, 7, now.Add(loginCodeTtl)); err != ErrLoginCodeNotFound {
+		t.Errorf("Expected the expired code to be turned away, got %v", err)
+	}
+
+	// expired codes are dropped when the next one is made
+	codes.create(now.Add(loginCodeTtl))
+	if len(codes.codes) != 1 {
+		t.Errorf("Expected only the new code kept, got %d codes", len(codes.codes))
+	}
+}
+
+func TestRedeemLoginCode_signsInConfirmingUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_login_codes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	code, _ := server.CreateLoginCode(context.Background(), &cmpb.Empty{})
+	if !code.Err.Success || code.ExpiresIn == 0 {
+		t.Fatalf("Expected a login code, got %v", code)
+	}
+
+	response, _ := server.ConfirmLoginCode(context.Background(),
+		&bepb.LoginCodeConfirmation{UserId: bob.User.UserId, Code: code.Code})
+	if !response.Success {
+		t.Fatalf("Expected the code to be confirmed, got %v", response)
+	}
+
+	user, _ := server.RedeemLoginCode(context.Background(), &bepb.LoginCode{Code: code.Code, Secret: code.Secret})
+	if !user.Err.Success || user.UserId != bob.User.UserId || user.Username != "Bob" || user.RoomId != room.Room.Id {
+		t.Errorf("Expected the device signed in as Bob, got %v", user)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+ * Fetches the lyrics of the songs being played so the web UI or a TV overlay
+ * can show them for singing along. Lyrics come from a configurable provider
+ * and are cached in the database, along with songs the provider had no lyrics
+ * for, so each song is only looked up once. Songs without lyrics are looked up
+ * again after a while in case the provider added them.
+ */
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	lyricsTimeout = 10 * time.Second   // how long to wait on the provider
+	lyricsRetry   = 7 * 24 * time.Hour // how long before looking up a song without lyrics again
+	lyricsAgent   = "ytbox-go"         // user agent sent to the providers
+
+	lrclibUrl    = "https://lrclib.net"     // default address of lrclib
+	lyricsOvhUrl = "https://api.lyrics.ovh" // default address of lyrics.ovh
+)
+
+var (
+	ErrLyricsDisabled        = errors.New("Lyrics are turned off.")
+	ErrLyricsNotFound        = errors.New("No lyrics were found for this song.")
+	ErrUnknownLyricsProvider = errors.New("Unknown lyrics provider")
+)
+
+/*
+ * A service that lyrics can be looked up from
+ */
+type lyricsProvider interface {
+	// name of the provider, recorded with the lyrics it found
+	name() string
+
+	// Look up the lyrics of a song. The artist may be empty and the length of
+	// the song in seconds may be zero if they aren't known. Returns
+	// ErrLyricsNotFound if the provider has no lyrics for the song.
+	fetch(artist string, track string, seconds float64) (*bepb.Lyrics, error)
+}
+
+/*
+ * Create the lyrics provider with the given name. Returns nil if the name is
+ * empty, which turns lyrics off.
+ */
+func newLyricsProvider(name string) (lyricsProvider, error) {
+	client := &http.Client{Timeout: lyricsTimeout}
+
+	switch name {
+	case "":
+		return nil, nil
+	case "lrclib":
+		return &lrclibProvider{baseUrl: lrclibUrl, client: client}, nil
+	case "lyricsovh":
+		return &lyricsOvhProvider{baseUrl: lyricsOvhUrl, client: client}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLyricsProvider, name)
+	}
+}
+
+/*
+ * Looks up and caches the lyrics of songs
+ */
+type lyricsFinder struct {
+	provider  lyricsProvider           // where lyrics are looked up. Nil if lyrics are turned off
+	dbManager db.DbManager             // caches the lyrics
+	pending   map[string]chan struct{} // closed when the song being looked up is cached
+	lock      sync.Mutex               // lock on the pending lookups
+}
+
+/*
+ * Initialize the lyrics finder
+ */
+func (f *lyricsFinder) init(provider lyricsProvider, dbManager db.DbManager) {
+	f.provider = provider
+	f.dbManager = dbManager
+	f.pending = make(map[string]chan struct{})
+}
+
+/*
+ * Start looking up the lyrics of each song as it starts playing, so they're
+ * cached by the time a display asks for them
+ */
+func (f *lyricsFinder) subscribe(bus *eventBus) {
+	bus.subscribe(func(event *bepb.Event) {
+		if f.provider != nil && event.Song != nil && !event.Song.Jingle {
+			go f.find(event.Song)
+		}
+	}, bepb.EventType_SongPlaying)
+}
+
+/*
+ * Returns the lyrics of a song, looking them up if they aren't cached. Only
+ * one lookup runs for a song at a time and the others wait on it.
+ */
+func (f *lyricsFinder) find(song *cmpb.Song) (*bepb.Lyrics, error) {
+	if f.provider == nil {
+		return nil, ErrLyricsDisabled
+	}
+
+	key := fmt.Sprintf("%d:%s", song.Service, song.ServiceId)
+	for {
+		if lyrics, ok := f.cached(song); ok {
+			return lyrics, foundLyrics(lyrics)
+		}
+
+		f.lock.Lock()
+		done, waiting := f.pending[key]
+		if !waiting {
+			done = make(chan struct{})
+			f.pending[key] = done
+		}
+		f.lock.Unlock()
+
+		if waiting {
+			<-done
+			if lyrics, ok := f.cached(song); ok {
+				return lyrics, foundLyrics(lyrics)
+			}
+			continue
+		}
+
+		lyrics, err := f.lookup(song)
+
+		f.lock.Lock()
+		delete(f.pending, key)
+		close(done)
+		f.lock.Unlock()
+
+		return lyrics, err
+	}
+}
+
+/*
+ * Returns the cached lyrics of a song. Cached songs without lyrics are treated
+ * as missing once they're due to be looked up again.
+ */
+func (f *lyricsFinder) cached(song *cmpb.Song) (*bepb.Lyrics, bool) {
+	data, err := f.dbManager.GetLyrics(song.Service, song.ServiceId)
+	if err != nil {
+		return nil, false
+	}
+
+	if foundLyrics(&data.Lyrics) != nil && time.Since(data.FetchDate) >= lyricsRetry {
+		return nil, false
+	}
+
+	return &data.Lyrics, true
+}
+
+/*
+ * Look up the lyrics of a song from the provider and cache them
+ */
+func (f *lyricsFinder) lookup(song *cmpb.Song) (*bepb.Lyrics, error) {
+	title := song.CleanTitle
+	if title == "" {
+		title = cleanTitle(song.Title)
+	}
+
+	artist, track := splitArtist(title)
+	lyrics, err := f.provider.fetch(artist, track, songSeconds(song))
+	if errors.Is(err, ErrLyricsNotFound) {
+		lyrics = &bepb.Lyrics{}
+	} else if err != nil {
+		log.Printf("Failed to fetch lyrics for %s from %s: %v", song.ServiceId, f.provider.name(), err)
+		return nil, err
+	}
+
+	lyrics.Provider = f.provider.name()
+	f.dbManager.AddLyrics(song.Service, song.ServiceId, lyrics)
+	return lyrics, foundLyrics(lyrics)
+}
+
+/*
+ * Returns ErrLyricsNotFound if the lyrics are empty
+ */
+func foundLyrics(lyrics *bepb.Lyrics) error {
+	if lyrics.Plain == "" && lyrics.Synced == "" {
+		return ErrLyricsNotFound
+	}
+
+	return nil
+}
+
+/*
+ * Split a cleaned up title into the artist and track. The artist is empty if
+ * the title doesn't name one. Featured artists are left off the track since
+ * providers list them inconsistently.
+ */
+func splitArtist(title string) (string, string) {
+	artist := ""
+	track := title
+	if parts := strings.SplitN(title, " - ", 2); len(parts) == 2 {
+		artist = strings.TrimSpace(parts[0])
+		track = parts[1]
+	}
+
+	if i := strings.Index(track, " feat. "); i >= 0 {
+		track = track[:i]
+	}
+
+	return artist, strings.TrimSpace(track)
+}
+
+/*
+ * Decode the json response of a GET request to a provider. Returns
+ * ErrLyricsNotFound if the provider responds with not found.
+ */
+func getLyricsJson(client *http.Client, link string, out interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", lyricsAgent)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(response.Body).Decode(out)
+	case http.StatusNotFound:
+		return ErrLyricsNotFound
+	default:
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+}
+
+/*
+ * Looks up lyrics from lrclib, which has synced lyrics for many songs
+ */
+type lrclibProvider struct {
+	baseUrl string       // address of the lrclib api
+	client  *http.Client // client used for requests
+}
+
+/*
+ * A search result from lrclib
+ */
+type lrclibResult struct {
+	Duration     float64 `json:"duration"`
+	Instrumental bool    `json:"instrumental"`
+	PlainLyrics  string  `json:"plainLyrics"`
+	SyncedLyrics string  `json:"syncedLyrics"`
+}
+
+func (p *lrclibProvider) name() string {
+	return "lrclib"
+}
+
+/*
+ * Search lrclib for the song and pick the result closest in length to it
+ */
+func (p *lrclibProvider) fetch(artist string, track string, seconds float64) (*bepb.Lyrics, error) {
+	query := url.Values{}
+	if artist == "" {
+		query.Set("q", track)
+	} else {
+		query.Set("artist_name", artist)
+		query.Set("track_name", track)
+	}
+
+	var results []lrclibResult
+	if err := getLyricsJson(p.client, p.baseUrl+"/api/search?"+query.Encode(), &results); err != nil {
+		return nil, err
+	}
+
+	var best *lrclibResult
+	for i := range results {
+		result := &results[i]
+		if result.Instrumental || result.PlainLyrics == "" {
+			continue
+		}
+
+		if best == nil || math.Abs(result.Duration-seconds) < math.Abs(best.Duration-seconds) {
+			best = result
+		}
+
+		if seconds == 0 {
+			break
+		}
+	}
+
+	if best == nil {
+		return nil, ErrLyricsNotFound
+	}
+
+	return &bepb.Lyrics{Plain: best.PlainLyrics, Synced: best.SyncedLyrics}, nil
+}
+
+/*
+ * Looks up plain lyrics from lyrics.ovh, which needs to know the artist
+ */
+type lyricsOvhProvider struct {
+	baseUrl string       // address of the lyrics.ovh api
+	client  *http.Client // client used for requests
+}
+
+func (p *lyricsOvhProvider) name() string {
+	return "lyricsovh"
+}
+
+/*
+ * Look up the lyrics of the artist's track
+ */
+func (p *lyricsOvhProvider) fetch(artist string, track string, seconds float64) (*bepb.Lyrics, error) {
+	if artist == "" {
+		return nil, ErrLyricsNotFound
+	}
+
+	var result struct {
+		Lyrics string `json:"lyrics"`
+	}
+
+	link := fmt.Sprintf("%s/v1/%s/%s", p.baseUrl, url.PathEscape(artist), url.PathEscape(track))
+	if err := getLyricsJson(p.client, link, &result); err != nil {
+		return nil, err
+	}
+
+	plain := strings.TrimSpace(result.Lyrics)
+	if plain == "" {
+		return nil, ErrLyricsNotFound
+	}
+
+	return &bepb.Lyrics{Plain: plain}, nil
+}
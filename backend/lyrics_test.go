@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Lyrics provider that counts its lookups
+ */
+type countingProvider struct {
+	lyrics  *bepb.Lyrics // returned lyrics. Nil returns ErrLyricsNotFound
+	fetches int          // number of lookups
+	artist  string       // artist of the last lookup
+	track   string       // track of the last lookup
+}
+
+func (p *countingProvider) name() string {
+	return "counting"
+}
+
+func (p *countingProvider) fetch(artist string, track string, seconds float64) (*bepb.Lyrics, error) {
+	p.fetches++
+	p.artist, p.track = artist, track
+	if p.lyrics == nil {
+		return nil, ErrLyricsNotFound
+	}
+	return &bepb.Lyrics{Plain: p.lyrics.Plain, Synced: p.lyrics.Synced}, nil
+}
+
+func testLyricsFinder(t *testing.T, provider lyricsProvider) (*lyricsFinder, func()) {
+	dir, err := ioutil.TempDir("", "ytbox_lyrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbManager := new(db.SqliteManager)
+	if err = dbManager.Init(filepath.Join(dir, "ytbox.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	finder := new(lyricsFinder)
+	finder.init(provider, dbManager)
+	return finder, func() {
+		dbManager.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSplitArtist(t *testing.T) {
+	tests := []struct {
+		title  string
+		artist string
+		track  string
+	}{
+		{"Artist - Song", "Artist", "Song"},
+		{"Artist - Song feat. Guest", "Artist", "Song"},
+		{"Artist - Song - Live", "Artist", "Song - Live"},
+		{"Just A Song", "", "Just A Song"},
+	}
+
+	for _, test := range tests {
+		artist, track := splitArtist(test.title)
+		if artist != test.artist || track != test.track {
+			t.Errorf("splitArtist(%q) = %q, %q, expected %q, %q", test.title, artist, track, test.artist, test.track)
+		}
+	}
+}
+
+func TestLyricsFinder_find_cachesLyrics(t *testing.T) {
+	provider := &countingProvider{lyrics: &bepb.Lyrics{Plain: "la la la"}}
+	finder, cleanUp := testLyricsFinder(t, provider)
+	defer cleanUp()
+
+	song := &cmpb.Song{Title: "Artist - Song (Official Video)", ServiceId: "abc", Service: cmpb.ServiceType_Youtube}
+	for i := 0; i < 2; i++ {
+		lyrics, err := finder.find(song)
+		if err != nil || lyrics.Plain != "la la la" || lyrics.Provider != "counting" {
+			t.Fatalf("Expected the provider's lyrics, got %v, %v", lyrics, err)
+		}
+	}
+
+	if provider.fetches != 1 {
+		t.Errorf("Expected lyrics to be fetched once, but were fetched %d times", provider.fetches)
+	}
+
+	if provider.artist != "Artist" || provider.track != "Song" {
+		t.Errorf("Expected a lookup of Artist - Song, got %q - %q", provider.artist, provider.track)
+	}
+}
+
+func TestLyricsFinder_find_cachesMisses(t *testing.T) {
+	provider := &countingProvider{}
+	finder, cleanUp := testLyricsFinder(t, provider)
+	defer cleanUp()
+
+	song := &cmpb.Song{Title: "Obscure Song", ServiceId: "abc", Service: cmpb.ServiceType_Youtube}
+	for i := 0; i < 2; i++ {
+		if _, err := finder.find(song); !errors.Is(err, ErrLyricsNotFound) {
+			t.Fatalf("Expected %v, got %v", ErrLyricsNotFound, err)
+		}
+	}
+
+	if provider.fetches != 1 {
+		t.Errorf("Expected lyrics to be looked up once, but were looked up %d times", provider.fetches)
+	}
+}
+
+func TestLyricsFinder_find_whenDisabled_returnsErr(t *testing.T) {
+	finder := new(lyricsFinder)
+	finder.init(nil, nil)
+
+	if _, err := finder.find(&cmpb.Song{}); err != ErrLyricsDisabled {
+		t.Errorf("Expected %v, got %v", ErrLyricsDisabled, err)
+	}
+}
+
+func TestLrclibProvider_fetch_picksClosestDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/search" || r.URL.Query().Get("artist_name") != "Artist" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"duration": 300, "plainLyrics": "extended"},
+			{"duration": 200, "instrumental": true},
+			{"duration": 181, "plainLyrics": "radio edit", "syncedLyrics": "[00:01.00] radio edit"}
+		]`)
+	}))
+	defer server.Close()
+
+	provider := &lrclibProvider{baseUrl: server.URL, client: server.Client()}
+	lyrics, err := provider.fetch("Artist", "Song", 180)
+	if err != nil {
+		t.Fatalf("Failed to fetch lyrics: %v", err)
+	}
+
+	if lyrics.Plain != "radio edit" || lyrics.Synced == "" {
+		t.Errorf("Expected the lyrics closest in length, got %v", lyrics)
+	}
+}
+
+func TestLyricsOvhProvider_fetch_whenNotFound_returnsErr(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	provider := &lyricsOvhProvider{baseUrl: server.URL, client: server.Client()}
+	if _, err := provider.fetch("Artist", "Song", 0); err != ErrLyricsNotFound {
+		t.Errorf("Expected %v, got %v", ErrLyricsNotFound, err)
+	}
+
+	// lyrics.ovh can't look songs up without an artist
+	if _, err := provider.fetch("", "Song", 0); err != ErrLyricsNotFound {
+		t.Errorf("Expected %v, got %v", ErrLyricsNotFound, err)
+	}
+}
+
+func TestNewLyricsProvider_whenUnknown_returnsErr(t *testing.T) {
+	if _, err := newLyricsProvider("nope"); !errors.Is(err, ErrUnknownLyricsProvider) {
+		t.Errorf("Expected %v, got %v", ErrUnknownLyricsProvider, err)
+	}
+
+	if provider, err := newLyricsProvider(""); provider != nil || err != nil {
+		t.Errorf("Expected lyrics to be turned off, got %v, %v", provider, err)
+	}
+}
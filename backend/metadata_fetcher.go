@@ -0,0 +1,212 @@
+/*
+ * Routes submitted links to the fetcher that gets their metadata. Links are
+ * sorted by the service they belong to, so YouTube links can go through the
+ * YouTube api while links to other sites go through yt-dlp. Which fetcher
+ * handles each service is configurable. A fetch that succeeds without a real
+ * title, like for a video that was made private, is tried again with the
+ * other fetcher when the service has one, since a blank title in the queue
+ * leaves everyone guessing at what's playing.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/nguyenmq/ytbox-go/links"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Services that submitted links can belong to
+ */
+const (
+	ServiceYoutube = "youtube" // YouTube videos
+	ServiceLocal   = "local"   // files on the backend's disk
+	ServiceWeb     = "web"     // pages on any other site
+)
+
+/*
+ * Fetchers that can be picked in the config
+ */
+const (
+	FetcherBuiltin = "builtin" // the YouTube api and the tags of local files
+	FetcherYtDlp   = "ytdlp"   // yt-dlp, which supports hundreds of sites
+)
+
+var ErrUnknownFetcher = errors.New("Unknown metadata fetcher")
+var ErrUnknownService = errors.New("Unknown service")
+var ErrBlankTitle = errors.New("Couldn't read the song's title. It may be private or deleted. Please pick another video.")
+
+/*
+ * Titles services give videos they can't show, compared by their title keys
+ */
+var placeholderTitles = map[string]bool{
+	"deletedvideo": true,
+	"privatevideo": true,
+	"untitled":     true,
+	"notitle":      true,
+	"youtube":      true,
+}
+
+/*
+ * Fetches the metadata of submitted links
+ */
+type MetadataFetcher interface {
+	// Populate the song with the title, service, service id and metadata of
+	// the link. Age restricted and region blocked videos are populated, but
+	// return ErrAgeRestricted or ErrRegionBlocked.
+	FetchSongData(link string, song *cmpb.Song) error
+}
+
+/*
+ * Fetcher used for each service unless the config picks another
+ */
+var defaultFetchers = map[string]string{
+	ServiceYoutube: FetcherBuiltin,
+	ServiceLocal:   FetcherBuiltin,
+	ServiceWeb:     FetcherYtDlp,
+}
+
+/*
+ * Returns the service a link belongs to, or an empty string if the link isn't
+ * one the backend can play
+ */
+func linkService(link string) string {
+	switch links.Parse(link).Kind {
+	case links.Youtube:
+		return ServiceYoutube
+	case links.LocalFile:
+		return ServiceLocal
+	case links.Web:
+		return ServiceWeb
+	default:
+		return ""
+	}
+}
+
+/*
+ * Returns the name of the fetcher to use for each service, with the choices in
+ * the config taking the place of the defaults
+ */
+func fetcherRoutes(config map[string]string) (map[string]string, error) {
+	routes := make(map[string]string, len(defaultFetchers))
+	for service, fetcher := range defaultFetchers {
+		routes[service] = fetcher
+	}
+
+	for service, fetcher := range config {
+		if _, exists := defaultFetchers[service]; !exists {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownService, service)
+		}
+
+		if fetcher != FetcherBuiltin && fetcher != FetcherYtDlp {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFetcher, fetcher)
+		}
+
+		routes[service] = fetcher
+	}
+
+	return routes, nil
+}
+
+/*
+ * Returns true if a fetched song doesn't have a real title: it's empty, a
+ * placeholder like "Private video" or just the video's id
+ */
+func blankTitle(song *cmpb.Song) bool {
+	title := strings.TrimSpace(song.Title)
+	return title == "" || placeholderTitles[titleKey(title)] ||
+		(song.Service == cmpb.ServiceType_Youtube && title == song.ServiceId)
+}
+
+/*
+ * Sends each link to the fetcher of its service
+ */
+type fetcherRouter struct {
+	fetchers  map[string]MetadataFetcher // service -> fetcher
+	fallbacks map[string]MetadataFetcher // service -> fetcher tried when the first gets a blank title
+}
+
+/*
+ * Initialize the router with the fetchers picked for each service. Fetchers
+ * given for a service take the place of the picked ones. YouTube, the one
+ * service both fetchers can read, falls back on the fetcher that wasn't
+ * picked.
+ */
+func (r *fetcherRouter) init(routes map[string]string, available map[string]MetadataFetcher,
+	custom map[string]MetadataFetcher) {
+
+	r.fetchers = make(map[string]MetadataFetcher, len(routes))
+	r.fallbacks = make(map[string]MetadataFetcher)
+	for service, name := range routes {
+		r.fetchers[service] = available[name]
+	}
+
+	if name, exists := routes[ServiceYoutube]; exists {
+		other := FetcherYtDlp
+		if name == FetcherYtDlp {
+			other = FetcherBuiltin
+		}
+
+		if fallback := available[other]; fallback != nil {
+			r.fallbacks[ServiceYoutube] = fallback
+		}
+	}
+
+	for service, fetcher := range custom {
+		r.fetchers[service] = fetcher
+		delete(r.fallbacks, service)
+	}
+}
+
+/*
+ * Fetch the metadata of a link with the fetcher of its service. A song
+ * fetched without a real title is fetched again with the service's fallback,
+ * whose song is kept if it has a title.
+ */
+func (r *fetcherRouter) FetchSongData(link string, song *cmpb.Song) error {
+	service := linkService(link)
+	fetcher, exists := r.fetchers[service]
+	if !exists || fetcher == nil {
+		return fmt.Errorf("%w: unknown link submitted: %s", ErrUnknownSong, link)
+	}
+
+	err := fetcher.FetchSongData(link, song)
+	fallback := r.fallbacks[service]
+	if fallback == nil || (err != nil && !isRestricted(err)) || !blankTitle(song) {
+		return err
+	}
+
+	log.Printf("Got a blank title for %s, fetching it again another way", link)
+	retried := new(cmpb.Song)
+	retryErr := fallback.FetchSongData(link, retried)
+	if (retryErr != nil && !isRestricted(retryErr)) || blankTitle(retried) {
+		return err
+	}
+
+	// the fetched fields are replaced while the caller's are kept
+	song.Metadata = nil
+	proto.Merge(song, retried)
+	return retryErr
+}
+
+/*
+ * Log a warning if links are routed to yt-dlp, but it isn't installed
+ */
+func warnMissingYtDlp(routes map[string]string, installed bool) {
+	if installed {
+		return
+	}
+
+	for service, fetcher := range routes {
+		if fetcher == FetcherYtDlp {
+			log.Printf("Links to %s are fetched with %s, but it isn't installed", service, ytDlpCommand)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nguyenmq/ytbox-go/links"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Metadata fetcher that records the links it was asked to fetch
+ */
+type recordingFetcher struct {
+	links []string
+}
+
+func (f *recordingFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	f.links = append(f.links, link)
+	song.Title = link
+	return nil
+}
+
+func TestLinkService(t *testing.T) {
+	tests := map[string]string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ": ServiceYoutube,
+		"youtu.be/dQw4w9WgXcQ":                        ServiceYoutube,
+		"/music/song.flac":                            ServiceLocal,
+		"https://soundcloud.com/artist/track":         ServiceWeb,
+		"ftp://example.com/song.mp3":                  "",
+	}
+
+	for link, expected := range tests {
+		if service := linkService(link); service != expected {
+			t.Errorf("linkService(%q) = %q, expected %q", link, service, expected)
+		}
+	}
+}
+
+func TestFetcherRoutes_whenConfigured_overridesDefaults(t *testing.T) {
+	routes, err := fetcherRoutes(map[string]string{ServiceYoutube: FetcherYtDlp})
+	if err != nil {
+		t.Fatalf("Failed to get routes: %v", err)
+	}
+
+	if routes[ServiceYoutube] != FetcherYtDlp || routes[ServiceLocal] != FetcherBuiltin || routes[ServiceWeb] != FetcherYtDlp {
+		t.Errorf("Unexpected routes: %v", routes)
+	}
+}
+
+func TestFetcherRoutes_whenUnknown_returnsErr(t *testing.T) {
+	if _, err := fetcherRoutes(map[string]string{"vimeo": FetcherYtDlp}); !errors.Is(err, ErrUnknownService) {
+		t.Errorf("Expected %v, got %v", ErrUnknownService, err)
+	}
+
+	if _, err := fetcherRoutes(map[string]string{ServiceWeb: "curl"}); !errors.Is(err, ErrUnknownFetcher) {
+		t.Errorf("Expected %v, got %v", ErrUnknownFetcher, err)
+	}
+}
+
+func TestFetcherRouter_routesByService(t *testing.T) {
+	builtin := new(recordingFetcher)
+	ytDlp := new(recordingFetcher)
+	custom := new(recordingFetcher)
+
+	router := new(fetcherRouter)
+	router.init(defaultFetchers, map[string]MetadataFetcher{FetcherBuiltin: builtin, FetcherYtDlp: ytDlp},
+		map[string]MetadataFetcher{ServiceLocal: custom})
+
+	links := []string{"https://youtu.be/dQw4w9WgXcQ", "https://vimeo.com/12345", "/music/song.mp3"}
+	for _, link := range links {
+		if err := router.FetchSongData(link, new(cmpb.Song)); err != nil {
+			t.Fatalf("Failed to fetch %s: %v", link, err)
+		}
+	}
+
+	if len(builtin.links) != 1 || len(ytDlp.links) != 1 || len(custom.links) != 1 {
+		t.Errorf("Expected one link per fetcher, got %v, %v and %v", builtin.links, ytDlp.links, custom.links)
+	}
+
+	if err := router.FetchSongData("ftp://example.com/song.mp3", new(cmpb.Song)); err == nil {
+		t.Errorf("Expected an error for a link without a service")
+	}
+}
+
+func TestSongFromYtDlp_whenYoutube_keepsVideoId(t *testing.T) {
+	song := new(cmpb.Song)
+	info := &ytDlpInfo{Id: "dQw4w9WgXcQ", Title: "Never Gonna Give You Up", Duration: 212.4,
+		ExtractorKey: ytDlpYoutube, WebpageUrl: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+
+	if err := songFromYtDlp(info, song); err != nil {
+		t.Fatalf("Failed to make song: %v", err)
+	}
+
+	if song.Service != cmpb.ServiceType_Youtube || song.ServiceId != "dQw4w9WgXcQ" {
+		t.Errorf("Expected a YouTube song, got %v", song)
+	}
+
+	if song.Metadata.Duration != "PT3M32S" {
+		t.Errorf("Expected a duration of PT3M32S, got %s", song.Metadata.Duration)
+	}
+}
+
+func TestSongFromYtDlp_whenOtherSite_usesPage(t *testing.T) {
+	song := new(cmpb.Song)
+	info := &ytDlpInfo{Id: "12345", Title: "Untitled", Artist: "Artist", Track: "Track", Duration: 3700,
+		ExtractorKey: "Soundcloud", WebpageUrl: "https://soundcloud.com/artist/track", AgeLimit: 18}
+
+	if err := songFromYtDlp(info, song); err != ErrAgeRestricted {
+		t.Errorf("Expected %v, got %v", ErrAgeRestricted, err)
+	}
+
+	if song.Service != cmpb.ServiceType_Web || song.ServiceId != info.WebpageUrl || song.Title != "Artist - Track" {
+		t.Errorf("Expected a web song, got %v", song)
+	}
+
+	if song.Metadata.Duration != "PT1H1M40S" {
+		t.Errorf("Expected a duration of PT1H1M40S, got %s", song.Metadata.Duration)
+	}
+}
+
+/*
+ * Metadata fetcher that gives every link the same title
+ */
+type titleFetcher struct {
+	title string
+	calls int
+}
+
+func (f *titleFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	f.calls++
+	song.Title = f.title
+	song.Service = cmpb.ServiceType_Youtube
+	song.ServiceId = links.VideoId(link)
+	song.Metadata = &cmpb.Metadata{Duration: "PT3M"}
+	return nil
+}
+
+func TestFetcherRouter_whenTitleIsBlank_triesOtherFetcher(t *testing.T) {
+	builtin := &titleFetcher{title: "Private video"}
+	ytDlp := &titleFetcher{title: "Never Gonna Give You Up"}
+
+	router := new(fetcherRouter)
+	router.init(defaultFetchers, map[string]MetadataFetcher{FetcherBuiltin: builtin, FetcherYtDlp: ytDlp}, nil)
+
+	song := &cmpb.Song{UserId: 7}
+	if err := router.FetchSongData("https://youtu.be/dQw4w9WgXcQ", song); err != nil {
+		t.Fatalf("Failed to fetch: %v", err)
+	}
+
+	if song.Title != "Never Gonna Give You Up" || song.UserId != 7 || song.ServiceId != "dQw4w9WgXcQ" {
+		t.Errorf("Expected the title from yt-dlp with the caller's fields kept, got %v", song)
+	}
+
+	builtin.title = "Rick Astley - Never Gonna Give You Up"
+	router.FetchSongData("https://youtu.be/dQw4w9WgXcQ", new(cmpb.Song))
+	if ytDlp.calls != 1 {
+		t.Errorf("Expected yt-dlp to be left alone when the title is real, got %d calls", ytDlp.calls)
+	}
+}
+
+func TestBlankTitle(t *testing.T) {
+	tests := map[string]bool{
+		"":                        true,
+		"   ":                     true,
+		"[Deleted video]":         true,
+		"Private Video":           true,
+		"dQw4w9WgXcQ":             true,
+		"Never Gonna Give You Up": false,
+		"🔥🔥🔥":                     false,
+	}
+
+	for title, expected := range tests {
+		song := &cmpb.Song{Title: title, Service: cmpb.ServiceType_Youtube, ServiceId: "dQw4w9WgXcQ"}
+		if blank := blankTitle(song); blank != expected {
+			t.Errorf("blankTitle(%q) = %t, expected %t", title, blank, expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Serves the fairness reports of every zone as metrics in the Prometheus text
+ * format, so hosts can graph and alert on how the queue treats its users with
+ * the monitoring they already run, along with the circuit breakers on calls to
+ * outside services. The metrics are served over plain http on their own
+ * address, apart from the grpc server.
+ */
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	metricsPath            = "/metrics"                                 // path the metrics are served on
+	metricsShutdownTimeout = 5 * time.Second                            // how long stopping waits for scrapes in progress
+	metricsContentType     = "text/plain; version=0.0.4; charset=utf-8" // content type of the Prometheus text format
+)
+
+/*
+ * A metric and how to read its value for a user
+ */
+type userMetric struct {
+	name  string                           // name of the metric
+	help  string                           // description shown by Prometheus
+	kind  string                           // gauge or counter
+	value func(*bepb.UserFairness) float64 // the user's value
+}
+
+/*
+ * The per user metrics exported from the fairness reports
+ */
+var userMetrics = []userMetric{
+	{"ytbox_user_pending_songs", "Songs the user has waiting in the queue.", "gauge",
+		func(u *bepb.UserFairness) float64 { return float64(u.Pending) }},
+	{"ytbox_user_played_seconds_total", "Seconds the user's songs played since the server started.", "counter",
+		func(u *bepb.UserFairness) float64 { return u.PlayedSeconds }},
+	{"ytbox_user_rotation_round", "Round of the rotation the user's latest song was queued in.", "gauge",
+		func(u *bepb.UserFairness) float64 { return float64(u.Round) }},
+	{"ytbox_user_next_position", "Position of the user's next song in the queue. Zero if nothing is waiting.",
+		"gauge", func(u *bepb.UserFairness) float64 { return float64(u.NextPosition) }},
+}
+
+/*
+ * Start serving the metrics on the address in the background
+ */
+func (s *BackendServer) startMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		s.writeMetrics(w, time.Now())
+	})
+
+	s.metricsServer = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Failed to serve metrics on %s: %v", addr, err)
+		}
+	}()
+}
+
+/*
+ * Stop serving the metrics. Does nothing if they weren't served.
+ */
+func (s *BackendServer) stopMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+
+	if err := s.metricsServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop serving metrics: %v", err)
+	}
+}
+
+/*
+ * Write the fairness reports of every zone and the state of the fetch breakers
+ * in the Prometheus text format
+ */
+func (s *BackendServer) writeMetrics(w io.Writer, now time.Time) {
+	zones := s.zones.list()
+	reports := make([]*bepb.FairnessReport, len(zones))
+	for i, zone := range zones {
+		reports[i] = s.fairnessReport(zone, now)
+	}
+
+	fmt.Fprintf(w, "# HELP ytbox_rotation_round Current round of the zone's rotation.\n")
+	fmt.Fprintf(w, "# TYPE ytbox_rotation_round gauge\n")
+	for i, report := range reports {
+		if report.Rotates {
+			fmt.Fprintf(w, "ytbox_rotation_round{zone=\"%s\"} %d\n", escapeLabel(zones[i].name), report.Round)
+		}
+	}
+
+	for _, metric := range userMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		for i, report := range reports {
+			for _, user := range report.Users {
+				fmt.Fprintf(w, "%s{zone=\"%s\",user_id=\"%d\",username=\"%s\"} %g\n", metric.name,
+					escapeLabel(zones[i].name), user.UserId, escapeLabel(user.Username), metric.value(user))
+			}
+		}
+	}
+
+	breakers := s.guard.stats(now)
+	fmt.Fprintf(w, "# HELP ytbox_fetch_breaker_open Whether calls to the service fail fast, 1 if they do.\n")
+	fmt.Fprintf(w, "# TYPE ytbox_fetch_breaker_open gauge\n")
+	for _, breaker := range breakers {
+		open := 0
+		if breaker.open {
+			open = 1
+		}
+		fmt.Fprintf(w, "ytbox_fetch_breaker_open{service=\"%s\"} %d\n", escapeLabel(breaker.service), open)
+	}
+
+	fmt.Fprintf(w, "# HELP ytbox_fetch_breaker_trips_total Times calls to the service started failing fast.\n")
+	fmt.Fprintf(w, "# TYPE ytbox_fetch_breaker_trips_total counter\n")
+	for _, breaker := range breakers {
+		fmt.Fprintf(w, "ytbox_fetch_breaker_trips_total{service=\"%s\"} %d\n", escapeLabel(breaker.service), breaker.trips)
+	}
+
+	fmt.Fprintf(w, "# HELP ytbox_fetch_failures_total Calls to the service that failed or timed out.\n")
+	fmt.Fprintf(w, "# TYPE ytbox_fetch_failures_total counter\n")
+	for _, breaker := range breakers {
+		fmt.Fprintf(w, "ytbox_fetch_failures_total{service=\"%s\"} %d\n", escapeLabel(breaker.service), breaker.failures)
+	}
+}
+
+/*
+ * Escape a label value for the Prometheus text format
+ */
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,82 @@
+/*
+ * Imports the queue files written by older versions of the backend, like
+ * /tmp/ytbox.queue. They're plain playlists without the rooms a snapshot
+ * carries, so the rooms are looked up in the database the old backend wrote
+ * to. Songs missing from the database are recorded in the history, then the
+ * songs are queued in the default zone.
+ */
+
+package backend
+
+import (
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const migratedRoomName = "Migrated" // room given to users whose room no longer exists
+
+/*
+ * Migrate the queue file at the path. Songs already queued are skipped, so
+ * migrating the same file twice is harmless. Returns the number of songs
+ * queued.
+ */
+func (s *BackendServer) migrateQueue(path string) (int, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// queue files are playlists, which parse as snapshots with only songs
+	snapshot := new(bepb.Snapshot)
+	if err = proto.Unmarshal(in, snapshot); err != nil {
+		return 0, err
+	}
+
+	if err = s.addMigratedRooms(snapshot); err != nil {
+		return 0, err
+	}
+
+	queued, _, err := s.restoreSnapshot(snapshot)
+	return queued, err
+}
+
+/*
+ * Fill in the rooms of the songs in a queue file from the database. Songs
+ * from rooms that no longer exist keep their usernames in the migrated room.
+ */
+func (s *BackendServer) addMigratedRooms(snapshot *bepb.Snapshot) error {
+	rooms, err := s.dbManager.GetRooms()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[uint32]string, len(rooms))
+	for _, room := range rooms {
+		names[room.Room.Id] = room.Room.Name
+	}
+
+	known := make(map[uint32]bool, len(snapshot.Rooms))
+	for _, room := range snapshot.Rooms {
+		known[room.Id] = true
+	}
+
+	for _, song := range append([]*cmpb.Song{snapshot.NowPlaying}, snapshot.Songs...) {
+		if song == nil || known[song.RoomId] {
+			continue
+		}
+
+		name, exists := names[song.RoomId]
+		if !exists {
+			name = migratedRoomName
+		}
+
+		snapshot.Rooms = append(snapshot.Rooms, &bepb.Room{Id: song.RoomId, Name: name})
+		known[song.RoomId] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestMigrateQueue_keepsUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	// the old backend recorded its songs in the same database
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	user, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	recorded := &cmpb.Song{Title: "recorded", Service: cmpb.ServiceType_Youtube, ServiceId: "recorded",
+		UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id}
+	server.dbManager.AddSong(recorded)
+
+	// this user's room was removed since
+	orphan := &cmpb.Song{Title: "orphan", Service: cmpb.ServiceType_Youtube, ServiceId: "orphan",
+		SongId: 99, UserId: 42, Username: "Ghost", RoomId: 7}
+
+	out, _ := proto.Marshal(&bepb.Playlist{Songs: []*cmpb.Song{recorded, orphan}})
+	path := filepath.Join(dir, "ytbox.queue")
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := server.migrateQueue(path)
+	if err != nil || queued != 2 {
+		t.Fatalf("Expected 2 songs queued, got %d and %v", queued, err)
+	}
+
+	playlist := server.queueMgr.GetPlaylist().Songs
+	if len(playlist) != 2 || playlist[0].SongId != recorded.SongId || playlist[0].UserId != user.User.UserId {
+		t.Fatalf("Expected the recorded song to keep its id and user, got %v", playlist)
+	}
+
+	migratedRoom, err := server.dbManager.GetRoomByName(migratedRoomName)
+	if err != nil || playlist[1].RoomId != migratedRoom.Room.Id || playlist[1].Username != "Ghost" {
+		t.Fatalf("Expected the orphaned song in the migrated room, got %v and %v", playlist[1], err)
+	}
+
+	if _, err := server.dbManager.GetSongById(playlist[1].SongId); err != nil {
+		t.Errorf("Expected the orphaned song to be recorded in the history: %v", err)
+	}
+
+	if queued, err := server.migrateQueue(path); err != nil || queued != 0 {
+		t.Errorf("Expected migrating again to queue nothing, got %d and %v", queued, err)
+	}
+}
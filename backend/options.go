@@ -0,0 +1,136 @@
+/*
+ * Options for embedding the backend in another Go program. The standalone
+ * ytb-be binary only needs a ServerConfig, but programs that embed the backend
+ * can swap out its parts and hook into what it's doing.
+ */
+
+package backend
+
+import (
+	"net"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Customizes a backend server created with New
+ */
+type Option func(*serverParts)
+
+/*
+ * Parts of the server that options can replace
+ */
+type serverParts struct {
+	newQueuer     func() queuer.SongQueuer   // creates the queue of each zone
+	dbManager     db.DbManager               // database. Nil opens the one in the config
+	listener      net.Listener               // listener. Nil listens on the address in the config
+	hooks         *Hooks                     // called as the server does things
+	fetchers      map[string]MetadataFetcher // service -> fetcher used in place of the configured one
+	snapshotStore SnapshotStore              // where snapshots are saved. Nil uses the store in the config
+}
+
+/*
+ * Functions called as the server does things. Hooks are subscribed to the
+ * server's event bus after its own parts and are called synchronously, so
+ * they should return quickly. Any of them can be left nil.
+ */
+type Hooks struct {
+	OnSongQueued   func(zoneId uint32, song *cmpb.Song) // a song was added to a zone's queue
+	OnSongPlaying  func(song *cmpb.Song)                // players were sent a new song to play
+	OnSongSkipped  func(song *cmpb.Song)                // the now playing song was skipped
+	OnPlayerJoined func(zoneId uint32, playerId int)    // a player joined a zone
+	OnPlayerLeft   func(zoneId uint32, playerId int)    // a player left a zone
+}
+
+/*
+ * Use a different kind of queue, such as queuer.NewFifoQueuer. The function is
+ * called once for every zone with a queue of its own. Defaults to round robin.
+ */
+func WithQueuer(newQueuer func() queuer.SongQueuer) Option {
+	return func(parts *serverParts) {
+		parts.newQueuer = newQueuer
+	}
+}
+
+/*
+ * Use an already initialized database manager instead of opening the database
+ * in the config
+ */
+func WithDbManager(dbManager db.DbManager) Option {
+	return func(parts *serverParts) {
+		parts.dbManager = dbManager
+	}
+}
+
+/*
+ * Serve on an existing listener instead of listening on the address in the
+ * config
+ */
+func WithListener(listener net.Listener) Option {
+	return func(parts *serverParts) {
+		parts.listener = listener
+	}
+}
+
+/*
+ * Fetch the metadata of links to a service, such as ServiceWeb, with a
+ * fetcher of the embedding program's own
+ */
+func WithMetadataFetcher(service string, fetcher MetadataFetcher) Option {
+	return func(parts *serverParts) {
+		if parts.fetchers == nil {
+			parts.fetchers = make(map[string]MetadataFetcher)
+		}
+		parts.fetchers[service] = fetcher
+	}
+}
+
+/*
+ * Save playlists and snapshots to a store of the embedding program's own
+ * instead of the one in the config
+ */
+func WithSnapshotStore(store SnapshotStore) Option {
+	return func(parts *serverParts) {
+		parts.snapshotStore = store
+	}
+}
+
+/*
+ * Call the hooks as the server does things
+ */
+func WithHooks(hooks Hooks) Option {
+	return func(parts *serverParts) {
+		parts.hooks = &hooks
+	}
+}
+
+func newRoundRobinQueuer() queuer.SongQueuer {
+	return queuer.NewRoundRobinQueuer()
+}
+
+/*
+ * Call the hooks for the events published on the bus
+ */
+func (h *Hooks) subscribe(bus *eventBus) {
+	if h == nil {
+		return
+	}
+
+	bus.subscribe(func(event *bepb.Event) {
+		switch {
+		case event.Type == bepb.EventType_SongQueued && h.OnSongQueued != nil:
+			h.OnSongQueued(event.ZoneId, event.Song)
+		case event.Type == bepb.EventType_SongPlaying && h.OnSongPlaying != nil:
+			h.OnSongPlaying(event.Song)
+		case event.Type == bepb.EventType_SongSkipped && h.OnSongSkipped != nil:
+			h.OnSongSkipped(event.Song)
+		case event.Type == bepb.EventType_PlayerJoined && h.OnPlayerJoined != nil:
+			h.OnPlayerJoined(event.ZoneId, int(event.PlayerId))
+		case event.Type == bepb.EventType_PlayerLeft && h.OnPlayerLeft != nil:
+			h.OnPlayerLeft(event.ZoneId, int(event.PlayerId))
+		}
+	})
+}
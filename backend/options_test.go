@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestNew_withOptions_usesParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_embed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbManager := new(db.SqliteManager)
+	if err = dbManager.Init(filepath.Join(dir, "ytbox.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer dbManager.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var queued []*cmpb.Song
+	server, err := New(&ServerConfig{},
+		WithListener(listener),
+		WithDbManager(dbManager),
+		WithQueuer(func() queuer.SongQueuer { return queuer.NewFifoQueuer() }),
+		WithHooks(Hooks{OnSongQueued: func(zoneId uint32, song *cmpb.Song) { queued = append(queued, song) }}))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if server.listener != listener || server.dbManager != dbManager {
+		t.Errorf("Server should use the listener and database it was given")
+	}
+
+	// a fifo queue doesn't let the second user jump ahead of the first
+	zone, _ := server.zones.get(defaultZoneId)
+	for _, song := range []*cmpb.Song{{SongId: 1, UserId: 1}, {SongId: 2, UserId: 1}, {SongId: 3, UserId: 2}} {
+		server.enqueueSong(zone, song)
+	}
+
+	playlist := server.queueMgr.GetPlaylist().Songs
+	if len(playlist) != 3 || playlist[1].UserId != 1 {
+		t.Errorf("Expected songs in submission order, but got %v", playlist)
+	}
+
+	if len(queued) != 3 {
+		t.Errorf("Expected the queued hook to be called 3 times, but got %d", len(queued))
+	}
+}
+
+func TestRun_whenContextCancelled_stops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_embed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := New(&ServerConfig{Addr: "127.0.0.1:0", DbPath: filepath.Join(dir, "ytbox.db")})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	cancel()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("Expected a clean stop, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * A party password keeps a backend exposed on a shared network, like a
+ * dorm's or an office's, from being open to anyone who finds the port. When
+ * the host sets one, users must give it to log in, and clients must send it
+ * with every other rpc acting for users, so skipping the log in doesn't get
+ * around it. Devices linked with a login code skip it, since a user who's
+ * already logged in confirms them.
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+)
+
+var (
+	ErrPasswordRequired = errors.New("This party needs a password to join.")
+	ErrWrongPassword    = errors.New("Wrong party password.")
+)
+
+/*
+ * Rpcs acting for users that are called without the party password. Logging
+ * in checks the password it's given, and a device asks for a login code
+ * before it's linked to anyone.
+ */
+var passwordlessMethods = map[string]bool{
+	"LoginUser":       true,
+	"CreateLoginCode": true,
+	"RedeemLoginCode": true,
+}
+
+/*
+ * Returns an error if the password given doesn't match the party's
+ */
+func matchPartyPassword(password string, given string) error {
+	if password == "" {
+		return nil
+	} else if given == "" {
+		return ErrPasswordRequired
+	} else if subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+/*
+ * Returns an error if the password given doesn't let the user join the party
+ */
+func (s *BackendServer) checkPartyPassword(given string) error {
+	return matchPartyPassword(s.partyPassword, given)
+}
+
+/*
+ * Check that the caller sent the party password in the request metadata if
+ * the rpc acts for users. Returns a PermissionDenied status error if it
+ * didn't. Callers given the player or admin role by a token don't need it.
+ */
+func (p *accessPolicy) checkPartyPassword(ctx context.Context, method string, required role) error {
+	if p.password == "" || required != roleUser || passwordlessMethods[method] {
+		return nil
+	}
+
+	if len(p.tokens) > 0 && p.callerRole(ctx) > roleUser {
+		return nil
+	}
+
+	given := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(common.PartyPasswordMetadataKey); len(values) > 0 {
+			given = values[0]
+		}
+	}
+
+	if err := matchPartyPassword(p.password, given); err != nil {
+		return status.Errorf(codes.PermissionDenied, "%s: %v", method, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+/*
+ * Returns a context sending the token and the party password, leaving out
+ * either if it's empty
+ */
+func partyContext(token string, password string) context.Context {
+	md := metadata.MD{}
+	if token != "" {
+		md.Set(common.TokenMetadataKey, token)
+	}
+	if password != "" {
+		md.Set(common.PartyPasswordMetadataKey, password)
+	}
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestLoginUser_withPartyPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	server.partyPassword = "hunter2"
+
+	for _, password := range []string{"", "hunter"} {
+		user, _ := server.LoginUser(context.Background(),
+			&bepb.User{Username: "Bob", RoomId: room.Room.Id, PartyPassword: password})
+		if user.Err.Success || user.UserId != 0 {
+			t.Errorf("Expected logging in with %q to be refused, got %v", password, user)
+		}
+	}
+
+	user, _ := server.LoginUser(context.Background(),
+		&bepb.User{Username: "Bob", RoomId: room.Room.Id, PartyPassword: "hunter2"})
+	if !user.Err.Success || user.UserId == 0 {
+		t.Fatalf("Expected the right password to log in, got %v", user)
+	}
+
+	if user.PartyPassword != "" {
+		t.Error("Expected the password not to be sent back")
+	}
+}
+
+func TestAccessPolicy_withPartyPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  map[string]string
+		ctx     context.Context
+		method  string
+		allowed bool
+	}{
+		{"no password", nil, partyContext("", ""), "SendSong", false},
+		{"wrong password", nil, partyContext("", "hunter"), "VoteSkip", false},
+		{"right password", nil, partyContext("", "hunter2"), "SendSong", true},
+		{"logging in", nil, partyContext("", ""), "LoginUser", true},
+		{"login code", nil, partyContext("", ""), "RedeemLoginCode", true},
+		{"reading the queue", nil, partyContext("", ""), "GetPlaylist", true},
+		{"user token without password", map[string]string{"user": "fe"}, partyContext("fe", ""), "React", false},
+		{"user token with password", map[string]string{"user": "fe"}, partyContext("fe", "hunter2"), "React", true},
+		{"admin token", map[string]string{"admin": "host"}, partyContext("host", ""), "RemoveSong", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := newAccessPolicy(test.tokens, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy.password = "hunter2"
+
+			err = policy.authorize(test.ctx, "/backend.YtbBackend/"+test.method)
+			if test.allowed && err != nil {
+				t.Errorf("Expected %s to be allowed, but got %v", test.method, err)
+			} else if !test.allowed && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Expected %s to be denied, but got %v", test.method, err)
+			}
+		})
+	}
+}
+
+func TestSendSong_withoutPartyPassword_turnedAway(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.partyPassword = "hunter2"
+	server.policy, _ = newAccessPolicy(nil, nil)
+	server.policy.password = "hunter2"
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	// a client that never logged in sends a song for bob's id
+	info := &grpc.UnaryServerInfo{FullMethod: "/backend.YtbBackend/SendSong"}
+	submission := &bepb.Submission{UserId: bob.User.UserId, Link: "https://www.youtube.com/watch?v=SilKjJ0S904"}
+	_, err = server.policy.unaryInterceptor(context.Background(), submission, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return server.SendSong(ctx, req.(*bepb.Submission))
+		})
+
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected the song to be turned away, but got %v", err)
+	}
+
+	if length := len(server.queueMgr.GetPlaylist().Songs); length != 0 {
+		t.Errorf("Expected nothing queued, but got %d songs", length)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Recaps a party once it ends: how many songs were played, how many hours of
+ * music that was, who submitted the most and who got skipped the most, and
+ * the songs it opened and closed with. A party ends when the queue closes for
+ * the night, a preset for another event is applied, the server stops or the
+ * host ends it. Recaps are saved in the database and can be posted to a
+ * webhook, such as one of a Discord channel.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	recapTopUsers      = 3                // users listed as top submitters and most skipped
+	recapCheckInterval = time.Minute      // time between checks for the queue closing
+	recapPostTimeout   = 10 * time.Second // how long posting a recap to the webhook may take
+)
+
+var ErrEmptyParty = errors.New("No songs were submitted during the party.")
+var ErrWebhooksNotBuilt = errors.New("This backend was built without webhooks.")
+
+/*
+ * Keeps track of when the current party started and recaps it once it ends
+ */
+type partyRecapper struct {
+	dbManager db.DbManager   // database the songs are read from and recaps saved to
+	webhook   string         // address recaps are posted to. Empty doesn't post them
+	client    *http.Client   // client used to post recaps
+	started   time.Time      // when the current party started
+	open      bool           // true if the queue was open at the last check
+	lock      sync.Mutex     // lock on the party
+	posts     sync.WaitGroup // recaps still being posted
+	done      chan struct{}  // closed to stop watching for the queue closing
+}
+
+/*
+ * Initialize the recapper with a party starting now
+ */
+func (r *partyRecapper) init(dbManager db.DbManager, webhook string, now time.Time) {
+	r.dbManager = dbManager
+	r.webhook = webhook
+	r.client = &http.Client{Timeout: recapPostTimeout}
+	r.started = now
+	r.open = true
+	r.done = make(chan struct{})
+}
+
+/*
+ * Start watching for the queue closing, which ends the party
+ */
+func (r *partyRecapper) start(isOpen func(now time.Time) bool) {
+	go func() {
+		ticker := time.NewTicker(recapCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				r.check(isOpen(now), now)
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+/*
+ * Stop watching the queue and recap the party that was going on. Waits for
+ * the recaps being posted.
+ */
+func (r *partyRecapper) stop() {
+	close(r.done)
+	r.endLogged(time.Now())
+	r.posts.Wait()
+}
+
+/*
+ * End the party if the queue closed since the last check
+ */
+func (r *partyRecapper) check(open bool, now time.Time) {
+	r.lock.Lock()
+	closed := r.open && !open
+	r.open = open
+	r.lock.Unlock()
+
+	if closed {
+		r.endLogged(now)
+	}
+}
+
+/*
+ * End the current party and start the next one. The recap is saved and
+ * posted to the webhook. Returns ErrEmptyParty if nobody submitted a song, in
+ * which case there's nothing to recap.
+ */
+func (r *partyRecapper) end(now time.Time) (*bepb.PartyRecap, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	songs, err := r.dbManager.GetSongsBetween(r.started, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(songs) == 0 {
+		r.started = now
+		return nil, ErrEmptyParty
+	}
+
+	recap := buildRecap(songs, r.started, now)
+	if err = r.dbManager.SaveRecap(recap); err != nil {
+		return nil, err
+	}
+	r.started = now
+
+	if r.webhook != "" {
+		r.posts.Add(1)
+		go func() {
+			defer r.posts.Done()
+			if err := r.post(recap); err != nil {
+				log.Printf("Failed to post party recap %d: %v", recap.Id, err)
+			}
+		}()
+	}
+
+	return recap, nil
+}
+
+/*
+ * End the current party, logging why it couldn't be recapped
+ */
+func (r *partyRecapper) endLogged(now time.Time) {
+	if _, err := r.end(now); err != nil && !errors.Is(err, ErrEmptyParty) {
+		log.Printf("Failed to recap the party: %v", err)
+	}
+}
+
+/*
+ * Recap the songs submitted during a party, which are oldest first
+ */
+func buildRecap(songs []*db.HistoryData, start time.Time, end time.Time) *bepb.PartyRecap {
+	recap := &bepb.PartyRecap{
+		StartedAt:  start.Unix(),
+		EndedAt:    end.Unix(),
+		TotalSongs: uint32(len(songs)),
+		FirstSong:  &songs[0].Song,
+		LastSong:   &songs[len(songs)-1].Song,
+	}
+
+	submitted := make(map[uint32]*bepb.UserCount)
+	skipped := make(map[uint32]*bepb.UserCount)
+	var length time.Duration
+
+	for _, entry := range songs {
+		hideSubmitter(&entry.Song)
+		length += songLength(&entry.Song)
+
+		// the auto dj plays other users' songs, which they didn't submit, and
+		// anonymous songs aren't credited to anyone
+		if entry.Song.Source == cmpb.SubmissionSource_AutoDj || entry.Song.Anonymous {
+			continue
+		}
+
+		countUser(submitted, &entry.Song)
+		if entry.Skipped {
+			countUser(skipped, &entry.Song)
+		}
+	}
+
+	recap.Hours = length.Hours()
+	recap.TopSubmitters = rankUsers(submitted)
+	recap.MostSkipped = rankUsers(skipped)
+	return recap
+}
+
+/*
+ * Count a song toward the user who submitted it
+ */
+func countUser(counts map[uint32]*bepb.UserCount, song *cmpb.Song) {
+	count, exists := counts[song.UserId]
+	if !exists {
+		count = &bepb.UserCount{UserId: song.UserId, Username: song.Username}
+		counts[song.UserId] = count
+	}
+	count.Count++
+}
+
+/*
+ * Returns the users with the highest counts, highest first. Ties go to the
+ * lower user id so recaps don't change from run to run.
+ */
+func rankUsers(counts map[uint32]*bepb.UserCount) []*bepb.UserCount {
+	ranked := make([]*bepb.UserCount, 0, len(counts))
+	for _, count := range counts {
+		ranked = append(ranked, count)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].UserId < ranked[j].UserId
+	})
+
+	if len(ranked) > recapTopUsers {
+		ranked = ranked[:recapTopUsers]
+	}
+
+	return ranked
+}
+
+/*
+ * Write a recap as a short message for a chat channel
+ */
+func recapSummary(recap *bepb.PartyRecap) string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "Party recap: %d songs, %.1f hours of music", recap.TotalSongs, recap.Hours)
+
+	if len(recap.TopSubmitters) > 0 {
+		fmt.Fprintf(&summary, "\nTop submitters: %s", joinUserCounts(recap.TopSubmitters))
+	}
+
+	if len(recap.MostSkipped) > 0 {
+		fmt.Fprintf(&summary, "\nMost skipped: %s", joinUserCounts(recap.MostSkipped))
+	}
+
+	fmt.Fprintf(&summary, "\nFirst song: %s\nLast song: %s", recap.FirstSong.GetTitle(),
+		recap.LastSong.GetTitle())
+	return summary.String()
+}
+
+/*
+ * Join users and their counts like "Alice (3), Bob (2)"
+ */
+func joinUserCounts(counts []*bepb.UserCount) string {
+	parts := make([]string, 0, len(counts))
+	for _, count := range counts {
+		parts = append(parts, fmt.Sprintf("%s (%d)", count.Username, count.Count))
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func recapSong(userId uint32, username string, duration string, skipped bool, source cmpb.SubmissionSource) *db.HistoryData {
+	return &db.HistoryData{
+		Song: cmpb.Song{Title: username + " song", UserId: userId, Username: username, Source: source,
+			Metadata: &cmpb.Metadata{Duration: duration}},
+		Skipped: skipped,
+	}
+}
+
+func TestBuildRecap_ranksUsers(t *testing.T) {
+	songs := []*db.HistoryData{
+		recapSong(1, "Alice", "PT30M", false, cmpb.SubmissionSource_WebUi),
+		recapSong(2, "Bob", "PT30M", true, cmpb.SubmissionSource_WebUi),
+		recapSong(2, "Bob", "PT30M", true, cmpb.SubmissionSource_WebUi),
+		recapSong(3, "Carol", "PT30M", false, cmpb.SubmissionSource_Cli),
+		recapSong(3, "Carol", "PT30M", false, cmpb.SubmissionSource_Cli),
+		recapSong(4, "Dave", "PT30M", false, cmpb.SubmissionSource_WebUi),
+		recapSong(1, "Alice", "PT1H", true, cmpb.SubmissionSource_AutoDj),
+	}
+
+	start := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	recap := buildRecap(songs, start, start.Add(5*time.Hour))
+
+	if recap.TotalSongs != 7 || recap.Hours != 4 {
+		t.Errorf("Expected 7 songs over 4 hours, got %d over %v", recap.TotalSongs, recap.Hours)
+	}
+
+	// ties go to the lower user id and the auto dj's pick doesn't count
+	top := recap.TopSubmitters
+	if len(top) != recapTopUsers || top[0].UserId != 2 || top[1].UserId != 3 || top[2].UserId != 1 ||
+		top[2].Count != 1 {
+		t.Errorf("Expected Bob, Carol and Alice as the top submitters, got %v", top)
+	}
+
+	if len(recap.MostSkipped) != 1 || recap.MostSkipped[0].Username != "Bob" || recap.MostSkipped[0].Count != 2 {
+		t.Errorf("Expected Bob as the most skipped, got %v", recap.MostSkipped)
+	}
+
+	if recap.FirstSong.Username != "Alice" || recap.LastSong.Source != cmpb.SubmissionSource_AutoDj {
+		t.Errorf("Expected the first and last songs, got %v and %v", recap.FirstSong, recap.LastSong)
+	}
+}
+
+func TestBuildRecap_whenAnonymous_notCredited(t *testing.T) {
+	anonymous := recapSong(1, "Alice", "PT30M", false, cmpb.SubmissionSource_WebUi)
+	anonymous.Song.Anonymous = true
+	songs := []*db.HistoryData{anonymous, recapSong(2, "Bob", "PT30M", false, cmpb.SubmissionSource_WebUi)}
+
+	start := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	recap := buildRecap(songs, start, start.Add(time.Hour))
+
+	if recap.TotalSongs != 2 || recap.FirstSong.Username != anonymousName {
+		t.Errorf("Expected 2 songs with the first one shown as Anonymous, got %v", recap)
+	}
+
+	if top := recap.TopSubmitters; len(top) != 1 || top[0].UserId != 2 {
+		t.Errorf("Expected only Bob credited, got %v", top)
+	}
+}
+
+func TestPartyRecapper_whenQueueCloses_savesAndPostsRecap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_recap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	posted := make(chan string, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct{ Content string }
+		json.NewDecoder(r.Body).Decode(&message)
+		posted <- message.Content
+	}))
+	defer webhook.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	user, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	server.dbManager.AddSong(&cmpb.Song{Title: "Clarity", Service: cmpb.ServiceType_Youtube, ServiceId: "clarity",
+		UserId: user.User.UserId, RoomId: room.Room.Id, Metadata: &cmpb.Metadata{Duration: "PT4M31S"}})
+
+	recapper := new(partyRecapper)
+	recapper.init(server.dbManager, webhook.URL, time.Now().Add(-time.Hour))
+
+	recapper.check(true, time.Now())
+	if _, err := server.dbManager.GetRecap(0); err == nil {
+		t.Fatal("Expected no recap while the queue is open")
+	}
+
+	recapper.check(false, time.Now().Add(time.Second))
+	recap, err := server.dbManager.GetRecap(0)
+	if err != nil || recap.TotalSongs != 1 || recap.FirstSong.Title != "Clarity" ||
+		recap.FirstSong.Metadata.Duration != "PT4M31S" {
+		t.Fatalf("Expected a recap of the song, got %v and %v", recap, err)
+	}
+
+	select {
+	case content := <-posted:
+		if !strings.Contains(content, "1 songs") || !strings.Contains(content, "Zedd (1)") {
+			t.Errorf("Expected the summary to be posted, got %q", content)
+		}
+	case <-time.After(time.Second):
+		if webhooksBuilt {
+			t.Fatal("Expected the recap to be posted to the webhook")
+		}
+	}
+
+	// nothing was submitted since, so the next party has nothing to recap
+	if _, err := recapper.end(time.Now().Add(2 * time.Second)); err != ErrEmptyParty {
+		t.Errorf("Expected an empty party, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Gives songs that fail to play right away a second chance. Streams resolved
+ * ahead of time expire and YouTube extraction fails now and then, so when a
+ * player reports the song failed within its first seconds, the zone plays it
+ * once more, streamed afresh, before moving on to the next song.
+ */
+
+package backend
+
+import (
+	"log"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	retryWindow = 10 * time.Second // songs failing within this long of starting are played again
+)
+
+/*
+ * Record the song the players were sent. Expects the player lock to be held.
+ */
+func (mgr *playerManager) startedPlaying(song *cmpb.Song, now time.Time) {
+	mgr.playing = song.GetSongId()
+	mgr.playStart = now
+	mgr.retried = false
+	mgr.failed = 0
+	mgr.retry = nil
+}
+
+/*
+ * Handle a player's report that the song failed to play. The song is played
+ * again once all the players are ready if it failed soon after it started and
+ * wasn't played again already. Expects the player lock to be held.
+ */
+func (mgr *playerManager) songFailed(id int, status *bepb.PlayerStatus, now time.Time) {
+	song := mgr.queueMgr.NowPlaying()
+	if song == nil || status.GetSongId() != mgr.playing || song.SongId != mgr.playing {
+		return
+	}
+
+	log.Printf("Player %d failed to play %s: %s", id, song.ServiceId, status.GetPlayError())
+	if mgr.retried {
+		log.Printf("Moving on from %s after it failed again", song.ServiceId)
+		mgr.failed = song.SongId
+		return
+	}
+
+	if mgr.retry != nil {
+		return
+	} else if now.Sub(mgr.playStart) > retryWindow {
+		mgr.failed = song.SongId
+		return
+	}
+
+	mgr.retry = &bepb.PlayerControl{
+		Command:  bepb.CommandType_Play,
+		Song:     song,
+		Upcoming: mgr.downloader.hints(mgr.queueMgr.GetPlaylist().Songs),
+		Retry:    true,
+	}
+}
+
+/*
+ * Returns how a song the players moved on from ended: with an error if they
+ * gave up on it after it failed, otherwise played to the end
+ */
+func (mgr *playerManager) outcomeOf(song *cmpb.Song) bepb.SongOutcome {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	if mgr.failed != 0 && mgr.failed == song.GetSongId() {
+		return bepb.SongOutcome_Errored
+	}
+	return bepb.SongOutcome_Completed
+}
+
+/*
+ * Send the players the failed song again. Returns false if there's no song to
+ * play again or the failure came too late to still start over.
+ */
+func (mgr *playerManager) replayFailed(now time.Time) bool {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	retry := mgr.retry
+	mgr.retry = nil
+	if retry == nil {
+		return false
+	}
+
+	// players that didn't fail played on, so it's too late to start over
+	if now.Sub(mgr.playStart) > retryWindow {
+		return false
+	}
+
+	log.Printf("Playing %s again after it failed", retry.Song.ServiceId)
+	mgr.withQuality(retry)
+	for id, state := range mgr.streams {
+		mgr.sendFading(retry, state)
+		mgr.ready[id] = PLAYER_BUSY
+	}
+	mgr.retried = true
+	mgr.playStart = now
+	return true
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestSongFailed_soonAfterStarting_playsItAgainOnce(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	song := &cmpb.Song{SongId: 7, ServiceId: "SilKjJ0S904"}
+	playerMgr.queueMgr.SetNowPlaying(song)
+
+	now := time.Now()
+	playerMgr.startedPlaying(song, now)
+	playerMgr.songFailed(1, &bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: 7}, now.Add(time.Second))
+	if retry := playerMgr.retry; retry == nil || retry.Song != song || !retry.Retry {
+		t.Fatalf("Expected the song to be played again, got %v", retry)
+	}
+
+	if !playerMgr.replayFailed(now.Add(2 * time.Second)) {
+		t.Fatalf("Expected the song to be sent again")
+	}
+
+	// a song failing again is skipped
+	playerMgr.songFailed(1, &bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: 7}, now.Add(3*time.Second))
+	if playerMgr.replayFailed(now.Add(3 * time.Second)) {
+		t.Errorf("Expected the song failing again to be skipped")
+	}
+
+	if outcome := playerMgr.outcomeOf(song); outcome != bepb.SongOutcome_Errored {
+		t.Errorf("Expected the song to have ended with an error, got %v", outcome)
+	}
+}
+
+func TestSongFailed_whenLateOrAnotherSong_movesOn(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	song := &cmpb.Song{SongId: 7, ServiceId: "SilKjJ0S904"}
+	playerMgr.queueMgr.SetNowPlaying(song)
+
+	now := time.Now()
+	playerMgr.startedPlaying(song, now)
+	playerMgr.songFailed(1, &bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: 8}, now.Add(time.Second))
+	if playerMgr.retry != nil {
+		t.Errorf("Expected a failure of another song to be ignored")
+	}
+
+	if outcome := playerMgr.outcomeOf(song); outcome != bepb.SongOutcome_Completed {
+		t.Errorf("Expected the song to still play to the end, got %v", outcome)
+	}
+
+	playerMgr.songFailed(1, &bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: 7}, now.Add(time.Minute))
+	if playerMgr.retry != nil {
+		t.Errorf("Expected a song failing a minute in to be skipped")
+	}
+
+	// another player failing late doesn't hold up the next song
+	playerMgr.songFailed(1, &bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: 7}, now.Add(time.Second))
+	if playerMgr.replayFailed(now.Add(time.Minute)) {
+		t.Errorf("Expected the song not to be played again once the others played on")
+	}
+}
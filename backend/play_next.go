@@ -0,0 +1,125 @@
+/*
+ * Lets users ask for their song to play next without an admin stepping in.
+ * The request goes out to the clients streaming events, and once a share of
+ * the other active users approved it the song moves to the front of the
+ * queue. Each user may have one request open at a time, and requests lapse
+ * if they aren't approved in time.
+ */
+
+package backend
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPlayNextShare = 0.5              // share of the other active users whose approvals move a song
+	playNextTimeout      = 10 * time.Minute // how long a request stays open
+)
+
+var (
+	ErrNoPlayNextRequest = errors.New("Nobody asked for that song to play next.")
+	ErrOwnPlayNext       = errors.New("You can't approve your own request.")
+)
+
+/*
+ * A request to play a song next and its approvals
+ */
+type playNextBallot struct {
+	zoneId    uint32          // id of the zone the song is queued in
+	songId    uint32          // id of the song asked for
+	asker     uint32          // id of the user who asked
+	approvers map[uint32]bool // ids of the users who approved
+	expires   time.Time       // when the request lapses
+}
+
+/*
+ * Counts the approvals of the requests to play songs next
+ */
+type playNextVoter struct {
+	share   float64                    // share of the other active users needed to move a song
+	ballots map[uint32]*playNextBallot // id of the user who asked -> the user's open request
+	lock    sync.Mutex                 // lock on the ballots
+}
+
+/*
+ * Initialize the voter. The share falls back to a default if it isn't between
+ * zero and one.
+ */
+func (v *playNextVoter) init(share float64) {
+	v.share = share
+	if v.share <= 0 || v.share > 1 {
+		v.share = defaultPlayNextShare
+	}
+
+	v.ballots = make(map[uint32]*playNextBallot)
+}
+
+/*
+ * Returns the number of approvals it takes to move a song with the given
+ * number of active users, who include the user asking. At least one approval
+ * is always needed.
+ */
+func (v *playNextVoter) needed(active int) int {
+	needed := int(math.Ceil(float64(active-1) * v.share))
+	if needed < 1 {
+		return 1
+	}
+
+	return needed
+}
+
+/*
+ * Open a request to play a song next, replacing the user's open request
+ */
+func (v *playNextVoter) ask(zoneId uint32, songId uint32, userId uint32, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.ballots[userId] = &playNextBallot{
+		zoneId:    zoneId,
+		songId:    songId,
+		asker:     userId,
+		approvers: make(map[uint32]bool),
+		expires:   now.Add(playNextTimeout),
+	}
+}
+
+/*
+ * Record a user's approval of the open request to play a song next. Returns
+ * the approvals so far and whether they reached the number needed. The
+ * request is closed once it passes, so only one caller is told to move the
+ * song.
+ */
+func (v *playNextVoter) approve(zoneId uint32, songId uint32, userId uint32, needed int,
+	now time.Time) (int, bool, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var ballot *playNextBallot
+	for asker, open := range v.ballots {
+		if !now.Before(open.expires) {
+			delete(v.ballots, asker)
+		} else if open.zoneId == zoneId && open.songId == songId {
+			ballot = open
+		}
+	}
+
+	if ballot == nil {
+		return 0, false, ErrNoPlayNextRequest
+	} else if ballot.asker == userId {
+		return 0, false, ErrOwnPlayNext
+	}
+
+	ballot.approvers[userId] = true
+	approvals := len(ballot.approvers)
+	if approvals < needed {
+		return approvals, false, nil
+	}
+
+	delete(v.ballots, ballot.asker)
+	return approvals, true, nil
+}
@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestPlayNextVoter_needed(t *testing.T) {
+	voter := new(playNextVoter)
+	voter.init(0.5)
+
+	// the user asking doesn't count
+	tests := map[int]int{0: 1, 1: 1, 2: 1, 3: 1, 4: 2, 7: 3}
+	for active, expected := range tests {
+		if needed := voter.needed(active); needed != expected {
+			t.Errorf("needed(%d) = %d, expected %d", active, needed, expected)
+		}
+	}
+}
+
+func TestPlayNextVoter_approve(t *testing.T) {
+	voter := new(playNextVoter)
+	voter.init(0)
+
+	now := time.Now()
+	if _, _, err := voter.approve(0, 10, 2, 2, now); err != ErrNoPlayNextRequest {
+		t.Errorf("Expected no request to approve, got %v", err)
+	}
+
+	voter.ask(0, 10, 1, now)
+	if _, _, err := voter.approve(0, 10, 1, 2, now); err != ErrOwnPlayNext {
+		t.Errorf("Expected the asker not to approve their own request, got %v", err)
+	}
+
+	if approvals, passed, _ := voter.approve(0, 10, 2, 2, now); approvals != 1 || passed {
+		t.Errorf("Expected 1 approval that didn't pass, got %d and %t", approvals, passed)
+	}
+
+	// approving twice doesn't count twice
+	if approvals, passed, _ := voter.approve(0, 10, 2, 2, now); approvals != 1 || passed {
+		t.Errorf("Expected a repeat approval not to count, got %d and %t", approvals, passed)
+	}
+
+	if approvals, passed, _ := voter.approve(0, 10, 3, 2, now); approvals != 2 || !passed {
+		t.Errorf("Expected 2 approvals that passed, got %d and %t", approvals, passed)
+	}
+
+	if _, _, err := voter.approve(0, 10, 4, 2, now); err != ErrNoPlayNextRequest {
+		t.Errorf("Expected the request to close once it passed, got %v", err)
+	}
+}
+
+func TestPlayNextVoter_approve_afterTimeout_lapses(t *testing.T) {
+	voter := new(playNextVoter)
+	voter.init(0.5)
+
+	now := time.Now()
+	voter.ask(0, 10, 1, now)
+	if _, _, err := voter.approve(0, 10, 2, 1, now.Add(playNextTimeout)); err != ErrNoPlayNextRequest {
+		t.Errorf("Expected the request to lapse, got %v", err)
+	}
+}
+
+func TestApprovePlayNext_movesSongToFront(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_play_next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	alice, _ := server.dbManager.AddUser("Alice", room.Room.Id)
+
+	server.queueMgr.AddSong(queuedSong(1, alice.User.UserId, "PT3M"))
+	server.queueMgr.AddSong(queuedSong(2, alice.User.UserId, "PT3M"))
+	server.queueMgr.AddSong(queuedSong(3, bob.User.UserId, "PT3M"))
+
+	asked, _ := server.RequestPlayNext(context.Background(), &bepb.PlayNext{UserId: bob.User.UserId, SongId: 1})
+	if asked.Err.Success {
+		t.Error("Expected Bob not to ask for Alice's song")
+	}
+
+	asked, _ = server.RequestPlayNext(context.Background(), &bepb.PlayNext{UserId: bob.User.UserId, SongId: 3})
+	if !asked.Err.Success || asked.Needed != 1 {
+		t.Fatalf("Expected Bob's request to need 1 approval, got %v", asked)
+	}
+
+	approved, _ := server.ApprovePlayNext(context.Background(), &bepb.PlayNext{UserId: alice.User.UserId, SongId: 3})
+	if !approved.Err.Success || !approved.Moved {
+		t.Fatalf("Expected Alice's approval to move the song, got %v", approved)
+	}
+
+	if order := playlistIds(server.queueMgr); len(order) != 3 || order[0] != 3 {
+		t.Errorf("Expected Bob's song at the front of the queue, got %v", order)
+	}
+}
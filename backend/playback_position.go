@@ -0,0 +1,222 @@
+/*
+ * Tracks how far along the now playing song is. Players report their position
+ * every few seconds and the backend fills in the time since the last report,
+ * so displays can draw progress bars that stay in sync with each other.
+ *
+ * Reports come in over Wi-Fi at irregular intervals, so taking each one at
+ * face value makes progress bars jitter and step backwards. Small differences
+ * between a report and the running estimate are worked in over a few seconds
+ * instead, without ever moving the estimate back. Differences too large to be
+ * drift are taken as a seek and jumped to right away.
+ */
+
+package backend
+
+import (
+	"math"
+	"time"
+
+	"github.com/rickb777/date/period"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	positionSlewWindow    = 5 * time.Second // time taken to work a small difference into the estimate
+	positionSeekThreshold = 3.0             // seconds of difference taken as a seek instead of drift
+	positionMinRate       = 0.5             // slowest the estimate plays while a report behind it catches up
+	latencySamples        = 12              // reports remembered to find a player's quickest delivery
+	latencyClockJump      = 10000           // milliseconds of delay taken as a clock being set, not a slow report
+)
+
+/*
+ * Last playback position reported by a player, smoothed against the reports
+ * before it
+ */
+type reportedPosition struct {
+	songId     uint32    // song the player was playing
+	elapsed    float64   // seconds of the song played
+	paused     bool      // true if playback was paused
+	reportedAt time.Time // when the report was received
+	correction float64   // seconds still to be worked into the position over the slew window
+}
+
+/*
+ * Works out how long a player's position reports spend in flight. The
+ * player's clock isn't in sync with the backend's, but the quickest recent
+ * report gives the offset between the two, and any report slower than that
+ * was held up on the way. The offset moves if either clock is set, such as by
+ * an NTP correction, so an offset far off the quickest starts over from it.
+ */
+type reportLatency struct {
+	offsets []int64 // time received minus time sent of recent reports, in milliseconds
+}
+
+/*
+ * Record a report sent at the given player time and received now. Returns how
+ * much longer the report took than the quickest recent one, or zero if the
+ * player didn't say when it was sent.
+ */
+func (l *reportLatency) delay(sentAt int64, now time.Time) time.Duration {
+	if sentAt <= 0 {
+		return 0
+	}
+
+	offset := now.UnixNano()/int64(time.Millisecond) - sentAt
+	if len(l.offsets) > 0 && offset-l.quickest() > latencyClockJump {
+		// the backend's clock was set forward or the player's back, so the
+		// earlier offsets no longer hold
+		l.offsets = l.offsets[:0]
+	}
+
+	l.offsets = append(l.offsets, offset)
+	if len(l.offsets) > latencySamples {
+		l.offsets = l.offsets[1:]
+	}
+
+	return time.Duration(offset-l.quickest()) * time.Millisecond
+}
+
+/*
+ * Returns the smallest recent offset. Assumes there is at least one.
+ */
+func (l *reportLatency) quickest() int64 {
+	quickest := l.offsets[0]
+	for _, other := range l.offsets[1:] {
+		if other < quickest {
+			quickest = other
+		}
+	}
+
+	return quickest
+}
+
+/*
+ * Record a position reported by a player. Assumes the caller holds the player
+ * lock.
+ */
+func (mgr *playerManager) updatePosition(id int, status *bepb.PlayerStatus, now time.Time) {
+	var delay time.Duration
+	if state, exists := mgr.streams[id]; exists {
+		delay = state.latency.delay(status.GetPositionTime(), now)
+	}
+
+	mgr.position = smoothPosition(mgr.position, status, delay, now)
+}
+
+/*
+ * Returns the position a player reported, smoothed against the previous
+ * estimate. The delay is how long the report was held up on its way, which
+ * the song kept playing for.
+ */
+func smoothPosition(previous *reportedPosition, status *bepb.PlayerStatus, delay time.Duration,
+	now time.Time) *reportedPosition {
+
+	report := &reportedPosition{
+		songId:     status.GetSongId(),
+		elapsed:    status.GetPosition(),
+		paused:     status.GetPaused(),
+		reportedAt: now,
+	}
+
+	if !report.paused {
+		report.elapsed += delay.Seconds()
+	}
+
+	if previous == nil || previous.songId != report.songId {
+		return report
+	}
+
+	estimate := previous.elapsedAt(now)
+	drift := report.elapsed - estimate
+
+	switch {
+	case math.Abs(drift) > positionSeekThreshold:
+		// the player seeked, so jump straight to the report
+	case report.paused:
+		// hold where the estimate got to until playback resumes
+		report.elapsed = math.Max(report.elapsed, estimate)
+	default:
+		// carry on from the estimate and catch up to the report gradually
+		report.elapsed = estimate
+		report.correction = math.Max(drift, -(1-positionMinRate)*positionSlewWindow.Seconds())
+	}
+
+	return report
+}
+
+/*
+ * Returns how far along the song was at the given time, going by the report.
+ * The correction is worked in evenly over the slew window.
+ */
+func (r *reportedPosition) elapsedAt(now time.Time) float64 {
+	if r.paused {
+		return r.elapsed
+	}
+
+	since := math.Max(now.Sub(r.reportedAt).Seconds(), 0)
+	share := math.Min(since/positionSlewWindow.Seconds(), 1)
+	return r.elapsed + since + r.correction*share
+}
+
+/*
+ * Returns the last position reported by any player in the zone, or nil if
+ * none was reported
+ */
+func (mgr *playerManager) lastPosition() *reportedPosition {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+	return mgr.position
+}
+
+/*
+ * Work out how far along the song is at the given time. A player's report for
+ * the song is moved forward by the time since it came in, unless the player
+ * was paused. Without a report the song is assumed to have been playing from
+ * its start offset since it was popped off the queue.
+ */
+func playbackPosition(song *cmpb.Song, startedAt time.Time, report *reportedPosition,
+	now time.Time) *bepb.PlaybackPosition {
+
+	position := &bepb.PlaybackPosition{
+		SongId:     song.SongId,
+		ServerTime: now.UnixNano() / int64(time.Millisecond),
+		Duration:   songSeconds(song),
+	}
+
+	if report != nil && report.songId == song.SongId {
+		position.Reported = true
+		position.Paused = report.paused
+		position.Elapsed = report.elapsedAt(now)
+	} else {
+		position.Elapsed = float64(song.StartAt) + now.Sub(startedAt).Seconds()
+	}
+
+	// chapters are timed from their own start rather than the video's
+	if song.Chapter > 0 {
+		position.Elapsed -= float64(song.StartAt)
+	}
+
+	if position.Elapsed < 0 {
+		position.Elapsed = 0
+	}
+
+	if position.Duration > 0 && position.Elapsed > position.Duration {
+		position.Elapsed = position.Duration
+	}
+
+	return position
+}
+
+/*
+ * Returns the length of a song in seconds, or zero if it isn't known
+ */
+func songSeconds(song *cmpb.Song) float64 {
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil {
+		return 0
+	}
+
+	return duration.DurationApprox().Seconds()
+}
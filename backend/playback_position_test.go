@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestPlaybackPosition_whenReported_interpolates(t *testing.T) {
+	now := time.Now()
+	song := &cmpb.Song{SongId: 3, Metadata: &cmpb.Metadata{Duration: "PT3M"}}
+	report := &reportedPosition{songId: 3, elapsed: 30, reportedAt: now.Add(-4 * time.Second)}
+
+	position := playbackPosition(song, now.Add(-time.Minute), report, now)
+	if !position.Reported || position.Elapsed != 34 || position.Duration != 180 {
+		t.Errorf("Expected 34 of 180 seconds reported, but got %v", position)
+	}
+
+	report.paused = true
+	position = playbackPosition(song, now.Add(-time.Minute), report, now)
+	if !position.Paused || position.Elapsed != 30 {
+		t.Errorf("Expected a paused position at 30 seconds, but got %v", position)
+	}
+}
+
+func TestPlaybackPosition_whenNotReported_estimatesFromStart(t *testing.T) {
+	now := time.Now()
+	song := &cmpb.Song{SongId: 3, Metadata: &cmpb.Metadata{Duration: "PT1M"}}
+
+	// reports for another song are ignored
+	report := &reportedPosition{songId: 2, elapsed: 10, reportedAt: now}
+
+	position := playbackPosition(song, now.Add(-20*time.Second), report, now)
+	if position.Reported || position.Elapsed != 20 {
+		t.Errorf("Expected 20 seconds estimated from the start, but got %v", position)
+	}
+
+	position = playbackPosition(song, now.Add(-2*time.Minute), nil, now)
+	if position.Elapsed != 60 {
+		t.Errorf("Expected the position to stop at the song's length, but got %v", position)
+	}
+
+	if position.ServerTime != now.UnixNano()/int64(time.Millisecond) {
+		t.Errorf("Expected the server time to be set, but got %v", position)
+	}
+}
+
+func TestSmoothPosition_whenDrifting_neverMovesBackwards(t *testing.T) {
+	start := time.Now()
+	previous := &reportedPosition{songId: 3, elapsed: 30, reportedAt: start}
+
+	// the estimate is at 35 seconds, half a second ahead of the player
+	now := start.Add(5 * time.Second)
+	status := &bepb.PlayerStatus{SongId: 3, Position: 34.5}
+	report := smoothPosition(previous, status, 0, now)
+
+	if elapsed := report.elapsedAt(now); elapsed != 35 {
+		t.Errorf("Expected the estimate to carry on from 35 seconds, but got %v", elapsed)
+	}
+
+	last := report.elapsedAt(now)
+	for step := 1; step <= 10; step++ {
+		elapsed := report.elapsedAt(now.Add(time.Duration(step) * time.Second))
+		if elapsed < last {
+			t.Fatalf("Expected the position to never move backwards, but went from %v to %v", last, elapsed)
+		}
+		last = elapsed
+	}
+
+	if elapsed := report.elapsedAt(now.Add(positionSlewWindow)); elapsed != 39.5 {
+		t.Errorf("Expected the estimate to have caught up to the player at 39.5 seconds, but got %v", elapsed)
+	}
+}
+
+func TestSmoothPosition_whenSeeked_jumps(t *testing.T) {
+	start := time.Now()
+	previous := &reportedPosition{songId: 3, elapsed: 30, reportedAt: start}
+
+	now := start.Add(5 * time.Second)
+	report := smoothPosition(previous, &bepb.PlayerStatus{SongId: 3, Position: 10}, 0, now)
+	if elapsed := report.elapsedAt(now); elapsed != 10 {
+		t.Errorf("Expected a seek back to 10 seconds to be jumped to, but got %v", elapsed)
+	}
+
+	report = smoothPosition(report, &bepb.PlayerStatus{SongId: 4, Position: 1}, 0, now)
+	if elapsed := report.elapsedAt(now); elapsed != 1 {
+		t.Errorf("Expected a new song to start from its report, but got %v", elapsed)
+	}
+}
+
+func TestSmoothPosition_whenPaused_holdsEstimate(t *testing.T) {
+	start := time.Now()
+	previous := &reportedPosition{songId: 3, elapsed: 30, reportedAt: start}
+
+	now := start.Add(5 * time.Second)
+	status := &bepb.PlayerStatus{SongId: 3, Position: 34.6, Paused: true}
+	report := smoothPosition(previous, status, 2*time.Second, now)
+
+	if elapsed := report.elapsedAt(now.Add(time.Minute)); !report.paused || elapsed != 35 {
+		t.Errorf("Expected a paused position held at 35 seconds, but got %v", elapsed)
+	}
+}
+
+func TestReportLatency_delay(t *testing.T) {
+	now := time.Now()
+	sentAt := now.UnixNano()/int64(time.Millisecond) - 100
+
+	latency := new(reportLatency)
+	if delay := latency.delay(sentAt, now); delay != 0 {
+		t.Errorf("Expected the first report to set the quickest delivery, but got %v", delay)
+	}
+
+	if delay := latency.delay(sentAt-250, now); delay != 250*time.Millisecond {
+		t.Errorf("Expected a report held up by 250ms, but got %v", delay)
+	}
+
+	if delay := latency.delay(0, now); delay != 0 {
+		t.Errorf("Expected no delay for a report without a send time, but got %v", delay)
+	}
+
+	report := smoothPosition(nil, &bepb.PlayerStatus{SongId: 3, Position: 20}, 250*time.Millisecond, now)
+	if report.elapsed != 20.25 {
+		t.Errorf("Expected the delay to be added to the report, but got %v", report.elapsed)
+	}
+}
+
+func TestReportLatency_whenClockSet_startsOver(t *testing.T) {
+	now := time.Now()
+	sentAt := now.UnixNano()/int64(time.Millisecond) - 100
+
+	latency := new(reportLatency)
+	latency.delay(sentAt, now)
+
+	// the backend's clock is set a minute forward between reports
+	later := now.Add(5 * time.Second)
+	if delay := latency.delay(sentAt+5000, later.Add(time.Minute)); delay != 0 {
+		t.Errorf("Expected a clock change to reset the quickest delivery, but got %v", delay)
+	}
+
+	if delay := latency.delay(sentAt+7000, later.Add(time.Minute+2250*time.Millisecond)); delay != 250*time.Millisecond {
+		t.Errorf("Expected delays measured against the new offset, but got %v", delay)
+	}
+}
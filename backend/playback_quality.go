@@ -0,0 +1,120 @@
+/*
+ * Playback quality lets admins trade picture for bandwidth, such as playing
+ * audio only on a metered hotspot or capping video at 480p on a busy Wi-Fi
+ * network. Each zone has its own quality, which is sent along with every song
+ * its players are told to play. Players pick the quality themselves until an
+ * admin sets one. In audio only mode every zone plays audio only for good,
+ * which keeps the TVs free for other uses, and songs whose video is the
+ * point, like trailers or dance practices, are queued with a warning.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var ErrAudioOnly = errors.New("The party plays audio only, so the quality can't be changed.")
+
+/*
+ * Words in the titles of videos that are meant to be watched rather than
+ * listened to
+ */
+var videoCentricWords = []string{
+	"trailer", "teaser", "gameplay", "walkthrough", "playthrough", "let's play", "speedrun", "vlog", "tutorial",
+	"unboxing", "reaction", "full movie", "full episode", "highlights", "dance practice", "choreography",
+	"mirrored", "dance cover",
+}
+
+/*
+ * Qualities by the names they're set with on the command line
+ */
+var PlaybackQualities = map[string]bepb.PlaybackQuality{
+	"default": bepb.PlaybackQuality_DefaultQuality,
+	"audio":   bepb.PlaybackQuality_AudioQuality,
+	"480p":    bepb.PlaybackQuality_Quality480p,
+	"1080p":   bepb.PlaybackQuality_Quality1080p,
+}
+
+/*
+ * Set the quality the players play songs at and tell the players, so the
+ * songs they're getting ready are fetched at it. The song playing keeps its
+ * quality until the next one starts. Returns the number of players told.
+ */
+func (mgr *playerManager) setQuality(quality bepb.PlaybackQuality) int {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	mgr.quality = quality
+	control := &bepb.PlayerControl{Command: bepb.CommandType_SetQuality, Quality: quality}
+	for _, state := range mgr.streams {
+		go sendToStream(control, state.out)
+	}
+
+	log.Printf("Set the playback quality of zone %d to %v", mgr.zoneId, quality)
+	return len(mgr.streams)
+}
+
+/*
+ * Returns the quality the players play songs at
+ */
+func (mgr *playerManager) playbackQuality() bepb.PlaybackQuality {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+	return mgr.quality
+}
+
+/*
+ * Add the quality to a command that plays a song. Expects the player lock to
+ * be held.
+ */
+func (mgr *playerManager) withQuality(control *bepb.PlayerControl) {
+	if control.GetSong() != nil {
+		control.Quality = mgr.quality
+	}
+}
+
+/*
+ * Returns the word in a song's title giving away that its video is the point,
+ * or an empty string if there's none. Words only match whole.
+ */
+func videoCentricWord(title string) string {
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}), " ") + " "
+
+	for _, word := range videoCentricWords {
+		if strings.Contains(words, " "+word+" ") {
+			return word
+		}
+	}
+	return ""
+}
+
+/*
+ * Returns a warning for the submitter of a song whose video is the point when
+ * the party plays audio only, or an empty string if there's nothing to warn
+ * about
+ */
+func (s *BackendServer) videoWarning(song *cmpb.Song) string {
+	if !s.audioOnly {
+		return ""
+	}
+
+	word := videoCentricWord(song.RawTitle)
+	if word == "" {
+		word = videoCentricWord(song.Title)
+	}
+
+	if word == "" {
+		return ""
+	}
+	return fmt.Sprintf("This looks like a video to watch (%s), but the party plays audio only.", word)
+}
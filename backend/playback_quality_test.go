@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestSetQuality_sentWithSongs(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	speaker := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	playerMgr.add(speaker, cancel)
+
+	if told := playerMgr.setQuality(bepb.PlaybackQuality_AudioQuality); told != 1 {
+		t.Errorf("Expected one player to be told, got %d", told)
+	}
+
+	control := speaker.next(t)
+	if control.Command != bepb.CommandType_SetQuality || control.Quality != bepb.PlaybackQuality_AudioQuality {
+		t.Fatalf("Expected the player told to play audio only, got %v", control)
+	}
+
+	play := &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: &cmpb.Song{SongId: 3}}
+	playerMgr.withQuality(play)
+	if play.Quality != bepb.PlaybackQuality_AudioQuality {
+		t.Errorf("Expected the song to be sent with the quality, got %v", play.Quality)
+	}
+
+	pause := &bepb.PlayerControl{Command: bepb.CommandType_Pause}
+	playerMgr.withQuality(pause)
+	if pause.Quality != bepb.PlaybackQuality_DefaultQuality {
+		t.Errorf("Expected commands without songs to be left alone, got %v", pause.Quality)
+	}
+}
+
+func TestVideoCentricWord(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"Dune: Part Two | Official Trailer 3", "trailer"},
+		{"BLACKPINK - 'Shut Down' DANCE PRACTICE VIDEO", "dance practice"},
+		{"Let's Play Minecraft #12", "let's play"},
+		{"Elden Ring Any% Speedrun (1:02:33)", "speedrun"},
+		{"Daft Punk - Harder, Better, Faster, Stronger", ""},
+		{"The Trailers Park Band - Home", ""},
+		{"Chain Reaction (Remastered)", "reaction"},
+	}
+
+	for _, test := range tests {
+		if word := videoCentricWord(test.title); word != test.expected {
+			t.Errorf("Expected %q for %q, but got %q", test.expected, test.title, word)
+		}
+	}
+}
+
+func TestAudioOnly_warnsAboutVideosAndKeepsQuality(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_audio_only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	fetcher := &titleFetcher{title: "Dune: Part Two | Official Trailer 3"}
+	server.metadata = fetcher
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	user, _ := server.dbManager.AddUser("Kahlan", room.Room.Id)
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/dQw4w9WgXcQ",
+		UserId: user.User.UserId})
+	if response.Message != "Success" {
+		t.Errorf("Expected no warning while videos can play, got %v", response)
+	}
+
+	server.audioOnly = true
+	fetcher.title = "Dune: Messiah | Teaser"
+	response, _ = server.SendSong(context.Background(), &bepb.Submission{Link: "https://youtu.be/9bZkp7q19f0",
+		UserId: user.User.UserId})
+	if !response.Success || !strings.HasPrefix(response.Message, "Queued, but heads up:") ||
+		!strings.Contains(response.Message, "teaser") {
+		t.Errorf("Expected the video to be queued with a warning, got %v", response)
+	}
+
+	request := &bepb.QualityRequest{Quality: bepb.PlaybackQuality_DefaultQuality}
+	if response, _ := server.SetPlaybackQuality(context.Background(), request); response.Message != ErrAudioOnly.Error() {
+		t.Errorf("Expected the quality to stay audio only, got %v", response)
+	}
+
+	request.Quality = bepb.PlaybackQuality_AudioQuality
+	if response, _ := server.SetPlaybackQuality(context.Background(), request); !response.Success {
+		t.Errorf("Expected audio only to be allowed, got %v", response)
+	}
+
+	if info, _ := server.GetServerInfo(context.Background(), &bepb.User{}); !info.AudioOnly {
+		t.Error("Expected the server info to show audio only mode")
+	}
+}
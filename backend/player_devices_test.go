@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestSetOutputDevice_forwardedToPlayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	playerMgr := server.zones.defaultZone.playerMgr
+	playerMgr.start()
+	defer playerMgr.stop()
+
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kitchen := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	kitchenId := playerMgr.add(kitchen, cancel)
+	patio := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	playerMgr.add(patio, cancel)
+
+	response, _ := server.SetOutputDevice(context.Background(),
+		&bepb.OutputDeviceRequest{PlayerId: uint32(kitchenId), Device: "hdmi"})
+	if !response.Success {
+		t.Fatalf("Expected the device to be set, got %v", response)
+	}
+
+	if control := kitchen.next(t); control.Command != bepb.CommandType_SetOutputDevice || control.OutputDevice != "hdmi" {
+		t.Errorf("Expected the kitchen player told to use hdmi, got %v", control)
+	}
+
+	// no player id sets the device of every player in the zone
+	response, _ = server.SetOutputDevice(context.Background(), &bepb.OutputDeviceRequest{Device: "analog"})
+	if !response.Success {
+		t.Fatalf("Expected the device to be set, got %v", response)
+	}
+
+	for _, player := range []*controlRecorder{kitchen, patio} {
+		if control := player.next(t); control.OutputDevice != "analog" {
+			t.Errorf("Expected every player told to use analog, got %v", control)
+		}
+	}
+
+	// players report the device they ended up using
+	playerMgr.updateDevices(kitchenId, &bepb.PlayerStatus{Command: bepb.CommandType_Devices,
+		Devices: []*bepb.AudioDevice{{Name: "analog"}, {Name: "hdmi"}}, OutputDevice: "analog"})
+	devices, _ := server.ListOutputDevices(context.Background(), &bepb.Zone{})
+	if !devices.Err.Success || len(devices.Players) != 2 || devices.Players[0].OutputDevice != "analog" {
+		t.Errorf("Expected the kitchen player's device listed, got %v", devices)
+	}
+}
+
+func TestSetOutputDevice_errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	playerMgr := server.zones.defaultZone.playerMgr
+	gone := playerMgr.add(&controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}, cancel)
+	playerMgr.remove(gone)
+
+	tests := []struct {
+		name    string
+		request *bepb.OutputDeviceRequest
+		message string
+	}{
+		{"no zone", &bepb.OutputDeviceRequest{ZoneId: 99, Device: "hdmi"}, ErrZoneNotFound.Error()},
+		{"no device", &bepb.OutputDeviceRequest{}, "Missing output device."},
+		{"no player", &bepb.OutputDeviceRequest{PlayerId: 42, Device: "hdmi"}, "Player is not connected."},
+		{"player left", &bepb.OutputDeviceRequest{PlayerId: uint32(gone), Device: "hdmi"}, "Player is not connected."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, _ := server.SetOutputDevice(context.Background(), test.request)
+			if response.Success || response.Message != test.message {
+				t.Errorf("Expected %q, got %v", test.message, response)
+			}
+		})
+	}
+}
+
+func TestBluetooth_forwardedToPlayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_bluetooth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	playerMgr := server.zones.defaultZone.playerMgr
+	playerMgr.start()
+	defer playerMgr.stop()
+
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kitchen := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	kitchenId := playerMgr.add(kitchen, cancel)
+	patio := &controlRecorder{controls: make(chan *bepb.PlayerControl, 4)}
+	playerMgr.add(patio, cancel)
+
+	const speaker = "00:11:22:33:44:55"
+	tests := []struct {
+		name    string
+		send    func(context.Context, *bepb.BluetoothRequest) (*bepb.Error, error)
+		command bepb.CommandType
+	}{
+		{"pair", server.PairBluetooth, bepb.CommandType_BluetoothPair},
+		{"connect", server.ConnectBluetooth, bepb.CommandType_BluetoothConnect},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, _ := test.send(context.Background(), &bepb.BluetoothRequest{PlayerId: uint32(kitchenId), Address: speaker})
+			if !response.Success {
+				t.Fatalf("Expected the command to be sent, got %v", response)
+			}
+
+			if control := kitchen.next(t); control.Command != test.command || control.BluetoothAddress != speaker {
+				t.Errorf("Expected the kitchen player told to %s, got %v", test.name, control)
+			}
+
+			// no player id sends the command to every player in the zone
+			if response, _ = test.send(context.Background(), &bepb.BluetoothRequest{Address: speaker}); !response.Success {
+				t.Fatalf("Expected the command to be sent, got %v", response)
+			}
+
+			for _, player := range []*controlRecorder{kitchen, patio} {
+				if control := player.next(t); control.Command != test.command {
+					t.Errorf("Expected every player told to %s, got %v", test.name, control)
+				}
+			}
+		})
+	}
+
+	// players report when pairing or connecting fails
+	playerMgr.updateBluetooth(kitchenId, &bepb.PlayerStatus{Command: bepb.CommandType_BluetoothDevices,
+		BluetoothError: "Failed to pair: org.bluez.Error.AuthenticationFailed"})
+	devices, _ := server.ListBluetoothDevices(context.Background(), &bepb.Zone{})
+	if !devices.Err.Success || len(devices.Players) != 2 || devices.Players[0].LastError == "" {
+		t.Errorf("Expected the kitchen player's error listed, got %v", devices)
+	}
+}
+
+func TestBluetooth_errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_bluetooth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	const speaker = "00:11:22:33:44:55"
+	tests := []struct {
+		name    string
+		request *bepb.BluetoothRequest
+		message string
+	}{
+		{"no zone", &bepb.BluetoothRequest{ZoneId: 99, Address: speaker}, ErrZoneNotFound.Error()},
+		{"no address", &bepb.BluetoothRequest{}, "Missing bluetooth address."},
+		{"no player", &bepb.BluetoothRequest{PlayerId: 42, Address: speaker}, "Player is not connected."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, send := range []func(context.Context, *bepb.BluetoothRequest) (*bepb.Error, error){
+				server.PairBluetooth, server.ConnectBluetooth} {
+
+				if response, _ := send(context.Background(), test.request); response.Success ||
+					response.Message != test.message {
+					t.Errorf("Expected %q, got %v", test.message, response)
+				}
+			}
+		})
+	}
+}
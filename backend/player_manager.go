@@ -9,17 +9,26 @@
 package backend
 
 import (
+	"context"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	"github.com/nguyenmq/ytbox-go/common"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
 const (
 	// The ready status is AND'd together to get an all-ready status
 	PLAYER_BUSY  = false
 	PLAYER_READY = true
+
+	// How long players have to confirm a song started before it goes back to
+	// the head of the queue
+	dispatchTimeout = 30 * time.Second
 )
 
 /*
@@ -34,67 +43,118 @@ type playerMessage struct {
  * Keeps track of state data belonging to a player
  */
 type playerState struct {
-	out  bepb.YtbBePlayer_SongPlayerServer
-	stop chan struct{}
+	out              bepb.YtbBePlayer_SongPlayerServer
+	cancel           context.CancelFunc      // ends the player's stream
+	devices          []*bepb.AudioDevice     // audio output devices reported by the player
+	outputDevice     string                  // audio output device the player is using
+	bluetoothDevices []*bepb.BluetoothDevice // bluetooth speakers reported by the player
+	bluetoothError   string                  // error from the player's last bluetooth command
+	latency          reportLatency           // how long the player's position reports spend in flight
+	supportsVolume   bool                    // true if the player can change its volume
+	registeredName   string                  // name of the registered player on the stream. Empty if unregistered
 }
 
 /*
  * Manages communication between the backend server and remote player clients.
  */
 type playerManager struct {
-	fanIn      chan playerMessage
-	fanOut     chan *bepb.PlayerControl
-	streams    map[int]*playerState
-	ready      map[int]bool
-	playerLock sync.RWMutex
-	streamIds  int
-	queueMgr   *queuer.SongQueueManager
+	fanIn       chan playerMessage
+	fanOut      chan *bepb.PlayerControl
+	done        chan struct{} // closed once the manager is stopped
+	streams     map[int]*playerState
+	ready       map[int]bool
+	playerLock  sync.RWMutex
+	streamIds   int
+	queueMgr    *queuer.SongQueueManager
+	downloader  *songDownloader
+	autoDj      *autoDj           // picks songs when the queue runs dry
+	jingles     *jingleBox        // plays jingles between songs
+	bus         *eventBus         // told as songs start playing
+	zoneId      uint32            // zone the players belong to
+	position    *reportedPosition // last playback position reported by a player
+	upNextShown uint32            // song the up next overlay was last shown for
+	fadedOut    uint32            // song the players were last told to fade out
+	crossfade   time.Duration     // how long songs fade out and in. Zero doesn't fade
+	duckTimer   *time.Timer       // brings the volume back up after ducking. Nil if not ducked for a set time
+
+	playing   uint32              // song the players were last sent. Zero if none
+	playStart time.Time           // when the players started the playing song
+	retried   bool                // true once the playing song was played again after failing
+	failed    uint32              // song the players gave up on after it failed. Zero if none
+	retry     *bepb.PlayerControl // command to play the failed song again. Nil if none is waiting
+
+	confirmWithin time.Duration // how long players have to confirm a song started
+
+	quality bepb.PlaybackQuality // quality songs are played at
 }
 
 /*
  * Initialize the player manager. It still needs to be started after being
  * initialized.
  */
-func (mgr *playerManager) init(queueMgr *queuer.SongQueueManager) {
+func (mgr *playerManager) init(queueMgr *queuer.SongQueueManager, downloader *songDownloader, dj *autoDj,
+	jingles *jingleBox, bus *eventBus) {
 	mgr.fanIn = make(chan playerMessage)
 	mgr.fanOut = make(chan *bepb.PlayerControl)
+	mgr.done = make(chan struct{})
 	mgr.streams = make(map[int]*playerState, 2)
 	mgr.ready = make(map[int]bool, 2)
 	mgr.streamIds = 0
 	mgr.queueMgr = queueMgr
+	mgr.downloader = downloader
+	mgr.autoDj = dj
+	mgr.jingles = jingles
+	mgr.bus = bus
+	mgr.confirmWithin = dispatchTimeout
+	queueMgr.SetReturnHook(func(song *cmpb.Song) {
+		mgr.bus.publish(&bepb.Event{Type: bepb.EventType_SongReturned, ZoneId: mgr.zoneId, Song: song})
+	})
 }
 
 /*
- * Add a player stream for the manager to keep track of. Returns a player id
- * and a channel to signal stop
+ * Add a player stream for the manager to keep track of. The cancel function
+ * ends the player's stream and is called when the manager stops. Returns a
+ * player id.
  */
-func (mgr *playerManager) add(out bepb.YtbBePlayer_SongPlayerServer) (int, chan struct{}) {
+func (mgr *playerManager) add(out bepb.YtbBePlayer_SongPlayerServer, cancel context.CancelFunc) int {
 	mgr.playerLock.Lock()
 	defer mgr.playerLock.Unlock()
 
 	mgr.streamIds++
 	state := new(playerState)
 	state.out = out
-	state.stop = make(chan struct{})
+	state.cancel = cancel
 
 	mgr.streams[mgr.streamIds] = state
 	mgr.ready[mgr.streamIds] = PLAYER_BUSY
 	log.Printf("New player %d", mgr.streamIds)
-	return mgr.streamIds, state.stop
+	return mgr.streamIds
 }
 
 /*
- * Receive messages from all remote players
+ * Receive messages from all remote players. Returns false if the message was
+ * dropped because the player's stream ended or the manager stopped.
  */
-func (mgr *playerManager) receiveFromPlayers(id int, status *bepb.PlayerStatus) {
-	mgr.fanIn <- playerMessage{Id: id, Status: status}
+func (mgr *playerManager) receiveFromPlayers(ctx context.Context, id int, status *bepb.PlayerStatus) bool {
+	select {
+	case mgr.fanIn <- playerMessage{Id: id, Status: status}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-mgr.done:
+		return false
+	}
 }
 
 /*
- * Send commands to all player clients
+ * Send commands to all player clients. The command is dropped if the manager
+ * was stopped.
  */
 func (mgr *playerManager) sendToPlayers(control *bepb.PlayerControl) {
-	mgr.fanOut <- control
+	select {
+	case mgr.fanOut <- control:
+	case <-mgr.done:
+	}
 }
 
 /*
@@ -110,6 +170,104 @@ func (mgr *playerManager) remove(id int) int {
 	return len(mgr.streams)
 }
 
+/*
+ * Returns the number of connected players
+ */
+func (mgr *playerManager) count() int {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+	return len(mgr.streams)
+}
+
+/*
+ * Record the audio output devices reported by a player
+ */
+func (mgr *playerManager) updateDevices(id int, status *bepb.PlayerStatus) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	if state, exists := mgr.streams[id]; exists {
+		state.devices = status.GetDevices()
+		state.outputDevice = status.GetOutputDevice()
+	}
+}
+
+/*
+ * Record the bluetooth speakers reported by a player
+ */
+func (mgr *playerManager) updateBluetooth(id int, status *bepb.PlayerStatus) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	if state, exists := mgr.streams[id]; exists {
+		state.bluetoothDevices = status.GetBluetoothDevices()
+		state.bluetoothError = status.GetBluetoothError()
+	}
+}
+
+/*
+ * Returns the bluetooth speakers of every connected player
+ */
+func (mgr *playerManager) listBluetooth() []*bepb.PlayerBluetooth {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	players := make([]*bepb.PlayerBluetooth, 0, len(mgr.streams))
+	for id, state := range mgr.streams {
+		players = append(players, &bepb.PlayerBluetooth{
+			PlayerId:  uint32(id),
+			Devices:   state.bluetoothDevices,
+			LastError: state.bluetoothError,
+		})
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].PlayerId < players[j].PlayerId
+	})
+
+	return players
+}
+
+/*
+ * Returns the audio output devices of every connected player
+ */
+func (mgr *playerManager) listDevices() []*bepb.PlayerDevices {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	players := make([]*bepb.PlayerDevices, 0, len(mgr.streams))
+	for id, state := range mgr.streams {
+		players = append(players, &bepb.PlayerDevices{
+			PlayerId:     uint32(id),
+			Devices:      state.devices,
+			OutputDevice: state.outputDevice,
+		})
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].PlayerId < players[j].PlayerId
+	})
+
+	return players
+}
+
+/*
+ * Send a command to a single player. Returns false if the player isn't
+ * connected.
+ */
+func (mgr *playerManager) sendToPlayer(id int, control *bepb.PlayerControl) bool {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	state, exists := mgr.streams[id]
+	if !exists {
+		return false
+	}
+
+	go sendToStream(control, state.out)
+	return true
+}
+
 /*
  * Start the the player manager
  */
@@ -119,47 +277,91 @@ func (mgr *playerManager) start() {
 
 		for {
 			select {
-			case control, ok := <-mgr.fanOut:
-				if !ok {
-					close(nextSong)
-					return
-				}
+			case <-mgr.done:
+				return
 
+			case control := <-mgr.fanOut:
 				log.Printf("Sending out command: %v", control.GetCommand())
-				mgr.playerLock.RLock()
+				mgr.playerLock.Lock()
+				if control.GetCommand() == bepb.CommandType_Next {
+					mgr.startedPlaying(control.GetSong(), time.Now())
+				}
+
+				// positions reported before going back are out of date
+				if control.GetCommand() == bepb.CommandType_Previous {
+					mgr.startedPlaying(control.GetSong(), time.Now())
+					mgr.position = nil
+					mgr.fadedOut = 0
+				}
+				mgr.withQuality(control)
 				for _, state := range mgr.streams {
-					go sendToStream(control, state.out)
+					mgr.sendFading(control, state)
 				}
-				mgr.playerLock.RUnlock()
+				mgr.playerLock.Unlock()
 
-			case msg, ok := <-mgr.fanIn:
-				if !ok {
-					close(nextSong)
-					return
+			case msg := <-mgr.fanIn:
+				// positions come in every few seconds, so they're not logged
+				if msg.Status.GetCommand() == bepb.CommandType_Position {
+					mgr.playerLock.Lock()
+					now := time.Now()
+					mgr.updatePosition(msg.Id, msg.Status, now)
+					mgr.confirmPlaying(msg.Status)
+					mgr.showUpNext(now)
+					mgr.fadeOut(now)
+					mgr.playerLock.Unlock()
+					continue
 				}
 
 				log.Printf("Player %d status: %v", msg.Id, msg.Status.GetCommand())
+				if len(msg.Status.GetDevices()) > 0 || msg.Status.GetCommand() == bepb.CommandType_Devices {
+					mgr.updateDevices(msg.Id, msg.Status)
+				}
+
+				if msg.Status.GetCommand() == bepb.CommandType_BluetoothDevices {
+					mgr.updateBluetooth(msg.Id, msg.Status)
+				}
+
+				// a song that failed was still handed off, so it isn't
+				// returned to the queue either
+				if msg.Status.GetCommand() == bepb.CommandType_Playing ||
+					msg.Status.GetCommand() == bepb.CommandType_Failed {
+					mgr.confirmPlaying(msg.Status)
+				}
+
+				if msg.Status.GetCommand() == bepb.CommandType_Failed {
+					mgr.playerLock.Lock()
+					mgr.songFailed(msg.Id, msg.Status, time.Now())
+					mgr.playerLock.Unlock()
+				}
+
 				if msg.Status.GetCommand() == bepb.CommandType_Ready {
+					mgr.updateVolumeSupport(msg.Id, msg.Status)
+
 					// Update the ready status of the current player
 					mgr.playerLock.Lock()
 					mgr.ready[msg.Id] = PLAYER_READY
 					mgr.playerLock.Unlock()
 
-					// Check if they're all ready
-					if mgr.playersReady() {
+					// Check if they're all ready, giving a song that just
+					// failed another go before moving on
+					if mgr.playersReady() && !mgr.replayFailed(time.Now()) {
 						go mgr.getNextSong(nextSong)
 					}
 				}
 
-			case control, ok := <-nextSong:
+			case control := <-nextSong:
 				// Send the song popped off the playlist to all the players and
 				// then reset their ready flags
-				if ok && control.GetCommand() == bepb.CommandType_Play {
+				if control.GetCommand() == bepb.CommandType_Play {
 					mgr.playerLock.Lock()
+					mgr.withQuality(&control)
 					for id, state := range mgr.streams {
-						go sendToStream(&control, state.out)
+						mgr.sendFading(&control, state)
 						mgr.ready[id] = PLAYER_BUSY
 					}
+					mgr.upNextShown = 0
+					mgr.fadedOut = 0
+					mgr.startedPlaying(control.GetSong(), time.Now())
 					mgr.playerLock.Unlock()
 				}
 			}
@@ -173,41 +375,85 @@ func (mgr *playerManager) start() {
  * the function is called while the playlist is empty.
  */
 func (mgr *playerManager) getNextSong(nextSong chan<- bepb.PlayerControl) {
+	// Keep the music going from the history if nobody queued anything, unless
+	// a dj has the decks
+	if mgr.queueMgr.Len() == 0 && !mgr.autoDj.holding(mgr.zoneId) {
+		if song := mgr.autoDj.pick(mgr.queueMgr.NowPlaying()); song != nil {
+			mgr.queueMgr.AddSong(song)
+		}
+	}
+
 	// Wait for there to be at least one song in the playlist
 	mgr.queueMgr.WaitForMoreSongs()
 
 	// Do a final check to see if all players are ready for the next song
 	if mgr.playersReady() {
-		song := mgr.queueMgr.PopQueue()
-		mgr.queueMgr.SavePlaylist(queuer.QueueSnapshot)
-		log.Println("Popped song")
+		if ended := mgr.queueMgr.NowPlaying(); ended != nil {
+			mgr.bus.publish(&bepb.Event{Type: bepb.EventType_SongEnded, ZoneId: mgr.zoneId, Song: ended,
+				Outcome: mgr.outcomeOf(ended)})
+		}
+
+		// a jingle plays in place of the next song, which stays queued
+		song := mgr.jingles.due(mgr.zoneId, time.Now())
+		if song != nil {
+			mgr.queueMgr.SetNowPlaying(song)
+			log.Printf("Playing jingle: %s", song.Title)
+		} else {
+			song = mgr.queueMgr.Dispatch(mgr.confirmWithin)
+			mgr.jingles.count(mgr.zoneId)
+			log.Println("Popped song")
+		}
 		control := bepb.PlayerControl{}
 
 		if song != nil {
 			control.Command = bepb.CommandType_Play
 			control.Song = song
+			control.LocalPath = mgr.downloader.lookup(song)
+			upcoming := mgr.queueMgr.GetPlaylist().Songs
+			control.Upcoming = mgr.downloader.hints(upcoming)
+			mgr.downloader.prefetch(upcoming)
 		} else {
 			mgr.queueMgr.ClearNowPlaying()
 			control.Command = bepb.CommandType_None
 		}
 
-		nextSong <- control
+		select {
+		case nextSong <- control:
+			if song != nil {
+				mgr.bus.publish(&bepb.Event{Type: bepb.EventType_SongPlaying, ZoneId: mgr.zoneId, Song: song})
+			}
+		case <-mgr.done:
+		}
 	}
 }
 
 /*
- * Stop the player manager and tell the goroutines listening on each stream to
- * stop
+ * Confirm the song a player reported on started playing, so it leaves the
+ * queue for good
+ */
+func (mgr *playerManager) confirmPlaying(status *bepb.PlayerStatus) {
+	if mgr.queueMgr.ConfirmDispatched(status.GetSongId()) {
+		log.Printf("Player confirmed song %d started", status.GetSongId())
+	}
+}
+
+/*
+ * Stop the player manager and end the stream of every connected player.
+ * Messages sent to the manager after it stops are dropped.
  */
 func (mgr *playerManager) stop() {
 	mgr.playerLock.Lock()
 	defer mgr.playerLock.Unlock()
 
 	for _, state := range mgr.streams {
-		state.stop <- struct{}{}
+		state.cancel()
+	}
+
+	if mgr.duckTimer != nil {
+		mgr.duckTimer.Stop()
 	}
 
-	close(mgr.fanIn)
+	close(mgr.done)
 }
 
 /*
@@ -234,5 +480,6 @@ func (mgr *playerManager) playersReady() bool {
  * Provides a goroutine for sending out the player control to the given stream
  */
 func sendToStream(control *bepb.PlayerControl, out bepb.YtbBePlayer_SongPlayerServer) {
+	common.Debugf("Sending %v to a player: {song: %d}", control.Command, control.GetSong().GetSongId())
 	out.Send(control)
 }
@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func setupPlayerManager() *playerManager {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+
+	downloader := new(songDownloader)
+	downloader.init("", 0)
+
+	playerMgr := new(playerManager)
+	playerMgr.init(queueMgr, downloader, nil, nil, nil)
+	return playerMgr
+}
+
+/*
+ * Fail the test if the function doesn't return within a second
+ */
+func finishesInTime(t *testing.T, name string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return in time", name)
+	}
+}
+
+func TestPlayerManagerStop_cancelsPlayerStreams(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	playerMgr.add(nil, cancel)
+
+	finishesInTime(t, "stop", playerMgr.stop)
+
+	if ctx.Err() == nil {
+		t.Errorf("Stopping the manager should cancel the player's stream")
+	}
+}
+
+func TestPlayerManagerStop_whenPlayerLeaving_doesNotDeadlock(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.start()
+
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	id := playerMgr.add(nil, cancel)
+
+	go playerMgr.remove(id)
+	finishesInTime(t, "stop", playerMgr.stop)
+}
+
+func TestPlayerManagerAfterStop_dropsMessages(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.start()
+	playerMgr.stop()
+
+	finishesInTime(t, "receiveFromPlayers", func() {
+		status := &bepb.PlayerStatus{Command: bepb.CommandType_Ready}
+		if playerMgr.receiveFromPlayers(context.Background(), 1, status) {
+			t.Errorf("Status should be dropped after the manager stops")
+		}
+	})
+
+	finishesInTime(t, "sendToPlayers", func() {
+		playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Pause})
+	})
+}
+
+/*
+ * Fail the test if the queue doesn't reach the length within a second
+ */
+func waitForQueueLen(t *testing.T, queueMgr *queuer.SongQueueManager, length int) {
+	deadline := time.Now().Add(time.Second)
+	for queueMgr.Len() != length {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d songs queued, got %d", length, queueMgr.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatch_withoutConfirmation_returnsSongToHead(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	queueMgr := playerMgr.queueMgr
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	queueMgr.AddSong(queuedSong(2, 2, "PT3M"))
+
+	returned := make(chan *cmpb.Song, 1)
+	queueMgr.SetReturnHook(func(song *cmpb.Song) { returned <- song })
+
+	song := queueMgr.Dispatch(10 * time.Millisecond)
+	if song.SongId != 1 || queueMgr.Len() != 1 {
+		t.Fatalf("Expected song 1 dispatched, got %v with %d songs queued", song, queueMgr.Len())
+	}
+
+	select {
+	case song := <-returned:
+		if song.SongId != 1 {
+			t.Errorf("Expected song 1 returned, got %v", song)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The unconfirmed song wasn't returned")
+	}
+
+	// it's back at the head and the same user isn't charged another turn
+	playlist := queueMgr.GetPlaylist().Songs
+	if len(playlist) != 2 || playlist[0].SongId != 1 || playlist[1].SongId != 2 {
+		t.Errorf("Expected song 1 back ahead of song 2, got %v", playlist)
+	}
+
+	if queueMgr.NowPlaying() != nil || queueMgr.Dispatched() != nil {
+		t.Errorf("Expected nothing playing after the song returned, got %v", queueMgr.NowPlaying())
+	}
+}
+
+func TestDispatch_whenPlayerConfirms_songLeavesQueue(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	queueMgr := playerMgr.queueMgr
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+
+	queueMgr.Dispatch(20 * time.Millisecond)
+	playerMgr.confirmPlaying(&bepb.PlayerStatus{Command: bepb.CommandType_Playing, SongId: 1})
+
+	time.Sleep(50 * time.Millisecond)
+	if queueMgr.Len() != 0 || queueMgr.NowPlaying().GetSongId() != 1 {
+		t.Errorf("Expected the confirmed song to stay playing, got %d songs queued", queueMgr.Len())
+	}
+}
+
+func TestDispatch_whenOtherSongConfirmed_stillReturns(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	queueMgr := playerMgr.queueMgr
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+
+	queueMgr.Dispatch(10 * time.Millisecond)
+	playerMgr.confirmPlaying(&bepb.PlayerStatus{Command: bepb.CommandType_Position, SongId: 7})
+	waitForQueueLen(t, queueMgr, 1)
+}
+
+func TestDispatch_nextSong_letsConfirmedSongGo(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	queueMgr := playerMgr.queueMgr
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	queueMgr.AddSong(queuedSong(2, 2, "PT3M"))
+
+	// the first song was skipped before it was confirmed, and the skip is
+	// done with it, so only the second one can come back
+	queueMgr.Dispatch(10 * time.Millisecond)
+	queueMgr.ConfirmDispatched(1)
+	queueMgr.Dispatch(10 * time.Millisecond)
+	waitForQueueLen(t, queueMgr, 1)
+
+	time.Sleep(30 * time.Millisecond)
+	if playlist := queueMgr.GetPlaylist().Songs; len(playlist) != 1 || playlist[0].SongId != 2 {
+		t.Errorf("Expected only song 2 back in the queue, got %v", playlist)
+	}
+}
+
+func TestDispatch_beforeEarlierSongConfirmed_returnsIt(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	queueMgr := playerMgr.queueMgr
+	queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	queueMgr.AddSong(queuedSong(2, 2, "PT3M"))
+
+	returned := make(chan *cmpb.Song, 1)
+	queueMgr.SetReturnHook(func(song *cmpb.Song) { returned <- song })
+
+	queueMgr.Dispatch(time.Hour)
+	if song := queueMgr.Dispatch(time.Hour); song.SongId != 2 || queueMgr.Dispatched() != song {
+		t.Fatalf("Expected song 2 dispatched, got %v", song)
+	}
+
+	select {
+	case song := <-returned:
+		if song.SongId != 1 {
+			t.Errorf("Expected song 1 returned, got %v", song)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The unconfirmed song wasn't returned")
+	}
+
+	if playlist := queueMgr.GetPlaylist().Songs; len(playlist) != 1 || playlist[0].SongId != 1 {
+		t.Errorf("Expected song 1 back in the queue, got %v", playlist)
+	}
+
+	// song 1 never played, so there's nothing to go back to
+	if previous := queueMgr.PreviousSong(); previous != nil {
+		t.Errorf("Expected no song to go back to, got %v", previous)
+	}
+}
+
+func TestPopQueue_withArtistGap_defersSameArtist(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewFifoQueuer())
+	queueMgr.SetArtistGap(time.Hour)
+
+	for id, artist := range []string{"Daft Punk", "daft punk", "Justice", ""} {
+		song := queuedSong(uint32(id+1), 1, "PT3M")
+		song.Artist = artist
+		queueMgr.AddSong(song)
+	}
+
+	// song 2 waits behind the other artists instead of being dropped
+	var played []uint32
+	for queueMgr.Len() > 0 {
+		played = append(played, queueMgr.PopQueue().SongId)
+	}
+
+	expected := []uint32{1, 3, 4, 2}
+	if fmt.Sprint(played) != fmt.Sprint(expected) {
+		t.Errorf("Expected songs to play in order %v, but got %v", expected, played)
+	}
+}
+
+func TestDispatch_whenReturned_doesNotDeferOwnArtist(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewFifoQueuer())
+	queueMgr.SetArtistGap(time.Hour)
+
+	first := queuedSong(1, 1, "PT3M")
+	first.Artist = "Daft Punk"
+	second := queuedSong(2, 2, "PT3M")
+	second.Artist = "Justice"
+	queueMgr.AddSong(first)
+	queueMgr.AddSong(second)
+
+	queueMgr.Dispatch(10 * time.Millisecond)
+	waitForQueueLen(t, queueMgr, 2)
+
+	if song := queueMgr.PopQueue(); song.SongId != 1 {
+		t.Errorf("Expected the returned song to play next, but got %v", song)
+	}
+}
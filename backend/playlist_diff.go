@@ -0,0 +1,147 @@
+/*
+ * Compares playlists, such as an old snapshot against the live queue before
+ * restoring it over a party. Songs are matched by their service id and the
+ * name of their submitter, since the same song has another id in another
+ * database. Matched songs that are part of the longest run kept in the same
+ * order in both playlists stayed in place and the rest of them moved.
+ */
+
+package backend
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * A song found in both playlists
+ */
+type diffPair struct {
+	from int // position in the playlist compared from
+	to   int // position in the playlist compared to
+}
+
+/*
+ * Load the songs of a playlist to compare: a snapshot or playlist file, or
+ * the default zone's live queue if the path is empty. The now playing song
+ * comes first.
+ */
+func (s *BackendServer) comparedSongs(path string) ([]*cmpb.Song, error) {
+	if path == "" {
+		nowPlaying, queued := s.queueMgr.Snapshot()
+		songs := make([]*cmpb.Song, 0, len(queued)+1)
+		if nowPlaying != nil && !nowPlaying.Jingle {
+			songs = append(songs, s.revealSubmitter(nowPlaying))
+		}
+
+		// anonymous songs are matched by their submitter like the rest
+		for _, song := range queued {
+			songs = append(songs, s.revealSubmitter(song))
+		}
+		return songs, nil
+	}
+
+	in, err := s.snapshots.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := new(bepb.Snapshot)
+	if err = proto.Unmarshal(in, snapshot); err != nil {
+		return nil, err
+	}
+
+	songs := make([]*cmpb.Song, 0, len(snapshot.Songs)+1)
+	if snapshot.NowPlaying != nil {
+		songs = append(songs, snapshot.NowPlaying)
+	}
+	return append(songs, snapshot.Songs...), nil
+}
+
+/*
+ * Returns what changed from one playlist to the other. Copies of the same
+ * song are paired up in the order they appear.
+ */
+func diffPlaylists(before []*cmpb.Song, after []*cmpb.Song) *bepb.PlaylistDiff {
+	diff := new(bepb.PlaylistDiff)
+
+	unmatched := make(map[string][]int)
+	for i, song := range before {
+		key := diffKey(song)
+		unmatched[key] = append(unmatched[key], i)
+	}
+
+	matched := make([]bool, len(before))
+	pairs := make([]diffPair, 0, len(after))
+	for j, song := range after {
+		key := diffKey(song)
+		if copies := unmatched[key]; len(copies) > 0 {
+			unmatched[key] = copies[1:]
+			matched[copies[0]] = true
+			pairs = append(pairs, diffPair{from: copies[0], to: j})
+		} else {
+			diff.Added = append(diff.Added, song)
+		}
+	}
+
+	for i, song := range before {
+		if !matched[i] {
+			diff.Removed = append(diff.Removed, song)
+		}
+	}
+
+	kept := keptInPlace(pairs)
+	for k, pair := range pairs {
+		if kept[k] {
+			diff.Unchanged++
+			continue
+		}
+
+		diff.Moved = append(diff.Moved, &bepb.MovedSong{Song: after[pair.to], From: uint32(pair.from),
+			To: uint32(pair.to)})
+	}
+
+	return diff
+}
+
+/*
+ * Marks the longest run of pairs, not necessarily back to back, that are in
+ * the same order in both playlists. Expects the pairs in the order of the
+ * playlist compared to.
+ */
+func keptInPlace(pairs []diffPair) []bool {
+	length := make([]int, len(pairs))
+	previous := make([]int, len(pairs))
+	best := -1
+
+	for k := range pairs {
+		length[k], previous[k] = 1, -1
+		for m := 0; m < k; m++ {
+			if pairs[m].from < pairs[k].from && length[m]+1 > length[k] {
+				length[k], previous[k] = length[m]+1, m
+			}
+		}
+
+		if best < 0 || length[k] > length[best] {
+			best = k
+		}
+	}
+
+	kept := make([]bool, len(pairs))
+	for k := best; k >= 0; k = previous[k] {
+		kept[k] = true
+	}
+
+	return kept
+}
+
+/*
+ * Returns what a song is matched by across playlists
+ */
+func diffKey(song *cmpb.Song) string {
+	return fmt.Sprintf("%d/%s/%s", song.Service, song.ServiceId, song.Username)
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func diffSong(serviceId string, username string) *cmpb.Song {
+	return &cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: serviceId, Username: username, Title: serviceId}
+}
+
+func TestDiffPlaylists_sameOrder_allUnchanged(t *testing.T) {
+	before := []*cmpb.Song{diffSong("a", "ann"), diffSong("b", "bob"), diffSong("c", "cat")}
+	after := []*cmpb.Song{diffSong("a", "ann"), diffSong("b", "bob"), diffSong("c", "cat")}
+
+	diff := diffPlaylists(before, after)
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Moved) != 0 {
+		t.Fatalf("Expected no changes, but got %v", diff)
+	}
+
+	if diff.Unchanged != 3 {
+		t.Errorf("Expected 3 unchanged songs, but got %d", diff.Unchanged)
+	}
+}
+
+func TestDiffPlaylists_addedAndRemoved(t *testing.T) {
+	before := []*cmpb.Song{diffSong("a", "ann"), diffSong("b", "bob")}
+	after := []*cmpb.Song{diffSong("a", "ann"), diffSong("c", "cat"), diffSong("b", "cat")}
+
+	diff := diffPlaylists(before, after)
+	if len(diff.Added) != 2 || diff.Added[0].ServiceId != "c" || diff.Added[1].Username != "cat" {
+		t.Errorf("Expected c and cat's b to be added, but got %v", diff.Added)
+	}
+
+	if len(diff.Removed) != 1 || diff.Removed[0].Username != "bob" {
+		t.Errorf("Expected bob's b to be removed, but got %v", diff.Removed)
+	}
+
+	if diff.Unchanged != 1 || len(diff.Moved) != 0 {
+		t.Errorf("Expected only a to stay in place, but got %v", diff)
+	}
+}
+
+func TestDiffPlaylists_movedToFront_onlyThatSongMoved(t *testing.T) {
+	before := []*cmpb.Song{diffSong("a", "ann"), diffSong("b", "bob"), diffSong("c", "cat"), diffSong("d", "dan")}
+	after := []*cmpb.Song{diffSong("d", "dan"), diffSong("a", "ann"), diffSong("b", "bob"), diffSong("c", "cat")}
+
+	diff := diffPlaylists(before, after)
+	if len(diff.Moved) != 1 {
+		t.Fatalf("Expected one song to move, but got %v", diff.Moved)
+	}
+
+	moved := diff.Moved[0]
+	if moved.Song.ServiceId != "d" || moved.From != 3 || moved.To != 0 {
+		t.Errorf("Expected d to move from 3 to 0, but got %v", moved)
+	}
+
+	if diff.Unchanged != 3 {
+		t.Errorf("Expected 3 unchanged songs, but got %d", diff.Unchanged)
+	}
+}
+
+func TestDiffPlaylists_duplicates_pairedInOrder(t *testing.T) {
+	before := []*cmpb.Song{diffSong("a", "ann"), diffSong("a", "ann")}
+	after := []*cmpb.Song{diffSong("a", "ann")}
+
+	diff := diffPlaylists(before, after)
+	if len(diff.Removed) != 1 || diff.Unchanged != 1 {
+		t.Errorf("Expected one copy removed and one unchanged, but got %v", diff)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Presets save the queue settings of recurring events, like "Friday Standup
+ * Tunes", so they can be set up again with one rpc. A preset picks the queue
+ * algorithm, the song length cap, the submission window, a playlist for the
+ * auto dj to fall back on and the hours the queue takes submissions.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const clockLayout = "15:04" // layout of the opening and closing times of presets
+
+var ErrInvalidClock = errors.New("Times must be given as HH:MM.")
+
+/*
+ * Limits on submissions that presets change while the server runs
+ */
+type queueLimits struct {
+	maxMinutes uint32        // longest song accepted in minutes
+	window     time.Duration // songs must start within this long. Zero allows any wait
+	openAt     int           // minute of the day the queue opens. Always open if equal to closeAt
+	closeAt    int           // minute of the day the queue closes
+}
+
+/*
+ * Returns the limits a preset sets
+ */
+func presetLimits(preset *bepb.Preset) (queueLimits, error) {
+	limits := queueLimits{
+		maxMinutes: preset.MaxMinutes,
+		window:     time.Duration(preset.SubmissionWindow) * time.Second,
+	}
+
+	if limits.maxMinutes == 0 {
+		limits.maxMinutes = allowedMinutes
+	}
+
+	if preset.OpenAt == "" && preset.CloseAt == "" {
+		return limits, nil
+	}
+
+	var err error
+	if limits.openAt, err = parseClock(preset.OpenAt); err != nil {
+		return limits, err
+	}
+
+	if limits.closeAt, err = parseClock(preset.CloseAt); err != nil {
+		return limits, err
+	}
+
+	return limits, nil
+}
+
+/*
+ * Returns true if the queue takes submissions at the given time. The open
+ * hours may run past midnight.
+ */
+func (l queueLimits) isOpen(now time.Time) bool {
+	if l.openAt == l.closeAt {
+		return true
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if l.openAt < l.closeAt {
+		return minute >= l.openAt && minute < l.closeAt
+	}
+
+	return minute >= l.openAt || minute < l.closeAt
+}
+
+/*
+ * Returns when the queue next opens after the given time. The opening time is
+ * read off the wall clock, so it stays put on days daylight saving time
+ * starts or ends.
+ */
+func (l queueLimits) nextOpen(now time.Time) time.Time {
+	open := time.Date(now.Year(), now.Month(), now.Day(), l.openAt/60, l.openAt%60, 0, 0, now.Location())
+	if !open.After(now) {
+		open = open.AddDate(0, 0, 1)
+	}
+
+	return open
+}
+
+/*
+ * Returns the minute of the day of a time written as HH:MM
+ */
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse(clockLayout, clock)
+	if err != nil {
+		return 0, fmt.Errorf("%w Got %q", ErrInvalidClock, clock)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+/*
+ * Returns a function that creates queues ordered by the algorithm. Hybrid
+ * queues weigh votes by the weights.
+ */
+func queuerFor(algorithm bepb.QueueAlgorithm, weights queuer.HybridWeights) func() queuer.SongQueuer {
+	switch algorithm {
+	case bepb.QueueAlgorithm_Fifo:
+		return func() queuer.SongQueuer { return queuer.NewFifoQueuer() }
+	case bepb.QueueAlgorithm_Hybrid:
+		return func() queuer.SongQueuer { return queuer.NewHybridQueuer(weights) }
+	}
+
+	return newRoundRobinQueuer
+}
+
+/*
+ * Returns how far votes move songs in hybrid queues
+ */
+func voteWeights(config *ServerConfig) queuer.HybridWeights {
+	weights := queuer.DefaultHybridWeights
+	if config.RotationVoteWeight >= 0 {
+		weights.Rotation = config.RotationVoteWeight
+	}
+
+	if config.BucketVoteWeight >= 0 {
+		weights.Bucket = config.BucketVoteWeight
+	}
+
+	return weights
+}
+
+/*
+ * Returns the current limits on submissions
+ */
+func (s *BackendServer) currentLimits() queueLimits {
+	s.limitsLock.RLock()
+	defer s.limitsLock.RUnlock()
+	return s.limits
+}
+
+/*
+ * Apply the settings of a preset to the server
+ */
+func (s *BackendServer) applyPreset(preset *bepb.Preset) error {
+	limits, err := presetLimits(preset)
+	if err != nil {
+		return err
+	}
+
+	s.zones.swapQueuers(queuerFor(preset.Algorithm, s.voteWeights))
+	s.autoDj.usePlaylist(preset.FallbackCode)
+
+	s.limitsLock.Lock()
+	s.limits = limits
+	s.limitsLock.Unlock()
+
+	// the preset is for another event, so the last one is over
+	s.recapper.endLogged(time.Now())
+	s.boarding.restart(time.Now())
+
+	log.Printf("Applied preset: {name: %s, algorithm: %v, max minutes: %d, window: %v, fallback: %s, open: %s-%s}",
+		preset.Name, preset.Algorithm, limits.maxMinutes, limits.window, preset.FallbackCode, preset.OpenAt,
+		preset.CloseAt)
+	return nil
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func clockTime(hour int, minute int) time.Time {
+	return time.Date(2020, time.March, 6, hour, minute, 0, 0, time.Local)
+}
+
+func TestPresetLimits_whenDefaults_usesAllowedMinutes(t *testing.T) {
+	limits, err := presetLimits(&bepb.Preset{Name: "Anytime", SubmissionWindow: 3600})
+	if err != nil {
+		t.Fatalf("Failed to get limits: %v", err)
+	}
+
+	if limits.maxMinutes != allowedMinutes || limits.window != time.Hour {
+		t.Errorf("Expected %d minutes and a 1h window, got %+v", allowedMinutes, limits)
+	}
+
+	if !limits.isOpen(clockTime(3, 0)) {
+		t.Errorf("Queue without open hours should always be open")
+	}
+}
+
+func TestPresetLimits_whenInvalidClock_returnsErr(t *testing.T) {
+	if _, err := presetLimits(&bepb.Preset{OpenAt: "5pm", CloseAt: "23:00"}); err == nil {
+		t.Errorf("Expected an error for an invalid opening time")
+	}
+}
+
+func TestQueueLimits_isOpen(t *testing.T) {
+	standup, _ := presetLimits(&bepb.Preset{OpenAt: "09:00", CloseAt: "09:30"})
+	party, _ := presetLimits(&bepb.Preset{OpenAt: "20:00", CloseAt: "02:00"})
+
+	tests := []struct {
+		limits queueLimits
+		now    time.Time
+		open   bool
+	}{
+		{standup, clockTime(8, 59), false},
+		{standup, clockTime(9, 0), true},
+		{standup, clockTime(9, 30), false},
+		{party, clockTime(19, 0), false},
+		{party, clockTime(23, 0), true},
+		{party, clockTime(1, 59), true},
+		{party, clockTime(2, 0), false},
+	}
+
+	for _, test := range tests {
+		if open := test.limits.isOpen(test.now); open != test.open {
+			t.Errorf("isOpen(%s) = %t, expected %t", test.now.Format(clockLayout), open, test.open)
+		}
+	}
+}
+
+func TestQueueLimits_nextOpen(t *testing.T) {
+	limits, _ := presetLimits(&bepb.Preset{OpenAt: "20:00", CloseAt: "02:00"})
+
+	if next := limits.nextOpen(clockTime(15, 0)); !next.Equal(clockTime(20, 0)) {
+		t.Errorf("Expected the queue to open at 20:00 today, got %v", next)
+	}
+
+	if next := limits.nextOpen(clockTime(3, 0)); !next.Equal(clockTime(20, 0)) {
+		t.Errorf("Expected the queue to open at 20:00 today, got %v", next)
+	}
+
+	if next := limits.nextOpen(clockTime(21, 0)); !next.Equal(clockTime(20, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Expected the queue to open at 20:00 tomorrow, got %v", next)
+	}
+}
+
+func TestQueueLimits_nextOpen_whenDaylightSavingStarts(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone data isn't installed: %v", err)
+	}
+
+	// clocks in New York moved forward an hour at 2:00 on March 8, 2020
+	limits, _ := presetLimits(&bepb.Preset{OpenAt: "20:00", CloseAt: "02:00"})
+	now := time.Date(2020, time.March, 8, 12, 0, 0, 0, newYork)
+
+	next := limits.nextOpen(now)
+	if next.Hour() != 20 || next.Minute() != 0 || next.Day() != 8 {
+		t.Errorf("Expected the queue to open at 20:00 on the 8th, got %v", next)
+	}
+}
+
+func TestZoneManager_swapQueuers_keepsSongs(t *testing.T) {
+	zones := setupZones()
+	queueMgr := zones.defaultZone.queueMgr
+	shared := zones.add(1, "kitchen", true)
+
+	// user 1 queues two songs before user 2, which round robin interleaves
+	for _, song := range []*cmpb.Song{{SongId: 1, UserId: 1}, {SongId: 2, UserId: 1}, {SongId: 3, UserId: 2}} {
+		queueMgr.AddSong(song)
+	}
+
+	zones.swapQueuers(queuerFor(bepb.QueueAlgorithm_Fifo, queuer.DefaultHybridWeights))
+
+	// songs keep the order they were going to play in
+	playlist := shared.queueMgr.GetPlaylist().Songs
+	if len(playlist) != 3 || playlist[0].SongId != 1 || playlist[1].SongId != 3 || playlist[2].SongId != 2 {
+		t.Fatalf("Expected songs 1, 3, 2 after swapping, got %v", playlist)
+	}
+
+	// new songs go in submission order now
+	queueMgr.AddSong(&cmpb.Song{SongId: 4, UserId: 2})
+	queueMgr.AddSong(&cmpb.Song{SongId: 5, UserId: 3})
+	playlist = queueMgr.GetPlaylist().Songs
+	if len(playlist) != 5 || playlist[3].SongId != 4 || playlist[4].SongId != 5 {
+		t.Errorf("Expected new songs at the end of a fifo queue, got %v", playlist)
+	}
+}
+
+func TestZoneManager_swapQueuers_toHybrid_letsVotesMoveSongs(t *testing.T) {
+	zones := setupZones()
+	queueMgr := zones.defaultZone.queueMgr
+	for _, song := range []*cmpb.Song{{SongId: 1, UserId: 1}, {SongId: 2, UserId: 1}, {SongId: 3, UserId: 2}} {
+		queueMgr.AddSong(song)
+	}
+
+	zones.swapQueuers(queuerFor(bepb.QueueAlgorithm_Hybrid, queuer.DefaultHybridWeights))
+
+	// a vote gives song 2 its submitter's first turn, ahead of song 1
+	queueMgr.VoteSong(2)
+	playlist := queueMgr.GetPlaylist().Songs
+	if len(playlist) != 3 || playlist[0].SongId != 2 || playlist[1].SongId != 3 || playlist[2].SongId != 1 {
+		t.Fatalf("Expected songs 2, 3, 1 after the vote, got %v", playlist)
+	}
+
+	// enough votes move song 1 back ahead, and a round ahead of the others
+	queueMgr.AddSong(&cmpb.Song{SongId: 4, UserId: 3})
+	for i := 0; i < 4; i++ {
+		queueMgr.VoteSong(1)
+	}
+
+	playlist = queueMgr.GetPlaylist().Songs
+	if len(playlist) != 4 || playlist[0].SongId != 1 || playlist[1].SongId != 3 || playlist[2].SongId != 4 ||
+		playlist[3].SongId != 2 {
+		t.Fatalf("Expected songs 1, 3, 4, 2 after the votes, got %v", playlist)
+	}
+
+	for _, songId := range []uint32{1, 3, 4, 2} {
+		if song := queueMgr.PopQueue(); song.SongId != songId {
+			t.Fatalf("Expected song %d to play next, got %v", songId, song)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Checks the songs of a playlist loaded at start up before they're queued.
+ * Playlists saved by an old backend or against another database can carry
+ * songs that would break the player or the history, so songs with malformed
+ * service ids or unknown submitters are dropped, songs missing from the
+ * database are recorded again and missing titles are filled back in.
+ */
+
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	"github.com/nguyenmq/ytbox-go/links"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * What checking a playlist repaired
+ */
+type queueRepairs struct {
+	checked   int // songs checked
+	malformed int // songs dropped for malformed service ids
+	unknown   int // songs dropped because their submitter isn't in the database
+	recorded  int // songs recorded in the database again under new ids
+	failed    int // songs dropped because they couldn't be recorded under new ids
+	retitled  int // songs whose missing titles were filled back in
+	untitled  int // songs whose titles couldn't be found, which are shown by their service ids
+}
+
+/*
+ * Returns true if checking the playlist changed anything
+ */
+func (r queueRepairs) repaired() bool {
+	return r.malformed+r.unknown+r.recorded+r.failed+r.retitled+r.untitled > 0
+}
+
+func (r queueRepairs) String() string {
+	if !r.repaired() {
+		return fmt.Sprintf("checked %d songs, nothing to repair", r.checked)
+	}
+
+	return fmt.Sprintf("checked %d songs: dropped %d with malformed ids, %d from unknown users and %d that "+
+		"couldn't be recorded, recorded %d missing from the database, refreshed %d titles and couldn't find %d",
+		r.checked, r.malformed, r.unknown, r.failed, r.recorded, r.retitled, r.untitled)
+}
+
+/*
+ * Check the songs of a playlist against the database and metadata before
+ * they're queued. Returns the songs to queue, in order, and what was
+ * repaired.
+ */
+func (s *BackendServer) repairQueue(songs []*cmpb.Song) ([]*cmpb.Song, queueRepairs) {
+	repairs := queueRepairs{checked: len(songs)}
+	kept := make([]*cmpb.Song, 0, len(songs))
+	taken := make(map[uint32]bool, len(songs))
+
+	for _, song := range songs {
+		if !validServiceId(song.Service, song.ServiceId) {
+			log.Printf("Dropping song %d with malformed id %q", song.SongId, song.ServiceId)
+			repairs.malformed++
+			continue
+		}
+
+		if username, _ := s.getUserFromId(song.UserId); username == "" {
+			log.Printf("Dropping song %s from unknown user %d", song.ServiceId, song.UserId)
+			repairs.unknown++
+			continue
+		}
+
+		recorded, err := recordedSong(s.dbManager, song, taken)
+		if err != nil {
+			// keep the song rather than lose it to a database hiccup
+			log.Printf("Failed to look up song %d: %v", song.SongId, err)
+		} else if recorded != nil {
+			if song.Title == "" && recorded.Title != "" {
+				song.Title, song.RawTitle, song.CleanTitle = recorded.Title, recorded.RawTitle, recorded.CleanTitle
+				repairs.retitled++
+			}
+		} else {
+			// the song is from another database or its id is already taken,
+			// so it gets a new id in this one
+			s.refreshTitle(song, &repairs)
+			if err := s.dbManager.AddSong(song); err != nil {
+				log.Printf("Dropping song %s that couldn't be recorded: %v", song.ServiceId, err)
+				repairs.failed++
+				continue
+			}
+			repairs.recorded++
+		}
+
+		if song.Title == "" {
+			s.refreshTitle(song, &repairs)
+		}
+		taken[song.SongId] = true
+		kept = append(kept, song)
+	}
+
+	return kept, repairs
+}
+
+/*
+ * Returns the song the database recorded under the song's id if it's the same
+ * song, so the song can keep its id. Returns nil for songs from another
+ * database and for ids already taken by another queued song, since songs
+ * sharing an id would be removed, skipped and evicted together.
+ */
+func recordedSong(dbManager db.DbManager, song *cmpb.Song, taken map[uint32]bool) (*cmpb.Song, error) {
+	if song.SongId == 0 || taken[song.SongId] {
+		return nil, nil
+	}
+
+	recorded, err := dbManager.GetSongById(song.SongId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if recorded.UserId != song.UserId || recorded.Service != song.Service || recorded.ServiceId != song.ServiceId {
+		return nil, nil
+	}
+
+	return recorded, nil
+}
+
+/*
+ * Fill in a song's missing title from its service, falling back to its
+ * service id
+ */
+func (s *BackendServer) refreshTitle(song *cmpb.Song, repairs *queueRepairs) {
+	if song.Title != "" {
+		return
+	}
+
+	fetched := new(cmpb.Song)
+	if err := s.metadata.FetchSongData(serviceLink(song.Service, song.ServiceId), fetched); err != nil ||
+		fetched.Title == "" {
+		log.Printf("Failed to refresh the title of %s: %v", song.ServiceId, err)
+		song.Title = song.ServiceId
+		repairs.untitled++
+		return
+	}
+
+	song.Title = fetched.Title
+	song.RomanizedTitle = fetched.RomanizedTitle
+	song.Language = fetched.Language
+	applyTitle(song, s.rawTitles)
+	if song.Metadata == nil {
+		song.Metadata = fetched.Metadata
+	}
+	repairs.retitled++
+}
+
+/*
+ * Returns true if the id is well formed for the service: a YouTube video id,
+ * an absolute path for local files or an http link for web pages
+ */
+func validServiceId(service cmpb.ServiceType, serviceId string) bool {
+	switch service {
+	case cmpb.ServiceType_Youtube:
+		return links.ValidVideoId(serviceId)
+	case cmpb.ServiceType_Local:
+		return filepath.IsAbs(serviceId)
+	case cmpb.ServiceType_Web:
+		link, err := url.Parse(serviceId)
+		return err == nil && (link.Scheme == "http" || link.Scheme == "https") && link.Host != ""
+	default:
+		return false
+	}
+}
+
+/*
+ * Returns the link a song with the service id would have been submitted as
+ */
+func serviceLink(service cmpb.ServiceType, serviceId string) string {
+	if service == cmpb.ServiceType_Youtube {
+		return "https://www.youtube.com/watch?v=" + serviceId
+	}
+
+	return serviceId
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestValidServiceId(t *testing.T) {
+	tests := []struct {
+		service   cmpb.ServiceType
+		serviceId string
+		valid     bool
+	}{
+		{cmpb.ServiceType_Youtube, "dQw4w9WgXcQ", true},
+		{cmpb.ServiceType_Youtube, "dQw4w9WgXc", false},
+		{cmpb.ServiceType_Youtube, "dQw4w9WgX!Q", false},
+		{cmpb.ServiceType_Local, "/music/song.flac", true},
+		{cmpb.ServiceType_Local, "music/song.flac", false},
+		{cmpb.ServiceType_Web, "https://soundcloud.com/artist/track", true},
+		{cmpb.ServiceType_Web, "ftp://example.com/song.mp3", false},
+		{cmpb.ServiceType_None, "dQw4w9WgXcQ", false},
+	}
+
+	for _, test := range tests {
+		if valid := validServiceId(test.service, test.serviceId); valid != test.valid {
+			t.Errorf("validServiceId(%v, %q) = %t, expected %t", test.service, test.serviceId, valid, test.valid)
+		}
+	}
+}
+
+func TestRepairQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_queue_repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	fetcher := new(recordingFetcher)
+	server.metadata = fetcher
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	recorded := &cmpb.Song{Title: "Recorded", Service: cmpb.ServiceType_Youtube, ServiceId: "SilKjJ0S904",
+		UserId: bob.User.UserId, RoomId: room.Room.Id}
+	server.dbManager.AddSong(recorded)
+
+	song := func(songId uint32, serviceId string, userId uint32) *cmpb.Song {
+		return &cmpb.Song{SongId: songId, Service: cmpb.ServiceType_Youtube, ServiceId: serviceId,
+			UserId: userId, RoomId: room.Room.Id}
+	}
+
+	songs, repairs := server.repairQueue([]*cmpb.Song{
+		song(recorded.SongId, "SilKjJ0S904", bob.User.UserId),
+		song(0, "not a video", bob.User.UserId),
+		song(0, "cHkDZ1ekB9U", 999),
+		song(500, "dQw4w9WgXcQ", bob.User.UserId),
+	})
+
+	expected := queueRepairs{checked: 4, malformed: 1, unknown: 1, recorded: 1, retitled: 2}
+	if repairs != expected {
+		t.Errorf("Expected the repairs %v, got %v", expected, repairs)
+	}
+
+	if len(songs) != 2 {
+		t.Fatalf("Expected 2 songs kept, got %v", songs)
+	}
+
+	// the title comes from the database when the song is recorded there
+	if songs[0].SongId != recorded.SongId || songs[0].Title != "Recorded" {
+		t.Errorf("Expected the recorded song with its title back, got %v", songs[0])
+	}
+
+	// otherwise it's fetched and the song is recorded under a new id
+	if len(fetcher.links) != 1 || songs[1].Title != fetcher.links[0] || songs[1].SongId == 500 {
+		t.Errorf("Expected the song retitled from its service and recorded, got %v", songs[1])
+	}
+}
+
+func TestRepairQueue_whenIdTakenTwice_recordsCopyUnderNewId(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_queue_repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.metadata = new(recordingFetcher)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	recorded := &cmpb.Song{Title: "Recorded", Service: cmpb.ServiceType_Youtube, ServiceId: "SilKjJ0S904",
+		UserId: bob.User.UserId, RoomId: room.Room.Id}
+	server.dbManager.AddSong(recorded)
+
+	songs, repairs := server.repairQueue([]*cmpb.Song{proto.Clone(recorded).(*cmpb.Song),
+		proto.Clone(recorded).(*cmpb.Song)})
+	if len(songs) != 2 || repairs.recorded != 1 {
+		t.Fatalf("Expected both songs kept and one recorded again, got %v with %v", songs, repairs)
+	}
+
+	if songs[0].SongId != recorded.SongId || songs[1].SongId == recorded.SongId {
+		t.Errorf("Expected the copy to get an id of its own, got %d and %d", songs[0].SongId, songs[1].SongId)
+	}
+}
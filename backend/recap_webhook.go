@@ -0,0 +1,46 @@
+//go:build !nowebhook
+
+/*
+ * Posts party recaps to a webhook, such as one of a Discord channel. Build
+ * with the nowebhook tag to leave webhooks out of the binary.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	webhooksBuilt = true // recaps can be posted to a webhook
+)
+
+/*
+ * Post a recap to the webhook. The message has the summary as its content,
+ * which chat services like Discord show, along with the recap itself.
+ */
+func (r *partyRecapper) post(recap *bepb.PartyRecap) error {
+	body, err := json.Marshal(struct {
+		Content string           `json:"content"`
+		Recap   *bepb.PartyRecap `json:"recap"`
+	}{recapSummary(recap), recap})
+	if err != nil {
+		return err
+	}
+
+	response, err := r.client.Post(r.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build nowebhook
+
+/*
+ * Stands in for posting party recaps in binaries built with the nowebhook
+ * tag. Recaps are still saved and can be read with GetPartyRecap.
+ */
+
+package backend
+
+import (
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	webhooksBuilt = false // recaps can be posted to a webhook
+)
+
+/*
+ * Refuse to post the recap
+ */
+func (r *partyRecapper) post(recap *bepb.PartyRecap) error {
+	return ErrWebhooksNotBuilt
+}
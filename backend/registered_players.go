@@ -0,0 +1,198 @@
+/*
+ * Registered players have a name, like "living room Pi", and a token they
+ * connect with, so the backend can tell its players apart. A registered
+ * player can be put in a zone and told which audio output device to use when
+ * it connects. Any player may connect until one is registered, after which
+ * only registered players can.
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const playerTokenBytes = 24 // random bytes in the token of a registered player
+
+var (
+	ErrUnregisteredPlayer     = errors.New("The player isn't registered.")
+	ErrRegisteredPlayerAbsent = errors.New("No player is registered by that name.")
+)
+
+/*
+ * Registers players and identifies them when they connect
+ */
+type playerRegistry struct {
+	dbManager db.DbManager // database the registered players are saved in
+}
+
+/*
+ * Initialize the registry with the database the players are saved in
+ */
+func (r *playerRegistry) init(dbManager db.DbManager) {
+	r.dbManager = dbManager
+}
+
+/*
+ * Register a player and give it a new token. Registering a name again
+ * replaces its settings and its token.
+ */
+func (r *playerRegistry) register(player *bepb.RegisteredPlayer) (*bepb.RegisteredPlayer, error) {
+	token, err := generatePlayerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	registered := &bepb.RegisteredPlayer{
+		Name:         player.GetName(),
+		Token:        token,
+		Zone:         player.GetZone(),
+		OutputDevice: player.GetOutputDevice(),
+	}
+
+	if err = r.dbManager.SaveRegisteredPlayer(registered); err != nil {
+		return nil, err
+	}
+
+	return registered, nil
+}
+
+/*
+ * Remove the player registered by the name
+ */
+func (r *playerRegistry) unregister(name string) error {
+	removed, err := r.dbManager.RemoveRegisteredPlayer(name)
+	if err != nil {
+		return err
+	} else if !removed {
+		return ErrRegisteredPlayerAbsent
+	}
+
+	return nil
+}
+
+/*
+ * Returns the registered players ordered by name
+ */
+func (r *playerRegistry) list() ([]*bepb.RegisteredPlayer, error) {
+	return r.dbManager.GetRegisteredPlayers()
+}
+
+/*
+ * Returns the registered player connecting with the token in the request
+ * metadata and records that it was seen. Returns nil if no players are
+ * registered, since any player may connect then, and ErrUnregisteredPlayer
+ * if the token doesn't belong to a registered player.
+ */
+func (r *playerRegistry) identify(ctx context.Context) (*bepb.RegisteredPlayer, error) {
+	players, err := r.dbManager.GetRegisteredPlayers()
+	if err != nil {
+		return nil, err
+	} else if len(players) == 0 {
+		return nil, nil
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(common.PlayerMetadataKey); len(values) > 0 {
+			token = values[0]
+		}
+	}
+
+	if token == "" {
+		return nil, ErrUnregisteredPlayer
+	}
+
+	player, err := r.dbManager.GetRegisteredPlayerByToken(token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnregisteredPlayer
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = r.dbManager.TouchRegisteredPlayer(player.Name); err != nil {
+		log.Printf("Failed to record that player %s connected: %v", player.Name, err)
+	}
+
+	return player, nil
+}
+
+/*
+ * Returns a random token for a registered player
+ */
+func generatePlayerToken() (string, error) {
+	token := make([]byte, playerTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+/*
+ * Record the name of the registered player connected on a stream
+ */
+func (mgr *playerManager) setRegisteredName(id int, name string) {
+	mgr.playerLock.Lock()
+	defer mgr.playerLock.Unlock()
+
+	if state, exists := mgr.streams[id]; exists {
+		state.registeredName = name
+	}
+}
+
+/*
+ * Returns the ids of the connected registered players by their names
+ */
+func (mgr *playerManager) registeredIds() map[string]int {
+	mgr.playerLock.RLock()
+	defer mgr.playerLock.RUnlock()
+
+	ids := make(map[string]int)
+	for id, state := range mgr.streams {
+		if state.registeredName != "" {
+			ids[state.registeredName] = id
+		}
+	}
+
+	return ids
+}
+
+/*
+ * Returns the registered players with whether they're connected in any zone.
+ * Tokens are left out.
+ */
+func (s *BackendServer) connectedRegisteredPlayers(players []*bepb.RegisteredPlayer) []*bepb.RegisteredPlayer {
+	connected := make(map[string]int)
+	for _, zone := range s.zones.list() {
+		for name, id := range zone.playerMgr.registeredIds() {
+			connected[name] = id
+		}
+	}
+
+	listed := make([]*bepb.RegisteredPlayer, 0, len(players))
+	for _, player := range players {
+		id, isConnected := connected[player.Name]
+		listed = append(listed, &bepb.RegisteredPlayer{
+			Name:         player.Name,
+			Zone:         player.Zone,
+			OutputDevice: player.OutputDevice,
+			Connected:    isConnected,
+			PlayerId:     uint32(id),
+			LastSeen:     player.LastSeen,
+		})
+	}
+
+	return listed
+}
@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func playerContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(common.PlayerMetadataKey, token))
+}
+
+func TestPlayerRegistry_identify_onceRegistered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_players")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	registry := new(playerRegistry)
+	registry.init(server.dbManager)
+
+	// any player may connect until one is registered
+	if player, err := registry.identify(context.Background()); player != nil || err != nil {
+		t.Fatalf("Expected anyone to connect, got %v and %v", player, err)
+	}
+
+	registered, err := registry.register(&bepb.RegisteredPlayer{Name: "living room Pi", Zone: "living room"})
+	if err != nil || registered.Token == "" {
+		t.Fatalf("Expected a token, got %v and %v", registered, err)
+	}
+
+	player, err := registry.identify(playerContext(registered.Token))
+	if err != nil || player.GetName() != "living room Pi" || player.GetZone() != "living room" {
+		t.Fatalf("Expected the living room Pi, got %v and %v", player, err)
+	}
+
+	if _, err = registry.identify(playerContext("guessed")); err != ErrUnregisteredPlayer {
+		t.Errorf("Expected an unknown token to be turned away, got %v", err)
+	}
+
+	if _, err = registry.identify(context.Background()); err != ErrUnregisteredPlayer {
+		t.Errorf("Expected a missing token to be turned away, got %v", err)
+	}
+
+	players, err := registry.list()
+	if err != nil || len(players) != 1 || players[0].LastSeen == 0 {
+		t.Errorf("Expected the player to have been seen, got %v and %v", players, err)
+	}
+
+	// registering again gives the player a new token
+	again, _ := registry.register(&bepb.RegisteredPlayer{Name: "living room Pi"})
+	if _, err = registry.identify(playerContext(registered.Token)); err != ErrUnregisteredPlayer {
+		t.Errorf("Expected the old token to stop working, got %v", err)
+	}
+
+	if err = registry.unregister(again.Name); err != nil {
+		t.Errorf("Expected the player to be unregistered, got %v", err)
+	}
+
+	if err = registry.unregister(again.Name); err != ErrRegisteredPlayerAbsent {
+		t.Errorf("Expected nothing to unregister, got %v", err)
+	}
+}
+
+func TestListRegisteredPlayers_leavesOutTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_players")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "ytbox.db")
+	defer server.dbManager.Close()
+
+	response, _ := server.RegisterPlayer(context.Background(), &bepb.RegisteredPlayer{Name: "test laptop", Zone: "attic"})
+	if response.Err.Success {
+		t.Fatalf("Expected an unknown zone to be refused")
+	}
+
+	response, _ = server.RegisterPlayer(context.Background(), &bepb.RegisteredPlayer{Name: "test laptop"})
+	if !response.Err.Success || response.Token == "" {
+		t.Fatalf("Expected the player to be registered, got %v", response)
+	}
+
+	list, _ := server.ListRegisteredPlayers(context.Background(), &cmpb.Empty{})
+	if !list.Err.Success || len(list.Players) != 1 {
+		t.Fatalf("Expected one registered player, got %v", list)
+	}
+
+	if player := list.Players[0]; player.Token != "" || player.Connected {
+		t.Errorf("Expected a disconnected player without its token, got %v", player)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Detects YouTube videos that the players won't be able to play, either
+ * because they're age restricted or because they're blocked in the server's
+ * region. Catching them when they're submitted beats having the player fail
+ * on them in the middle of the party.
+ */
+
+package backend
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+const ageRestrictedRating = "ytAgeRestricted" // YouTube's rating for age restricted videos
+
+var (
+	ErrAgeRestricted = errors.New("This video is age restricted and may not play.")
+	ErrRegionBlocked = errors.New("This video is blocked in this region and may not play.")
+)
+
+/*
+ * Returns the restriction that would keep a video from playing in the region,
+ * or nil if it can play. Region restrictions are only checked if the region
+ * is known.
+ */
+func checkRestrictions(details *youtube.VideoContentDetails, region string) error {
+	if details == nil {
+		return nil
+	}
+
+	if details.ContentRating != nil && details.ContentRating.YtRating == ageRestrictedRating {
+		return ErrAgeRestricted
+	}
+
+	restriction := details.RegionRestriction
+	if restriction == nil || region == "" {
+		return nil
+	}
+
+	if len(restriction.Allowed) > 0 && !containsRegion(restriction.Allowed, region) {
+		return ErrRegionBlocked
+	}
+
+	if containsRegion(restriction.Blocked, region) {
+		return ErrRegionBlocked
+	}
+
+	return nil
+}
+
+/*
+ * Returns true if the error is a restriction on where or to whom a video can
+ * play
+ */
+func isRestricted(err error) bool {
+	return errors.Is(err, ErrAgeRestricted) || errors.Is(err, ErrRegionBlocked)
+}
+
+func containsRegion(regions []string, region string) bool {
+	for _, r := range regions {
+		if strings.EqualFold(r, region) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"testing"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+func TestCheckRestrictions_whenUnrestricted_returnsNil(t *testing.T) {
+	details := &youtube.VideoContentDetails{Duration: "PT3M"}
+	if err := checkRestrictions(details, "US"); err != nil {
+		t.Errorf("Expected no restriction, got %v", err)
+	}
+}
+
+func TestCheckRestrictions_whenAgeRestricted_returnsErr(t *testing.T) {
+	details := &youtube.VideoContentDetails{
+		ContentRating: &youtube.ContentRating{YtRating: ageRestrictedRating},
+	}
+	if err := checkRestrictions(details, ""); err != ErrAgeRestricted {
+		t.Errorf("Expected %v, got %v", ErrAgeRestricted, err)
+	}
+}
+
+func TestCheckRestrictions_whenBlockedInRegion_returnsErr(t *testing.T) {
+	details := &youtube.VideoContentDetails{
+		RegionRestriction: &youtube.VideoContentDetailsRegionRestriction{Blocked: []string{"DE", "US"}},
+	}
+	if err := checkRestrictions(details, "us"); err != ErrRegionBlocked {
+		t.Errorf("Expected %v, got %v", ErrRegionBlocked, err)
+	}
+	if err := checkRestrictions(details, "CA"); err != nil {
+		t.Errorf("Expected no restriction in CA, got %v", err)
+	}
+}
+
+func TestCheckRestrictions_whenNotInAllowed_returnsErr(t *testing.T) {
+	details := &youtube.VideoContentDetails{
+		RegionRestriction: &youtube.VideoContentDetailsRegionRestriction{Allowed: []string{"JP"}},
+	}
+	if err := checkRestrictions(details, "US"); err != ErrRegionBlocked {
+		t.Errorf("Expected %v, got %v", ErrRegionBlocked, err)
+	}
+	if err := checkRestrictions(details, "JP"); err != nil {
+		t.Errorf("Expected no restriction in JP, got %v", err)
+	}
+}
+
+func TestCheckRestrictions_whenRegionUnknown_skipsRegionCheck(t *testing.T) {
+	details := &youtube.VideoContentDetails{
+		RegionRestriction: &youtube.VideoContentDetailsRegionRestriction{Allowed: []string{"JP"}},
+	}
+	if err := checkRestrictions(details, ""); err != nil {
+		t.Errorf("Expected no restriction, got %v", err)
+	}
+}
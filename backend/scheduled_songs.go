@@ -0,0 +1,355 @@
+/*
+ * Admins can pin a song to play at a set time, like the countdown song at
+ * midnight. The song is looked up when it's scheduled, so a slow lookup can't
+ * make it late, and goes to the front of the zone's queue once the time comes,
+ * whatever else is queued. It plays as soon as the song playing at the time
+ * ends, or right away if it was scheduled to interrupt. Scheduled songs are
+ * saved, so they survive a restart. Songs whose time passed while the server
+ * was down play once it's back if they're only a little late and are dropped
+ * otherwise. Timers wait at most a minute before checking the wall clock
+ * again, so songs still play on time after the clock is set or the host
+ * wakes from sleep.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	scheduleGrace   = 15 * time.Minute // how late a song missed while the server was down may still play
+	scheduleRecheck = time.Minute      // longest a timer waits before checking the wall clock again
+)
+
+var (
+	ErrScheduledNotFound = errors.New("No song is scheduled with that id.")
+	ErrScheduleInPast    = errors.New("That time has already passed.")
+)
+
+/*
+ * A scheduled song and the timer playing it
+ */
+type pinnedSong struct {
+	scheduled *bepb.ScheduledSong // the song and when it plays
+	timer     *time.Timer         // plays the song once its time comes
+}
+
+/*
+ * Keeps track of the scheduled songs and plays each once its time comes
+ */
+type songScheduler struct {
+	dbManager db.DbManager                        // database the scheduled songs are saved in
+	pinned    map[uint32]*pinnedSong              // scheduled song id -> the song
+	play      func(scheduled *bepb.ScheduledSong) // called once a song's time comes
+	now       func() time.Time                    // returns the wall clock time songs are due by
+	recheck   time.Duration                       // longest a timer waits before checking the time again
+	stopped   bool                                // true once the timers are stopped
+	lock      sync.Mutex                          // lock on the scheduled songs
+}
+
+/*
+ * Initialize the scheduler with the songs saved in the database and the
+ * function that plays them
+ */
+func (p *songScheduler) init(dbManager db.DbManager, play func(scheduled *bepb.ScheduledSong), now time.Time) {
+	p.dbManager = dbManager
+	p.pinned = make(map[uint32]*pinnedSong)
+	p.play = play
+	p.now = time.Now
+	p.recheck = scheduleRecheck
+
+	saved, err := dbManager.GetScheduledSongs()
+	if err != nil {
+		log.Printf("Failed to load scheduled songs: %v", err)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, scheduled := range saved {
+		if now.Sub(time.Unix(scheduled.PlayAt, 0)) > scheduleGrace {
+			log.Printf("Dropping song %d scheduled for %s, which passed while the server was down",
+				scheduled.Id, time.Unix(scheduled.PlayAt, 0).Format(time.RFC1123))
+			dbManager.RemoveScheduledSong(scheduled.Id)
+			continue
+		}
+
+		p.arm(scheduled, now)
+	}
+}
+
+/*
+ * Save a song to play at its time and set its id
+ */
+func (p *songScheduler) add(scheduled *bepb.ScheduledSong, now time.Time) error {
+	if !time.Unix(scheduled.PlayAt, 0).After(now) {
+		return ErrScheduleInPast
+	}
+
+	if err := p.dbManager.SaveScheduledSong(scheduled); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.arm(scheduled, now)
+	log.Printf("Scheduled %s to play in zone %d at %s", scheduled.Song.GetServiceId(), scheduled.ZoneId,
+		time.Unix(scheduled.PlayAt, 0).Format(time.RFC1123))
+	return nil
+}
+
+/*
+ * Start the timer playing a song at its time. Songs whose time passed play
+ * right away. Assumes the caller holds the lock.
+ */
+func (p *songScheduler) arm(scheduled *bepb.ScheduledSong, now time.Time) {
+	pin := &pinnedSong{scheduled: scheduled}
+	p.wait(pin, now)
+	p.pinned[scheduled.Id] = pin
+}
+
+/*
+ * Set the song's timer to go off at its time, or sooner to check the time
+ * again. Timers run on the monotonic clock, which doesn't follow the wall
+ * clock being set and may stop while the host sleeps. Assumes the caller
+ * holds the lock.
+ */
+func (p *songScheduler) wait(pin *pinnedSong, now time.Time) {
+	delay := time.Unix(pin.scheduled.PlayAt, 0).Sub(now)
+	if delay > p.recheck {
+		delay = p.recheck
+	}
+	pin.timer = time.AfterFunc(delay, func() { p.due(pin) })
+}
+
+/*
+ * Play a song if its time came by the wall clock, or wait some more
+ */
+func (p *songScheduler) due(pin *pinnedSong) {
+	p.lock.Lock()
+	if p.stopped || p.pinned[pin.scheduled.Id] != pin {
+		p.lock.Unlock()
+		return
+	}
+
+	if now := p.now(); now.Before(time.Unix(pin.scheduled.PlayAt, 0)) {
+		p.wait(pin, now)
+		p.lock.Unlock()
+		return
+	}
+	p.lock.Unlock()
+
+	p.fire(pin)
+}
+
+/*
+ * Play a song whose time came, unless it was cancelled
+ */
+func (p *songScheduler) fire(pin *pinnedSong) {
+	p.lock.Lock()
+	if p.pinned[pin.scheduled.Id] != pin {
+		p.lock.Unlock()
+		return
+	}
+	delete(p.pinned, pin.scheduled.Id)
+	p.lock.Unlock()
+
+	if _, err := p.dbManager.RemoveScheduledSong(pin.scheduled.Id); err != nil {
+		log.Printf("Failed to remove scheduled song %d: %v", pin.scheduled.Id, err)
+	}
+
+	p.play(pin.scheduled)
+}
+
+/*
+ * Cancel a scheduled song before its time comes
+ */
+func (p *songScheduler) cancel(scheduledId uint32) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pin, exists := p.pinned[scheduledId]
+	if !exists {
+		return ErrScheduledNotFound
+	}
+
+	if _, err := p.dbManager.RemoveScheduledSong(scheduledId); err != nil {
+		return err
+	}
+
+	pin.timer.Stop()
+	delete(p.pinned, scheduledId)
+	return nil
+}
+
+/*
+ * Returns the scheduled songs, soonest first
+ */
+func (p *songScheduler) list() []*bepb.ScheduledSong {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	songs := make([]*bepb.ScheduledSong, 0, len(p.pinned))
+	for _, pin := range p.pinned {
+		songs = append(songs, pin.scheduled)
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		if songs[i].PlayAt != songs[j].PlayAt {
+			return songs[i].PlayAt < songs[j].PlayAt
+		}
+		return songs[i].Id < songs[j].Id
+	})
+
+	return songs
+}
+
+/*
+ * Stop the timers playing the scheduled songs, such as when the server
+ * stops. The songs stay saved for the next start.
+ */
+func (p *songScheduler) stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stopped = true
+	for _, pin := range p.pinned {
+		pin.timer.Stop()
+	}
+}
+
+/*
+ * Schedules a song to play in a zone at a set time
+ */
+func (s *BackendServer) ScheduleSong(con context.Context, req *bepb.ScheduledSong) (*bepb.ScheduledSong, error) {
+	response := &bepb.ScheduledSong{Err: &bepb.Error{Success: false}}
+
+	if username, _ := s.getUserFromId(req.GetUserId()); username == "" {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
+
+	if _, exists := s.zones.get(req.GetZoneId()); !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	if !time.Unix(req.GetPlayAt(), 0).After(time.Now()) {
+		response.Err.Message = ErrScheduleInPast.Error()
+		return response, nil
+	}
+
+	song := new(cmpb.Song)
+	var err error
+	if links.IsSearchQuery(req.GetLink()) {
+		err = s.resolveSearchQuery(req.GetLink(), song)
+	} else {
+		err = s.metadata.FetchSongData(req.GetLink(), song)
+	}
+
+	if errors.Is(err, ErrServiceUnavailable) {
+		response.Err.Message = serviceDownMessage
+		return response, nil
+	} else if err != nil {
+		response.Err.Message = fmt.Sprintf("Failed to look up the song: %v", err)
+		return response, nil
+	} else if blankTitle(song) {
+		response.Err.Message = ErrBlankTitle.Error()
+		return response, nil
+	}
+	applyChapter(song, 0)
+	applyTitle(song, s.rawTitles)
+
+	scheduled := &bepb.ScheduledSong{
+		Link:      req.GetLink(),
+		UserId:    req.GetUserId(),
+		ZoneId:    req.GetZoneId(),
+		PlayAt:    req.GetPlayAt(),
+		Interrupt: req.GetInterrupt(),
+		Song:      song,
+	}
+
+	if err = s.scheduler.add(scheduled, time.Now()); err != nil {
+		response.Err.Message = "Failed to schedule the song."
+		if errors.Is(err, ErrScheduleInPast) {
+			response.Err.Message = err.Error()
+		}
+		return response, nil
+	}
+
+	response = proto.Clone(scheduled).(*bepb.ScheduledSong)
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
+
+/*
+ * Cancels a song scheduled to play at a set time
+ */
+func (s *BackendServer) CancelScheduled(con context.Context, req *bepb.ScheduledSong) (*bepb.Error, error) {
+	if err := s.scheduler.cancel(req.GetId()); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
+	log.Printf("Cancelled scheduled song %d", req.GetId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the songs scheduled to play at set times, soonest first
+ */
+func (s *BackendServer) ListScheduled(con context.Context, empty *cmpb.Empty) (*bepb.ScheduledSongList, error) {
+	return &bepb.ScheduledSongList{Songs: s.scheduler.list(), Err: &bepb.Error{Success: true, Message: "Success"}}, nil
+}
+
+/*
+ * Put a scheduled song at the front of its zone's queue, cutting off the song
+ * playing if it was scheduled to interrupt
+ */
+func (s *BackendServer) playScheduled(scheduled *bepb.ScheduledSong) {
+	zone, exists := s.zones.get(scheduled.ZoneId)
+	if !exists {
+		log.Printf("Dropping scheduled song %d for zone %d, which was removed", scheduled.Id, scheduled.ZoneId)
+		return
+	}
+
+	song := proto.Clone(scheduled.Song).(*cmpb.Song)
+	song.UserId = scheduled.UserId
+	song.Source = cmpb.SubmissionSource_Scheduled
+	song.Username, song.RoomId = s.getUserFromId(song.UserId)
+	if song.Username == "" {
+		log.Printf("Dropping scheduled song %d credited to user %d, who no longer exists", scheduled.Id,
+			scheduled.UserId)
+		return
+	}
+
+	if err := s.queueSong(zone, song); err != nil {
+		log.Printf("Failed to queue scheduled song %d: %v", scheduled.Id, err)
+		return
+	}
+
+	if err := zone.queueMgr.PromoteSong(song.SongId); err != nil {
+		log.Printf("Failed to move scheduled song %d to the front of the queue: %v", scheduled.Id, err)
+	}
+
+	// players waiting on an empty queue pick the song up on their own
+	log.Printf("Playing scheduled song %s in zone %d", song.ServiceId, zone.id)
+	if scheduled.Interrupt && zone.queueMgr.NowPlaying() != nil && !zone.playerMgr.playersReady() {
+		s.skipNowPlaying(zone, &bepb.Event{Outcome: bepb.SongOutcome_Interrupted})
+	}
+}
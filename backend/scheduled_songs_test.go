@@ -0,0 +1,138 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestScheduledSong_jumpsTheQueueAtItsTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_scheduled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	defer server.scheduler.stop()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	for _, serviceId := range []string{"first", "second"} {
+		song := &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube, ServiceId: serviceId,
+			UserId: bob.User.UserId, RoomId: room.Room.Id}
+		if err := server.queueSong(server.zones.defaultZone, song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	countdown := &bepb.ScheduledSong{UserId: bob.User.UserId, PlayAt: now.Add(time.Hour).Unix(),
+		Song: &cmpb.Song{Title: "countdown", Service: cmpb.ServiceType_Youtube, ServiceId: "countdown"}}
+	later := &bepb.ScheduledSong{UserId: bob.User.UserId, PlayAt: now.Add(2 * time.Hour).Unix(),
+		Song: &cmpb.Song{Title: "later", Service: cmpb.ServiceType_Youtube, ServiceId: "later"}}
+	for _, scheduled := range []*bepb.ScheduledSong{later, countdown} {
+		if err := server.scheduler.add(scheduled, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	past := &bepb.ScheduledSong{UserId: bob.User.UserId, PlayAt: now.Add(-time.Minute).Unix()}
+	if err := server.scheduler.add(past, now); err != ErrScheduleInPast {
+		t.Errorf("Expected a time that passed to be refused, got %v", err)
+	}
+
+	listed, _ := server.ListScheduled(context.Background(), &cmpb.Empty{})
+	if len(listed.Songs) != 2 || listed.Songs[0].Id != countdown.Id || listed.Songs[1].Id != later.Id {
+		t.Fatalf("Expected the scheduled songs soonest first, got %v", listed.Songs)
+	}
+
+	if response, _ := server.CancelScheduled(context.Background(), later); !response.Success {
+		t.Errorf("Expected the later song to be cancelled, got %v", response)
+	}
+
+	if response, _ := server.CancelScheduled(context.Background(), later); response.Success {
+		t.Error("Expected cancelling the song again to fail")
+	}
+
+	saved, _ := server.dbManager.GetScheduledSongs()
+	if len(saved) != 1 || saved[0].Id != countdown.Id {
+		t.Fatalf("Expected only the countdown song to stay saved, got %v", saved)
+	}
+
+	// the countdown's time comes
+	server.scheduler.fire(server.scheduler.pinned[countdown.Id])
+
+	playlist := server.queueMgr.GetPlaylist().Songs
+	if len(playlist) != 3 || playlist[0].ServiceId != "countdown" {
+		t.Fatalf("Expected the countdown song at the front of the queue, got %v", playlist)
+	}
+
+	if playlist[0].Source != cmpb.SubmissionSource_Scheduled || playlist[0].Username != "Bob" {
+		t.Errorf("Expected the song to be queued as scheduled for Bob, got %v", playlist[0])
+	}
+
+	if listed, _ := server.ListScheduled(context.Background(), &cmpb.Empty{}); len(listed.Songs) != 0 {
+		t.Errorf("Expected nothing left scheduled, got %v", listed.Songs)
+	}
+}
+
+func TestScheduledSong_playsByTheWallClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_scheduled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	defer server.scheduler.stop()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	var lock sync.Mutex
+	wall := time.Now()
+	server.scheduler.now = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return wall
+	}
+	server.scheduler.recheck = 5 * time.Millisecond
+
+	countdown := &bepb.ScheduledSong{UserId: bob.User.UserId, PlayAt: wall.Add(time.Hour).Unix(),
+		Song: &cmpb.Song{Title: "countdown", Service: cmpb.ServiceType_Youtube, ServiceId: "countdown"}}
+	if err := server.scheduler.add(countdown, wall); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if songs := server.queueMgr.GetPlaylist().Songs; len(songs) != 0 {
+		t.Fatalf("Expected the song to wait for its time, got %v", songs)
+	}
+
+	// the clock is set forward past the song's time, like after the host
+	// wakes from sleep
+	lock.Lock()
+	wall = wall.Add(time.Hour + time.Second)
+	lock.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for len(server.queueMgr.GetPlaylist().Songs) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the song to play once the wall clock reached its time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if songs := server.queueMgr.GetPlaylist().Songs; songs[0].ServiceId != "countdown" {
+		t.Errorf("Expected the countdown song queued, got %v", songs)
+	}
+}
@@ -10,439 +10,3350 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/rickb777/date/period"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
 	db "github.com/nguyenmq/ytbox-go/database"
+	"github.com/nguyenmq/ytbox-go/links"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
 const (
-	LogPrefix      string = "ytb-be" // logging prefix name
-	allowedMinutes        = 10
+	LogPrefix            string = "ytb-be" // logging prefix name
+	allowedMinutes              = 10
+	maxShareCodeAttempts        = 5 // attempts at generating an unused share code
+	defaultDrainTimeout         = 10 * time.Second
+	searchResolveCount          = 5               // results considered when resolving a query
+	defaultSearchResults        = 5               // candidates returned when the client doesn't ask
+	maxSearchResults            = 10              // most candidates a client can ask for
+	restartAfter                = 5 * time.Second // songs played longer than this restart instead of going back
+)
+
+var (
+	ErrUnknownRecipient  = errors.New("Nobody by that name is in your room.")
+	ErrAnonymousDisabled = errors.New("Anonymous submissions aren't allowed.")
+	ErrSongNotRecorded   = errors.New("Failed to save the song. Please try again.")
+	ErrSongBlocked       = errors.New("The host blocked that song. Please pick another one.")
+	ErrUnknownSubmitter  = errors.New("The song's submitter doesn't exist.")
 )
 
 /*
  * Implements the backend rpc server interface
  */
 type BackendServer struct {
-	listener  net.Listener             // network listener
-	beServer  *grpc.Server             // backend RPC server
-	queueMgr  *queuer.SongQueueManager // playlist queue
-	dbManager db.DbManager             // database manager
-	userCache *UserCache               // user identity cache
-	playerMgr *playerManager           // player manager
-	streamWG  sync.WaitGroup           // wait group for streaming goroutines
-	fetcher   *SongFetcher             // Song metadata fetcher
+	listener     net.Listener             // network listener
+	beServer     *grpc.Server             // backend RPC server
+	queueMgr     *queuer.SongQueueManager // playlist queue
+	dbManager    db.DbManager             // database manager
+	userCache    *UserCache               // user identity cache
+	playerMgr    *playerManager           // player manager
+	streamWG     sync.WaitGroup           // wait group for streaming goroutines
+	fetcher      *SongFetcher             // Song metadata fetcher
+	metadata     MetadataFetcher          // fetches the metadata of submitted links by service
+	guard        *fetchGuard              // limits how long calls to outside services may hang
+	downloader   *songDownloader          // pre-fetches audio of upcoming songs
+	zones        *zoneManager             // player zones
+	voteWeights  queuer.HybridWeights     // how far votes move songs in hybrid queues
+	maintainer   *dbMaintainer            // prunes and compacts the database
+	writes       *writeQueue              // writes the song history in the background
+	achievements *achievementTracker      // awards achievements from the song history
+	lyrics       *lyricsFinder            // looks up the lyrics of songs
+	autoDj       *autoDj                  // picks songs from the history when the queue runs dry
+	activity     *activityTracker         // keeps track of which users are still around
+	skipVotes    *skipVoter               // counts votes to skip the songs playing in zones
+	playNext     *playNextVoter           // counts approvals of requests to play songs next
+	boarding     *boardingWindow          // limits everyone to one song early in the party
+	kiosks       *kioskLimiter            // limits the songs each kiosk queues an hour
+	dedup        *dedupWindow             // turns repeat submissions into votes
+	recapper     *partyRecapper           // recaps parties once they end
+	approvals    *approvalQueue           // long songs waiting for an admin to approve them
+	decks        *deckHandoff             // the dj who has the decks, if anyone
+	suggestions  *approvalQueue           // songs suggested to the dj while they have the decks
+	jingles      *jingleBox               // jingles played between songs
+	registry     *playerRegistry          // players registered by name
+	apiKeys      *apiKeyring              // api keys of bots and other clients
+	policy       *accessPolicy            // decides which callers may call each rpc
+	clients      *clientDirectory         // clients streaming from the backend
+	timeOuts     *timeOutTracker          // users timed out from submitting songs
+	scheduler    *songScheduler           // songs pinned to play at set times
+	loginCodes   *loginCodeTracker        // codes linking new devices to signed in users
+	plays        *playTracker             // how long each user's songs played
+	snapshots    SnapshotStore            // where playlists and snapshots are saved
+	crashPath    string                   // snapshot saved on signals and panics. Empty saves none
+
+	bus            *eventBus         // passes what the server does on to the parts acting on it
+	events         *eventBroadcaster // sends events to the clients streaming them
+	federation     *federationHub    // accepts links from backends that follow this one
+	federationLink *federationLink   // link to the backend this one follows. Nil if not following
+	metricsAddr    string            // address to serve metrics on. Empty doesn't serve them
+	metricsServer  *http.Server      // serves the metrics. Nil until they're served
+
+	limits         queueLimits  // limits on submissions, which presets can change
+	tiers          lengthTiers  // limits on submissions by song length
+	limitsLock     sync.RWMutex // lock on the limits
+	rawTitles      bool         // show and dedup songs by their raw titles instead of cleaned ones
+	flagRestricted bool         // queue restricted videos with a warning instead of rejecting them
+	allowAnonymous bool         // let users submit songs without being named
+	audioOnly      bool         // players only ever play audio
+	partyPassword  string       // password users must give to log in. Empty if there's none
+	joinUrl        string       // address of the web frontend guests join at. Empty if it wasn't given
+
+	serving       bool               // true while new player streams are admitted
+	stopped       bool               // true once Stop was called
+	stateLock     sync.Mutex         // lock on the serving state and stream admission
+	shutdown      context.Context    // cancelled when the server starts shutting down
+	cancelStreams context.CancelFunc // cancels the shutdown context
+	drainTimeout  time.Duration      // how long Stop waits for connections to drain
+	started       time.Time          // when the server was created
+}
+
+/*
+ * Settings used to create a new backend server
+ */
+type ServerConfig struct {
+	Addr      string // address and port to listen on
+	LoadFile  string // serialized playlist to load on start up
+	DbPath    string // path to the database
+	YtApiKey  string // YouTube data api key
+	CacheDir  string // directory to pre-fetch audio into. Empty disables caching
+	CacheSize int64  // maximum size of the audio cache in bytes
+
+	Retention           time.Duration // how long to keep history. Zero keeps it forever
+	MaintenanceInterval time.Duration // time between database maintenance runs
+	ReadReplica         string        // read-only database for statistics and history. Empty reads from DbPath
+	DrainTimeout        time.Duration // how long Stop waits for connections to close
+
+	AutoDj                 bool          // play songs from the history when the queue runs dry
+	AutoDjAvoidRecent      time.Duration // songs played within this long ago aren't picked
+	AutoDjAllowSameChannel bool          // allow back to back picks from the same channel
+
+	SubmissionWindow time.Duration // reject songs that wouldn't start within this long. Zero disables
+	ArtistGap        time.Duration // shortest time between songs by the same artist. Zero disables
+	DedupWindow      time.Duration // songs submitted this recently count a repeat as a vote. Zero disables
+	BoardingWindow   time.Duration // users may queue one song each for this long after a party starts
+	KioskSongs       uint32        // songs each kiosk may queue an hour. Zero doesn't limit them
+	RawTitles        bool          // show and dedup songs by their titles as uploaded instead of cleaned up
+	Lyrics           string        // provider to fetch lyrics from. Empty turns lyrics off
+	Region           string        // ISO 3166 code of the players' region. Empty skips region checks
+	FlagRestricted   bool          // queue age restricted and region blocked videos with a warning
+	AllowAnonymous   bool          // let users submit songs shown as "Anonymous"
+	PartyPassword    string        // password users must give to log in. Empty lets anyone log in
+	JoinUrl          string        // address of the web frontend guests join at, for QR codes. Empty takes the caller's
+	Demo             bool          // fill the database with sample users, history and a queue
+	ClearDemo        bool          // remove the sample data Demo added on start up
+	InactiveAfter    time.Duration // users who haven't done anything for this long are inactive
+	SkipVoteShare    float64       // share of the active users whose votes skip a song
+	PlayNextShare    float64       // share of the other active users whose approvals move a song to play next
+	RecapWebhook     string        // address party recaps are posted to, such as a Discord webhook
+	JingleEvery      uint32        // songs between jingles. Zero doesn't count songs
+	JingleOnHour     bool          // play a jingle once each hour strikes
+	Crossfade        time.Duration // how long players fade songs out and in. Zero doesn't fade
+	MetricsAddr      string        // address to serve Prometheus metrics on. Empty doesn't serve them
+
+	// How songs are ordered until a preset picks another way. In the hybrid
+	// queue each vote moves a song RotationVoteWeight rounds ahead of other
+	// users' songs and BucketVoteWeight places ahead of its submitter's other
+	// songs. Negative weights fall back to the defaults.
+	Queue              bepb.QueueAlgorithm
+	RotationVoteWeight float64
+	BucketVoteWeight   float64
+
+	// Quality players play songs at until an admin sets another one.
+	// AudioOnly has every player play audio only for good and warns about
+	// songs whose video is the point.
+	Quality   bepb.PlaybackQuality
+	AudioOnly bool
+
+	// Where playlists and snapshots are saved. Empty saves them to local
+	// files, and an s3:// url, such as s3://bucket/prefix, saves them to the
+	// bucket at S3Endpoint, which defaults to AWS's endpoint for S3Region.
+	SnapshotStore string
+	S3Endpoint    string
+	S3Region      string
+	S3AccessKey   string
+	S3SecretKey   string
+	CrashSnapshot string // snapshot saved on request and before going down. Empty saves none
+
+	// Length tiers. Songs at least DoubleAfter long count double against
+	// UserSongs, the songs a user may have queued, and songs at least
+	// ApprovalAfter long wait for an admin to approve them. Zero disables each.
+	DoubleAfter   time.Duration
+	ApprovalAfter time.Duration
+	UserSongs     uint32
+
+	// Fetcher to use for each service, such as "web": "ytdlp". Services left
+	// out use their default fetcher.
+	Fetchers map[string]string
+
+	// Limits on calls to outside services, such as the YouTube api. Each
+	// attempt may take FetchTimeout and failed attempts are retried up to
+	// FetchRetries times with backoff. After BreakerAfter failures in a row,
+	// calls to the service fail fast for BreakerCooldown. Zero values other
+	// than FetchRetries fall back to defaults.
+	FetchTimeout    time.Duration
+	FetchRetries    int
+	BreakerAfter    int
+	BreakerCooldown time.Duration
+
+	// Access control. Tokens maps role names, such as "admin", to the token
+	// granting the role. Policy maps rpc names to the role required to call
+	// them in place of the default, such as "NextSong": "anonymous". Without
+	// tokens every caller is an admin.
+	Tokens map[string]string
+	Policy map[string]string
+
+	// Experimental federation with a backend in another room
+	FederationName  string              // name of this backend. Defaults to the host name
+	FederationPeer  string              // address of the backend to follow. Empty to not follow one
+	FederationMode  bepb.FederationMode // how to follow the other backend
+	FederationToken string              // token sent to the backend being followed
+
+	// Connection tuning. Zero values fall back to defaults that keep player
+	// streams alive behind NATs.
+	KeepaliveTime         time.Duration // idle time before the server pings a client
+	KeepaliveTimeout      time.Duration // how long to wait for a ping response
+	KeepaliveMinTime      time.Duration // shortest ping interval allowed from clients
+	MaxConnectionIdle     time.Duration // close connections idle this long. Zero never closes them
+	MaxConnectionAge      time.Duration // close connections this old. Zero never closes them
+	MaxConnectionAgeGrace time.Duration // time given to rpcs on a connection closed for age
+	MaxRecvMsgSize        int           // largest message the server accepts in bytes
+	MaxSendMsgSize        int           // largest message the server sends in bytes
+}
+
+/*
+ * Create a new yt_box backend server. Exits if the server can't be created.
+ */
+func NewServer(config *ServerConfig, opts ...Option) *BackendServer {
+	server, err := New(config, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create the backend server: %v", err)
+	}
+
+	return server
 }
 
 /*
- * Create a new yt_box backend server
+ * Create a new yt_box backend server with options for embedding it in another
+ * program
  */
-func NewServer(addr string, loadFile string, dbPath string, ytApiKey string) *BackendServer {
-	var err error
+func New(config *ServerConfig, opts ...Option) (*BackendServer, error) {
+	weights := voteWeights(config)
+	parts := &serverParts{newQueuer: queuerFor(config.Queue, weights)}
+	for _, opt := range opts {
+		opt(parts)
+	}
+
+	// check the lyrics provider and fetchers before opening anything
+	lyricsProvider, err := newLyricsProvider(config.Lyrics)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := fetcherRoutes(config.Fetchers)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := newAccessPolicy(config.Tokens, config.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := parts.snapshotStore
+	if snapshots == nil {
+		if snapshots, err = newSnapshotStore(config); err != nil {
+			return nil, err
+		}
+	}
 
 	// initialize the backend server struct
 	server := new(BackendServer)
-	server.listener, err = net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatalf("Failed to listen on %s with error: %v", addr, err)
+	server.voteWeights = weights
+	server.bus = new(eventBus)
+	server.bus.init()
+	server.events = new(eventBroadcaster)
+	server.events.init()
+	server.listener = parts.listener
+	if server.listener == nil {
+		listener, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", config.Addr, err)
+		}
+		server.listener = listener
+	}
+
+	// initialize the shutdown state
+	server.started = time.Now()
+	server.shutdown, server.cancelStreams = context.WithCancel(context.Background())
+	server.drainTimeout = config.DrainTimeout
+	if server.drainTimeout <= 0 {
+		server.drainTimeout = defaultDrainTimeout
 	}
 
 	// initialize the rpc server
-	server.beServer = grpc.NewServer()
+	server.crashPath = config.CrashSnapshot
+	server.beServer = grpc.NewServer(serverOptions(config, policy, server)...)
 	bepb.RegisterYtbBackendServer(server.beServer, server)
 	bepb.RegisterYtbBePlayerServer(server.beServer, server)
 
 	// initialize the song queue
 	server.queueMgr = new(queuer.SongQueueManager)
-	server.queueMgr.Init(queuer.NewRoundRobinQueuer())
+	server.queueMgr.Init(parts.newQueuer())
+	server.queueMgr.SetArtistGap(config.ArtistGap)
 
 	// initialize the database manager
-	server.dbManager = new(db.SqliteManager)
-	server.dbManager.Init(dbPath)
+	server.dbManager = parts.dbManager
+	if server.dbManager == nil {
+		sqlite := new(db.SqliteManager)
+		if err := sqlite.Init(config.DbPath); err != nil {
+			server.listener.Close()
+			return nil, fmt.Errorf("failed to open database %s: %w", config.DbPath, err)
+		}
+
+		if config.ReadReplica != "" {
+			if err := sqlite.OpenReadReplica(config.ReadReplica); err != nil {
+				sqlite.Close()
+				server.listener.Close()
+				return nil, fmt.Errorf("failed to open read replica %s: %w", config.ReadReplica, err)
+			}
+		}
+		server.dbManager = sqlite
+	}
+
+	// write the song history in the background
+	server.writes = new(writeQueue)
+	server.writes.init(server.dbManager)
+
+	// initialize the achievement tracker
+	server.achievements = new(achievementTracker)
+	server.achievements.init(server.dbManager)
+
+	// initialize the lyrics finder
+	server.lyrics = new(lyricsFinder)
+	server.lyrics.init(lyricsProvider, server.dbManager)
+
+	// initialize the database maintenance
+	server.maintainer = new(dbMaintainer)
+	server.maintainer.init(server.dbManager, server.achievements, config.Retention, config.MaintenanceInterval)
 
 	// initialize the user identity cache
 	server.userCache = new(UserCache)
 	server.userCache.Init()
 
-	// load a snapshot playlist if provided
-	if loadFile != "" {
-		server.loadPlaylistFromFile(loadFile)
+	// initialize the song fetcher
+	server.fetcher = new(SongFetcher)
+	server.fetcher.init(config.YtApiKey, config.Region, config.FetchTimeout)
+
+	// guard the calls that leave the backend
+	server.guard = new(fetchGuard)
+	server.guard.init(config.FetchTimeout, config.FetchRetries, config.BreakerAfter, config.BreakerCooldown,
+		ServiceYoutube, ServiceWeb)
+
+	// route submitted links to the fetchers of their services
+	ytDlp := new(ytDlpFetcher)
+	warnMissingYtDlp(routes, ytDlp.init())
+	router := new(fetcherRouter)
+	router.init(routes, map[string]MetadataFetcher{FetcherBuiltin: server.fetcher, FetcherYtDlp: ytDlp},
+		parts.fetchers)
+	router.guardServices(server.guard, ServiceYoutube, ServiceWeb)
+	server.metadata = router
+
+	// remove the sample data before anything could queue it
+	if config.ClearDemo {
+		if _, err := server.dbManager.RemoveDemoData(); err != nil {
+			log.Printf("Failed to remove the demo data: %v", err)
+		}
+	}
+
+	// load a snapshot playlist if provided, repairing it against the database
+	server.rawTitles = config.RawTitles
+	server.snapshots = snapshots
+	if config.LoadFile != "" {
+		server.loadPlaylistFromFile(server.queueMgr, config.LoadFile)
+	}
+
+	// initialize the audio downloader
+	server.downloader = new(songDownloader)
+	server.downloader.init(config.CacheDir, config.CacheSize)
+	server.downloader.prefetch(server.queueMgr.GetPlaylist().Songs)
+
+	// initialize federation with other backends
+	server.initFederation(config)
+
+	// initialize the auto dj, leaving the music to the leader when mirroring it
+	mirroring := server.federationLink != nil && config.FederationMode == bepb.FederationMode_MirrorNowPlaying
+	server.autoDj = new(autoDj)
+	server.autoDj.init(server.dbManager, config.AutoDj && !mirroring, config.AutoDjAvoidRecent,
+		config.AutoDjAllowSameChannel)
+
+	// initialize the jingles played between songs
+	server.jingles = new(jingleBox)
+	server.jingles.init(server.dbManager, config.JingleEvery, config.JingleOnHour)
+
+	// audio only mode overrides whatever quality was asked for
+	server.audioOnly = config.AudioOnly
+	if config.AudioOnly {
+		config.Quality = bepb.PlaybackQuality_AudioQuality
 	}
 
 	// initialize the player manager
 	server.playerMgr = new(playerManager)
-	server.playerMgr.init(server.queueMgr)
+	server.playerMgr.init(server.queueMgr, server.downloader, server.autoDj, server.jingles, server.bus)
+	server.playerMgr.crossfade = config.Crossfade
+	server.playerMgr.quality = config.Quality
+
+	// initialize the player zones
+	server.zones = new(zoneManager)
+	server.zones.init(server.queueMgr, server.playerMgr, server.downloader, server.autoDj, server.jingles,
+		server.bus, parts.newQueuer)
+	server.zones.artistGap = config.ArtistGap
+	server.zones.crossfade = config.Crossfade
+	server.zones.quality = config.Quality
+	server.loadZones()
+
+	// measure how long each user's songs play
+	server.plays = new(playTracker)
+	server.plays.init()
+
+	// subscribe the server's parts to what it does
+	server.subscribeParts(parts.hooks)
+
+	server.limits = queueLimits{maxMinutes: allowedMinutes, window: config.SubmissionWindow}
+	server.boarding = new(boardingWindow)
+	server.boarding.init(config.BoardingWindow, time.Now())
+	server.kiosks = new(kioskLimiter)
+	server.kiosks.init(config.KioskSongs)
+	server.dedup = new(dedupWindow)
+	server.dedup.init(config.DedupWindow)
+	server.recapper = new(partyRecapper)
+	server.recapper.init(server.dbManager, config.RecapWebhook, time.Now())
+	server.tiers = lengthTiers{doubleAfter: config.DoubleAfter, approvalAfter: config.ApprovalAfter,
+		userSongs: config.UserSongs}
+	server.approvals = new(approvalQueue)
+	server.approvals.init()
+	server.decks = new(deckHandoff)
+	server.suggestions = new(approvalQueue)
+	server.suggestions.init()
+	server.registry = new(playerRegistry)
+	server.registry.init(server.dbManager)
+	server.apiKeys = new(apiKeyring)
+	server.apiKeys.init(server.dbManager)
+	policy.keys = server.apiKeys
+	policy.password = config.PartyPassword
+	server.policy = policy
+	server.clients = new(clientDirectory)
+	server.clients.init()
+	server.timeOuts = new(timeOutTracker)
+	server.timeOuts.init(server.endTimeOut)
+	server.scheduler = new(songScheduler)
+	server.scheduler.init(server.dbManager, server.playScheduled, time.Now())
+	server.metricsAddr = config.MetricsAddr
+
+	// initialize the activity tracking, skip votes and play next requests
+	server.activity = new(activityTracker)
+	server.activity.init(config.InactiveAfter)
+	server.skipVotes = new(skipVoter)
+	server.skipVotes.init(config.SkipVoteShare)
+	server.playNext = new(playNextVoter)
+	server.playNext.init(config.PlayNextShare)
+	server.loginCodes = new(loginCodeTracker)
+	server.loginCodes.init()
+	server.flagRestricted = config.FlagRestricted
+	server.allowAnonymous = config.AllowAnonymous
+	server.partyPassword = config.PartyPassword
+	server.joinUrl = config.JoinUrl
+
+	// add the sample data once everything it's queued through is ready
+	if config.Demo {
+		if err := server.seedDemo(time.Now()); err != nil {
+			log.Printf("Failed to add the demo data: %v", err)
+		}
+	}
 
-	// initialize the song fetcher
-	server.fetcher = new(SongFetcher)
-	server.fetcher.init(ytApiKey)
+	return server, nil
+}
+
+/*
+ * Start the server
+ */
+func (s *BackendServer) Serve() error {
+	s.stateLock.Lock()
+	if s.stopped {
+		s.stateLock.Unlock()
+		return grpc.ErrServerStopped
+	}
+	s.serving = true
+	s.zones.start()
+	s.maintainer.start()
+	s.federationLink.start(s.mirrorNowPlaying)
+	s.recapper.start(func(now time.Time) bool { return s.currentLimits().isOpen(now) })
+	if s.metricsAddr != "" {
+		s.startMetrics(s.metricsAddr)
+	}
+	s.stateLock.Unlock()
+
+	return s.beServer.Serve(s.listener)
+}
+
+/*
+ * Serve until the context is cancelled and then stop the server. Returns nil
+ * once the server stopped cleanly.
+ */
+func (s *BackendServer) Run(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.shutdown.Done():
+		}
+	}()
+
+	// Serve fails if the context was cancelled before it started, which
+	// isn't an error
+	if err := s.Serve(); err != nil && ctx.Err() == nil {
+		s.Stop()
+		<-stopped
+		return err
+	}
+
+	// Serve returns as soon as shutdown begins, so wait for the connections
+	// to drain
+	<-stopped
+	return nil
+}
+
+/*
+ * Stop the server. New player streams are turned away, connected players are
+ * told to disconnect and in-flight rpcs are given until the drain timeout to
+ * finish before their connections are cut. Safe to call more than once.
+ */
+func (s *BackendServer) Stop() {
+	s.stateLock.Lock()
+	if s.stopped {
+		s.stateLock.Unlock()
+		return
+	}
+	wasServing := s.serving
+	s.serving = false
+	s.stopped = true
+	s.stateLock.Unlock()
+
+	deadline := time.Now().Add(s.drainTimeout)
+
+	// end the player streams
+	s.cancelStreams()
+
+	// stop ending time-outs, which are lifted by the restart anyway
+	s.timeOuts.stop()
+
+	// stop playing scheduled songs, which are saved for the next start
+	s.scheduler.stop()
+
+	if wasServing {
+		// stop the player managers
+		s.zones.stop()
+
+		// stop the scheduled database maintenance
+		s.maintainer.stop()
+
+		// stop following the leader
+		s.federationLink.stop()
+
+		// recap the party that was going on
+		s.recapper.stop()
+
+		// stop serving the metrics
+		s.stopMetrics()
+	}
+
+	// wait for the player streams to clean up after themselves
+	if !s.waitForStreams(deadline) {
+		log.Printf("Player streams did not close within %v", s.drainTimeout)
+	}
+
+	// stop the rpc server, cutting off whatever is left at the deadline
+	stopped := make(chan struct{})
+	go func() {
+		s.beServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		log.Printf("Connections did not drain within %v, forcing shutdown", s.drainTimeout)
+		s.beServer.Stop()
+		<-stopped
+	}
+
+	// stop downloading songs
+	s.downloader.stop()
+
+	// finish writing the song history
+	s.writes.stop()
+}
+
+/*
+ * Admit a new player stream. Returns false if the server isn't serving. Every
+ * admitted stream must call streamWG.Done when it ends.
+ */
+func (s *BackendServer) admitStream() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if !s.serving {
+		return false
+	}
+
+	// adding under the lock keeps Stop from waiting on the group while a
+	// stream is being admitted
+	s.streamWG.Add(1)
+	return true
+}
+
+/*
+ * Wait for the admitted player streams to end. Returns false if they were
+ * still running at the deadline.
+ */
+func (s *BackendServer) waitForStreams(deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		s.streamWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+/*
+ * Receive a song from a remote client for appending to the play queue
+ */
+func (s *BackendServer) SendSong(con context.Context, sub *bepb.Submission) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+	log.Printf("Submission: {link: %s, userId: %d, source: %v}\n", sub.Link, sub.UserId, sub.Source)
+
+	userId, kiosk, err := s.submitterOf(con, sub)
+	if errors.Is(err, ErrGuestNameMissing) || errors.Is(err, ErrNotKiosk) {
+		response.Message = err.Error()
+		return response, nil
+	} else if err != nil {
+		log.Printf("Failed to add guest %s at kiosk %s: %v", sub.GetGuestName(), kiosk, err)
+		response.Message = "Failed to add you as a guest."
+		return response, nil
+	}
+
+	song := new(cmpb.Song)
+	song.UserId = userId
+	song.Source = sub.GetSource()
+	if kiosk != "" {
+		song.Source = cmpb.SubmissionSource_Kiosk
+	}
+
+	song.Username, song.RoomId = s.getUserFromId(song.UserId)
+	if song.Username == "" {
+		response.Message = "Song submitted by unknown user"
+		log.Printf(response.Message)
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(sub.GetZoneId())
+	if !exists {
+		response.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	if err := s.creditSong(song, sub.GetForUsername()); err != nil {
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	if sub.GetAnonymous() {
+		if !s.allowAnonymous {
+			response.Message = ErrAnonymousDisabled.Error()
+			return response, nil
+		}
+		song.Anonymous = true
+	}
+
+	if message := s.timedOutMessage(song.UserId); message != "" {
+		response.Message = message
+		return response, nil
+	}
+
+	heldByDecks, suggestion := s.decks.holds(zone.id, song.UserId)
+	if heldByDecks && !suggestion {
+		response.Message = s.decksMessage(zone.id, song.UserId)
+		return response, nil
+	}
+
+	s.touchUser(song.UserId)
+	exempt := s.isExempt(song.UserId)
+	limits := s.currentLimits()
+
+	if now := time.Now(); !exempt && !limits.isOpen(now) {
+		response.Message = fmt.Sprintf("The queue is closed. It opens again at %s.",
+			limits.nextOpen(now).Format(time.Kitchen))
+		return response, nil
+	}
+
+	var restriction error
+	if links.IsSearchQuery(sub.Link) {
+		if err := s.resolveSearchQuery(sub.Link, song); errors.Is(err, ErrServiceUnavailable) {
+			response.Message = serviceDownMessage
+			log.Println(err.Error())
+			return response, nil
+		} else if err != nil {
+			response.Message = "Could not find a song matching your search."
+			log.Println(err.Error())
+			return response, nil
+		}
+	} else if err := s.metadata.FetchSongData(sub.Link, song); isRestricted(err) {
+		// restricted videos are flagged instead of rejected if so configured
+		restriction = err
+		if !s.flagRestricted {
+			response.Message = err.Error() + " Please pick another video."
+			log.Printf("Rejected %s from user %d: %v", song.ServiceId, song.UserId, err)
+			return response, nil
+		}
+	} else if errors.Is(err, ErrServiceUnavailable) {
+		response.Message = serviceDownMessage
+		log.Println(err.Error())
+		return response, nil
+	} else if err != nil {
+		response.Message = "Failed to fetch metadata for your song. Please check your link."
+		log.Println(err.Error())
+		return response, nil
+	}
+
+	if blankTitle(song) {
+		response.Message = ErrBlankTitle.Error()
+		log.Printf("Rejected %s from user %d without a title", song.ServiceId, song.UserId)
+		return response, nil
+	}
+
+	if err := applyChapter(song, sub.GetChapter()); err != nil {
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	if s.isBlocked(song) {
+		response.Message = ErrSongBlocked.Error()
+		log.Printf("Rejected blocked song %s from user %d", song.ServiceId, song.UserId)
+		return response, nil
+	}
+
+	duration, err := period.Parse(song.Metadata.Duration)
+	if err != nil {
+		response.Message = "Got an unexpected response from YouTube."
+		log.Printf("Failed to parse duration %s with error %s\n", song.Metadata.Duration, err.Error())
+		return response, nil
+	}
+
+	// long songs go to an admin instead of being held to the length cap
+	length := duration.DurationApprox()
+	response.Tier = s.tiers.tierOf(length)
+	if !exempt && response.Tier != bepb.LengthTier_LongSong && !isValidDuration(duration, limits.maxMinutes) {
+		response.Message = fmt.Sprintf("Please do no submit songs greater than %d minutes.", limits.maxMinutes)
+		return response, nil
+	}
+
+	applyTitle(song, s.rawTitles)
+	s.applyPreferences(song, sub.Link)
+
+	// songs for the default zone go to the leader when following one
+	if zone.id == defaultZoneId {
+		if forwarded, ok := s.federationLink.forward(song); ok {
+			log.Printf("Forwarded %s from user %d: %s", song.ServiceId, song.UserId, forwarded.Message)
+			return forwarded, nil
+		}
+	}
+
+	if recent := s.dedup.find(song, zone.queueZoneId(), time.Now()); recent != nil {
+		return s.voteForRecent(zone, recent, song.UserId), nil
+	}
+
+	if isQueued(zone.queueMgr, song) {
+		response.Message = "That song is already queued."
+		return response, nil
+	}
+
+	// the dj decides whether suggestions are queued, so the limits don't apply
+	if heldByDecks {
+		id := s.suggestions.hold(song, zone.id, time.Now())
+		response.Message = "The dj has the decks. Your song was sent to them as a suggestion."
+		log.Printf("Holding %s from user %d as suggestion %d", song.ServiceId, song.UserId, id)
+		return response, nil
+	}
+
+	if limits.window > 0 && !exempt {
+		now := time.Now()
+		start := estimateStart(zone.queueMgr, song, now)
+		if start.After(now.Add(limits.window)) {
+			response.EstimatedStart = start.Unix()
+			response.Message = fmt.Sprintf("Your song wouldn't start until around %s. Only songs starting within %v can be queued.",
+				start.Format(time.Kitchen), limits.window)
+			log.Printf("Rejected %s from user %d, estimated start %v", song.ServiceId, song.UserId, start)
+			return response, nil
+		}
+	}
+
+	if !exempt && !s.tiers.allows(zone.queueMgr, song.UserId, length) {
+		response.Message = fmt.Sprintf("You can only have %d songs queued at a time.", s.tiers.userSongs)
+		if s.tiers.doubleAfter > 0 {
+			response.Message += fmt.Sprintf(" Songs over %.0f minutes count as two.", s.tiers.doubleAfter.Minutes())
+		}
+		return response, nil
+	}
+
+	if !exempt && response.Tier == bepb.LengthTier_LongSong {
+		id := s.approvals.hold(song, zone.id, time.Now())
+		response.Message = fmt.Sprintf("Songs over %.0f minutes need the host's approval. Your song is waiting for it.",
+			s.tiers.approvalAfter.Minutes())
+		log.Printf("Holding %s from user %d for approval as pending song %d", song.ServiceId, song.UserId, id)
+		return response, nil
+	}
+
+	if !exempt {
+		if end, ok := s.boarding.board(song.UserId, limits, time.Now()); !ok {
+			response.Message = fmt.Sprintf("Everyone gets one song to start the party. You can queue more at %s.",
+				end.Format(time.Kitchen))
+			return response, nil
+		}
+	}
+
+	// a song turned away from here on doesn't use up the user's boarding song
+	unboard := func() {
+		if !exempt {
+			s.boarding.unboard(song.UserId)
+		}
+	}
+
+	if kiosk != "" {
+		if next, ok := s.kiosks.take(kiosk, time.Now()); !ok {
+			unboard()
+			response.Message = fmt.Sprintf("This kiosk has queued all the songs it can for now. Try again at %s.",
+				next.Format(time.Kitchen))
+			log.Printf("Rejected %s from kiosk %s over its limit", song.ServiceId, kiosk)
+			return response, nil
+		}
+	}
+
+	if err := s.queueSong(zone, song); err != nil {
+		unboard()
+		response.Message = ErrSongNotRecorded.Error()
+		return response, nil
+	}
+	s.dedup.record(song, zone.queueZoneId(), time.Now())
+
+	var warnings []string
+	if restriction != nil {
+		warnings = append(warnings, restriction.Error())
+	}
+	if warning := s.videoWarning(song); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	response.Success = true
+	response.Message = "Success"
+	if len(warnings) > 0 {
+		response.Message = "Queued, but heads up: " + strings.Join(warnings, " ")
+	}
+	log.Printf("Song data: { %v}", song)
+	return response, nil
+}
+
+/*
+ * Fill in the song with the top search result that's short enough to be
+ * queued. Falls back to the top result so the caller reports why it can't be
+ * queued.
+ */
+func (s *BackendServer) resolveSearchQuery(query string, song *cmpb.Song) error {
+	candidates, err := s.searchYoutube(query, searchResolveCount)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return ErrNoSearchResults
+	}
+
+	maxMinutes := s.currentLimits().maxMinutes
+	match := candidates[0]
+	for _, candidate := range candidates {
+		duration, err := period.Parse(candidate.Metadata.Duration)
+		if err == nil && isValidDuration(duration, maxMinutes) {
+			match = candidate
+			break
+		}
+	}
+
+	log.Printf("Resolved search %q to %s", query, match.ServiceId)
+	song.Title = match.Title
+	song.ServiceId = match.ServiceId
+	song.Service = match.Service
+	song.Metadata = match.Metadata
+	return nil
+}
+
+/*
+ * Search YouTube for songs matching a free-text query
+ */
+func (s *BackendServer) SearchCandidates(con context.Context, request *bepb.SearchRequest) (*bepb.SearchResults, error) {
+	response := &bepb.SearchResults{Err: &bepb.Error{Success: false}}
+
+	query := strings.TrimSpace(request.GetQuery())
+	if query == "" {
+		response.Err.Message = "Missing search query."
+		return response, nil
+	}
+
+	maxResults := int64(request.GetMaxResults())
+	if maxResults == 0 {
+		maxResults = defaultSearchResults
+	} else if maxResults > maxSearchResults {
+		maxResults = maxSearchResults
+	}
+
+	songs, err := s.searchYoutube(query, maxResults)
+	if errors.Is(err, ErrServiceUnavailable) {
+		log.Println(err.Error())
+		response.Err.Message = serviceDownMessage
+		return response, nil
+	} else if err != nil {
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	for _, song := range songs {
+		applyTitle(song, s.rawTitles)
+	}
+
+	response.Songs = songs
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Subscribe the parts of the server that act on its events. The history is
+ * recorded and the queue saved before anything else hears of an event, and
+ * the embedding program's hooks hear of it last. Event streams only see
+ * anonymous songs with their submitters hidden.
+ */
+func (s *BackendServer) subscribeParts(hooks *Hooks) {
+	s.bus.subscribe(s.recordEnding, bepb.EventType_SongSkipped, bepb.EventType_SongEnded)
+	s.bus.subscribe(s.saveQueue, bepb.EventType_SongQueued, bepb.EventType_SongRemoved, bepb.EventType_SongPlaying,
+		bepb.EventType_PlayNextMoved, bepb.EventType_SongReturned)
+	s.bus.subscribe(s.prefetchQueue, bepb.EventType_SongQueued, bepb.EventType_PlayNextMoved)
+	s.bus.subscribe(s.plays.record, bepb.EventType_SongPlaying)
+	s.lyrics.subscribe(s.bus)
+	s.bus.subscribe(func(event *bepb.Event) { s.events.publish(publicEvent(event)) })
+	hooks.subscribe(s.bus)
+}
+
+/*
+ * Record in the history how a song ended, marking it skipped if it was
+ */
+func (s *BackendServer) recordEnding(event *bepb.Event) {
+	if event.Song.Jingle {
+		return
+	}
+
+	if event.Type == bepb.EventType_SongSkipped {
+		s.writes.markSkipped(event.Song.SongId)
+	}
+	s.writes.setOutcome(event.Song.SongId, event.Outcome, event.SkippedBy, event.Voters)
+}
+
+/*
+ * Save the queue of the zone the event happened in so it can be reloaded
+ * after a crash. Shared zones save the default zone's queue.
+ */
+func (s *BackendServer) saveQueue(event *bepb.Event) {
+	zone, exists := s.zones.get(event.ZoneId)
+	if !exists {
+		return
+	}
+
+	out, err := zone.queueMgr.MarshalPlaylist()
+	if err != nil {
+		return
+	}
+
+	path := zoneQueueSnapshot(zone.queueZoneId())
+	if err = s.snapshots.Save(path, out); err != nil {
+		log.Printf("Failed to save the queue to \"%s\" with error: %v", path, err)
+	}
+}
+
+/*
+ * Returns where the queue of a zone is saved. The default zone's queue is
+ * saved to the usual queue snapshot.
+ */
+func zoneQueueSnapshot(zoneId uint32) string {
+	if zoneId == defaultZoneId {
+		return queuer.QueueSnapshot
+	}
+
+	return fmt.Sprintf("%s.%d", queuer.QueueSnapshot, zoneId)
+}
+
+/*
+ * Download the audio of the songs coming up in the zone a song was queued in
+ */
+func (s *BackendServer) prefetchQueue(event *bepb.Event) {
+	if zone, exists := s.zones.get(event.ZoneId); exists {
+		s.downloader.prefetch(zone.queueMgr.GetPlaylist().Songs)
+	}
+}
+
+/*
+ * Append a song to a zone's queue and record it in the database. The song is
+ * given an id before it's queued and recorded in the background. Songs that
+ * couldn't be given an id aren't queued, so no song is queued without an id
+ * of its own. Neither are songs from users the database doesn't know, which
+ * it would refuse to record once the song was already queued.
+ */
+func (s *BackendServer) queueSong(zone *zone, song *cmpb.Song) error {
+	if username, _ := s.getUserFromId(song.UserId); username == "" {
+		log.Printf("Refused to queue %s from unknown user %d", song.ServiceId, song.UserId)
+		return ErrUnknownSubmitter
+	}
+
+	if err := s.writes.recordSong(song); err != nil {
+		log.Printf("Failed to record %s: %v", song.ServiceId, err)
+		return err
+	}
+
+	s.enqueueSong(zone, song)
+	return nil
+}
+
+/*
+ * Append a song that's already recorded in the database to a zone's queue.
+ * Anonymous songs lose their submitter's name here, before anyone sees them.
+ */
+func (s *BackendServer) enqueueSong(zone *zone, song *cmpb.Song) {
+	hideSubmitterName(song)
+	zone.queueMgr.AddSong(song)
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_SongQueued, ZoneId: zone.id, Song: song})
+}
+
+/*
+ * Load a playlist from a serialized protobuf file in the snapshot store into a
+ * queue. Broken songs are repaired or dropped before they're queued.
+ */
+func (s *BackendServer) loadPlaylistFromFile(queueMgr *queuer.SongQueueManager, file string) {
+	in, err := s.snapshots.Load(file)
+	if errors.Is(err, os.ErrNotExist) && queueMgr != s.queueMgr {
+		return
+	} else if err != nil {
+		log.Printf("Error reading file: %s", file)
+		return
+	}
+
+	playlist := &bepb.Playlist{}
+	err = proto.Unmarshal(in, playlist)
+	if err != nil {
+		log.Printf("Failed to parse playlist file: %v", err)
+		return
+	}
+
+	songs, repairs := s.repairQueue(playlist.Songs)
+	log.Printf("Loading songs from file \"%s\", %v:", file, repairs)
+	for index, song := range songs {
+		queueMgr.AddSong(song)
+		log.Printf("%3d. { %v}", index+1, song)
+	}
+}
+
+/*
+ * Returns the songs a user has waiting in a zone's queue, so clients can show
+ * a user their songs without fetching the whole playlist. Anonymous songs are
+ * only listed for callers trusted to act for users, like the frontend, since
+ * anyone else asking could be finding out who submitted them.
+ */
+func (s *BackendServer) ListQueuedByUser(con context.Context, request *bepb.UserQueueRequest) (*bepb.UserQueue, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.UserQueue{Err: &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}}, nil
+	}
+
+	return &bepb.UserQueue{
+		Songs: queuedByUser(zone.queueMgr, request.GetUserId(), s.policy.callerRole(con) >= roleUser, time.Now()),
+		Err:   &bepb.Error{Success: true, Message: "Success"},
+	}, nil
+}
+
+/*
+ * Returns the songs in the queue back to the requesting client
+ */
+func (s *BackendServer) GetPlaylist(con context.Context, arg *cmpb.Empty) (*bepb.Playlist, error) {
+	return publicPlaylist(s.queueMgr.GetPlaylist()), nil
+}
+
+/*
+ * Login the given user. If the userId is zero, then a new user needs to be
+ * created. A successful call will echo the username and return a userId
+ * greater than zero. An id of zero indicates an error occurred and the user
+ * will not be considered to be logged in. An a user already exists with the
+ * given id, but with a different name, then the new name shall be applied to
+ * the database.
+ */
+func (s *BackendServer) LoginUser(con context.Context, user *bepb.User) (*bepb.User, error) {
+	response := new(bepb.User)
+	response.Err = new(bepb.Error)
+	response.Err.Success = false
+
+	if err := s.checkPartyPassword(user.GetPartyPassword()); err != nil {
+		log.Printf("Refused to log in %s: %v", user.Username, err)
+		response.Username = user.Username
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	userData, err := s.dbFor(con).GetUserById(user.UserId)
+
+	if userData == nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// if no results were returned, then create a new user
+			userData, err = s.findOrAddUser(user.Username, user.RoomId)
+			if err != nil {
+				log.Printf("Failed to add user: %s, to room: %d, err: %s",
+					user.Username, user.RoomId, err.Error())
+				response.Username = user.Username
+				response.Err.Message = "Failed to add new user."
+				return response, nil
+			}
+		} else {
+			response.Username = user.Username
+			response.Err.Message = "Failed to add new user."
+			return response, nil
+		}
+	} else if userData.User.Username != user.Username {
+		// Update the username in the database if the names differ
+		err = s.dbFor(con).UpdateUsername(user.Username, user.UserId)
+		if err != nil {
+			log.Println("Could not update username")
+			response.Username = user.Username
+			response.Err.Message = "Could not update username."
+			return response, nil
+		}
+	}
+
+	// cache the user id and username
+	s.userCache.AddUserToCache(userData.User.UserId, user.Username, user.RoomId)
+	s.touchUser(userData.User.UserId)
+
+	response.Username = user.Username
+	response.UserId = userData.User.UserId
+	response.RoomId = userData.User.RoomId
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Pops a song off the top of the queueMgr and returns it
+ */
+func (s *BackendServer) PopQueue(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
+	if s.queueMgr.Len() > 0 {
+		song := s.queueMgr.Dispatch(s.playerMgr.confirmWithin)
+		log.Printf("Popped song: %v\n", song)
+		return song, nil
+	}
+
+	log.Println("Queue is empty, nothing to pop")
+	return &cmpb.Song{}, nil
+}
+
+/*
+ * Hands out a code for linking a new device to a user signed in elsewhere
+ */
+func (s *BackendServer) CreateLoginCode(con context.Context, empty *cmpb.Empty) (*bepb.LoginCode, error) {
+	code, secret, err := s.loginCodes.create(time.Now())
+	if err != nil {
+		log.Printf("Failed to create a login code: %v", err)
+		return &bepb.LoginCode{Err: &bepb.Error{Success: false, Message: "Failed to create a login code."}}, nil
+	}
+
+	return &bepb.LoginCode{
+		Code:      code,
+		Secret:    secret,
+		ExpiresIn: uint32(loginCodeTtl.Seconds()),
+		Err:       &bepb.Error{Success: true, Message: "Success"},
+	}, nil
+}
+
+/*
+ * Confirms a login code on behalf of a signed in user, so the device showing
+ * the code is signed in as them
+ */
+func (s *BackendServer) ConfirmLoginCode(con context.Context, request *bepb.LoginCodeConfirmation) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	if err := s.loginCodes.confirm(request.GetCode(), request.GetUserId(), time.Now()); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
+	log.Printf("User %d confirmed a login code", request.GetUserId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Signs a new device in as the user who confirmed its code
+ */
+func (s *BackendServer) RedeemLoginCode(con context.Context, request *bepb.LoginCode) (*bepb.User, error) {
+	userId, err := s.loginCodes.redeem(request.GetCode(), request.GetSecret(), time.Now())
+	if err != nil {
+		return &bepb.User{Err: &bepb.Error{Success: false, Message: err.Error()}}, nil
+	}
+
+	username, roomId := s.getUserFromId(userId)
+	if username == "" {
+		return &bepb.User{Err: &bepb.Error{Success: false, Message: "User does not exist."}}, nil
+	}
+
+	s.touchUser(userId)
+	log.Printf("Linked a new device to user %d", userId)
+	return &bepb.User{
+		Username: username,
+		UserId:   userId,
+		RoomId:   roomId,
+		Err:      &bepb.Error{Success: true, Message: "Success"},
+	}, nil
+}
+
+/*
+ * Confirms a song popped off a zone's queue started playing, so it doesn't go
+ * back to the head of the queue
+ */
+func (s *BackendServer) ConfirmPlayback(con context.Context, request *bepb.PlaybackConfirmation) (*bepb.Error, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if !zone.queueMgr.ConfirmDispatched(request.GetSongId()) {
+		return &bepb.Error{Success: false, Message: "The song isn't waiting to be confirmed."}, nil
+	}
+
+	log.Printf("Confirmed song %d started", request.GetSongId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Saves the current playlist to the given file location
+ */
+func (s *BackendServer) SavePlaylist(con context.Context, fname *bepb.FilePath) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+	if fname.WithHistory {
+		return s.saveSnapshot(fname.Path), nil
+	}
+
+	out, err := s.queueMgr.MarshalPlaylist()
+	if err != nil {
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	if err = s.snapshots.Save(fname.Path, out); err != nil {
+		log.Printf("Failed to write playlist to \"%s\" with error: %v", fname.Path, err)
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	log.Printf("Saved current playlist to: %s", fname.Path)
+	response.Success = true
+	response.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Saves the playlist, the now playing song and the recent history to the given
+ * file location
+ */
+func (s *BackendServer) saveSnapshot(path string) *bepb.Error {
+	snapshot, err := s.takeSnapshot()
+	if err != nil {
+		log.Printf("Failed to take snapshot: %v", err)
+		return &bepb.Error{Success: false, Message: "Failed to read the history."}
+	}
+
+	out, err := proto.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Failed to encode Snapshot with error: %v", err)
+		return &bepb.Error{Success: false, Message: err.Error()}
+	}
+
+	if err = s.snapshots.Save(path, out); err != nil {
+		log.Printf("Failed to write snapshot to file \"%s\" with error: %v", path, err)
+		return &bepb.Error{Success: false, Message: err.Error()}
+	}
+
+	log.Printf("Saved snapshot to: %s {songs: %d, history: %d}", path, len(snapshot.Songs), len(snapshot.History))
+	return &bepb.Error{Success: true, Message: "Success"}
+}
+
+/*
+ * Restores a snapshot or playlist saved to the given file location
+ */
+func (s *BackendServer) RestorePlaylist(con context.Context, fname *bepb.FilePath) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+
+	in, err := s.snapshots.Load(fname.Path)
+	if err != nil {
+		log.Printf("Error reading file: %s", fname.Path)
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	snapshot := new(bepb.Snapshot)
+	if err = proto.Unmarshal(in, snapshot); err != nil {
+		log.Printf("Failed to parse snapshot file: %v", err)
+		response.Message = "Failed to parse snapshot file."
+		return response, nil
+	}
+
+	queued, restored, err := s.restoreSnapshot(snapshot)
+	if err != nil {
+		log.Printf("Failed to restore snapshot %s: %v", fname.Path, err)
+		response.Message = "Failed to restore snapshot."
+		return response, nil
+	}
+
+	log.Printf("Restored snapshot from: %s {queued: %d, history: %d}", fname.Path, queued, restored)
+	response.Success = true
+	response.Message = fmt.Sprintf("Queued %d songs and restored %d songs of history.", queued, restored)
+	return response, nil
+}
+
+/*
+ * Imports a queue file written by an older backend, recording its songs in
+ * the database and queueing them
+ */
+func (s *BackendServer) MigrateQueue(con context.Context, fname *bepb.FilePath) (*bepb.Error, error) {
+	queued, err := s.migrateQueue(fname.Path)
+	if err != nil {
+		log.Printf("Failed to migrate queue file %s: %v", fname.Path, err)
+		return &bepb.Error{Success: false, Message: "Failed to migrate queue file."}, nil
+	}
+
+	log.Printf("Migrated queue file: %s {queued: %d}", fname.Path, queued)
+	return &bepb.Error{Success: true, Message: fmt.Sprintf("Queued %d songs.", queued)}, nil
+}
+
+/*
+ * Compares two snapshot or playlist files, or one of them against the live
+ * queue, without changing anything
+ */
+func (s *BackendServer) ComparePlaylists(con context.Context, comparison *bepb.PlaylistComparison) (*bepb.PlaylistDiff, error) {
+	playlists := make([][]*cmpb.Song, 0, 2)
+	for _, path := range []string{comparison.GetBefore(), comparison.GetAfter()} {
+		songs, err := s.comparedSongs(path)
+		if err != nil {
+			log.Printf("Failed to read playlist %s to compare: %v", path, err)
+			return &bepb.PlaylistDiff{Err: &bepb.Error{Success: false, Message: "Failed to read " + path + "."}}, nil
+		}
+		playlists = append(playlists, songs)
+	}
+
+	diff := diffPlaylists(playlists[0], playlists[1])
+	diff.Err = &bepb.Error{Success: true, Message: "Success"}
+	return diff, nil
+}
+
+/*
+ * Returns the database manager for queries made on behalf of a call. The
+ * queries give up once the call is canceled or its deadline passes.
+ */
+func (s *BackendServer) dbFor(con context.Context) db.DbManager {
+	return s.dbManager.WithContext(con)
+}
+
+/*
+ * Returns the username associated with the user id. An empty string is
+ * returned if there was an error or the user id wasn't found.
+ */
+func (s *BackendServer) getUserFromId(userId uint32) (string, uint32) {
+	if userId == 0 {
+		log.Println("User id of zero was passed into getUserFromId")
+		return "", 0
+	}
+
+	// check the user identities cache for the name
+	username, exists := s.userCache.LookupUsername(userId)
+	if exists {
+		roomId, _ := s.userCache.LookupRoomId(userId)
+		return username, roomId
+	}
+
+	// retrieve the name from the database if the user isn't in the cache
+	userData, err := s.dbManager.GetUserById(userId)
+	if err != nil {
+		log.Printf("Failed to get username from database with id: %d", userId)
+		return "", 0
+	}
+
+	// Add the username to the cache and return the name we found in the
+	// database
+	s.userCache.AddUserToCache(userId, userData.User.Username, userData.User.RoomId)
+	return userData.User.Username, userData.User.RoomId
+}
+
+/*
+ * Apply the submitter's preferences to a song. Songs start where their link
+ * says unless the submitter always starts from the beginning. Chapters always
+ * start at their own start. The song is queued without the preferences if
+ * they can't be read.
+ */
+func (s *BackendServer) applyPreferences(song *cmpb.Song, link string) {
+	preferences, err := s.dbManager.GetPreferences(song.UserId)
+	if err != nil {
+		log.Printf("Failed to get preferences of user %d: %v", song.UserId, err)
+		return
+	}
+
+	song.AudioOnly = preferences.AudioOnly
+	if song.Chapter == 0 && preferences.StartBehavior == bepb.StartBehavior_StartFromLink {
+		song.StartAt = uint32(links.Parse(link).StartAt)
+	}
+}
+
+/*
+ * Credit a song to the user in the submitter's room it was queued for. Songs
+ * queued for the submitter themselves aren't credited.
+ */
+func (s *BackendServer) creditSong(song *cmpb.Song, forUsername string) error {
+	forUsername = strings.TrimSpace(forUsername)
+	if forUsername == "" {
+		return nil
+	}
+
+	recipient, err := s.dbManager.GetUserByName(forUsername, song.RoomId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownRecipient
+	} else if err != nil {
+		log.Printf("Failed to look up user %s: %v", forUsername, err)
+		return err
+	}
+
+	if recipient.User.UserId != song.UserId {
+		song.ForUserId = recipient.User.UserId
+		song.ForUsername = recipient.User.Username
+	}
+
+	return nil
+}
+
+/*
+ * Removes the given song from the playlist. The user identified by the song
+ * eviction must be the user who submitted the song or the user it was queued
+ * for.
+ */
+func (s *BackendServer) RemoveSong(con context.Context, eviction *bepb.Eviction) (*bepb.Error, error) {
+	zone, exists := s.zones.get(eviction.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	err := zone.queueMgr.RemoveSong(eviction.GetSongId(), eviction.GetUserId())
+
+	if err != nil {
+		log.Printf("Failed to remove song from playlist: %v", err)
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	} else {
+		log.Printf("Removed song: {song id: %d, user id: %d}", eviction.GetSongId(), eviction.GetUserId())
+		s.bus.publish(&bepb.Event{Type: bepb.EventType_SongRemoved, ZoneId: zone.id,
+			Song: &cmpb.Song{SongId: eviction.GetSongId()}})
+		return &bepb.Error{Success: true, Message: "Success"}, nil
+	}
+}
+
+/*
+ * Removes every queued copy of a song from every zone, no matter who submitted
+ * it, such as a song everyone piled onto. The song is blocked from being
+ * submitted again if asked to, even when no copies were queued.
+ */
+func (s *BackendServer) RemoveByServiceId(con context.Context, eviction *bepb.ServiceEviction) (*bepb.ServiceEvictionResult, error) {
+	result := &bepb.ServiceEvictionResult{Err: &bepb.Error{Success: true, Message: "Success"}}
+	if eviction.GetBlock() {
+		if err := s.dbFor(con).BlockSong(eviction.GetService(), eviction.GetServiceId()); err != nil {
+			result.Err = &bepb.Error{Success: false, Message: "Failed to block the song."}
+			return result, nil
+		}
+		result.Blocked = true
+	}
+
+	// zones sharing a queue only find the copies once
+	for _, zone := range s.zones.list() {
+		for _, song := range zone.queueMgr.RemoveByServiceId(eviction.GetService(), eviction.GetServiceId()) {
+			s.bus.publish(&bepb.Event{Type: bepb.EventType_SongRemoved, ZoneId: zone.id,
+				Song: &cmpb.Song{SongId: song.SongId}})
+			result.Removed++
+		}
+	}
+
+	log.Printf("Removed every copy of %s: {removed: %d, blocked: %t}", eviction.GetServiceId(), result.Removed,
+		result.Blocked)
+	return result, nil
+}
+
+/*
+ * Lets a blocked song be submitted again
+ */
+func (s *BackendServer) UnblockSong(con context.Context, eviction *bepb.ServiceEviction) (*bepb.Error, error) {
+	unblocked, err := s.dbFor(con).UnblockSong(eviction.GetService(), eviction.GetServiceId())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to unblock the song."}, nil
+	} else if !unblocked {
+		return &bepb.Error{Success: false, Message: "That song isn't blocked."}, nil
+	}
+
+	log.Printf("Unblocked song: %s", eviction.GetServiceId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Returns true if the host blocked the song from being submitted. Songs are
+ * let through if the database can't be checked.
+ */
+func (s *BackendServer) isBlocked(song *cmpb.Song) bool {
+	blocked, err := s.dbManager.IsSongBlocked(song.Service, song.ServiceId)
+	return err == nil && blocked
+}
+
+/*
+ * Returns the song that should be considered "now playing". If there isn't a
+ * current song, then an empty Song struct is returned.
+ */
+func (s *BackendServer) GetNowPlaying(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
+	nowPlaying := s.queueMgr.NowPlaying()
+
+	if nowPlaying == nil {
+		return &cmpb.Song{}, nil
+	}
+
+	return publicSong(nowPlaying), nil
+}
+
+/*
+ * Forwards the command to skip the currently playing song onto the remote
+ * player. If the queue is empty the players are stopped instead and the
+ * response says there was no song to skip to.
+ */
+func (s *BackendServer) NextSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
+	skip := &bepb.Event{Outcome: bepb.SongOutcome_SkippedByAdmin, SkippedBy: s.policy.describeCaller(con)}
+	if !s.skipNowPlaying(s.zones.defaultZone, skip) {
+		return &bepb.Error{Success: false, Message: ErrQueueEmpty.Error()}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Skip the song playing in a zone and send the zone's players the next song
+ * in its queue. The skip event carries how the song was skipped and by whom.
+ * If the queue is empty, the players are told to stop and nothing is left
+ * playing. Returns false if there was no song to skip to.
+ */
+func (s *BackendServer) skipNowPlaying(zone *zone, skip *bepb.Event) bool {
+	if skipped := zone.queueMgr.NowPlaying(); skipped != nil {
+		// a skipped song is done with, even if no player confirmed it started
+		zone.queueMgr.ConfirmDispatched(skipped.SongId)
+		skip.Type = bepb.EventType_SongSkipped
+		skip.ZoneId = zone.id
+		skip.Song = skipped
+		s.bus.publish(skip)
+	}
+
+	nextSong := zone.queueMgr.Dispatch(zone.playerMgr.confirmWithin)
+	if nextSong == nil {
+		log.Printf("Stopping the players in zone %d with nothing left to play", zone.id)
+		zone.queueMgr.ClearNowPlaying()
+		zone.playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Stop})
+		return false
+	}
+
+	s.jingles.count(zone.id)
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Next, Song: nextSong}
+	control.LocalPath = s.downloader.lookup(nextSong)
+	upcoming := zone.queueMgr.GetPlaylist().Songs
+	control.Upcoming = s.downloader.hints(upcoming)
+	s.downloader.prefetch(upcoming)
+	zone.playerMgr.sendToPlayers(control)
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_SongPlaying, ZoneId: zone.id, Song: nextSong})
+	return true
+}
+
+/*
+ * Restarts the song playing in the default zone, or goes back to the song
+ * played before it if it only just started
+ */
+func (s *BackendServer) PreviousSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
+	if !s.previousSong(s.zones.defaultZone, time.Now()) {
+		return &bepb.Error{Success: false, Message: "There's no song to go back to."}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Send a zone's players back to the start of the song playing, or back to the
+ * song played before it if the song started within the last few seconds or
+ * isn't known to be playing. Returns false if there was nothing to go back to.
+ */
+func (s *BackendServer) previousSong(zone *zone, now time.Time) bool {
+	song, startedAt := zone.queueMgr.NowPlayingSince()
+	restart := song != nil &&
+		playbackPosition(song, startedAt, zone.playerMgr.lastPosition(), now).Elapsed > restartAfter.Seconds()
+
+	if !restart {
+		if previous := zone.queueMgr.PreviousSong(); previous != nil {
+			log.Printf("Going back to %s in zone %d", previous.ServiceId, zone.id)
+			control := &bepb.PlayerControl{Command: bepb.CommandType_Previous, Song: previous}
+			control.LocalPath = s.downloader.lookup(previous)
+			control.Upcoming = s.downloader.hints(zone.queueMgr.GetPlaylist().Songs)
+			zone.playerMgr.sendToPlayers(control)
+			s.bus.publish(&bepb.Event{Type: bepb.EventType_SongPlaying, ZoneId: zone.id, Song: previous})
+			return true
+		}
+	}
+
+	// players seek to the start of the song they're already playing
+	song = zone.queueMgr.RestartNowPlaying()
+	if song == nil {
+		return false
+	}
+
+	log.Printf("Restarting %s in zone %d", song.ServiceId, zone.id)
+	zone.playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Previous, Song: song})
+	return true
+}
+
+/*
+ * Forwards the command to pause the currently playing song onto the remote
+ * player
+ */
+func (s *BackendServer) PauseSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
+	s.playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Pause})
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Stream RPC connection with the remote player client
+ */
+func (s *BackendServer) SongPlayer(stream bepb.YtbBePlayer_SongPlayerServer) error {
+	if !s.admitStream() {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	defer s.streamWG.Done()
+
+	// the stream ends when the player disconnects, the player manager stops
+	// or the server shuts down
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.shutdown.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// once players are registered, only they may connect
+	registered, err := s.registry.identify(ctx)
+	if errors.Is(err, ErrUnregisteredPlayer) {
+		log.Printf("Turned away an unregistered player")
+		return status.Error(codes.Unauthenticated, err.Error())
+	} else if err != nil {
+		log.Printf("Failed to identify a remote player: %v", err)
+		return status.Error(codes.Internal, "failed to identify the player")
+	}
+
+	client := s.clients.connect(ctx, bepb.ClientKind_PlayerClient, s.policy.describeCaller(ctx))
+	defer s.clients.disconnect(client)
+
+	statuses := make(chan *bepb.PlayerStatus)
+	go receivePlayerStatus(ctx, cancel, stream, statuses)
+
+	// wait for the player to say which zone it wants to join
+	var first *bepb.PlayerStatus
+	select {
+	case first = <-statuses:
+	case <-ctx.Done():
+		log.Printf("Remote player left before sending its first status")
+		return nil
+	}
+
+	// the first status sent by the player decides which zone it joins, unless
+	// the player was registered to a zone
+	zoneName := first.GetZone()
+	if registered.GetZone() != "" {
+		zoneName = registered.GetZone()
+	}
+
+	zone, exists := s.zones.getByName(zoneName)
+	if !exists {
+		log.Printf("Remote player asked to join unknown zone: %s", zoneName)
+		return status.Errorf(codes.NotFound, "zone %s does not exist", zoneName)
+	}
+
+	id := zone.playerMgr.add(stream, cancel)
+	s.clients.joined(client, zone.id, id, registered.GetName())
+	if registered != nil {
+		zone.playerMgr.setRegisteredName(id, registered.Name)
+		log.Printf("Player %d (%s) joined zone %s", id, registered.Name, zone.name)
+
+		if registered.OutputDevice != "" {
+			zone.playerMgr.sendToPlayer(id, &bepb.PlayerControl{
+				Command:      bepb.CommandType_SetOutputDevice,
+				OutputDevice: registered.OutputDevice,
+			})
+		}
+	} else {
+		log.Printf("Player %d joined zone %s", id, zone.name)
+	}
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_PlayerJoined, ZoneId: zone.id, PlayerId: uint32(id)})
+	zone.playerMgr.receiveFromPlayers(ctx, id, first)
+
+	for {
+		select {
+		case playerStatus := <-statuses:
+			// write the received status to the player manager
+			s.clients.touch(client)
+			zone.playerMgr.receiveFromPlayers(ctx, id, playerStatus)
+
+		case <-ctx.Done():
+			if zone.playerMgr.remove(id) == 0 {
+				zone.queueMgr.ClearNowPlaying()
+			}
+			s.bus.publish(&bepb.Event{Type: bepb.EventType_PlayerLeft, ZoneId: zone.id, PlayerId: uint32(id)})
+			return nil
+		}
+	}
+}
+
+/*
+ * Forward the statuses sent by a remote player until its stream ends. Cancels
+ * the stream's context on the way out.
+ */
+func receivePlayerStatus(ctx context.Context, cancel context.CancelFunc,
+	stream bepb.YtbBePlayer_SongPlayerServer, statuses chan<- *bepb.PlayerStatus) {
+	defer cancel()
+
+	for {
+		playerStatus, err := stream.Recv()
+		if err == io.EOF {
+			log.Printf("Disconnected from remote player")
+			return
+		}
+
+		if grpc.Code(err) == codes.Canceled {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Error receiving message from remote player: %v", err)
+			return
+		}
+
+		select {
+		case statuses <- playerStatus:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+ * Get the user with the given name in a room, adding the user if there isn't
+ * one. Made through the write queue, which the caller waits on.
+ */
+func (s *BackendServer) findOrAddUser(username string, roomId uint32) (*db.UserData, error) {
+	return s.writes.findOrAddUser(username, roomId)
+}
+
+/*
+ * Handles command to create a new room. Room names should be unique. Will
+ * return an error if the room already exists.
+ */
+func (s *BackendServer) CreateRoom(con context.Context, room *bepb.Room) (*bepb.Room, error) {
+	response := new(bepb.Room)
+	response.Err = new(bepb.Error)
+	response.Err.Success = false
+	roomData, err := s.dbFor(con).GetRoomByName(room.Name)
+
+	// room doesn't exist so create it
+	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
+		roomData, err = s.dbFor(con).AddRoom(room.Name)
+
+		if err != nil {
+			log.Printf("Failed to create a new room: {name: %s, error: %v}", room.Name, err)
+			response.Err.Message = "Failed to create room."
+			return response, nil
+		}
+
+		response.Name = roomData.Room.Name
+		response.Id = roomData.Room.Id
+		response.Err.Success = true
+		return response, nil
+	}
+
+	response.Err.Message = "Room already exists."
+	return response, nil
+}
+
+/*
+ * Handles querying of a room for its existence.
+ */
+func (s *BackendServer) GetRoom(con context.Context, room *bepb.Room) (*bepb.Room, error) {
+	response := new(bepb.Room)
+	response.Err = new(bepb.Error)
+	response.Err.Success = false
+	roomData, err := s.dbFor(con).GetRoomByName(room.Name)
+
+	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
+		response.Err.Message = "Room does not exist."
+	} else if err != nil {
+		response.Err.Message = err.Error()
+	} else {
+		response.Name = roomData.Room.Name
+		response.Id = roomData.Room.Id
+		response.Err.Success = true
+	}
+
+	return response, nil
+}
+
+/*
+ * Returns extended metadata for a song that is either in the queue or was
+ * submitted in the past. Details are fetched from the song's service the first
+ * time they're requested and then served out of the database.
+ */
+func (s *BackendServer) GetSongDetails(con context.Context, request *bepb.SongDetailsRequest) (*bepb.SongDetails, error) {
+	response := &bepb.SongDetails{Err: &bepb.Error{Success: false}}
+
+	song := s.queueMgr.FindSong(request.GetSongId())
+	if song == nil {
+		var err error
+		song, err = s.dbFor(con).GetSongById(request.GetSongId())
+		if err != nil {
+			log.Printf("Failed to find song %d: %v", request.GetSongId(), err)
+			response.Err.Message = "Song does not exist."
+			return response, nil
+		}
+	}
+
+	cached, err := s.dbFor(con).GetSongDetails(song.Service, song.ServiceId)
+	if err == nil {
+		response.Description = cached.Details.Description
+		response.Channel = cached.Details.Channel
+		response.ViewCount = cached.Details.ViewCount
+		response.Thumbnails = cached.Details.Thumbnails
+	} else {
+		err = s.fetchSongDetails(song, response)
+		if err != nil {
+			log.Printf("Failed to fetch details for song %d: %v", song.SongId, err)
+			response.Err.Message = "Failed to fetch song details."
+			return response, nil
+		}
+
+		s.dbFor(con).AddSongDetails(song.Service, song.ServiceId, response)
+	}
+
+	// only the host may see who submitted an anonymous song
+	response.Song = song
+	if s.policy.callerRole(con) < roleAdmin {
+		response.Song = publicSong(song)
+	}
+
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Returns the songs with the ids, looking in each zone's queue before the
+ * history so queued songs come back with their votes. Each song is returned
+ * once, in the order its id was first asked for.
+ */
+func (s *BackendServer) GetSongs(con context.Context, request *bepb.SongIdList) (*bepb.SongBatch, error) {
+	found := make(map[uint32]*cmpb.Song)
+	unqueued := make([]uint32, 0)
+	for _, songId := range request.GetSongIds() {
+		if _, seen := found[songId]; seen {
+			continue
+		}
+
+		for _, zone := range s.zones.list() {
+			if song := zone.queueMgr.FindSong(songId); song != nil {
+				found[songId] = proto.Clone(song).(*cmpb.Song)
+				break
+			}
+		}
+
+		if found[songId] == nil {
+			unqueued = append(unqueued, songId)
+			found[songId] = nil
+		}
+	}
+
+	played, err := s.dbFor(con).GetSongsByIds(unqueued)
+	if err != nil {
+		return &bepb.SongBatch{Err: &bepb.Error{Success: false, Message: "Failed to look up the songs."}}, nil
+	}
+
+	for _, song := range played {
+		found[song.SongId] = song
+	}
+
+	response := &bepb.SongBatch{Err: &bepb.Error{Success: true, Message: "Success"}}
+	for _, songId := range request.GetSongIds() {
+		song, pending := found[songId]
+		if !pending {
+			continue
+		}
+
+		if song == nil {
+			response.Missing = append(response.Missing, songId)
+		} else {
+			hideSubmitter(song)
+			response.Songs = append(response.Songs, song)
+		}
+		delete(found, songId)
+	}
+
+	return response, nil
+}
+
+/*
+ * Creates a new player zone. Zone names should be unique.
+ */
+func (s *BackendServer) CreateZone(con context.Context, request *bepb.Zone) (*bepb.Zone, error) {
+	response := &bepb.Zone{Err: &bepb.Error{Success: false}}
+
+	if request.GetName() == "" || request.GetName() == defaultZoneName {
+		response.Err.Message = "Invalid zone name."
+		return response, nil
+	}
+
+	if _, exists := s.zones.getByName(request.GetName()); exists {
+		response.Err.Message = "Zone already exists."
+		return response, nil
+	}
+
+	zoneData, err := s.dbFor(con).AddZone(request.GetName(), request.GetShared())
+	if err != nil {
+		log.Printf("Failed to create a new zone: {name: %s, error: %v}", request.GetName(), err)
+		response.Err.Message = "Failed to create zone."
+		return response, nil
+	}
+
+	zone := s.zones.add(zoneData.Zone.Id, zoneData.Zone.Name, zoneData.Zone.Shared)
+	return zone.toProto(), nil
+}
+
+/*
+ * Removes a player zone. Zones with connected players can't be removed.
+ */
+func (s *BackendServer) RemoveZone(con context.Context, request *bepb.Zone) (*bepb.Error, error) {
+	if err := s.zones.remove(request.GetId()); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
+	if err := s.dbFor(con).RemoveZone(request.GetId()); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to remove zone."}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists all of the player zones with their now playing songs
+ */
+func (s *BackendServer) ListZones(con context.Context, empty *cmpb.Empty) (*bepb.ZoneList, error) {
+	response := new(bepb.ZoneList)
+
+	for _, zone := range s.zones.list() {
+		response.Zones = append(response.Zones, zone.toProto())
+	}
+
+	return response, nil
+}
+
+/*
+ * Returns the songs queued up for the given zone
+ */
+func (s *BackendServer) GetZonePlaylist(con context.Context, request *bepb.Zone) (*bepb.Playlist, error) {
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		return &bepb.Playlist{}, nil
+	}
+
+	return publicPlaylist(zone.queueMgr.GetPlaylist()), nil
+}
+
+/*
+ * Returns a zone's playlist unless it's still at the generation the client
+ * has, in which case only the generation is sent back
+ */
+func (s *BackendServer) GetPlaylistIfChanged(con context.Context, request *bepb.PlaylistRequest) (*bepb.Playlist, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Playlist{}, nil
+	}
+
+	playlist := zone.queueMgr.GetPlaylist()
+	if since := request.GetSinceGeneration(); since != 0 && since == playlist.Generation {
+		return &bepb.Playlist{Generation: playlist.Generation, NotModified: true}, nil
+	}
+
+	return publicPlaylist(playlist), nil
+}
+
+/*
+ * Returns statistics about the songs submitted to the backend
+ */
+func (s *BackendServer) GetStats(con context.Context, empty *cmpb.Empty) (*bepb.Stats, error) {
+	response := new(bepb.Stats)
+
+	counts, err := s.dbFor(con).GetSourceCounts()
+	if err != nil {
+		log.Printf("Failed to get submission source counts: %v", err)
+		return response, nil
+	}
+
+	for source := range cmpb.SubmissionSource_name {
+		count := counts[cmpb.SubmissionSource(source)]
+		response.TotalSongs += count
+		response.Sources = append(response.Sources, &bepb.SourceCount{
+			Source: cmpb.SubmissionSource(source),
+			Count:  count,
+		})
+	}
+
+	sort.Slice(response.Sources, func(i, j int) bool {
+		return response.Sources[i].Source < response.Sources[j].Source
+	})
+
+	outcomes, err := s.dbFor(con).GetOutcomeCounts(0)
+	if err != nil {
+		log.Printf("Failed to get song outcome counts: %v", err)
+		return response, nil
+	}
+	response.Outcomes = outcomeCounts(outcomes)
+
+	highlights, err := s.dbFor(con).GetTopReactions()
+	if err != nil {
+		log.Printf("Failed to get top reactions: %v", err)
+		return response, nil
+	}
+
+	for _, highlight := range highlights {
+		song := highlight.Song
+		hideSubmitter(&song)
+		response.TopReactions = append(response.TopReactions, &bepb.ReactionHighlight{
+			Emoji: highlight.Emoji,
+			Song:  &song,
+			Count: highlight.Reactions,
+		})
+	}
+
+	return response, nil
+}
+
+/*
+ * Reacts to the song playing in a zone. A user can react to a song with each
+ * emoji once.
+ */
+func (s *BackendServer) React(con context.Context, reaction *bepb.Reaction) (*bepb.Error, error) {
+	username, _ := s.getUserFromId(reaction.GetUserId())
+	if username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	zone, exists := s.zones.get(reaction.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	s.touchUser(reaction.GetUserId())
+
+	song := zone.queueMgr.NowPlaying()
+	if song == nil {
+		return &bepb.Error{Success: false, Message: "Nothing is playing."}, nil
+	}
+
+	added, err := s.dbFor(con).AddReaction(song.SongId, reaction.GetUserId(), reaction.GetEmoji())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save reaction."}, nil
+	}
+
+	if !added {
+		return &bepb.Error{Success: false, Message: "Already reacted with " + reaction.GetEmoji() + "."}, nil
+	}
+
+	counts, err := s.dbFor(con).GetReactionCounts(song.SongId)
+	if err != nil {
+		log.Printf("Failed to count reactions to song %d: %v", song.SongId, err)
+	}
+
+	s.bus.publish(&bepb.Event{
+		Type:      bepb.EventType_SongReaction,
+		ZoneId:    zone.id,
+		Song:      song,
+		Username:  username,
+		Emoji:     reaction.GetEmoji(),
+		Reactions: counts,
+	})
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Streams events from the server until the client goes away or the server
+ * shuts down, starting with the events missed since the one the client
+ * resumes after
+ */
+func (s *BackendServer) Events(request *bepb.EventsRequest, stream bepb.YtbBackend_EventsServer) error {
+	if !s.admitStream() {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	defer s.streamWG.Done()
+
+	events, missed := s.events.resume(request.GetResumeAfter())
+	defer s.events.unsubscribe(events)
+
+	client := s.clients.connect(stream.Context(), bepb.ClientKind_EventClient, s.policy.describeCaller(stream.Context()))
+	defer s.clients.disconnect(client)
+
+	for _, event := range missed {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			s.clients.touch(client)
+
+		case <-stream.Context().Done():
+			return nil
+
+		case <-s.shutdown.Done():
+			return nil
+		}
+	}
+}
+
+/*
+ * Returns how far along the song playing in a zone is
+ */
+func (s *BackendServer) GetPlaybackPosition(con context.Context, request *bepb.Zone) (*bepb.PlaybackPosition, error) {
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		return &bepb.PlaybackPosition{Err: &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}}, nil
+	}
+
+	now := time.Now()
+	song, startedAt := zone.queueMgr.NowPlayingSince()
+	if song == nil {
+		return &bepb.PlaybackPosition{
+			ServerTime: now.UnixNano() / int64(time.Millisecond),
+			Err:        &bepb.Error{Success: true, Message: "Nothing is playing."},
+		}, nil
+	}
+
+	position := playbackPosition(song, startedAt, zone.playerMgr.lastPosition(), now)
+	position.Err = &bepb.Error{Success: true, Message: "Success"}
+	return position, nil
+}
+
+/*
+ * Returns the lyrics of the song playing in a zone. Lyrics are looked up from
+ * the provider the first time they're asked for and then served out of the
+ * database.
+ */
+func (s *BackendServer) GetLyrics(con context.Context, request *bepb.Zone) (*bepb.Lyrics, error) {
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		return &bepb.Lyrics{Err: &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}}, nil
+	}
+
+	song := zone.queueMgr.NowPlaying()
+	if song == nil {
+		return &bepb.Lyrics{Err: &bepb.Error{Success: false, Message: "Nothing is playing."}}, nil
+	}
+
+	lyrics, err := s.lyrics.find(song)
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, ErrLyricsDisabled) && !errors.Is(err, ErrLyricsNotFound) {
+			message = "Failed to fetch lyrics."
+		}
+		return &bepb.Lyrics{SongId: song.SongId, Err: &bepb.Error{Success: false, Message: message}}, nil
+	}
+
+	response := proto.Clone(lyrics).(*bepb.Lyrics)
+	response.SongId = song.SongId
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
+
+/*
+ * Returns true if the user is exempt from the submission limits. Users are
+ * held to the limits if the exemption can't be looked up.
+ */
+func (s *BackendServer) isExempt(userId uint32) bool {
+	exempt, err := s.dbManager.IsUserExempt(userId)
+	if err != nil {
+		log.Printf("Failed to look up exemption of user %d: %v", userId, err)
+		return false
+	}
+
+	return exempt
+}
+
+/*
+ * Exempts a user from the submission limits or takes the exemption away
+ */
+func (s *BackendServer) SetExemption(con context.Context, request *bepb.Exemption) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	if err := s.dbFor(con).SetUserExempt(request.GetUserId(), request.GetExempt()); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save exemption."}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the users exempt from the submission limits
+ */
+func (s *BackendServer) ListExemptions(con context.Context, empty *cmpb.Empty) (*bepb.ExemptionList, error) {
+	response := &bepb.ExemptionList{Err: &bepb.Error{Success: false}}
+
+	users, err := s.dbFor(con).GetExemptUsers()
+	if err != nil {
+		response.Err.Message = "Failed to get exemptions."
+		return response, nil
+	}
+
+	for _, userData := range users {
+		response.Exemptions = append(response.Exemptions, &bepb.Exemption{
+			UserId:   userData.User.UserId,
+			Exempt:   true,
+			Username: userData.User.Username,
+		})
+	}
+
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Saves the queue settings of a recurring event as a preset
+ */
+func (s *BackendServer) SavePreset(con context.Context, preset *bepb.Preset) (*bepb.Error, error) {
+	preset.FallbackCode = strings.ToUpper(strings.TrimSpace(preset.FallbackCode))
+	if preset.FallbackCode != "" {
+		if _, err := s.dbFor(con).GetSharedPlaylist(preset.FallbackCode); err != nil {
+			return &bepb.Error{Success: false, Message: "Fallback playlist does not exist."}, nil
+		}
+	}
+
+	if err := s.dbFor(con).SavePreset(preset); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save preset."}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the saved presets
+ */
+func (s *BackendServer) ListPresets(con context.Context, empty *cmpb.Empty) (*bepb.PresetList, error) {
+	response := &bepb.PresetList{Err: &bepb.Error{Success: false}}
+
+	presets, err := s.dbFor(con).GetPresets()
+	if err != nil {
+		response.Err.Message = "Failed to get presets."
+		return response, nil
+	}
+
+	response.Presets = presets
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Applies the settings of a saved preset to the queue
+ */
+func (s *BackendServer) ApplyPreset(con context.Context, request *bepb.PresetRequest) (*bepb.Error, error) {
+	preset, err := s.dbFor(con).GetPreset(request.GetName())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Preset does not exist."}, nil
+	}
+
+	if err = s.applyPreset(preset); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Keeps a user active while a client is open
+ */
+func (s *BackendServer) Heartbeat(con context.Context, user *bepb.User) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(user.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	s.touchUser(user.GetUserId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the users who did something recently, most recently seen first
+ */
+func (s *BackendServer) ActiveUsers(con context.Context, empty *cmpb.Empty) (*bepb.ActiveUserList, error) {
+	response := &bepb.ActiveUserList{Err: &bepb.Error{Success: true}}
+
+	for _, user := range s.activity.active(time.Now()) {
+		username, _ := s.getUserFromId(user.userId)
+		response.Users = append(response.Users, &bepb.ActiveUser{
+			UserId:   user.userId,
+			Username: username,
+			LastSeen: user.lastSeen.Unix(),
+		})
+	}
+
+	return response, nil
+}
+
+/*
+ * Votes to skip the song playing in a zone. The song is skipped once enough of
+ * the active users voted for it.
+ */
+func (s *BackendServer) VoteSkip(con context.Context, request *bepb.SkipVote) (*bepb.SkipVoteResult, error) {
+	response := &bepb.SkipVoteResult{Err: &bepb.Error{Success: false}}
+
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	song := zone.queueMgr.NowPlaying()
+	if song == nil {
+		response.Err.Message = "Nothing is playing."
+		return response, nil
+	}
+
+	if song.Jingle {
+		response.Err.Message = "Jingles can't be voted off."
+		return response, nil
+	}
+
+	s.touchUser(request.GetUserId())
+	needed := s.skipVotes.needed(s.activity.count(time.Now()))
+	votes, voters := s.skipVotes.vote(zone.id, song.SongId, request.GetUserId(), needed)
+	passed := voters != nil
+	if passed {
+		log.Printf("Skipping song %d in zone %d with %d of %d votes", song.SongId, zone.id, votes, needed)
+		s.skipNowPlaying(zone, &bepb.Event{Outcome: bepb.SongOutcome_SkippedByVote, Voters: voters})
+	}
+
+	response.Votes = uint32(votes)
+	response.Needed = uint32(needed)
+	response.Skipped = passed
+	response.Err.Success = true
+	response.Err.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Returns the achievements a user has earned
+ */
+func (s *BackendServer) GetAchievements(con context.Context, request *bepb.AchievementRequest) (*bepb.AchievementList, error) {
+	response := &bepb.AchievementList{Err: &bepb.Error{Success: false}}
+
+	achievements, err := s.achievements.list(request.GetUserId())
+	if err != nil {
+		log.Printf("Failed to get achievements of user %d: %v", request.GetUserId(), err)
+		response.Err.Message = "Failed to get achievements."
+		return response, nil
+	}
+
+	response.Achievements = achievements
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Ranks the users by the number of songs they submitted
+ */
+func (s *BackendServer) Leaderboard(con context.Context, request *bepb.LeaderboardRequest) (*bepb.LeaderboardList, error) {
+	response := &bepb.LeaderboardList{Err: &bepb.Error{Success: false}}
+
+	entries, err := s.achievements.leaderboard(int(request.GetLimit()))
+	if err != nil {
+		log.Printf("Failed to build the leaderboard: %v", err)
+		response.Err.Message = "Failed to build the leaderboard."
+		return response, nil
+	}
+
+	response.Entries = entries
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Runs database maintenance right away
+ */
+func (s *BackendServer) RunMaintenance(con context.Context, empty *cmpb.Empty) (*bepb.MaintenanceReport, error) {
+	response := &bepb.MaintenanceReport{Err: &bepb.Error{Success: false}}
+
+	pruned, err := s.maintainer.run()
+	response.PrunedSongs = uint32(pruned)
+	if err != nil {
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	response.Err.Success = true
+	response.Err.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Saves a copy of the zone's queue, or just the user's songs in it, under a
+ * new share code
+ */
+func (s *BackendServer) SharePlaylist(con context.Context, request *bepb.ShareRequest) (*bepb.ShareCode, error) {
+	response := &bepb.ShareCode{Err: &bepb.Error{Success: false}}
+
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		response.Err.Message = "Playlist shared by unknown user."
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	songs := make([]*cmpb.Song, 0)
+	for _, song := range zone.queueMgr.GetPlaylist().Songs {
+		if !request.GetOnlyUserSongs() || song.UserId == request.GetUserId() {
+			songs = append(songs, song)
+		}
+	}
+
+	if len(songs) == 0 {
+		response.Err.Message = "There are no songs to share."
+		return response, nil
+	}
+
+	// retry a few times in case the random code is already taken
+	for attempt := 0; attempt < maxShareCodeAttempts; attempt++ {
+		code, err := generateShareCode()
+		if err != nil {
+			log.Printf("Failed to generate share code: %v", err)
+			break
+		}
+
+		if err = s.dbFor(con).AddSharedPlaylist(code, request.GetUserId(), songs); err == nil {
+			response.Code = code
+			response.SongCount = uint32(len(songs))
+			response.Err.Success = true
+			response.Err.Message = "Success"
+			return response, nil
+		}
+	}
+
+	response.Err.Message = "Failed to share playlist."
+	return response, nil
+}
+
+/*
+ * Queues up the songs shared under the given code on behalf of the importing
+ * user
+ */
+func (s *BackendServer) ImportShared(con context.Context, request *bepb.ImportRequest) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+
+	username, roomId := s.getUserFromId(request.GetUserId())
+	if username == "" {
+		response.Message = "Playlist imported by unknown user."
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		response.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	if message := s.timedOutMessage(request.GetUserId()); message != "" {
+		response.Message = message
+		return response, nil
+	}
+
+	if message := s.decksMessage(zone.id, request.GetUserId()); message != "" {
+		response.Message = message
+		return response, nil
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(request.GetCode()))
+	songs, err := s.dbFor(con).GetSharedPlaylist(code)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.Message = "Share code does not exist."
+		return response, nil
+	} else if err != nil {
+		log.Printf("Failed to get shared playlist %s: %v", code, err)
+		response.Message = "Failed to import playlist."
+		return response, nil
+	}
+
+	// record the whole playlist or none of it before queueing anything
+	err = s.dbFor(con).WithTx(func(tx db.DbManager) error {
+		for _, song := range songs {
+			song.UserId = request.GetUserId()
+			song.Username = username
+			song.RoomId = roomId
+			if err := tx.AddSong(song); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to record shared playlist %s: %v", code, err)
+		response.Message = "Failed to import playlist."
+		return response, nil
+	}
+
+	for _, song := range songs {
+		s.enqueueSong(zone, song)
+	}
+
+	log.Printf("Imported shared playlist: {code: %s, user: %d, songs: %d}", code, request.GetUserId(), len(songs))
+	response.Success = true
+	response.Message = fmt.Sprintf("Queued %d songs.", len(songs))
+	return response, nil
+}
+
+/*
+ * Lists the audio output devices reported by the players in a zone
+ */
+func (s *BackendServer) ListOutputDevices(con context.Context, request *bepb.Zone) (*bepb.DeviceList, error) {
+	response := &bepb.DeviceList{Err: &bepb.Error{Success: false}}
+
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	response.Players = zone.playerMgr.listDevices()
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Forwards the audio output device selection to a player or to every player
+ * in a zone
+ */
+func (s *BackendServer) SetOutputDevice(con context.Context, request *bepb.OutputDeviceRequest) (*bepb.Error, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if request.GetDevice() == "" {
+		return &bepb.Error{Success: false, Message: "Missing output device."}, nil
+	}
+
+	control := &bepb.PlayerControl{
+		Command:      bepb.CommandType_SetOutputDevice,
+		OutputDevice: request.GetDevice(),
+	}
+
+	if response := s.sendToZonePlayer(zone, request.GetPlayerId(), control); !response.Success {
+		return response, nil
+	}
+
+	log.Printf("Set output device: {zone: %d, player: %d, device: %s}",
+		request.GetZoneId(), request.GetPlayerId(), request.GetDevice())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Lists the bluetooth speakers last reported by the players in a zone
+ */
+func (s *BackendServer) ListBluetoothDevices(con context.Context, request *bepb.Zone) (*bepb.BluetoothList, error) {
+	response := &bepb.BluetoothList{Err: &bepb.Error{Success: false}}
+
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	response.Players = zone.playerMgr.listBluetooth()
+	response.Err.Success = true
+	return response, nil
+}
+
+/*
+ * Asks the players to scan for nearby bluetooth speakers. The players report
+ * what they found once the scan completes.
+ */
+func (s *BackendServer) ScanBluetooth(con context.Context, request *bepb.BluetoothRequest) (*bepb.Error, error) {
+	return s.sendBluetoothCommand(request, bepb.CommandType_BluetoothScan), nil
+}
+
+/*
+ * Asks a player to pair with a bluetooth speaker
+ */
+func (s *BackendServer) PairBluetooth(con context.Context, request *bepb.BluetoothRequest) (*bepb.Error, error) {
+	if request.GetAddress() == "" {
+		return &bepb.Error{Success: false, Message: "Missing bluetooth address."}, nil
+	}
+
+	return s.sendBluetoothCommand(request, bepb.CommandType_BluetoothPair), nil
+}
+
+/*
+ * Asks a player to connect to a paired bluetooth speaker
+ */
+func (s *BackendServer) ConnectBluetooth(con context.Context, request *bepb.BluetoothRequest) (*bepb.Error, error) {
+	if request.GetAddress() == "" {
+		return &bepb.Error{Success: false, Message: "Missing bluetooth address."}, nil
+	}
+
+	return s.sendBluetoothCommand(request, bepb.CommandType_BluetoothConnect), nil
+}
+
+/*
+ * Forwards a bluetooth command to the targeted players of a zone
+ */
+func (s *BackendServer) sendBluetoothCommand(request *bepb.BluetoothRequest, command bepb.CommandType) *bepb.Error {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}
+	}
+
+	control := &bepb.PlayerControl{
+		Command:          command,
+		BluetoothAddress: request.GetAddress(),
+	}
+
+	response := s.sendToZonePlayer(zone, request.GetPlayerId(), control)
+	if response.Success {
+		log.Printf("Sent bluetooth command: {command: %v, zone: %d, player: %d, address: %s}",
+			command, request.GetZoneId(), request.GetPlayerId(), request.GetAddress())
+	}
+
+	return response
+}
+
+/*
+ * Sends a command to one player in a zone. A player id of zero sends the
+ * command to every player in the zone.
+ */
+func (s *BackendServer) sendToZonePlayer(zone *zone, playerId uint32, control *bepb.PlayerControl) *bepb.Error {
+	if playerId == 0 {
+		zone.playerMgr.sendToPlayers(control)
+	} else if !zone.playerMgr.sendToPlayer(int(playerId), control) {
+		return &bepb.Error{Success: false, Message: "Player is not connected."}
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}
+}
+
+/*
+ * Handshake from a backend that wants to follow this one
+ */
+func (s *BackendServer) Federate(con context.Context, hello *bepb.FederationHello) (*bepb.FederationAck, error) {
+	response := &bepb.FederationAck{Name: s.federation.name, Err: &bepb.Error{Success: false}}
+
+	if err := s.federation.accept(hello); err != nil {
+		log.Printf("Refused federation with %s: %v", hello.GetName(), err)
+		response.Err.Message = err.Error()
+		return response, nil
+	}
+
+	response.Err.Success = true
+	response.Err.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Queue a song forwarded from a backend that follows this one
+ */
+func (s *BackendServer) ForwardSong(con context.Context, forwarded *bepb.FederatedSong) (*bepb.Error, error) {
+	response := &bepb.Error{Success: false}
+
+	song := forwarded.GetSong()
+	if song.GetServiceId() == "" {
+		response.Message = "Missing song."
+		return response, nil
+	}
+
+	if isQueued(s.queueMgr, song) {
+		response.Message = fmt.Sprintf("That song is already queued on %s.", s.federation.name)
+		return response, nil
+	}
+
+	if s.isBlocked(song) {
+		response.Message = ErrSongBlocked.Error()
+		return response, nil
+	}
+
+	if err := s.federation.resolve(forwarded.GetOrigin(), song); err != nil {
+		log.Printf("Failed to take song forwarded from %s: %v", forwarded.GetOrigin(), err)
+		response.Message = err.Error()
+		return response, nil
+	}
+
+	zone, _ := s.zones.get(defaultZoneId)
+	if err := s.queueSong(zone, song); err != nil {
+		response.Message = ErrSongNotRecorded.Error()
+		return response, nil
+	}
+	log.Printf("Queued song forwarded from %s: { %v}", forwarded.GetOrigin(), song)
+
+	response.Success = true
+	response.Message = "Success"
+	return response, nil
+}
+
+/*
+ * Set up the federation hub and, if configured, the link to the backend this
+ * one follows
+ */
+func (s *BackendServer) initFederation(config *ServerConfig) {
+	name := config.FederationName
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	following := config.FederationPeer != ""
+	if following {
+		link := new(federationLink)
+		if err := link.init(name, config.FederationPeer, config.FederationMode, config.FederationToken); err != nil {
+			log.Printf("Failed to link to %s: %v", config.FederationPeer, err)
+			following = false
+		} else {
+			s.federationLink = link
+		}
+	}
+
+	s.federation = new(federationHub)
+	s.federation.init(name, s.dbManager, following)
+}
+
+/*
+ * Switch the default zone's players over to a song mirrored from the leader.
+ * An empty song means the leader stopped playing.
+ */
+func (s *BackendServer) mirrorNowPlaying(song *cmpb.Song) {
+	control := &bepb.PlayerControl{Command: bepb.CommandType_Next}
+
+	if song.GetServiceId() == "" {
+		s.queueMgr.ClearNowPlaying()
+		control.Command = bepb.CommandType_Stop
+	} else {
+		s.queueMgr.SetNowPlaying(song)
+		control.Song = song
+		control.LocalPath = s.downloader.lookup(song)
+	}
+
+	log.Printf("Mirroring now playing: { %v}", song)
+	s.playerMgr.sendToPlayers(control)
+}
+
+/*
+ * Restore the zones saved in the database, along with the queues saved for
+ * the zones that keep their own
+ */
+func (s *BackendServer) loadZones() {
+	zones, err := s.dbManager.GetZones()
+	if err != nil {
+		log.Printf("Failed to load zones: %v", err)
+		return
+	}
+
+	for _, zoneData := range zones {
+		zone := s.zones.add(zoneData.Zone.Id, zoneData.Zone.Name, zoneData.Zone.Shared)
+		if !zone.shared {
+			s.loadPlaylistFromFile(zone.queueMgr, zoneQueueSnapshot(zone.id))
+		}
+	}
+}
+
+func isValidDuration(duration period.Period, maxMinutes uint32) bool {
+	return !duration.IsZero() && duration.Minutes() < int(maxMinutes)
+}
+
+/*
+ * Returns a user along with their submission preferences
+ */
+func (s *BackendServer) WhoAmI(con context.Context, user *bepb.User) (*bepb.UserProfile, error) {
+	username, roomId := s.getUserFromId(user.GetUserId())
+	if username == "" {
+		return &bepb.UserProfile{Err: &bepb.Error{Success: false, Message: "User does not exist."}}, nil
+	}
+
+	preferences, err := s.dbFor(con).GetPreferences(user.GetUserId())
+	if err != nil {
+		return &bepb.UserProfile{Err: &bepb.Error{Success: false, Message: "Failed to get preferences."}}, nil
+	}
+
+	identities, err := s.dbFor(con).GetUserIdentities(user.GetUserId())
+	if err != nil {
+		return &bepb.UserProfile{Err: &bepb.Error{Success: false, Message: "Failed to get linked accounts."}}, nil
+	}
+
+	return &bepb.UserProfile{
+		User:        &bepb.User{Username: username, UserId: user.GetUserId(), RoomId: roomId},
+		Preferences: preferences,
+		Identities:  identities,
+		Err:         &bepb.Error{Success: true, Message: "Success"},
+	}, nil
+}
+
+/*
+ * Lowers the volume of a zone's players, such as for an announcement
+ */
+func (s *BackendServer) Duck(con context.Context, request *bepb.DuckRequest) (*bepb.Error, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	duration := time.Duration(request.GetSeconds()) * time.Second
+	if zone.playerMgr.duck(request.GetVolume(), duration) == 0 {
+		return &bepb.Error{Success: false, Message: ErrNoVolumeControl.Error()}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Brings the volume of a zone's players back up after ducking
+ */
+func (s *BackendServer) Unduck(con context.Context, request *bepb.Zone) (*bepb.Error, error) {
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if zone.playerMgr.unduck() == 0 {
+		return &bepb.Error{Success: false, Message: ErrNoVolumeControl.Error()}, nil
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Sets the quality a zone's players play songs at
+ */
+func (s *BackendServer) SetPlaybackQuality(con context.Context, request *bepb.QualityRequest) (*bepb.Error, error) {
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
+	}
+
+	if s.audioOnly && request.GetQuality() != bepb.PlaybackQuality_AudioQuality {
+		return &bepb.Error{Success: false, Message: ErrAudioOnly.Error()}, nil
+	}
+
+	zone.playerMgr.setQuality(request.GetQuality())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Saves the settings a user's submissions get by default
+ */
+func (s *BackendServer) SetPreferences(con context.Context, preferences *bepb.Preferences) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(preferences.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
+
+	if err := s.dbFor(con).SetPreferences(preferences); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save preferences."}, nil
+	}
+
+	log.Printf("Saved preferences: {%v}", preferences)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Gets what front-end screens show for a user's room. Screens nobody is
+ * signed in to, like public displays, get the whole server's settings.
+ */
+func (s *BackendServer) GetServerInfo(con context.Context, user *bepb.User) (*bepb.ServerInfo, error) {
+	var roomId uint32
+	if user.GetUserId() != 0 {
+		var username string
+		if username, roomId = s.getUserFromId(user.GetUserId()); username == "" {
+			return &bepb.ServerInfo{Err: &bepb.Error{Success: false, Message: "User does not exist."}}, nil
+		}
+	}
+
+	display, err := s.dbFor(con).GetDisplaySettings(roomId)
+	if err != nil {
+		return &bepb.ServerInfo{Err: &bepb.Error{Success: false, Message: "Failed to get display settings."}}, nil
+	}
+
+	info := &bepb.ServerInfo{Display: display, AudioOnly: s.audioOnly,
+		Err: &bepb.Error{Success: true, Message: "Success"}}
+	if info.Decks = s.decks.status(); info.Decks != nil {
+		info.Decks.Suggested = uint32(len(s.suggestions.list()))
+	}
+	return info, nil
+}
+
+/*
+ * Saves how front-end screens show a room's party, or every room's that has
+ * no settings of its own for room id zero
+ */
+func (s *BackendServer) SetDisplaySettings(con context.Context, settings *bepb.DisplaySettings) (*bepb.Error, error) {
+	saved, err := s.dbFor(con).SetDisplaySettings(settings)
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save display settings."}, nil
+	} else if !saved {
+		return &bepb.Error{Success: false, Message: "Room does not exist."}, nil
+	}
+
+	log.Printf("Saved display settings: {%v}", settings)
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Gets the recap of a party that ended, or the latest one if no id is given
+ */
+func (s *BackendServer) GetPartyRecap(con context.Context, request *bepb.RecapRequest) (*bepb.PartyRecap, error) {
+	recap, err := s.dbFor(con).GetRecap(request.GetId())
+	if errors.Is(err, sql.ErrNoRows) {
+		return &bepb.PartyRecap{Err: &bepb.Error{Success: false, Message: "Recap does not exist."}}, nil
+	} else if err != nil {
+		return &bepb.PartyRecap{Err: &bepb.Error{Success: false, Message: "Failed to get recap."}}, nil
+	}
 
-	return server
+	recap.Err = &bepb.Error{Success: true, Message: "Success"}
+	return recap, nil
 }
 
 /*
- * Start the server
+ * Ends the current party and returns its recap
  */
-func (s *BackendServer) Serve() {
-	s.playerMgr.start()
-	s.beServer.Serve(s.listener)
+func (s *BackendServer) EndParty(con context.Context, empty *cmpb.Empty) (*bepb.PartyRecap, error) {
+	recap, err := s.recapper.end(time.Now())
+	if errors.Is(err, ErrEmptyParty) {
+		return &bepb.PartyRecap{Err: &bepb.Error{Success: false, Message: err.Error()}}, nil
+	} else if err != nil {
+		return &bepb.PartyRecap{Err: &bepb.Error{Success: false, Message: "Failed to recap the party."}}, nil
+	}
+
+	recap.Err = &bepb.Error{Success: true, Message: "Success"}
+	return recap, nil
 }
 
 /*
- * Stop the server
+ * Lists the long songs waiting for an admin to approve them
  */
-func (s *BackendServer) Stop() {
-	// stop the player manager
-	s.playerMgr.stop()
-
-	// wait for all the rpc streaming connections to close
-	s.streamWG.Wait()
-
-	// stop the rpc server
-	s.beServer.GracefulStop()
+func (s *BackendServer) ListPendingSongs(con context.Context, empty *cmpb.Empty) (*bepb.PendingSongList, error) {
+	return &bepb.PendingSongList{
+		Songs: s.approvals.list(),
+		Err:   &bepb.Error{Success: true, Message: "Success"},
+	}, nil
 }
 
 /*
- * Receive a song from a remote client for appending to the play queue
+ * Queues a long song an admin approved or drops one they turned away
  */
-func (s *BackendServer) SendSong(con context.Context, sub *bepb.Submission) (*bepb.Error, error) {
-	response := &bepb.Error{Success: false}
-	log.Printf("Submission: {link: %s, userId: %d}\n", sub.Link, sub.UserId)
-
-	song := new(cmpb.Song)
-	song.UserId = sub.GetUserId()
-
-	song.Username, song.RoomId = s.getUserFromId(song.UserId)
-	if song.Username == "" {
-		response.Message = "Song submitted by unknown user"
-		log.Printf(response.Message)
-		return response, nil
+func (s *BackendServer) ReviewSong(con context.Context, review *bepb.SongReview) (*bepb.Error, error) {
+	pending, err := s.approvals.take(review.GetId())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
 	}
 
-	err := s.fetcher.fetchSongData(sub.Link, song)
-	if err != nil {
-		response.Message = "Failed to fetch metadata for your song. Please check your link."
-		log.Println(err.Error())
+	response := &bepb.Error{Success: true, Message: "Success", Tier: bepb.LengthTier_LongSong}
+	if !review.GetApprove() {
+		log.Printf("Turned away pending song %d: %s", review.GetId(), pending.song.ServiceId)
 		return response, nil
 	}
 
-	duration, err := period.Parse(song.Metadata.Duration)
-	if err != nil {
-		response.Message = "Got an unexpected response from YouTube."
-		log.Printf("Failed to parse duration %s with error %s\n", song.Metadata.Duration, err.Error())
-		return response, nil
+	zone, exists := s.zones.get(pending.zoneId)
+	if !exists {
+		return &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}, nil
 	}
 
-	if isValidDuration(duration) {
-		response.Success = true
-		response.Message = "Success"
-		s.queueMgr.AddSong(song)
-		s.dbManager.AddSong(song)
-		s.queueMgr.SavePlaylist(queuer.QueueSnapshot)
-		log.Printf("Song data: { %v}", song)
-		return response, nil
-	} else {
-		response.Message = fmt.Sprintf("Please do no submit songs greater than %d minutes.", allowedMinutes)
+	if isQueued(zone.queueMgr, pending.song) {
+		return &bepb.Error{Success: false, Message: "That song is already queued."}, nil
 	}
 
+	if err := s.queueSong(zone, pending.song); err != nil {
+		return &bepb.Error{Success: false, Message: ErrSongNotRecorded.Error()}, nil
+	}
+	log.Printf("Approved pending song %d: { %v}", review.GetId(), pending.song)
 	return response, nil
 }
 
 /*
- * Load a playlist from a serialized protobuf file
+ * Registers a jingle to play between songs
  */
-func (s *BackendServer) loadPlaylistFromFile(file string) {
-	in, err := ioutil.ReadFile(file)
-	if err != nil {
-		log.Printf("Error reading file: %s", file)
-		return
+func (s *BackendServer) AddJingle(con context.Context, jingle *bepb.Jingle) (*bepb.Error, error) {
+	if err := s.jingles.add(jingle); err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save jingle."}, nil
 	}
 
-	playlist := &bepb.Playlist{}
-	err = proto.Unmarshal(in, playlist)
-	if err != nil {
-		log.Printf("Failed to parse playlist file: %v", err)
-		return
-	}
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
 
-	log.Printf("Loading songs from file \"%s\":", file)
-	for index, song := range playlist.Songs {
-		s.queueMgr.AddSong(song)
-		log.Printf("%3d. { %v}", index+1, song)
+/*
+ * Stops playing a jingle
+ */
+func (s *BackendServer) RemoveJingle(con context.Context, jingle *bepb.Jingle) (*bepb.Error, error) {
+	if err := s.jingles.remove(jingle.GetName()); errors.Is(err, ErrJingleNotFound) {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	} else if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to remove jingle."}, nil
 	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
 }
 
 /*
- * Returns the songs in the queue back to the requesting client
+ * Lists the registered jingles in the order they take turns
  */
-func (s *BackendServer) GetPlaylist(con context.Context, arg *cmpb.Empty) (*bepb.Playlist, error) {
-	return s.queueMgr.GetPlaylist(), nil
+func (s *BackendServer) ListJingles(con context.Context, empty *cmpb.Empty) (*bepb.JingleList, error) {
+	return &bepb.JingleList{
+		Jingles: s.jingles.list(),
+		Err:     &bepb.Error{Success: true, Message: "Success"},
+	}, nil
 }
 
 /*
- * Login the given user. If the userId is zero, then a new user needs to be
- * created. A successful call will echo the username and return a userId
- * greater than zero. An id of zero indicates an error occurred and the user
- * will not be considered to be logged in. An a user already exists with the
- * given id, but with a different name, then the new name shall be applied to
- * the database.
+ * Registers a player by name and returns the token it connects with
  */
-func (s *BackendServer) LoginUser(con context.Context, user *bepb.User) (*bepb.User, error) {
-	response := new(bepb.User)
-	response.Err = new(bepb.Error)
-	response.Err.Success = false
-	userData, err := s.dbManager.GetUserById(user.UserId)
-
-	if userData == nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			// if no results were returned, then create a new user
-			userData, err = s.dbManager.AddUser(user.Username, user.RoomId)
-			if err != nil {
-				log.Printf("Failed to add user: %s, to room: %d, err: %s",
-					user.Username, user.RoomId, err.Error())
-				response.Username = user.Username
-				response.Err.Message = "Failed to add new user."
-				return response, nil
-			}
-		} else {
-			response.Username = user.Username
-			response.Err.Message = "Failed to add new user."
-			return response, nil
-		}
-	} else if userData.User.Username != user.Username {
-		// Update the username in the database if the names differ
-		err = s.dbManager.UpdateUsername(user.Username, user.UserId)
-		if err != nil {
-			log.Println("Could not update username")
-			response.Username = user.Username
-			response.Err.Message = "Could not update username."
-			return response, nil
+func (s *BackendServer) RegisterPlayer(con context.Context, player *bepb.RegisteredPlayer) (*bepb.RegisteredPlayer, error) {
+	if player.GetZone() != "" {
+		if _, exists := s.zones.getByName(player.GetZone()); !exists {
+			return &bepb.RegisteredPlayer{Err: &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}}, nil
 		}
 	}
 
-	// cache the user id and username
-	s.userCache.AddUserToCache(userData.User.UserId, user.Username, user.RoomId)
+	registered, err := s.registry.register(player)
+	if err != nil {
+		log.Printf("Failed to register player %s: %v", player.GetName(), err)
+		return &bepb.RegisteredPlayer{Err: &bepb.Error{Success: false, Message: "Failed to register the player."}}, nil
+	}
 
-	response.Username = user.Username
-	response.UserId = userData.User.UserId
-	response.RoomId = userData.User.RoomId
-	response.Err.Success = true
-	return response, nil
+	registered.Err = &bepb.Error{Success: true, Message: "Success"}
+	return registered, nil
 }
 
 /*
- * Pops a song off the top of the queueMgr and returns it
+ * Removes a registered player by name. The player can't connect again once
+ * it disconnects.
  */
-func (s *BackendServer) PopQueue(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
-	if s.queueMgr.Len() > 0 {
-		song := s.queueMgr.PopQueue()
-		log.Printf("Popped song: %v\n", song)
-		return song, nil
+func (s *BackendServer) UnregisterPlayer(con context.Context, player *bepb.RegisteredPlayer) (*bepb.Error, error) {
+	if err := s.registry.unregister(player.GetName()); errors.Is(err, ErrRegisteredPlayerAbsent) {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	} else if err != nil {
+		log.Printf("Failed to unregister player %s: %v", player.GetName(), err)
+		return &bepb.Error{Success: false, Message: "Failed to unregister the player."}, nil
 	}
 
-	log.Println("Queue is empty, nothing to pop")
-	return &cmpb.Song{}, nil
+	log.Printf("Unregistered player %s", player.GetName())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
 }
 
 /*
- * Saves the current playlist to the given file location
+ * Lists the registered players and whether they're connected
  */
-func (s *BackendServer) SavePlaylist(con context.Context, fname *bepb.FilePath) (*bepb.Error, error) {
-	response := &bepb.Error{Success: false}
-	err := s.queueMgr.SavePlaylist(fname.Path)
+func (s *BackendServer) ListRegisteredPlayers(con context.Context, empty *cmpb.Empty) (*bepb.RegisteredPlayerList, error) {
+	players, err := s.registry.list()
 	if err != nil {
-		response.Message = err.Error()
-		return response, nil
+		log.Printf("Failed to list the registered players: %v", err)
+		return &bepb.RegisteredPlayerList{Err: &bepb.Error{Success: false, Message: "Failed to list the registered players."}}, nil
 	}
 
-	log.Printf("Saved current playlist to: %s", fname.Path)
-	response.Success = true
-	response.Message = "Success"
-	return response, nil
+	return &bepb.RegisteredPlayerList{
+		Players: s.connectedRegisteredPlayers(players),
+		Err:     &bepb.Error{Success: true, Message: "Success"},
+	}, nil
 }
 
 /*
- * Returns the username associated with the user id. An empty string is
- * returned if there was an error or the user id wasn't found.
+ * Creates an api key for a bot or other client. The key in the response is
+ * the only copy of it.
  */
-func (s *BackendServer) getUserFromId(userId uint32) (string, uint32) {
-	if userId == 0 {
-		log.Println("User id of zero was passed into getUserFromId")
-		return "", 0
+func (s *BackendServer) CreateApiKey(con context.Context, request *bepb.ApiKey) (*bepb.ApiKey, error) {
+	key, err := s.apiKeys.create(request.GetName(), request.GetScope())
+	if errors.Is(err, ErrApiKeyTaken) {
+		return &bepb.ApiKey{Err: &bepb.Error{Success: false, Message: err.Error()}}, nil
+	} else if err != nil {
+		log.Printf("Failed to create api key %s: %v", request.GetName(), err)
+		return &bepb.ApiKey{Err: &bepb.Error{Success: false, Message: "Failed to create the api key."}}, nil
 	}
 
-	// check the user identities cache for the name
-	username, exists := s.userCache.LookupUsername(userId)
-	if exists {
-		roomId, _ := s.userCache.LookupRoomId(userId)
-		return username, roomId
-	}
+	key.Err = &bepb.Error{Success: true, Message: "Success"}
+	return key, nil
+}
 
-	// retrieve the name from the database if the user isn't in the cache
-	userData, err := s.dbManager.GetUserById(userId)
-	if err != nil {
-		log.Printf("Failed to get username from database with id: %d", userId)
-		return "", 0
+/*
+ * Revokes an api key by name. Clients sending it are turned away from then on.
+ */
+func (s *BackendServer) RevokeApiKey(con context.Context, request *bepb.ApiKey) (*bepb.Error, error) {
+	if err := s.apiKeys.revoke(request.GetName()); errors.Is(err, ErrApiKeyAbsent) {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	} else if err != nil {
+		log.Printf("Failed to revoke api key %s: %v", request.GetName(), err)
+		return &bepb.Error{Success: false, Message: "Failed to revoke the api key."}, nil
 	}
 
-	// Add the username to the cache and return the name we found in the
-	// database
-	s.userCache.AddUserToCache(userId, userData.User.Username, userData.User.RoomId)
-	return userData.User.Username, userData.User.RoomId
+	log.Printf("Revoked api key %s", request.GetName())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
 }
 
 /*
- * Removes the given song from the playlist. The user identified by the song
- * eviction must match the id of the user who submitted the song.
+ * Lists the api keys and their scopes
  */
-func (s *BackendServer) RemoveSong(con context.Context, eviction *bepb.Eviction) (*bepb.Error, error) {
-	err := s.queueMgr.RemoveSong(eviction.GetSongId(), eviction.GetUserId())
-
+func (s *BackendServer) ListApiKeys(con context.Context, empty *cmpb.Empty) (*bepb.ApiKeyList, error) {
+	keys, err := s.apiKeys.list()
 	if err != nil {
-		log.Printf("Failed to remove song from playlist: %v", err)
-		return &bepb.Error{Success: false, Message: err.Error()}, nil
-	} else {
-		log.Printf("Removed song: {song id: %d, user id: %d}", eviction.GetSongId(), eviction.GetUserId())
-		s.queueMgr.SavePlaylist(queuer.QueueSnapshot)
-		return &bepb.Error{Success: true, Message: "Success"}, nil
+		log.Printf("Failed to list the api keys: %v", err)
+		return &bepb.ApiKeyList{Err: &bepb.Error{Success: false, Message: "Failed to list the api keys."}}, nil
 	}
+
+	return &bepb.ApiKeyList{Keys: keys, Err: &bepb.Error{Success: true, Message: "Success"}}, nil
 }
 
 /*
- * Returns the song that should be considered "now playing". If there isn't a
- * current song, then an empty Song struct is returned.
+ * Times a user out. Their queued songs move to the end of the queue and they
+ * can't submit songs until the time-out ends.
  */
-func (s *BackendServer) GetNowPlaying(con context.Context, empty *cmpb.Empty) (*cmpb.Song, error) {
-	nowPlaying := s.queueMgr.NowPlaying()
+func (s *BackendServer) TimeOutUser(con context.Context, request *bepb.TimeOut) (*bepb.Error, error) {
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		return &bepb.Error{Success: false, Message: "User does not exist."}, nil
+	}
 
-	if nowPlaying == nil {
-		return &cmpb.Song{}, nil
+	duration := time.Duration(request.GetSeconds()) * time.Second
+	until := s.timeOuts.give(request.GetUserId(), duration, time.Now())
+
+	for _, zone := range s.zones.list() {
+		zone.queueMgr.DemoteUser(request.GetUserId())
 	}
 
-	return nowPlaying, nil
+	log.Printf("Timed out user %d until %s", request.GetUserId(), until.Format(time.Kitchen))
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_TimeOutStarted, UserId: request.GetUserId(), Until: until.Unix()})
+	return &bepb.Error{Success: true, Message: "Success"}, nil
 }
 
 /*
- * Forwards the command to skip the currently playing song onto the remote
- * player
+ * Ends a user's time-out early
  */
-func (s *BackendServer) NextSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
-	nextSong := s.queueMgr.PopQueue()
-	control := &bepb.PlayerControl{Command: bepb.CommandType_Next, Song: nextSong}
-	s.playerMgr.sendToPlayers(control)
+func (s *BackendServer) EndTimeOut(con context.Context, request *bepb.TimeOut) (*bepb.Error, error) {
+	if err := s.timeOuts.lift(request.GetUserId()); err != nil {
+		return &bepb.Error{Success: false, Message: err.Error()}, nil
+	}
+
 	return &bepb.Error{Success: true, Message: "Success"}, nil
 }
 
 /*
- * Forwards the command to pause the currently playing song onto the remote
- * player
+ * Lists the users who are timed out
  */
-func (s *BackendServer) PauseSong(con context.Context, empty *cmpb.Empty) (*bepb.Error, error) {
-	s.playerMgr.sendToPlayers(&bepb.PlayerControl{Command: bepb.CommandType_Pause})
-	return &bepb.Error{Success: true, Message: "Success"}, nil
+func (s *BackendServer) ListTimeOuts(con context.Context, empty *cmpb.Empty) (*bepb.TimeOutList, error) {
+	timeOuts := s.timeOuts.list()
+	for _, timeOut := range timeOuts {
+		timeOut.Username, _ = s.getUserFromId(timeOut.UserId)
+	}
+
+	return &bepb.TimeOutList{TimeOuts: timeOuts, Err: &bepb.Error{Success: true, Message: "Success"}}, nil
 }
 
 /*
- * Stream RPC connection with the remote player client
+ * Lets a user whose time-out ended queue songs as usual again and tells the
+ * clients the time-out is over
  */
-func (s *BackendServer) SongPlayer(stream bepb.YtbBePlayer_SongPlayerServer) error {
-	s.streamWG.Add(1)
-	defer s.streamWG.Done()
-	id, stop := s.playerMgr.add(stream)
-
-	go func() {
-		for {
-			status, err := stream.Recv()
-			if err == io.EOF {
-				log.Printf("Disconnected from remote player")
-				break
-			}
+func (s *BackendServer) endTimeOut(userId uint32) {
+	for _, zone := range s.zones.list() {
+		zone.queueMgr.RestoreUser(userId)
+	}
 
-			if grpc.Code(err) == codes.Canceled {
-				break
-			}
+	log.Printf("Time-out of user %d ended", userId)
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_TimeOutEnded, UserId: userId})
+}
 
-			if err != nil {
-				log.Printf("Error receiving message from remote player: %v", err)
-				break
-			}
+/*
+ * Returns the message turning away a timed out user's submissions, or an
+ * empty string if the user isn't timed out
+ */
+func (s *BackendServer) timedOutMessage(userId uint32) string {
+	until, timedOut := s.timeOuts.active(userId, time.Now())
+	if !timedOut {
+		return ""
+	}
 
-			// write the received status to the player manager
-			s.playerMgr.receiveFromPlayers(id, status)
-		}
-		stop <- struct{}{}
-	}()
+	return fmt.Sprintf("You're timed out until %s.", until.Format(time.Kitchen))
+}
 
-	<-stop
-	if s.playerMgr.remove(id) == 0 {
-		s.queueMgr.ClearNowPlaying()
+/*
+ * Reports how fairly a zone's queue is treating its users
+ */
+func (s *BackendServer) GetFairnessReport(con context.Context, request *bepb.Zone) (*bepb.FairnessReport, error) {
+	zone, exists := s.zones.get(request.GetId())
+	if !exists {
+		return &bepb.FairnessReport{Err: &bepb.Error{Success: false, Message: ErrZoneNotFound.Error()}}, nil
 	}
-	return nil
+
+	report := s.fairnessReport(zone, time.Now())
+	report.Err = &bepb.Error{Success: true, Message: "Success"}
+	return report, nil
 }
 
 /*
- * Handles command to create a new room. Room names should be unique. Will
- * return an error if the room already exists.
+ * Asks for a user's queued song to play next. The request is sent to the
+ * clients streaming events so the other active users can approve it.
  */
-func (s *BackendServer) CreateRoom(con context.Context, room *bepb.Room) (*bepb.Room, error) {
-	response := new(bepb.Room)
-	response.Err = new(bepb.Error)
-	response.Err.Success = false
-	roomData, err := s.dbManager.GetRoomByName(room.Name)
+func (s *BackendServer) RequestPlayNext(con context.Context, request *bepb.PlayNext) (*bepb.PlayNextResult, error) {
+	response := &bepb.PlayNextResult{Err: &bepb.Error{Success: false}}
 
-	// room doesn't exist so create it
-	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
-		roomData, err = s.dbManager.AddRoom(room.Name)
+	username, _ := s.getUserFromId(request.GetUserId())
+	if username == "" {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
 
-		if err != nil {
-			log.Printf("Failed to create a new room: {name: %s, error: %v}", room.Name, err)
-			response.Err.Message = "Failed to create room."
+	if message := s.timedOutMessage(request.GetUserId()); message != "" {
+		response.Err.Message = message
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	_, songs := zone.queueMgr.Snapshot()
+	var song *cmpb.Song
+	for i, queued := range songs {
+		if queued.SongId != request.GetSongId() {
+			continue
+		} else if queued.UserId != request.GetUserId() {
+			response.Err.Message = "You can only ask for your own songs to play next."
+			return response, nil
+		} else if i == 0 {
+			response.Err.Message = "Your song is already next."
 			return response, nil
 		}
+		song = queued
+	}
 
-		response.Name = roomData.Room.Name
-		response.Id = roomData.Room.Id
-		response.Err.Success = true
+	if song == nil {
+		response.Err.Message = "Song is not in the queue."
 		return response, nil
 	}
 
-	response.Err.Message = "Room already exists."
+	now := time.Now()
+	s.touchUser(request.GetUserId())
+	s.playNext.ask(zone.id, song.SongId, request.GetUserId(), now)
+	s.bus.publish(&bepb.Event{Type: bepb.EventType_PlayNextAsked, ZoneId: zone.id, Song: song,
+		UserId: request.GetUserId(), Username: username})
+	log.Printf("%s asked for song %d to play next in zone %d", username, song.SongId, zone.id)
+
+	response.Needed = uint32(s.playNext.needed(s.activity.count(now)))
+	response.Err.Success = true
+	response.Err.Message = "Success"
 	return response, nil
 }
 
 /*
- * Handles querying of a room for its existence.
+ * Approves another user's request to play their song next. The song moves to
+ * the front of the queue once enough of the other active users approved.
  */
-func (s *BackendServer) GetRoom(con context.Context, room *bepb.Room) (*bepb.Room, error) {
-	response := new(bepb.Room)
-	response.Err = new(bepb.Error)
-	response.Err.Success = false
-	roomData, err := s.dbManager.GetRoomByName(room.Name)
+func (s *BackendServer) ApprovePlayNext(con context.Context, request *bepb.PlayNext) (*bepb.PlayNextResult, error) {
+	response := &bepb.PlayNextResult{Err: &bepb.Error{Success: false}}
 
-	if roomData == nil && errors.Is(err, sql.ErrNoRows) {
-		response.Err.Message = "Room does not exist."
-	} else if err != nil {
+	if username, _ := s.getUserFromId(request.GetUserId()); username == "" {
+		response.Err.Message = "User does not exist."
+		return response, nil
+	}
+
+	zone, exists := s.zones.get(request.GetZoneId())
+	if !exists {
+		response.Err.Message = ErrZoneNotFound.Error()
+		return response, nil
+	}
+
+	now := time.Now()
+	s.touchUser(request.GetUserId())
+	needed := s.playNext.needed(s.activity.count(now))
+	approvals, passed, err := s.playNext.approve(zone.id, request.GetSongId(), request.GetUserId(), needed, now)
+	if err != nil {
 		response.Err.Message = err.Error()
-	} else {
-		response.Name = roomData.Room.Name
-		response.Id = roomData.Room.Id
-		response.Err.Success = true
+		return response, nil
 	}
 
-	return response, nil
-}
+	if passed {
+		if err = zone.queueMgr.PromoteSong(request.GetSongId()); err != nil {
+			response.Err.Message = "Song is not in the queue."
+			return response, nil
+		}
 
-func isValidDuration(duration period.Period) bool {
-	return !duration.IsZero() && duration.Minutes() < allowedMinutes
+		log.Printf("Moved song %d to play next in zone %d with %d of %d approvals", request.GetSongId(), zone.id,
+			approvals, needed)
+		s.bus.publish(&bepb.Event{Type: bepb.EventType_PlayNextMoved, ZoneId: zone.id,
+			Song: zone.queueMgr.FindSong(request.GetSongId())})
+	}
+
+	response.Approvals = uint32(approvals)
+	response.Needed = uint32(needed)
+	response.Moved = passed
+	response.Err.Success = true
+	response.Err.Message = "Success"
+	return response, nil
 }
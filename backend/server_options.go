@@ -0,0 +1,84 @@
+/*
+ * Builds the gRPC server options from the server config. Players hold a
+ * SongPlayer stream open for the whole night, often over Wi-Fi and behind a
+ * NAT, so the server pings idle connections to keep them from being dropped
+ * silently.
+ */
+
+package backend
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultKeepaliveTime    = 30 * time.Second // idle time before pinging a client
+	defaultKeepaliveTimeout = 10 * time.Second // wait for a ping response
+	defaultKeepaliveMinTime = 10 * time.Second // shortest ping interval allowed from clients
+	defaultMaxMsgSize       = 4 * 1024 * 1024  // grpc's own default message size limit
+)
+
+/*
+ * Returns the options used to create the gRPC server
+ */
+func serverOptions(config *ServerConfig, policy *accessPolicy, s *BackendServer) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.panicInterceptor, debugInterceptor, policy.unaryInterceptor, sourceInterceptor,
+			validationInterceptor, deadlineInterceptor),
+		grpc.ChainStreamInterceptor(s.panicStreamInterceptor, policy.streamInterceptor),
+		grpc.KeepaliveParams(keepaliveParams(config)),
+		grpc.KeepaliveEnforcementPolicy(keepalivePolicy(config)),
+		grpc.MaxRecvMsgSize(orDefaultSize(config.MaxRecvMsgSize, defaultMaxMsgSize)),
+		grpc.MaxSendMsgSize(orDefaultSize(config.MaxSendMsgSize, defaultMaxMsgSize)),
+	}
+}
+
+/*
+ * Returns how the server pings clients and ages out connections. A zero idle
+ * or age limit leaves grpc's default of never closing the connection.
+ */
+func keepaliveParams(config *ServerConfig) keepalive.ServerParameters {
+	params := keepalive.ServerParameters{
+		Time:    orDefaultDuration(config.KeepaliveTime, defaultKeepaliveTime),
+		Timeout: orDefaultDuration(config.KeepaliveTimeout, defaultKeepaliveTimeout),
+	}
+
+	if config.MaxConnectionIdle > 0 {
+		params.MaxConnectionIdle = config.MaxConnectionIdle
+	}
+
+	if config.MaxConnectionAge > 0 {
+		params.MaxConnectionAge = config.MaxConnectionAge
+		params.MaxConnectionAgeGrace = config.MaxConnectionAgeGrace
+	}
+
+	return params
+}
+
+/*
+ * Returns how often clients are allowed to ping the server. Players ping
+ * while they wait for songs, so pings without an active rpc are allowed.
+ */
+func keepalivePolicy(config *ServerConfig) keepalive.EnforcementPolicy {
+	return keepalive.EnforcementPolicy{
+		MinTime:             orDefaultDuration(config.KeepaliveMinTime, defaultKeepaliveMinTime),
+		PermitWithoutStream: true,
+	}
+}
+
+func orDefaultDuration(value time.Duration, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func orDefaultSize(value int, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveParams_whenNotSet_usesDefaults(t *testing.T) {
+	params := keepaliveParams(&ServerConfig{})
+
+	if params.Time != defaultKeepaliveTime || params.Timeout != defaultKeepaliveTimeout {
+		t.Errorf("Expected default keepalive of %v/%v, but got %v/%v", defaultKeepaliveTime,
+			defaultKeepaliveTimeout, params.Time, params.Timeout)
+	}
+
+	if params.MaxConnectionAge != 0 || params.MaxConnectionIdle != 0 {
+		t.Errorf("Connections should not be aged out by default, but got %+v", params)
+	}
+
+	policy := keepalivePolicy(&ServerConfig{})
+	if policy.MinTime != defaultKeepaliveMinTime || !policy.PermitWithoutStream {
+		t.Errorf("Unexpected default enforcement policy: %+v", policy)
+	}
+}
+
+func TestKeepaliveParams_whenSet_usesConfig(t *testing.T) {
+	config := &ServerConfig{
+		KeepaliveTime:         time.Minute,
+		KeepaliveTimeout:      5 * time.Second,
+		MaxConnectionAge:      time.Hour,
+		MaxConnectionAgeGrace: time.Minute,
+	}
+
+	params := keepaliveParams(config)
+	if params.Time != time.Minute || params.Timeout != 5*time.Second {
+		t.Errorf("Expected keepalive of 1m/5s, but got %v/%v", params.Time, params.Timeout)
+	}
+
+	if params.MaxConnectionAge != time.Hour || params.MaxConnectionAgeGrace != time.Minute {
+		t.Errorf("Expected connection age of 1h with 1m grace, but got %v/%v",
+			params.MaxConnectionAge, params.MaxConnectionAgeGrace)
+	}
+}
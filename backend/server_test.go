@@ -0,0 +1,405 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestCreditSong_letsRecipientRemoveSong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	alice, _ := server.dbManager.AddUser("Alice", room.Room.Id)
+	carol, _ := server.dbManager.AddUser("Carol", room.Room.Id)
+
+	song := &cmpb.Song{Title: "for alice", Service: cmpb.ServiceType_Youtube, ServiceId: "gift",
+		UserId: bob.User.UserId, Username: "Bob", RoomId: room.Room.Id}
+
+	if err := server.creditSong(song, "Nobody"); err != ErrUnknownRecipient {
+		t.Errorf("Expected an unknown recipient to be rejected, but got %v", err)
+	}
+
+	if err := server.creditSong(song, "Bob"); err != nil || song.ForUserId != 0 {
+		t.Errorf("Expected a song queued for the submitter not to be credited, but got %v", song)
+	}
+
+	if err := server.creditSong(song, " Alice "); err != nil || song.ForUserId != alice.User.UserId ||
+		song.ForUsername != "Alice" {
+		t.Fatalf("Expected the song to be credited to Alice, but got %v, %v", song, err)
+	}
+	server.queueSong(server.zones.defaultZone, song)
+
+	response, _ := server.RemoveSong(context.Background(), &bepb.Eviction{SongId: song.SongId, UserId: carol.User.UserId})
+	if response.Success {
+		t.Errorf("Expected other users to be unable to remove the song")
+	}
+
+	response, _ = server.RemoveSong(context.Background(), &bepb.Eviction{SongId: song.SongId, UserId: alice.User.UserId})
+	if !response.Success || server.queueMgr.Len() != 0 {
+		t.Errorf("Expected the user the song was queued for to remove it, but got %v", response)
+	}
+}
+
+func TestApplyPreferences_setsAudioOnlyAndStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	user, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	link := "https://youtu.be/bL_NcoCJgzo?t=42"
+
+	song := &cmpb.Song{UserId: user.User.UserId}
+	server.applyPreferences(song, link)
+	if song.AudioOnly || song.StartAt != 42 {
+		t.Errorf("Expected the defaults to start where the link says, but got %v", song)
+	}
+
+	server.SetPreferences(context.Background(), &bepb.Preferences{UserId: user.User.UserId, AudioOnly: true,
+		StartBehavior: bepb.StartBehavior_StartFromBeginning})
+
+	song = &cmpb.Song{UserId: user.User.UserId}
+	server.applyPreferences(song, link)
+	if !song.AudioOnly || song.StartAt != 0 {
+		t.Errorf("Expected an audio only song from the beginning, but got %v", song)
+	}
+
+	profile, _ := server.WhoAmI(context.Background(), &bepb.User{UserId: user.User.UserId})
+	if !profile.Err.Success || profile.User.Username != "Bob" || !profile.Preferences.AudioOnly {
+		t.Errorf("Expected Bob's profile with his preferences, but got %v", profile)
+	}
+}
+
+func TestGetPlaylistIfChanged_onlySendsChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	server.queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	first, _ := server.GetPlaylistIfChanged(context.Background(), &bepb.PlaylistRequest{})
+	if first.NotModified || len(first.Songs) != 1 || first.Generation == 0 {
+		t.Fatalf("Expected the playlist with its generation, got %v", first)
+	}
+
+	unchanged, _ := server.GetPlaylistIfChanged(context.Background(),
+		&bepb.PlaylistRequest{SinceGeneration: first.Generation})
+	if !unchanged.NotModified || len(unchanged.Songs) != 0 || unchanged.Generation != first.Generation {
+		t.Errorf("Expected not modified while the queue is unchanged, got %v", unchanged)
+	}
+
+	server.queueMgr.AddSong(queuedSong(2, 2, "PT3M"))
+	changed, _ := server.GetPlaylistIfChanged(context.Background(),
+		&bepb.PlaylistRequest{SinceGeneration: first.Generation})
+	if changed.NotModified || len(changed.Songs) != 2 || changed.Generation == first.Generation {
+		t.Errorf("Expected the new playlist once a song was queued, got %v", changed)
+	}
+}
+
+func TestPopQueue_withoutPlayer_returnsSongUntilConfirmed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	server.playerMgr.confirmWithin = 10 * time.Millisecond
+	server.queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+
+	// nobody is around to play the popped song, so it isn't lost
+	if song, _ := server.PopQueue(context.Background(), &cmpb.Empty{}); song.SongId != 1 {
+		t.Fatalf("Expected song 1 popped, got %v", song)
+	}
+	waitForQueueLen(t, server.queueMgr, 1)
+
+	server.PopQueue(context.Background(), &cmpb.Empty{})
+	response, _ := server.ConfirmPlayback(context.Background(), &bepb.PlaybackConfirmation{SongId: 1})
+	if !response.Success {
+		t.Fatalf("Expected the song to be confirmed, got %v", response)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if server.queueMgr.Len() != 0 {
+		t.Errorf("Expected the confirmed song to leave the queue, got %d songs queued", server.queueMgr.Len())
+	}
+
+	if response, _ := server.ConfirmPlayback(context.Background(), &bepb.PlaybackConfirmation{SongId: 1}); response.Success {
+		t.Errorf("Expected a song confirmed twice to be rejected, got %v", response)
+	}
+}
+
+func TestNextSong_whenQueueEmpty_stopsPlayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	out := make(chan *bepb.PlayerControl, 1)
+	server.playerMgr.fanOut = out
+	server.queueMgr.SetNowPlaying(queuedSong(1, 1, "PT3M"))
+
+	response, _ := server.NextSong(context.Background(), &cmpb.Empty{})
+	if response.Success || response.Message != ErrQueueEmpty.Error() {
+		t.Errorf("Expected the skip to say the queue is empty, got %v", response)
+	}
+
+	if control := <-out; control.Command != bepb.CommandType_Stop || control.Song != nil {
+		t.Errorf("Expected the players to be stopped, got %v", control)
+	}
+
+	if server.queueMgr.NowPlaying() != nil {
+		t.Errorf("Expected nothing playing, got %v", server.queueMgr.NowPlaying())
+	}
+}
+
+func TestPreviousSong_goesBackOrRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	out := make(chan *bepb.PlayerControl, 4)
+	server.playerMgr.fanOut = out
+	zone := server.zones.defaultZone
+
+	if server.previousSong(zone, time.Now()) {
+		t.Errorf("Expected nothing to go back to before anything played")
+	}
+
+	server.queueMgr.AddSong(queuedSong(1, 1, "PT3M"))
+	server.queueMgr.AddSong(queuedSong(2, 2, "PT3M"))
+	server.queueMgr.PopQueue()
+	server.queueMgr.PopQueue()
+
+	// song 2 only just started, so the players go back to song 1 and song 2
+	// plays next
+	if !server.previousSong(zone, time.Now()) {
+		t.Fatal("Expected to go back to song 1")
+	}
+
+	if control := <-out; control.Command != bepb.CommandType_Previous || control.Song.SongId != 1 {
+		t.Errorf("Expected the players sent back to song 1, got %v", control)
+	}
+
+	if playlist := server.queueMgr.GetPlaylist().Songs; len(playlist) != 1 || playlist[0].SongId != 2 {
+		t.Errorf("Expected song 2 back at the head of the queue, got %v", playlist)
+	}
+
+	// a minute in, song 1 starts over instead
+	if !server.previousSong(zone, time.Now().Add(time.Minute)) {
+		t.Fatal("Expected song 1 to restart")
+	}
+
+	if control := <-out; control.Command != bepb.CommandType_Previous || control.Song.SongId != 1 {
+		t.Errorf("Expected the players told to restart song 1, got %v", control)
+	}
+
+	if server.queueMgr.NowPlaying().SongId != 1 || server.queueMgr.Len() != 1 {
+		t.Errorf("Expected song 1 still playing, got %v", server.queueMgr.NowPlaying())
+	}
+}
+
+func TestQueueSong_whenNotRecorded_isNotQueued(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	// songs get their ids from the database, never from the caller
+	song := &cmpb.Song{SongId: 42, Title: "queued", Service: cmpb.ServiceType_Youtube, ServiceId: "queued",
+		UserId: bob.User.UserId, RoomId: room.Room.Id}
+	if err := server.queueSong(server.zones.defaultZone, song); err != nil || song.SongId == 42 {
+		t.Fatalf("Expected the song queued under an id from the database, got %v with error %v", song, err)
+	}
+
+	// a song the database would refuse, here for an unknown user, never
+	// reaches the queue
+	orphan := &cmpb.Song{Title: "orphan", Service: cmpb.ServiceType_Youtube, ServiceId: "orphan", UserId: 999,
+		RoomId: room.Room.Id}
+	if err := server.queueSong(server.zones.defaultZone, orphan); err == nil || server.queueMgr.Len() != 1 {
+		t.Errorf("Expected the unrecorded song to be left out of the queue, got %d songs queued", server.queueMgr.Len())
+	}
+
+	next := &cmpb.Song{Title: "next", Service: cmpb.ServiceType_Youtube, ServiceId: "next",
+		UserId: bob.User.UserId, RoomId: room.Room.Id}
+	if err := server.queueSong(server.zones.defaultZone, next); err != nil || next.SongId != song.SongId+1 {
+		t.Fatalf("Expected the next reserved id, got %v with error %v", next, err)
+	}
+
+	// songs added the usual way are numbered past the reserved ids
+	later := &cmpb.Song{Title: "later", Service: cmpb.ServiceType_Youtube, ServiceId: "later",
+		UserId: bob.User.UserId, RoomId: room.Room.Id}
+	if err := server.dbManager.AddSong(later); err != nil || later.SongId < song.SongId+songIdBlockSize {
+		t.Errorf("Expected the song numbered past the reserved block, got %v with error %v", later, err)
+	}
+
+	server.writes.flush()
+	if recorded, err := server.dbManager.GetSongById(song.SongId); err != nil || recorded.ServiceId != "queued" {
+		t.Errorf("Expected the song to be recorded, got %v with error %v", recorded, err)
+	}
+
+	if recorded, err := server.dbManager.GetSongById(next.SongId); err != nil || recorded.ServiceId != "next" {
+		t.Errorf("Expected the next song to be recorded, got %v with error %v", recorded, err)
+	}
+}
+
+func TestRemoveByServiceId_removesEveryCopyAndBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	patio := server.zones.add(1, "patio", false)
+	for i, name := range []string{"Bob", "Alice", "Carol"} {
+		user, _ := server.dbManager.AddUser(name, room.Room.Id)
+		for _, serviceId := range []string{"meme", "other" + name} {
+			song := &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube, ServiceId: serviceId,
+				UserId: user.User.UserId, RoomId: room.Room.Id}
+			zone := server.zones.defaultZone
+			if i == 2 {
+				zone = patio
+			}
+			server.queueSong(zone, song)
+		}
+	}
+
+	result, _ := server.RemoveByServiceId(context.Background(), &bepb.ServiceEviction{
+		Service: cmpb.ServiceType_Youtube, ServiceId: "meme", Block: true})
+	if !result.Err.Success || result.Removed != 3 || !result.Blocked {
+		t.Fatalf("Expected 3 copies removed and the song blocked, got %v", result)
+	}
+
+	for _, zone := range []*zone{server.zones.defaultZone, patio} {
+		for _, song := range zone.queueMgr.GetPlaylist().Songs {
+			if song.ServiceId == "meme" {
+				t.Errorf("Expected no copies left in zone %d, got %v", zone.id, song)
+			}
+		}
+	}
+
+	if server.queueMgr.Len() != 2 || patio.queueMgr.Len() != 1 {
+		t.Errorf("Expected the other songs to stay queued, got %d and %d", server.queueMgr.Len(), patio.queueMgr.Len())
+	}
+
+	if !server.isBlocked(&cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "meme"}) {
+		t.Error("Expected the song to be blocked")
+	}
+
+	response, _ := server.UnblockSong(context.Background(), &bepb.ServiceEviction{
+		Service: cmpb.ServiceType_Youtube, ServiceId: "meme"})
+	if !response.Success || server.isBlocked(&cmpb.Song{Service: cmpb.ServiceType_Youtube, ServiceId: "meme"}) {
+		t.Errorf("Expected the song to be unblocked, got %v", response)
+	}
+}
+
+func TestGetSongs_fromQueueAndHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	for _, serviceId := range []string{"played", "queued"} {
+		song := &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube, ServiceId: serviceId,
+			UserId: bob.User.UserId, RoomId: room.Room.Id, Anonymous: serviceId == "played"}
+		if err := server.queueSong(server.zones.defaultZone, song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	played := server.queueMgr.PopQueue()
+	queued := server.queueMgr.GetPlaylist().Songs[0]
+	server.queueMgr.ClearNowPlaying()
+	server.queueMgr.VoteSong(queued.SongId)
+	server.writes.flush()
+
+	request := &bepb.SongIdList{SongIds: []uint32{queued.SongId, 999, played.SongId, queued.SongId}}
+	batch, _ := server.GetSongs(context.Background(), request)
+	if !batch.Err.Success || len(batch.Songs) != 2 {
+		t.Fatalf("Expected both songs, got %v", batch)
+	}
+
+	if batch.Songs[0].ServiceId != "queued" || batch.Songs[0].Votes != 1 {
+		t.Errorf("Expected the queued song with its vote first, got %v", batch.Songs[0])
+	}
+
+	if batch.Songs[1].ServiceId != "played" || batch.Songs[1].Username != anonymousName {
+		t.Errorf("Expected the played song from the history without its submitter, got %v", batch.Songs[1])
+	}
+
+	if len(batch.Missing) != 1 || batch.Missing[0] != 999 {
+		t.Errorf("Expected song 999 to be missing, got %v", batch.Missing)
+	}
+}
+
+func TestSendSong_whenTitleIsBlank_rejects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.metadata = &titleFetcher{title: "  "}
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId,
+		Link: "https://youtu.be/dQw4w9WgXcQ"})
+	if response.Success || response.Message != ErrBlankTitle.Error() || server.queueMgr.Len() != 0 {
+		t.Errorf("Expected the song without a title to be turned away, got %v", response)
+	}
+}
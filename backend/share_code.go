@@ -0,0 +1,36 @@
+/*
+ * Generates the short codes handed out for shared playlists
+ */
+
+package backend
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const (
+	shareCodeLength = 6 // number of characters in a share code
+
+	// characters used in share codes. Leaves out characters that are easy to
+	// confuse with each other when read aloud or off a screen (0/O, 1/I/L).
+	shareCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+)
+
+/*
+ * Generate a random share code
+ */
+func generateShareCode() (string, error) {
+	code := make([]byte, shareCodeLength)
+	max := big.NewInt(int64(len(shareCodeAlphabet)))
+
+	for i := range code {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shareCodeAlphabet[index.Int64()]
+	}
+
+	return string(code), nil
+}
@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateShareCode_when_success(t *testing.T) {
+	code, err := generateShareCode()
+
+	if err != nil {
+		t.Fatalf("Failed to generate share code: %v", err)
+	}
+
+	if len(code) != shareCodeLength {
+		t.Errorf("Share code should be %d characters long, but was %s", shareCodeLength, code)
+	}
+
+	for _, char := range code {
+		if !strings.ContainsRune(shareCodeAlphabet, char) {
+			t.Errorf("Share code %s contains unexpected character %c", code, char)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+	"github.com/nguyenmq/ytbox-go/simplayer"
+)
+
+func TestSimulatedPlayer_playsThroughTheQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_sim_player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	go server.Serve()
+	defer server.Stop()
+
+	conn, err := grpc.Dial(server.listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := bepb.NewYtbBePlayerClient(conn).SongPlayer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	player := simplayer.New("", 100)
+	go player.Run(ctx, stream)
+
+	server.queueMgr.AddSong(queuedSong(1, 1, "PT5S"))
+	server.queueMgr.AddSong(queuedSong(2, 1, "PT10M"))
+
+	// the short song plays out on its own and the long one is skipped
+	waitForPlayed(t, player, 2)
+	if response, _ := server.NextSong(context.Background(), &cmpb.Empty{}); response.Success {
+		t.Errorf("Expected skipping past the last song to say the queue is empty, got %v", response)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for player.NowPlaying() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if player.NowPlaying() != nil || server.queueMgr.Len() != 0 {
+		t.Errorf("Expected the player to be idle with the queue empty, got %v", player.NowPlaying())
+	}
+
+	if played := player.Played(); played[0].SongId != 1 || played[1].SongId != 2 {
+		t.Errorf("Expected the songs played in queue order, got %v", played)
+	}
+}
+
+/*
+ * Wait for the player to start playing the number of songs
+ */
+func waitForPlayed(t *testing.T, player *simplayer.Player, count int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(player.Played()) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d songs played, got %v", count, player.Played())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Lets users vote to skip the song playing in a zone. A song is skipped once
+ * a share of the active users voted for it. Votes only count toward the song
+ * they were cast on, so they start over whenever the song changes.
+ */
+
+package backend
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const defaultSkipVoteShare = 0.5 // share of the active users whose votes skip a song
+
+/*
+ * Votes to skip the song playing in a zone
+ */
+type skipBallot struct {
+	songId uint32          // id of the song voted on
+	voters map[uint32]bool // ids of the users who voted
+}
+
+/*
+ * Counts the skip votes of every zone
+ */
+type skipVoter struct {
+	share   float64                // share of the active users needed to skip a song
+	ballots map[uint32]*skipBallot // zone id -> votes on the zone's song
+	lock    sync.Mutex             // lock on the ballots
+}
+
+/*
+ * Initialize the voter. The share falls back to a default if it isn't between
+ * zero and one.
+ */
+func (v *skipVoter) init(share float64) {
+	v.share = share
+	if v.share <= 0 || v.share > 1 {
+		v.share = defaultSkipVoteShare
+	}
+
+	v.ballots = make(map[uint32]*skipBallot)
+}
+
+/*
+ * Returns the number of votes it takes to skip a song with the given number
+ * of active users. At least one vote is always needed.
+ */
+func (v *skipVoter) needed(active int) int {
+	needed := int(math.Ceil(float64(active) * v.share))
+	if needed < 1 {
+		return 1
+	}
+
+	return needed
+}
+
+/*
+ * Record a user's vote to skip a song in a zone. Returns the votes for the
+ * song so far and, once they reached the number needed, the ids of the users
+ * who voted. The votes are cleared once they pass, so only one caller is told
+ * to skip the song.
+ */
+func (v *skipVoter) vote(zoneId uint32, songId uint32, userId uint32, needed int) (int, []uint32) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	ballot, exists := v.ballots[zoneId]
+	if !exists || ballot.songId != songId {
+		ballot = &skipBallot{songId: songId, voters: make(map[uint32]bool)}
+		v.ballots[zoneId] = ballot
+	}
+
+	ballot.voters[userId] = true
+	votes := len(ballot.voters)
+	if votes < needed {
+		return votes, nil
+	}
+
+	voters := make([]uint32, 0, votes)
+	for voter := range ballot.voters {
+		voters = append(voters, voter)
+	}
+	sort.Slice(voters, func(i, j int) bool { return voters[i] < voters[j] })
+
+	delete(v.ballots, zoneId)
+	return votes, voters
+}
@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"testing"
+)
+
+func TestSkipVoter_needed(t *testing.T) {
+	voter := new(skipVoter)
+	voter.init(0.5)
+
+	tests := map[int]int{0: 1, 1: 1, 2: 1, 3: 2, 4: 2, 7: 4}
+	for active, expected := range tests {
+		if needed := voter.needed(active); needed != expected {
+			t.Errorf("needed(%d) = %d, expected %d", active, needed, expected)
+		}
+	}
+}
+
+func TestSkipVoter_vote_passesOnce(t *testing.T) {
+	voter := new(skipVoter)
+	voter.init(0)
+
+	if votes, voters := voter.vote(0, 10, 1, 2); votes != 1 || voters != nil {
+		t.Errorf("Expected 1 vote that didn't pass, got %d and %v", votes, voters)
+	}
+
+	// voting twice doesn't count twice
+	if votes, voters := voter.vote(0, 10, 1, 2); votes != 1 || voters != nil {
+		t.Errorf("Expected a repeat vote not to count, got %d and %v", votes, voters)
+	}
+
+	if votes, voters := voter.vote(0, 10, 2, 2); votes != 2 || len(voters) != 2 || voters[0] != 1 || voters[1] != 2 {
+		t.Errorf("Expected 2 votes by users 1 and 2 that passed, got %d and %v", votes, voters)
+	}
+
+	if votes, _ := voter.vote(0, 10, 3, 2); votes != 1 {
+		t.Errorf("Expected votes to start over after passing, got %d", votes)
+	}
+}
+
+func TestSkipVoter_vote_whenSongChanges_startsOver(t *testing.T) {
+	voter := new(skipVoter)
+	voter.init(0.5)
+
+	voter.vote(0, 10, 1, 3)
+	voter.vote(1, 10, 2, 3)
+	if votes, _ := voter.vote(0, 11, 2, 3); votes != 1 {
+		t.Errorf("Expected votes for a new song to start over, got %d", votes)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Snapshots save everything needed to move a party to a replacement machine:
+ * the queue, the song that was playing and the recent history. Restoring a
+ * snapshot into another database adds the rooms and users the songs were
+ * submitted by, since their ids there won't match.
+ */
+
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const snapshotHistoryLimit = 500 // most history songs saved in a snapshot
+
+/*
+ * Take a snapshot of the default zone's queue and now playing song along with
+ * the recent history
+ */
+func (s *BackendServer) takeSnapshot() (*bepb.Snapshot, error) {
+	// the history has to include the songs still being written
+	s.writes.flush()
+
+	history, err := s.dbManager.GetRecentSongs(snapshotHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.snapshotWith(history)
+}
+
+/*
+ * Take a snapshot of the default zone's queue and now playing song along with
+ * the given history. The history is left out of the snapshot when it's nil.
+ */
+func (s *BackendServer) snapshotWith(history []*db.HistoryData) (*bepb.Snapshot, error) {
+	rooms, err := s.dbManager.GetRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &bepb.Snapshot{SavedAt: time.Now().Unix()}
+	snapshot.NowPlaying, snapshot.Songs = s.queueMgr.Snapshot()
+	if snapshot.NowPlaying.GetJingle() {
+		snapshot.NowPlaying = nil
+	}
+
+	// songs are restored to their submitters by name, so anonymous ones need
+	// theirs back
+	snapshot.NowPlaying = s.revealSubmitter(snapshot.NowPlaying)
+	for i, song := range snapshot.Songs {
+		snapshot.Songs[i] = s.revealSubmitter(song)
+	}
+
+	// songs still waiting to play are saved in the queue instead
+	pending := make(map[uint32]bool)
+	for _, song := range snapshot.Songs {
+		pending[song.SongId] = true
+	}
+
+	if snapshot.NowPlaying != nil {
+		pending[snapshot.NowPlaying.SongId] = true
+	}
+
+	for _, entry := range history {
+		if !pending[entry.Song.SongId] {
+			snapshot.History = append(snapshot.History, &bepb.HistorySong{
+				Song:      &entry.Song,
+				Submitted: entry.Date.Unix(),
+				Skipped:   entry.Skipped,
+				Outcome:   entry.Outcome,
+				Voters:    entry.Voters,
+				SkippedBy: entry.SkippedBy,
+			})
+		}
+	}
+
+	for _, room := range rooms {
+		snapshot.Rooms = append(snapshot.Rooms, &bepb.Room{Id: room.Room.Id, Name: room.Room.Name})
+	}
+
+	return snapshot, nil
+}
+
+/*
+ * Restore a snapshot into the default zone. The now playing song goes to the
+ * front of the queue. Songs already queued and history already recorded are
+ * skipped, so restoring the same snapshot twice is harmless. The snapshot
+ * isn't changed. Returns the
+ * number of songs queued and added to the history.
+ */
+func (s *BackendServer) restoreSnapshot(snapshot *bepb.Snapshot) (int, int, error) {
+	zone := s.zones.defaultZone
+	rooms := make(map[uint32]string, len(snapshot.Rooms))
+	for _, room := range snapshot.Rooms {
+		rooms[room.Id] = room.Name
+	}
+
+	queue := make([]*cmpb.Song, 0, len(snapshot.Songs)+1)
+	for _, song := range append([]*cmpb.Song{snapshot.NowPlaying}, snapshot.Songs...) {
+		if song != nil && song.ServiceId != "" && !isQueued(zone.queueMgr, song) {
+			queue = append(queue, proto.Clone(song).(*cmpb.Song))
+		}
+	}
+
+	// songs can't keep ids already taken in the queue
+	taken := make(map[uint32]bool)
+	nowPlaying, queued := zone.queueMgr.Snapshot()
+	for _, song := range append(queued, nowPlaying) {
+		if song != nil {
+			taken[song.SongId] = true
+		}
+	}
+
+	restored := 0
+	err := s.dbManager.WithTx(func(tx db.DbManager) error {
+		users := new(snapshotUsers)
+		users.init(tx, rooms)
+
+		// oldest first, so the restored songs get ids in submission order
+		for i := len(snapshot.History) - 1; i >= 0; i-- {
+			entry := snapshot.History[i]
+			song := proto.Clone(entry.Song).(*cmpb.Song)
+			if err := users.resolve(song); err != nil {
+				return err
+			}
+
+			added, err := tx.AddHistorySong(song, time.Unix(entry.Submitted, 0), entry.Skipped)
+			if err != nil {
+				return err
+			}
+
+			if !added {
+				continue
+			}
+			restored++
+
+			// the voters' ids belong to the old database, so only the
+			// outcome comes along
+			if entry.Outcome != bepb.SongOutcome_NotEnded {
+				if err := tx.SetSongOutcome(song.SongId, entry.Outcome, entry.SkippedBy, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, song := range queue {
+			if err := users.resolve(song); err != nil {
+				return err
+			}
+
+			// songs from this database keep their ids
+			recorded, err := recordedSong(tx, song, taken)
+			if err != nil {
+				return err
+			}
+
+			if recorded == nil {
+				if err := tx.AddSong(song); err != nil {
+					return err
+				}
+			}
+			taken[song.SongId] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, song := range queue {
+		s.enqueueSong(zone, song)
+	}
+
+	return len(queue), restored, nil
+}
+
+/*
+ * Finds or adds the users who submitted the songs of a snapshot
+ */
+type snapshotUsers struct {
+	tx    db.DbManager            // transaction the snapshot is restored in
+	rooms map[uint32]string       // room id in the snapshot -> room name
+	users map[uint32]*db.UserData // user id in the snapshot -> user in the database
+}
+
+/*
+ * Initialize the users of a snapshot
+ */
+func (u *snapshotUsers) init(tx db.DbManager, rooms map[uint32]string) {
+	u.tx = tx
+	u.rooms = rooms
+	u.users = make(map[uint32]*db.UserData)
+}
+
+/*
+ * Point a song at the user and room it has in the database, adding them if
+ * they don't exist
+ */
+func (u *snapshotUsers) resolve(song *cmpb.Song) error {
+	userData, exists := u.users[song.UserId]
+	if !exists {
+		var err error
+		if userData, err = u.lookup(song); err != nil {
+			return err
+		}
+		u.users[song.UserId] = userData
+	}
+
+	song.UserId = userData.User.UserId
+	song.RoomId = userData.User.RoomId
+	return nil
+}
+
+/*
+ * Find the user who submitted a song by name and room, adding them if needed
+ */
+func (u *snapshotUsers) lookup(song *cmpb.Song) (*db.UserData, error) {
+	roomName, exists := u.rooms[song.RoomId]
+	if !exists {
+		return nil, errors.New("Snapshot is missing the room of a song.")
+	}
+
+	roomData, err := u.tx.GetRoomByName(roomName)
+	if errors.Is(err, sql.ErrNoRows) {
+		roomData, err = u.tx.AddRoom(roomName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := u.tx.GetUserByName(song.Username, roomData.Room.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		userData, err = u.tx.AddUser(song.Username, roomData.Room.Id)
+	}
+
+	return userData, err
+}
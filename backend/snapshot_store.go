@@ -0,0 +1,292 @@
+/*
+ * Snapshot stores hold the playlists and snapshots the backend saves and
+ * restores. They're written to local files by default, but can be written to
+ * an S3 bucket, or anything speaking its api like MinIO, so they survive the
+ * backend's SD card dying on a Raspberry Pi. Requests to S3 are signed with
+ * Signature Version 4 and address the bucket in the path, which both S3 and
+ * MinIO accept.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Scheme         = "s3://"            // scheme of snapshot store urls for S3 buckets
+	s3Service        = "s3"               // service name signed into requests
+	s3Algorithm      = "AWS4-HMAC-SHA256" // signing algorithm of Signature Version 4
+	s3DateFormat     = "20060102T150405Z" // format of the time a request was signed
+	s3RequestTimeout = 30 * time.Second   // how long a request to the bucket may take
+	s3ErrorBodyLimit = 512                // bytes of an error response kept in the error
+	defaultS3Region  = "us-east-1"        // region signed into requests if none is given
+)
+
+var (
+	ErrUnknownSnapshotStore = errors.New("Snapshot stores must be s3:// urls.")
+	ErrMissingS3Bucket      = errors.New("The snapshot store url has no bucket.")
+	ErrMissingS3Credentials = errors.New("An access key and secret key are needed to use an S3 snapshot store.")
+)
+
+/*
+ * Saves and loads snapshots by name. Names are file paths for the default
+ * store and keys under the bucket's prefix for S3.
+ */
+type SnapshotStore interface {
+	Save(name string, data []byte) error
+	Load(name string) ([]byte, error) // errors.Is(err, os.ErrNotExist) if nothing is saved by the name
+}
+
+/*
+ * Returns the snapshot store in the config. Snapshots are saved to local
+ * files if no store is set.
+ */
+func newSnapshotStore(config *ServerConfig) (SnapshotStore, error) {
+	if config.SnapshotStore == "" {
+		return new(fileStore), nil
+	} else if !strings.HasPrefix(config.SnapshotStore, s3Scheme) {
+		return nil, ErrUnknownSnapshotStore
+	}
+
+	store := new(s3Store)
+	if err := store.init(config); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+/*
+ * Saves snapshots to local files
+ */
+type fileStore struct{}
+
+func (f *fileStore) Save(name string, data []byte) error {
+	return ioutil.WriteFile(name, data, 0644)
+}
+
+func (f *fileStore) Load(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+/*
+ * Saves snapshots to an S3 bucket
+ */
+type s3Store struct {
+	endpoint  *url.URL         // address of the S3 api
+	bucket    string           // bucket the snapshots are saved in
+	prefix    string           // prefix of the snapshots' keys. Empty saves them at the top of the bucket
+	region    string           // region signed into requests
+	accessKey string           // id of the access key signing requests
+	secretKey string           // secret of the access key signing requests
+	client    *http.Client     // client sending the requests
+	now       func() time.Time // returns the time requests are signed at
+}
+
+/*
+ * Initialize the store with the bucket and credentials in the config
+ */
+func (s *s3Store) init(config *ServerConfig) error {
+	bucketPath := strings.TrimPrefix(config.SnapshotStore, s3Scheme)
+	s.bucket = strings.SplitN(bucketPath, "/", 2)[0]
+	s.prefix = strings.Trim(strings.TrimPrefix(bucketPath, s.bucket), "/")
+	if s.bucket == "" {
+		return ErrMissingS3Bucket
+	}
+
+	if config.S3AccessKey == "" || config.S3SecretKey == "" {
+		return ErrMissingS3Credentials
+	}
+
+	s.region = config.S3Region
+	if s.region == "" {
+		s.region = defaultS3Region
+	}
+
+	endpoint := config.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an http or https url", endpoint)
+	}
+
+	s.endpoint = parsed
+	s.accessKey = config.S3AccessKey
+	s.secretKey = config.S3SecretKey
+	s.client = &http.Client{Timeout: s3RequestTimeout}
+	s.now = time.Now
+	return nil
+}
+
+func (s *s3Store) Save(name string, data []byte) error {
+	response, err := s.do(http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return s3Error(response)
+}
+
+func (s *s3Store) Load(name string) ([]byte, error) {
+	response, err := s.do(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("no snapshot saved as %s: %w", s.key(name), os.ErrNotExist)
+	} else if err = s3Error(response); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+/*
+ * Returns a description of where the snapshots are saved
+ */
+func (s *s3Store) String() string {
+	return fmt.Sprintf("%s%s at %s", s3Scheme, path.Join(s.bucket, s.prefix), s.endpoint.Host)
+}
+
+/*
+ * Returns the key a snapshot is saved under
+ */
+func (s *s3Store) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, path.Clean("/"+name)), "/")
+}
+
+/*
+ * Send a signed request for the snapshot with the name
+ */
+func (s *s3Store) do(method string, name string, body []byte) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = path.Join("/", s.endpoint.Path, s.bucket, s.key(name))
+	target.RawPath = s3EscapePath(target.Path)
+
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(request, body, s.now().UTC())
+	return s.client.Do(request)
+}
+
+/*
+ * Sign a request with Signature Version 4
+ */
+func (s *s3Store) sign(request *http.Request, body []byte, now time.Time) {
+	stamp := now.Format(s3DateFormat)
+	date := stamp[:8]
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 request.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           stamp,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{s3Algorithm, stamp, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signature := hex.EncodeToString(hmacSha256(s3SigningKey(s.secretKey, date, s.region, s3Service), stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+/*
+ * Returns the key requests are signed with on the date
+ */
+func s3SigningKey(secretKey string, date string, region string, service string) []byte {
+	key := hmacSha256([]byte("AWS4"+secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	return hmacSha256(key, "aws4_request")
+}
+
+/*
+ * Escape a path the way S3 expects it in a canonical request, which leaves
+ * only unreserved characters and slashes unescaped
+ */
+func s3EscapePath(unescaped string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(unescaped) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+/*
+ * Returns an error describing a failed response. Nil if it succeeded.
+ */
+func s3Error(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, s3ErrorBodyLimit))
+	return fmt.Errorf("snapshot store responded %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Keeps objects in memory like an S3 bucket, checking that requests are
+ * signed by the test's access key
+ */
+type fakeBucket struct {
+	objects map[string][]byte
+	lock    sync.Mutex
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm+" Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.objects[r.URL.Path] = body
+	case http.MethodGet:
+		object, exists := b.objects[r.URL.Path]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+	}
+}
+
+func testS3Store(t *testing.T, endpoint string) *s3Store {
+	store, err := newSnapshotStore(&ServerConfig{
+		SnapshotStore: "s3://ytbox/pi",
+		S3Endpoint:    endpoint,
+		S3AccessKey:   "AKID",
+		S3SecretKey:   "SECRET",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the store: %v", err)
+	}
+
+	return store.(*s3Store)
+}
+
+func TestS3SigningKey_matchesAwsExample(t *testing.T) {
+	key := s3SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+
+	expected := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("Expected signing key %s, got %x", expected, key)
+	}
+}
+
+func TestS3Store_sign(t *testing.T) {
+	store := testS3Store(t, "http://minio.local:9000")
+
+	request, _ := http.NewRequest(http.MethodPut, "http://minio.local:9000/ytbox/pi/tmp/ytbox.queue", nil)
+	store.sign(request, []byte("hello"), time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKID/20261017/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=9636c4251985e76dc1897e9a409284252abec7018b383b8f8b6ad39ee394ffe7"
+	if auth := request.Header.Get("Authorization"); auth != expected {
+		t.Errorf("Expected authorization\n%s\ngot\n%s", expected, auth)
+	}
+}
+
+func TestS3Store_saveAndLoad(t *testing.T) {
+	bucket := &fakeBucket{objects: make(map[string][]byte)}
+	endpoint := httptest.NewServer(bucket)
+	defer endpoint.Close()
+
+	store := testS3Store(t, endpoint.URL)
+	if err := store.Save("/tmp/ytbox.queue", []byte("queue")); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	if _, exists := bucket.objects["/ytbox/pi/tmp/ytbox.queue"]; !exists {
+		t.Errorf("Expected the snapshot under the bucket's prefix, got %v", bucket.objects)
+	}
+
+	loaded, err := store.Load("/tmp/ytbox.queue")
+	if err != nil || string(loaded) != "queue" {
+		t.Errorf("Expected to load the saved snapshot, got %q, %v", loaded, err)
+	}
+
+	if _, err = store.Load("missing.queue"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing snapshot to not exist, got %v", err)
+	}
+}
+
+func TestNewSnapshotStore_rejectsBadConfig(t *testing.T) {
+	configs := map[*ServerConfig]error{
+		{SnapshotStore: "ftp://ytbox"}:                                       ErrUnknownSnapshotStore,
+		{SnapshotStore: "s3://", S3AccessKey: "AKID", S3SecretKey: "SECRET"}: ErrMissingS3Bucket,
+		{SnapshotStore: "s3://ytbox"}:                                        ErrMissingS3Credentials,
+	}
+
+	for config, expected := range configs {
+		if _, err := newSnapshotStore(config); err != expected {
+			t.Errorf("Expected %v for %s, got %v", expected, config.SnapshotStore, err)
+		}
+	}
+}
+
+func TestSavePlaylist_writesToSnapshotStore(t *testing.T) {
+	bucket := &fakeBucket{objects: make(map[string][]byte)}
+	endpoint := httptest.NewServer(bucket)
+	defer endpoint.Close()
+
+	dir, err := ioutil.TempDir("", "ytbox_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := testSnapshotServer(t, dir, "old.db")
+	defer old.dbManager.Close()
+	old.snapshots = testS3Store(t, endpoint.URL)
+
+	room, _ := old.dbManager.AddRoom("Kitchen")
+	user, _ := old.dbManager.AddUser("Zedd", room.Room.Id)
+	old.queueSong(old.zones.defaultZone, &cmpb.Song{Title: "queued", Service: cmpb.ServiceType_Youtube,
+		ServiceId: "queued", UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id})
+
+	response, _ := old.SavePlaylist(context.Background(), &bepb.FilePath{Path: "party.queue", WithHistory: true})
+	if !response.Success {
+		t.Fatalf("Failed to save the snapshot: %s", response.Message)
+	}
+
+	if _, exists := bucket.objects["/ytbox/pi/party.queue"]; !exists {
+		t.Fatalf("Expected the snapshot in the bucket, got %v", bucket.objects)
+	}
+
+	// the replacement restores from the bucket without the old machine's files
+	replacement := testSnapshotServer(t, dir, "new.db")
+	defer replacement.dbManager.Close()
+	replacement.snapshots = testS3Store(t, endpoint.URL)
+
+	response, _ = replacement.RestorePlaylist(context.Background(), &bepb.FilePath{Path: "party.queue"})
+	if !response.Success || replacement.queueMgr.Len() != 1 {
+		t.Errorf("Expected the song restored from the bucket, got %s with %d queued", response.Message,
+			replacement.queueMgr.Len())
+	}
+}
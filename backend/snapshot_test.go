@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Create a server backed by a new database in the directory
+ */
+func testSnapshotServer(t *testing.T, dir string, name string) *BackendServer {
+	dbManager := new(db.SqliteManager)
+	if err := dbManager.Init(filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := New(&ServerConfig{}, WithListener(listener), WithDbManager(dbManager))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	return server
+}
+
+func TestRestoreSnapshot_onAnotherDatabase_keepsEverything(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := testSnapshotServer(t, dir, "old.db")
+	defer old.dbManager.Close()
+
+	// the replacement machine has another room, so ids won't line up
+	replacement := testSnapshotServer(t, dir, "new.db")
+	defer replacement.dbManager.Close()
+	replacement.dbManager.AddRoom("Basement")
+
+	room, _ := old.dbManager.AddRoom("Kitchen")
+	user, _ := old.dbManager.AddUser("Zedd", room.Room.Id)
+	for _, serviceId := range []string{"played", "playing", "queued"} {
+		old.queueSong(old.zones.defaultZone, &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube,
+			ServiceId: serviceId, UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id})
+	}
+	old.queueMgr.PopQueue()
+	old.queueMgr.PopQueue()
+
+	snapshot, err := old.takeSnapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	if len(snapshot.Songs) != 1 || snapshot.NowPlaying.ServiceId != "playing" || len(snapshot.History) != 1 {
+		t.Fatalf("Expected one queued, one playing and one history song, got %v", snapshot)
+	}
+
+	queued, restored, err := replacement.restoreSnapshot(snapshot)
+	if err != nil || queued != 2 || restored != 1 {
+		t.Fatalf("Expected 2 songs queued and 1 restored, got %d, %d and %v", queued, restored, err)
+	}
+
+	playlist := replacement.queueMgr.GetPlaylist().Songs
+	if len(playlist) != 2 || playlist[0].ServiceId != "playing" || playlist[1].ServiceId != "queued" {
+		t.Fatalf("Expected the playing song ahead of the queued one, got %v", playlist)
+	}
+
+	restoredUser, err := replacement.dbManager.GetUserByName("Zedd", playlist[0].RoomId)
+	if err != nil || restoredUser.User.UserId != playlist[0].UserId {
+		t.Errorf("Expected the songs to belong to the restored user, got %v", playlist[0])
+	}
+
+	// restoring again doesn't duplicate anything
+	if queued, restored, err = replacement.restoreSnapshot(snapshot); err != nil || queued != 0 || restored != 0 {
+		t.Errorf("Expected nothing restored twice, got %d, %d and %v", queued, restored, err)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Lets users submit one chapter of a long video, like a track from a full
+ * album upload, as a song of its own. Chapters are read from the timestamps
+ * in a YouTube video's description, the way YouTube finds them, or from the
+ * chapters yt-dlp reports. A chapter plays from its start to its end, which
+ * are passed to the player as offsets into the video.
+ */
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rickb777/date/period"
+
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	minChapters      = 3  // fewest timestamps in a description that make up chapters
+	minChapterLength = 10 // shortest a chapter can be in seconds
+)
+
+var ErrNoChapter = errors.New("That video doesn't have a chapter with that number.")
+
+/*
+ * Description lines with a timestamp before or after the chapter title, like
+ * "1. 0:00 - Intro" or "Intro (0:00)"
+ */
+var (
+	leadingTimestamp  = regexp.MustCompile(`^(?:\d+[.)]\s+)?[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?(?:\s+|\s*[-–—:|]\s*|$)(.*)$`)
+	trailingTimestamp = regexp.MustCompile(`^(.*?)(?:\s+|\s*[-–—:|]\s*)[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?$`)
+)
+
+/*
+ * Returns the chapters in a YouTube video's description. Like YouTube, the
+ * timestamps only count as chapters if there are at least three of them, the
+ * first is at the start of the video, they're in order and each chapter is at
+ * least ten seconds long. The last chapter runs to the end of the video, which
+ * is zero if its length isn't known.
+ */
+func parseChapters(description string, length uint32) []*cmpb.Chapter {
+	var chapters []*cmpb.Chapter
+	for _, line := range strings.Split(description, "\n") {
+		title, start, ok := chapterLine(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+
+		if len(chapters) == 0 && start != 0 {
+			return nil
+		}
+
+		if len(chapters) > 0 {
+			previous := chapters[len(chapters)-1]
+			if start < previous.StartAt+minChapterLength {
+				return nil
+			}
+			previous.EndAt = start
+		}
+
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", len(chapters)+1)
+		}
+		chapters = append(chapters, &cmpb.Chapter{Title: title, StartAt: start})
+	}
+
+	if len(chapters) < minChapters {
+		return nil
+	}
+
+	last := chapters[len(chapters)-1]
+	if length > 0 {
+		if length < last.StartAt+minChapterLength {
+			return nil
+		}
+		last.EndAt = length
+	}
+
+	return chapters
+}
+
+/*
+ * Split a description line into the chapter title and the seconds its
+ * timestamp points at. Returns false if the line doesn't have a timestamp.
+ */
+func chapterLine(line string) (string, uint32, bool) {
+	title, timestamp := "", ""
+	if match := leadingTimestamp.FindStringSubmatch(line); match != nil {
+		timestamp, title = match[1], match[2]
+	} else if match := trailingTimestamp.FindStringSubmatch(line); match != nil {
+		title, timestamp = match[1], match[2]
+	} else {
+		return "", 0, false
+	}
+
+	var seconds uint32
+	for _, part := range strings.Split(timestamp, ":") {
+		value, _ := strconv.Atoi(part)
+		seconds = seconds*60 + uint32(value)
+	}
+
+	return strings.Trim(strings.TrimSpace(title), "-–—:|"), seconds, true
+}
+
+/*
+ * Returns the length of a song in whole seconds, or zero if it isn't known
+ */
+func durationSeconds(song *cmpb.Song) uint32 {
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil {
+		return 0
+	}
+
+	return uint32(duration.DurationApprox().Seconds() + 0.5)
+}
+
+/*
+ * Turn a fetched video into the chapter of it that was submitted, so it plays
+ * from the chapter's start to its end under the chapter's title. A chapter of
+ * zero keeps the whole video. Either way, the song stops carrying the list of
+ * chapters, which is only needed while picking one.
+ */
+func applyChapter(song *cmpb.Song, number uint32) error {
+	chapters := song.GetMetadata().GetChapters()
+	if song.Metadata != nil {
+		song.Metadata.Chapters = nil
+	}
+
+	if number == 0 {
+		return nil
+	} else if int(number) > len(chapters) {
+		return ErrNoChapter
+	}
+
+	chapter := chapters[number-1]
+	song.Title = chapter.Title
+	song.RomanizedTitle = ""
+	song.Chapter = number
+	song.StartAt = chapter.StartAt
+	song.EndAt = chapter.EndAt
+	if chapter.EndAt > 0 {
+		song.Metadata.Duration = ytDlpDuration(float64(chapter.EndAt - chapter.StartAt))
+	}
+
+	return nil
+}
+
+/*
+ * Lists the chapters of the video a link points at
+ */
+func (s *BackendServer) ListChapters(con context.Context, sub *bepb.Submission) (*bepb.ChapterList, error) {
+	response := &bepb.ChapterList{Err: &bepb.Error{Success: false}}
+	if links.IsSearchQuery(sub.GetLink()) {
+		response.Err.Message = "Only links have chapters."
+		return response, nil
+	}
+
+	song := new(cmpb.Song)
+	err := s.metadata.FetchSongData(sub.GetLink(), song)
+	if errors.Is(err, ErrServiceUnavailable) {
+		response.Err.Message = serviceDownMessage
+		return response, nil
+	} else if err != nil && !isRestricted(err) {
+		log.Printf("Failed to fetch the chapters of %s: %v", sub.GetLink(), err)
+		response.Err.Message = "Failed to fetch metadata for your song. Please check your link."
+		return response, nil
+	}
+
+	response.Title = song.Title
+	response.Chapters = song.GetMetadata().GetChapters()
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Metadata fetcher returning a full album upload split into chapters
+ */
+type albumFetcher struct{}
+
+func (f *albumFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	song.Title = "Band - Album (Full Album)"
+	song.Service = cmpb.ServiceType_Youtube
+	song.ServiceId = "SilKjJ0S904"
+	song.Metadata = &cmpb.Metadata{
+		Duration: "PT12M",
+		Chapters: parseChapters("Tracklist:\n0:00 Band - Opener\n4:10 Band - Single\n1. 9:05 - Band - Closer", 720),
+	}
+	return nil
+}
+
+func TestParseChapters(t *testing.T) {
+	chapters := parseChapters("Tracks\n[0:00] Intro\nFirst Song - 3:25\n(1:02:03) Last Song\nthanks for listening!",
+		4000)
+	if len(chapters) != 3 {
+		t.Fatalf("Expected 3 chapters, got %v", chapters)
+	}
+
+	expected := []*cmpb.Chapter{
+		{Title: "Intro", StartAt: 0, EndAt: 205},
+		{Title: "First Song", StartAt: 205, EndAt: 3723},
+		{Title: "Last Song", StartAt: 3723, EndAt: 4000},
+	}
+	for i, chapter := range chapters {
+		if chapter.Title != expected[i].Title || chapter.StartAt != expected[i].StartAt ||
+			chapter.EndAt != expected[i].EndAt {
+			t.Errorf("Expected chapter %d to be %v, got %v", i+1, expected[i], chapter)
+		}
+	}
+}
+
+func TestParseChapters_whenNotChapters_returnsNil(t *testing.T) {
+	descriptions := map[string]string{
+		"too few":         "0:00 Intro\n3:00 Song",
+		"not from start":  "0:30 Intro\n3:00 Song\n6:00 Outro",
+		"out of order":    "0:00 Intro\n6:00 Song\n3:00 Outro",
+		"too short":       "0:00 Intro\n0:05 Song\n6:00 Outro",
+		"past the end":    "0:00 Intro\n3:00 Song\n6:00 Outro",
+		"no timestamps":   "Follow us on every platform",
+		"empty":           "",
+		"comment in text": "best part at 2:30\n0:00 Intro\n3:00 Song\n6:00 Outro",
+	}
+
+	for name, description := range descriptions {
+		if chapters := parseChapters(description, 300); chapters != nil {
+			t.Errorf("%s: expected no chapters, got %v", name, chapters)
+		}
+	}
+}
+
+func TestSendSong_withChapter_queuesJustTheChapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_chapters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.metadata = new(albumFetcher)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	link := "https://www.youtube.com/watch?v=SilKjJ0S904"
+
+	listed, _ := server.ListChapters(context.Background(), &bepb.Submission{Link: link})
+	if !listed.Err.Success || len(listed.Chapters) != 3 || listed.Chapters[1].Title != "Band - Single" {
+		t.Fatalf("Expected the album's 3 chapters, got %v", listed)
+	}
+
+	response, _ := server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId, Link: link,
+		Chapter: 4})
+	if response.Success || response.Message != ErrNoChapter.Error() {
+		t.Errorf("Expected a chapter past the last one to be turned away, got %v", response)
+	}
+
+	response, _ = server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId, Link: link})
+	if response.Success {
+		t.Errorf("Expected the whole album to be held to the length cap, got %v", response)
+	}
+
+	for _, chapter := range []uint32{2, 3} {
+		response, _ = server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId, Link: link,
+			Chapter: chapter})
+		if !response.Success {
+			t.Fatalf("Expected chapter %d to be queued, got %v", chapter, response)
+		}
+	}
+
+	queued := server.queueMgr.GetPlaylist().Songs
+	if len(queued) != 2 {
+		t.Fatalf("Expected both chapters to be queued as songs of their own, got %v", queued)
+	}
+
+	song := queued[0]
+	if song.Title != "Band - Single" || song.Chapter != 2 || song.StartAt != 250 || song.EndAt != 545 ||
+		song.Metadata.Duration != "PT4M55S" || len(song.Metadata.Chapters) != 0 {
+		t.Errorf("Expected the second chapter, got %v", song)
+	}
+
+	server.writes.flush()
+	stored, err := server.dbManager.GetSongById(song.SongId)
+	if err != nil || stored.Chapter != 2 || stored.StartAt != 250 || stored.EndAt != 545 {
+		t.Errorf("Expected the chapter's offsets to be saved with the song, got %v, %v", stored, err)
+	}
+}
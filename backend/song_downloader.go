@@ -0,0 +1,267 @@
+/*
+ * Pre-fetches the audio of upcoming songs into a local cache directory so the
+ * players can keep going through a flaky internet connection. The cache is
+ * bounded in size and the least recently used files are evicted first.
+ */
+
+package backend
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	downloaderCommand = "yt-dlp" // external program used to download audio
+	prefetchCount     = 3        // number of upcoming songs to keep cached
+	hintCount         = 3        // number of upcoming songs players are told about
+	downloadBacklog   = 16       // number of download requests that may wait
+)
+
+/*
+ * A song's audio file stored in the cache directory
+ */
+type cacheEntry struct {
+	path       string    // location of the audio file
+	size       int64     // size of the file in bytes
+	lastAccess time.Time // last time the file was handed to a player
+}
+
+/*
+ * Downloads songs in the background and keeps track of the cached files
+ */
+type songDownloader struct {
+	enabled   bool                   // true if a cache directory was given
+	cacheDir  string                 // directory holding the cached files
+	maxBytes  int64                  // upper bound on the size of the cache
+	usedBytes int64                  // current size of the cache
+	entries   map[string]*cacheEntry // service id -> cached file
+	pending   map[string]bool        // service ids being downloaded
+	requests  chan *cmpb.Song        // songs waiting to be downloaded
+	lock      sync.Mutex             // lock on the cache state
+}
+
+/*
+ * Initialize the downloader. Caching is disabled if the cache directory is
+ * empty. Files left behind in the cache directory by a previous run are
+ * picked back up.
+ */
+func (d *songDownloader) init(cacheDir string, maxBytes int64) {
+	d.entries = make(map[string]*cacheEntry)
+	d.pending = make(map[string]bool)
+
+	if cacheDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		log.Printf("Failed to create cache directory %s, caching disabled: %v", cacheDir, err)
+		return
+	}
+
+	d.enabled = true
+	d.cacheDir = cacheDir
+	d.maxBytes = maxBytes
+	d.requests = make(chan *cmpb.Song, downloadBacklog)
+	d.loadCacheDir()
+
+	go func() {
+		for song := range d.requests {
+			d.download(song)
+		}
+	}()
+
+	log.Printf("Caching songs in %s: {used: %d bytes, limit: %d bytes}", cacheDir, d.usedBytes, maxBytes)
+}
+
+/*
+ * Stop the background download worker
+ */
+func (d *songDownloader) stop() {
+	if d.enabled {
+		close(d.requests)
+	}
+}
+
+/*
+ * Queue up downloads for the songs at the front of the given playlist that
+ * aren't cached yet
+ */
+func (d *songDownloader) prefetch(songs []*cmpb.Song) {
+	if !d.enabled {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i := 0; i < len(songs) && i < prefetchCount; i++ {
+		song := songs[i]
+		if song.Service != cmpb.ServiceType_Youtube {
+			continue
+		}
+
+		if _, cached := d.entries[song.ServiceId]; cached || d.pending[song.ServiceId] {
+			continue
+		}
+
+		select {
+		case d.requests <- song:
+			d.pending[song.ServiceId] = true
+		default:
+			// the song will be requested again the next time the queue changes
+			log.Printf("Download backlog is full, skipping prefetch of %s", song.ServiceId)
+		}
+	}
+}
+
+/*
+ * Returns the path to the cached audio of the song. An empty string is
+ * returned if the song isn't cached.
+ */
+func (d *songDownloader) lookup(song *cmpb.Song) string {
+	if !d.enabled || song == nil {
+		return ""
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	entry, exists := d.entries[song.ServiceId]
+	if !exists {
+		return ""
+	}
+
+	entry.lastAccess = time.Now()
+	return entry.path
+}
+
+/*
+ * Returns hints about the songs at the front of the given playlist so the
+ * players can get them ready ahead of time
+ */
+func (d *songDownloader) hints(songs []*cmpb.Song) []*bepb.PrefetchHint {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	hints := make([]*bepb.PrefetchHint, 0, hintCount)
+	for i := 0; i < len(songs) && i < hintCount; i++ {
+		hint := &bepb.PrefetchHint{Song: songs[i]}
+		if entry, cached := d.entries[songs[i].ServiceId]; cached {
+			hint.LocalPath = entry.path
+		}
+		hints = append(hints, hint)
+	}
+
+	return hints
+}
+
+/*
+ * Download the audio of a single song into the cache directory
+ */
+func (d *songDownloader) download(song *cmpb.Song) {
+	defer func() {
+		d.lock.Lock()
+		delete(d.pending, song.ServiceId)
+		d.lock.Unlock()
+	}()
+
+	link := links.WatchLink(song.ServiceId)
+	output := filepath.Join(d.cacheDir, song.ServiceId+".%(ext)s")
+	cmd := exec.Command(downloaderCommand, "--quiet", "--no-playlist", "--format", "bestaudio",
+		"--output", output, link)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Failed to download %s: {error: %v, output: %s}", song.ServiceId, err, out)
+		return
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(d.cacheDir, song.ServiceId+".*"))
+	if len(matches) == 0 {
+		log.Printf("Download of %s finished, but no file was written", song.ServiceId)
+		return
+	}
+
+	info, err := os.Stat(matches[0])
+	if err != nil {
+		log.Printf("Failed to stat downloaded file %s: %v", matches[0], err)
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.entries[song.ServiceId] = &cacheEntry{path: matches[0], size: info.Size(), lastAccess: time.Now()}
+	d.usedBytes += info.Size()
+	log.Printf("Cached song: {id: %s, file: %s, size: %d bytes}", song.ServiceId, matches[0], info.Size())
+
+	d.evict(song.ServiceId)
+}
+
+/*
+ * Remove the least recently used files until the cache fits within its size
+ * limit. The entry identified by keep is never evicted. Assumes the caller
+ * holds the lock.
+ */
+func (d *songDownloader) evict(keep string) {
+	for d.usedBytes > d.maxBytes {
+		oldestId := ""
+		var oldest *cacheEntry
+
+		for id, entry := range d.entries {
+			if id != keep && (oldest == nil || entry.lastAccess.Before(oldest.lastAccess)) {
+				oldestId = id
+				oldest = entry
+			}
+		}
+
+		if oldest == nil {
+			return
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to evict cached file %s: %v", oldest.path, err)
+		}
+
+		d.usedBytes -= oldest.size
+		delete(d.entries, oldestId)
+		log.Printf("Evicted cached song: %s", oldestId)
+	}
+}
+
+/*
+ * Index the files already present in the cache directory
+ */
+func (d *songDownloader) loadCacheDir() {
+	files, err := ioutil.ReadDir(d.cacheDir)
+	if err != nil {
+		log.Printf("Failed to read cache directory %s: %v", d.cacheDir, err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".part") {
+			continue
+		}
+
+		id := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		d.entries[id] = &cacheEntry{
+			path:       filepath.Join(d.cacheDir, file.Name()),
+			size:       file.Size(),
+			lastAccess: file.ModTime(),
+		}
+		d.usedBytes += file.Size()
+	}
+
+	d.evict("")
+}
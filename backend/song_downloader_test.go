@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Create a cache directory holding one file per id, each one older than the
+ * next
+ */
+func setupCacheDir(t *testing.T, ids []string, size int) string {
+	dir, err := ioutil.TempDir("", "ytbox_cache")
+	if err != nil {
+		t.Fatalf("Failed to create cache directory: %v", err)
+	}
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range ids {
+		path := filepath.Join(dir, id+".webm")
+		ioutil.WriteFile(path, make([]byte, size), 0644)
+		modTime := start.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	return dir
+}
+
+func TestDownloaderInit_whenOverLimit_evictsOldest(t *testing.T) {
+	dir := setupCacheDir(t, []string{"oldest", "middle", "newest"}, 10)
+	defer os.RemoveAll(dir)
+
+	downloader := new(songDownloader)
+	downloader.init(dir, 20)
+	defer downloader.stop()
+
+	if downloader.usedBytes != 20 {
+		t.Errorf("Cache should use 20 bytes, but used %d", downloader.usedBytes)
+	}
+
+	if path := downloader.lookup(&cmpb.Song{ServiceId: "oldest"}); path != "" {
+		t.Errorf("Oldest song should have been evicted, but found %s", path)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "oldest.webm")); !os.IsNotExist(err) {
+		t.Errorf("Evicted file should have been deleted")
+	}
+
+	if path := downloader.lookup(&cmpb.Song{ServiceId: "newest"}); path == "" {
+		t.Errorf("Newest song should still be cached")
+	}
+}
+
+func TestDownloaderLookup_whenDisabled_returnsEmpty(t *testing.T) {
+	downloader := new(songDownloader)
+	downloader.init("", 0)
+
+	if path := downloader.lookup(&cmpb.Song{ServiceId: "newest"}); path != "" {
+		t.Errorf("Disabled downloader should not return a path, but returned %s", path)
+	}
+}
+
+func TestDownloaderHints_includesCachedPaths(t *testing.T) {
+	dir := setupCacheDir(t, []string{"cached"}, 10)
+	defer os.RemoveAll(dir)
+
+	downloader := new(songDownloader)
+	downloader.init(dir, 100)
+	defer downloader.stop()
+
+	songs := []*cmpb.Song{{ServiceId: "cached"}, {ServiceId: "a"}, {ServiceId: "b"}, {ServiceId: "c"}}
+	hints := downloader.hints(songs)
+
+	if len(hints) != hintCount {
+		t.Fatalf("Expected %d hints, but got %d", hintCount, len(hints))
+	}
+
+	if hints[0].LocalPath != filepath.Join(dir, "cached.webm") || hints[1].LocalPath != "" {
+		t.Errorf("Only the cached song should have a local path, but got %v", hints)
+	}
+
+	if hints[2].Song != songs[2] {
+		t.Errorf("Hints should be in queue order, but got %v", hints)
+	}
+}
@@ -10,61 +10,91 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/dhowden/tag"
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
-var (
-	// match absolute paths to mp3 or flac files
-	validFile = regexp.MustCompile(`(^\/).*\.(mp3|flac)$`)
-
-	// match youtube links
-	validYt = regexp.MustCompile(`^(https?://)?(www\.)?(m\.)?(youtube\.com|youtu\.be)(\S+)$`)
+const (
+	descriptionLength = 280  // maximum number of characters kept from a description
+	localizedLanguage = "en" // language video titles are localized to, standing in for romanized titles
+)
 
-	// match the full length youtube url
-	fullYoutubeLink = regexp.MustCompile(`^(https?://)?(www\.)?(m\.)?youtube\.com/watch(\S+)$`)
+var ErrNoSearchResults = errors.New("No songs matched the search")
 
-	// match the shortened youtube url
-	shortYoutubeLink = regexp.MustCompile(`^(https?://)?(www\.)?youtu\.be/(\S+)$`)
+type SongFetcher struct {
+	ytService *youtube.Service // client of the YouTube api
+	region    string           // region the players are in, for region blocked videos
+	timeout   time.Duration    // how long each request to the YouTube api may take
+}
 
-	// full length youtube url uses a query parameter
-	videoQueryParam = regexp.MustCompile(`v=[A-Za-z0-9_\-]+`)
+func (fetcher *SongFetcher) init(apiKey string, region string, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
 
-	// shortened youtube url uses a path parameter
-	videoPathParam = regexp.MustCompile(`be/[A-Za-z0-9_\-]+`)
-)
+	fetcher.ytService, _ = youtube.NewService(context.Background(), option.WithAPIKey(apiKey))
+	fetcher.region = strings.ToUpper(region)
+	fetcher.timeout = timeout
+}
 
-type SongFetcher struct {
-	ytService *youtube.Service
+/*
+ * Returns a context that cancels a request to the YouTube api once it runs
+ * past the timeout
+ */
+func (fetcher *SongFetcher) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), fetcher.timeout)
 }
 
-func (fetcher *SongFetcher) init(apiKey string) {
-	fetcher.ytService, _ = youtube.NewService(context.Background(), option.WithAPIKey(apiKey))
+/*
+ * Check that the YouTube api accepts the api key with a request that costs
+ * the least quota
+ */
+func (fetcher *SongFetcher) checkApiKey(ctx context.Context) error {
+	_, err := fetcher.ytService.I18nRegions.List("snippet").Context(ctx).Do()
+	return err
 }
 
-func (fetcher *SongFetcher) fetchSongData(link string, song *cmpb.Song) error {
-	if validYt.MatchString(link) {
+/*
+ * Fetch the metadata of a YouTube link with the YouTube api or of a local file
+ * from its tags
+ */
+func (fetcher *SongFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	switch links.Parse(link).Kind {
+	case links.Youtube:
 		return fetcher.fetchYoutubeSongData(link, song)
-	} else if validFile.MatchString(link) {
+	case links.LocalFile:
 		return fetcher.fetchLocalSongData(link, song)
-	} else {
-		err := errors.New(fmt.Sprintf("Unknown link submitted: %s", link))
-		return err
+	default:
+		return fmt.Errorf("%w: unknown link submitted: %s", ErrUnknownSong, link)
 	}
 }
 
-func extractVideoId(link string) string {
-	if fullYoutubeLink.MatchString(link) {
-		return strings.TrimPrefix(videoQueryParam.FindString(link), "v=")
-	} else if shortYoutubeLink.MatchString(link) {
-		return strings.TrimPrefix(videoPathParam.FindString(link), "be/")
-	} else {
-		return ""
+/*
+ * Returns the link to the medium quality thumbnail of a YouTube video
+ */
+func youtubeThumbnail(videoId string) string {
+	return fmt.Sprintf("https://i.ytimg.com/vi/%s/mqdefault.jpg", videoId)
+}
+
+/*
+ * Fill in the language of a YouTube video's title and the title the uploader
+ * localized it to, which stands in for the romanized title
+ */
+func applySnippetLanguage(snippet *youtube.VideoSnippet, song *cmpb.Song) {
+	song.Language = snippet.DefaultLanguage
+	if song.Language == "" {
+		song.Language = snippet.DefaultAudioLanguage
+	}
+
+	if snippet.Localized != nil && snippet.Localized.Title != snippet.Title {
+		song.RomanizedTitle = snippet.Localized.Title
 	}
 }
 
@@ -72,17 +102,23 @@ func extractVideoId(link string) string {
  * Fetch song data for the given link. This includes the song title, service
  * id, and service type. Currently only YouTube links are supported. Populates
  * the Song structure with the song data it retrieves. Returns an error status.
+ * Videos that are age restricted or blocked in the region are still populated,
+ * but return ErrAgeRestricted or ErrRegionBlocked.
  */
 func (fetcher *SongFetcher) fetchYoutubeSongData(link string, song *cmpb.Song) error {
-	songId := extractVideoId(link)
+	songId := links.VideoId(link)
 	if len(songId) == 0 {
 		log.Printf("Failed to extract id from link: %s\n", link)
-		return errors.New("Failed to extract song id")
+		return fmt.Errorf("%w: failed to extract song id", ErrUnknownSong)
 	}
 
+	ctx, cancel := fetcher.requestContext()
+	defer cancel()
+
 	request := fetcher.ytService.Videos.List("snippet,contentDetails")
 	request.Id(songId)
-	response, err := request.Do()
+	request.Hl(localizedLanguage)
+	response, err := request.Context(ctx).Do()
 
 	if err != nil {
 		log.Printf("Failed to fetch song data for %s with error: %s\n", songId, err.Error())
@@ -92,18 +128,97 @@ func (fetcher *SongFetcher) fetchYoutubeSongData(link string, song *cmpb.Song) e
 	if len(response.Items) > 0 {
 		item := response.Items[0]
 		song.Title = item.Snippet.Title
+		song.Artist = item.Snippet.ChannelTitle
 		song.ServiceId = songId
 		song.Service = cmpb.ServiceType_Youtube
 		song.Metadata = &cmpb.Metadata{
-			Thumbnail: fmt.Sprintf("https://i.ytimg.com/vi/%s/mqdefault.jpg", songId),
+			Thumbnail: youtubeThumbnail(songId),
 			Duration:  item.ContentDetails.Duration,
 		}
+		song.Metadata.Chapters = parseChapters(item.Snippet.Description, durationSeconds(song))
+		applySnippetLanguage(item.Snippet, song)
 
 		return nil
 	}
 
 	log.Printf("Did not get proper metadata from youtube: %v", response)
-	return errors.New("Failed to fetch song metadata")
+	return fmt.Errorf("%w: no video with id %s", ErrUnknownSong, songId)
+}
+
+/*
+ * Search YouTube for videos matching the query. Returns up to maxResults
+ * songs populated with the same data as a submitted link, best match first.
+ * Videos that wouldn't play are left out.
+ */
+func (fetcher *SongFetcher) searchYoutube(query string, maxResults int64) ([]*cmpb.Song, error) {
+	ctx, cancel := fetcher.requestContext()
+	defer cancel()
+
+	search := fetcher.ytService.Search.List("id")
+	search.Q(query)
+	search.Type("video")
+	search.MaxResults(maxResults)
+	results, err := search.Context(ctx).Do()
+
+	if err != nil {
+		log.Printf("Failed to search for %s with error: %s\n", query, err.Error())
+		return nil, errors.New("Failed to search for songs")
+	}
+
+	ids := make([]string, 0, len(results.Items))
+	for _, item := range results.Items {
+		if item.Id != nil && item.Id.VideoId != "" {
+			ids = append(ids, item.Id.VideoId)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, ErrNoSearchResults
+	}
+
+	// the search results don't include durations, so look the videos up
+	request := fetcher.ytService.Videos.List("snippet,contentDetails")
+	request.Id(strings.Join(ids, ","))
+	request.Hl(localizedLanguage)
+	response, err := request.Context(ctx).Do()
+
+	if err != nil {
+		log.Printf("Failed to fetch search results for %s with error: %s\n", query, err.Error())
+		return nil, errors.New("Failed to fetch song metadata")
+	}
+
+	videos := make(map[string]*youtube.Video, len(response.Items))
+	for _, item := range response.Items {
+		videos[item.Id] = item
+	}
+
+	// keep the order of the search results
+	songs := make([]*cmpb.Song, 0, len(ids))
+	for _, id := range ids {
+		item, exists := videos[id]
+		if !exists || checkRestrictions(item.ContentDetails, fetcher.region) != nil {
+			continue
+		}
+
+		song := &cmpb.Song{
+			Title:     item.Snippet.Title,
+			Artist:    item.Snippet.ChannelTitle,
+			ServiceId: id,
+			Service:   cmpb.ServiceType_Youtube,
+			Metadata: &cmpb.Metadata{
+				Thumbnail: youtubeThumbnail(id),
+				Duration:  item.ContentDetails.Duration,
+			},
+		}
+		applySnippetLanguage(item.Snippet, song)
+		songs = append(songs, song)
+	}
+
+	if len(songs) == 0 {
+		return nil, ErrNoSearchResults
+	}
+
+	return songs, nil
 }
 
 /*
@@ -124,8 +239,114 @@ func (fetcher *SongFetcher) fetchLocalSongData(link string, song *cmpb.Song) err
 	}
 
 	song.Title = fmt.Sprintf("%s - %s", tags.Artist(), tags.Title())
+	song.Artist = tags.Artist()
 	song.ServiceId = link
 	song.Service = cmpb.ServiceType_Local
 
 	return nil
 }
+
+/*
+ * Fetch the extended metadata of a song that was already submitted. Populates
+ * the details structure with the data it retrieves. Returns an error status.
+ */
+func (fetcher *SongFetcher) fetchSongDetails(song *cmpb.Song, details *bepb.SongDetails) error {
+	switch song.Service {
+	case cmpb.ServiceType_Youtube:
+		return fetcher.fetchYoutubeSongDetails(song.ServiceId, details)
+	case cmpb.ServiceType_Local:
+		return fetcher.fetchLocalSongDetails(song.ServiceId, details)
+	default:
+		return errors.New(fmt.Sprintf("Details are not supported for service: %v", song.Service))
+	}
+}
+
+/*
+ * Fetch the description, channel, view count, and thumbnails of a YouTube
+ * video
+ */
+func (fetcher *SongFetcher) fetchYoutubeSongDetails(videoId string, details *bepb.SongDetails) error {
+	ctx, cancel := fetcher.requestContext()
+	defer cancel()
+
+	request := fetcher.ytService.Videos.List("snippet,statistics")
+	request.Id(videoId)
+	response, err := request.Context(ctx).Do()
+
+	if err != nil {
+		log.Printf("Failed to fetch song details for %s with error: %s\n", videoId, err.Error())
+		return errors.New("Failed to fetch song details")
+	}
+
+	if len(response.Items) == 0 {
+		log.Printf("Did not get proper details from youtube: %v", response)
+		return fmt.Errorf("%w: no video with id %s", ErrUnknownSong, videoId)
+	}
+
+	item := response.Items[0]
+	details.Description = truncateDescription(item.Snippet.Description)
+	details.Channel = item.Snippet.ChannelTitle
+	if item.Statistics != nil {
+		details.ViewCount = item.Statistics.ViewCount
+	}
+
+	if thumbnails := item.Snippet.Thumbnails; thumbnails != nil {
+		details.Thumbnails = appendThumbnail(details.Thumbnails, "default", thumbnails.Default)
+		details.Thumbnails = appendThumbnail(details.Thumbnails, "medium", thumbnails.Medium)
+		details.Thumbnails = appendThumbnail(details.Thumbnails, "high", thumbnails.High)
+		details.Thumbnails = appendThumbnail(details.Thumbnails, "standard", thumbnails.Standard)
+		details.Thumbnails = appendThumbnail(details.Thumbnails, "maxres", thumbnails.Maxres)
+	}
+
+	return nil
+}
+
+/*
+ * Read the artist and album out of a local mp3 or flac file
+ */
+func (fetcher *SongFetcher) fetchLocalSongDetails(path string, details *bepb.SongDetails) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to read file %s: %v", path, err)
+		return err
+	}
+	defer file.Close()
+
+	tags, err := tag.ReadFrom(file)
+	if err != nil {
+		log.Printf("Failed to parse tags: %v", err)
+		return err
+	}
+
+	details.Channel = tags.Artist()
+	details.Description = truncateDescription(tags.Album())
+	return nil
+}
+
+/*
+ * Add a YouTube thumbnail to the list if the video has one of that quality
+ */
+func appendThumbnail(list []*bepb.Thumbnail, quality string, thumbnail *youtube.Thumbnail) []*bepb.Thumbnail {
+	if thumbnail == nil || thumbnail.Url == "" {
+		return list
+	}
+
+	return append(list, &bepb.Thumbnail{
+		Quality: quality,
+		Url:     thumbnail.Url,
+		Width:   uint32(thumbnail.Width),
+		Height:  uint32(thumbnail.Height),
+	})
+}
+
+/*
+ * Shorten a description down to a snippet that fits in a detail card
+ */
+func truncateDescription(description string) string {
+	runes := []rune(strings.TrimSpace(description))
+	if len(runes) > descriptionLength {
+		return string(runes[0:descriptionLength]) + "…"
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,135 @@
+/*
+ * Matches up uploads of the same track on different services, like a
+ * SoundCloud upload and the YouTube video of it. Their ids have nothing in
+ * common and their titles are rarely written the same, so tracks are matched
+ * by the words of their cleaned up titles and by their lengths.
+ */
+
+package backend
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rickb777/date/period"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	matchDurationTolerance = 5.0 // most seconds the lengths of the same track differ by across services
+	matchMinWords          = 2   // fewest title words needed to match a track by its title
+	matchMinOverlap        = 0.8 // share of the shorter title's words the other title must have
+)
+
+/*
+ * Words that don't tell tracks apart, like the "feat." before a guest or the
+ * "Topic" of auto generated YouTube channels
+ */
+var matchIgnoredWords = map[string]bool{
+	"the": true, "a": true, "an": true, "and": true, "x": true, "ft": true, "feat": true, "featuring": true,
+	"prod": true, "topic": true, "official": true, "audio": true, "video": true, "music": true,
+	"lyrics": true, "lyric": true, "visualizer": true, "hd": true, "hq": true,
+}
+
+/*
+ * Words that mark a different take on a track. Titles only match if they
+ * have the same ones, so a remix isn't mistaken for the original.
+ */
+var matchVersionWords = map[string]bool{
+	"remix": true, "live": true, "acoustic": true, "cover": true, "instrumental": true, "karaoke": true,
+	"edit": true, "mix": true, "extended": true, "nightcore": true, "slowed": true, "sped": true,
+	"reverb": true, "bootleg": true, "demo": true,
+}
+
+/*
+ * Returns true if two songs on different services are likely the same track.
+ * Their titles must share most of their words, ignoring words like "Official
+ * Audio" and an artist missing from one of them, and their lengths must be
+ * within a few seconds of each other. Songs with unknown lengths never match.
+ */
+func sameTrack(a *cmpb.Song, b *cmpb.Song) bool {
+	if a.Service == b.Service {
+		return false
+	}
+
+	lengthA, okA := knownLength(a)
+	lengthB, okB := knownLength(b)
+	if !okA || !okB || lengthA-lengthB > matchDurationTolerance || lengthB-lengthA > matchDurationTolerance {
+		return false
+	}
+
+	wordsA, versionsA := trackWords(matchTitle(a))
+	wordsB, versionsB := trackWords(matchTitle(b))
+	if versionsA != versionsB {
+		return false
+	}
+
+	shorter, longer := wordsA, wordsB
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+
+	if len(shorter) < matchMinWords {
+		return false
+	}
+
+	shared := 0
+	for word := range shorter {
+		if longer[word] {
+			shared++
+		}
+	}
+
+	return float64(shared) >= matchMinOverlap*float64(len(shorter))
+}
+
+/*
+ * Returns the title of a song to match on. Cleaned titles are preferred,
+ * since they're free of noise like "[HD]".
+ */
+func matchTitle(song *cmpb.Song) string {
+	if song.CleanTitle != "" {
+		return song.CleanTitle
+	}
+
+	return song.Title
+}
+
+/*
+ * Returns the length of a song in seconds, or false if it isn't known
+ */
+func knownLength(song *cmpb.Song) (float64, bool) {
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil || duration.IsZero() {
+		return 0, false
+	}
+
+	return duration.DurationApprox().Seconds(), true
+}
+
+/*
+ * Split a title into the words that tell tracks apart and the version words
+ * it has, which are sorted and joined so they can be compared
+ */
+func trackWords(title string) (map[string]bool, string) {
+	words := make(map[string]bool)
+	var versions []string
+
+	fields := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range fields {
+		switch {
+		case matchVersionWords[word]:
+			versions = append(versions, word)
+		case !matchIgnoredWords[word]:
+			words[word] = true
+		}
+	}
+
+	sort.Strings(versions)
+	return words, strings.Join(versions, " ")
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func track(service cmpb.ServiceType, title string, duration string) *cmpb.Song {
+	song := &cmpb.Song{Title: title, Service: service, ServiceId: title, Metadata: &cmpb.Metadata{Duration: duration}}
+	applyTitle(song, false)
+	return song
+}
+
+func TestSameTrack(t *testing.T) {
+	youtube := track(cmpb.ServiceType_Youtube, "Daft Punk - One More Time (Official Audio)", "PT5M20S")
+
+	matches := []struct {
+		song     *cmpb.Song
+		expected bool
+	}{
+		{track(cmpb.ServiceType_Web, "One More Time", "PT5M22S"), true},
+		{track(cmpb.ServiceType_Web, "daft punk | one more time", "PT5M18S"), true},
+		{track(cmpb.ServiceType_Web, "One More Time", "PT6M10S"), false},
+		{track(cmpb.ServiceType_Web, "One More Time", ""), false},
+		{track(cmpb.ServiceType_Web, "One More Time (Remix)", "PT5M20S"), false},
+		{track(cmpb.ServiceType_Web, "Daft Punk - Aerodynamic", "PT5M20S"), false},
+		{track(cmpb.ServiceType_Web, "Time", "PT5M20S"), false},
+		{track(cmpb.ServiceType_Youtube, "One More Time", "PT5M20S"), false},
+	}
+
+	for _, match := range matches {
+		if same := sameTrack(youtube, match.song); same != match.expected {
+			t.Errorf("sameTrack(%q, %q) = %t, expected %t", youtube.Title, match.song.Title, same,
+				match.expected)
+		}
+	}
+}
+
+func TestSameSong_acrossServices(t *testing.T) {
+	youtube := track(cmpb.ServiceType_Youtube, "Daft Punk - One More Time [HD]", "PT5M20S")
+	soundcloud := track(cmpb.ServiceType_Web, "One More Time", "PT5M21S")
+
+	if !sameSong(youtube, soundcloud) || !sameSong(soundcloud, youtube) {
+		t.Errorf("Expected uploads of the same track on different services to be the same song")
+	}
+}
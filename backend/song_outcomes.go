@@ -0,0 +1,101 @@
+/*
+ * Records how each song that played came to an end: played to its end, voted
+ * off, skipped by an admin or a bot, failed to play or cut off for a scheduled
+ * song. Skips remember who made them, the caller who skipped the song or the
+ * users who voted it off, so the history can answer who keeps skipping whose
+ * songs.
+ */
+
+package backend
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const defaultHistorySongs = 50 // songs of the history fetched when no limit is asked for
+
+/*
+ * Lists every outcome with the number of songs that ended that way, in the
+ * order of the outcomes
+ */
+func outcomeCounts(counts map[bepb.SongOutcome]uint32) []*bepb.OutcomeCount {
+	outcomes := make([]*bepb.OutcomeCount, 0, len(bepb.SongOutcome_name))
+	for outcome := range bepb.SongOutcome_name {
+		outcomes = append(outcomes, &bepb.OutcomeCount{
+			Outcome: bepb.SongOutcome(outcome),
+			Count:   counts[bepb.SongOutcome(outcome)],
+		})
+	}
+
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].Outcome < outcomes[j].Outcome
+	})
+	return outcomes
+}
+
+/*
+ * Get the recently submitted songs, of everyone or of one user, and how each
+ * one ended, newest first
+ */
+func (s *BackendServer) GetHistory(con context.Context, request *bepb.HistoryRequest) (*bepb.HistoryList, error) {
+	response := &bepb.HistoryList{Err: &bepb.Error{Success: false}}
+
+	limit := int(request.GetLimit())
+	if limit == 0 {
+		limit = defaultHistorySongs
+	}
+
+	history, err := s.dbFor(con).GetHistory(request.GetUserId(), limit)
+	if err != nil {
+		log.Printf("Failed to get the history: %v", err)
+		response.Err.Message = "Failed to get the history."
+		return response, nil
+	}
+
+	response.Songs = make([]*bepb.HistorySong, 0, len(history))
+	for _, entry := range history {
+		song := entry.Song
+		hideSubmitter(&song)
+		response.Songs = append(response.Songs, &bepb.HistorySong{
+			Song:      &song,
+			Submitted: entry.Date.Unix(),
+			Skipped:   entry.Skipped,
+			Outcome:   entry.Outcome,
+			Voters:    entry.Voters,
+			SkippedBy: entry.SkippedBy,
+		})
+	}
+
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
+
+/*
+ * Get how a user's songs ended and who skipped them the most
+ */
+func (s *BackendServer) GetSkipReport(con context.Context, user *bepb.User) (*bepb.SkipReport, error) {
+	response := &bepb.SkipReport{Err: &bepb.Error{Success: false}}
+
+	counts, err := s.dbFor(con).GetOutcomeCounts(user.GetUserId())
+	if err != nil {
+		log.Printf("Failed to get the song outcomes of user %d: %v", user.GetUserId(), err)
+		response.Err.Message = "Failed to get how the user's songs ended."
+		return response, nil
+	}
+
+	skippers, err := s.dbFor(con).GetSkippers(user.GetUserId())
+	if err != nil {
+		log.Printf("Failed to get the skippers of user %d: %v", user.GetUserId(), err)
+		response.Err.Message = "Failed to get who skipped the user's songs."
+		return response, nil
+	}
+
+	response.Outcomes = outcomeCounts(counts)
+	response.Skippers = skippers
+	response.Err = &bepb.Error{Success: true, Message: "Success"}
+	return response, nil
+}
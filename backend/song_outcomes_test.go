@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestSkips_recordOutcomeAndWhoSkipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_outcomes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+	server.playerMgr.fanOut = make(chan *bepb.PlayerControl, 4)
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	zedd, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	kahlan, _ := server.dbManager.AddUser("Kahlan", room.Room.Id)
+
+	songs := make([]*cmpb.Song, 2)
+	for i := range songs {
+		songs[i] = &cmpb.Song{Title: "Song", ServiceId: string(rune('a' + i)), UserId: zedd.User.UserId,
+			RoomId: room.Room.Id}
+		if err = server.dbManager.AddSong(songs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first song is voted off
+	server.queueMgr.SetNowPlaying(songs[0])
+	result, _ := server.VoteSkip(context.Background(), &bepb.SkipVote{UserId: kahlan.User.UserId})
+	if !result.Skipped {
+		t.Fatalf("Expected the vote to skip the song, got %v", result)
+	}
+
+	// the second is skipped by a bot
+	key, err := server.apiKeys.create("bot", bepb.ApiKeyScope_SubmitOnlyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.queueMgr.SetNowPlaying(songs[1])
+	server.NextSong(apiKeyContext(key.Key), &cmpb.Empty{})
+	server.writes.flush()
+
+	history, _ := server.GetHistory(context.Background(), &bepb.HistoryRequest{UserId: zedd.User.UserId})
+	if !history.Err.Success || len(history.Songs) != 2 {
+		t.Fatalf("Expected both songs in the history, got %v", history)
+	}
+
+	voted, skipped := history.Songs[1], history.Songs[0]
+	if voted.Outcome != bepb.SongOutcome_SkippedByVote || len(voted.Voters) != 1 ||
+		voted.Voters[0] != kahlan.User.UserId || !voted.Skipped {
+		t.Errorf("Expected the first song voted off by Kahlan, got %v", voted)
+	}
+
+	if skipped.Outcome != bepb.SongOutcome_SkippedByAdmin || skipped.SkippedBy != "api key bot" {
+		t.Errorf("Expected the second song skipped by the bot, got %v", skipped)
+	}
+
+	report, _ := server.GetSkipReport(context.Background(), &bepb.User{UserId: zedd.User.UserId})
+	if !report.Err.Success || len(report.Skippers) != 2 {
+		t.Fatalf("Expected Kahlan and the bot to have skipped songs, got %v", report)
+	}
+
+	if counts := report.Outcomes; len(counts) != len(bepb.SongOutcome_name) ||
+		counts[bepb.SongOutcome_SkippedByVote].Count != 1 || counts[bepb.SongOutcome_SkippedByAdmin].Count != 1 {
+		t.Errorf("Expected one song voted off and one skipped, got %v", counts)
+	}
+}
+
+func TestRecordEnding_whenSongEnds_recordsOutcome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_outcomes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Lounge")
+	zedd, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	song := &cmpb.Song{Title: "Song", ServiceId: "a", UserId: zedd.User.UserId, RoomId: room.Room.Id}
+	if err = server.dbManager.AddSong(song); err != nil {
+		t.Fatal(err)
+	}
+
+	server.bus.publish(&bepb.Event{Type: bepb.EventType_SongEnded, Song: song, Outcome: bepb.SongOutcome_Errored})
+	server.writes.flush()
+
+	history, _ := server.dbManager.GetHistory(0, 1)
+	if len(history) != 1 || history[0].Outcome != bepb.SongOutcome_Errored || history[0].Skipped {
+		t.Errorf("Expected the song to have ended with an error without being skipped, got %v", history)
+	}
+}
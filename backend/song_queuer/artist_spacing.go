@@ -0,0 +1,100 @@
+/*
+ * Spaces out songs by the same artist. When the song at the head of the queue
+ * is by an artist played too recently, the first queued song by another
+ * artist plays ahead of it. The deferred song keeps its place and plays once
+ * its artist's gap has passed.
+ */
+
+package song_queue
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * When an artist was last played and by which song
+ */
+type artistPlay struct {
+	songId uint32    // id of the song that was played
+	at     time.Time // when the song came off the queue
+}
+
+/*
+ * Remembers when each artist was last played. Shared by managers sharing a
+ * queue and guarded by the queue's lock.
+ */
+type artistSpacing struct {
+	gap    time.Duration          // shortest time between songs by one artist. Zero turns spacing off
+	played map[string]*artistPlay // artist key -> their latest play
+}
+
+/*
+ * Sets the shortest time between two songs by the same artist. Zero lets songs
+ * by one artist play back to back.
+ */
+func (manager *SongQueueManager) SetArtistGap(gap time.Duration) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.spacing.gap = gap
+}
+
+/*
+ * Pops the first song whose artist wasn't played within the gap. If every
+ * queued song would break the gap, the head of the queue plays anyway rather
+ * than leaving the room in silence. Must be called under the queue's write
+ * lock with songs queued.
+ */
+func (manager *SongQueueManager) popSpaced(now time.Time) *cmpb.Song {
+	if manager.spacing.gap > 0 {
+		head := manager.queue.front().value()
+		for elem := manager.queue.front(); elem != nil; elem = elem.next() {
+			song := elem.value()
+			if manager.spacing.allows(song, now) {
+				if song != head && manager.queue.promote(song.SongId) == nil {
+					log.Printf("Deferred %s to space out songs by %q", head.ServiceId, head.Artist)
+				}
+				break
+			}
+		}
+	}
+
+	song := manager.queue.pop()
+	if key := artistKey(song); key != "" {
+		manager.spacing.played[key] = &artistPlay{songId: song.SongId, at: now}
+	}
+
+	return song
+}
+
+/*
+ * Forgets that a song was played, such as when it goes back to the queue
+ * without having been listened to. Must be called under the queue's write
+ * lock.
+ */
+func (manager *SongQueueManager) unplayArtist(song *cmpb.Song) {
+	key := artistKey(song)
+	if last, exists := manager.spacing.played[key]; exists && last.songId == song.SongId {
+		delete(manager.spacing.played, key)
+	}
+}
+
+/*
+ * Returns true if the song's artist wasn't played within the gap. Songs with
+ * an unknown artist are always allowed.
+ */
+func (spacing *artistSpacing) allows(song *cmpb.Song, now time.Time) bool {
+	last, exists := spacing.played[artistKey(song)]
+	return !exists || now.Sub(last.at) >= spacing.gap
+}
+
+/*
+ * Returns the artist of the song in lower case without spaces, so a channel
+ * named "RickAstley" matches a title naming "Rick Astley". Empty if unknown.
+ */
+func artistKey(song *cmpb.Song) string {
+	return strings.ToLower(strings.Join(strings.Fields(song.GetArtist()), ""))
+}
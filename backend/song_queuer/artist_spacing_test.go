@@ -0,0 +1,111 @@
+package song_queue
+
+import (
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Returns a queue manager over a fifo queue of songs by the artists, with ids
+ * counting up from one
+ */
+func spacedQueue(gap time.Duration, artists ...string) *SongQueueManager {
+	manager := new(SongQueueManager)
+	manager.Init(NewFifoQueuer())
+	manager.SetArtistGap(gap)
+
+	for i, artist := range artists {
+		manager.AddSong(&cmpb.Song{SongId: uint32(i + 1), UserId: 1, Artist: artist,
+			Service: cmpb.ServiceType_Youtube})
+	}
+
+	return manager
+}
+
+/*
+ * Pops songs off the queue at the times, returning their ids
+ */
+func popAt(manager *SongQueueManager, times ...time.Time) []uint32 {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	ids := make([]uint32, 0, len(times))
+	for _, now := range times {
+		ids = append(ids, manager.popSpaced(now).SongId)
+	}
+
+	return ids
+}
+
+func TestArtistKey(t *testing.T) {
+	tests := []struct {
+		artist   string
+		expected string
+	}{
+		{"Rick Astley", "rickastley"},
+		{"RickAstley", "rickastley"},
+		{"  Daft   Punk ", "daftpunk"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if key := artistKey(&cmpb.Song{Artist: test.artist}); key != test.expected {
+			t.Errorf("Expected %q for %q, but got %q", test.expected, test.artist, key)
+		}
+	}
+}
+
+func TestPopSpaced(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	minutes := func(counts ...int) []time.Time {
+		times := make([]time.Time, len(counts))
+		for i, count := range counts {
+			times[i] = start.Add(time.Duration(count) * time.Minute)
+		}
+		return times
+	}
+
+	tests := []struct {
+		name     string
+		gap      time.Duration
+		artists  []string
+		times    []time.Time
+		expected []uint32
+	}{
+		{"spacing off", 0, []string{"Muse", "Muse", "Blur"}, minutes(0, 1, 2), []uint32{1, 2, 3}},
+		{"artist within the gap", 10 * time.Minute, []string{"Muse", "Muse", "Blur"}, minutes(0, 1, 2),
+			[]uint32{1, 3, 2}},
+		{"gap passed", 10 * time.Minute, []string{"Muse", "Muse", "Blur"}, minutes(0, 10, 11), []uint32{1, 2, 3}},
+		{"only the artist left", 10 * time.Minute, []string{"Muse", "Muse"}, minutes(0, 1), []uint32{1, 2}},
+		{"unknown artists", 10 * time.Minute, []string{"", "", "Blur"}, minutes(0, 1, 2), []uint32{1, 2, 3}},
+		{"spelled differently", 10 * time.Minute, []string{"Daft Punk", "DaftPunk", "Blur"}, minutes(0, 1, 2),
+			[]uint32{1, 3, 2}},
+	}
+
+	for _, test := range tests {
+		manager := spacedQueue(test.gap, test.artists...)
+		order := popAt(manager, test.times...)
+		for i := range test.expected {
+			if order[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, but got %v", test.name, test.expected, order)
+				break
+			}
+		}
+	}
+}
+
+func TestUnplayArtist_letsArtistPlayAgain(t *testing.T) {
+	manager := spacedQueue(10*time.Minute, "Muse", "Muse", "Blur")
+	now := time.Now()
+
+	manager.lock.Lock()
+	first := manager.popSpaced(now)
+	manager.unplayArtist(first)
+	manager.lock.Unlock()
+
+	if order := popAt(manager, now, now); order[0] != 2 || order[1] != 3 {
+		t.Errorf("Expected the artist's next song to play right away, got %v", order)
+	}
+}
@@ -0,0 +1,499 @@
+/*
+ * Conformance tests every SongQueuer has to pass. They push random sequences
+ * of operations through each queuer and check the invariants the rest of the
+ * backend relies on after every step: songs are never lost or duplicated, the
+ * queue plays in the order it lists, operations only move the songs they're
+ * about and queuers with turns share them fairly. A new queuer is covered by
+ * adding it to conformingQueuers.
+ */
+
+package song_queue
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Constructors of the queuers that must pass the conformance tests
+ */
+var conformingQueuers = map[string]func() SongQueuer{
+	"fifo":        func() SongQueuer { return NewFifoQueuer() },
+	"round robin": func() SongQueuer { return NewRoundRobinQueuer() },
+	"hybrid":      func() SongQueuer { return NewHybridQueuer(DefaultHybridWeights) },
+}
+
+const (
+	conformanceRuns  = 50  // random sequences run through each queuer
+	conformanceSteps = 300 // operations in each sequence
+	conformanceUsers = 5   // users submitting songs
+	conformanceLinks = 8   // distinct videos, so some songs are queued twice
+)
+
+/*
+ * Runs a conformance check against every queuer, naming each run after its
+ * seed so a failing sequence can be replayed
+ */
+func forEachQueuer(t *testing.T, runs int, check func(t *testing.T, queuer SongQueuer, rng *rand.Rand)) {
+	for name, newQueuer := range conformingQueuers {
+		t.Run(name, func(t *testing.T) {
+			base := time.Now().UnixNano()
+			for run := 0; run < runs; run++ {
+				seed := base + int64(run)
+				ok := t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+					check(t, newQueuer(), rand.New(rand.NewSource(seed)))
+				})
+				if !ok {
+					return
+				}
+			}
+		})
+	}
+}
+
+/*
+ * Builds songs with increasing ids from random users and videos
+ */
+type songMaker struct {
+	rng    *rand.Rand
+	nextId uint32
+}
+
+func (maker *songMaker) make() *cmpb.Song {
+	maker.nextId++
+	userId := uint32(maker.rng.Intn(conformanceUsers) + 1)
+	song := &cmpb.Song{
+		Title:     fmt.Sprintf("title %d", maker.nextId),
+		SongId:    maker.nextId,
+		Username:  fmt.Sprintf("user %d", userId),
+		UserId:    userId,
+		Service:   cmpb.ServiceType_Youtube,
+		ServiceId: fmt.Sprintf("video %d", maker.rng.Intn(conformanceLinks)),
+	}
+
+	// some songs are queued on behalf of another user, who can remove them too
+	if maker.rng.Intn(5) == 0 {
+		song.ForUserId = uint32(maker.rng.Intn(conformanceUsers) + 1)
+	}
+
+	return song
+}
+
+/*
+ * Returns the ids of the queued songs in the order the queue lists them
+ */
+func listed(queuer SongQueuer) []uint32 {
+	var ids []uint32
+	for e := queuer.front(); e != nil; e = e.next() {
+		ids = append(ids, e.value().SongId)
+	}
+
+	return ids
+}
+
+/*
+ * Checks the queue holds exactly the songs in the model, each listed once and
+ * counted the same way by length and stats
+ */
+func checkContents(t *testing.T, queuer SongQueuer, queued map[uint32]*cmpb.Song, step string) {
+	t.Helper()
+
+	ids := listed(queuer)
+	if len(ids) != queuer.length() {
+		t.Fatalf("after %s: queue lists %d songs but has a length of %d", step, len(ids), queuer.length())
+	}
+
+	seen := make(map[uint32]bool)
+	buckets := make(map[uint32]int)
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("after %s: song %d is listed twice in %v", step, id, ids)
+		} else if queued[id] == nil {
+			t.Fatalf("after %s: song %d is listed but wasn't queued", step, id)
+		}
+		seen[id] = true
+		buckets[queued[id].UserId]++
+	}
+
+	if len(ids) != len(queued) {
+		for id := range queued {
+			if !seen[id] {
+				t.Fatalf("after %s: song %d was lost from the queue", step, id)
+			}
+		}
+	}
+
+	stats := queuer.stats()
+	if stats.Entries != len(ids) {
+		t.Fatalf("after %s: stats count %d songs but %d are queued", step, stats.Entries, len(ids))
+	}
+	for userId, count := range buckets {
+		if stats.Buckets[userId] != count {
+			t.Fatalf("after %s: stats count %d songs for user %d but %d are queued",
+				step, stats.Buckets[userId], userId, count)
+		}
+	}
+	for userId, count := range stats.Buckets {
+		if count != buckets[userId] {
+			t.Fatalf("after %s: stats count %d songs for user %d but %d are queued", step, count, userId, buckets[userId])
+		}
+	}
+}
+
+/*
+ * Checks the songs that stayed queued kept the order they were in, leaving
+ * out the songs the operation was meant to move
+ */
+func checkStable(t *testing.T, before []uint32, after []uint32, moved map[uint32]bool, step string) {
+	t.Helper()
+
+	kept := make(map[uint32]bool)
+	for _, id := range after {
+		kept[id] = true
+	}
+
+	var was, is []uint32
+	for _, id := range before {
+		if kept[id] && !moved[id] {
+			was = append(was, id)
+		}
+	}
+	for _, id := range after {
+		if !moved[id] {
+			is = append(is, id)
+		}
+	}
+
+	if fmt.Sprint(was) != fmt.Sprint(is) {
+		t.Fatalf("after %s: queue went from %v to %v", step, before, after)
+	}
+}
+
+/*
+ * Picks a random queued song
+ */
+func pickQueued(queuer SongQueuer, rng *rand.Rand) *cmpb.Song {
+	ids := listed(queuer)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	target := ids[rng.Intn(len(ids))]
+	for e := queuer.front(); e != nil; e = e.next() {
+		if e.value().SongId == target {
+			return e.value()
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Runs random operations through a queuer, checking after each one that no
+ * song was lost or duplicated, that the queue pops in the order it lists and
+ * that the songs an operation wasn't about kept their order
+ */
+func TestConformanceRandomOperations(t *testing.T) {
+	forEachQueuer(t, conformanceRuns, func(t *testing.T, queuer SongQueuer, rng *rand.Rand) {
+		maker := &songMaker{rng: rng}
+		queued := make(map[uint32]*cmpb.Song)
+		var popped []*cmpb.Song
+
+		for step := 0; step < conformanceSteps; step++ {
+			before := listed(queuer)
+			moved := make(map[uint32]bool)
+			var name string
+
+			switch op := rng.Intn(100); {
+			case op < 40:
+				song := maker.make()
+				name = fmt.Sprintf("pushing song %d", song.SongId)
+				queuer.push(song)
+				queued[song.SongId] = song
+				moved[song.SongId] = true
+
+			case op < 60:
+				name = "popping"
+				var next *cmpb.Song
+				if front := queuer.front(); front != nil {
+					next = front.value()
+				}
+
+				song := queuer.pop()
+				if song != next {
+					t.Fatalf("popped %v but the queue listed %v first", song, next)
+				}
+				if song != nil {
+					delete(queued, song.SongId)
+					popped = append(popped, song)
+				}
+
+			case op < 70:
+				song := pickQueued(queuer, rng)
+				if song == nil {
+					continue
+				}
+
+				userId := uint32(rng.Intn(conformanceUsers) + 1)
+				name = fmt.Sprintf("user %d removing song %d", userId, song.SongId)
+				err := queuer.remove(song.SongId, userId)
+				if allowed := canRemove(song, userId); allowed && err != nil {
+					t.Fatalf("%s: %v", name, err)
+				} else if !allowed && err == nil {
+					t.Fatalf("%s: removed a song the user can't remove", name)
+				} else if allowed {
+					delete(queued, song.SongId)
+				}
+
+			case op < 75:
+				name = "removing a song that isn't queued"
+				if err := queuer.remove(maker.nextId+1, 1); err == nil {
+					t.Fatalf("%s: no error", name)
+				}
+
+			case op < 80:
+				serviceId := fmt.Sprintf("video %d", rng.Intn(conformanceLinks))
+				name = fmt.Sprintf("removing every copy of %s", serviceId)
+				copies := copiesOf(queuer, cmpb.ServiceType_Youtube, serviceId)
+				removed := queuer.removeByServiceId(cmpb.ServiceType_Youtube, serviceId)
+				if len(removed) != len(copies) {
+					t.Fatalf("%s: removed %d songs but %d were queued", name, len(removed), len(copies))
+				}
+				for _, song := range removed {
+					if song.ServiceId != serviceId || queued[song.SongId] == nil {
+						t.Fatalf("%s: removed song %v", name, song)
+					}
+					delete(queued, song.SongId)
+				}
+
+			case op < 85:
+				if len(popped) == 0 {
+					continue
+				}
+
+				song := popped[len(popped)-1]
+				popped = popped[:len(popped)-1]
+				name = fmt.Sprintf("requeueing song %d", song.SongId)
+				queuer.requeue(song)
+				queued[song.SongId] = song
+				moved[song.SongId] = true
+				if front := queuer.front(); front == nil || front.value() != song {
+					t.Fatalf("%s: the song isn't at the front of %v", name, listed(queuer))
+				}
+
+			case op < 90:
+				song := pickQueued(queuer, rng)
+				if song == nil {
+					continue
+				}
+
+				name = fmt.Sprintf("promoting song %d", song.SongId)
+				if err := queuer.promote(song.SongId); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				moved[song.SongId] = true
+				if front := queuer.front(); front == nil || front.value() != song {
+					t.Fatalf("%s: the song isn't at the front of %v", name, listed(queuer))
+				}
+
+			case op < 95:
+				userId := uint32(rng.Intn(conformanceUsers) + 1)
+				name = fmt.Sprintf("demoting user %d", userId)
+				queuer.demote(userId)
+
+				// the user's songs end up last and keep their order among themselves
+				ids := listed(queuer)
+				for i, id := range ids {
+					if queued[id].UserId == userId {
+						moved[id] = true
+					} else if i > 0 && moved[ids[i-1]] {
+						t.Fatalf("%s: song %d is behind the user's songs in %v", name, id, ids)
+					}
+				}
+				var was, is []uint32
+				for _, id := range before {
+					if moved[id] {
+						was = append(was, id)
+					}
+				}
+				for _, id := range ids {
+					if moved[id] {
+						is = append(is, id)
+					}
+				}
+				if fmt.Sprint(was) != fmt.Sprint(is) {
+					t.Fatalf("%s: the user's songs went from %v to %v", name, was, is)
+				}
+
+			default:
+				userId := uint32(rng.Intn(conformanceUsers) + 1)
+				name = fmt.Sprintf("forgetting user %d", userId)
+				queuer.forget(userId)
+			}
+
+			checkContents(t, queuer, queued, name)
+			checkStable(t, before, listed(queuer), moved, name)
+		}
+	})
+}
+
+/*
+ * Checks a song's position is the number of songs that play before it once
+ * it's pushed onto the queue
+ */
+func TestConformancePosition(t *testing.T) {
+	forEachQueuer(t, conformanceRuns, func(t *testing.T, queuer SongQueuer, rng *rand.Rand) {
+		maker := &songMaker{rng: rng}
+		for i := rng.Intn(30); i > 0; i-- {
+			queuer.push(maker.make())
+			if rng.Intn(4) == 0 {
+				queuer.pop()
+			}
+		}
+
+		song := maker.make()
+		position := queuer.position(song)
+		if position > queuer.length() {
+			t.Fatalf("position %d is past the end of a queue of %d songs", position, queuer.length())
+		}
+
+		queuer.push(song)
+		ahead := 0
+		for next := queuer.pop(); next != song; next = queuer.pop() {
+			if next == nil {
+				t.Fatalf("song %d was never popped", song.SongId)
+			}
+			ahead++
+		}
+
+		if ahead != position {
+			t.Fatalf("expected %d songs to play before song %d but %d did", position, song.SongId, ahead)
+		}
+	})
+}
+
+/*
+ * Checks queuers with turns share them fairly when no songs were voted for or
+ * moved: a user only gets another song once every other user waiting on a
+ * song got one as well
+ */
+func TestConformanceFairness(t *testing.T) {
+	forEachQueuer(t, conformanceRuns, func(t *testing.T, queuer SongQueuer, rng *rand.Rand) {
+		if _, rounds := queuer.turns(); rounds == nil {
+			t.Skip("the queuer doesn't take turns")
+		}
+
+		maker := &songMaker{rng: rng}
+		for i := rng.Intn(40) + 1; i > 0; i-- {
+			queuer.push(maker.make())
+		}
+
+		remaining := queuer.stats().Buckets
+		played := make(map[uint32]int)
+		for song := queuer.pop(); song != nil; song = queuer.pop() {
+			for userId, count := range remaining {
+				if userId != song.UserId && count > 0 && played[song.UserId] > played[userId] {
+					t.Fatalf("user %d got song %d ahead of user %d, who's waiting with %d songs played to their %d",
+						song.UserId, song.SongId, userId, played[userId], played[song.UserId])
+				}
+			}
+			played[song.UserId]++
+			remaining[song.UserId]--
+		}
+	})
+}
+
+/*
+ * Adds, removes and pops songs from many goroutines at once through a queue
+ * manager, while the queuer is swapped out underneath, and checks every song
+ * ended up either popped, removed or still queued, and only one of them
+ */
+func TestConformanceConcurrent(t *testing.T) {
+	const (
+		writers = 8
+		songs   = 200
+	)
+
+	for name, newQueuer := range conformingQueuers {
+		t.Run(name, func(t *testing.T) {
+			manager := new(SongQueueManager)
+			manager.Init(newQueuer())
+
+			var lock sync.Mutex
+			outcomes := make(map[uint32]string)
+			record := func(songId uint32, outcome string) {
+				lock.Lock()
+				defer lock.Unlock()
+				if previous, ok := outcomes[songId]; ok {
+					t.Errorf("song %d was %s after it was already %s", songId, outcome, previous)
+				}
+				outcomes[songId] = outcome
+			}
+
+			var wait sync.WaitGroup
+			for writer := 0; writer < writers; writer++ {
+				wait.Add(1)
+				go func(userId uint32) {
+					defer wait.Done()
+					for i := 0; i < songs; i++ {
+						songId := userId*songs + uint32(i)
+						manager.AddSong(&cmpb.Song{SongId: songId, UserId: userId, ServiceId: fmt.Sprint(songId)})
+						if i%3 == 0 && manager.RemoveSong(songId, userId) == nil {
+							record(songId, "removed")
+						}
+					}
+				}(uint32(writer + 1))
+			}
+
+			done := make(chan bool)
+			var poppers sync.WaitGroup
+			for popper := 0; popper < 2; popper++ {
+				poppers.Add(1)
+				go func() {
+					defer poppers.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						if song := manager.PopQueue(); song != nil {
+							record(song.SongId, "popped")
+						}
+					}
+				}()
+			}
+
+			poppers.Add(1)
+			go func() {
+				defer poppers.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					manager.SwapQueuer(newQueuer())
+					manager.Stats()
+				}
+			}()
+
+			wait.Wait()
+			close(done)
+			poppers.Wait()
+
+			for _, song := range manager.GetPlaylist().GetSongs() {
+				record(song.SongId, "left queued")
+			}
+
+			if len(outcomes) != writers*songs {
+				t.Fatalf("accounted for %d of the %d songs added", len(outcomes), writers*songs)
+			}
+		})
+	}
+}
@@ -1,5 +1,5 @@
 /*
- * Implements a first-in-first-out songQueuer
+ * Implements a first-in-first-out SongQueuer
  */
 
 package song_queue
@@ -29,10 +29,18 @@ func (fifo *FifoQueuer) push(song *cmpb.Song) {
 	fifo.queue.PushBack(song)
 }
 
+func (fifo *FifoQueuer) requeue(song *cmpb.Song) {
+	fifo.queue.PushFront(song)
+}
+
 func (fifo *FifoQueuer) length() int {
 	return fifo.queue.Len()
 }
 
+func (fifo *FifoQueuer) position(song *cmpb.Song) int {
+	return fifo.queue.Len()
+}
+
 func (fifo *FifoQueuer) pop() *cmpb.Song {
 	if fifo.queue.Len() > 0 {
 		return fifo.queue.Remove(fifo.queue.Front()).(*cmpb.Song)
@@ -41,11 +49,11 @@ func (fifo *FifoQueuer) pop() *cmpb.Song {
 	return nil
 }
 
-func (fifo *FifoQueuer) RemoveSong(songId uint32, userId uint32) error {
+func (fifo *FifoQueuer) remove(songId uint32, userId uint32) error {
 	for e := fifo.queue.Front(); e != nil; e = e.Next() {
 		var song *cmpb.Song = e.Value.(*cmpb.Song)
 
-		if song.GetSongId() == songId && song.GetUserId() == userId {
+		if song.GetSongId() == songId && canRemove(song, userId) {
 			fifo.queue.Remove(e)
 			return nil
 		}
@@ -54,12 +62,69 @@ func (fifo *FifoQueuer) RemoveSong(songId uint32, userId uint32) error {
 	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
 }
 
-func (fifo *FifoQueuer) front() fifoElement {
-	return fifoElement{
-		current: fifo.queue.Front(),
+func (fifo *FifoQueuer) removeByServiceId(service cmpb.ServiceType, serviceId string) []*cmpb.Song {
+	copies := copiesOf(fifo, service, serviceId)
+	for _, song := range copies {
+		fifo.remove(song.SongId, song.UserId)
+	}
+
+	return copies
+}
+
+// Songs are played in the order they were submitted, so there are no turns
+// to forget
+func (fifo *FifoQueuer) forget(userId uint32) {
+}
+
+func (fifo *FifoQueuer) demote(userId uint32) {
+	var demoted []*list.Element
+	for e := fifo.queue.Front(); e != nil; e = e.Next() {
+		if e.Value.(*cmpb.Song).GetUserId() == userId {
+			demoted = append(demoted, e)
+		}
+	}
+
+	for _, e := range demoted {
+		fifo.queue.MoveToBack(e)
+	}
+}
+
+func (fifo *FifoQueuer) promote(songId uint32) error {
+	for e := fifo.queue.Front(); e != nil; e = e.Next() {
+		if e.Value.(*cmpb.Song).GetSongId() == songId {
+			fifo.queue.MoveToFront(e)
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+// Songs are played in the order they were submitted, so there's no rotation
+func (fifo *FifoQueuer) turns() (int, map[uint32]int) {
+	return 0, nil
+}
+
+// Each song has an element of its own that's freed when the song is removed,
+// so the queue never needs compacting
+func (fifo *FifoQueuer) stats() QueueStats {
+	return QueueStats{
+		Entries:  fifo.queue.Len(),
+		Buckets:  bucketsOf(fifo),
+		Capacity: fifo.queue.Len(),
 	}
 }
 
+func (fifo *FifoQueuer) front() queueElement {
+	if fifo.queue.Len() > 0 {
+		return fifoElement{
+			current: fifo.queue.Front(),
+		}
+	}
+
+	return nil
+}
+
 type fifoElement struct {
 	current *list.Element
 }
@@ -68,8 +133,12 @@ func (e fifoElement) value() *cmpb.Song {
 	return e.current.Value.(*cmpb.Song)
 }
 
-func (e fifoElement) next() fifoElement {
-	return fifoElement{
-		current: e.current.Next(),
+func (e fifoElement) next() queueElement {
+	if next := e.current.Next(); next != nil {
+		return fifoElement{
+			current: next,
+		}
 	}
+
+	return nil
 }
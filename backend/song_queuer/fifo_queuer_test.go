@@ -10,11 +10,16 @@ import (
  * List of sample song data to test against
  */
 var sampleSongs = []cmpb.Song{
-	{"title 1", 1, "Kid A", 1, cmpb.ServiceType_Youtube, "0xdeadbeef"},
-	{"title 2", 2, "Kid B", 2, cmpb.ServiceType_Youtube, "0xba5eba11"},
-	{"title 3", 3, "Kid A", 1, cmpb.ServiceType_Youtube, "0xf01dab1e"},
-	{"title 4", 4, "Kid B", 2, cmpb.ServiceType_Youtube, "0xb01dface"},
-	{"title 5", 5, "Kid A", 1, cmpb.ServiceType_Youtube, "0xca55e77e"},
+	{Title: "title 1", SongId: 1, Username: "Kid A", UserId: 1,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xdeadbeef"},
+	{Title: "title 2", SongId: 2, Username: "Kid B", UserId: 2,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xba5eba11"},
+	{Title: "title 3", SongId: 3, Username: "Kid A", UserId: 1,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xf01dab1e"},
+	{Title: "title 4", SongId: 4, Username: "Kid B", UserId: 2,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xb01dface"},
+	{Title: "title 5", SongId: 5, Username: "Kid A", UserId: 1,
+		Service: cmpb.ServiceType_Youtube, ServiceId: "0xca55e77e"},
 }
 
 /*
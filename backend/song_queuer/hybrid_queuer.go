@@ -0,0 +1,402 @@
+/*
+ * A HybridQueuer takes turns between users like the round robin queuer, but
+ * lets votes move songs ahead. Pure vote queues favor the popular friends and
+ * pure round robin ignores what the room wants, so votes only weigh in as far
+ * as the weights allow: within a user's bucket, a voted song takes the turn of
+ * the user's less voted songs, and across the rotation, a voted song moves
+ * ahead of songs in its round and, with enough votes, into earlier rounds.
+ *
+ * Votes are counted on the songs themselves while they're queued, so the
+ * order is worked out each time the queue is read instead of kept sorted.
+ */
+
+package song_queue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * How far votes move songs in the queue
+ */
+type HybridWeights struct {
+	Rotation float64 // rounds each vote moves a song ahead of other users' songs. Zero only breaks ties
+	Bucket   float64 // places each vote moves a song ahead of its submitter's other songs
+}
+
+// Four votes move a song a round ahead of other users' songs, and each vote
+// moves it a place ahead of its submitter's other songs
+var DefaultHybridWeights = HybridWeights{Rotation: 0.25, Bucket: 1}
+
+// A user submission managed by the hybrid queuer
+type hybridSubmission struct {
+	song   *cmpb.Song // a song in the queue
+	round  int        // the round of the turn the song holds in its submitter's bucket
+	time   time.Time  // time at which the turn was taken
+	pinned int        // order the song was moved to the front in. Zero if it wasn't
+}
+
+// A submission and where it plays given the votes counted so far
+type hybridPlacement struct {
+	sub   *hybridSubmission // the submission placed
+	round int               // round of the turn the song plays in
+	time  time.Time         // time at which the turn was taken
+	score float64           // round moved ahead by the song's votes. Lower plays first
+}
+
+type HybridQueuer struct {
+	weights HybridWeights       // how far votes move songs
+	queue   []*hybridSubmission // the songs in the queue, in no particular order
+	users   map[uint32]int      // keeps track of round robin count
+	round   int                 // the current round
+	pins    int                 // songs moved to the front so far
+
+	forgotten int // users deleted since the users map was made, whose room the map keeps
+}
+
+func NewHybridQueuer(weights HybridWeights) *HybridQueuer {
+	hybrid := new(HybridQueuer)
+	hybrid.weights = weights
+	hybrid.queue = make([]*hybridSubmission, 0)
+	hybrid.users = make(map[uint32]int)
+	return hybrid
+}
+
+func (hybrid *HybridQueuer) push(song *cmpb.Song) {
+	round := hybrid.nextRound(song.UserId)
+	hybrid.users[song.UserId] = round
+	hybrid.queue = append(hybrid.queue, &hybridSubmission{song: song, round: round, time: time.Now()})
+}
+
+// Put the song back ahead of every other song. The submitter's rounds are
+// left alone since the song already took its turn.
+func (hybrid *HybridQueuer) requeue(song *cmpb.Song) {
+	hybrid.pins++
+	hybrid.queue = append(hybrid.queue, &hybridSubmission{
+		song:   song,
+		round:  hybrid.round,
+		time:   time.Now(),
+		pinned: hybrid.pins,
+	})
+}
+
+// Get the round the user's next submission will be placed in
+func (hybrid *HybridQueuer) nextRound(userId uint32) int {
+	round := 0
+	if userRound, ok := hybrid.users[userId]; ok {
+		round = userRound + 1
+	}
+
+	// bump the user up to the current round if they're behind
+	if round < hybrid.round {
+		round = hybrid.round
+	}
+
+	return round
+}
+
+// Work out the order the songs play in. Each user's turns go to their songs
+// with the most voted first, then the songs are sorted by their turn's round
+// less the rounds their votes are worth, with votes breaking ties.
+func (hybrid *HybridQueuer) sorted() []*hybridPlacement {
+	placed := make([]*hybridPlacement, 0, len(hybrid.queue))
+	for _, bucket := range hybrid.buckets() {
+		placed = append(placed, hybrid.assign(bucket)...)
+	}
+
+	for _, sub := range hybrid.queue {
+		if sub.pinned > 0 {
+			placed = append(placed, &hybridPlacement{sub: sub, round: sub.round, time: sub.time})
+		}
+	}
+
+	sort.Slice(placed, func(i, j int) bool {
+		a, b := placed[i], placed[j]
+		if a.sub.pinned != b.sub.pinned {
+			return a.sub.pinned > b.sub.pinned
+		} else if a.score != b.score {
+			return a.score < b.score
+		} else if a.sub.song.Votes != b.sub.song.Votes {
+			return a.sub.song.Votes > b.sub.song.Votes
+		} else if !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+		return a.sub.song.SongId < b.sub.song.SongId
+	})
+
+	return placed
+}
+
+// Group the songs that weren't moved to the front by their submitter
+func (hybrid *HybridQueuer) buckets() map[uint32][]*hybridSubmission {
+	buckets := make(map[uint32][]*hybridSubmission)
+	for _, sub := range hybrid.queue {
+		if sub.pinned == 0 {
+			buckets[sub.song.UserId] = append(buckets[sub.song.UserId], sub)
+		}
+	}
+
+	return buckets
+}
+
+// Hand the turns a user holds to their songs. Each song moves ahead of the
+// user's other songs by its votes times the bucket weight.
+func (hybrid *HybridQueuer) assign(bucket []*hybridSubmission) []*hybridPlacement {
+	sort.Slice(bucket, func(i, j int) bool {
+		if bucket[i].round != bucket[j].round {
+			return bucket[i].round < bucket[j].round
+		}
+		return bucket[i].time.Before(bucket[j].time)
+	})
+
+	keys := make(map[*hybridSubmission]float64, len(bucket))
+	songs := make([]*hybridSubmission, len(bucket))
+	for i, sub := range bucket {
+		keys[sub] = float64(i) - hybrid.weights.Bucket*float64(sub.song.Votes)
+		songs[i] = sub
+	}
+
+	// a song that moves as far as the song ahead of it goes in front
+	sort.SliceStable(songs, func(i, j int) bool {
+		if keys[songs[i]] != keys[songs[j]] {
+			return keys[songs[i]] < keys[songs[j]]
+		}
+		return songs[i].song.Votes > songs[j].song.Votes
+	})
+
+	placed := make([]*hybridPlacement, len(songs))
+	for i, sub := range songs {
+		placed[i] = &hybridPlacement{
+			sub:   sub,
+			round: bucket[i].round,
+			time:  bucket[i].time,
+			score: float64(bucket[i].round) - hybrid.weights.Rotation*float64(sub.song.Votes),
+		}
+	}
+
+	return placed
+}
+
+// Give the user's songs the turns they play in now, so the turn a song takes
+// off the queue goes with it
+func (hybrid *HybridQueuer) settle(userId uint32) {
+	bucket := hybrid.buckets()[userId]
+	for _, p := range hybrid.assign(bucket) {
+		p.sub.round = p.round
+		p.sub.time = p.time
+	}
+}
+
+// Take a submission out of the queue
+func (hybrid *HybridQueuer) drop(sub *hybridSubmission) {
+	for i, queued := range hybrid.queue {
+		if queued == sub {
+			last := len(hybrid.queue) - 1
+			copy(hybrid.queue[i:], hybrid.queue[i+1:])
+			hybrid.queue[last] = nil // let the song be freed
+			hybrid.queue = hybrid.queue[:last]
+			hybrid.compact()
+			return
+		}
+	}
+}
+
+// A new submission has no votes, so it goes behind every song placed in its
+// round or an earlier one
+func (hybrid *HybridQueuer) position(song *cmpb.Song) int {
+	round := float64(hybrid.nextRound(song.UserId))
+
+	ahead := 0
+	for _, p := range hybrid.sorted() {
+		if p.sub.pinned > 0 || p.score <= round {
+			ahead++
+		}
+	}
+
+	return ahead
+}
+
+func (hybrid *HybridQueuer) length() int {
+	return len(hybrid.queue)
+}
+
+func (hybrid *HybridQueuer) pop() *cmpb.Song {
+	if len(hybrid.queue) == 0 {
+		return nil
+	}
+
+	placed := hybrid.sorted()
+	first := placed[0]
+	if first.sub.pinned == 0 {
+		hybrid.settle(first.sub.song.UserId)
+	}
+	hybrid.drop(first.sub)
+
+	// votes can play a song from a later round early, so the round only
+	// advances once no song is left in the earlier ones
+	lowest := first.sub.round
+	for _, sub := range hybrid.queue {
+		if sub.round < lowest {
+			lowest = sub.round
+		}
+	}
+
+	if lowest > hybrid.round {
+		hybrid.round = lowest
+	}
+
+	return first.sub.song
+}
+
+func (hybrid *HybridQueuer) remove(songId uint32, userId uint32) error {
+	for _, sub := range hybrid.queue {
+		if sub.song.SongId == songId && canRemove(sub.song, userId) {
+			if sub.pinned == 0 {
+				hybrid.settle(sub.song.UserId)
+			}
+			hybrid.drop(sub)
+			// give the submitter back the round the song took up
+			hybrid.users[sub.song.UserId]--
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+// Each submitter gets back the round their copy took up, as if they had
+// removed it themselves
+func (hybrid *HybridQueuer) removeByServiceId(service cmpb.ServiceType, serviceId string) []*cmpb.Song {
+	copies := copiesOf(hybrid, service, serviceId)
+	for _, song := range copies {
+		hybrid.remove(song.SongId, song.UserId)
+	}
+
+	return copies
+}
+
+// Drop the round count of a user with nothing queued, so the user's next
+// submission starts over in the current round
+func (hybrid *HybridQueuer) forget(userId uint32) {
+	for _, sub := range hybrid.queue {
+		if sub.song.UserId == userId {
+			return
+		}
+	}
+
+	delete(hybrid.users, userId)
+	hybrid.forgotten++
+	hybrid.compact()
+}
+
+// Copy the queue and the users map into less memory once most of the memory
+// they hold on to sits unused, such as after many songs were removed
+func (hybrid *HybridQueuer) compact() {
+	length := len(hybrid.queue)
+	if shouldCompact(length, cap(hybrid.queue)) {
+		queue := make([]*hybridSubmission, length, 2*length)
+		copy(queue, hybrid.queue)
+		hybrid.queue = queue
+	}
+
+	if shouldCompact(len(hybrid.users), len(hybrid.users)+hybrid.forgotten) {
+		users := make(map[uint32]int, len(hybrid.users))
+		for userId, round := range hybrid.users {
+			users[userId] = round
+		}
+		hybrid.users = users
+		hybrid.forgotten = 0
+	}
+}
+
+// Put the user's songs in rounds after every other song's, one song per
+// round, so they play last unless the room votes them up
+func (hybrid *HybridQueuer) demote(userId uint32) {
+	last := hybrid.round
+	for _, sub := range hybrid.queue {
+		if sub.song.UserId != userId && sub.round > last {
+			last = sub.round
+		}
+	}
+
+	for _, p := range hybrid.sorted() {
+		if p.sub.song.UserId == userId {
+			last++
+			p.sub.round = last
+			p.sub.pinned = 0
+			hybrid.users[userId] = last
+		}
+	}
+}
+
+// Put the song ahead of every other song. The submitter keeps the round the
+// song was queued in, so their next song still waits its turn.
+func (hybrid *HybridQueuer) promote(songId uint32) error {
+	for _, sub := range hybrid.queue {
+		if sub.song.SongId == songId {
+			if sub.pinned == 0 {
+				hybrid.settle(sub.song.UserId)
+			}
+			hybrid.pins++
+			sub.pinned = hybrid.pins
+			sub.round = hybrid.round
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+func (hybrid *HybridQueuer) turns() (int, map[uint32]int) {
+	users := make(map[uint32]int, len(hybrid.users))
+	for userId, round := range hybrid.users {
+		users[userId] = round
+	}
+
+	return hybrid.round, users
+}
+
+func (hybrid *HybridQueuer) stats() QueueStats {
+	return QueueStats{
+		Entries:  len(hybrid.queue),
+		Buckets:  bucketsOf(hybrid),
+		Tracked:  len(hybrid.users),
+		Capacity: cap(hybrid.queue),
+	}
+}
+
+func (hybrid *HybridQueuer) front() queueElement {
+	placed := hybrid.sorted()
+	if len(placed) == 0 {
+		return nil
+	}
+
+	songs := make([]*cmpb.Song, len(placed))
+	for i, p := range placed {
+		songs[i] = p.sub.song
+	}
+
+	return hybridElement{songs: songs, index: 0}
+}
+
+type hybridElement struct {
+	songs []*cmpb.Song // songs in the order they play
+	index int          // index of element
+}
+
+func (e hybridElement) value() *cmpb.Song {
+	return e.songs[e.index]
+}
+
+func (e hybridElement) next() queueElement {
+	if e.index+1 < len(e.songs) {
+		return hybridElement{songs: e.songs, index: e.index + 1}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package song_queue
+
+import (
+	"reflect"
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Returns a song with the id submitted by the user
+ */
+func testSong(songId uint32, userId uint32) *cmpb.Song {
+	return &cmpb.Song{SongId: songId, UserId: userId, Service: cmpb.ServiceType_Youtube}
+}
+
+/*
+ * Pushes songs onto the queuer, each given as its id and its submitter's id
+ */
+func pushSongs(queuer SongQueuer, songs ...[2]uint32) map[uint32]*cmpb.Song {
+	pushed := make(map[uint32]*cmpb.Song)
+	for _, ids := range songs {
+		song := testSong(ids[0], ids[1])
+		queuer.push(song)
+		pushed[song.SongId] = song
+	}
+
+	return pushed
+}
+
+func TestHybridPushPop_withoutVotes_takesTurns(t *testing.T) {
+	queuer := NewHybridQueuer(DefaultHybridWeights)
+	pushSongs(queuer, [2]uint32{1, 1}, [2]uint32{2, 1}, [2]uint32{3, 1}, [2]uint32{4, 2}, [2]uint32{5, 3})
+
+	expected := []uint32{1, 4, 5, 2, 3}
+	if order := listed(queuer); !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Expected the users to take turns %v, but got %v", expected, order)
+	}
+
+	for _, songId := range expected {
+		if song := queuer.pop(); song.SongId != songId {
+			t.Errorf("Expected song %d to pop, but got %d", songId, song.SongId)
+		}
+	}
+
+	if queuer.pop() != nil {
+		t.Error("Expected nothing to pop off an empty queue")
+	}
+}
+
+func TestHybridVotes_reorderSongs(t *testing.T) {
+	tests := []struct {
+		name     string
+		weights  HybridWeights
+		votes    map[uint32]uint32
+		expected []uint32
+	}{
+		{"no votes", DefaultHybridWeights, nil, []uint32{1, 2, 3, 4}},
+		{"within the submitter's songs", DefaultHybridWeights, map[uint32]uint32{3: 1}, []uint32{3, 2, 1, 4}},
+		{"into an earlier round", DefaultHybridWeights, map[uint32]uint32{4: 4}, []uint32{4, 1, 3, 2}},
+		{"only breaking ties", HybridWeights{}, map[uint32]uint32{4: 4}, []uint32{1, 2, 4, 3}},
+		{"a round a vote", HybridWeights{Rotation: 1}, map[uint32]uint32{3: 1}, []uint32{3, 1, 2, 4}},
+	}
+
+	for _, test := range tests {
+		queuer := NewHybridQueuer(test.weights)
+		songs := pushSongs(queuer, [2]uint32{1, 1}, [2]uint32{2, 2}, [2]uint32{3, 1}, [2]uint32{4, 2})
+		for songId, votes := range test.votes {
+			songs[songId].Votes = votes
+		}
+
+		if order := listed(queuer); !reflect.DeepEqual(order, test.expected) {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, order)
+		}
+	}
+}
+
+func TestHybridRemove_givesBackTheTurn(t *testing.T) {
+	queuer := NewHybridQueuer(DefaultHybridWeights)
+	pushSongs(queuer, [2]uint32{1, 1}, [2]uint32{2, 1}, [2]uint32{3, 2})
+
+	if err := queuer.remove(2, 2); err == nil {
+		t.Error("Expected only the submitter to be able to remove the song")
+	}
+
+	if err := queuer.remove(2, 1); err != nil {
+		t.Fatalf("Expected the song to be removed, got %v", err)
+	}
+
+	if err := queuer.remove(2, 1); err == nil {
+		t.Error("Expected removing the song twice to fail")
+	}
+
+	if _, rounds := queuer.turns(); rounds[1] != 0 {
+		t.Errorf("Expected the submitter back in the first round, got %d", rounds[1])
+	}
+
+	pushSongs(queuer, [2]uint32{4, 1})
+	if order := listed(queuer); !reflect.DeepEqual(order, []uint32{1, 3, 4}) {
+		t.Errorf("Expected the submitter's next song to take the freed turn, got %v", order)
+	}
+}
+
+func TestHybridPromote_playsNextAndKeepsTurns(t *testing.T) {
+	queuer := NewHybridQueuer(DefaultHybridWeights)
+	songs := pushSongs(queuer, [2]uint32{1, 1}, [2]uint32{2, 2}, [2]uint32{3, 1}, [2]uint32{4, 2})
+
+	if err := queuer.promote(42); err == nil {
+		t.Error("Expected promoting a song that isn't queued to fail")
+	}
+
+	if err := queuer.promote(4); err != nil {
+		t.Fatal(err)
+	}
+
+	// votes don't move songs past a promoted one
+	songs[3].Votes = 10
+	if order := listed(queuer); !reflect.DeepEqual(order, []uint32{4, 3, 2, 1}) {
+		t.Errorf("Expected the promoted song first, got %v", order)
+	}
+
+	if song := queuer.pop(); song.SongId != 4 {
+		t.Errorf("Expected the promoted song to pop, got %d", song.SongId)
+	}
+}
+
+func TestHybridDemote_movesUserLast(t *testing.T) {
+	queuer := NewHybridQueuer(DefaultHybridWeights)
+	songs := pushSongs(queuer, [2]uint32{1, 1}, [2]uint32{2, 2}, [2]uint32{3, 1}, [2]uint32{4, 3}, [2]uint32{5, 2})
+
+	queuer.demote(1)
+	if order := listed(queuer); !reflect.DeepEqual(order, []uint32{2, 4, 5, 1, 3}) {
+		t.Errorf("Expected the user's songs last in their order, got %v", order)
+	}
+
+	// the room can still vote a demoted song up
+	songs[1].Votes = 12
+	if order := listed(queuer); order[0] != 1 {
+		t.Errorf("Expected the voted song to move ahead, got %v", order)
+	}
+}
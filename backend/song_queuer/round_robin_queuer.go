@@ -13,8 +13,6 @@ import (
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
-const markedForRemoval = -1
-
 // A user submission managed by the round robin queuer
 type submission struct {
 	song  *cmpb.Song // A song in the queue
@@ -46,6 +44,12 @@ type RoundRobinQueuer struct {
 	queue []*submission  // the queue of songs
 	users map[uint32]int // keeps track of round robin count
 	round int            // the current round
+
+	// Popping a song only moves the queue's start past it, and deleting a
+	// user leaves their room in the map, so the memory is only given back
+	// when the queue or the map is copied
+	dropped   int // songs popped since the queue's array was allocated
+	forgotten int // users deleted since the users map was made
 }
 
 func NewRoundRobinQueuer() *RoundRobinQueuer {
@@ -57,10 +61,57 @@ func NewRoundRobinQueuer() *RoundRobinQueuer {
 }
 
 func (roundRobin *RoundRobinQueuer) push(song *cmpb.Song) {
+	round := roundRobin.nextRound(song.UserId)
+	roundRobin.users[song.UserId] = round
+
+	sub := &submission{
+		song:  song,
+		round: round,
+		time:  time.Now(),
+	}
+
+	roundRobin.append(sub)
+}
+
+// Add a submission to the queue and sort it
+func (roundRobin *RoundRobinQueuer) append(sub *submission) {
+	// a full queue is copied into a new array, leaving popped songs behind
+	if len(roundRobin.queue) == cap(roundRobin.queue) {
+		roundRobin.dropped = 0
+	}
+
+	roundRobin.queue = append(roundRobin.queue, sub)
+	sort.Sort(byRoundRobin(roundRobin.queue))
+}
+
+// Put the song back in the current round, just ahead of the front song. The
+// submitter's rounds are left alone since the song already took its turn.
+func (roundRobin *RoundRobinQueuer) requeue(song *cmpb.Song) {
+	sub := &submission{
+		song:  song,
+		round: roundRobin.round,
+		time:  time.Now(),
+	}
+
+	if len(roundRobin.queue) > 0 {
+		front := roundRobin.queue[0]
+		if front.round < sub.round {
+			sub.round = front.round
+		}
+		if sub.round == front.round && !sub.time.Before(front.time) {
+			sub.time = front.time.Add(-time.Nanosecond)
+		}
+	}
+
+	roundRobin.append(sub)
+}
+
+// Get the round the user's next submission will be placed in
+func (roundRobin *RoundRobinQueuer) nextRound(userId uint32) int {
 	var round int = 0
 
 	// get the current round belonging to the user
-	if user_round, ok := roundRobin.users[song.UserId]; ok == true {
+	if user_round, ok := roundRobin.users[userId]; ok == true {
 		round = user_round + 1
 	}
 
@@ -69,16 +120,22 @@ func (roundRobin *RoundRobinQueuer) push(song *cmpb.Song) {
 		round = roundRobin.round
 	}
 
-	roundRobin.users[song.UserId] = round
+	return round
+}
 
-	sub := &submission{
-		song:  song,
-		round: round,
-		time:  time.Now(),
+// A new submission goes behind every song in its round or an earlier one,
+// since those were all submitted before it
+func (roundRobin *RoundRobinQueuer) position(song *cmpb.Song) int {
+	round := roundRobin.nextRound(song.UserId)
+
+	ahead := 0
+	for _, sub := range roundRobin.queue {
+		if sub.round <= round {
+			ahead++
+		}
 	}
 
-	roundRobin.queue = append(roundRobin.queue, sub)
-	sort.Sort(byRoundRobin(roundRobin.queue))
+	return ahead
 }
 
 func (roundRobin *RoundRobinQueuer) length() int {
@@ -92,7 +149,9 @@ func (roundRobin *RoundRobinQueuer) pop() *cmpb.Song {
 		sub := current_list[0]
 		current_list[0] = nil
 		roundRobin.queue = current_list[1:current_length]
+		roundRobin.dropped++
 		sort.Sort(byRoundRobin(roundRobin.queue))
+		roundRobin.compact()
 
 		// advance the round as songs are popped off
 		roundRobin.round = sub.round
@@ -105,13 +164,16 @@ func (roundRobin *RoundRobinQueuer) pop() *cmpb.Song {
 
 func (roundRobin *RoundRobinQueuer) remove(songId uint32, userId uint32) error {
 	for i, sub := range roundRobin.queue {
-		if sub.song.SongId == songId && sub.song.UserId == userId {
-			// move the song to be removed to the front and pop it off
-			roundRobin.queue[i].round = markedForRemoval
-			sort.Sort(byRoundRobin(roundRobin.queue))
-			roundRobin.pop()
-			// give the user deleting a song back one of their rounds
-			roundRobin.users[userId]--
+		if sub.song.SongId == songId && canRemove(sub.song, userId) {
+			// take the song out where it is, leaving the current round alone
+			// since the song never played
+			last := len(roundRobin.queue) - 1
+			copy(roundRobin.queue[i:], roundRobin.queue[i+1:])
+			roundRobin.queue[last] = nil
+			roundRobin.queue = roundRobin.queue[:last]
+			roundRobin.compact()
+			// give the submitter back the round the song took up
+			roundRobin.users[sub.song.UserId]--
 			return nil
 		}
 	}
@@ -119,6 +181,111 @@ func (roundRobin *RoundRobinQueuer) remove(songId uint32, userId uint32) error {
 	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
 }
 
+// Each submitter gets back the round their copy took up, as if they had
+// removed it themselves
+func (roundRobin *RoundRobinQueuer) removeByServiceId(service cmpb.ServiceType, serviceId string) []*cmpb.Song {
+	copies := copiesOf(roundRobin, service, serviceId)
+	for _, song := range copies {
+		roundRobin.remove(song.SongId, song.UserId)
+	}
+
+	return copies
+}
+
+// Drop the round count of a user with nothing queued, so the user's next
+// submission starts over in the current round
+func (roundRobin *RoundRobinQueuer) forget(userId uint32) {
+	for _, sub := range roundRobin.queue {
+		if sub.song.UserId == userId {
+			return
+		}
+	}
+
+	delete(roundRobin.users, userId)
+	roundRobin.forgotten++
+	roundRobin.compact()
+}
+
+// Copy the queue and the users map into less memory once most of the memory
+// they hold on to sits unused, such as after many songs were removed
+func (roundRobin *RoundRobinQueuer) compact() {
+	length := len(roundRobin.queue)
+	if shouldCompact(length, roundRobin.dropped+cap(roundRobin.queue)) {
+		queue := make([]*submission, length, 2*length)
+		copy(queue, roundRobin.queue)
+		roundRobin.queue = queue
+		roundRobin.dropped = 0
+	}
+
+	if shouldCompact(len(roundRobin.users), len(roundRobin.users)+roundRobin.forgotten) {
+		users := make(map[uint32]int, len(roundRobin.users))
+		for userId, round := range roundRobin.users {
+			users[userId] = round
+		}
+		roundRobin.users = users
+		roundRobin.forgotten = 0
+	}
+}
+
+// Put the user's songs in rounds after every other song's, one song per
+// round, so they play last
+func (roundRobin *RoundRobinQueuer) demote(userId uint32) {
+	last := roundRobin.round
+	for _, sub := range roundRobin.queue {
+		if sub.song.UserId != userId && sub.round > last {
+			last = sub.round
+		}
+	}
+
+	// the queue is sorted, so the user's songs keep their order
+	for _, sub := range roundRobin.queue {
+		if sub.song.UserId == userId {
+			last++
+			sub.round = last
+			roundRobin.users[userId] = last
+		}
+	}
+
+	sort.Sort(byRoundRobin(roundRobin.queue))
+}
+
+// Put the song in the front song's round, just ahead of it. The submitter
+// keeps the round the song was queued in, so their next song still waits its
+// turn.
+func (roundRobin *RoundRobinQueuer) promote(songId uint32) error {
+	for _, sub := range roundRobin.queue {
+		if sub.song.SongId == songId {
+			front := roundRobin.queue[0]
+			if sub != front {
+				sub.round = front.round
+				sub.time = front.time.Add(-time.Nanosecond)
+				sort.Sort(byRoundRobin(roundRobin.queue))
+			}
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Song with id %d does not exist in the queue", songId))
+}
+
+func (roundRobin *RoundRobinQueuer) turns() (int, map[uint32]int) {
+	users := make(map[uint32]int, len(roundRobin.users))
+	for userId, round := range roundRobin.users {
+		users[userId] = round
+	}
+
+	return roundRobin.round, users
+}
+
+func (roundRobin *RoundRobinQueuer) stats() QueueStats {
+	return QueueStats{
+		Entries:  len(roundRobin.queue),
+		Buckets:  bucketsOf(roundRobin),
+		Tracked:  len(roundRobin.users),
+		Capacity: roundRobin.dropped + cap(roundRobin.queue),
+	}
+}
+
 func (roundRobin *RoundRobinQueuer) front() queueElement {
 	if len(roundRobin.queue) > 0 {
 		new_element := roundRobinElement{
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
@@ -17,29 +18,82 @@ import (
 
 const (
 	QueueSnapshot string = "/tmp/ytbox.queue" // location of the queue snapshot
+
+	playedHistory = 10 // songs remembered to go back to
 )
 
 /*
  * Manages the song queue
  */
 type SongQueueManager struct {
-	queue      songQueuer    // the playlist of songs
-	lock       *sync.RWMutex // read/write lock on the playlist
-	npLock     *sync.Mutex   // lock on the now playing value
-	cLock      *sync.Mutex   // mutex for condition variable
-	cond       *sync.Cond    // condition variable on the queue
-	nowPlaying *cmpb.Song    // the currently playing song
+	queue      SongQueuer       // the playlist of songs
+	lock       *sync.RWMutex    // read/write lock on the playlist
+	npLock     *sync.Mutex      // lock on the now playing value
+	cLock      *sync.Mutex      // mutex for condition variable
+	cond       *sync.Cond       // condition variable on the queue
+	nowPlaying *cmpb.Song       // the currently playing song
+	startedAt  time.Time        // when the now playing song was popped off the queue
+	cache      *playlistCache   // playlist built from the queue, shared with managers sharing the queue
+	demoted    map[uint32]bool  // users whose songs are kept at the end of the queue, shared like the queue
+	dispatched *cmpb.Song       // popped song waiting on a player to confirm it started. Guarded by npLock
+	giveBack   *time.Timer      // returns the dispatched song to the queue if it isn't confirmed. Guarded by npLock
+	onReturn   func(*cmpb.Song) // called after a dispatched song returns to the queue
+	played     []*cmpb.Song     // songs played before the now playing one, oldest first. Guarded by npLock
+	spacing    *artistSpacing   // when each artist was last played, shared like the queue
+}
+
+/*
+ * Keeps the playlist built from the queue until the queue changes. Clients
+ * poll the playlist far more often than songs are queued, so it's only
+ * rebuilt once each time the queue changes instead of on every request.
+ */
+type playlistCache struct {
+	generation uint64         // bumped whenever the queue changes. Changed under the queue's write lock
+	built      uint64         // generation the cached playlist was built at
+	playlist   *bepb.Playlist // the cached playlist. Nil until it's first built
+	lock       sync.Mutex     // lock on the cached playlist
+}
+
+/*
+ * Queuer whose algorithm can be changed while songs are queued. Managers
+ * sharing a queue share the swappable queuer, so they all switch at once.
+ */
+type swappableQueuer struct {
+	SongQueuer
 }
 
 /*
  * Initializes the queue
  */
-func (manager *SongQueueManager) Init(queuer songQueuer) {
-	manager.queue = queuer
+func (manager *SongQueueManager) Init(queuer SongQueuer) {
+	manager.queue = &swappableQueuer{queuer}
 	manager.lock = new(sync.RWMutex)
 	manager.npLock = new(sync.Mutex)
 	manager.cLock = new(sync.Mutex)
 	manager.cond = sync.NewCond(manager.cLock)
+	manager.cache = new(playlistCache)
+	manager.demoted = make(map[uint32]bool)
+	manager.spacing = &artistSpacing{played: make(map[string]*artistPlay)}
+
+	// start from the clock so clients don't mistake a restarted queue for
+	// the one they last saw
+	manager.cache.generation = uint64(time.Now().UnixNano())
+}
+
+/*
+ * Initializes a manager that shares the queue of another manager, but keeps
+ * track of its own now playing song. Songs added to either manager go into
+ * the same queue.
+ */
+func (manager *SongQueueManager) InitShared(source *SongQueueManager) {
+	manager.queue = source.queue
+	manager.lock = source.lock
+	manager.npLock = new(sync.Mutex)
+	manager.cLock = source.cLock
+	manager.cond = source.cond
+	manager.cache = source.cache
+	manager.demoted = source.demoted
+	manager.spacing = source.spacing
 }
 
 /*
@@ -50,6 +104,8 @@ func (manager *SongQueueManager) AddSong(song *cmpb.Song) {
 	defer manager.lock.Unlock()
 
 	manager.queue.push(song)
+	manager.keepDemoted()
+	manager.cache.generation++
 
 	if manager.queue.length() == 1 {
 		manager.cond.Broadcast()
@@ -72,6 +128,7 @@ func (manager *SongQueueManager) ClearNowPlaying() {
 	manager.npLock.Lock()
 	defer manager.npLock.Unlock()
 
+	manager.rememberPlayed()
 	manager.nowPlaying = nil
 }
 
@@ -86,21 +143,200 @@ func (manager *SongQueueManager) NowPlaying() *cmpb.Song {
 }
 
 /*
- * Returns a list of songs in the queue
+ * Set the now playing song to one that didn't come off the queue, such as a
+ * song mirrored from another backend
+ */
+func (manager *SongQueueManager) SetNowPlaying(song *cmpb.Song) {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	manager.rememberPlayed()
+	manager.nowPlaying = song
+	manager.startedAt = time.Now()
+}
+
+/*
+ * Start the now playing song over. Returns the song, or nil if nothing is
+ * playing.
+ */
+func (manager *SongQueueManager) RestartNowPlaying() *cmpb.Song {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if manager.nowPlaying != nil {
+		manager.startedAt = time.Now()
+	}
+
+	return manager.nowPlaying
+}
+
+/*
+ * Go back to the song played before the now playing one. The now playing song
+ * goes back to the head of the queue so it plays next. Returns the song gone
+ * back to, or nil if there's none to go back to.
+ */
+func (manager *SongQueueManager) PreviousSong() *cmpb.Song {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if len(manager.played) == 0 {
+		return nil
+	}
+
+	previous := manager.played[len(manager.played)-1]
+	manager.played = manager.played[:len(manager.played)-1]
+
+	if manager.giveBack != nil {
+		manager.giveBack.Stop()
+		manager.giveBack = nil
+	}
+	manager.dispatched = nil
+
+	if manager.nowPlaying != nil && !manager.nowPlaying.Jingle {
+		manager.lock.Lock()
+		manager.queue.requeue(manager.nowPlaying)
+		manager.unplayArtist(manager.nowPlaying)
+		manager.keepDemoted()
+		manager.cache.generation++
+		manager.lock.Unlock()
+	}
+
+	manager.nowPlaying = previous
+	manager.startedAt = time.Now()
+	return previous
+}
+
+/*
+ * Remember the now playing song as played, so it can be gone back to.
+ * Jingles aren't remembered. Expects npLock to be held.
+ */
+func (manager *SongQueueManager) rememberPlayed() {
+	if manager.nowPlaying == nil || manager.nowPlaying.Jingle {
+		return
+	}
+
+	manager.played = append(manager.played, manager.nowPlaying)
+	if len(manager.played) > playedHistory {
+		manager.played = manager.played[len(manager.played)-playedHistory:]
+	}
+}
+
+/*
+ * Returns the currently playing song and the time it started. The song is nil
+ * if nothing is playing.
+ */
+func (manager *SongQueueManager) NowPlayingSince() (*cmpb.Song, time.Time) {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	return manager.nowPlaying, manager.startedAt
+}
+
+/*
+ * Returns the currently playing song and the songs in the queue as of the same
+ * moment, so a song being popped isn't missed or seen twice
+ */
+func (manager *SongQueueManager) Snapshot() (*cmpb.Song, []*cmpb.Song) {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	songs := make([]*cmpb.Song, 0, manager.queue.length())
+	for e := manager.queue.front(); e != nil; e = e.next() {
+		songs = append(songs, e.value())
+	}
+
+	return manager.nowPlaying, songs
+}
+
+/*
+ * Returns the current round of the queue's rotation and the round each user's
+ * latest song was queued in. The rounds are nil if the queue doesn't take
+ * turns, like a fifo queue.
+ */
+func (manager *SongQueueManager) Rotation() (int, map[uint32]int) {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.queue.turns()
+}
+
+/*
+ * Returns the number of songs queued, how many each user queued and how much
+ * memory the queue set aside for them
+ */
+func (manager *SongQueueManager) Stats() QueueStats {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	return manager.queue.stats()
+}
+
+/*
+ * Returns the songs in the queue that would play before the given song if it
+ * were added now
+ */
+func (manager *SongQueueManager) SongsAhead(song *cmpb.Song) []*cmpb.Song {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	ahead := manager.queue.position(song)
+	songs := make([]*cmpb.Song, 0, ahead)
+	for e := manager.queue.front(); e != nil && len(songs) < ahead; e = e.next() {
+		songs = append(songs, e.value())
+	}
+
+	return songs
+}
+
+/*
+ * Returns a list of songs in the queue. The playlist is only rebuilt after
+ * the queue changed. Each caller gets its own copy of the cached playlist, so
+ * changing the list doesn't change it for anyone else, but the songs are the
+ * queued ones and must not be modified.
  */
 func (manager *SongQueueManager) GetPlaylist() *bepb.Playlist {
-	songs := make([]*cmpb.Song, manager.queue.length())
-	var idx int = 0
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	cache := manager.cache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.playlist == nil || cache.built != cache.generation {
+		songs := make([]*cmpb.Song, 0, manager.queue.length())
+		for e := manager.queue.front(); e != nil; e = e.next() {
+			songs = append(songs, e.value())
+		}
+
+		cache.playlist = &bepb.Playlist{Songs: songs, Generation: cache.generation}
+		cache.built = cache.generation
+	}
+
+	songs := make([]*cmpb.Song, len(cache.playlist.Songs))
+	copy(songs, cache.playlist.Songs)
+	return &bepb.Playlist{Songs: songs, Generation: cache.playlist.Generation}
+}
+
+/*
+ * Returns the song with the given id if it's either playing or waiting in the
+ * queue. Returns nil if the song couldn't be found.
+ */
+func (manager *SongQueueManager) FindSong(songId uint32) *cmpb.Song {
+	if nowPlaying := manager.NowPlaying(); nowPlaying != nil && nowPlaying.SongId == songId {
+		return nowPlaying
+	}
 
 	manager.lock.RLock()
 	defer manager.lock.RUnlock()
 
 	for e := manager.queue.front(); e != nil; e = e.next() {
-		songs[idx] = e.value()
-		idx++
+		if e.value().SongId == songId {
+			return e.value()
+		}
 	}
 
-	return &bepb.Playlist{Songs: songs}
+	return nil
 }
 
 /*
@@ -118,42 +354,296 @@ func (manager *SongQueueManager) WaitForMoreSongs() {
 }
 
 /*
- * Pops the next song off the queue and returns it
+ * Pops the next song off the queue and returns it. Songs by an artist played
+ * within the artist gap are passed over until the gap has passed.
  */
 func (manager *SongQueueManager) PopQueue() *cmpb.Song {
 	manager.npLock.Lock()
 	defer manager.npLock.Unlock()
+	manager.rememberPlayed()
 	manager.nowPlaying = nil
 
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
 
 	if manager.queue.length() > 0 {
-		manager.nowPlaying = manager.queue.pop()
+		manager.startedAt = time.Now()
+		manager.nowPlaying = manager.popSpaced(manager.startedAt)
+		manager.cache.generation++
 	}
 
 	return manager.nowPlaying
 }
 
 /*
- * Removes the identified song from the queue. Both the song id and uesr id
- * must match in order for the song to be successfully removed.
+ * Pops the next song off the queue like PopQueue, but only until a player
+ * confirms it started playing. If no confirmation comes within the timeout,
+ * the song goes back to the head of the queue. A song dispatched earlier and
+ * still waiting goes back to the head right away, so it plays after this one
+ * instead of being lost. Callers that are done with it, like when it was
+ * skipped, confirm it first.
+ */
+func (manager *SongQueueManager) Dispatch(timeout time.Duration) *cmpb.Song {
+	song := manager.PopQueue()
+
+	manager.npLock.Lock()
+	if manager.giveBack != nil {
+		manager.giveBack.Stop()
+		manager.giveBack = nil
+	}
+
+	earlier := manager.dispatched
+	manager.dispatched = song
+	if song != nil {
+		manager.giveBack = time.AfterFunc(timeout, func() { manager.returnDispatched(song) })
+	}
+
+	// the earlier song never played, so it's not one to go back to
+	if last := len(manager.played) - 1; earlier != nil && last >= 0 && manager.played[last] == earlier {
+		manager.played = manager.played[:last]
+	}
+
+	if earlier != nil {
+		manager.requeueDispatched(earlier)
+	}
+	onReturn := manager.onReturn
+	manager.npLock.Unlock()
+
+	if earlier != nil {
+		log.Printf("Returned %s to the queue after another song was dispatched before it was confirmed",
+			earlier.ServiceId)
+		manager.cond.Broadcast()
+		if onReturn != nil {
+			onReturn(earlier)
+		}
+	}
+
+	return song
+}
+
+/*
+ * Confirms the dispatched song started playing, so it leaves the queue for
+ * good. Returns false if the song isn't the one waiting on confirmation.
+ */
+func (manager *SongQueueManager) ConfirmDispatched(songId uint32) bool {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+
+	if manager.dispatched == nil || manager.dispatched.SongId != songId {
+		return false
+	}
+
+	manager.giveBack.Stop()
+	manager.giveBack = nil
+	manager.dispatched = nil
+	return true
+}
+
+/*
+ * Returns the song waiting on a player to confirm it started playing, if any
+ */
+func (manager *SongQueueManager) Dispatched() *cmpb.Song {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+	return manager.dispatched
+}
+
+/*
+ * Sets the function called after a dispatched song returns to the queue
+ */
+func (manager *SongQueueManager) SetReturnHook(onReturn func(*cmpb.Song)) {
+	manager.npLock.Lock()
+	defer manager.npLock.Unlock()
+	manager.onReturn = onReturn
+}
+
+/*
+ * Puts a dispatched song that was never confirmed back at the head of the
+ * queue. Does nothing if the song was confirmed or let go in the meantime.
+ */
+func (manager *SongQueueManager) returnDispatched(song *cmpb.Song) {
+	manager.npLock.Lock()
+	if manager.dispatched != song {
+		manager.npLock.Unlock()
+		return
+	}
+
+	manager.dispatched = nil
+	manager.giveBack = nil
+	if manager.nowPlaying == song {
+		manager.nowPlaying = nil
+	}
+	onReturn := manager.onReturn
+
+	manager.requeueDispatched(song)
+	manager.npLock.Unlock()
+
+	log.Printf("Returned %s to the queue after no player confirmed it started", song.ServiceId)
+	manager.cond.Broadcast()
+	if onReturn != nil {
+		onReturn(song)
+	}
+}
+
+/*
+ * Puts a dispatched song back at the head of the queue. Expects npLock to be
+ * held.
+ */
+func (manager *SongQueueManager) requeueDispatched(song *cmpb.Song) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.queue.requeue(song)
+	manager.unplayArtist(song)
+	manager.keepDemoted()
+	manager.cache.generation++
+}
+
+/*
+ * Switches the queue over to another queuer, such as a fifo queue in place of
+ * a round robin one. The queued songs are moved over in the order they would
+ * have played. Managers sharing the queue switch too.
+ */
+func (manager *SongQueueManager) SwapQueuer(queuer SongQueuer) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	current := manager.queue.(*swappableQueuer)
+	for current.length() > 0 {
+		queuer.push(current.pop())
+	}
+	current.SongQueuer = queuer
+	manager.keepDemoted()
+	manager.cache.generation++
+}
+
+/*
+ * Removes the identified song from the queue. The song id must match and the
+ * user must be the one who submitted the song or the one it was queued for.
  */
 func (manager *SongQueueManager) RemoveSong(songId uint32, userId uint32) error {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
-	return manager.queue.remove(songId, userId)
+
+	if err := manager.queue.remove(songId, userId); err != nil {
+		return err
+	}
+
+	manager.cache.generation++
+	return nil
 }
 
 /*
- * Saves the playlist to a file
+ * Removes every queued copy of a song, no matter who submitted it. Returns
+ * the songs removed.
  */
-func (manager *SongQueueManager) SavePlaylist(path string) error {
-	playlist := manager.GetPlaylist()
+func (manager *SongQueueManager) RemoveByServiceId(service cmpb.ServiceType, serviceId string) []*cmpb.Song {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
 
-	out, err := proto.Marshal(playlist)
+	removed := manager.queue.removeByServiceId(service, serviceId)
+	if len(removed) > 0 {
+		manager.cache.generation++
+	}
+
+	return removed
+}
+
+/*
+ * Forgets the turns a user used up in the queue, such as after the user went
+ * inactive. Users with songs still queued keep their place in the rotation.
+ */
+func (manager *SongQueueManager) ForgetUser(userId uint32) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.queue.forget(userId)
+}
+
+/*
+ * Moves a user's songs to the end of the queue and keeps them behind the
+ * songs queued after them, until the user is restored
+ */
+func (manager *SongQueueManager) DemoteUser(userId uint32) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.demoted[userId] = true
+	manager.queue.demote(userId)
+	manager.cache.generation++
+}
+
+/*
+ * Moves a queued song to the front of the queue, so it plays next
+ */
+func (manager *SongQueueManager) PromoteSong(songId uint32) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if err := manager.queue.promote(songId); err != nil {
+		return err
+	}
+
+	manager.cache.generation++
+	return nil
+}
+
+/*
+ * Counts a vote for a queued song. Returns false if the song isn't queued.
+ */
+func (manager *SongQueueManager) VoteSong(songId uint32) bool {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	for e := manager.queue.front(); e != nil; e = e.next() {
+		if song := e.value(); song.SongId == songId {
+			song.Votes++
+			manager.cache.generation++
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Stops keeping a user's songs at the end of the queue. Their songs stay
+ * where they are and the user's next songs are queued as usual.
+ */
+func (manager *SongQueueManager) RestoreUser(userId uint32) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	delete(manager.demoted, userId)
+}
+
+/*
+ * Moves the songs of the demoted users back to the end of the queue after it
+ * changed. Must be called under the queue's write lock.
+ */
+func (manager *SongQueueManager) keepDemoted() {
+	for userId := range manager.demoted {
+		manager.queue.demote(userId)
+	}
+}
+
+/*
+ * Returns the playlist serialized the way SavePlaylist saves it
+ */
+func (manager *SongQueueManager) MarshalPlaylist() ([]byte, error) {
+	out, err := proto.Marshal(manager.GetPlaylist())
 	if err != nil {
 		log.Printf("Failed to encode Playlist with error: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+/*
+ * Saves the playlist to a file
+ */
+func (manager *SongQueueManager) SavePlaylist(path string) error {
+	out, err := manager.MarshalPlaylist()
+	if err != nil {
 		return err
 	}
 
@@ -0,0 +1,105 @@
+package song_queue
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+/*
+ * Returns the ids of the songs in the manager's playlist, in order
+ */
+func playlistIds(manager *SongQueueManager) []uint32 {
+	ids := make([]uint32, 0)
+	for _, song := range manager.GetPlaylist().Songs {
+		ids = append(ids, song.SongId)
+	}
+
+	return ids
+}
+
+func TestGetPlaylist_rebuiltAfterQueueChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(manager *SongQueueManager)
+		ids    []uint32
+	}{
+		{"add", func(manager *SongQueueManager) { manager.AddSong(testSong(4, 3)) }, []uint32{1, 3, 2, 4}},
+		{"pop", func(manager *SongQueueManager) { manager.PopQueue() }, []uint32{3, 2}},
+		{"remove", func(manager *SongQueueManager) { manager.RemoveSong(3, 1) }, []uint32{1, 2}},
+		{"swap", func(manager *SongQueueManager) { manager.SwapQueuer(NewRoundRobinQueuer()) }, []uint32{1, 2, 3}},
+		{"play next", func(manager *SongQueueManager) { manager.PromoteSong(2) }, []uint32{2, 1, 3}},
+		{"return", func(manager *SongQueueManager) {
+			manager.returnDispatched(manager.Dispatch(time.Hour))
+		}, []uint32{1, 3, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := new(SongQueueManager)
+			manager.Init(NewFifoQueuer())
+			for _, song := range [][2]uint32{{1, 1}, {3, 1}, {2, 2}} {
+				manager.AddSong(testSong(song[0], song[1]))
+			}
+
+			before := manager.GetPlaylist()
+			test.change(manager)
+			after := manager.GetPlaylist()
+
+			if after.Generation == before.Generation {
+				t.Errorf("Expected the generation to change from %d", before.Generation)
+			}
+			if ids := playlistIds(manager); !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("Expected the playlist rebuilt as %v, but got %v", test.ids, ids)
+			}
+		})
+	}
+}
+
+func TestGetPlaylist_unchangedQueue_keepsGeneration(t *testing.T) {
+	manager := new(SongQueueManager)
+	manager.Init(NewFifoQueuer())
+	manager.AddSong(testSong(1, 1))
+
+	first := manager.GetPlaylist()
+	if second := manager.GetPlaylist(); second.Generation != first.Generation {
+		t.Errorf("Expected the generation to stay %d, but got %d", first.Generation, second.Generation)
+	}
+}
+
+func TestGetPlaylist_callersGetTheirOwnCopy(t *testing.T) {
+	manager := new(SongQueueManager)
+	manager.Init(NewFifoQueuer())
+	manager.AddSong(testSong(1, 1))
+	manager.AddSong(testSong(2, 2))
+
+	shared := new(SongQueueManager)
+	shared.InitShared(manager)
+
+	changed := manager.GetPlaylist()
+	changed.Songs[0], changed.Songs[1] = changed.Songs[1], changed.Songs[0]
+	changed.Songs = changed.Songs[:1]
+	changed.Generation = 0
+
+	for _, caller := range []*SongQueueManager{manager, shared} {
+		playlist := caller.GetPlaylist()
+		if ids := playlistIds(caller); !reflect.DeepEqual(ids, []uint32{1, 2}) || playlist.Generation == 0 {
+			t.Errorf("Expected another caller's changes not to show, but got %v at generation %d", ids,
+				playlist.Generation)
+		}
+	}
+}
+
+func TestGetPlaylist_sharedQueue_seesChanges(t *testing.T) {
+	manager := new(SongQueueManager)
+	manager.Init(NewFifoQueuer())
+	shared := new(SongQueueManager)
+	shared.InitShared(manager)
+
+	before := shared.GetPlaylist()
+	manager.AddSong(testSong(1, 1))
+
+	if after := shared.GetPlaylist(); after.Generation == before.Generation || len(after.Songs) != 1 {
+		t.Errorf("Expected the shared manager to see the added song, but got %v", after)
+	}
+}
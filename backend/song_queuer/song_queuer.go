@@ -5,9 +5,10 @@ import (
 )
 
 /*
- * A songQueuer maintains a list of songs
+ * A SongQueuer maintains a list of songs. Only the queuers in this package can
+ * implement it.
  */
-type songQueuer interface {
+type SongQueuer interface {
 	// Get an element pointer to the front of the queue. User for interation
 	front() queueElement
 
@@ -20,8 +21,75 @@ type songQueuer interface {
 	// Push a new song onto the queue
 	push(song *cmpb.Song)
 
-	// Remove song from the queue
+	// Put a song that was popped off the queue back at the front, without
+	// charging its submitter another turn
+	requeue(song *cmpb.Song)
+
+	// Remove song from the queue if the user submitted it or it was queued
+	// for them
 	remove(songId uint32, userId uint32) error
+
+	// Remove every queued copy of a song no matter who submitted it. Returns
+	// the songs removed, in the order they were queued in.
+	removeByServiceId(service cmpb.ServiceType, serviceId string) []*cmpb.Song
+
+	// Get the number of songs that would play before the song if it were
+	// pushed onto the queue now
+	position(song *cmpb.Song) int
+
+	// Forget the turns a user used up, such as after the user left. Users
+	// with songs still queued are kept.
+	forget(userId uint32)
+
+	// Move the user's songs behind every other song in the queue, keeping
+	// them in the order they were in
+	demote(userId uint32)
+
+	// Move a song to the front of the queue, so it plays next
+	promote(songId uint32) error
+
+	// Get the current round of the rotation and the round each user's latest
+	// song was queued in. Queuers without turns return nil rounds.
+	turns() (int, map[uint32]int)
+
+	// Get the number of songs queued, how they're split between users and
+	// how much room the queue set aside for them
+	stats() QueueStats
+}
+
+// Queues hold on to the room songs took up after they're removed, so they
+// give it back once most of it sits unused and it's more than this many
+// songs' worth
+const compactAbove = 64
+
+/*
+ * Size of a queue and the memory set aside for it
+ */
+type QueueStats struct {
+	Entries  int            // songs in the queue
+	Buckets  map[uint32]int // user id -> songs the user has queued
+	Tracked  int            // users whose turns are tracked, including users with nothing queued
+	Capacity int            // songs the queue has room for before it needs more memory
+}
+
+/*
+ * Returns true if a queue with room for capacity songs holding length songs
+ * should be copied into less memory
+ */
+func shouldCompact(length int, capacity int) bool {
+	return capacity > compactAbove && capacity > 4*length
+}
+
+/*
+ * Returns the number of songs each user has queued
+ */
+func bucketsOf(queue SongQueuer) map[uint32]int {
+	buckets := make(map[uint32]int)
+	for elem := queue.front(); elem != nil; elem = elem.next() {
+		buckets[elem.value().GetUserId()]++
+	}
+
+	return buckets
 }
 
 type queueElement interface {
@@ -32,3 +100,25 @@ type queueElement interface {
 	// is returned.
 	next() queueElement
 }
+
+/*
+ * Returns the queued copies of a song, in queue order
+ */
+func copiesOf(queue SongQueuer, service cmpb.ServiceType, serviceId string) []*cmpb.Song {
+	copies := make([]*cmpb.Song, 0)
+	for elem := queue.front(); elem != nil; elem = elem.next() {
+		if song := elem.value(); song.Service == service && song.ServiceId == serviceId {
+			copies = append(copies, song)
+		}
+	}
+
+	return copies
+}
+
+/*
+ * Returns true if the user can remove the song: the user who submitted it or
+ * the user it was queued for
+ */
+func canRemove(song *cmpb.Song, userId uint32) bool {
+	return song.GetUserId() == userId || (song.GetForUserId() != 0 && song.GetForUserId() == userId)
+}
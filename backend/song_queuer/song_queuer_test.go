@@ -0,0 +1,79 @@
+package song_queue
+
+import (
+	"testing"
+)
+
+func TestShouldCompact(t *testing.T) {
+	tests := []struct {
+		length   int
+		capacity int
+		expected bool
+	}{
+		{0, 0, false},
+		{0, compactAbove, false},
+		{0, compactAbove + 1, true},
+		{100, 400, false},
+		{100, 401, true},
+		{10, 40, false},
+	}
+
+	for _, test := range tests {
+		if compact := shouldCompact(test.length, test.capacity); compact != test.expected {
+			t.Errorf("Expected %t for %d songs in room for %d, but got %t", test.expected, test.length,
+				test.capacity, compact)
+		}
+	}
+}
+
+func TestStats_countSongsByUser(t *testing.T) {
+	for name, newQueuer := range conformingQueuers {
+		queuer := newQueuer()
+		for songId := uint32(1); songId <= 5; songId++ {
+			queuer.push(testSong(songId, songId%2+1))
+		}
+		queuer.pop()
+
+		stats := queuer.stats()
+		if stats.Entries != 4 || stats.Buckets[1] != 2 || stats.Buckets[2] != 2 || stats.Capacity < stats.Entries {
+			t.Errorf("%s: expected four songs split between two users, got %+v", name, stats)
+		}
+	}
+}
+
+func TestCompact_afterLargeRemovals(t *testing.T) {
+	const pushed = 500
+
+	for name, newQueuer := range conformingQueuers {
+		queuer := newQueuer()
+		for songId := uint32(1); songId <= pushed; songId++ {
+			queuer.push(testSong(songId, songId))
+		}
+
+		for songId := uint32(1); songId <= pushed-10; songId++ {
+			if err := queuer.remove(songId, songId); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			queuer.forget(songId)
+		}
+
+		stats := queuer.stats()
+		if stats.Entries != 10 || len(stats.Buckets) != 10 {
+			t.Errorf("%s: expected ten songs left, got %+v", name, stats)
+		}
+
+		if shouldCompact(stats.Entries, stats.Capacity) {
+			t.Errorf("%s: expected the queue to give back its room, got room for %d", name, stats.Capacity)
+		}
+
+		if stats.Tracked > 10 {
+			t.Errorf("%s: expected only the users with songs queued to be tracked, got %d", name, stats.Tracked)
+		}
+
+		for songId := uint32(pushed - 9); songId <= pushed; songId++ {
+			if song := queuer.pop(); song == nil || song.SongId != songId {
+				t.Fatalf("%s: expected song %d to pop, but got %v", name, songId, song)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Lets users tag queued and played songs with words like "chill", "banger"
+ * or "throwback". Tags belong to the song rather than one submission of it,
+ * so a song keeps its tags however many times it's queued again. Tagged songs
+ * can be searched for, queued by tag and drawn on by the auto dj.
+ */
+
+package backend
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	maxTagLength       = 24  // most characters in a tag
+	defaultTagResults  = 50  // songs returned by a tag search when the client doesn't ask
+	maxTagResults      = 200 // most songs a client can ask a tag search for
+	queueTaggedChoices = 20  // tagged songs drawn from the history when queueing by tag
+)
+
+/*
+ * Returns a tag the way it's stored, trimmed and in lower case
+ */
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+/*
+ * Tags a song that's queued, playing or in the history
+ */
+func (s *BackendServer) TagSong(con context.Context, tag *bepb.SongTag) (*bepb.Error, error) {
+	song, message := s.taggableSong(con, tag)
+	if song == nil {
+		return &bepb.Error{Success: false, Message: message}, nil
+	}
+
+	name := normalizeTag(tag.GetTag())
+	added, err := s.dbFor(con).AddSongTag(song.Service, song.ServiceId, name, tag.GetUserId())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to save the tag."}, nil
+	} else if !added {
+		return &bepb.Error{Success: false, Message: "Already tagged " + name + "."}, nil
+	}
+
+	s.touchUser(tag.GetUserId())
+	log.Printf("Tagged song %s with %s for user %d", song.ServiceId, name, tag.GetUserId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Removes a tag the user put on a song
+ */
+func (s *BackendServer) UntagSong(con context.Context, tag *bepb.SongTag) (*bepb.Error, error) {
+	song, message := s.taggableSong(con, tag)
+	if song == nil {
+		return &bepb.Error{Success: false, Message: message}, nil
+	}
+
+	name := normalizeTag(tag.GetTag())
+	removed, err := s.dbFor(con).RemoveSongTag(song.Service, song.ServiceId, name, tag.GetUserId())
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to remove the tag."}, nil
+	} else if !removed {
+		return &bepb.Error{Success: false, Message: "You didn't tag that song " + name + "."}, nil
+	}
+
+	log.Printf("Untagged song %s from %s for user %d", song.ServiceId, name, tag.GetUserId())
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Finds the songs in the history with a tag starting with the one searched
+ * for, most recently played first
+ */
+func (s *BackendServer) FindTagged(con context.Context, search *bepb.TagSearch) (*bepb.TaggedSongList, error) {
+	limit := int(search.GetLimit())
+	if limit == 0 {
+		limit = defaultTagResults
+	} else if limit > maxTagResults {
+		limit = maxTagResults
+	}
+
+	songs, err := s.dbFor(con).FindTaggedSongs(normalizeTag(search.GetTag()), limit)
+	if err != nil {
+		return &bepb.TaggedSongList{Err: &bepb.Error{Success: false, Message: "Failed to search the tags."}}, nil
+	}
+
+	return &bepb.TaggedSongList{Songs: songs, Err: &bepb.Error{Success: true, Message: "Success"}}, nil
+}
+
+/*
+ * Queues a song from the history with the tag for the user, as though they'd
+ * submitted it. Songs the auto dj would consider played too recently are
+ * passed over unless every song with the tag was.
+ */
+func (s *BackendServer) QueueTagged(con context.Context, req *bepb.TagRequest) (*bepb.Error, error) {
+	name := normalizeTag(req.GetTag())
+	candidates, err := s.dbFor(con).GetTaggedFallbackCandidates(name, time.Now().Add(-s.autoDj.avoidRecent),
+		queueTaggedChoices)
+	if err == nil && len(candidates) == 0 {
+		candidates, err = s.dbFor(con).GetTaggedFallbackCandidates(name, time.Now(), queueTaggedChoices)
+	}
+
+	if err != nil {
+		return &bepb.Error{Success: false, Message: "Failed to look up the songs tagged " + name + "."}, nil
+	} else if len(candidates) == 0 {
+		return &bepb.Error{Success: false, Message: "No songs are tagged " + name + "."}, nil
+	}
+
+	choice := candidates[0]
+	if zone, exists := s.zones.get(req.GetZoneId()); exists {
+		if chosen := chooseFallback(candidates, zone.queueMgr.NowPlaying(), "", false); chosen != nil {
+			choice = chosen
+		}
+	}
+
+	log.Printf("Queueing %s tagged %s for user %d", choice.Song.ServiceId, name, req.GetUserId())
+	return s.SendSong(con, &bepb.Submission{
+		Link:   serviceLink(choice.Song.Service, choice.Song.ServiceId),
+		UserId: req.GetUserId(),
+		ZoneId: req.GetZoneId(),
+	})
+}
+
+/*
+ * Has the auto dj pick songs with a tag when it fills in for an empty queue.
+ * An empty tag has it pick from the whole history again.
+ */
+func (s *BackendServer) SetAutoDjTag(con context.Context, search *bepb.TagSearch) (*bepb.Error, error) {
+	name := normalizeTag(search.GetTag())
+	s.autoDj.useTag(name)
+
+	if name == "" {
+		log.Printf("Auto dj picks from the whole history")
+	} else {
+		log.Printf("Auto dj picks songs tagged %s", name)
+	}
+
+	return &bepb.Error{Success: true, Message: "Success"}, nil
+}
+
+/*
+ * Returns the song a tag is put on or taken off of, looking in the zone's
+ * queue before the history so songs that were just queued can be tagged.
+ * Returns nil and a message for the user if there's no such song.
+ */
+func (s *BackendServer) taggableSong(con context.Context, tag *bepb.SongTag) (*cmpb.Song, string) {
+	if username, _ := s.getUserFromId(tag.GetUserId()); username == "" {
+		return nil, "User does not exist."
+	}
+
+	zone, exists := s.zones.get(tag.GetZoneId())
+	if !exists {
+		return nil, ErrZoneNotFound.Error()
+	}
+
+	if song := zone.queueMgr.FindSong(tag.GetSongId()); song != nil {
+		return song, ""
+	}
+
+	song, err := s.dbFor(con).GetSongById(tag.GetSongId())
+	if err != nil {
+		return nil, "Song does not exist."
+	}
+
+	return song, ""
+}
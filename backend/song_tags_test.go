@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestTagSong_findsAndPicksByTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	for _, serviceId := range []string{"mellow", "loud"} {
+		song := &cmpb.Song{Title: serviceId, Service: cmpb.ServiceType_Youtube, ServiceId: serviceId,
+			UserId: bob.User.UserId, RoomId: room.Room.Id}
+		if err := server.queueSong(server.zones.defaultZone, song); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the song is tagged while it's still queued
+	mellow := server.queueMgr.GetPlaylist().Songs[0]
+	tag := &bepb.SongTag{SongId: mellow.SongId, UserId: bob.User.UserId, Tag: "chill"}
+	if response, _ := server.TagSong(context.Background(), tag); !response.Success {
+		t.Fatalf("Expected the song to be tagged, got %v", response)
+	}
+
+	if response, _ := server.TagSong(context.Background(), tag); response.Success {
+		t.Error("Expected tagging the song again to fail")
+	}
+
+	server.writes.flush()
+	found, _ := server.FindTagged(context.Background(), &bepb.TagSearch{Tag: "ch"})
+	if !found.Err.Success || len(found.Songs) != 1 || found.Songs[0].Song.ServiceId != "mellow" {
+		t.Fatalf("Expected the tagged song to be found, got %v", found)
+	}
+
+	// songs played just now may be picked again
+	server.autoDj.enabled = true
+	server.autoDj.avoidRecent = -time.Hour
+	server.autoDj.useTag("chill")
+	for i := 0; i < 3; i++ {
+		if song := server.autoDj.pick(nil); song == nil || song.ServiceId != "mellow" {
+			t.Fatalf("Expected the auto dj to pick the tagged song, got %v", song)
+		}
+	}
+
+	if response, _ := server.UntagSong(context.Background(), tag); !response.Success {
+		t.Errorf("Expected the tag to be removed, got %v", response)
+	}
+
+	response, _ := server.QueueTagged(context.Background(), &bepb.TagRequest{UserId: bob.User.UserId, Tag: "chill"})
+	if response.Success {
+		t.Errorf("Expected nothing to be queued once the tag is gone, got %v", response)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Estimates when a newly submitted song would start playing. When a
+ * submission window is configured, songs that wouldn't start within it are
+ * turned away so people don't stack up songs they won't be around to hear.
+ */
+
+package backend
+
+import (
+	"time"
+
+	"github.com/rickb777/date/period"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const defaultSongEstimate = 4 * time.Minute // length assumed for songs without a duration
+
+/*
+ * Estimate when the song would start playing if it were queued now, based on
+ * the time left on the now playing song and the length of every song ahead of
+ * it in the queue
+ */
+func estimateStart(queueMgr *queuer.SongQueueManager, song *cmpb.Song, now time.Time) time.Time {
+	wait := timeLeft(queueMgr, now)
+	for _, ahead := range queueMgr.SongsAhead(song) {
+		wait += songLength(ahead)
+	}
+
+	return now.Add(wait)
+}
+
+/*
+ * Returns the songs in the queue submitted by the user or queued for them,
+ * with their places in the queue and when they're estimated to start. The
+ * user's anonymous songs are left out unless asked for.
+ */
+func queuedByUser(queueMgr *queuer.SongQueueManager, userId uint32, anonymous bool,
+	now time.Time) []*bepb.QueuedSong {
+	songs := make([]*bepb.QueuedSong, 0)
+	wait := timeLeft(queueMgr, now)
+
+	for index, song := range queueMgr.GetPlaylist().Songs {
+		mine := song.UserId == userId || (song.ForUserId != 0 && song.ForUserId == userId)
+		if mine && (anonymous || !song.Anonymous) {
+			songs = append(songs, &bepb.QueuedSong{
+				Song:           publicSong(song),
+				Position:       uint32(index + 1),
+				EstimatedStart: now.Add(wait).Unix(),
+			})
+		}
+		wait += songLength(song)
+	}
+
+	return songs
+}
+
+/*
+ * Returns the time left on the now playing song
+ */
+func timeLeft(queueMgr *queuer.SongQueueManager, now time.Time) time.Duration {
+	if playing, since := queueMgr.NowPlayingSince(); playing != nil {
+		if remaining := songLength(playing) - now.Sub(since); remaining > 0 {
+			return remaining
+		}
+	}
+
+	return 0
+}
+
+/*
+ * Returns the length of a song. Songs with a missing or unreadable duration,
+ * such as auto dj picks, are assumed to be of average length.
+ */
+func songLength(song *cmpb.Song) time.Duration {
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil || duration.IsZero() {
+		return defaultSongEstimate
+	}
+
+	return duration.DurationApprox()
+}
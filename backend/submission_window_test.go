@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func queuedSong(songId uint32, userId uint32, duration string) *cmpb.Song {
+	return &cmpb.Song{
+		SongId:   songId,
+		UserId:   userId,
+		Metadata: &cmpb.Metadata{Duration: duration},
+	}
+}
+
+func TestEstimateStart_emptyQueue_startsNow(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+
+	now := time.Now()
+	start := estimateStart(queueMgr, queuedSong(1, 1, "PT3M"), now)
+	if !start.Equal(now) {
+		t.Errorf("Expected song to start now, but got %v", start.Sub(now))
+	}
+}
+
+func TestEstimateStart_countsOnlySongsAhead(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+	queueMgr.AddSong(queuedSong(1, 1, "PT4M"))
+	queueMgr.AddSong(queuedSong(2, 1, "PT5M"))
+	queueMgr.AddSong(queuedSong(3, 2, "PT3M"))
+
+	// user 3 hasn't queued anything, so their song goes ahead of user 1's
+	// second song
+	now := time.Now()
+	start := estimateStart(queueMgr, queuedSong(4, 3, "PT3M"), now)
+	if wait := start.Sub(now); wait != 7*time.Minute {
+		t.Errorf("Expected a 7m wait, but got %v", wait)
+	}
+
+	// user 2's next song goes behind everything
+	start = estimateStart(queueMgr, queuedSong(5, 2, "PT3M"), now)
+	if wait := start.Sub(now); wait != 12*time.Minute {
+		t.Errorf("Expected a 12m wait, but got %v", wait)
+	}
+}
+
+func TestEstimateStart_includesNowPlaying(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+	queueMgr.AddSong(queuedSong(1, 1, "PT4M"))
+	queueMgr.AddSong(queuedSong(2, 2, ""))
+	queueMgr.PopQueue()
+
+	// an hour later the now playing song has long finished
+	start := estimateStart(queueMgr, queuedSong(3, 3, "PT3M"), time.Now().Add(time.Hour))
+	if wait := time.Until(start) - time.Hour; wait > defaultSongEstimate+time.Second || wait < defaultSongEstimate-time.Second {
+		t.Errorf("Expected to only wait for the queued song, but got %v", wait)
+	}
+
+	now := time.Now()
+	start = estimateStart(queueMgr, queuedSong(3, 3, "PT3M"), now)
+	if wait := start.Sub(now); wait <= defaultSongEstimate+3*time.Minute ||
+		wait > defaultSongEstimate+4*time.Minute {
+		t.Errorf("Expected to wait for the rest of the now playing song, but got %v", wait)
+	}
+}
+
+func TestQueuedByUser_listsPositionsAndStarts(t *testing.T) {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+	queueMgr.AddSong(queuedSong(1, 1, "PT4M"))
+	queueMgr.AddSong(queuedSong(2, 1, "PT5M"))
+	queueMgr.AddSong(queuedSong(3, 2, "PT3M"))
+
+	// user 2 queued this one for user 1
+	gift := queuedSong(4, 2, "PT3M")
+	gift.ForUserId = 1
+	queueMgr.AddSong(gift)
+
+	now := time.Now()
+	songs := queuedByUser(queueMgr, 1, true, now)
+
+	expected := []struct {
+		songId   uint32
+		position uint32
+		wait     time.Duration
+	}{{1, 1, 0}, {2, 3, 7 * time.Minute}, {4, 4, 12 * time.Minute}}
+
+	if len(songs) != len(expected) {
+		t.Fatalf("Expected %d songs, but got %v", len(expected), songs)
+	}
+
+	for i, song := range songs {
+		if song.Song.SongId != expected[i].songId || song.Position != expected[i].position ||
+			song.EstimatedStart != now.Add(expected[i].wait).Unix() {
+			t.Errorf("Expected song %d at position %d after %v, but got %v", expected[i].songId,
+				expected[i].position, expected[i].wait, song)
+		}
+	}
+
+	if songs := queuedByUser(queueMgr, 3, true, now); len(songs) != 0 {
+		t.Errorf("Expected no songs for a user with nothing queued, but got %v", songs)
+	}
+}
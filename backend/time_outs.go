@@ -0,0 +1,140 @@
+/*
+ * Time-outs are a softer alternative to banning a user. A timed out user's
+ * queued songs move to the end of the queue and stay behind the songs queued
+ * after them, and the user can't submit songs until the time-out ends on its
+ * own or an admin lifts it. Time-outs aren't saved, so restarting the server
+ * lifts them.
+ */
+
+package backend
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+var ErrNotTimedOut = errors.New("The user isn't timed out.")
+
+/*
+ * A user's time-out and the timer ending it
+ */
+type timeOut struct {
+	until time.Time   // when the time-out ends
+	timer *time.Timer // ends the time-out once it's over
+}
+
+/*
+ * Keeps track of the users who are timed out and ends their time-outs
+ */
+type timeOutTracker struct {
+	timeOuts map[uint32]*timeOut // user id -> the user's time-out
+	expire   func(userId uint32) // called once a user's time-out ends or is lifted
+	lock     sync.Mutex          // lock on the time-outs
+}
+
+/*
+ * Initialize the tracker with the function called when a time-out ends
+ */
+func (t *timeOutTracker) init(expire func(userId uint32)) {
+	t.timeOuts = make(map[uint32]*timeOut)
+	t.expire = expire
+}
+
+/*
+ * Time a user out for the duration, replacing any time-out they already
+ * have. Returns when the time-out ends.
+ */
+func (t *timeOutTracker) give(userId uint32, duration time.Duration, now time.Time) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if current, exists := t.timeOuts[userId]; exists {
+		current.timer.Stop()
+	}
+
+	given := &timeOut{until: now.Add(duration)}
+	given.timer = time.AfterFunc(duration, func() { t.end(userId, given) })
+	t.timeOuts[userId] = given
+	return given.until
+}
+
+/*
+ * Lift a user's time-out before it ends
+ */
+func (t *timeOutTracker) lift(userId uint32) error {
+	t.lock.Lock()
+	current, exists := t.timeOuts[userId]
+	t.lock.Unlock()
+
+	if !exists || !t.end(userId, current) {
+		return ErrNotTimedOut
+	}
+
+	return nil
+}
+
+/*
+ * End a time-out if it's still the user's current one. Returns false if it
+ * already ended or was replaced.
+ */
+func (t *timeOutTracker) end(userId uint32, ended *timeOut) bool {
+	t.lock.Lock()
+	if t.timeOuts[userId] != ended {
+		t.lock.Unlock()
+		return false
+	}
+
+	ended.timer.Stop()
+	delete(t.timeOuts, userId)
+	t.lock.Unlock()
+
+	t.expire(userId)
+	return true
+}
+
+/*
+ * Returns when the user's time-out ends and true if the user is timed out
+ */
+func (t *timeOutTracker) active(userId uint32, now time.Time) (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	current, exists := t.timeOuts[userId]
+	if !exists || !now.Before(current.until) {
+		return time.Time{}, false
+	}
+
+	return current.until, true
+}
+
+/*
+ * Returns the time-outs ordered by user id
+ */
+func (t *timeOutTracker) list() []*bepb.TimeOut {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	timeOuts := make([]*bepb.TimeOut, 0, len(t.timeOuts))
+	for userId, current := range t.timeOuts {
+		timeOuts = append(timeOuts, &bepb.TimeOut{UserId: userId, Until: current.until.Unix()})
+	}
+
+	sort.Slice(timeOuts, func(i, j int) bool { return timeOuts[i].UserId < timeOuts[j].UserId })
+	return timeOuts
+}
+
+/*
+ * Stop the timers ending the time-outs, such as when the server stops
+ */
+func (t *timeOutTracker) stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, current := range t.timeOuts {
+		current.timer.Stop()
+	}
+}
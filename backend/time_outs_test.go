@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestTimeOutTracker_expiresOnItsOwn(t *testing.T) {
+	expired := make(chan uint32, 2)
+	tracker := new(timeOutTracker)
+	tracker.init(func(userId uint32) { expired <- userId })
+
+	now := time.Now()
+	tracker.give(1, time.Hour, now)
+	tracker.give(1, 10*time.Millisecond, now)
+
+	if until, timedOut := tracker.active(1, now); !timedOut || !until.Equal(now.Add(10*time.Millisecond)) {
+		t.Fatalf("Expected the second time-out to replace the first, got %v", until)
+	}
+
+	select {
+	case userId := <-expired:
+		if userId != 1 {
+			t.Errorf("Expected user 1's time-out to end, got user %d", userId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the time-out to end on its own")
+	}
+
+	if _, timedOut := tracker.active(1, time.Now()); timedOut {
+		t.Error("Expected the user to be free to submit again")
+	}
+
+	if err := tracker.lift(1); err != ErrNotTimedOut {
+		t.Errorf("Expected nothing to lift, got %v", err)
+	}
+
+	select {
+	case <-expired:
+		t.Error("Expected the replaced time-out not to end the user's time-out again")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestTimeOutUser_demotesSongsAndBlocksSubmissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_time_outs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, _ := server.dbManager.AddUser("Bob", room.Room.Id)
+	alice, _ := server.dbManager.AddUser("Alice", room.Room.Id)
+
+	server.queueMgr.AddSong(queuedSong(1, bob.User.UserId, "PT3M"))
+	server.queueMgr.AddSong(queuedSong(2, alice.User.UserId, "PT3M"))
+
+	response, _ := server.TimeOutUser(context.Background(), &bepb.TimeOut{UserId: bob.User.UserId, Seconds: 600})
+	if !response.Success {
+		t.Fatalf("Expected Bob to be timed out, got %v", response)
+	}
+
+	// songs queued during the time-out still go ahead of Bob's
+	server.queueMgr.AddSong(queuedSong(3, alice.User.UserId, "PT3M"))
+	if order := playlistIds(server.queueMgr); len(order) != 3 || order[0] != 2 || order[1] != 3 || order[2] != 1 {
+		t.Errorf("Expected Bob's song at the end of the queue, got %v", order)
+	}
+
+	sent, _ := server.SendSong(context.Background(), &bepb.Submission{UserId: bob.User.UserId,
+		Link: "https://youtu.be/bL_NcoCJgzo"})
+	if sent.Success {
+		t.Error("Expected Bob's submission to be turned away")
+	}
+
+	list, _ := server.ListTimeOuts(context.Background(), &cmpb.Empty{})
+	if len(list.TimeOuts) != 1 || list.TimeOuts[0].Username != "Bob" {
+		t.Errorf("Expected Bob to be listed, got %v", list)
+	}
+
+	if response, _ = server.EndTimeOut(context.Background(), &bepb.TimeOut{UserId: bob.User.UserId}); !response.Success {
+		t.Errorf("Expected Bob's time-out to be lifted, got %v", response)
+	}
+
+	if message := server.timedOutMessage(bob.User.UserId); message != "" {
+		t.Errorf("Expected Bob to submit again, got %s", message)
+	}
+}
+
+func playlistIds(queueMgr *queuer.SongQueueManager) []uint32 {
+	var ids []uint32
+	for _, song := range queueMgr.GetPlaylist().Songs {
+		ids = append(ids, song.SongId)
+	}
+	return ids
+}
@@ -0,0 +1,200 @@
+/*
+ * Cleans up the titles songs are uploaded with. Titles run through a pipeline
+ * of steps that strip noise like "(Official Video) [HD] 4K" and normalize how
+ * the artist and title are written, so "Artist – Song ft Guest (Lyrics)" comes
+ * out as "Artist - Song feat. Guest". Songs keep both their raw and cleaned
+ * titles and the backend is configured with which one is shown.
+ */
+
+package backend
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var (
+	bracketed     = regexp.MustCompile(`\s*[(\[【]([^)\]】]*)[)\]】]`)
+	titleDashes   = regexp.MustCompile(`\s*[–—―]\s*|\s+-{1,2}\s+|\s+-{2}|-{2}\s+`)
+	featuring     = regexp.MustCompile(`(?i)\b(?:ft|feat|featuring)\b\.?\s+`)
+	emptyBrackets = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+)
+
+/*
+ * Words that only ever make up noise when in brackets, like "Official Music
+ * Video" or "2011 Remaster"
+ */
+var noiseWords = map[string]bool{
+	"official": true, "officiel": true, "oficial": true, "music": true, "video": true, "videoclip": true,
+	"clip": true, "audio": true, "lyric": true, "lyrics": true, "with": true, "visualizer": true,
+	"visualiser": true, "hd": true, "hq": true, "4k": true, "8k": true, "uhd": true, "1080p": true,
+	"720p": true, "480p": true, "2160p": true, "mv": true, "m/v": true, "remaster": true,
+	"remastered": true, "explicit": true, "version": true, "full": true, "color": true, "coded": true,
+}
+
+/*
+ * Quality tags that are noise even when trailing the title without brackets
+ */
+var trailingNoise = map[string]bool{
+	"hd": true, "hq": true, "4k": true, "8k": true, "uhd": true, "1080p": true, "720p": true, "2160p": true,
+}
+
+/*
+ * Steps of the title cleanup pipeline, in the order they run
+ */
+var titleSteps = []func(string) string{
+	stripBracketedNoise,
+	stripTrailingNoise,
+	normalizeDashes,
+	normalizeFeaturing,
+	tidySpacing,
+}
+
+/*
+ * Run a title through the cleanup pipeline. Returns the raw title if nothing
+ * would be left of it.
+ */
+func cleanTitle(raw string) string {
+	title := raw
+	for _, step := range titleSteps {
+		title = step(title)
+	}
+
+	if title == "" {
+		return strings.TrimSpace(raw)
+	}
+
+	return title
+}
+
+/*
+ * Record the raw and cleaned titles of a freshly fetched song and show the one
+ * the backend is configured with. The artist named in the title, if any, takes
+ * the place of the channel the fetcher filled in.
+ */
+func applyTitle(song *cmpb.Song, useRaw bool) {
+	song.RawTitle = song.Title
+	song.CleanTitle = cleanTitle(song.Title)
+	if !useRaw {
+		song.Title = song.CleanTitle
+	}
+
+	if artist, _ := splitArtist(song.CleanTitle); artist != "" {
+		song.Artist = artist
+	} else {
+		song.Artist = channelArtist(song.Artist)
+	}
+
+	applyRomanized(song, useRaw)
+}
+
+/*
+ * Returns the artist behind a channel name, without the " - Topic" of auto
+ * generated YouTube channels or the "VEVO" of label run ones
+ */
+func channelArtist(channel string) string {
+	artist := strings.TrimSuffix(strings.TrimSpace(channel), " - Topic")
+	return strings.TrimSpace(strings.TrimSuffix(artist, "VEVO"))
+}
+
+/*
+ * Returns true if two songs are the same song, either because they link to
+ * the same video, because their shown titles match after ignoring case,
+ * spacing and punctuation or because they're uploads of the same track on
+ * different services. Different chapters of a video are different songs.
+ */
+func sameSong(a *cmpb.Song, b *cmpb.Song) bool {
+	if a.Service == b.Service && a.ServiceId == b.ServiceId {
+		return a.Chapter == b.Chapter
+	}
+
+	key := titleKey(a.Title)
+	return (key != "" && key == titleKey(b.Title)) || sameTrack(a, b)
+}
+
+/*
+ * Reduce a title to lowercase letters and digits for comparing
+ */
+func titleKey(title string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, title)
+}
+
+/*
+ * Remove bracketed parts of the title that are nothing but noise words and
+ * numbers
+ */
+func stripBracketedNoise(title string) string {
+	return bracketed.ReplaceAllStringFunc(title, func(part string) string {
+		inner := bracketed.FindStringSubmatch(part)[1]
+		if isNoise(inner) {
+			return ""
+		}
+		return part
+	})
+}
+
+/*
+ * Returns true if the text is made of noise words and numbers, with at least
+ * one noise word
+ */
+func isNoise(text string) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '|' || r == ','
+	})
+
+	noise := false
+	for _, word := range words {
+		switch {
+		case noiseWords[word]:
+			noise = true
+		case strings.IndexFunc(word, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0:
+			return false
+		}
+	}
+
+	return noise
+}
+
+/*
+ * Remove quality tags like "HD" or "4K" from the end of the title
+ */
+func stripTrailingNoise(title string) string {
+	words := strings.Fields(title)
+	for len(words) > 1 && trailingNoise[strings.ToLower(words[len(words)-1])] {
+		words = words[:len(words)-1]
+	}
+
+	return strings.Join(words, " ")
+}
+
+/*
+ * Write the separator between the artist and title as a spaced hyphen
+ */
+func normalizeDashes(title string) string {
+	return titleDashes.ReplaceAllString(title, " - ")
+}
+
+/*
+ * Write featured artists as "feat."
+ */
+func normalizeFeaturing(title string) string {
+	return featuring.ReplaceAllString(title, "feat. ")
+}
+
+/*
+ * Drop empty brackets, collapse runs of spaces and trim separators left
+ * dangling at either end
+ */
+func tidySpacing(title string) string {
+	title = emptyBrackets.ReplaceAllString(title, "")
+	title = strings.Join(strings.Fields(title), " ")
+	return strings.Trim(title, " -|~")
+}
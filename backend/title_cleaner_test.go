@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"Daft Punk - One More Time (Official Video) [HD] 4K", "Daft Punk - One More Time"},
+		{"Queen – Bohemian Rhapsody (Remastered 2011)", "Queen - Bohemian Rhapsody"},
+		{"Drake—Hotline Bling [Official Music Video]", "Drake - Hotline Bling"},
+		{"Calvin Harris ft Rihanna -- This Is What You Came For (Lyrics)", "Calvin Harris feat. Rihanna - This Is What You Came For"},
+		{"Artist - Song (feat. Guest) (Audio)", "Artist - Song (feat. Guest)"},
+		{"Jay-Z - 99 Problems (Live at Madison Square Garden)", "Jay-Z - 99 Problems (Live at Madison Square Garden)"},
+		{"Soft Cell - Tainted Love (1981)", "Soft Cell - Tainted Love (1981)"},
+		{"[Official Video]", "[Official Video]"},
+	}
+
+	for _, test := range tests {
+		if cleaned := cleanTitle(test.raw); cleaned != test.expected {
+			t.Errorf("Cleaning %q: expected %q, but got %q", test.raw, test.expected, cleaned)
+		}
+	}
+}
+
+func TestApplyTitle_whenRawTitles_showsRaw(t *testing.T) {
+	raw := "Daft Punk - One More Time (Official Video)"
+
+	song := &cmpb.Song{Title: raw}
+	applyTitle(song, false)
+	if song.Title != "Daft Punk - One More Time" || song.RawTitle != raw || song.CleanTitle != song.Title {
+		t.Errorf("Expected the cleaned title to be shown, but got %v", song)
+	}
+
+	song = &cmpb.Song{Title: raw}
+	applyTitle(song, true)
+	if song.Title != raw || song.CleanTitle != "Daft Punk - One More Time" {
+		t.Errorf("Expected the raw title to be shown, but got %v", song)
+	}
+}
+
+func TestApplyTitle_setsArtist(t *testing.T) {
+	tests := []struct {
+		title    string
+		channel  string
+		expected string
+	}{
+		{"Daft Punk - One More Time (Official Video)", "DaftPunkVEVO", "Daft Punk"},
+		{"One More Time", "Daft Punk - Topic", "Daft Punk"},
+		{"One More Time", "DaftPunkVEVO", "DaftPunk"},
+		{"One More Time", "", ""},
+	}
+
+	for _, test := range tests {
+		song := &cmpb.Song{Title: test.title, Artist: test.channel}
+		applyTitle(song, false)
+		if song.Artist != test.expected {
+			t.Errorf("Applying %q from %q: expected artist %q, but got %q", test.title, test.channel, test.expected, song.Artist)
+		}
+	}
+}
+
+func TestSameSong_whenTitlesMatch_true(t *testing.T) {
+	song := &cmpb.Song{Title: "Daft Punk - One More Time", Service: cmpb.ServiceType_Youtube, ServiceId: "a"}
+
+	if !sameSong(song, &cmpb.Song{Title: "daft punk: one more time", Service: cmpb.ServiceType_Youtube, ServiceId: "b"}) {
+		t.Errorf("Songs with matching titles should be the same song")
+	}
+
+	if sameSong(song, &cmpb.Song{Title: "Daft Punk - Aerodynamic", Service: cmpb.ServiceType_Youtube, ServiceId: "c"}) {
+		t.Errorf("Songs with different titles and ids should not be the same song")
+	}
+
+	if sameSong(&cmpb.Song{ServiceId: "d"}, &cmpb.Song{ServiceId: "e"}) {
+		t.Errorf("Songs without titles should only match by id")
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * Keeps a romanized variant of titles written in other scripts, so screens
+ * that can't draw every script, or guests who can't read it, still get a
+ * readable title. The variant comes from the service when it has a title in
+ * Latin letters, like a YouTube video's English localization. Otherwise
+ * Korean, Japanese kana and Cyrillic titles are transliterated letter by
+ * letter. Titles with letters that can't be spelled out that way, like Chinese
+ * characters, are left without one. Songs also carry the language of their
+ * title when the service reports it or the script gives it away.
+ */
+
+package backend
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	hangulFirst  = 0xAC00 // first precomposed Hangul syllable
+	hangulLast   = 0xD7A3 // last precomposed Hangul syllable
+	hangulVowels = 21     // vowels a syllable can have
+	hangulFinals = 28     // final consonants a syllable can end with, counting none
+	kanaOffset   = 0x60   // distance from a hiragana to the same katakana
+	longVowel    = 'ー'    // katakana mark making the vowel before it long
+	smallTsu     = 'っ'    // doubles the consonant after it
+)
+
+/*
+ * Revised Romanization of the leading consonants, vowels and final consonants
+ * of Hangul syllables, in the order of their Unicode code points
+ */
+var (
+	hangulLeads   = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulMedials = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo",
+		"we", "wi", "yu", "eu", "ui", "i"}
+	hangulTails = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p",
+		"t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+)
+
+/*
+ * Hepburn romanization of the hiragana. Katakana are looked up as the same
+ * hiragana.
+ */
+var kanaRomaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+}
+
+/*
+ * Small kana that join the kana before them, like the ゃ of きゃ "kya" or the
+ * ァ of ファ "fa"
+ */
+var smallKana = map[rune]string{
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+}
+
+/*
+ * Romanization of the Cyrillic letters of Russian and Ukrainian
+ */
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+/*
+ * East Asian punctuation and the Latin letters they stand in for
+ */
+var asianPunctuation = map[rune]string{
+	'、': ", ", '。': ". ", '「': "\"", '」': "\"", '『': "\"", '』': "\"", '・': " ", '〜': "~", '　': " ",
+}
+
+/*
+ * Returns true if every letter of the text is a Latin letter
+ */
+func latinText(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Returns the language the script of a title gives away, or an empty string
+ * if it doesn't. Kana only appear in Japanese and Hangul only in Korean.
+ */
+func titleLanguage(title string) string {
+	for _, r := range title {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			return "ja"
+		case unicode.Is(unicode.Hangul, r):
+			return "ko"
+		}
+	}
+	return ""
+}
+
+/*
+ * Spell out a title in Latin letters. Returns an empty string if the title is
+ * already in Latin letters or has letters that can't be spelled out.
+ */
+func romanize(title string) string {
+	if latinText(title) {
+		return ""
+	}
+
+	var out strings.Builder
+	doubleNext := false
+	for i := 0; i < len(title); {
+		r, size := utf8.DecodeRuneInString(title[i:])
+		i += size
+
+		// fullwidth letters and digits are the ascii ones
+		if r >= '！' && r <= '～' {
+			r -= 0xFEE0
+		}
+
+		spelled, ok := romanizeRune(r)
+		switch {
+		case r == smallTsu || r == smallTsu+kanaOffset:
+			doubleNext = true
+			continue
+		case smallKana[hiragana(r)] != "":
+			spelled = joinSmallKana(&out, smallKana[hiragana(r)])
+		case r == longVowel:
+			spelled = lastVowel(out.String())
+		case !ok:
+			return ""
+		}
+
+		if doubleNext && spelled != "" {
+			if strings.HasPrefix(spelled, "ch") {
+				out.WriteString("t")
+			} else if !strings.ContainsRune("aeiou", rune(spelled[0])) {
+				out.WriteByte(spelled[0])
+			}
+		}
+		doubleNext = false
+		out.WriteString(spelled)
+	}
+
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+/*
+ * Spell out one character of a title. Returns false for letters that can't be
+ * spelled out. Anything that isn't a letter stays as it is.
+ */
+func romanizeRune(r rune) (string, bool) {
+	if r >= hangulFirst && r <= hangulLast {
+		syllable := int(r - hangulFirst)
+		lead := syllable / (hangulVowels * hangulFinals)
+		medial := syllable % (hangulVowels * hangulFinals) / hangulFinals
+		tail := syllable % hangulFinals
+		return hangulLeads[lead] + hangulMedials[medial] + hangulTails[tail], true
+	}
+
+	if spelled, exists := kanaRomaji[hiragana(r)]; exists {
+		return spelled, true
+	}
+
+	if spelled, exists := cyrillicLatin[unicode.ToLower(r)]; exists {
+		if unicode.IsUpper(r) && spelled != "" {
+			spelled = strings.ToUpper(spelled[:1]) + spelled[1:]
+		}
+		return spelled, true
+	}
+
+	if spelled, exists := asianPunctuation[r]; exists {
+		return spelled, true
+	}
+
+	if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+		return "", false
+	}
+	return string(r), true
+}
+
+/*
+ * Returns the hiragana a katakana stands for, or the rune itself if it isn't
+ * a katakana
+ */
+func hiragana(r rune) rune {
+	if r >= 'ァ' && r <= 'ヶ' {
+		return r - kanaOffset
+	}
+	return r
+}
+
+/*
+ * Join a small kana to the kana before it. The small ya, yu and yo take the
+ * place of the i that kana ends with, or of its y sound after sh, ch and j.
+ * The small vowels take the place of the kana's vowel. Returns what to write
+ * after the rewritten kana.
+ */
+func joinSmallKana(out *strings.Builder, small string) string {
+	written := out.String()
+	if !strings.HasSuffix(written, "i") && !strings.HasSuffix(written, "u") && !strings.HasSuffix(written, "e") &&
+		!strings.HasSuffix(written, "o") {
+		return small
+	}
+
+	before := written[:len(written)-1]
+	if strings.HasPrefix(small, "y") && (strings.HasSuffix(before, "sh") || strings.HasSuffix(before, "ch") ||
+		strings.HasSuffix(before, "j")) {
+		small = small[1:]
+	} else if !strings.HasPrefix(small, "y") && (before == "" || strings.HasSuffix(before, " ")) {
+		// a lone u before a small vowel, like ウィ, sounds like a w
+		before += "w"
+	}
+
+	out.Reset()
+	out.WriteString(before)
+	return small
+}
+
+/*
+ * Returns the vowel the text ends with, which a long vowel mark repeats
+ */
+func lastVowel(text string) string {
+	if text == "" || !strings.ContainsRune("aeiou", rune(text[len(text)-1])) {
+		return ""
+	}
+	return text[len(text)-1:]
+}
+
+/*
+ * Fill in the language of a song's title and its romanized variant, if it
+ * needs one. A romanized title from the service is cleaned up along with the
+ * title unless raw titles are shown, and the rest are spelled out from the
+ * title that's shown.
+ */
+func applyRomanized(song *cmpb.Song, useRaw bool) {
+	if song.Language == "" {
+		song.Language = titleLanguage(song.RawTitle)
+	}
+
+	if latinText(song.Title) {
+		song.RomanizedTitle = ""
+	} else if song.RomanizedTitle != "" && latinText(song.RomanizedTitle) {
+		if !useRaw {
+			song.RomanizedTitle = cleanTitle(song.RomanizedTitle)
+		}
+	} else {
+		song.RomanizedTitle = romanize(song.Title)
+	}
+}
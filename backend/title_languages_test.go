@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"testing"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestRomanize(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"PSY - 강남스타일", "PSY - gangnamseutail"},
+		{"아이유 - 밤편지", "aiyu - bampyeonji"},
+		{"ヨルシカ - ただ君に晴れ", ""},
+		{"きゃりーぱみゅぱみゅ", "kyariipamyupamyu"},
+		{"ずっと真夜中でいいのに。", ""},
+		{"ちょっと", "chotto"},
+		{"カップヌードル", "kappunuudoru"},
+		{"ファイナル・ファンタジー", "fainaru fantajii"},
+		{"Кино - Группа крови", "Kino - Gruppa krovi"},
+		{"Щедрик", "Shchedrik"},
+		{"Daft Punk - One More Time", ""},
+		{"周杰倫 - 晴天", ""},
+	}
+
+	for _, test := range tests {
+		if romanized := romanize(test.title); romanized != test.expected {
+			t.Errorf("Romanizing %q: expected %q, but got %q", test.title, test.expected, romanized)
+		}
+	}
+}
+
+func TestTitleLanguage(t *testing.T) {
+	tests := map[string]string{
+		"PSY - 강남스타일":         "ko",
+		"ヨルシカ - ただ君に晴れ":       "ja",
+		"周杰倫 - 晴天":            "",
+		"Кино - Группа крови": "",
+		"One More Time":       "",
+	}
+
+	for title, expected := range tests {
+		if language := titleLanguage(title); language != expected {
+			t.Errorf("Language of %q: expected %q, but got %q", title, expected, language)
+		}
+	}
+}
+
+func TestApplyTitle_keepsRomanizedTitle(t *testing.T) {
+	// the localized title from the service wins and is cleaned like the title
+	song := &cmpb.Song{Title: "PSY - 강남스타일 (Official Video)", RomanizedTitle: "PSY - Gangnam Style (Official Video)"}
+	applyTitle(song, false)
+	if song.RomanizedTitle != "PSY - Gangnam Style" || song.Language != "ko" {
+		t.Errorf("Expected the localized title cleaned up, but got %v", song)
+	}
+
+	// titles without one are spelled out
+	song = &cmpb.Song{Title: "Кино - Группа крови", Language: "ru"}
+	applyTitle(song, false)
+	if song.RomanizedTitle != "Kino - Gruppa krovi" || song.Language != "ru" {
+		t.Errorf("Expected the title spelled out, but got %v", song)
+	}
+
+	// titles in Latin letters don't need one
+	song = &cmpb.Song{Title: "Daft Punk - One More Time", RomanizedTitle: "Daft Punk - One More Time"}
+	applyTitle(song, false)
+	if song.RomanizedTitle != "" {
+		t.Errorf("Expected no romanized title, but got %q", song.RomanizedTitle)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Shows the songs coming up next on players in the final seconds of a song,
+ * like the queue preview on a Chromecast. The overlay is sent once a song's
+ * estimated position comes within the lead time of its end, so it always has
+ * the latest queue.
+ */
+
+package backend
+
+import (
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	upNextCount = 3                // songs shown in the overlay
+	upNextLead  = 20 * time.Second // how long before the end of a song the overlay is shown
+)
+
+/*
+ * Send the up next overlay to every player if the now playing song is in its
+ * final seconds and the overlay hasn't been shown for it yet. Assumes the
+ * caller holds the player lock.
+ */
+func (mgr *playerManager) showUpNext(now time.Time) {
+	report := mgr.position
+	if report == nil || report.paused || report.songId == mgr.upNextShown {
+		return
+	}
+
+	song := mgr.queueMgr.NowPlaying()
+	if song == nil || song.SongId != report.songId {
+		return
+	}
+
+	duration := songSeconds(song)
+	left := duration - report.elapsedAt(now)
+	if duration == 0 || left <= 0 || left > upNextLead.Seconds() {
+		return
+	}
+
+	overlay := upNextOverlay(mgr.queueMgr.GetPlaylist().Songs, left)
+	if overlay == nil {
+		// check again on the next report in case something gets queued
+		return
+	}
+
+	mgr.upNextShown = song.SongId
+	control := &bepb.PlayerControl{Command: bepb.CommandType_UpNext, UpNext: overlay}
+	for _, state := range mgr.streams {
+		go sendToStream(control, state.out)
+	}
+}
+
+/*
+ * Returns the overlay for the front of the queue, or nil if the queue is empty
+ */
+func upNextOverlay(queue []*cmpb.Song, secondsLeft float64) *bepb.UpNextOverlay {
+	if len(queue) == 0 {
+		return nil
+	}
+
+	if len(queue) > upNextCount {
+		queue = queue[:upNextCount]
+	}
+
+	overlay := &bepb.UpNextOverlay{SecondsLeft: secondsLeft}
+	for _, song := range queue {
+		overlay.Songs = append(overlay.Songs, &cmpb.Song{
+			SongId:    song.SongId,
+			Title:     song.Title,
+			Username:  song.Username,
+			Service:   song.Service,
+			ServiceId: song.ServiceId,
+			Metadata:  song.Metadata,
+		})
+	}
+
+	return overlay
+}
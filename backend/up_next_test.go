@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestUpNextOverlay_showsFrontOfQueue(t *testing.T) {
+	queue := []*cmpb.Song{
+		{SongId: 1, Title: "one", Username: "alice"},
+		{SongId: 2, Title: "two", Username: "bob"},
+		{SongId: 3, Title: "three", Username: "alice"},
+		{SongId: 4, Title: "four", Username: "carol"},
+	}
+
+	overlay := upNextOverlay(queue, 12)
+	if len(overlay.Songs) != upNextCount || overlay.SecondsLeft != 12 {
+		t.Fatalf("Expected %d songs with 12 seconds left, but got %v", upNextCount, overlay)
+	}
+
+	if overlay.Songs[0].SongId != 1 || overlay.Songs[1].Username != "bob" {
+		t.Errorf("Expected the songs in queue order with their submitters, but got %v", overlay.Songs)
+	}
+
+	if upNextOverlay(nil, 12) != nil {
+		t.Errorf("Expected no overlay for an empty queue")
+	}
+}
+
+func TestShowUpNext_whenSongEnding_showsOnce(t *testing.T) {
+	playerMgr := setupPlayerManager()
+	playerMgr.queueMgr.SetNowPlaying(&cmpb.Song{SongId: 7, Metadata: &cmpb.Metadata{Duration: "PT3M"}})
+
+	now := time.Now()
+	playerMgr.position = &reportedPosition{songId: 7, elapsed: 170, reportedAt: now}
+	playerMgr.showUpNext(now)
+	if playerMgr.upNextShown != 0 {
+		t.Errorf("Expected no overlay while the queue is empty")
+	}
+
+	playerMgr.queueMgr.AddSong(&cmpb.Song{SongId: 8, UserId: 1, Title: "next"})
+
+	playerMgr.position = &reportedPosition{songId: 7, elapsed: 100, reportedAt: now}
+	playerMgr.showUpNext(now)
+	if playerMgr.upNextShown != 0 {
+		t.Errorf("Expected no overlay before the final %v of the song", upNextLead)
+	}
+
+	playerMgr.position = &reportedPosition{songId: 7, elapsed: 170, reportedAt: now}
+	playerMgr.showUpNext(now)
+	if playerMgr.upNextShown != 7 {
+		t.Errorf("Expected the overlay to be shown in the final seconds of the song")
+	}
+}
@@ -73,3 +73,13 @@ func (c *UserCache) AddUserToCache(userId uint32, username string, roomId uint32
 
 	c.cache[userId] = &UserEntry{username, roomId}
 }
+
+/*
+ * Returns the number of users in the cache
+ */
+func (c *UserCache) Size() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return len(c.cache)
+}
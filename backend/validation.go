@@ -0,0 +1,578 @@
+/*
+ * Validates the fields of incoming requests before they reach the rpc
+ * handlers. Requests that break a constraint are turned away with an
+ * InvalidArgument status carrying a BadRequest detail for every bad field.
+ */
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	maxLinkLength     = 2048 // longest link that can be submitted
+	maxQueryLength    = 200  // longest search query
+	maxUsernameLength = 32   // longest username
+	maxNameLength     = 64   // longest room, zone or backend name
+	maxPathLength     = 4096 // longest file path
+	maxDeviceLength   = 256  // longest audio device name
+	maxEmojiLength    = 8    // most characters in a reaction, enough for joined emoji
+	maxDuckSeconds    = 3600 // longest a zone can be ducked for at once
+	maxBannerLength   = 280  // longest banner message shown on screens
+	maxSongIds        = 200  // most songs looked up in one call
+	maxProviderLength = 32   // longest name of a service accounts are linked from
+	maxExternalLength = 128  // longest id of an account on another service
+	maxGuestLength    = 24   // longest name a guest can enter at a kiosk, leaving room for the prefix
+	maxHistorySongs   = 500  // most songs of the history fetched in one call
+)
+
+var bluetoothAddress = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+var themeColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+var songTag = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var identityProvider = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+/*
+ * Collects the fields of a request that failed validation
+ */
+type violations []*errdetails.BadRequest_FieldViolation
+
+func (v *violations) add(field string, description string) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+}
+
+/*
+ * Validators of the requests of each rpc, keyed by method name. Rpcs without a
+ * validator take any request.
+ */
+var requestValidators = map[string]func(req interface{}, v *violations){
+	"SendSong":         func(req interface{}, v *violations) { validateSubmission(req.(*bepb.Submission), v) },
+	"SearchCandidates": func(req interface{}, v *violations) { validateQuery("query", req.(*bepb.SearchRequest).GetQuery(), v) },
+	"RemoveSong":       func(req interface{}, v *violations) { validateEviction(req.(*bepb.Eviction), v) },
+	"SavePlaylist":     func(req interface{}, v *violations) { validatePath(req.(*bepb.FilePath).GetPath(), v) },
+	"RestorePlaylist":  func(req interface{}, v *violations) { validatePath(req.(*bepb.FilePath).GetPath(), v) },
+	"MigrateQueue":     func(req interface{}, v *violations) { validatePath(req.(*bepb.FilePath).GetPath(), v) },
+	"LoginUser":        func(req interface{}, v *violations) { validateUser(req.(*bepb.User), v) },
+	"CreateRoom":       func(req interface{}, v *violations) { validateName("name", req.(*bepb.Room).GetName(), v) },
+	"GetRoom":          func(req interface{}, v *violations) { validateName("name", req.(*bepb.Room).GetName(), v) },
+	"GetSongDetails": func(req interface{}, v *violations) {
+		requireId("songId", req.(*bepb.SongDetailsRequest).GetSongId(), v)
+	},
+	"CreateZone": func(req interface{}, v *violations) { validateName("name", req.(*bepb.Zone).GetName(), v) },
+	"RemoveZone": func(req interface{}, v *violations) { requireId("id", req.(*bepb.Zone).GetId(), v) },
+	"GetAchievements": func(req interface{}, v *violations) {
+		requireId("userId", req.(*bepb.AchievementRequest).GetUserId(), v)
+	},
+	"SharePlaylist":    func(req interface{}, v *violations) { requireId("userId", req.(*bepb.ShareRequest).GetUserId(), v) },
+	"ImportShared":     func(req interface{}, v *violations) { validateImport(req.(*bepb.ImportRequest), v) },
+	"SetOutputDevice":  func(req interface{}, v *violations) { validateDevice(req.(*bepb.OutputDeviceRequest).GetDevice(), v) },
+	"PairBluetooth":    func(req interface{}, v *violations) { validateBluetooth(req.(*bepb.BluetoothRequest), v) },
+	"ConnectBluetooth": func(req interface{}, v *violations) { validateBluetooth(req.(*bepb.BluetoothRequest), v) },
+	"Federate":         func(req interface{}, v *violations) { validateName("name", req.(*bepb.FederationHello).GetName(), v) },
+	"ForwardSong":      func(req interface{}, v *violations) { validateFederatedSong(req.(*bepb.FederatedSong), v) },
+	"React":            func(req interface{}, v *violations) { validateReaction(req.(*bepb.Reaction), v) },
+	"SetExemption":     func(req interface{}, v *violations) { requireId("userId", req.(*bepb.Exemption).GetUserId(), v) },
+	"SavePreset":       func(req interface{}, v *violations) { validatePreset(req.(*bepb.Preset), v) },
+	"ApplyPreset":      func(req interface{}, v *violations) { validateName("name", req.(*bepb.PresetRequest).GetName(), v) },
+	"Heartbeat":        func(req interface{}, v *violations) { requireId("userId", req.(*bepb.User).GetUserId(), v) },
+	"VoteSkip":         func(req interface{}, v *violations) { requireId("userId", req.(*bepb.SkipVote).GetUserId(), v) },
+	"ListQueuedByUser": func(req interface{}, v *violations) {
+		requireId("userId", req.(*bepb.UserQueueRequest).GetUserId(), v)
+	},
+	"WhoAmI":         func(req interface{}, v *violations) { requireId("userId", req.(*bepb.User).GetUserId(), v) },
+	"SetPreferences": func(req interface{}, v *violations) { validatePreferences(req.(*bepb.Preferences), v) },
+	"Duck":           func(req interface{}, v *violations) { validateDuck(req.(*bepb.DuckRequest), v) },
+	"ReviewSong":     func(req interface{}, v *violations) { requireId("id", req.(*bepb.SongReview).GetId(), v) },
+	"AddJingle":      func(req interface{}, v *violations) { validateJingle(req.(*bepb.Jingle), v) },
+	"RemoveJingle":   func(req interface{}, v *violations) { validateName("name", req.(*bepb.Jingle).GetName(), v) },
+	"RegisterPlayer": func(req interface{}, v *violations) { validateRegisteredPlayer(req.(*bepb.RegisteredPlayer), v) },
+	"UnregisterPlayer": func(req interface{}, v *violations) {
+		validateName("name", req.(*bepb.RegisteredPlayer).GetName(), v)
+	},
+	"TimeOutUser":      func(req interface{}, v *violations) { validateTimeOut(req.(*bepb.TimeOut), v) },
+	"EndTimeOut":       func(req interface{}, v *violations) { requireId("userId", req.(*bepb.TimeOut).GetUserId(), v) },
+	"RequestPlayNext":  func(req interface{}, v *violations) { validatePlayNext(req.(*bepb.PlayNext), v) },
+	"ApprovePlayNext":  func(req interface{}, v *violations) { validatePlayNext(req.(*bepb.PlayNext), v) },
+	"TakeDecks":        func(req interface{}, v *violations) { requireId("userId", req.(*bepb.DeckTakeover).GetUserId(), v) },
+	"ReviewSuggestion": func(req interface{}, v *violations) { requireId("id", req.(*bepb.SongReview).GetId(), v) },
+	"ConfirmLoginCode": func(req interface{}, v *violations) {
+		requireId("userId", req.(*bepb.LoginCodeConfirmation).GetUserId(), v)
+	},
+	"RemoveByServiceId": func(req interface{}, v *violations) { validateServiceEviction(req.(*bepb.ServiceEviction), v) },
+	"UnblockSong":       func(req interface{}, v *violations) { validateServiceEviction(req.(*bepb.ServiceEviction), v) },
+	"ComparePlaylists": func(req interface{}, v *violations) {
+		validateComparison(req.(*bepb.PlaylistComparison), v)
+	},
+	"SetDisplaySettings": func(req interface{}, v *violations) {
+		validateDisplaySettings(req.(*bepb.DisplaySettings), v)
+	},
+	"CreateApiKey": func(req interface{}, v *violations) { validateApiKey(req.(*bepb.ApiKey), v) },
+	"RevokeApiKey": func(req interface{}, v *violations) { validateName("name", req.(*bepb.ApiKey).GetName(), v) },
+	"SetPlaybackQuality": func(req interface{}, v *violations) {
+		if _, exists := bepb.PlaybackQuality_name[int32(req.(*bepb.QualityRequest).GetQuality())]; !exists {
+			v.add("quality", "unknown quality")
+		}
+	},
+	"TagSong":      func(req interface{}, v *violations) { validateSongTag(req.(*bepb.SongTag), v) },
+	"UntagSong":    func(req interface{}, v *violations) { validateSongTag(req.(*bepb.SongTag), v) },
+	"FindTagged":   func(req interface{}, v *violations) { validateTag(req.(*bepb.TagSearch).GetTag(), false, v) },
+	"SetAutoDjTag": func(req interface{}, v *violations) { validateTag(req.(*bepb.TagSearch).GetTag(), false, v) },
+	"QueueTagged": func(req interface{}, v *violations) {
+		requireId("userId", req.(*bepb.TagRequest).GetUserId(), v)
+		validateTag(req.(*bepb.TagRequest).GetTag(), true, v)
+	},
+	"SetLogLevel": func(req interface{}, v *violations) {
+		if _, exists := bepb.LogLevel_name[int32(req.(*bepb.LogLevelRequest).GetLevel())]; !exists {
+			v.add("level", "unknown log level")
+		}
+	},
+	"ScheduleSong":    func(req interface{}, v *violations) { validateScheduledSong(req.(*bepb.ScheduledSong), v) },
+	"CancelScheduled": func(req interface{}, v *violations) { requireId("id", req.(*bepb.ScheduledSong).GetId(), v) },
+	"GetSongs": func(req interface{}, v *violations) {
+		if len(req.(*bepb.SongIdList).GetSongIds()) > maxSongIds {
+			v.add("songIds", fmt.Sprintf("at most %d songs can be looked up at once", maxSongIds))
+		}
+	},
+	"ListChapters": func(req interface{}, v *violations) { validateLink(req.(*bepb.Submission).GetLink(), v) },
+	"LinkIdentity": func(req interface{}, v *violations) {
+		requireId("userId", req.(*bepb.ExternalIdentity).GetUserId(), v)
+		validateIdentity(req.(*bepb.ExternalIdentity), v)
+	},
+	"UnlinkIdentity":  func(req interface{}, v *violations) { validateIdentity(req.(*bepb.ExternalIdentity), v) },
+	"ResolveIdentity": func(req interface{}, v *violations) { validateIdentity(req.(*bepb.ExternalIdentity), v) },
+	"GetHistory": func(req interface{}, v *violations) {
+		if req.(*bepb.HistoryRequest).GetLimit() > maxHistorySongs {
+			v.add("limit", fmt.Sprintf("at most %d songs can be fetched at once", maxHistorySongs))
+		}
+	},
+	"GetSkipReport": func(req interface{}, v *violations) { requireId("userId", req.(*bepb.User).GetUserId(), v) },
+}
+
+/*
+ * Turn away requests that fail validation before they reach the handler
+ */
+func validationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := validateRequest(path.Base(info.FullMethod), req); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+/*
+ * Validate the request of an rpc. Returns an InvalidArgument status error if
+ * any field is invalid.
+ */
+func validateRequest(method string, req interface{}) error {
+	validator, exists := requestValidators[method]
+	if !exists {
+		return nil
+	}
+
+	var v violations
+	validator(req, &v)
+	if len(v) == 0 {
+		return nil
+	}
+
+	problems := make([]string, len(v))
+	for i, violation := range v {
+		problems[i] = violation.Field + " " + violation.Description
+	}
+
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("Invalid request: %s.", strings.Join(problems, "; ")))
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v}); err == nil {
+		st = detailed
+	}
+
+	return st.Err()
+}
+
+func validateSubmission(sub *bepb.Submission, v *violations) {
+	validateLink(sub.GetLink(), v)
+	if sub.GetGuestName() != "" {
+		validateGuestName(sub.GetGuestName(), v)
+	} else {
+		requireId("userId", sub.GetUserId(), v)
+	}
+
+	if sub.GetForUsername() != "" {
+		validateUsername("forUsername", sub.GetForUsername(), v)
+	}
+
+	if _, exists := cmpb.SubmissionSource_name[int32(sub.GetSource())]; !exists {
+		v.add("source", "unknown submission source")
+	}
+}
+
+/*
+ * Links are either a web link, a YouTube link without a scheme, a path to a
+ * local file or a search query
+ */
+func validateLink(link string, v *violations) {
+	switch {
+	case strings.TrimSpace(link) == "":
+		v.add("link", "must not be empty")
+	case len(link) > maxLinkLength:
+		v.add("link", fmt.Sprintf("must be at most %d characters", maxLinkLength))
+	case links.IsSearchQuery(link):
+		validateQuery("link", link, v)
+	case strings.Contains(link, "://") && links.Parse(link).Kind == links.Unknown:
+		v.add("link", "must be an http or https link")
+	}
+}
+
+func validateQuery(field string, query string, v *violations) {
+	if strings.TrimSpace(query) == "" {
+		v.add(field, "must not be empty")
+	} else if utf8.RuneCountInString(query) > maxQueryLength {
+		v.add(field, fmt.Sprintf("must be at most %d characters", maxQueryLength))
+	}
+}
+
+func validatePreferences(preferences *bepb.Preferences, v *violations) {
+	requireId("userId", preferences.GetUserId(), v)
+
+	if _, exists := bepb.StartBehavior_name[int32(preferences.GetStartBehavior())]; !exists {
+		v.add("startBehavior", "unknown start behavior")
+	}
+}
+
+func validateDuck(request *bepb.DuckRequest, v *violations) {
+	if request.GetVolume() >= 100 {
+		v.add("volume", "must be less than 100 percent")
+	}
+
+	if request.GetSeconds() > maxDuckSeconds {
+		v.add("seconds", fmt.Sprintf("must be at most %d", maxDuckSeconds))
+	}
+}
+
+func validateEviction(eviction *bepb.Eviction, v *violations) {
+	requireId("songId", eviction.GetSongId(), v)
+	requireId("userId", eviction.GetUserId(), v)
+}
+
+/*
+ * Songs evicted by their service are picked out by a well formed id
+ */
+func validateServiceEviction(eviction *bepb.ServiceEviction, v *violations) {
+	if !validServiceId(eviction.GetService(), eviction.GetServiceId()) {
+		v.add("serviceId", "must be a well formed id of a song on the service")
+	}
+}
+
+/*
+ * New users are created with a user id of zero, but always need a room
+ */
+func validateUser(user *bepb.User, v *violations) {
+	validateUsername("username", user.GetUsername(), v)
+	requireId("roomId", user.GetRoomId(), v)
+}
+
+/*
+ * Usernames are made of letters, digits, spaces and a little punctuation
+ */
+func validateUsername(field string, username string, v *violations) {
+	if strings.TrimSpace(username) == "" {
+		v.add(field, "must not be empty")
+		return
+	}
+
+	if utf8.RuneCountInString(username) > maxUsernameLength {
+		v.add(field, fmt.Sprintf("must be at most %d characters", maxUsernameLength))
+	}
+
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && !strings.ContainsRune("-_.'", r) {
+			v.add(field, "may only contain letters, digits, spaces and -_.'")
+			return
+		}
+	}
+}
+
+func validateGuestName(name string, v *violations) {
+	if utf8.RuneCountInString(name) > maxGuestLength {
+		v.add("guestName", fmt.Sprintf("must be at most %d characters", maxGuestLength))
+		return
+	}
+
+	validateUsername("guestName", name, v)
+}
+
+func validateName(field string, name string, v *violations) {
+	if strings.TrimSpace(name) == "" {
+		v.add(field, "must not be empty")
+	} else if utf8.RuneCountInString(name) > maxNameLength {
+		v.add(field, fmt.Sprintf("must be at most %d characters", maxNameLength))
+	} else if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		v.add(field, "must not contain control characters")
+	}
+}
+
+func validatePath(filePath string, v *violations) {
+	if filePath == "" {
+		v.add("path", "must not be empty")
+	} else if len(filePath) > maxPathLength {
+		v.add("path", fmt.Sprintf("must be at most %d characters", maxPathLength))
+	}
+}
+
+/*
+ * Either playlist can be the live queue, so the paths may be empty, but at
+ * least one of them has to name a file
+ */
+func validateComparison(comparison *bepb.PlaylistComparison, v *violations) {
+	if comparison.GetBefore() == "" && comparison.GetAfter() == "" {
+		v.add("before", "must not be empty if after is")
+	}
+
+	if len(comparison.GetBefore()) > maxPathLength {
+		v.add("before", fmt.Sprintf("must be at most %d characters", maxPathLength))
+	}
+
+	if len(comparison.GetAfter()) > maxPathLength {
+		v.add("after", fmt.Sprintf("must be at most %d characters", maxPathLength))
+	}
+}
+
+/*
+ * The banner may be left out, and so may the theme color to keep the default
+ * theme
+ */
+func validateDisplaySettings(settings *bepb.DisplaySettings, v *violations) {
+	validateName("partyName", settings.GetPartyName(), v)
+
+	if settings.GetThemeColor() != "" && !themeColor.MatchString(settings.GetThemeColor()) {
+		v.add("themeColor", "must be a color like #ff6600")
+	}
+
+	if utf8.RuneCountInString(settings.GetBanner()) > maxBannerLength {
+		v.add("banner", fmt.Sprintf("must be at most %d characters", maxBannerLength))
+	} else if strings.IndexFunc(settings.GetBanner(), unicode.IsControl) >= 0 {
+		v.add("banner", "must not contain control characters")
+	}
+}
+
+func validateJingle(jingle *bepb.Jingle, v *violations) {
+	validateName("name", jingle.GetName(), v)
+
+	if strings.TrimSpace(jingle.GetLink()) == "" {
+		v.add("link", "must not be empty")
+	} else if len(jingle.GetLink()) > maxLinkLength {
+		v.add("link", fmt.Sprintf("must be at most %d characters", maxLinkLength))
+	}
+}
+
+func validateRegisteredPlayer(player *bepb.RegisteredPlayer, v *violations) {
+	validateName("name", player.GetName(), v)
+
+	if len(player.GetOutputDevice()) > maxDeviceLength {
+		v.add("outputDevice", fmt.Sprintf("must be at most %d characters", maxDeviceLength))
+	}
+}
+
+func validateApiKey(key *bepb.ApiKey, v *violations) {
+	validateName("name", key.GetName(), v)
+
+	if _, exists := bepb.ApiKeyScope_name[int32(key.GetScope())]; !exists {
+		v.add("scope", "unknown scope")
+	}
+}
+
+func validateTimeOut(timeOut *bepb.TimeOut, v *violations) {
+	requireId("userId", timeOut.GetUserId(), v)
+
+	if timeOut.GetSeconds() == 0 {
+		v.add("seconds", "must be greater than zero")
+	}
+}
+
+func validatePlayNext(request *bepb.PlayNext, v *violations) {
+	requireId("userId", request.GetUserId(), v)
+	requireId("songId", request.GetSongId(), v)
+}
+
+func validateImport(request *bepb.ImportRequest, v *violations) {
+	requireId("userId", request.GetUserId(), v)
+
+	validateShareCode("code", request.GetCode(), v)
+}
+
+func validateShareCode(field string, code string, v *violations) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != shareCodeLength || strings.Trim(code, shareCodeAlphabet) != "" {
+		v.add(field, fmt.Sprintf("must be a %d character share code", shareCodeLength))
+	}
+}
+
+/*
+ * Presets open and close the queue at times written as HH:MM, which are
+ * either both set or both left out
+ */
+func validatePreset(preset *bepb.Preset, v *violations) {
+	validateName("name", preset.GetName(), v)
+
+	if _, exists := bepb.QueueAlgorithm_name[int32(preset.GetAlgorithm())]; !exists {
+		v.add("algorithm", "unknown queue algorithm")
+	}
+
+	if preset.GetSubmissionWindow() < 0 {
+		v.add("submissionWindow", "must not be negative")
+	}
+
+	if preset.GetFallbackCode() != "" {
+		validateShareCode("fallbackCode", preset.GetFallbackCode(), v)
+	}
+
+	openAt, closeAt := preset.GetOpenAt(), preset.GetCloseAt()
+	if (openAt == "") != (closeAt == "") {
+		v.add("closeAt", "must be set along with openAt")
+		return
+	}
+
+	validateClock("openAt", openAt, v)
+	validateClock("closeAt", closeAt, v)
+}
+
+func validateClock(field string, clock string, v *violations) {
+	if _, err := parseClock(clock); clock != "" && err != nil {
+		v.add(field, "must be a time like 17:30")
+	}
+}
+
+func validateDevice(device string, v *violations) {
+	if device == "" {
+		v.add("device", "must not be empty")
+	} else if len(device) > maxDeviceLength {
+		v.add("device", fmt.Sprintf("must be at most %d characters", maxDeviceLength))
+	}
+}
+
+func validateBluetooth(request *bepb.BluetoothRequest, v *violations) {
+	if !bluetoothAddress.MatchString(request.GetAddress()) {
+		v.add("address", "must be a Bluetooth address like 00:11:22:AA:BB:CC")
+	}
+}
+
+func validateFederatedSong(forwarded *bepb.FederatedSong, v *violations) {
+	validateName("origin", forwarded.GetOrigin(), v)
+
+	song := forwarded.GetSong()
+	if song == nil {
+		v.add("song", "must not be empty")
+		return
+	}
+
+	if song.GetServiceId() == "" {
+		v.add("song.serviceId", "must not be empty")
+	}
+	validateUsername("song.username", song.GetUsername(), v)
+}
+
+/*
+ * Reactions are a single emoji, which can take a few characters when joined
+ * or modified. Letters, digits and spaces aren't allowed.
+ */
+func validateReaction(reaction *bepb.Reaction, v *violations) {
+	requireId("userId", reaction.GetUserId(), v)
+
+	emoji := reaction.GetEmoji()
+	if emoji == "" {
+		v.add("emoji", "must not be empty")
+		return
+	}
+
+	if utf8.RuneCountInString(emoji) > maxEmojiLength {
+		v.add("emoji", fmt.Sprintf("must be at most %d characters", maxEmojiLength))
+		return
+	}
+
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			v.add("emoji", "must be an emoji")
+			return
+		}
+	}
+}
+
+func validateSongTag(tag *bepb.SongTag, v *violations) {
+	requireId("songId", tag.GetSongId(), v)
+	requireId("userId", tag.GetUserId(), v)
+	validateTag(tag.GetTag(), true, v)
+}
+
+/*
+ * Tags are checked as they're stored, so "Chill " passes as "chill"
+ */
+func validateTag(tag string, required bool, v *violations) {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		if required {
+			v.add("tag", "must not be empty")
+		}
+		return
+	}
+
+	if len(tag) > maxTagLength {
+		v.add("tag", fmt.Sprintf("must be at most %d characters", maxTagLength))
+	} else if !songTag.MatchString(tag) {
+		v.add("tag", "must be lower case letters and digits joined by dashes")
+	}
+}
+
+func validateScheduledSong(scheduled *bepb.ScheduledSong, v *violations) {
+	requireId("userId", scheduled.GetUserId(), v)
+	validateLink(scheduled.GetLink(), v)
+
+	if scheduled.GetPlayAt() <= 0 {
+		v.add("playAt", "must be a unix time")
+	}
+}
+
+func requireId(field string, id uint32, v *violations) {
+	if id == 0 {
+		v.add(field, "must not be zero")
+	}
+}
+
+/*
+ * Accounts on other services are named by a provider like "discord" and an id
+ * the provider gave the account
+ */
+func validateIdentity(identity *bepb.ExternalIdentity, v *violations) {
+	provider := strings.ToLower(strings.TrimSpace(identity.GetProvider()))
+	switch {
+	case provider == "":
+		v.add("provider", "must not be empty")
+	case len(provider) > maxProviderLength:
+		v.add("provider", fmt.Sprintf("must be at most %d characters", maxProviderLength))
+	case !identityProvider.MatchString(provider):
+		v.add("provider", "must be letters and digits, optionally separated by dots, dashes or underscores")
+	}
+
+	externalId := strings.TrimSpace(identity.GetExternalId())
+	switch {
+	case externalId == "":
+		v.add("externalId", "must not be empty")
+	case len(externalId) > maxExternalLength:
+		v.add("externalId", fmt.Sprintf("must be at most %d characters", maxExternalLength))
+	}
+}
@@ -0,0 +1,138 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Returns the fields reported as invalid by a validation error
+ */
+func invalidFields(t *testing.T, err error) []string {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.InvalidArgument {
+		t.Fatalf("Expected an InvalidArgument error, but got %v", err)
+	}
+
+	var fields []string
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				fields = append(fields, violation.Field)
+			}
+		}
+	}
+
+	return fields
+}
+
+func TestValidateRequest_whenValid_passes(t *testing.T) {
+	requests := map[string]interface{}{
+		"SendSong":      &bepb.Submission{Link: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", UserId: 1},
+		"LoginUser":     &bepb.User{Username: "Kahlan Amnell", RoomId: 1},
+		"ImportShared":  &bepb.ImportRequest{Code: "abc234", UserId: 1},
+		"PairBluetooth": &bepb.BluetoothRequest{Address: "00:11:22:AA:bb:CC"},
+		"GetPlaylist":   &cmpb.Empty{},
+	}
+
+	for method, req := range requests {
+		if err := validateRequest(method, req); err != nil {
+			t.Errorf("%s: expected request to pass, but got %v", method, err)
+		}
+	}
+
+	for _, link := range []string{"youtu.be/dQw4w9WgXcQ", "/music/song.mp3", "daft punk one more time"} {
+		if err := validateRequest("SendSong", &bepb.Submission{Link: link, UserId: 1}); err != nil {
+			t.Errorf("Expected link %q to pass, but got %v", link, err)
+		}
+	}
+}
+
+func TestValidateRequest_whenSubmissionInvalid_reportsFields(t *testing.T) {
+	err := validateRequest("SendSong", &bepb.Submission{Link: "ftp://example.com/song", Source: 99})
+
+	fields := invalidFields(t, err)
+	if strings.Join(fields, ",") != "link,userId,source" {
+		t.Errorf("Expected link, userId and source to be invalid, but got %v", fields)
+	}
+}
+
+func TestValidateRequest_whenLinkTooLong_rejected(t *testing.T) {
+	link := "https://example.com/" + strings.Repeat("a", maxLinkLength)
+
+	fields := invalidFields(t, validateRequest("SendSong", &bepb.Submission{Link: link, UserId: 1}))
+	if len(fields) != 1 || fields[0] != "link" {
+		t.Errorf("Expected only the link to be invalid, but got %v", fields)
+	}
+
+	query := strings.Repeat("a ", maxQueryLength)
+	fields = invalidFields(t, validateRequest("SendSong", &bepb.Submission{Link: query, UserId: 1}))
+	if len(fields) != 1 || fields[0] != "link" {
+		t.Errorf("Expected only the search query to be invalid, but got %v", fields)
+	}
+}
+
+func TestValidateRequest_whenGuestNameGiven_needsNoUserId(t *testing.T) {
+	link := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	if err := validateRequest("SendSong", &bepb.Submission{Link: link, GuestName: "Sam"}); err != nil {
+		t.Errorf("Expected a guest's submission to be valid, but got %v", err)
+	}
+
+	for _, name := range []string{"<script>", strings.Repeat("a", maxGuestLength+1)} {
+		fields := invalidFields(t, validateRequest("SendSong", &bepb.Submission{Link: link, GuestName: name}))
+		if len(fields) != 1 || fields[0] != "guestName" {
+			t.Errorf("Expected guest name %q to be invalid, but got %v", name, fields)
+		}
+	}
+}
+
+func TestValidateRequest_whenUsernameInvalid_rejected(t *testing.T) {
+	for _, username := range []string{"", "   ", "<script>", strings.Repeat("a", maxUsernameLength+1)} {
+		fields := invalidFields(t, validateRequest("LoginUser", &bepb.User{Username: username, RoomId: 1}))
+		if len(fields) != 1 || fields[0] != "username" {
+			t.Errorf("Expected username %q to be invalid, but got %v", username, fields)
+		}
+	}
+}
+
+func TestValidateRequest_whenIdMissing_rejected(t *testing.T) {
+	fields := invalidFields(t, validateRequest("RemoveSong", &bepb.Eviction{}))
+	if strings.Join(fields, ",") != "songId,userId" {
+		t.Errorf("Expected songId and userId to be invalid, but got %v", fields)
+	}
+}
+
+func TestValidateRequest_whenReactionNotEmoji_rejected(t *testing.T) {
+	for _, emoji := range []string{"🔥", "👍🏽", "❤️"} {
+		if err := validateRequest("React", &bepb.Reaction{UserId: 1, Emoji: emoji}); err != nil {
+			t.Errorf("Expected emoji %q to pass, but got %v", emoji, err)
+		}
+	}
+
+	for _, emoji := range []string{"", "lol", "🔥 🔥", strings.Repeat("🔥", maxEmojiLength+1)} {
+		fields := invalidFields(t, validateRequest("React", &bepb.Reaction{UserId: 1, Emoji: emoji}))
+		if len(fields) != 1 || fields[0] != "emoji" {
+			t.Errorf("Expected emoji %q to be invalid, but got %v", emoji, fields)
+		}
+	}
+}
+
+func TestValidateRequest_whenDisplaySettingsInvalid_reportsFields(t *testing.T) {
+	valid := &bepb.DisplaySettings{PartyName: "Office Party", ThemeColor: "#FF6600", Banner: "Pizza at 7"}
+	if err := validateRequest("SetDisplaySettings", valid); err != nil {
+		t.Errorf("Expected the settings to pass, but got %v", err)
+	}
+
+	invalid := &bepb.DisplaySettings{ThemeColor: "orange", Banner: strings.Repeat("a", maxBannerLength+1)}
+	fields := invalidFields(t, validateRequest("SetDisplaySettings", invalid))
+	if strings.Join(fields, ",") != "partyName,themeColor,banner" {
+		t.Errorf("Expected partyName, themeColor and banner to be invalid, but got %v", fields)
+	}
+}
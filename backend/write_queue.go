@@ -0,0 +1,291 @@
+/*
+ * Writes the song history to the database in the background, so a slow SD
+ * card never holds up submitting a song. Songs are given ids from a block
+ * reserved ahead of time, queued and played right away, and written along
+ * with skips in batches. Writes failing with errors that may pass, like a
+ * locked or briefly unavailable database, are retried with backoff instead of
+ * being dropped. The buffer is bounded, so a database that stays down slows
+ * submissions rather than letting the backlog grow without end.
+ *
+ * Users are added through the queue too, but a login waits for its user to be
+ * written, since it needs the user's id.
+ */
+
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	db "github.com/nguyenmq/ytbox-go/database"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	writeBuffer     = 256                    // most writes waiting to be made before adding more blocks
+	writeBatchSize  = 32                     // most writes made in one transaction
+	writeRetries    = 8                      // times a write failing with a transient error is retried
+	writeBackoff    = 100 * time.Millisecond // wait before the first retry, doubled for each one after
+	writeMaxBackoff = 5 * time.Second        // longest wait between retries
+	songIdBlockSize = 64                     // song ids reserved at a time
+)
+
+/*
+ * A write to make in the background
+ */
+type dbWrite struct {
+	name string                      // what's written, for the logs
+	run  func(tx db.DbManager) error // makes the write
+	done func(err error)             // called once the write is made or dropped. Nil if nothing waits on it
+}
+
+/*
+ * Queues writes to the database and makes them in the background
+ */
+type writeQueue struct {
+	dbManager db.DbManager   // database the writes are made to
+	writes    chan *dbWrite  // writes waiting to be made
+	pending   sync.WaitGroup // writes queued but not made yet
+	closed    bool           // whether the queue stopped taking writes
+	lock      sync.RWMutex   // lock on closing the queue
+	stopped   chan struct{}  // closed once the writes queued before stopping are made
+	backoff   time.Duration  // wait before the first retry of a write
+
+	idLock     sync.Mutex // lock on the reserved song ids
+	nextId     uint32     // next song id to give out. Zero if none are reserved
+	reservedTo uint32     // last song id of the reserved block
+}
+
+/*
+ * Initialize the queue and start making writes in the background
+ */
+func (q *writeQueue) init(dbManager db.DbManager) {
+	q.dbManager = dbManager
+	q.writes = make(chan *dbWrite, writeBuffer)
+	q.stopped = make(chan struct{})
+	q.backoff = writeBackoff
+
+	go q.run()
+}
+
+/*
+ * Give a song an id and queue it to be recorded in the history. The song is
+ * copied, so it may be changed once it's queued.
+ */
+func (q *writeQueue) recordSong(song *cmpb.Song) error {
+	songId, err := q.reserveSongId()
+	if err != nil {
+		return err
+	}
+
+	song.SongId = songId
+	record := proto.Clone(song).(*cmpb.Song)
+	q.add(&dbWrite{name: "song " + song.ServiceId, run: func(tx db.DbManager) error {
+		return tx.AddReservedSong(record)
+	}})
+
+	return nil
+}
+
+/*
+ * Get the user with the given name in a room, adding the user if there isn't
+ * one, and wait for the write to be made. The lookup and insert happen in one
+ * transaction so concurrent logins with the same name end up as the same user
+ * instead of duplicates.
+ */
+func (q *writeQueue) findOrAddUser(username string, roomId uint32) (*db.UserData, error) {
+	var userData *db.UserData
+	result := make(chan error, 1)
+
+	q.add(&dbWrite{name: "user " + username, run: func(tx db.DbManager) error {
+		var err error
+		userData, err = tx.GetUserByName(username, roomId)
+		if errors.Is(err, sql.ErrNoRows) {
+			userData, err = tx.AddUser(username, roomId)
+		}
+		return err
+	}, done: func(err error) { result <- err }})
+
+	if err := <-result; err != nil {
+		return nil, err
+	}
+	return userData, nil
+}
+
+/*
+ * Queue a song to be flagged as skipped in the history. The skip is made
+ * after the song is recorded, since writes are made in order.
+ */
+func (q *writeQueue) markSkipped(songId uint32) {
+	q.add(&dbWrite{name: "skip", run: func(tx db.DbManager) error {
+		return tx.MarkSongSkipped(songId)
+	}})
+}
+
+/*
+ * Queue how a song ended to be recorded in the history, after the song is
+ */
+func (q *writeQueue) setOutcome(songId uint32, outcome bepb.SongOutcome, skippedBy string, voters []uint32) {
+	q.add(&dbWrite{name: "outcome", run: func(tx db.DbManager) error {
+		return tx.SetSongOutcome(songId, outcome, skippedBy, voters)
+	}})
+}
+
+/*
+ * Queue a write. Blocks while the buffer is full. Writes made after the queue
+ * stopped are made right away.
+ */
+func (q *writeQueue) add(write *dbWrite) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if q.closed {
+		q.write([]*dbWrite{write})
+		return
+	}
+
+	q.pending.Add(1)
+	q.writes <- write
+}
+
+/*
+ * Returns the next reserved song id, reserving another block once the last
+ * one runs out
+ */
+func (q *writeQueue) reserveSongId() (uint32, error) {
+	q.idLock.Lock()
+	defer q.idLock.Unlock()
+
+	if q.nextId == 0 || q.nextId > q.reservedTo {
+		first, err := q.dbManager.ReserveSongIds(songIdBlockSize)
+		if err != nil {
+			log.Printf("Failed to reserve song ids: %v", err)
+			return 0, err
+		}
+
+		q.nextId = first
+		q.reservedTo = first + songIdBlockSize - 1
+	}
+
+	songId := q.nextId
+	q.nextId++
+	return songId, nil
+}
+
+/*
+ * Make the queued writes in batches until the queue is stopped
+ */
+func (q *writeQueue) run() {
+	defer close(q.stopped)
+
+	for write := range q.writes {
+		batch := []*dbWrite{write}
+
+	collect:
+		for len(batch) < writeBatchSize {
+			select {
+			case next, ok := <-q.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		q.write(batch)
+		for range batch {
+			q.pending.Done()
+		}
+	}
+}
+
+/*
+ * Make a batch of writes in one transaction. If the batch fails for a reason
+ * other than a transient error, the writes are made one at a time so one bad
+ * write doesn't take the others down with it.
+ */
+func (q *writeQueue) write(batch []*dbWrite) {
+	err := q.retry(func() error {
+		return q.dbManager.WithTx(func(tx db.DbManager) error {
+			for _, write := range batch {
+				if err := write.run(tx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	if err == nil || len(batch) == 1 {
+		for _, write := range batch {
+			q.finish(write, err)
+		}
+		return
+	}
+
+	for _, write := range batch {
+		q.finish(write, q.retry(func() error { return write.run(q.dbManager) }))
+	}
+}
+
+/*
+ * Tell whatever waits on the write how it went, logging it if it was dropped
+ */
+func (q *writeQueue) finish(write *dbWrite, err error) {
+	if err != nil {
+		log.Printf("Dropping the write of %s: %v", write.name, err)
+	}
+
+	if write.done != nil {
+		write.done(err)
+	}
+}
+
+/*
+ * Run a write, retrying it with backoff while it fails with transient errors
+ */
+func (q *writeQueue) retry(write func() error) error {
+	backoff := q.backoff
+	err := write()
+	for attempt := 0; err != nil && db.IsTransient(err) && attempt < writeRetries; attempt++ {
+		log.Printf("Retrying a database write in %v after: %v", backoff, err)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > writeMaxBackoff {
+			backoff = writeMaxBackoff
+		}
+		err = write()
+	}
+
+	return err
+}
+
+/*
+ * Wait for the writes queued so far to be made
+ */
+func (q *writeQueue) flush() {
+	q.pending.Wait()
+}
+
+/*
+ * Stop taking writes and wait for the queued ones to be made. Safe to call
+ * more than once.
+ */
+func (q *writeQueue) stop() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.writes)
+	}
+	q.lock.Unlock()
+
+	<-q.stopped
+}
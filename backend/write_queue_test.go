@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestRetry_whenDatabaseBusy_retriesUntilWritten(t *testing.T) {
+	queue := &writeQueue{backoff: time.Millisecond}
+
+	attempts := 0
+	err := queue.retry(func() error {
+		if attempts++; attempts < 3 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return nil
+	})
+
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected the write to be retried until it went through, got %d attempts and %v", attempts, err)
+	}
+
+	attempts = 0
+	refused := errors.New("constraint failed")
+	err = queue.retry(func() error {
+		attempts++
+		return refused
+	})
+
+	if err != refused || attempts != 1 {
+		t.Errorf("Expected a refused write not to be retried, got %d attempts and %v", attempts, err)
+	}
+}
+
+func TestFindOrAddUser_writtenThroughTheQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_writes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := testSnapshotServer(t, dir, "test.db")
+	defer server.dbManager.Close()
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	bob, err := server.writes.findOrAddUser("Bob", room.Room.Id)
+	if err != nil || bob.User.UserId == 0 {
+		t.Fatalf("Expected Bob to be added, got %v with error %v", bob, err)
+	}
+
+	again, err := server.writes.findOrAddUser("Bob", room.Room.Id)
+	if err != nil || again.User.UserId != bob.User.UserId {
+		t.Errorf("Expected Bob to be found again, got %v with error %v", again, err)
+	}
+
+	// users are still added once the queue stops taking writes
+	server.writes.stop()
+	alice, err := server.writes.findOrAddUser("Alice", room.Room.Id)
+	if err != nil || alice.User.UserId == 0 || alice.User.UserId == bob.User.UserId {
+		t.Errorf("Expected Alice to be added after the queue stopped, got %v with error %v", alice, err)
+	}
+}
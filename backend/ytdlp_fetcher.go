@@ -0,0 +1,171 @@
+/*
+ * Fetches song metadata with yt-dlp, which knows how to read hundreds of
+ * sites. Songs from YouTube keep their video id, so they play and cache the
+ * same as songs fetched through the YouTube api. Songs from anywhere else are
+ * played straight from their page.
+ */
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rickb777/date/period"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	ytDlpCommand   = "yt-dlp"         // external program used to fetch metadata
+	ytDlpTimeout   = 30 * time.Second // how long to wait on yt-dlp
+	ytDlpYoutube   = "Youtube"        // yt-dlp's name for YouTube videos
+	ytDlpAdultsAge = 18               // age limit of videos that are age restricted
+)
+
+/*
+ * The parts of yt-dlp's json output that songs are made from
+ */
+type ytDlpInfo struct {
+	Id           string         `json:"id"`
+	Title        string         `json:"title"`
+	AltTitle     string         `json:"alt_title"`
+	Language     string         `json:"language"`
+	Artist       string         `json:"artist"`
+	Channel      string         `json:"channel"`
+	Track        string         `json:"track"`
+	Duration     float64        `json:"duration"`
+	Thumbnail    string         `json:"thumbnail"`
+	WebpageUrl   string         `json:"webpage_url"`
+	ExtractorKey string         `json:"extractor_key"`
+	AgeLimit     int            `json:"age_limit"`
+	Chapters     []ytDlpChapter `json:"chapters"`
+}
+
+/*
+ * A chapter of a video as yt-dlp reports it
+ */
+type ytDlpChapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+/*
+ * Fetches song metadata by running yt-dlp
+ */
+type ytDlpFetcher struct {
+	command string // path of the yt-dlp program
+}
+
+/*
+ * Initialize the fetcher. Returns false if yt-dlp isn't installed.
+ */
+func (f *ytDlpFetcher) init() bool {
+	path, err := exec.LookPath(ytDlpCommand)
+	if err != nil {
+		f.command = ytDlpCommand
+		return false
+	}
+
+	f.command = path
+	return true
+}
+
+/*
+ * Fetch the metadata of a link with yt-dlp
+ */
+func (f *ytDlpFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ytDlpTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, f.command, "--dump-single-json", "--no-playlist", "--skip-download",
+		"--no-warnings", link).Output()
+	if err != nil {
+		log.Printf("Failed to fetch song data for %s with %s: %v", link, ytDlpCommand, err)
+
+		// yt-dlp ran to the end, so it doesn't know the link
+		var exitErr *exec.ExitError
+		if ctx.Err() == nil && errors.As(err, &exitErr) {
+			return fmt.Errorf("%w: %s can't fetch %s", ErrUnknownSong, ytDlpCommand, link)
+		}
+		return errors.New("Failed to fetch song metadata")
+	}
+
+	info := new(ytDlpInfo)
+	if err = json.Unmarshal(out, info); err != nil {
+		log.Printf("Failed to parse %s output for %s: %v", ytDlpCommand, link, err)
+		return errors.New("Failed to fetch song metadata")
+	}
+
+	if info.WebpageUrl == "" {
+		info.WebpageUrl = link
+	}
+
+	return songFromYtDlp(info, song)
+}
+
+/*
+ * Populate a song from yt-dlp's metadata. Returns ErrAgeRestricted for videos
+ * only adults can watch.
+ */
+func songFromYtDlp(info *ytDlpInfo, song *cmpb.Song) error {
+	song.Title = info.Title
+	song.Artist = info.Channel
+	if info.Artist != "" {
+		song.Artist = info.Artist
+	}
+	if info.Artist != "" && info.Track != "" {
+		song.Title = info.Artist + " - " + info.Track
+	}
+	song.RomanizedTitle = info.AltTitle
+	song.Language = info.Language
+
+	song.Metadata = &cmpb.Metadata{
+		Thumbnail: info.Thumbnail,
+		Duration:  ytDlpDuration(info.Duration),
+	}
+
+	for i, chapter := range info.Chapters {
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+
+		song.Metadata.Chapters = append(song.Metadata.Chapters, &cmpb.Chapter{
+			Title:   title,
+			StartAt: uint32(chapter.StartTime + 0.5),
+			EndAt:   uint32(chapter.EndTime + 0.5),
+		})
+	}
+
+	if info.ExtractorKey == ytDlpYoutube && info.Id != "" {
+		song.Service = cmpb.ServiceType_Youtube
+		song.ServiceId = info.Id
+		song.Metadata.Thumbnail = youtubeThumbnail(info.Id)
+	} else {
+		song.Service = cmpb.ServiceType_Web
+		song.ServiceId = info.WebpageUrl
+	}
+
+	if info.AgeLimit >= ytDlpAdultsAge {
+		return ErrAgeRestricted
+	}
+
+	return nil
+}
+
+/*
+ * Write a length in seconds the way YouTube does, like PT4M13S. Zero if the
+ * length isn't known, such as for live streams.
+ */
+func ytDlpDuration(seconds float64) string {
+	total := int(seconds + 0.5)
+	return period.NewHMS(total/3600, total%3600/60, total%60).String()
+}
@@ -0,0 +1,284 @@
+/*
+ * Manages the player zones of the backend. A zone is a group of players that
+ * play the same songs, such as the players in one room of a house. Every zone
+ * has its own connected players and its own now playing song. A zone either
+ * keeps its own queue or shares the queue of the default zone.
+ */
+
+package backend
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	defaultZoneId   uint32 = 0         // id of the zone that always exists
+	defaultZoneName string = "default" // name of the zone that always exists
+)
+
+var ErrRemoveDefaultZone = errors.New("The default zone cannot be removed.")
+var ErrZoneHasPlayers = errors.New("Zone still has connected players.")
+var ErrZoneNotFound = errors.New("Zone does not exist.")
+var ErrQueueEmpty = errors.New("The queue is empty.")
+
+/*
+ * A group of players and the queue they play from
+ */
+type zone struct {
+	id        uint32                   // id of the zone
+	name      string                   // name of the zone
+	shared    bool                     // true if the zone uses the default zone's queue
+	queueMgr  *queuer.SongQueueManager // the zone's playlist queue
+	playerMgr *playerManager           // players connected to the zone
+}
+
+/*
+ * Keeps track of all the zones
+ */
+type zoneManager struct {
+	zones       map[uint32]*zone         // zone id -> zone
+	defaultZone *zone                    // the zone that always exists
+	downloader  *songDownloader          // pre-fetches audio of upcoming songs
+	autoDj      *autoDj                  // picks songs when a queue runs dry
+	jingles     *jingleBox               // plays jingles between songs
+	bus         *eventBus                // passed on to the zones' player managers
+	newQueuer   func() queuer.SongQueuer // creates the queue of a zone that isn't shared
+	artistGap   time.Duration            // shortest time between songs by one artist in queues that aren't shared
+	crossfade   time.Duration            // how long the zones' players fade songs out and in
+	quality     bepb.PlaybackQuality     // quality new zones' players play songs at
+	started     bool                     // true once the player managers were started
+	lock        sync.RWMutex             // lock on the zones
+}
+
+/*
+ * Initialize the zone manager with the default zone built from the server's
+ * main queue and player manager
+ */
+func (mgr *zoneManager) init(queueMgr *queuer.SongQueueManager, playerMgr *playerManager,
+	downloader *songDownloader, dj *autoDj, jingles *jingleBox, bus *eventBus,
+	newQueuer func() queuer.SongQueuer) {
+	mgr.zones = make(map[uint32]*zone)
+	mgr.downloader = downloader
+	mgr.autoDj = dj
+	mgr.jingles = jingles
+	mgr.bus = bus
+	mgr.newQueuer = newQueuer
+	mgr.defaultZone = &zone{
+		id:        defaultZoneId,
+		name:      defaultZoneName,
+		shared:    true,
+		queueMgr:  queueMgr,
+		playerMgr: playerMgr,
+	}
+	mgr.zones[defaultZoneId] = mgr.defaultZone
+}
+
+/*
+ * Add a new zone. A shared zone plays from the default zone's queue. Otherwise
+ * the zone gets a new empty queue of its own.
+ */
+func (mgr *zoneManager) add(id uint32, name string, shared bool) *zone {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	queueMgr := new(queuer.SongQueueManager)
+	if shared {
+		queueMgr.InitShared(mgr.defaultZone.queueMgr)
+	} else {
+		queueMgr.Init(mgr.newQueuer())
+		queueMgr.SetArtistGap(mgr.artistGap)
+	}
+
+	playerMgr := new(playerManager)
+	playerMgr.init(queueMgr, mgr.downloader, mgr.autoDj, mgr.jingles, mgr.bus)
+	playerMgr.zoneId = id
+	playerMgr.crossfade = mgr.crossfade
+	playerMgr.quality = mgr.quality
+	if mgr.started {
+		playerMgr.start()
+	}
+
+	newZone := &zone{
+		id:        id,
+		name:      name,
+		shared:    shared,
+		queueMgr:  queueMgr,
+		playerMgr: playerMgr,
+	}
+	mgr.zones[id] = newZone
+	log.Printf("Added zone: {id: %d, name: %s, shared: %t}", id, name, shared)
+
+	return newZone
+}
+
+/*
+ * Switch every queue over to queues made by newQueuer, keeping the songs
+ * queued. Zones added later get the new kind of queue too.
+ */
+func (mgr *zoneManager) swapQueuers(newQueuer func() queuer.SongQueuer) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.newQueuer = newQueuer
+	for _, z := range mgr.zones {
+		// shared zones switch along with the default zone's queue
+		if z == mgr.defaultZone || !z.shared {
+			z.queueMgr.SwapQueuer(newQueuer())
+		}
+	}
+}
+
+/*
+ * Forget the queue turns of a user who went inactive in every queue
+ */
+func (mgr *zoneManager) forgetUser(userId uint32) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	for _, z := range mgr.zones {
+		// shared zones play from the default zone's queue
+		if z == mgr.defaultZone || !z.shared {
+			z.queueMgr.ForgetUser(userId)
+		}
+	}
+}
+
+/*
+ * Remove a zone. The default zone and zones with connected players can't be
+ * removed.
+ */
+func (mgr *zoneManager) remove(id uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if id == defaultZoneId {
+		return ErrRemoveDefaultZone
+	}
+
+	removed, exists := mgr.zones[id]
+	if !exists {
+		return ErrZoneNotFound
+	}
+
+	if removed.playerMgr.count() > 0 {
+		return ErrZoneHasPlayers
+	}
+
+	if mgr.started {
+		removed.playerMgr.stop()
+	}
+
+	delete(mgr.zones, id)
+	log.Printf("Removed zone: {id: %d, name: %s}", id, removed.name)
+	return nil
+}
+
+/*
+ * Get a zone by its id
+ */
+func (mgr *zoneManager) get(id uint32) (*zone, bool) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	found, exists := mgr.zones[id]
+	return found, exists
+}
+
+/*
+ * Get a zone by its name. An empty name refers to the default zone.
+ */
+func (mgr *zoneManager) getByName(name string) (*zone, bool) {
+	if name == "" {
+		return mgr.defaultZone, true
+	}
+
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	for _, found := range mgr.zones {
+		if found.name == name {
+			return found, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+ * Returns all the zones ordered by id
+ */
+func (mgr *zoneManager) list() []*zone {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	zones := make([]*zone, 0, len(mgr.zones))
+	for _, found := range mgr.zones {
+		zones = append(zones, found)
+	}
+
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].id < zones[j].id
+	})
+
+	return zones
+}
+
+/*
+ * Start the player managers of every zone
+ */
+func (mgr *zoneManager) start() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	for _, found := range mgr.zones {
+		found.playerMgr.start()
+	}
+	mgr.started = true
+}
+
+/*
+ * Stop the player managers of every zone
+ */
+func (mgr *zoneManager) stop() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	for _, found := range mgr.zones {
+		found.playerMgr.stop()
+	}
+	mgr.started = false
+}
+
+/*
+ * Describe the zone as a protobuf message
+ */
+func (z *zone) toProto() *bepb.Zone {
+	return &bepb.Zone{
+		Name:       z.name,
+		Id:         z.id,
+		Shared:     z.shared,
+		NowPlaying: z.queueMgr.NowPlaying(),
+		Players:    uint32(z.playerMgr.count()),
+		Err:        &bepb.Error{Success: true},
+		Quality:    z.playerMgr.playbackQuality(),
+	}
+}
+
+/*
+ * Returns the id of the zone whose queue the zone plays from. Shared zones
+ * play from the default zone's queue.
+ */
+func (z *zone) queueZoneId() uint32 {
+	if z.shared {
+		return defaultZoneId
+	}
+
+	return z.id
+}
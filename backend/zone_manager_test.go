@@ -0,0 +1,200 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	queuer "github.com/nguyenmq/ytbox-go/backend/song_queuer"
+	db "github.com/nguyenmq/ytbox-go/database"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * Snapshot store keeping what's saved in memory
+ */
+type memoryStore struct {
+	saved map[string][]byte
+	lock  sync.Mutex
+}
+
+func (m *memoryStore) Save(name string, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.saved[name] = data
+	return nil
+}
+
+func (m *memoryStore) Load(name string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if data, exists := m.saved[name]; exists {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func setupZones() *zoneManager {
+	queueMgr := new(queuer.SongQueueManager)
+	queueMgr.Init(queuer.NewRoundRobinQueuer())
+
+	downloader := new(songDownloader)
+	downloader.init("", 0)
+
+	playerMgr := new(playerManager)
+	playerMgr.init(queueMgr, downloader, nil, nil, nil)
+
+	zones := new(zoneManager)
+	zones.init(queueMgr, playerMgr, downloader, nil, nil, nil, newRoundRobinQueuer)
+	return zones
+}
+
+func TestZoneAdd_whenShared_usesDefaultQueue(t *testing.T) {
+	zones := setupZones()
+	patio := zones.add(1, "patio", true)
+
+	zones.defaultZone.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1})
+
+	if patio.queueMgr.Len() != 1 {
+		t.Fatalf("Shared zone should see 1 song, but saw %d", patio.queueMgr.Len())
+	}
+
+	song := patio.queueMgr.PopQueue()
+	if song == nil || song.SongId != 1 {
+		t.Fatalf("Shared zone should pop song 1, but got %v", song)
+	}
+
+	if zones.defaultZone.queueMgr.NowPlaying() != nil {
+		t.Errorf("Default zone should not be playing the song popped by the shared zone")
+	}
+}
+
+func TestZoneAdd_whenNotShared_usesOwnQueue(t *testing.T) {
+	zones := setupZones()
+	patio := zones.add(1, "patio", false)
+
+	zones.defaultZone.queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1})
+
+	if patio.queueMgr.Len() != 0 {
+		t.Errorf("Zone with its own queue should be empty, but had %d songs", patio.queueMgr.Len())
+	}
+}
+
+func TestZoneRemove_whenDefault_fails(t *testing.T) {
+	zones := setupZones()
+
+	if err := zones.remove(defaultZoneId); err != ErrRemoveDefaultZone {
+		t.Errorf("Removing the default zone should fail, but returned %v", err)
+	}
+}
+
+func TestZoneGetByName_when_success(t *testing.T) {
+	zones := setupZones()
+	zones.add(1, "patio", false)
+
+	found, exists := zones.getByName("patio")
+	if !exists || found.id != 1 {
+		t.Errorf("Should find zone 1 by name")
+	}
+
+	found, exists = zones.getByName("")
+	if !exists || found.id != defaultZoneId {
+		t.Errorf("Empty name should find the default zone")
+	}
+}
+
+func TestZoneManager_forgetUser_resetsTurns(t *testing.T) {
+	zones := setupZones()
+	queueMgr := zones.defaultZone.queueMgr
+
+	// user 1 used up three turns, which have all played
+	for id := uint32(1); id <= 3; id++ {
+		queueMgr.AddSong(&cmpb.Song{SongId: id, UserId: 1})
+	}
+	for queueMgr.Len() > 0 {
+		queueMgr.PopQueue()
+	}
+
+	zones.forgetUser(1)
+
+	// user 1 takes a turn in the current round along with user 2
+	queueMgr.AddSong(&cmpb.Song{SongId: 4, UserId: 1})
+	queueMgr.AddSong(&cmpb.Song{SongId: 5, UserId: 2})
+	playlist := queueMgr.GetPlaylist().Songs
+	if len(playlist) != 2 || playlist[0].SongId != 4 || playlist[1].SongId != 5 {
+		t.Errorf("Expected songs 4, 5 after forgetting user 1, got %v", playlist)
+	}
+}
+
+func TestZoneManager_forgetUser_whenSongsQueued_keepsTurns(t *testing.T) {
+	zones := setupZones()
+	queueMgr := zones.defaultZone.queueMgr
+
+	queueMgr.AddSong(&cmpb.Song{SongId: 1, UserId: 1})
+	queueMgr.AddSong(&cmpb.Song{SongId: 2, UserId: 1})
+	zones.forgetUser(1)
+	queueMgr.AddSong(&cmpb.Song{SongId: 3, UserId: 1})
+
+	playlist := queueMgr.GetPlaylist().Songs
+	if len(playlist) != 3 || playlist[2].SongId != 3 {
+		t.Errorf("Expected song 3 to stay behind the user's queued songs, got %v", playlist)
+	}
+}
+
+func TestSaveQueue_zoneWithOwnQueue_savedAndRestored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_zones")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &memoryStore{saved: make(map[string][]byte)}
+	start := func() *BackendServer {
+		dbManager := new(db.SqliteManager)
+		if err := dbManager.Init(filepath.Join(dir, "test.db")); err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := New(&ServerConfig{}, WithListener(listener), WithDbManager(dbManager),
+			WithSnapshotStore(store))
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return server
+	}
+
+	server := start()
+	zoneData, _ := server.dbManager.AddZone("patio", false)
+	patio := server.zones.add(zoneData.Zone.Id, zoneData.Zone.Name, zoneData.Zone.Shared)
+
+	room, _ := server.dbManager.AddRoom("Kitchen")
+	user, _ := server.dbManager.AddUser("Zedd", room.Room.Id)
+	server.queueSong(patio, &cmpb.Song{Title: "patio song", Service: cmpb.ServiceType_Youtube,
+		ServiceId: "dQw4w9WgXcQ", UserId: user.User.UserId, Username: "Zedd", RoomId: room.Room.Id})
+	server.writes.flush()
+	server.dbManager.Close()
+
+	if _, exists := store.saved[zoneQueueSnapshot(patio.id)]; !exists {
+		t.Fatalf("Expected the patio's queue saved, got %v", store.saved)
+	}
+	if _, exists := store.saved[queuer.QueueSnapshot]; exists {
+		t.Errorf("Expected the default zone's queue left alone")
+	}
+
+	// after a restart the patio plays on from its saved queue
+	restarted := start()
+	defer restarted.dbManager.Close()
+
+	restored, exists := restarted.zones.get(patio.id)
+	if !exists || restored.queueMgr.Len() != 1 || restarted.queueMgr.Len() != 0 {
+		t.Errorf("Expected the patio's song restored to its own queue, got zone %v", restored)
+	}
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/nguyenmq/ytbox-go/common"
+	"github.com/nguyenmq/ytbox-go/links"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
@@ -23,6 +28,9 @@ var (
 	app        = kingpin.New(prefix, "Command line client to ytb-be.")
 	remoteHost = app.Flag("host", "Address of remote ytb-be service.").Default("127.0.0.1").Short('h').String()
 	remotePort = app.Flag("port", "Port of remote ytb-be service.").Default("9009").Short('p').String()
+	token      = app.Flag("token", "Access token to send to the ytb-be service.").String()
+	apiKey     = app.Flag("apiKey", "Api key to send to the ytb-be service in place of a token.").String()
+	partyPass  = app.Flag("partyPassword", "Party password to send to the ytb-be service, if the host set one.").Envar("YTBOX_PARTY_PASSWORD").String()
 
 	// "playlist" subcommand
 	playlist = app.Command("playlist", "Get current songs in the playlist.").Alias("ls")
@@ -32,10 +40,19 @@ var (
 	loginName   = login.Arg("username", "Alias to login as.").Required().String()
 	loginRoomId = login.Arg("roomId", "Id of the room to log user into.").Required().Uint32()
 	loginId     = login.Arg("userId", "Id of the alias to login as.").Uint32()
+	loginPass   = login.Flag("password", "Password of the party, if the host set one.").String()
+
+	// "linkDevice" subcommand
+	linkDevice       = app.Command("linkDevice", "Sign in the device showing a login code as a user.")
+	linkDeviceUserId = linkDevice.Arg("userId", "Id of the user to sign the device in as.").Required().Uint32()
+	linkDeviceCode   = linkDevice.Arg("code", "Code shown on the device.").Required().String()
 
 	// "next" subcommand
 	next = app.Command("next", "Skip to the next song.")
 
+	// "previous" subcommand
+	previous = app.Command("previous", "Restart the song or go back to the one before it.").Alias("prev")
+
 	// "now" subcommand
 	now = app.Command("now", "Get the current song that is playing.").Default()
 
@@ -43,21 +60,63 @@ var (
 	pause = app.Command("pause", "Toggle pause state of the player.")
 
 	// "pop" subcommand
-	pop = app.Command("pop", "Pop a song off the top of the queue.")
+	pop = app.Command("pop", "Pop a song off the top of the queue. It returns to the top unless confirmed.")
+
+	// "confirm" subcommand
+	confirm       = app.Command("confirm", "Confirm a popped song started playing.")
+	confirmSongId = confirm.Arg("songId", "Id of the song that started playing.").Required().Uint32()
+	confirmZoneId = confirm.Flag("zone", "Id of the zone the song is playing in.").Uint32()
 
 	// "remove" subcommand
 	remove     = app.Command("remove", "Remove a song from the playlist.").Alias("rm")
 	removeSong = remove.Arg("songId", "Id of the song to remove.").Required().Uint32()
 	removeUser = remove.Arg("userId", "Id of the user who subitted the song.").Required().Uint32()
+	removeZone = remove.Flag("zone", "Id of the zone the song is queued in.").Uint32()
+
+	// "removeAll" subcommand
+	removeAll      = app.Command("removeAll", "Remove every queued copy of a song, no matter who submitted it.")
+	removeAllLink  = removeAll.Arg("link", "Link to the song or path of the local file.").Required().String()
+	removeAllBlock = removeAll.Flag("block", "Also block the song from being submitted again.").Bool()
+
+	// "unblock" subcommand
+	unblock     = app.Command("unblock", "Let a blocked song be submitted again.")
+	unblockLink = unblock.Arg("link", "Link to the song or path of the local file.").Required().String()
 
 	// "save" subcommand
-	save     = app.Command("save", "Save the current playlist to a file.")
-	saveFile = save.Arg("file", "File name to write playlist to").Required().String()
+	save        = app.Command("save", "Save the current playlist to a file.")
+	saveFile    = save.Arg("file", "File name to write playlist to").Required().String()
+	saveHistory = save.Flag("history", "Save the now playing song and recent history too.").Bool()
+
+	// "restore" subcommand
+	restore     = app.Command("restore", "Restore a saved snapshot or playlist.")
+	restoreFile = restore.Arg("file", "File name of the snapshot on the server.").Required().String()
+
+	// "migrate" subcommand
+	migrate     = app.Command("migrate", "Import a queue file written by an older backend.")
+	migrateFile = migrate.Arg("file", "File name of the queue file on the server.").Default("/tmp/ytbox.queue").String()
+
+	// "compare" subcommand
+	compare       = app.Command("compare", "Show what changed between two snapshots, or a snapshot and the live queue.")
+	compareBefore = compare.Arg("before", "File name of the snapshot on the server.").Required().String()
+	compareAfter  = compare.Arg("after", "File name of the snapshot to compare to. Defaults to the live queue.").String()
 
 	// "send" subcommand
 	send     = app.Command("send", "send a link to the queue.")
-	sendLink = send.Arg("link", "Link to song.").Required().String()
+	sendLink = send.Arg("link", "Link to song or a search query.").Required().String()
 	sendUser = send.Arg("user", "User id to send link under.").Required().Uint32()
+	sendZone = send.Flag("zone", "Id of the zone to queue the song in.").Uint32()
+	sendFor  = send.Flag("for", "Name of a user in the same room to queue the song for.").String()
+	sendAnon = send.Flag("anonymous", "Show the song as submitted by \"Anonymous\".").Bool()
+	sendPart = send.Flag("chapter", "Number of the chapter of the video to queue instead of the whole video.").Uint32()
+
+	// "kiosk" subcommand
+	kiosk      = app.Command("kiosk", "Send a link to the queue for a guest, the way a kiosk does. Needs a kiosk api key.")
+	kioskLink  = kiosk.Arg("link", "Link to song or a search query.").Required().String()
+	kioskGuest = kiosk.Arg("name", "Name the guest entered.").Required().String()
+
+	// "chapters" subcommand
+	chapters     = app.Command("chapters", "List the chapters of a long video, like the tracks of a full album.")
+	chaptersLink = chapters.Arg("link", "Link to the video.").Required().String()
 
 	// "newRoom" subcommand
 	newRoom  = app.Command("newRoom", "Creates a new room.")
@@ -65,6 +124,376 @@ var (
 
 	getRoom     = app.Command("getRoom", "Query for a room by name.")
 	getRoomName = getRoom.Arg("name", "Name of the room.").Required().String()
+
+	// "search" subcommand
+	search        = app.Command("search", "Search YouTube for songs to submit.")
+	searchQuery   = search.Arg("query", "Text to search for.").Required().String()
+	searchResults = search.Flag("results", "Maximum number of results.").Uint32()
+
+	// "details" subcommand
+	details       = app.Command("details", "Get extended metadata about a song.")
+	detailsSongId = details.Arg("songId", "Id of the song.").Required().Uint32()
+
+	// "songs" subcommand
+	songs        = app.Command("songs", "Look up songs in the queues or the history by id.")
+	songsSongIds = songs.Arg("songIds", "Ids of the songs.").Required().Uint32List()
+
+	// "stats" subcommand
+	stats = app.Command("stats", "Get statistics about submitted songs.")
+
+	// "history" subcommand
+	history      = app.Command("history", "List the recently submitted songs and how each one ended.")
+	historyUser  = history.Flag("user", "Only list the songs of this user.").Uint32()
+	historyLimit = history.Flag("limit", "Number of songs to list.").Uint32()
+
+	// "skips" subcommand
+	skips     = app.Command("skips", "Show how a user's songs ended and who skipped them the most.")
+	skipsUser = skips.Arg("user", "Id of the user.").Required().Uint32()
+
+	// "achievements" subcommand
+	achievements     = app.Command("achievements", "List the achievements a user has earned.")
+	achievementsUser = achievements.Arg("user", "Id of the user.").Required().Uint32()
+
+	// "leaderboard" subcommand
+	leaderboard      = app.Command("leaderboard", "Rank users by the number of songs they submitted.")
+	leaderboardLimit = leaderboard.Flag("limit", "Number of users to show.").Uint32()
+
+	// "maintain" subcommand
+	maintain = app.Command("maintain", "Prune old history and compact the database.")
+
+	// "logLevel" subcommand
+	logLevel     = app.Command("logLevel", "Change how much the backend logs without restarting it.")
+	logLevelName = logLevel.Arg("level", "How much to log: info, or debug to also log every rpc call and player command.").Required().Enum("info", "debug")
+
+	// "dump" subcommand
+	dump = app.Command("dump", "Report on the backend's internal state for debugging.")
+
+	// "clients" subcommand
+	clients = app.Command("clients", "List the players and other clients streaming from the backend.")
+
+	// "share" subcommand
+	share          = app.Command("share", "Share the queue under a short code.")
+	shareUser      = share.Arg("userId", "Id of the user sharing the queue.").Required().Uint32()
+	shareUserSongs = share.Flag("mine", "Only share the songs queued by the user.").Bool()
+	shareZone      = share.Flag("zone", "Id of the zone whose queue is shared.").Uint32()
+
+	// "import" subcommand
+	importCode = app.Command("import", "Queue up the songs of a shared playlist.")
+	importArg  = importCode.Arg("code", "Share code of the playlist.").Required().String()
+	importUser = importCode.Arg("userId", "Id of the user to queue the songs under.").Required().Uint32()
+	importZone = importCode.Flag("zone", "Id of the zone to queue the songs in.").Uint32()
+
+	// "devices" subcommand
+	devices     = app.Command("devices", "List the audio output devices of the players in a zone.")
+	devicesZone = devices.Flag("zone", "Id of the zone.").Uint32()
+
+	// "setDevice" subcommand
+	setDevice       = app.Command("setDevice", "Select the audio output device of the players.")
+	setDeviceName   = setDevice.Arg("device", "Name of the audio output device.").Required().String()
+	setDeviceZone   = setDevice.Flag("zone", "Id of the zone.").Uint32()
+	setDevicePlayer = setDevice.Flag("player", "Id of the player. Selects the device on all players if not set.").Uint32()
+
+	// "bluetooth" subcommand
+	bluetooth     = app.Command("bluetooth", "List the bluetooth speakers known to the players in a zone.")
+	bluetoothZone = bluetooth.Flag("zone", "Id of the zone.").Uint32()
+
+	// "btScan" subcommand
+	btScan       = app.Command("btScan", "Scan for nearby bluetooth speakers.")
+	btScanZone   = btScan.Flag("zone", "Id of the zone.").Uint32()
+	btScanPlayer = btScan.Flag("player", "Id of the player. Scans on all players if not set.").Uint32()
+
+	// "btPair" subcommand
+	btPair        = app.Command("btPair", "Pair a player with a bluetooth speaker.")
+	btPairAddress = btPair.Arg("address", "Address of the bluetooth speaker.").Required().String()
+	btPairZone    = btPair.Flag("zone", "Id of the zone.").Uint32()
+	btPairPlayer  = btPair.Flag("player", "Id of the player. Pairs all players if not set.").Uint32()
+
+	// "btConnect" subcommand
+	btConnect        = app.Command("btConnect", "Connect a player to a paired bluetooth speaker.")
+	btConnectAddress = btConnect.Arg("address", "Address of the bluetooth speaker.").Required().String()
+	btConnectZone    = btConnect.Flag("zone", "Id of the zone.").Uint32()
+	btConnectPlayer  = btConnect.Flag("player", "Id of the player. Connects all players if not set.").Uint32()
+
+	// "zones" subcommand
+	zones = app.Command("zones", "List the player zones.")
+
+	// "newZone" subcommand
+	newZone       = app.Command("newZone", "Creates a new player zone.")
+	newZoneName   = newZone.Arg("name", "Name of the zone.").Required().String()
+	newZoneShared = newZone.Flag("shared", "Play songs from the default zone's queue.").Bool()
+
+	// "rmZone" subcommand
+	rmZone   = app.Command("rmZone", "Removes a player zone.")
+	rmZoneId = rmZone.Arg("zoneId", "Id of the zone.").Required().Uint32()
+
+	// "zonePlaylist" subcommand
+	zonePlaylist   = app.Command("zonePlaylist", "Get the songs queued up for a zone.")
+	zonePlaylistId = zonePlaylist.Arg("zoneId", "Id of the zone.").Required().Uint32()
+
+	// "react" subcommand
+	react      = app.Command("react", "React to the now playing song with an emoji.")
+	reactUser  = react.Arg("userId", "Id of the user reacting.").Required().Uint32()
+	reactEmoji = react.Arg("emoji", "Emoji to react with.").Required().String()
+	reactZone  = react.Flag("zone", "Id of the zone.").Uint32()
+
+	// "events" subcommand
+	events       = app.Command("events", "Print events from the server as they happen.")
+	eventsResume = events.Flag("resume", "Sequence of the last event seen, to print the ones missed since first.").Uint64()
+
+	// "exempt" subcommand
+	exempt       = app.Command("exempt", "Exempt a user from the submission limits.")
+	exemptUser   = exempt.Arg("userId", "Id of the user.").Required().Uint32()
+	exemptRevoke = exempt.Flag("revoke", "Take the exemption away instead.").Bool()
+
+	// "exemptions" subcommand
+	exemptions = app.Command("exemptions", "List the users exempt from the submission limits.")
+
+	// "position" subcommand
+	position     = app.Command("position", "Get how far along the now playing song is.")
+	positionZone = position.Flag("zone", "Id of the zone.").Uint32()
+
+	// "lyrics" subcommand
+	lyrics       = app.Command("lyrics", "Get the lyrics of the now playing song.")
+	lyricsZone   = lyrics.Flag("zone", "Id of the zone.").Uint32()
+	lyricsSynced = lyrics.Flag("synced", "Print the lyrics with timestamps if there are any.").Bool()
+
+	// "savePreset" subcommand
+	savePreset         = app.Command("savePreset", "Save queue settings for a recurring event.")
+	savePresetName     = savePreset.Arg("name", "Name of the preset.").Required().String()
+	savePresetFifo     = savePreset.Flag("fifo", "Play songs in the order they were submitted instead of taking turns.").Bool()
+	savePresetHybrid   = savePreset.Flag("hybrid", "Take turns, but let votes move songs ahead.").Bool()
+	savePresetMinutes  = savePreset.Flag("maxMinutes", "Longest song accepted in minutes.").Uint32()
+	savePresetWindow   = savePreset.Flag("window", "Only accept songs expected to start within this long, e.g. 1h.").Duration()
+	savePresetFallback = savePreset.Flag("fallback", "Share code of a playlist to play when the queue runs dry.").String()
+	savePresetOpen     = savePreset.Flag("open", "Time the queue opens each day, e.g. 17:00.").String()
+	savePresetClose    = savePreset.Flag("close", "Time the queue closes each day, e.g. 23:00.").String()
+
+	// "presets" subcommand
+	presets = app.Command("presets", "List the saved presets.")
+
+	// "applyPreset" subcommand
+	applyPreset     = app.Command("applyPreset", "Apply the settings of a saved preset.")
+	applyPresetName = applyPreset.Arg("name", "Name of the preset.").Required().String()
+
+	// "active" subcommand
+	active = app.Command("active", "List the users who did something recently.")
+
+	// "heartbeat" subcommand
+	heartbeat     = app.Command("heartbeat", "Keep a user active.")
+	heartbeatUser = heartbeat.Arg("userId", "Id of the user.").Required().Uint32()
+
+	// "voteSkip" subcommand
+	voteSkip     = app.Command("voteSkip", "Vote to skip the now playing song.")
+	voteSkipUser = voteSkip.Arg("userId", "Id of the user voting.").Required().Uint32()
+	voteSkipZone = voteSkip.Flag("zone", "Id of the zone.").Uint32()
+
+	// "whoami" subcommand
+	whoAmI     = app.Command("whoami", "Show a user and their submission preferences.")
+	whoAmIUser = whoAmI.Arg("userId", "Id of the user.").Required().Uint32()
+
+	// "link" subcommand
+	linkAccount    = app.Command("link", "Link an account on another service, like a Discord user, to a user.")
+	linkUser       = linkAccount.Arg("userId", "Id of the user.").Required().Uint32()
+	linkProvider   = linkAccount.Arg("provider", "Service the account belongs to, like \"discord\".").Required().String()
+	linkExternalId = linkAccount.Arg("externalId", "Id of the account on its service.").Required().String()
+
+	// "unlink" subcommand
+	unlinkAccount    = app.Command("unlink", "Unlink an account on another service from its user.")
+	unlinkProvider   = unlinkAccount.Arg("provider", "Service the account belongs to.").Required().String()
+	unlinkExternalId = unlinkAccount.Arg("externalId", "Id of the account on its service.").Required().String()
+
+	// "identity" subcommand
+	identity           = app.Command("identity", "Show the user an account on another service is linked to.")
+	identityProvider   = identity.Arg("provider", "Service the account belongs to.").Required().String()
+	identityExternalId = identity.Arg("externalId", "Id of the account on its service.").Required().String()
+
+	// "prefs" subcommand
+	prefs              = app.Command("prefs", "Set the defaults a user's submissions get.")
+	prefsUser          = prefs.Arg("userId", "Id of the user.").Required().Uint32()
+	prefsAudioOnly     = prefs.Flag("audioOnly", "Play the user's songs without video.").Bool()
+	prefsFromBeginning = prefs.Flag("fromBeginning", "Ignore the timestamps in the user's links.").Bool()
+	prefsNotify        = prefs.Flag("notify", "Notify the user when their songs start playing.").Bool()
+
+	// "info" subcommand
+	info     = app.Command("info", "Show how screens show the party in a user's room.")
+	infoUser = info.Arg("userId", "Id of the user. Leave out for the whole server.").Uint32()
+
+	// "display" subcommand
+	display            = app.Command("display", "Set how screens show the party.")
+	displayName        = display.Arg("name", "Name of the party.").Required().String()
+	displayRoom        = display.Flag("room", "Id of the room to set. Leave out for the whole server.").Uint32()
+	displayColor       = display.Flag("color", "Color to theme screens with, like #ff6600.").String()
+	displayBanner      = display.Flag("banner", "Message shown across the top of every screen.").String()
+	displayHideSubmits = display.Flag("hideSubmitters", "Don't show who submitted each song.").Bool()
+	displayRomanized   = display.Flag("romanized", "Show titles in other scripts in Latin letters when they can be spelled out.").Bool()
+
+	// "joinCode" subcommand
+	joinCode        = app.Command("joinCode", "Save a QR code of the link guests join the party at.")
+	joinCodeRoom    = joinCode.Flag("room", "Name of the room the link logs guests into.").String()
+	joinCodeSize    = joinCode.Flag("size", "Width and height of the QR code in pixels.").Default("256").Uint32()
+	joinCodeBaseUrl = joinCode.Flag("baseUrl", "Address of the web frontend, if ytb-be wasn't started with --joinUrl.").String()
+	joinCodeOut     = joinCode.Flag("out", "File to save the QR code to.").Default("join.png").String()
+
+	// "duck" subcommand
+	duck        = app.Command("duck", "Lower the volume of a zone's players, such as for an announcement.")
+	duckZone    = duck.Flag("zone", "Id of the zone.").Uint32()
+	duckVolume  = duck.Flag("volume", "Percent of the normal volume to play at.").Default("30").Uint32()
+	duckSeconds = duck.Flag("for", "How long to stay ducked, e.g. 30s. Stays ducked until unduck if not set.").Duration()
+
+	// "unduck" subcommand
+	unduck     = app.Command("unduck", "Bring the volume of a zone's players back up.")
+	unduckZone = unduck.Flag("zone", "Id of the zone.").Uint32()
+
+	// "quality" subcommand
+	quality     = app.Command("quality", "Set the quality a zone's players play songs at.")
+	qualityName = quality.Arg("quality", "Quality to play songs at: default, audio, 480p or 1080p.").Required().Enum("default", "audio", "480p", "1080p")
+	qualityZone = quality.Flag("zone", "Id of the zone.").Uint32()
+
+	// "tag" subcommand
+	tag       = app.Command("tag", "Tag a queued or played song, such as chill or throwback.")
+	tagUser   = tag.Arg("userId", "Id of the user tagging the song.").Required().Uint32()
+	tagSongId = tag.Arg("songId", "Id of the song.").Required().Uint32()
+	tagName   = tag.Arg("tag", "Tag to put on the song.").Required().String()
+	tagZone   = tag.Flag("zone", "Id of the zone the song is queued in.").Uint32()
+
+	// "untag" subcommand
+	untag       = app.Command("untag", "Remove a tag you put on a song.")
+	untagUser   = untag.Arg("userId", "Id of the user who tagged the song.").Required().Uint32()
+	untagSongId = untag.Arg("songId", "Id of the song.").Required().Uint32()
+	untagName   = untag.Arg("tag", "Tag to remove from the song.").Required().String()
+	untagZone   = untag.Flag("zone", "Id of the zone the song is queued in.").Uint32()
+
+	// "tagged" subcommand
+	tagged      = app.Command("tagged", "List the songs with a tag, most recently played first.")
+	taggedName  = tagged.Arg("tag", "Tag to look for. Tags starting with it match too.").String()
+	taggedLimit = tagged.Flag("limit", "Most songs to list.").Uint32()
+
+	// "queueTagged" subcommand
+	queueTagged     = app.Command("queueTagged", "Queue a song with a tag for a user.")
+	queueTaggedUser = queueTagged.Arg("userId", "Id of the user to queue the song for.").Required().Uint32()
+	queueTaggedName = queueTagged.Arg("tag", "Tag the song must have.").Required().String()
+	queueTaggedZone = queueTagged.Flag("zone", "Id of the zone.").Uint32()
+
+	// "djTag" subcommand
+	djTag     = app.Command("djTag", "Have the auto dj pick songs with a tag. Leave the tag out to pick from the whole history.")
+	djTagName = djTag.Arg("tag", "Tag the songs should have.").String()
+
+	// "mine" subcommand
+	mine     = app.Command("mine", "List a user's songs waiting in the queue.")
+	mineUser = mine.Arg("userId", "Id of the user.").Required().Uint32()
+	mineZone = mine.Flag("zone", "Id of the zone.").Uint32()
+
+	// "recap" subcommand
+	recap   = app.Command("recap", "Show the recap of a party that ended.")
+	recapId = recap.Arg("id", "Id of the recap. Shows the latest one if not set.").Uint32()
+
+	// "endParty" subcommand
+	endParty = app.Command("endParty", "End the current party and show its recap.")
+
+	// "pending" subcommand
+	pending = app.Command("pending", "List the long songs waiting for approval.")
+
+	// "review" subcommand
+	review       = app.Command("review", "Approve a long song waiting for approval, or turn it away.")
+	reviewId     = review.Arg("id", "Id of the pending song.").Required().Uint32()
+	reviewReject = review.Flag("reject", "Turn the song away instead of queueing it.").Bool()
+
+	// "takeDecks" subcommand
+	takeDecks         = app.Command("takeDecks", "Hand the decks to a dj, so only their songs play.")
+	takeDecksUser     = takeDecks.Arg("userId", "Id of the dj.").Required().Uint32()
+	takeDecksZone     = takeDecks.Flag("zone", "Id of the zone. Defaults to the default zone.").Uint32()
+	takeDecksSuggests = takeDecks.Flag("suggestions", "Hold everyone else's songs as suggestions instead of turning them away.").Bool()
+
+	// "handBack" subcommand
+	handBack = app.Command("handBack", "Take the decks back from the dj and return everyone's songs to the queue.")
+
+	// "suggestions" subcommand
+	suggestions = app.Command("suggestions", "List the songs suggested to the dj.")
+
+	// "suggestion" subcommand
+	suggestion       = app.Command("suggestion", "Accept a song suggested to the dj, or turn it away.")
+	suggestionId     = suggestion.Arg("id", "Id of the suggestion.").Required().Uint32()
+	suggestionReject = suggestion.Flag("reject", "Turn the song away instead of queueing it.").Bool()
+
+	// "addJingle" subcommand
+	addJingle     = app.Command("addJingle", "Register a jingle to play between songs.")
+	addJingleName = addJingle.Arg("name", "Name of the jingle.").Required().String()
+	addJingleLink = addJingle.Arg("link", "Path to an audio file on the players or an http link to one.").Required().String()
+
+	// "removeJingle" subcommand
+	removeJingle     = app.Command("removeJingle", "Stop playing a jingle.")
+	removeJingleName = removeJingle.Arg("name", "Name of the jingle.").Required().String()
+
+	// "jingles" subcommand
+	jingles = app.Command("jingles", "List the registered jingles.")
+
+	// "schedule" subcommand
+	schedule          = app.Command("schedule", "Pin a song to play at a set time, like the countdown song at midnight.")
+	scheduleUser      = schedule.Arg("userId", "Id of the user the song is credited to.").Required().Uint32()
+	scheduleLink      = schedule.Arg("link", "Link to the song, or a search for it.").Required().String()
+	scheduleAt        = schedule.Arg("at", "Time to play the song at, as 23:59 for the next time the clock reads it or 2006-01-02 23:59.").Required().String()
+	scheduleZone      = schedule.Flag("zone", "Id of the zone.").Uint32()
+	scheduleInterrupt = schedule.Flag("interrupt", "Cut off the song playing at the time instead of letting it finish.").Bool()
+
+	// "unschedule" subcommand
+	unschedule   = app.Command("unschedule", "Cancel a scheduled song.")
+	unscheduleId = unschedule.Arg("id", "Id of the scheduled song.").Required().Uint32()
+
+	// "scheduled" subcommand
+	scheduled = app.Command("scheduled", "List the songs scheduled to play at set times.")
+
+	// "registerPlayer" subcommand
+	registerPlayer       = app.Command("registerPlayer", "Register a player and print the token it connects with.")
+	registerPlayerName   = registerPlayer.Arg("name", "Name of the player, like \"living room Pi\".").Required().String()
+	registerPlayerZone   = registerPlayer.Flag("zone", "Zone the player joins. Lets the player choose if not set.").String()
+	registerPlayerOutput = registerPlayer.Flag("output", "Audio output device the player uses when it connects.").String()
+
+	// "unregisterPlayer" subcommand
+	unregisterPlayer     = app.Command("unregisterPlayer", "Remove a registered player.")
+	unregisterPlayerName = unregisterPlayer.Arg("name", "Name of the player.").Required().String()
+
+	// "players" subcommand
+	players = app.Command("players", "List the registered players and whether they're connected.")
+
+	// "createKey" subcommand
+	createKey      = app.Command("createKey", "Create an api key for a bot or kiosk and print it.")
+	createKeyName  = createKey.Arg("name", "Name of the client, like \"discord bot\".").Required().String()
+	createKeyScope = createKey.Flag("scope", "What the key can call: read, submit, kiosk or player.").Default("read").Enum("read", "submit", "kiosk", "player")
+
+	// "revokeKey" subcommand
+	revokeKey     = app.Command("revokeKey", "Revoke an api key.")
+	revokeKeyName = revokeKey.Arg("name", "Name of the key.").Required().String()
+
+	// "keys" subcommand
+	keys = app.Command("keys", "List the api keys and their scopes.")
+
+	// "timeOut" subcommand
+	timeOut         = app.Command("timeOut", "Move a user's songs to the end of the queue and stop them submitting for a while.")
+	timeOutUser     = timeOut.Arg("userId", "Id of the user.").Required().Uint32()
+	timeOutDuration = timeOut.Arg("duration", "How long the time-out lasts, like 30m.").Required().Duration()
+
+	// "endTimeOut" subcommand
+	endTimeOut     = app.Command("endTimeOut", "End a user's time-out early.")
+	endTimeOutUser = endTimeOut.Arg("userId", "Id of the user.").Required().Uint32()
+
+	// "timeOuts" subcommand
+	timeOuts = app.Command("timeOuts", "List the users who are timed out.")
+
+	// "fairness" subcommand
+	fairness     = app.Command("fairness", "Show how fairly the queue is treating its users.")
+	fairnessZone = fairness.Flag("zone", "Id of the zone.").Uint32()
+
+	// "playNext" subcommand
+	playNext       = app.Command("playNext", "Ask for a song to play next.")
+	playNextUser   = playNext.Arg("userId", "Id of the user asking.").Required().Uint32()
+	playNextSongId = playNext.Arg("songId", "Id of the user's song.").Required().Uint32()
+	playNextZone   = playNext.Flag("zone", "Id of the zone.").Uint32()
+
+	// "approveNext" subcommand
+	approveNext       = app.Command("approveNext", "Approve another user's request to play their song next.")
+	approveNextUser   = approveNext.Arg("userId", "Id of the user approving.").Required().Uint32()
+	approveNextSongId = approveNext.Arg("songId", "Id of the song asked for.").Required().Uint32()
+	approveNextZone   = approveNext.Flag("zone", "Id of the zone.").Uint32()
 )
 
 /*
@@ -76,6 +505,9 @@ func connectToRemote() (*grpc.ClientConn, bepb.YtbBackendClient) {
 	opts = append(opts, grpc.WithInsecure())
 	opts = append(opts, grpc.WithBlock())
 	opts = append(opts, grpc.FailOnNonTempDialError(true))
+	opts = append(opts, common.TokenDialOptions(*token)...)
+	opts = append(opts, common.ApiKeyDialOptions(*apiKey)...)
+	opts = append(opts, common.PartyPasswordDialOptions(*partyPass)...)
 
 	conn, err := grpc.Dial(*remoteHost+":"+*remotePort, opts...)
 	if err != nil {
@@ -93,8 +525,13 @@ func connectToRemote() (*grpc.ClientConn, bepb.YtbBackendClient) {
 func sendCommand(client bepb.YtbBackendClient) {
 	link := *sendLink
 	_, err := client.SendSong(context.Background(), &bepb.Submission{
-		Link:   link,
-		UserId: *sendUser,
+		Link:        link,
+		UserId:      *sendUser,
+		ZoneId:      *sendZone,
+		Source:      cmpb.SubmissionSource_Cli,
+		ForUsername: *sendFor,
+		Anonymous:   *sendAnon,
+		Chapter:     *sendPart,
 	})
 	if err != nil {
 		fmt.Printf("failed to call SendSong: %v\n", err)
@@ -104,6 +541,66 @@ func sendCommand(client bepb.YtbBackendClient) {
 	fmt.Println(link)
 }
 
+/*
+ * Handler to send a link for a guest at a kiosk
+ */
+func kioskCommand(client bepb.YtbBackendClient) {
+	response, err := client.SendSong(context.Background(), &bepb.Submission{
+		Link:      *kioskLink,
+		GuestName: *kioskGuest,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SendSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Success {
+		fmt.Println(response.Message)
+		os.Exit(1)
+	}
+
+	fmt.Println(*kioskLink)
+}
+
+/*
+ * Handler to list the chapters of a video, numbered the way send --chapter
+ * takes them
+ */
+func chaptersCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListChapters(context.Background(), &bepb.Submission{Link: *chaptersLink})
+	if err != nil {
+		fmt.Printf("failed to call ListChapters: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	if len(response.Chapters) == 0 {
+		fmt.Printf("%s has no chapters\n", response.Title)
+		return
+	}
+
+	fmt.Println(response.Title)
+	for i, chapter := range response.Chapters {
+		fmt.Printf("%3d. { start: %s, end: %s, title: %s }\n", i+1, clockTime(chapter.StartAt),
+			clockTime(chapter.EndAt), chapter.Title)
+	}
+}
+
+/*
+ * Write seconds the way YouTube writes timestamps, like 1:02:03 or 4:05
+ */
+func clockTime(seconds uint32) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
 /*
  * Handler to list the songs in the playlist
  */
@@ -115,8 +612,18 @@ func playlistCommand(client bepb.YtbBackendClient) {
 	}
 
 	for i := 0; i < len(playlist.Songs); i++ {
-		fmt.Printf("%3d. { id: %2d, user: %2d, title: %s }\n",
-			i+1, playlist.Songs[i].SongId, playlist.Songs[i].UserId, playlist.Songs[i].Title)
+		song := playlist.Songs[i]
+		votes := ""
+		if song.Votes > 0 {
+			votes = fmt.Sprintf(", votes: %d", song.Votes)
+		}
+
+		if song.ForUserId != 0 {
+			fmt.Printf("%3d. { id: %2d, user: %2d, for: %2d, title: %s%s }\n",
+				i+1, song.SongId, song.UserId, song.ForUserId, song.Title, votes)
+		} else {
+			fmt.Printf("%3d. { id: %2d, user: %2d, title: %s%s }\n", i+1, song.SongId, song.UserId, song.Title, votes)
+		}
 	}
 }
 
@@ -124,7 +631,7 @@ func playlistCommand(client bepb.YtbBackendClient) {
  * Tell the backend server to save the current playlist to a file
  */
 func saveCommand(client bepb.YtbBackendClient) {
-	response, err := client.SavePlaylist(context.Background(), &bepb.FilePath{Path: *saveFile})
+	response, err := client.SavePlaylist(context.Background(), &bepb.FilePath{Path: *saveFile, WithHistory: *saveHistory})
 	if err != nil {
 		fmt.Printf("failed to call GetPlaylist: %v\n", err)
 		os.Exit(1)
@@ -133,6 +640,59 @@ func saveCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
 }
 
+func restoreCommand(client bepb.YtbBackendClient) {
+	response, err := client.RestorePlaylist(context.Background(), &bepb.FilePath{Path: *restoreFile})
+	if err != nil {
+		fmt.Printf("failed to call RestorePlaylist: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func migrateCommand(client bepb.YtbBackendClient) {
+	response, err := client.MigrateQueue(context.Background(), &bepb.FilePath{Path: *migrateFile})
+	if err != nil {
+		fmt.Printf("failed to call MigrateQueue: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+/*
+ * Show the songs added, removed and moved between two playlists
+ */
+func compareCommand(client bepb.YtbBackendClient) {
+	comparison := &bepb.PlaylistComparison{Before: *compareBefore, After: *compareAfter}
+	response, err := client.ComparePlaylists(context.Background(), comparison)
+	if err != nil {
+		fmt.Printf("failed to call ComparePlaylists: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, song := range response.Added {
+		fmt.Printf("+ { service: %s, user: %s, title: %s }\n", song.ServiceId, song.Username, song.Title)
+	}
+
+	for _, song := range response.Removed {
+		fmt.Printf("- { service: %s, user: %s, title: %s }\n", song.ServiceId, song.Username, song.Title)
+	}
+
+	for _, moved := range response.Moved {
+		fmt.Printf("~ { from: %d, to: %d, user: %s, title: %s }\n", moved.From+1, moved.To+1, moved.Song.Username,
+			moved.Song.Title)
+	}
+
+	fmt.Printf("Added: %d, removed: %d, moved: %d, unchanged: %d\n", len(response.Added), len(response.Removed),
+		len(response.Moved), response.Unchanged)
+}
+
 func popCommand(client bepb.YtbBackendClient) {
 	song, err := client.PopQueue(context.Background(), &cmpb.Empty{})
 	if err != nil {
@@ -143,8 +703,25 @@ func popCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Popped song: { %v}\n", song)
 }
 
+func confirmCommand(client bepb.YtbBackendClient) {
+	response, err := client.ConfirmPlayback(context.Background(),
+		&bepb.PlaybackConfirmation{ZoneId: *confirmZoneId, SongId: *confirmSongId})
+	if err != nil {
+		fmt.Printf("failed to call ConfirmPlayback: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
 func loginCommand(client bepb.YtbBackendClient) {
-	user, err := client.LoginUser(context.Background(), &bepb.User{Username: *loginName, UserId: *loginId, RoomId: *loginRoomId})
+	password := *loginPass
+	if password == "" {
+		password = *partyPass
+	}
+
+	user, err := client.LoginUser(context.Background(), &bepb.User{Username: *loginName, UserId: *loginId, RoomId: *loginRoomId,
+		PartyPassword: password})
 	if err != nil {
 		fmt.Printf("failed to call LoginUser: %v\n", err)
 		os.Exit(1)
@@ -160,7 +737,7 @@ func loginCommand(client bepb.YtbBackendClient) {
 }
 
 func removeCommand(client bepb.YtbBackendClient) {
-	response, err := client.RemoveSong(context.Background(), &bepb.Eviction{SongId: *removeSong, UserId: *removeUser})
+	response, err := client.RemoveSong(context.Background(), &bepb.Eviction{SongId: *removeSong, UserId: *removeUser, ZoneId: *removeZone})
 	if err != nil {
 		fmt.Printf("failed to call RemoveSong: %v\n", err)
 		os.Exit(1)
@@ -169,6 +746,58 @@ func removeCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
 }
 
+func removeAllCommand(client bepb.YtbBackendClient) {
+	eviction := serviceEviction(*removeAllLink)
+	eviction.Block = *removeAllBlock
+
+	response, err := client.RemoveByServiceId(context.Background(), eviction)
+	if err != nil {
+		fmt.Printf("failed to call RemoveByServiceId: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("Removed %d queued copies", response.Removed)
+	if response.Blocked {
+		fmt.Print(" and blocked the song")
+	}
+	fmt.Println()
+}
+
+func unblockCommand(client bepb.YtbBackendClient) {
+	response, err := client.UnblockSong(context.Background(), serviceEviction(*unblockLink))
+	if err != nil {
+		fmt.Printf("failed to call UnblockSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+/*
+ * Returns the eviction of the song a link points to, the same way the backend
+ * identifies a submitted link
+ */
+func serviceEviction(link string) *bepb.ServiceEviction {
+	parsed := links.Parse(link)
+	switch parsed.Kind {
+	case links.Youtube:
+		return &bepb.ServiceEviction{Service: cmpb.ServiceType_Youtube, ServiceId: parsed.VideoId}
+	case links.LocalFile:
+		return &bepb.ServiceEviction{Service: cmpb.ServiceType_Local, ServiceId: parsed.Raw}
+	case links.Web:
+		return &bepb.ServiceEviction{Service: cmpb.ServiceType_Web, ServiceId: parsed.Raw}
+	}
+
+	fmt.Printf("%q isn't a link to a song\n", link)
+	os.Exit(1)
+	return nil
+}
+
 func nowCommand(client bepb.YtbBackendClient) {
 	song, err := client.GetNowPlaying(context.Background(), &cmpb.Empty{})
 	if err != nil {
@@ -184,6 +813,17 @@ func nowCommand(client bepb.YtbBackendClient) {
 	}
 }
 
+func linkDeviceCommand(client bepb.YtbBackendClient) {
+	response, err := client.ConfirmLoginCode(context.Background(),
+		&bepb.LoginCodeConfirmation{UserId: *linkDeviceUserId, Code: *linkDeviceCode})
+	if err != nil {
+		fmt.Printf("failed to call ConfirmLoginCode: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
 func nextCommand(client bepb.YtbBackendClient) {
 	response, err := client.NextSong(context.Background(), &cmpb.Empty{})
 	if err != nil {
@@ -194,6 +834,16 @@ func nextCommand(client bepb.YtbBackendClient) {
 	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
 }
 
+func previousCommand(client bepb.YtbBackendClient) {
+	response, err := client.PreviousSong(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call PreviousSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
 func pauseCommand(client bepb.YtbBackendClient) {
 	response, err := client.PauseSong(context.Background(), &cmpb.Empty{})
 	if err != nil {
@@ -234,46 +884,1683 @@ func getRoomCommand(client bepb.YtbBackendClient) {
 	}
 }
 
-func main() {
-	kingpin.Version("0.1")
-	parsed := kingpin.MustParse(app.Parse(os.Args[1:]))
+func searchCommand(client bepb.YtbBackendClient) {
+	response, err := client.SearchCandidates(context.Background(), &bepb.SearchRequest{
+		Query:      *searchQuery,
+		MaxResults: *searchResults,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SearchCandidates: %v\n", err)
+		os.Exit(1)
+	}
 
-	conn, client := connectToRemote()
-	defer conn.Close()
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
 
-	switch parsed {
-	case now.FullCommand():
-		nowCommand(client)
+	for _, song := range response.Songs {
+		fmt.Printf("https://www.youtube.com/watch?v=%s [%s] %s\n", song.ServiceId,
+			song.Metadata.Duration, song.Title)
+	}
+}
 
-	case send.FullCommand():
-		sendCommand(client)
+func detailsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetSongDetails(context.Background(), &bepb.SongDetailsRequest{SongId: *detailsSongId})
+	if err != nil {
+		fmt.Printf("failed to call GetSongDetails: %v\n", err)
+		os.Exit(1)
+	}
 
-	case playlist.FullCommand():
-		playlistCommand(client)
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
 
-	case save.FullCommand():
-		saveCommand(client)
+	fmt.Printf("Title: %s\n", response.Song.Title)
+	fmt.Printf("Channel: %s\n", response.Channel)
+	fmt.Printf("Views: %d\n", response.ViewCount)
+	fmt.Printf("Description: %s\n", response.Description)
+	for _, thumbnail := range response.Thumbnails {
+		fmt.Printf("Thumbnail: { quality: %s, size: %dx%d, url: %s }\n",
+			thumbnail.Quality, thumbnail.Width, thumbnail.Height, thumbnail.Url)
+	}
+}
 
-	case pop.FullCommand():
-		popCommand(client)
+func songsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetSongs(context.Background(), &bepb.SongIdList{SongIds: *songsSongIds})
+	if err != nil {
+		fmt.Printf("failed to call GetSongs: %v\n", err)
+		os.Exit(1)
+	}
 
-	case login.FullCommand():
-		loginCommand(client)
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
 
-	case remove.FullCommand():
-		removeCommand(client)
+	for _, song := range response.Songs {
+		fmt.Printf("{ id: %d, title: %s, user: %s, votes: %d }\n", song.SongId, song.Title, song.Username,
+			song.Votes)
+	}
 
-	case next.FullCommand():
-		nextCommand(client)
+	for _, songId := range response.Missing {
+		fmt.Printf("Song %d does not exist\n", songId)
+	}
+}
 
-	case pause.FullCommand():
-		pauseCommand(client)
+func zonesCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListZones(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListZones: %v\n", err)
+		os.Exit(1)
+	}
 
-	case newRoom.FullCommand():
-		newRoomCommand(client)
+	for _, zone := range response.Zones {
+		nowPlaying := "nothing"
+		if zone.NowPlaying != nil && zone.NowPlaying.SongId != 0 {
+			nowPlaying = zone.NowPlaying.Title
+		}
 
-	case getRoom.FullCommand():
-		getRoomCommand(client)
+		fmt.Printf("{ id: %2d, name: %s, shared: %t, players: %d, quality: %v, playing: %s }\n",
+			zone.Id, zone.Name, zone.Shared, zone.Players, zone.Quality, nowPlaying)
+	}
+}
+
+func newZoneCommand(client bepb.YtbBackendClient) {
+	zone, err := client.CreateZone(context.Background(), &bepb.Zone{Name: *newZoneName, Shared: *newZoneShared})
+	if err != nil {
+		fmt.Printf("failed to call CreateZone: %v\n", err)
+		os.Exit(1)
+	}
+
+	if zone.Err.Success == false {
+		fmt.Println(zone.Err.Message)
+	} else {
+		fmt.Printf("Zone name: %s\n", zone.Name)
+		fmt.Printf("Zone id: %2d\n", zone.Id)
+	}
+}
+
+func rmZoneCommand(client bepb.YtbBackendClient) {
+	response, err := client.RemoveZone(context.Background(), &bepb.Zone{Id: *rmZoneId})
+	if err != nil {
+		fmt.Printf("failed to call RemoveZone: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func zonePlaylistCommand(client bepb.YtbBackendClient) {
+	playlist, err := client.GetZonePlaylist(context.Background(), &bepb.Zone{Id: *zonePlaylistId})
+	if err != nil {
+		fmt.Printf("failed to call GetZonePlaylist: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < len(playlist.Songs); i++ {
+		fmt.Printf("%3d. { id: %2d, user: %2d, title: %s }\n",
+			i+1, playlist.Songs[i].SongId, playlist.Songs[i].UserId, playlist.Songs[i].Title)
+	}
+}
+
+func statsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetStats(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call GetStats: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Total songs: %d\n", response.TotalSongs)
+	for _, source := range response.Sources {
+		fmt.Printf("%15s: %d\n", source.Source, source.Count)
+	}
+
+	for _, outcome := range response.Outcomes {
+		fmt.Printf("%15s: %d\n", outcome.Outcome, outcome.Count)
+	}
+
+	for _, highlight := range response.TopReactions {
+		fmt.Printf("Most %s song of the night: %s (%d)\n", highlight.Emoji, highlight.Song.Title, highlight.Count)
+	}
+}
+
+func historyCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetHistory(context.Background(), &bepb.HistoryRequest{
+		UserId: *historyUser,
+		Limit:  *historyLimit,
+	})
+	if err != nil {
+		fmt.Printf("failed to call GetHistory: %v\n", err)
+		os.Exit(1)
+	} else if !response.Err.Success {
+		fmt.Printf("Response: {success: %t, message: %s}\n", response.Err.Success, response.Err.Message)
+		os.Exit(1)
+	}
+
+	for _, entry := range response.Songs {
+		fmt.Printf("{ id: %d, user: %s, outcome: %s", entry.Song.SongId, entry.Song.Username, entry.Outcome)
+		if entry.SkippedBy != "" {
+			fmt.Printf(", skipped by: %s", entry.SkippedBy)
+		}
+		if len(entry.Voters) > 0 {
+			fmt.Printf(", voters: %v", entry.Voters)
+		}
+		fmt.Printf(", title: %s }\n", entry.Song.Title)
+	}
+}
+
+func skipsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetSkipReport(context.Background(), &bepb.User{UserId: *skipsUser})
+	if err != nil {
+		fmt.Printf("failed to call GetSkipReport: %v\n", err)
+		os.Exit(1)
+	} else if !response.Err.Success {
+		fmt.Printf("Response: {success: %t, message: %s}\n", response.Err.Success, response.Err.Message)
+		os.Exit(1)
+	}
+
+	for _, outcome := range response.Outcomes {
+		fmt.Printf("%15s: %d\n", outcome.Outcome, outcome.Count)
+	}
+
+	for _, skipper := range response.Skippers {
+		fmt.Printf("{ user: %d, name: %s, skips: %d }\n", skipper.UserId, skipper.Name, skipper.Skips)
+	}
+}
+
+func reactCommand(client bepb.YtbBackendClient) {
+	response, err := client.React(context.Background(), &bepb.Reaction{
+		UserId: *reactUser,
+		ZoneId: *reactZone,
+		Emoji:  *reactEmoji,
+	})
+	if err != nil {
+		fmt.Printf("failed to call React: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func exemptCommand(client bepb.YtbBackendClient) {
+	response, err := client.SetExemption(context.Background(), &bepb.Exemption{
+		UserId: *exemptUser,
+		Exempt: !*exemptRevoke,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SetExemption: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func exemptionsCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListExemptions(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListExemptions: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, exemption := range response.Exemptions {
+		fmt.Printf("{ id: %2d, user: %s }\n", exemption.UserId, exemption.Username)
+	}
+}
+
+func savePresetCommand(client bepb.YtbBackendClient) {
+	preset := &bepb.Preset{
+		Name:             *savePresetName,
+		MaxMinutes:       *savePresetMinutes,
+		SubmissionWindow: int64(savePresetWindow.Seconds()),
+		FallbackCode:     *savePresetFallback,
+		OpenAt:           *savePresetOpen,
+		CloseAt:          *savePresetClose,
+	}
+	if *savePresetFifo {
+		preset.Algorithm = bepb.QueueAlgorithm_Fifo
+	} else if *savePresetHybrid {
+		preset.Algorithm = bepb.QueueAlgorithm_Hybrid
+	}
+
+	response, err := client.SavePreset(context.Background(), preset)
+	if err != nil {
+		fmt.Printf("failed to call SavePreset: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func presetsCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListPresets(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListPresets: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, preset := range response.Presets {
+		fmt.Printf("{ name: %s, algorithm: %v, max minutes: %d, window: %v, fallback: %s, open: %s-%s }\n",
+			preset.Name, preset.Algorithm, preset.MaxMinutes, time.Duration(preset.SubmissionWindow)*time.Second,
+			preset.FallbackCode, preset.OpenAt, preset.CloseAt)
+	}
+}
+
+func applyPresetCommand(client bepb.YtbBackendClient) {
+	response, err := client.ApplyPreset(context.Background(), &bepb.PresetRequest{Name: *applyPresetName})
+	if err != nil {
+		fmt.Printf("failed to call ApplyPreset: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func activeCommand(client bepb.YtbBackendClient) {
+	response, err := client.ActiveUsers(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ActiveUsers: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, user := range response.Users {
+		fmt.Printf("{ id: %2d, user: %s, last seen: %s }\n", user.UserId, user.Username,
+			time.Unix(user.LastSeen, 0).Format(time.Kitchen))
+	}
+}
+
+func heartbeatCommand(client bepb.YtbBackendClient) {
+	response, err := client.Heartbeat(context.Background(), &bepb.User{UserId: *heartbeatUser})
+	if err != nil {
+		fmt.Printf("failed to call Heartbeat: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func voteSkipCommand(client bepb.YtbBackendClient) {
+	response, err := client.VoteSkip(context.Background(), &bepb.SkipVote{UserId: *voteSkipUser, ZoneId: *voteSkipZone})
+	if err != nil {
+		fmt.Printf("failed to call VoteSkip: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("{ votes: %d, needed: %d, skipped: %t }\n", response.Votes, response.Needed, response.Skipped)
+}
+
+func whoAmICommand(client bepb.YtbBackendClient) {
+	profile, err := client.WhoAmI(context.Background(), &bepb.User{UserId: *whoAmIUser})
+	if err != nil {
+		fmt.Printf("failed to call WhoAmI: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !profile.Err.Success {
+		fmt.Println(profile.Err.Message)
+		return
+	}
+
+	fmt.Printf("{ id: %d, user: %s, room: %d }\n", profile.User.UserId, profile.User.Username, profile.User.RoomId)
+	fmt.Printf("{ audio only: %t, start: %v, notify: %t }\n", profile.Preferences.AudioOnly,
+		profile.Preferences.StartBehavior, profile.Preferences.Notify)
+
+	for _, linked := range profile.Identities {
+		fmt.Printf("{ linked: %s, id: %s, since: %s }\n", linked.Provider, linked.ExternalId,
+			time.Unix(linked.LinkedAt, 0).Format(time.RFC1123))
+	}
+}
+
+func linkCommand(client bepb.YtbBackendClient) {
+	response, err := client.LinkIdentity(context.Background(), &bepb.ExternalIdentity{UserId: *linkUser,
+		Provider: *linkProvider, ExternalId: *linkExternalId})
+	if err != nil {
+		fmt.Printf("failed to call LinkIdentity: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func unlinkCommand(client bepb.YtbBackendClient) {
+	response, err := client.UnlinkIdentity(context.Background(), &bepb.ExternalIdentity{Provider: *unlinkProvider,
+		ExternalId: *unlinkExternalId})
+	if err != nil {
+		fmt.Printf("failed to call UnlinkIdentity: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func identityCommand(client bepb.YtbBackendClient) {
+	user, err := client.ResolveIdentity(context.Background(), &bepb.ExternalIdentity{Provider: *identityProvider,
+		ExternalId: *identityExternalId})
+	if err != nil {
+		fmt.Printf("failed to call ResolveIdentity: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !user.Err.Success {
+		fmt.Println(user.Err.Message)
+		return
+	}
+
+	fmt.Printf("{ id: %d, user: %s, room: %d }\n", user.UserId, user.Username, user.RoomId)
+}
+
+func prefsCommand(client bepb.YtbBackendClient) {
+	preferences := &bepb.Preferences{UserId: *prefsUser, AudioOnly: *prefsAudioOnly, Notify: *prefsNotify}
+	if *prefsFromBeginning {
+		preferences.StartBehavior = bepb.StartBehavior_StartFromBeginning
+	}
+
+	response, err := client.SetPreferences(context.Background(), preferences)
+	if err != nil {
+		fmt.Printf("failed to call SetPreferences: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func infoCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetServerInfo(context.Background(), &bepb.User{UserId: *infoUser})
+	if err != nil {
+		fmt.Printf("failed to call GetServerInfo: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	display := response.Display
+	fmt.Printf("{ room: %d, party: %s, color: %s, show submitter: %t, romanized titles: %t }\n", display.RoomId,
+		display.PartyName, display.ThemeColor, display.ShowSubmitter, display.RomanizedTitles)
+	if display.Banner != "" {
+		fmt.Printf("Banner: %s\n", display.Banner)
+	}
+
+	if response.AudioOnly {
+		fmt.Println("Audio only: players only play audio")
+	}
+
+	if decks := response.Decks; decks != nil {
+		fmt.Printf("Decks: { dj: %s, zone: %d, since: %s, suggestions: %t, suggested: %d, set aside: %d }\n",
+			decks.Username, decks.ZoneId, time.Unix(decks.Since, 0).Format(time.Kitchen), decks.Suggestions,
+			decks.Suggested, decks.SetAside)
+	}
+}
+
+func displayCommand(client bepb.YtbBackendClient) {
+	settings := &bepb.DisplaySettings{
+		RoomId:          *displayRoom,
+		PartyName:       *displayName,
+		ThemeColor:      *displayColor,
+		Banner:          *displayBanner,
+		ShowSubmitter:   !*displayHideSubmits,
+		RomanizedTitles: *displayRomanized,
+	}
+
+	response, err := client.SetDisplaySettings(context.Background(), settings)
+	if err != nil {
+		fmt.Printf("failed to call SetDisplaySettings: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func joinCodeCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetJoinCode(context.Background(), &bepb.JoinCodeRequest{
+		Room:    *joinCodeRoom,
+		Size:    *joinCodeSize,
+		BaseUrl: *joinCodeBaseUrl,
+	})
+	if err != nil {
+		fmt.Printf("failed to call GetJoinCode: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	if err = ioutil.WriteFile(*joinCodeOut, response.Png, 0644); err != nil {
+		fmt.Printf("failed to save the QR code: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved a QR code of %s to %s\n", response.Url, *joinCodeOut)
+}
+
+func duckCommand(client bepb.YtbBackendClient) {
+	response, err := client.Duck(context.Background(), &bepb.DuckRequest{
+		ZoneId:  *duckZone,
+		Volume:  *duckVolume,
+		Seconds: uint32(duckSeconds.Seconds()),
+	})
+	if err != nil {
+		fmt.Printf("failed to call Duck: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func unduckCommand(client bepb.YtbBackendClient) {
+	response, err := client.Unduck(context.Background(), &bepb.Zone{Id: *unduckZone})
+	if err != nil {
+		fmt.Printf("failed to call Unduck: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func qualityCommand(client bepb.YtbBackendClient) {
+	qualities := map[string]bepb.PlaybackQuality{
+		"default": bepb.PlaybackQuality_DefaultQuality,
+		"audio":   bepb.PlaybackQuality_AudioQuality,
+		"480p":    bepb.PlaybackQuality_Quality480p,
+		"1080p":   bepb.PlaybackQuality_Quality1080p,
+	}
+
+	response, err := client.SetPlaybackQuality(context.Background(), &bepb.QualityRequest{
+		ZoneId:  *qualityZone,
+		Quality: qualities[*qualityName],
+	})
+	if err != nil {
+		fmt.Printf("failed to call SetPlaybackQuality: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func tagCommand(client bepb.YtbBackendClient) {
+	response, err := client.TagSong(context.Background(), &bepb.SongTag{
+		SongId: *tagSongId,
+		UserId: *tagUser,
+		ZoneId: *tagZone,
+		Tag:    *tagName,
+	})
+	if err != nil {
+		fmt.Printf("failed to call TagSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func untagCommand(client bepb.YtbBackendClient) {
+	response, err := client.UntagSong(context.Background(), &bepb.SongTag{
+		SongId: *untagSongId,
+		UserId: *untagUser,
+		ZoneId: *untagZone,
+		Tag:    *untagName,
+	})
+	if err != nil {
+		fmt.Printf("failed to call UntagSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func taggedCommand(client bepb.YtbBackendClient) {
+	response, err := client.FindTagged(context.Background(), &bepb.TagSearch{Tag: *taggedName, Limit: *taggedLimit})
+	if err != nil {
+		fmt.Printf("failed to call FindTagged: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, song := range response.Songs {
+		fmt.Printf("{ id: %4d, title: %s, tags: %s }\n", song.Song.SongId, song.Song.Title,
+			strings.Join(song.Tags, ", "))
+	}
+}
+
+func queueTaggedCommand(client bepb.YtbBackendClient) {
+	response, err := client.QueueTagged(context.Background(), &bepb.TagRequest{
+		UserId: *queueTaggedUser,
+		ZoneId: *queueTaggedZone,
+		Tag:    *queueTaggedName,
+	})
+	if err != nil {
+		fmt.Printf("failed to call QueueTagged: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func djTagCommand(client bepb.YtbBackendClient) {
+	response, err := client.SetAutoDjTag(context.Background(), &bepb.TagSearch{Tag: *djTagName})
+	if err != nil {
+		fmt.Printf("failed to call SetAutoDjTag: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func mineCommand(client bepb.YtbBackendClient) {
+	queue, err := client.ListQueuedByUser(context.Background(), &bepb.UserQueueRequest{UserId: *mineUser, ZoneId: *mineZone})
+	if err != nil {
+		fmt.Printf("failed to call ListQueuedByUser: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !queue.Err.Success {
+		fmt.Println(queue.Err.Message)
+		return
+	}
+
+	for _, queued := range queue.Songs {
+		fmt.Printf("#%d { title: %s, id: %d, starts: %s }\n", queued.Position, queued.Song.Title,
+			queued.Song.SongId, time.Unix(queued.EstimatedStart, 0).Format(time.Kitchen))
+	}
+}
+
+func pendingCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListPendingSongs(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListPendingSongs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, pending := range response.Songs {
+		fmt.Printf("{ id: %2d, title: %s, user: %s, zone: %d, submitted: %s }\n", pending.Id, pending.Song.Title,
+			pending.Song.Username, pending.ZoneId, time.Unix(pending.Submitted, 0).Format(time.Kitchen))
+	}
+}
+
+func reviewCommand(client bepb.YtbBackendClient) {
+	response, err := client.ReviewSong(context.Background(), &bepb.SongReview{Id: *reviewId, Approve: !*reviewReject})
+	if err != nil {
+		fmt.Printf("failed to call ReviewSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func takeDecksCommand(client bepb.YtbBackendClient) {
+	response, err := client.TakeDecks(context.Background(), &bepb.DeckTakeover{
+		UserId:      *takeDecksUser,
+		ZoneId:      *takeDecksZone,
+		Suggestions: *takeDecksSuggests,
+	})
+	if err != nil {
+		fmt.Printf("failed to call TakeDecks: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func handBackCommand(client bepb.YtbBackendClient) {
+	response, err := client.HandBackDecks(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call HandBackDecks: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func suggestionsCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListSuggestions(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListSuggestions: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, suggested := range response.Songs {
+		fmt.Printf("{ id: %2d, title: %s, user: %s, zone: %d, submitted: %s }\n", suggested.Id, suggested.Song.Title,
+			suggested.Song.Username, suggested.ZoneId, time.Unix(suggested.Submitted, 0).Format(time.Kitchen))
+	}
+}
+
+func suggestionCommand(client bepb.YtbBackendClient) {
+	response, err := client.ReviewSuggestion(context.Background(),
+		&bepb.SongReview{Id: *suggestionId, Approve: !*suggestionReject})
+	if err != nil {
+		fmt.Printf("failed to call ReviewSuggestion: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func addJingleCommand(client bepb.YtbBackendClient) {
+	response, err := client.AddJingle(context.Background(), &bepb.Jingle{Name: *addJingleName, Link: *addJingleLink})
+	if err != nil {
+		fmt.Printf("failed to call AddJingle: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func removeJingleCommand(client bepb.YtbBackendClient) {
+	response, err := client.RemoveJingle(context.Background(), &bepb.Jingle{Name: *removeJingleName})
+	if err != nil {
+		fmt.Printf("failed to call RemoveJingle: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func jinglesCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListJingles(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListJingles: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, jingle := range response.Jingles {
+		fmt.Printf("{ name: %s, link: %s }\n", jingle.Name, jingle.Link)
+	}
+}
+
+/*
+ * Parse the time a song is scheduled at. A time of day alone is the next time
+ * the clock reads it.
+ */
+func parseScheduleTime(at string, now time.Time) (time.Time, error) {
+	if clock, err := time.ParseInLocation("15:04", at, now.Location()); err == nil {
+		playAt := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !playAt.After(now) {
+			playAt = playAt.AddDate(0, 0, 1)
+		}
+		return playAt, nil
+	}
+
+	return time.ParseInLocation("2006-01-02 15:04", at, now.Location())
+}
+
+func scheduleCommand(client bepb.YtbBackendClient) {
+	playAt, err := parseScheduleTime(*scheduleAt, time.Now())
+	if err != nil {
+		fmt.Printf("failed to parse the time %q: %v\n", *scheduleAt, err)
+		os.Exit(1)
+	}
+
+	response, err := client.ScheduleSong(context.Background(), &bepb.ScheduledSong{
+		Link:      *scheduleLink,
+		UserId:    *scheduleUser,
+		ZoneId:    *scheduleZone,
+		PlayAt:    playAt.Unix(),
+		Interrupt: *scheduleInterrupt,
+	})
+	if err != nil {
+		fmt.Printf("failed to call ScheduleSong: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("Scheduled %s as %d to play at %s\n", response.Song.Title, response.Id, playAt.Format(time.RFC1123))
+}
+
+func unscheduleCommand(client bepb.YtbBackendClient) {
+	response, err := client.CancelScheduled(context.Background(), &bepb.ScheduledSong{Id: *unscheduleId})
+	if err != nil {
+		fmt.Printf("failed to call CancelScheduled: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func scheduledCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListScheduled(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListScheduled: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, song := range response.Songs {
+		fmt.Printf("{ id: %d, at: %s, zone: %d, interrupt: %t, title: %s }\n", song.Id,
+			time.Unix(song.PlayAt, 0).Format(time.RFC1123), song.ZoneId, song.Interrupt, song.Song.GetTitle())
+	}
+}
+
+func registerPlayerCommand(client bepb.YtbBackendClient) {
+	response, err := client.RegisterPlayer(context.Background(), &bepb.RegisteredPlayer{
+		Name:         *registerPlayerName,
+		Zone:         *registerPlayerZone,
+		OutputDevice: *registerPlayerOutput,
+	})
+	if err != nil {
+		fmt.Printf("failed to call RegisterPlayer: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("Registered %s. Start its player with --player %s\n", response.Name, response.Token)
+}
+
+func unregisterPlayerCommand(client bepb.YtbBackendClient) {
+	response, err := client.UnregisterPlayer(context.Background(), &bepb.RegisteredPlayer{Name: *unregisterPlayerName})
+	if err != nil {
+		fmt.Printf("failed to call UnregisterPlayer: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func playersCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListRegisteredPlayers(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListRegisteredPlayers: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, player := range response.Players {
+		state := "disconnected"
+		if player.Connected {
+			state = fmt.Sprintf("connected as player %d", player.PlayerId)
+		}
+
+		lastSeen := "never"
+		if player.LastSeen > 0 {
+			lastSeen = time.Unix(player.LastSeen, 0).Format(time.Stamp)
+		}
+
+		fmt.Printf("{ name: %s, zone: %s, output: %s, %s, last seen: %s }\n", player.Name, player.Zone,
+			player.OutputDevice, state, lastSeen)
+	}
+}
+
+func createKeyCommand(client bepb.YtbBackendClient) {
+	scopes := map[string]bepb.ApiKeyScope{
+		"read":   bepb.ApiKeyScope_ReadOnlyKey,
+		"submit": bepb.ApiKeyScope_SubmitOnlyKey,
+		"kiosk":  bepb.ApiKeyScope_KioskKey,
+		"player": bepb.ApiKeyScope_PlayerKey,
+	}
+
+	response, err := client.CreateApiKey(context.Background(), &bepb.ApiKey{
+		Name:  *createKeyName,
+		Scope: scopes[*createKeyScope],
+	})
+	if err != nil {
+		fmt.Printf("failed to call CreateApiKey: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("Created %s. It won't be shown again: %s\n", response.Name, response.Key)
+}
+
+func revokeKeyCommand(client bepb.YtbBackendClient) {
+	response, err := client.RevokeApiKey(context.Background(), &bepb.ApiKey{Name: *revokeKeyName})
+	if err != nil {
+		fmt.Printf("failed to call RevokeApiKey: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func keysCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListApiKeys(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListApiKeys: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, key := range response.Keys {
+		fmt.Printf("{ name: %s, scope: %v, created: %s }\n", key.Name, key.Scope,
+			time.Unix(key.Created, 0).Format(time.Stamp))
+	}
+}
+
+func timeOutCommand(client bepb.YtbBackendClient) {
+	request := &bepb.TimeOut{UserId: *timeOutUser, Seconds: uint32(timeOutDuration.Seconds())}
+	response, err := client.TimeOutUser(context.Background(), request)
+	if err != nil {
+		fmt.Printf("failed to call TimeOutUser: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func endTimeOutCommand(client bepb.YtbBackendClient) {
+	response, err := client.EndTimeOut(context.Background(), &bepb.TimeOut{UserId: *endTimeOutUser})
+	if err != nil {
+		fmt.Printf("failed to call EndTimeOut: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func timeOutsCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListTimeOuts(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListTimeOuts: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, timeOut := range response.TimeOuts {
+		fmt.Printf("{ userId: %d, username: %s, until: %s }\n", timeOut.UserId, timeOut.Username,
+			time.Unix(timeOut.Until, 0).Format(time.Kitchen))
+	}
+}
+
+func fairnessCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetFairnessReport(context.Background(), &bepb.Zone{Id: *fairnessZone})
+	if err != nil {
+		fmt.Printf("failed to call GetFairnessReport: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	if response.Rotates {
+		fmt.Printf("Rotation round: %d\n", response.Round)
+	}
+
+	for _, user := range response.Users {
+		played := time.Duration(user.PlayedSeconds * float64(time.Second)).Round(time.Second)
+		fmt.Printf("{ userId: %d, username: %s, pending: %d, next: %d, played: %s, round: %d }\n", user.UserId,
+			user.Username, user.Pending, user.NextPosition, played, user.Round)
+	}
+}
+
+func playNextCommand(client bepb.YtbBackendClient) {
+	response, err := client.RequestPlayNext(context.Background(),
+		&bepb.PlayNext{UserId: *playNextUser, ZoneId: *playNextZone, SongId: *playNextSongId})
+	if err != nil {
+		fmt.Printf("failed to call RequestPlayNext: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("Asked to play song %d next. { needed: %d }\n", *playNextSongId, response.Needed)
+}
+
+func approveNextCommand(client bepb.YtbBackendClient) {
+	response, err := client.ApprovePlayNext(context.Background(),
+		&bepb.PlayNext{UserId: *approveNextUser, ZoneId: *approveNextZone, SongId: *approveNextSongId})
+	if err != nil {
+		fmt.Printf("failed to call ApprovePlayNext: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("{ approvals: %d, needed: %d, moved: %t }\n", response.Approvals, response.Needed, response.Moved)
+}
+
+func recapCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetPartyRecap(context.Background(), &bepb.RecapRequest{Id: *recapId})
+	if err != nil {
+		fmt.Printf("failed to call GetPartyRecap: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRecap(response)
+}
+
+func endPartyCommand(client bepb.YtbBackendClient) {
+	response, err := client.EndParty(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call EndParty: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRecap(response)
+}
+
+func printRecap(recap *bepb.PartyRecap) {
+	if !recap.Err.Success {
+		fmt.Println(recap.Err.Message)
+		return
+	}
+
+	fmt.Printf("Recap #%d: %s to %s\n", recap.Id, time.Unix(recap.StartedAt, 0).Format(time.Stamp),
+		time.Unix(recap.EndedAt, 0).Format(time.Stamp))
+	fmt.Printf("{songs: %d, hours: %.1f}\n", recap.TotalSongs, recap.Hours)
+	for _, count := range recap.TopSubmitters {
+		fmt.Printf("Top submitter: { user: %s, id: %d, songs: %d }\n", count.Username, count.UserId, count.Count)
+	}
+	for _, count := range recap.MostSkipped {
+		fmt.Printf("Most skipped: { user: %s, id: %d, skips: %d }\n", count.Username, count.UserId, count.Count)
+	}
+	fmt.Printf("First song: %s\nLast song: %s\n", recap.FirstSong.GetTitle(), recap.LastSong.GetTitle())
+}
+
+func positionCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetPlaybackPosition(context.Background(), &bepb.Zone{Id: *positionZone})
+	if err != nil {
+		fmt.Printf("failed to call GetPlaybackPosition: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success || response.SongId == 0 {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	fmt.Printf("{song id: %d, elapsed: %.1fs, duration: %.0fs, paused: %t, reported: %t}\n",
+		response.SongId, response.Elapsed, response.Duration, response.Paused, response.Reported)
+}
+
+func lyricsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetLyrics(context.Background(), &bepb.Zone{Id: *lyricsZone})
+	if err != nil {
+		fmt.Printf("failed to call GetLyrics: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	if *lyricsSynced && response.Synced != "" {
+		fmt.Println(response.Synced)
+	} else {
+		fmt.Println(response.Plain)
+	}
+	fmt.Printf("\n(lyrics from %s)\n", response.Provider)
+}
+
+func eventsCommand(client bepb.YtbBackendClient) {
+	stream, err := client.Events(context.Background(), &bepb.EventsRequest{ResumeAfter: *eventsResume})
+	if err != nil {
+		fmt.Printf("failed to call Events: %v\n", err)
+		os.Exit(1)
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			fmt.Printf("event stream ended: %v\n", err)
+			return
+		}
+
+		fmt.Printf("#%d ", event.Sequence)
+		switch event.Type {
+		case bepb.EventType_SongReaction:
+			fmt.Printf("%s: {zone: %d, user: %s, emoji: %s, title: %s}\n", event.Type, event.ZoneId,
+				event.Username, event.Emoji, event.Song.GetTitle())
+		case bepb.EventType_PlayerJoined, bepb.EventType_PlayerLeft:
+			fmt.Printf("%s: {zone: %d, player: %d}\n", event.Type, event.ZoneId, event.PlayerId)
+		case bepb.EventType_SongRemoved:
+			fmt.Printf("%s: {zone: %d, song: %d}\n", event.Type, event.ZoneId, event.Song.GetSongId())
+		case bepb.EventType_EventsMissed:
+			fmt.Printf("%s: the events since %d are no longer kept\n", event.Type, *eventsResume)
+		case bepb.EventType_PlayNextAsked:
+			fmt.Printf("%s: {zone: %d, user: %s, song: %d, title: %s}\n", event.Type, event.ZoneId,
+				event.Username, event.Song.GetSongId(), event.Song.GetTitle())
+		default:
+			fmt.Printf("%s: {zone: %d, title: %s}\n", event.Type, event.ZoneId, event.Song.GetTitle())
+		}
+	}
+}
+
+func maintainCommand(client bepb.YtbBackendClient) {
+	response, err := client.RunMaintenance(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call RunMaintenance: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s, pruned songs: %d}\n",
+		response.Err.Success, response.Err.Message, response.PrunedSongs)
+}
+
+func logLevelCommand(client bepb.YtbBackendClient) {
+	levels := map[string]bepb.LogLevel{
+		"info":  bepb.LogLevel_InfoLevel,
+		"debug": bepb.LogLevel_DebugLevel,
+	}
+
+	response, err := client.SetLogLevel(context.Background(), &bepb.LogLevelRequest{Level: levels[*logLevelName]})
+	if err != nil {
+		fmt.Printf("failed to call SetLogLevel: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.Success, response.Message)
+}
+
+func dumpCommand(client bepb.YtbBackendClient) {
+	report, err := client.DumpState(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call DumpState: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !report.Err.Success {
+		fmt.Println(report.Err.Message)
+		return
+	}
+
+	fmt.Printf("Log level: %v, uptime: %v, goroutines: %d, heap: %d bytes, event streams: %d, pending writes: %d\n",
+		report.LogLevel, time.Duration(report.UptimeSeconds)*time.Second, report.Goroutines, report.HeapBytes,
+		report.EventStreams, report.PendingWrites)
+
+	for _, zone := range report.Zones {
+		fmt.Printf("Zone %d (%s): { shared: %t, quality: %v, playing: %s }\n", zone.Zone.Id, zone.Zone.Name,
+			zone.Zone.Shared, zone.Zone.Quality, zone.Zone.NowPlaying.GetTitle())
+
+		if zone.Dispatched != nil {
+			fmt.Printf("  Waiting on a player to start: %s\n", zone.Dispatched.Title)
+		}
+
+		if stats := zone.QueueStats; stats != nil {
+			fmt.Printf("  Queue: { entries: %d, users: %d, tracked users: %d, capacity: %d }\n", stats.Entries,
+				len(stats.Buckets), stats.TrackedUsers, stats.Capacity)
+		}
+
+		for i, song := range zone.Queue {
+			fmt.Printf("  %2d. { id: %d, title: %s, user: %s }\n", i+1, song.SongId, song.Title, song.Username)
+		}
+
+		for _, player := range zone.Players {
+			fmt.Printf("  Player %d: { name: %s, device: %s, ready: %t, volume: %t }\n", player.PlayerId,
+				player.RegisteredName, player.OutputDevice, player.Ready, player.SupportsVolume)
+		}
+	}
+
+	for _, cache := range report.Caches {
+		fmt.Printf("Cache %s: { entries: %d, bytes: %d }\n", cache.Name, cache.Entries, cache.Bytes)
+	}
+}
+
+func clientsCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListClients(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		fmt.Printf("failed to call ListClients: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !response.Err.Success {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	now := time.Now()
+	for _, connected := range response.Clients {
+		fmt.Printf("{ id: %d, kind: %v, peer: %s, identity: %s, agent: %s, connected: %v ago, active: %v ago",
+			connected.Id, connected.Kind, connected.Peer, connected.Identity, connected.UserAgent,
+			now.Sub(time.Unix(connected.ConnectedAt, 0)).Round(time.Second),
+			now.Sub(time.Unix(connected.LastActive, 0)).Round(time.Second))
+		if connected.Kind == bepb.ClientKind_PlayerClient {
+			fmt.Printf(", zone: %d, player: %d", connected.ZoneId, connected.PlayerId)
+		}
+		fmt.Println(" }")
+	}
+}
+
+func shareCommand(client bepb.YtbBackendClient) {
+	response, err := client.SharePlaylist(context.Background(), &bepb.ShareRequest{
+		UserId:        *shareUser,
+		OnlyUserSongs: *shareUserSongs,
+		ZoneId:        *shareZone,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SharePlaylist: %v\n", err)
+		os.Exit(1)
+	}
+
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+	} else {
+		fmt.Printf("Share code: %s (%d songs)\n", response.Code, response.SongCount)
+	}
+}
+
+func importCommand(client bepb.YtbBackendClient) {
+	response, err := client.ImportShared(context.Background(), &bepb.ImportRequest{
+		Code:   *importArg,
+		UserId: *importUser,
+		ZoneId: *importZone,
+	})
+	if err != nil {
+		fmt.Printf("failed to call ImportShared: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func devicesCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListOutputDevices(context.Background(), &bepb.Zone{Id: *devicesZone})
+	if err != nil {
+		fmt.Printf("failed to call ListOutputDevices: %v\n", err)
+		os.Exit(1)
+	}
+
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, player := range response.Players {
+		fmt.Printf("Player %d:\n", player.PlayerId)
+		for _, device := range player.Devices {
+			current := " "
+			if device.Name == player.OutputDevice {
+				current = "*"
+			}
+			fmt.Printf("  %s %s (%s)\n", current, device.Name, device.Description)
+		}
+	}
+}
+
+func setDeviceCommand(client bepb.YtbBackendClient) {
+	response, err := client.SetOutputDevice(context.Background(), &bepb.OutputDeviceRequest{
+		ZoneId:   *setDeviceZone,
+		PlayerId: *setDevicePlayer,
+		Device:   *setDeviceName,
+	})
+	if err != nil {
+		fmt.Printf("failed to call SetOutputDevice: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func bluetoothCommand(client bepb.YtbBackendClient) {
+	response, err := client.ListBluetoothDevices(context.Background(), &bepb.Zone{Id: *bluetoothZone})
+	if err != nil {
+		fmt.Printf("failed to call ListBluetoothDevices: %v\n", err)
+		os.Exit(1)
+	}
+
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, player := range response.Players {
+		fmt.Printf("Player %d:\n", player.PlayerId)
+		if player.LastError != "" {
+			fmt.Printf("  last error: %s\n", player.LastError)
+		}
+
+		for _, device := range player.Devices {
+			fmt.Printf("  %s %s {paired: %t, connected: %t}\n", device.Address, device.Name,
+				device.Paired, device.Connected)
+		}
+	}
+}
+
+func btScanCommand(client bepb.YtbBackendClient) {
+	response, err := client.ScanBluetooth(context.Background(), &bepb.BluetoothRequest{
+		ZoneId:   *btScanZone,
+		PlayerId: *btScanPlayer,
+	})
+	if err != nil {
+		fmt.Printf("failed to call ScanBluetooth: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func btPairCommand(client bepb.YtbBackendClient) {
+	response, err := client.PairBluetooth(context.Background(), &bepb.BluetoothRequest{
+		ZoneId:   *btPairZone,
+		PlayerId: *btPairPlayer,
+		Address:  *btPairAddress,
+	})
+	if err != nil {
+		fmt.Printf("failed to call PairBluetooth: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func btConnectCommand(client bepb.YtbBackendClient) {
+	response, err := client.ConnectBluetooth(context.Background(), &bepb.BluetoothRequest{
+		ZoneId:   *btConnectZone,
+		PlayerId: *btConnectPlayer,
+		Address:  *btConnectAddress,
+	})
+	if err != nil {
+		fmt.Printf("failed to call ConnectBluetooth: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Response: {success: %t, message: %s}\n", response.GetSuccess(), response.GetMessage())
+}
+
+func achievementsCommand(client bepb.YtbBackendClient) {
+	response, err := client.GetAchievements(context.Background(), &bepb.AchievementRequest{UserId: *achievementsUser})
+	if err != nil {
+		fmt.Printf("failed to call GetAchievements: %v\n", err)
+		os.Exit(1)
+	}
+
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for _, achievement := range response.Achievements {
+		fmt.Printf("%s: %s (earned %s)\n", achievement.Name, achievement.Description, achievement.EarnDate)
+	}
+}
+
+func leaderboardCommand(client bepb.YtbBackendClient) {
+	response, err := client.Leaderboard(context.Background(), &bepb.LeaderboardRequest{Limit: *leaderboardLimit})
+	if err != nil {
+		fmt.Printf("failed to call Leaderboard: %v\n", err)
+		os.Exit(1)
+	}
+
+	if response.Err.Success == false {
+		fmt.Println(response.Err.Message)
+		return
+	}
+
+	for rank, entry := range response.Entries {
+		fmt.Printf("%2d. %s {songs: %d, skips: %d, achievements: %d}\n", rank+1, entry.Username,
+			entry.SongCount, entry.SkipCount, entry.AchievementCount)
+	}
+}
+
+func main() {
+	kingpin.Version("0.1")
+	parsed := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	conn, client := connectToRemote()
+	defer conn.Close()
+
+	switch parsed {
+	case now.FullCommand():
+		nowCommand(client)
+
+	case send.FullCommand():
+		sendCommand(client)
+
+	case kiosk.FullCommand():
+		kioskCommand(client)
+
+	case chapters.FullCommand():
+		chaptersCommand(client)
+
+	case playlist.FullCommand():
+		playlistCommand(client)
+
+	case save.FullCommand():
+		saveCommand(client)
+
+	case restore.FullCommand():
+		restoreCommand(client)
+
+	case migrate.FullCommand():
+		migrateCommand(client)
+
+	case compare.FullCommand():
+		compareCommand(client)
+
+	case pop.FullCommand():
+		popCommand(client)
+
+	case confirm.FullCommand():
+		confirmCommand(client)
+
+	case login.FullCommand():
+		loginCommand(client)
+
+	case linkDevice.FullCommand():
+		linkDeviceCommand(client)
+
+	case remove.FullCommand():
+		removeCommand(client)
+
+	case removeAll.FullCommand():
+		removeAllCommand(client)
+
+	case unblock.FullCommand():
+		unblockCommand(client)
+
+	case next.FullCommand():
+		nextCommand(client)
+
+	case previous.FullCommand():
+		previousCommand(client)
+
+	case pause.FullCommand():
+		pauseCommand(client)
+
+	case newRoom.FullCommand():
+		newRoomCommand(client)
+
+	case getRoom.FullCommand():
+		getRoomCommand(client)
+
+	case achievements.FullCommand():
+		achievementsCommand(client)
+
+	case leaderboard.FullCommand():
+		leaderboardCommand(client)
+
+	case search.FullCommand():
+		searchCommand(client)
+
+	case details.FullCommand():
+		detailsCommand(client)
+
+	case songs.FullCommand():
+		songsCommand(client)
+
+	case stats.FullCommand():
+		statsCommand(client)
+	case history.FullCommand():
+		historyCommand(client)
+	case skips.FullCommand():
+		skipsCommand(client)
+
+	case maintain.FullCommand():
+		maintainCommand(client)
+
+	case logLevel.FullCommand():
+		logLevelCommand(client)
+
+	case dump.FullCommand():
+		dumpCommand(client)
+
+	case clients.FullCommand():
+		clientsCommand(client)
+
+	case share.FullCommand():
+		shareCommand(client)
+
+	case importCode.FullCommand():
+		importCommand(client)
+
+	case devices.FullCommand():
+		devicesCommand(client)
+
+	case setDevice.FullCommand():
+		setDeviceCommand(client)
+
+	case bluetooth.FullCommand():
+		bluetoothCommand(client)
+
+	case btScan.FullCommand():
+		btScanCommand(client)
+
+	case btPair.FullCommand():
+		btPairCommand(client)
+
+	case btConnect.FullCommand():
+		btConnectCommand(client)
+
+	case zones.FullCommand():
+		zonesCommand(client)
+
+	case newZone.FullCommand():
+		newZoneCommand(client)
+
+	case rmZone.FullCommand():
+		rmZoneCommand(client)
+
+	case zonePlaylist.FullCommand():
+		zonePlaylistCommand(client)
+
+	case react.FullCommand():
+		reactCommand(client)
+
+	case events.FullCommand():
+		eventsCommand(client)
+
+	case position.FullCommand():
+		positionCommand(client)
+
+	case lyrics.FullCommand():
+		lyricsCommand(client)
+
+	case exempt.FullCommand():
+		exemptCommand(client)
+
+	case exemptions.FullCommand():
+		exemptionsCommand(client)
+
+	case savePreset.FullCommand():
+		savePresetCommand(client)
+
+	case presets.FullCommand():
+		presetsCommand(client)
+
+	case applyPreset.FullCommand():
+		applyPresetCommand(client)
+
+	case active.FullCommand():
+		activeCommand(client)
+
+	case heartbeat.FullCommand():
+		heartbeatCommand(client)
+
+	case voteSkip.FullCommand():
+		voteSkipCommand(client)
+
+	case whoAmI.FullCommand():
+		whoAmICommand(client)
+
+	case linkAccount.FullCommand():
+		linkCommand(client)
+
+	case unlinkAccount.FullCommand():
+		unlinkCommand(client)
+
+	case identity.FullCommand():
+		identityCommand(client)
+
+	case prefs.FullCommand():
+		prefsCommand(client)
+
+	case info.FullCommand():
+		infoCommand(client)
+
+	case display.FullCommand():
+		displayCommand(client)
+
+	case joinCode.FullCommand():
+		joinCodeCommand(client)
+
+	case mine.FullCommand():
+		mineCommand(client)
+
+	case duck.FullCommand():
+		duckCommand(client)
+
+	case unduck.FullCommand():
+		unduckCommand(client)
+
+	case quality.FullCommand():
+		qualityCommand(client)
+
+	case tag.FullCommand():
+		tagCommand(client)
+
+	case untag.FullCommand():
+		untagCommand(client)
+
+	case tagged.FullCommand():
+		taggedCommand(client)
+
+	case queueTagged.FullCommand():
+		queueTaggedCommand(client)
+
+	case djTag.FullCommand():
+		djTagCommand(client)
+
+	case recap.FullCommand():
+		recapCommand(client)
+
+	case endParty.FullCommand():
+		endPartyCommand(client)
+
+	case pending.FullCommand():
+		pendingCommand(client)
+
+	case review.FullCommand():
+		reviewCommand(client)
+
+	case takeDecks.FullCommand():
+		takeDecksCommand(client)
+
+	case handBack.FullCommand():
+		handBackCommand(client)
+
+	case suggestions.FullCommand():
+		suggestionsCommand(client)
+
+	case suggestion.FullCommand():
+		suggestionCommand(client)
+
+	case addJingle.FullCommand():
+		addJingleCommand(client)
+
+	case removeJingle.FullCommand():
+		removeJingleCommand(client)
+
+	case jingles.FullCommand():
+		jinglesCommand(client)
+
+	case schedule.FullCommand():
+		scheduleCommand(client)
+
+	case unschedule.FullCommand():
+		unscheduleCommand(client)
+
+	case scheduled.FullCommand():
+		scheduledCommand(client)
+
+	case registerPlayer.FullCommand():
+		registerPlayerCommand(client)
+
+	case unregisterPlayer.FullCommand():
+		unregisterPlayerCommand(client)
+
+	case players.FullCommand():
+		playersCommand(client)
+
+	case createKey.FullCommand():
+		createKeyCommand(client)
+
+	case revokeKey.FullCommand():
+		revokeKeyCommand(client)
+
+	case keys.FullCommand():
+		keysCommand(client)
+
+	case timeOut.FullCommand():
+		timeOutCommand(client)
+
+	case endTimeOut.FullCommand():
+		endTimeOutCommand(client)
+
+	case timeOuts.FullCommand():
+		timeOutsCommand(client)
+
+	case fairness.FullCommand():
+		fairnessCommand(client)
+	case playNext.FullCommand():
+		playNextCommand(client)
+	case approveNext.FullCommand():
+		approveNextCommand(client)
 
 	default:
 		nowCommand(client)
@@ -5,15 +5,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/nguyenmq/ytbox-go/backend"
 	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 )
 
 /*
@@ -23,9 +27,81 @@ var (
 	app       = kingpin.New(backend.LogPrefix, "yt_box backend server")
 	all       = app.Flag("all", "Listen on all interfaces. Only listens on localhost by default.").Short('a').Bool()
 	port      = app.Flag("port", "Port to listen on").Default("9009").Short('p').String()
-	loadFile  = app.Flag("load", "Load a serialized protobuf playlist from a file").Short('l').ExistingFile()
+	loadFile  = app.Flag("load", "Load a serialized protobuf playlist from a file, or from the --snapshots bucket if set").Short('l').String()
 	dbFile    = app.Flag("database", "Path to database").Default("./ytbox.db").Short('d').String()
+	readDb    = app.Flag("readReplica", "Read statistics and history from this read-only copy of the database. Pass the --database path for a second connection.").String()
 	ytApiFile = app.Flag("apiKey", "Path to file containing YouTube api key").Default("./yt_api.key").String()
+	cacheDir  = app.Flag("cache", "Directory to pre-download upcoming songs into. Disabled if not set.").String()
+	cacheSize = app.Flag("cacheSize", "Maximum size of the song cache in megabytes").Default("1024").Int64()
+	retention = app.Flag("retention", "How long to keep song history, e.g. 8760h. Kept forever if not set.").Duration()
+	maintain  = app.Flag("maintenance", "Time between database maintenance runs. Disabled if zero.").Default("24h").Duration()
+	drain     = app.Flag("drain", "How long to wait for connections to close when stopping").Default("10s").Duration()
+	window    = app.Flag("window", "Only accept songs expected to start within this long, e.g. 2h. Disabled if not set.").Duration()
+	artistGap = app.Flag("artistGap", "Play songs by the same artist at least this long apart, e.g. 30m. Disabled if not set.").Duration()
+	dedup     = app.Flag("dedupWindow", "Count songs submitted again within this long as votes for the first submission, e.g. 10m. Disabled if not set.").Duration()
+	boarding  = app.Flag("boarding", "Let users queue one song each for this long after the party starts, e.g. 20m. Disabled if not set.").Duration()
+	kiosks    = app.Flag("kioskSongs", "Songs each kiosk can queue an hour. Unlimited if not set.").Uint32()
+	rawTitles = app.Flag("rawTitles", "Show and dedup songs by their titles as uploaded instead of cleaned up").Bool()
+	region    = app.Flag("region", "Two letter code of the region the players are in, to catch region blocked videos").String()
+	flagRestr = app.Flag("flagRestricted", "Queue age restricted and region blocked videos with a warning instead of rejecting them").Bool()
+	anonymous = app.Flag("allowAnonymous", "Let users submit songs shown as submitted by \"Anonymous\"").Bool()
+	password  = app.Flag("partyPassword", "Password users must give to log in. Anyone who can reach the port can log in if not set.").Envar("YTBOX_PARTY_PASSWORD").String()
+	lyrics    = app.Flag("lyrics", "Fetch lyrics of the now playing song from this provider. Disabled if not set.").Enum("lrclib", "lyricsovh")
+	fetchers  = app.Flag("fetcher", "Fetch links to a service with another fetcher, e.g. youtube=ytdlp. Services are youtube, local and web.").StringMap()
+	fetchTime = app.Flag("fetchTimeout", "How long each call to YouTube or yt-dlp may take before it's retried").Default("10s").Duration()
+	retries   = app.Flag("fetchRetries", "Times a failed call to YouTube or yt-dlp is retried, with backoff").Default("2").Int()
+	tripAfter = app.Flag("breakerAfter", "Failures in a row after which calls to a service fail fast").Default("5").Int()
+	cooldown  = app.Flag("breakerCooldown", "How long calls to a failing service fail fast before it's tried again").Default("30s").Duration()
+	inactive  = app.Flag("inactiveAfter", "Users who haven't submitted, voted or checked in for this long are inactive").Default("15m").Duration()
+	tokens    = app.Flag("token", "Token granting a role to clients that send it, e.g. admin=s3cret. Roles are user, player and admin.").StringMap()
+	policy    = app.Flag("policy", "Role required to call an rpc in place of the default, e.g. NextSong=anonymous").StringMap()
+	skipShare = app.Flag("skipShare", "Share of the active users whose votes skip a song").Default("0.5").Float64()
+	nextShare = app.Flag("playNextShare", "Share of the other active users whose approvals move a song to play next").Default("0.5").Float64()
+	recapHook = app.Flag("recapWebhook", "Post a recap of each party to this webhook, e.g. a Discord channel's").String()
+	joinUrl   = app.Flag("joinUrl", "Address guests reach the web frontend at, for join QR codes, e.g. http://ytbox.local:8080").String()
+	userSongs = app.Flag("userSongs", "Songs each user may have queued at a time. Unlimited if not set.").Uint32()
+	double    = app.Flag("doubleAfter", "Songs at least this long count as two against --userSongs, e.g. 5m. Disabled if not set.").Duration()
+	approval  = app.Flag("approvalAfter", "Songs at least this long wait for an admin to approve them, e.g. 10m. Disabled if not set.").Duration()
+	jingleN   = app.Flag("jingleEvery", "Play a jingle after this many songs. Disabled if not set.").Uint32()
+	jingleHr  = app.Flag("jingleOnHour", "Play a jingle once each hour strikes").Bool()
+	crossfade = app.Flag("crossfade", "Tell players to fade songs out and the next ones in over this long, e.g. 5s. Disabled if not set.").Duration()
+	audioOnly = app.Flag("audioOnly", "Have every player play audio only, whatever quality an admin sets, and warn about songs whose video is the point").Bool()
+	quality   = app.Flag("quality", "Quality players play songs at until an admin sets another: default, audio, 480p or 1080p").Default("default").Enum("default", "audio", "480p", "1080p")
+	logLevel  = app.Flag("logLevel", "How much to log: info, or debug to also log every rpc call and player command").Default("info").Enum("info", "debug")
+	metrics   = app.Flag("metricsAddr", "Serve Prometheus metrics on this address, e.g. :9100. Not served if not set.").String()
+	demo      = app.Flag("demo", "Fill the database with sample users, history and a queue to try things out").Bool()
+	clearDemo = app.Flag("clearDemo", "Remove the sample data added by --demo").Bool()
+	check     = app.Flag("check", "Check the config, database, snapshots and api key, print a report and exit without starting").Bool()
+	backup    = app.Flag("backup", "Back up the database, snapshots and config to this archive and exit without starting").String()
+	restore   = app.Flag("restore", "Restore a --backup archive to --database and the snapshot store, print a report and exit without starting").String()
+
+	keepalive        = app.Flag("keepalive", "Idle time before pinging a client").Default("30s").Duration()
+	keepaliveTimeout = app.Flag("keepaliveTimeout", "How long to wait for a ping response").Default("10s").Duration()
+	keepaliveMinTime = app.Flag("keepaliveMinTime", "Shortest ping interval allowed from clients").Default("10s").Duration()
+	maxConnIdle      = app.Flag("maxConnIdle", "Close connections idle this long. Never closed if not set.").Duration()
+	maxConnAge       = app.Flag("maxConnAge", "Close connections this old. Never closed if not set.").Duration()
+	maxConnAgeGrace  = app.Flag("maxConnAgeGrace", "Time given to rpcs on a connection closed for age").Default("30s").Duration()
+	maxMsgSize       = app.Flag("maxMsgSize", "Largest message sent or received in megabytes").Default("4").Int()
+
+	queue          = app.Flag("queue", "How songs are ordered: roundRobin, fifo, or hybrid to take turns but let votes move songs ahead").Default("roundRobin").Enum("roundRobin", "fifo", "hybrid")
+	rotationWeight = app.Flag("rotationVoteWeight", "Rounds each vote moves a song ahead of other users' songs in a hybrid queue").Default("0.25").Float64()
+	bucketWeight   = app.Flag("bucketVoteWeight", "Places each vote moves a song ahead of its submitter's other songs in a hybrid queue").Default("1").Float64()
+
+	autoDj            = app.Flag("autoDj", "Play songs from the history when the queue runs dry").Bool()
+	autoDjAvoid       = app.Flag("autoDjAvoid", "Don't let the auto dj pick songs played within this long ago").Default("4h").Duration()
+	autoDjSameChannel = app.Flag("autoDjSameChannel", "Let the auto dj pick back to back songs from the same channel").Bool()
+
+	snapshots   = app.Flag("snapshots", "Save playlists and snapshots to an S3 bucket instead of local files, e.g. s3://bucket/prefix").String()
+	s3Endpoint  = app.Flag("s3Endpoint", "Address of the S3 api, e.g. http://nas:9000 for MinIO. Defaults to AWS's.").String()
+	s3Region    = app.Flag("s3Region", "Region of the --snapshots bucket").Default("us-east-1").String()
+	s3AccessKey = app.Flag("s3AccessKey", "Access key id for the --snapshots bucket").Envar("AWS_ACCESS_KEY_ID").String()
+	s3SecretKey = app.Flag("s3SecretKey", "Secret access key for the --snapshots bucket").Envar("AWS_SECRET_ACCESS_KEY").String()
+	crashFile   = app.Flag("crashSnapshot", "Save a snapshot here on SIGUSR1, before shutting down and when an rpc panics. Empty saves none.").Default("/tmp/ytbox.snapshot").String()
+
+	federationName  = app.Flag("name", "Name of this backend when federating. Defaults to the host name.").String()
+	federate        = app.Flag("federate", "Experimental: address of another backend to follow").String()
+	federationToken = app.Flag("federateToken", "Access token to send to the backend being followed").String()
+	federationMode  = app.Flag("federationMode", "Forward songs to the other backend or also mirror what it plays").Default("forward").Enum("forward", "mirror")
 )
 
 func main() {
@@ -34,31 +110,220 @@ func main() {
 
 	logFile := common.InitLogger(backend.LogPrefix, true)
 	defer logFile.Close()
+	common.SetLogLevel(common.LogLevels[*logLevel])
 
 	addr := "127.0.0.1"
 	if *all {
 		addr = "0.0.0.0"
 	}
 
-	ytApiKey, err := ioutil.ReadFile(*ytApiFile)
-	if err != nil {
-		log.Printf("Could not read api key file at %s with error: %s\n", *ytApiFile, err.Error())
+	// a missing key is reported with the other problems when checking
+	ytApiKey, keyErr := ioutil.ReadFile(*ytApiFile)
+	if keyErr != nil && !*check && *backup == "" && *restore == "" {
+		log.Printf("Could not read api key file at %s with error: %s\n", *ytApiFile, keyErr.Error())
 		os.Exit(1)
 	}
 
-	ytbServer := backend.NewServer(addr+":"+*port, *loadFile, *dbFile, string(ytApiKey))
+	config := &backend.ServerConfig{
+		Addr:      addr + ":" + *port,
+		LoadFile:  *loadFile,
+		DbPath:    *dbFile,
+		YtApiKey:  string(ytApiKey),
+		CacheDir:  *cacheDir,
+		CacheSize: *cacheSize * 1024 * 1024,
+
+		Retention:           *retention,
+		MaintenanceInterval: *maintain,
+		ReadReplica:         *readDb,
+		DrainTimeout:        *drain,
+		SubmissionWindow:    *window,
+		ArtistGap:           *artistGap,
+		BoardingWindow:      *boarding,
+		KioskSongs:          *kiosks,
+		DedupWindow:         *dedup,
+		RawTitles:           *rawTitles,
+		Region:              *region,
+		FlagRestricted:      *flagRestr,
+		AllowAnonymous:      *anonymous,
+		PartyPassword:       *password,
+		JoinUrl:             *joinUrl,
+		Demo:                *demo,
+		ClearDemo:           *clearDemo,
+		Lyrics:              *lyrics,
+		Fetchers:            *fetchers,
+		FetchTimeout:        *fetchTime,
+		FetchRetries:        *retries,
+		BreakerAfter:        *tripAfter,
+		BreakerCooldown:     *cooldown,
+		InactiveAfter:       *inactive,
+		SkipVoteShare:       *skipShare,
+		PlayNextShare:       *nextShare,
+		RecapWebhook:        *recapHook,
+		UserSongs:           *userSongs,
+		DoubleAfter:         *double,
+		ApprovalAfter:       *approval,
+		JingleEvery:         *jingleN,
+		JingleOnHour:        *jingleHr,
+		Crossfade:           *crossfade,
+		Quality:             backend.PlaybackQualities[*quality],
+		AudioOnly:           *audioOnly,
+		MetricsAddr:         *metrics,
+		SnapshotStore:       *snapshots,
+		S3Endpoint:          *s3Endpoint,
+		S3Region:            *s3Region,
+		S3AccessKey:         *s3AccessKey,
+		S3SecretKey:         *s3SecretKey,
+		CrashSnapshot:       *crashFile,
+		Tokens:              *tokens,
+		Policy:              *policy,
+
+		Queue:              parseQueueAlgorithm(*queue),
+		RotationVoteWeight: *rotationWeight,
+		BucketVoteWeight:   *bucketWeight,
 
+		AutoDj:                 *autoDj,
+		AutoDjAvoidRecent:      *autoDjAvoid,
+		AutoDjAllowSameChannel: *autoDjSameChannel,
+
+		FederationName:  *federationName,
+		FederationPeer:  *federate,
+		FederationMode:  parseFederationMode(*federationMode),
+		FederationToken: *federationToken,
+
+		KeepaliveTime:         *keepalive,
+		KeepaliveTimeout:      *keepaliveTimeout,
+		KeepaliveMinTime:      *keepaliveMinTime,
+		MaxConnectionIdle:     *maxConnIdle,
+		MaxConnectionAge:      *maxConnAge,
+		MaxConnectionAgeGrace: *maxConnAgeGrace,
+		MaxRecvMsgSize:        *maxMsgSize * 1024 * 1024,
+		MaxSendMsgSize:        *maxMsgSize * 1024 * 1024,
+	}
+
+	if *check {
+		os.Exit(checkConfig(config, keyErr))
+	}
+
+	if *backup != "" {
+		os.Exit(backupServer(config, *backup))
+	}
+
+	if *restore != "" {
+		os.Exit(restoreServer(config, *restore))
+	}
+
+	ytbServer := backend.NewServer(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		stop := make(chan os.Signal)
-		signal.Notify(stop, os.Interrupt)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				ytbServer.SaveCrashSnapshot("asked to by SIGUSR1")
+				continue
+			}
 
-		select {
-		case <-stop:
-			ytbServer.Stop()
+			ytbServer.SaveCrashSnapshot(fmt.Sprintf("shutting down on %v", sig))
+			cancel()
+			return
 		}
 	}()
 
 	log.Println("Server started")
-	ytbServer.Serve()
+	if err := ytbServer.Run(ctx); err != nil {
+		log.Fatalf("Server failed with error: %v", err)
+	}
 	log.Println("Server stopped")
 }
+
+/*
+ * Print a report of checking the config. Returns the exit status, which is
+ * non-zero if any check failed.
+ */
+func checkConfig(config *backend.ServerConfig, keyErr error) int {
+	results := backend.CheckConfig(config)
+	if keyErr != nil {
+		keyResult := backend.CheckResult{Name: "YouTube api key file", Detail: *ytApiFile, Err: keyErr}
+		results = append([]backend.CheckResult{keyResult}, results...)
+	}
+
+	return printReport(results)
+}
+
+/*
+ * Back up the server to an archive. Returns the exit status.
+ */
+func backupServer(config *backend.ServerConfig, archive string) int {
+	if err := backend.Backup(config, archive); err != nil {
+		fmt.Printf("Failed to back up to %s: %v\n", archive, err)
+		return 1
+	}
+
+	fmt.Printf("Backed up to %s\n", archive)
+	return 0
+}
+
+/*
+ * Restore the server from an archive and print a report of what was
+ * restored. Returns the exit status, which is non-zero if restoring or any
+ * check failed.
+ */
+func restoreServer(config *backend.ServerConfig, archive string) int {
+	results, err := backend.Restore(config, archive)
+	if err != nil {
+		fmt.Printf("Failed to restore %s: %v\n", archive, err)
+		return 1
+	}
+
+	return printReport(results)
+}
+
+/*
+ * Print the result of each check. Returns the exit status, which is non-zero
+ * if any check failed.
+ */
+func printReport(results []backend.CheckResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", result.Name, result.Err)
+		} else if result.Detail != "" {
+			fmt.Printf("ok    %s: %s\n", result.Name, result.Detail)
+		} else {
+			fmt.Printf("ok    %s\n", result.Name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(results))
+		return 1
+	}
+
+	fmt.Printf("All %d checks passed\n", len(results))
+	return 0
+}
+
+/*
+ * Convert the queue flag into its protobuf value
+ */
+func parseQueueAlgorithm(queue string) bepb.QueueAlgorithm {
+	switch queue {
+	case "fifo":
+		return bepb.QueueAlgorithm_Fifo
+	case "hybrid":
+		return bepb.QueueAlgorithm_Hybrid
+	}
+	return bepb.QueueAlgorithm_RoundRobin
+}
+
+/*
+ * Convert the federation mode flag into its protobuf value
+ */
+func parseFederationMode(mode string) bepb.FederationMode {
+	if mode == "mirror" {
+		return bepb.FederationMode_MirrorNowPlaying
+	}
+	return bepb.FederationMode_ForwardQueue
+}
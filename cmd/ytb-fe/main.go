@@ -26,6 +26,9 @@ var (
 	hashFile  = app.Flag("hash", "File containing hash key").Default("hash.key").String()
 	blockFile = app.Flag("block", "File containing block key").Default("block.key").String()
 	debug     = app.Flag("debug", "Enable debug mode.").Short('d').Bool()
+	token     = app.Flag("token", "Access token to send to the backend").String()
+	password  = app.Flag("partyPassword", "Party password to send to the backend, if the host set one").Envar("YTBOX_PARTY_PASSWORD").String()
+	publicUrl = app.Flag("publicUrl", "Address guests reach this frontend at, for join QR codes, e.g. http://ytbox.local:8080. The backend's --joinUrl wins if set.").String()
 )
 
 func main() {
@@ -52,7 +55,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	server := frontend.NewServer(addr+":"+*port, []byte(hashKey), []byte(blockKey), *debug)
+	server := frontend.NewServer(addr+":"+*port, []byte(hashKey), []byte(blockKey), *debug, *token, *password, *publicUrl)
 
 	go func() {
 		stop := make(chan os.Signal)
@@ -0,0 +1,151 @@
+/*
+ * Drives the Bluetooth stack of the player box through bluetoothctl so the
+ * party host can pair and switch speakers without logging into the player.
+ * Once a speaker is connected, pipewire exposes it as a new audio sink which
+ * shows up in mpv's audio device list.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	bluetoothCommand  = "bluetoothctl" // program used to control bluetooth
+	bluetoothScanSecs = "10"           // how long to scan for new devices
+	audioSinkUuid     = "Audio Sink"   // service advertised by speakers
+)
+
+/*
+ * Run a bluetoothctl command and return its output
+ */
+func runBluetoothctl(args ...string) (string, error) {
+	out, err := exec.Command(bluetoothCommand, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s failed: %v: %s", bluetoothCommand,
+			strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
+
+/*
+ * Scan for nearby bluetooth devices. Blocks for the length of the scan.
+ */
+func scanBluetooth() error {
+	_, err := runBluetoothctl("--timeout", bluetoothScanSecs, "scan", "on")
+	return err
+}
+
+/*
+ * Pair with a bluetooth device and trust it so it reconnects on its own
+ */
+func pairBluetooth(address string) error {
+	if _, err := runBluetoothctl("pair", address); err != nil {
+		return err
+	}
+
+	_, err := runBluetoothctl("trust", address)
+	return err
+}
+
+/*
+ * Connect to a paired bluetooth device
+ */
+func connectBluetooth(address string) error {
+	out, err := runBluetoothctl("connect", address)
+	if err != nil {
+		return err
+	}
+
+	// bluetoothctl exits cleanly even when the connection fails
+	if !strings.Contains(out, "Connection successful") {
+		return fmt.Errorf("failed to connect to %s: %s", address, strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+/*
+ * List the bluetooth audio sinks known to the player
+ */
+func listBluetoothSpeakers() ([]*bepb.BluetoothDevice, error) {
+	out, err := runBluetoothctl("devices")
+	if err != nil {
+		return nil, err
+	}
+
+	speakers := make([]*bepb.BluetoothDevice, 0)
+	for _, line := range strings.Split(out, "\n") {
+		// lines look like "Device AA:BB:CC:DD:EE:FF Speaker Name"
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) < 2 || fields[0] != "Device" {
+			continue
+		}
+
+		info, err := runBluetoothctl("info", fields[1])
+		if err != nil || !strings.Contains(info, audioSinkUuid) {
+			continue
+		}
+
+		device := &bepb.BluetoothDevice{Address: fields[1]}
+		if len(fields) == 3 {
+			device.Name = fields[2]
+		}
+		device.Paired = strings.Contains(info, "Paired: yes")
+		device.Connected = strings.Contains(info, "Connected: yes")
+		speakers = append(speakers, device)
+	}
+
+	return speakers, nil
+}
+
+/*
+ * Carry out a bluetooth command from the server and build the status to send
+ * back. The status always carries the current list of speakers.
+ */
+func handleBluetooth(control *bepb.PlayerControl) *bepb.PlayerStatus {
+	var err error
+
+	switch control.GetCommand() {
+	case bepb.CommandType_BluetoothScan:
+		err = scanBluetooth()
+
+	case bepb.CommandType_BluetoothPair:
+		err = pairBluetooth(control.GetBluetoothAddress())
+
+	case bepb.CommandType_BluetoothConnect:
+		err = connectBluetooth(control.GetBluetoothAddress())
+	}
+
+	status := &bepb.PlayerStatus{Command: bepb.CommandType_BluetoothDevices}
+	if err != nil {
+		fmt.Printf("Bluetooth command failed: %v\n", err)
+		status.BluetoothError = err.Error()
+	}
+
+	speakers, err := listBluetoothSpeakers()
+	if err != nil {
+		fmt.Printf("Failed to list bluetooth speakers: %v\n", err)
+		if status.BluetoothError == "" {
+			status.BluetoothError = err.Error()
+		}
+	}
+	status.BluetoothDevices = speakers
+
+	return status
+}
+
+/*
+ * Returns true if the command is handled by the bluetooth controller
+ */
+func isBluetoothCommand(command bepb.CommandType) bool {
+	return command == bepb.CommandType_BluetoothScan ||
+		command == bepb.CommandType_BluetoothPair ||
+		command == bepb.CommandType_BluetoothConnect
+}
@@ -6,13 +6,17 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	mpv "github.com/DexterLB/mpvipc"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/nguyenmq/ytbox-go/common"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
@@ -21,21 +25,42 @@ import (
  * Command line arguments
  */
 var (
-	app        = kingpin.New("ytb-player", "Command line client to play videos in the ytb-be queue")
-	remoteHost = app.Flag("host", "Address of remote ytb-be service").Default("127.0.0.1").Short('h').String()
-	remotePort = app.Flag("port", "Port of remote ytb-be service").Default("9009").Short('p').String()
-	continuous = app.Flag("cont", "Continuous play songs from the queue").Short('c').Bool()
+	app            = kingpin.New("ytb-player", "Command line client to play videos in the ytb-be queue")
+	remoteHost     = app.Flag("host", "Address of remote ytb-be service").Default("127.0.0.1").Short('h').String()
+	remotePort     = app.Flag("port", "Port of remote ytb-be service").Default("9009").Short('p').String()
+	continuous     = app.Flag("cont", "Continuous play songs from the queue").Short('c').Bool()
+	token          = app.Flag("token", "Access token to send to the ytb-be service").String()
+	playerToken    = app.Flag("player", "Token the player was registered with on the ytb-be service").String()
+	keepaliveTime  = app.Flag("keepalive", "Idle time before pinging the ytb-be service").Default("30s").Duration()
+	zone           = app.Flag("zone", "Name of the zone to play songs for. Uses the default zone if not set").Short('z').String()
+	prefetch       = app.Flag("prefetch", "Resolve the streams of upcoming songs ahead of time with yt-dlp").Default("true").Bool()
+	prefetchFormat = app.Flag("prefetchFormat", "yt-dlp format of the resolved streams").Default("best[height<=720]/best").String()
 )
 
 const (
-	mpvSocket = "./.mpvsocket"
+	mpvSocket        = "./.mpvsocket"
+	positionInterval = 5 * time.Second // time between reports of the playback position
+	fadeSteps        = 20              // volume changes made over a fade
 )
 
+/*
+ * ytdl formats mpv streams songs in at each playback quality. mpv can merge
+ * separate video and audio streams, unlike the streams resolved ahead of time.
+ */
+var mpvQualityFormats = map[bepb.PlaybackQuality]string{
+	bepb.PlaybackQuality_AudioQuality: "bestaudio/best",
+	bepb.PlaybackQuality_Quality480p:  "bestvideo[height<=480]+bestaudio/best[height<=480]/best",
+	bepb.PlaybackQuality_Quality1080p: "bestvideo[height<=1080]+bestaudio/best[height<=1080]/best",
+}
+
 /*
  * Remote contoller to interface with mpv
  */
 type Remote struct {
-	conn *mpv.Connection
+	conn         *mpv.Connection
+	normalVolume float64 // volume to go back to after ducking. Zero when not ducked
+	fullVolume   float64 // volume songs fade in to. Zero until the first fade
+	fades        uint32  // number of fades started, so older fades stop
 }
 
 /*
@@ -46,14 +71,20 @@ func (r *Remote) Init(conn *mpv.Connection) {
 }
 
 /*
- * Load a song into mpv
+ * Load a song into mpv with per-file options, such as "start=42", which may
+ * be empty
  */
-func (r *Remote) LoadSong(name string, play bool) {
-	var err error
+func (r *Remote) LoadSong(name string, play bool, options string) {
+	flag := "append"
 	if play {
-		_, err = r.conn.Call("loadfile", name, "append-play")
+		flag = "append-play"
+	}
+
+	var err error
+	if options != "" {
+		_, err = r.conn.Call("loadfile", name, flag, -1, options)
 	} else {
-		_, err = r.conn.Call("loadfile", name, "append")
+		_, err = r.conn.Call("loadfile", name, flag)
 	}
 
 	if err != nil {
@@ -81,6 +112,160 @@ func (r *Remote) ForcePause(state bool) {
 	}
 }
 
+/*
+ * Get the audio output devices known to mpv
+ */
+func (r *Remote) GetAudioDevices() []*bepb.AudioDevice {
+	devices := make([]*bepb.AudioDevice, 0)
+
+	list, err := r.conn.Get("audio-device-list")
+	if err != nil {
+		fmt.Printf("Failed to get audio devices: %v\n", err)
+		return devices
+	}
+
+	entries, ok := list.([]interface{})
+	if !ok {
+		return devices
+	}
+
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _ := fields["name"].(string)
+		description, _ := fields["description"].(string)
+		devices = append(devices, &bepb.AudioDevice{Name: name, Description: description})
+	}
+
+	return devices
+}
+
+/*
+ * Get the name of the audio output device mpv is using
+ */
+func (r *Remote) GetAudioDevice() string {
+	device, err := r.conn.Get("audio-device")
+	if err != nil {
+		fmt.Printf("Failed to get audio device: %v\n", err)
+		return ""
+	}
+
+	name, _ := device.(string)
+	return name
+}
+
+/*
+ * Switch the audio output device
+ */
+func (r *Remote) SetAudioDevice(name string) {
+	_, err := r.conn.Call("set_property", "audio-device", name)
+	if err != nil {
+		fmt.Printf("Failed to set audio device: %v\n", err)
+	}
+}
+
+/*
+ * Lower the volume to a percent of the normal volume. Ducking again while
+ * ducked is relative to the normal volume, not the ducked one.
+ */
+func (r *Remote) Duck(percent uint32) {
+	if r.normalVolume == 0 {
+		volume, err := r.conn.Get("volume")
+		if err != nil {
+			fmt.Printf("Failed to get volume: %v\n", err)
+			return
+		}
+		r.normalVolume, _ = volume.(float64)
+	}
+
+	_, err := r.conn.Call("set_property", "volume", r.normalVolume*float64(percent)/100)
+	if err != nil {
+		fmt.Printf("Failed to duck volume: %v\n", err)
+	}
+}
+
+/*
+ * Go back to the volume from before ducking
+ */
+func (r *Remote) Unduck() {
+	if r.normalVolume == 0 {
+		return
+	}
+
+	_, err := r.conn.Call("set_property", "volume", r.normalVolume)
+	if err != nil {
+		fmt.Printf("Failed to restore volume: %v\n", err)
+		return
+	}
+	r.normalVolume = 0
+}
+
+/*
+ * Fade the volume in from silence or out to silence over a number of seconds.
+ * The fade runs in the background and stops if another one starts.
+ */
+func (r *Remote) Fade(in bool, seconds float64) {
+	if r.fullVolume == 0 {
+		volume, err := r.conn.Get("volume")
+		if err != nil {
+			fmt.Printf("Failed to get volume: %v\n", err)
+			return
+		}
+		r.fullVolume, _ = volume.(float64)
+	}
+
+	fade := atomic.AddUint32(&r.fades, 1)
+	full := r.fullVolume
+	step := time.Duration(seconds * float64(time.Second) / fadeSteps)
+	go func() {
+		for i := 0; i <= fadeSteps && atomic.LoadUint32(&r.fades) == fade; i++ {
+			share := float64(i) / fadeSteps
+			if !in {
+				share = 1 - share
+			}
+
+			_, err := r.conn.Call("set_property", "volume", full*share)
+			if err != nil {
+				fmt.Printf("Failed to fade volume: %v\n", err)
+				return
+			}
+			time.Sleep(step)
+		}
+	}()
+}
+
+/*
+ * Get the seconds played of the current song and whether it's paused
+ */
+func (r *Remote) GetPosition() (float64, bool, error) {
+	position, err := r.conn.Get("time-pos")
+	if err != nil {
+		return 0, false, err
+	}
+
+	paused, err := r.conn.Get("pause")
+	if err != nil {
+		return 0, false, err
+	}
+
+	seconds, _ := position.(float64)
+	isPaused, _ := paused.(bool)
+	return seconds, isPaused, nil
+}
+
+/*
+ * Show text over the video for the given time
+ */
+func (r *Remote) ShowText(text string, duration time.Duration) {
+	_, err := r.conn.Call("show-text", text, duration.Milliseconds())
+	if err != nil {
+		fmt.Printf("Failed to show text: %v\n", err)
+	}
+}
+
 /*
  * Get the number of tracks in mpv's playlist
  */
@@ -97,9 +282,9 @@ func (r *Remote) GetPlaylistCount() float64 {
 /*
  * Go to the next song
  */
-func (r *Remote) Next(name string) {
+func (r *Remote) Next(name string, options string) {
 	if name != "" {
-		r.LoadSong(name, true)
+		r.LoadSong(name, true, options)
 	}
 
 	// a new player should have one track in the playlist. Players who are
@@ -114,6 +299,26 @@ func (r *Remote) Next(name string) {
 	}
 }
 
+/*
+ * Seek to the start of the song playing
+ */
+func (r *Remote) Restart() {
+	_, err := r.conn.Call("seek", 0, "absolute")
+	if err != nil {
+		fmt.Printf("Failed to restart song: %v\n", err)
+	}
+}
+
+/*
+ * Stop playback and clear the playlist, leaving mpv idle
+ */
+func (r *Remote) Stop() {
+	_, err := r.conn.Call("stop")
+	if err != nil {
+		fmt.Printf("Failed to stop: %v\n", err)
+	}
+}
+
 /*
  * Tell mpv to quit
  */
@@ -134,6 +339,16 @@ func connectToRemote() (*grpc.ClientConn, bepb.YtbBePlayer_SongPlayerClient) {
 	opts = append(opts, grpc.WithInsecure())
 	opts = append(opts, grpc.WithBlock())
 	opts = append(opts, grpc.FailOnNonTempDialError(true))
+	opts = append(opts, common.TokenDialOptions(*token)...)
+	opts = append(opts, common.PlayerDialOptions(*playerToken)...)
+
+	// ping the server so the stream isn't dropped by a NAT while the player
+	// waits for songs. Must not ping more often than the server allows.
+	opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                *keepaliveTime,
+		Timeout:             10 * time.Second,
+		PermitWithoutStream: true,
+	}))
 
 	conn, err := grpc.Dial(*remoteHost+":"+*remotePort, opts...)
 	if err != nil {
@@ -175,29 +390,114 @@ func receiveStatus(stream bepb.YtbBePlayer_SongPlayerClient, newStatus chan *bep
 }
 
 /*
- * Handle a new status message from the server.
+ * Handle a new status message from the server. playingId is the song the
+ * player is playing, or zero when idle.
  */
-func handleNewStatus(status *bepb.PlayerControl, remote *Remote) {
+func handleNewStatus(status *bepb.PlayerControl, remote *Remote, resolver *streamResolver, playingId uint32) {
 	fmt.Printf("Received: %v\n", status)
 
+	// songs are played at the quality sent along with them
+	switch status.GetCommand() {
+	case bepb.CommandType_Play, bepb.CommandType_Next, bepb.CommandType_Previous, bepb.CommandType_SetQuality:
+		resolver.setQuality(status.GetQuality())
+	}
+
 	switch status.GetCommand() {
 	case bepb.CommandType_Play:
-		link, ok := buildSongLink(status.GetSong())
+		link, ok := resolveSongLink(status, resolver)
 		if ok {
-			remote.LoadSong(link, true)
+			remote.LoadSong(link, true, playbackOptions(status.GetSong(), status.GetQuality()))
 		}
 
 	case bepb.CommandType_Next:
-		// link can be an empty string. We still want to stop the player even
-		// if there are no more songs in the playlist
-		link, _ := buildSongLink(status.GetSong())
-		remote.Next(link)
+		// link can be an empty string from backends that skip past the last
+		// song with Next instead of Stop. We still want to stop the player
+		// even if there are no more songs in the playlist
+		link, _ := resolveSongLink(status, resolver)
+		remote.Next(link, playbackOptions(status.GetSong(), status.GetQuality()))
+
+	case bepb.CommandType_Previous:
+		// the song playing is restarted and an earlier song is loaded like
+		// the next one
+		if status.GetSong().GetSongId() == playingId {
+			remote.Restart()
+		} else {
+			link, _ := resolveSongLink(status, resolver)
+			remote.Next(link, playbackOptions(status.GetSong(), status.GetQuality()))
+		}
+
+	case bepb.CommandType_Stop:
+		remote.Stop()
 
 	case bepb.CommandType_Pause:
 		remote.TogglePause()
+
+	case bepb.CommandType_SetOutputDevice:
+		remote.SetAudioDevice(status.GetOutputDevice())
+
+	case bepb.CommandType_UpNext:
+		showUpNext(status.GetUpNext(), remote)
+
+	case bepb.CommandType_Duck:
+		remote.Duck(status.GetDuckVolume())
+
+	case bepb.CommandType_Unduck:
+		remote.Unduck()
+
+	case bepb.CommandType_FadeOut:
+		remote.Fade(false, status.GetFadeSeconds())
+
+	case bepb.CommandType_FadeIn:
+		remote.Fade(true, status.GetFadeSeconds())
 	}
 }
 
+/*
+ * Show the songs coming up over the end of the playing song
+ */
+func showUpNext(overlay *bepb.UpNextOverlay, remote *Remote) {
+	if len(overlay.GetSongs()) == 0 {
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("Up next:")
+	for index, song := range overlay.GetSongs() {
+		fmt.Fprintf(&text, "\n%d. %s (%s)", index+1, song.GetTitle(), song.GetUsername())
+	}
+
+	remote.ShowText(text.String(), time.Duration(overlay.GetSecondsLeft()*float64(time.Second)))
+}
+
+/*
+ * Report the available audio output devices to the server
+ */
+func reportDevices(stream bepb.YtbBePlayer_SongPlayerClient, remote *Remote) {
+	stream.Send(&bepb.PlayerStatus{
+		Command:      bepb.CommandType_Devices,
+		Devices:      remote.GetAudioDevices(),
+		OutputDevice: remote.GetAudioDevice(),
+	})
+}
+
+/*
+ * Report how far along the playing song is to the server
+ */
+func reportPosition(stream bepb.YtbBePlayer_SongPlayerClient, remote *Remote, songId uint32) {
+	position, paused, err := remote.GetPosition()
+	if err != nil {
+		return
+	}
+
+	stream.Send(&bepb.PlayerStatus{
+		Command:      bepb.CommandType_Position,
+		SongId:       songId,
+		Position:     position,
+		Paused:       paused,
+		PositionTime: time.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
+
 /*
  * Handle messages from other goroutines.
  */
@@ -205,15 +505,28 @@ func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connect
 	newStatus := make(chan *bepb.PlayerControl)
 	halt := make(chan os.Signal)
 	mpvExit := make(chan struct{})
+	bluetoothDone := make(chan *bepb.PlayerStatus)
 	signal.Notify(halt, os.Interrupt)
 	remote := new(Remote)
 	remote.Init(conn)
 	events, stop := conn.NewEventListener()
 	streamOk := true
 	running := true
+	resolver := new(streamResolver)
+	resolver.init(*prefetch, *prefetchFormat)
+	var playingId uint32 // id of the song being played. Zero when idle
+	positionTicker := time.NewTicker(positionInterval)
+	defer positionTicker.Stop()
 
 	// send the initial command to the server to signal the player is ready
-	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+	// and which zone it belongs to
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready, Zone: *zone, SupportsVolume: true})
+	reportDevices(stream, remote)
+
+	// report the bluetooth speakers the player already knows about
+	go func() {
+		bluetoothDone <- handleBluetooth(&bepb.PlayerControl{})
+	}()
 
 	// start receiving messages
 	go receiveStatus(stream, newStatus)
@@ -231,7 +544,31 @@ func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connect
 				running = false
 				break
 			}
-			handleNewStatus(status, remote)
+			handleNewStatus(status, remote, resolver, playingId)
+			resolver.prefetch(status.GetUpcoming())
+
+			if status.GetCommand() == bepb.CommandType_Play || status.GetCommand() == bepb.CommandType_Next ||
+				status.GetCommand() == bepb.CommandType_Previous {
+				playingId = status.GetSong().GetSongId()
+			}
+
+			// let the server know the device switch went through
+			if status.GetCommand() == bepb.CommandType_SetOutputDevice {
+				reportDevices(stream, remote)
+			}
+
+			// bluetooth commands can take a while, so don't hold up playback
+			if isBluetoothCommand(status.GetCommand()) {
+				go func(control *bepb.PlayerControl) {
+					bluetoothDone <- handleBluetooth(control)
+				}(status)
+			}
+
+		case report := <-bluetoothDone:
+			stream.Send(report)
+
+			// a newly connected speaker shows up as a new audio device
+			reportDevices(stream, remote)
 
 		case <-mpvExit:
 			running = false
@@ -242,9 +579,29 @@ func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connect
 			break
 
 		case event := <-events:
+			// the backend returns songs nobody confirmed to the queue
+			if event.Name == "file-loaded" && playingId != 0 {
+				stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Playing, SongId: playingId})
+			}
+
+			// the backend gives songs that fail right away another go
+			if event.Name == "end-file" && event.Reason == "error" && playingId != 0 {
+				stream.Send(&bepb.PlayerStatus{
+					Command:   bepb.CommandType_Failed,
+					SongId:    playingId,
+					PlayError: "mpv failed to play the song",
+				})
+			}
+
 			if event.Name == "idle" {
+				playingId = 0
 				stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
 			}
+
+		case <-positionTicker.C:
+			if playingId != 0 {
+				reportPosition(stream, remote, playingId)
+			}
 		}
 	}
 
@@ -264,6 +621,56 @@ func interactionLoop(stream bepb.YtbBePlayer_SongPlayerClient, conn *mpv.Connect
 	close(halt)
 }
 
+/*
+ * Prefer the pre-downloaded copy of the song if the backend sent one and it's
+ * reachable from this player. Otherwise stream the song from its service,
+ * using the stream url resolved ahead of time if there is one. Songs played
+ * again after failing are always streamed afresh.
+ */
+func resolveSongLink(status *bepb.PlayerControl, resolver *streamResolver) (string, bool) {
+	if status.GetRetry() {
+		return buildSongLink(status.GetSong())
+	}
+
+	if path := status.GetLocalPath(); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+
+	link, ok := buildSongLink(status.GetSong())
+	if !ok {
+		return link, ok
+	}
+
+	return resolver.lookup(link), true
+}
+
+/*
+ * Returns the mpv options for playing a song the way its submitter prefers,
+ * at the quality the backend asked for
+ */
+func playbackOptions(song *cmpb.Song, quality bepb.PlaybackQuality) string {
+	var options []string
+	if song.GetStartAt() > 0 {
+		options = append(options, fmt.Sprintf("start=%d", song.GetStartAt()))
+	}
+
+	if song.GetEndAt() > 0 {
+		options = append(options, fmt.Sprintf("end=%d", song.GetEndAt()))
+	}
+
+	if song.GetAudioOnly() || quality == bepb.PlaybackQuality_AudioQuality {
+		options = append(options, "vid=no")
+	}
+
+	if format, exists := mpvQualityFormats[quality]; exists {
+		options = append(options, "ytdl-format="+format)
+	}
+
+	return strings.Join(options, ",")
+}
+
 /*
  * Build the song link
  */
@@ -278,6 +685,9 @@ func buildSongLink(song *cmpb.Song) (string, bool) {
 	case cmpb.ServiceType_Youtube:
 		link = fmt.Sprintf("https://www.youtube.com/watch?v=%s", song.GetServiceId())
 
+	case cmpb.ServiceType_Web:
+		link = song.GetServiceId()
+
 	case cmpb.ServiceType_None:
 		ok = false
 
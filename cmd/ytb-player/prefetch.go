@@ -0,0 +1,195 @@
+/*
+ * Gets upcoming songs ready to play. The backend sends the next few songs
+ * along with every song it plays, and the player resolves their stream urls
+ * in the background so the next song starts without waiting on YouTube.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	resolverCommand = "yt-dlp"         // external program used to resolve stream urls
+	resolvedTTL     = 30 * time.Minute // how long a resolved stream url is trusted
+	maxResolved     = 16               // most resolved stream urls kept around
+)
+
+/*
+ * yt-dlp formats resolving to a single stream at each playback quality. The
+ * default quality uses the format the player was started with.
+ */
+var qualityFormats = map[bepb.PlaybackQuality]string{
+	bepb.PlaybackQuality_AudioQuality: "bestaudio/best",
+	bepb.PlaybackQuality_Quality480p:  "best[height<=480]/best",
+	bepb.PlaybackQuality_Quality1080p: "best[height<=1080]/best",
+}
+
+/*
+ * A stream url resolved ahead of time
+ */
+type resolvedStream struct {
+	url        string    // direct url of the stream
+	resolvedAt time.Time // when the url was resolved
+}
+
+/*
+ * Resolves the stream urls of upcoming songs ahead of time, so mpv doesn't
+ * have to look them up when the song starts
+ */
+type streamResolver struct {
+	enabled  bool                       // true if the resolver program is installed
+	format   string                     // format of the streams to resolve
+	fallback string                     // format the player was started with
+	resolved map[string]*resolvedStream // link -> resolved stream
+	pending  map[string]bool            // links being resolved
+	lock     sync.Mutex                 // lock on the resolved streams
+}
+
+/*
+ * Initialize the resolver. Resolving is disabled if it was turned off or the
+ * resolver program isn't installed.
+ */
+func (r *streamResolver) init(enabled bool, format string) {
+	r.format = format
+	r.fallback = format
+	r.resolved = make(map[string]*resolvedStream)
+	r.pending = make(map[string]bool)
+
+	if _, err := exec.LookPath(resolverCommand); enabled && err == nil {
+		r.enabled = true
+	}
+}
+
+/*
+ * Resolve streams at a playback quality from now on. Streams resolved at
+ * another quality are thrown away.
+ */
+func (r *streamResolver) setQuality(quality bepb.PlaybackQuality) {
+	format, exists := qualityFormats[quality]
+	if !exists {
+		format = r.fallback
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if format != r.format {
+		r.format = format
+		r.resolved = make(map[string]*resolvedStream)
+	}
+}
+
+/*
+ * Start resolving the stream urls of the upcoming songs in the background
+ */
+func (r *streamResolver) prefetch(hints []*bepb.PrefetchHint) {
+	if !r.enabled {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, hint := range hints {
+		// cached copies and local files start instantly already
+		service := hint.GetSong().GetService()
+		if hint.GetLocalPath() != "" || (service != cmpb.ServiceType_Youtube && service != cmpb.ServiceType_Web) {
+			continue
+		}
+
+		link, ok := buildSongLink(hint.GetSong())
+		if !ok || r.pending[link] || r.fresh(link) {
+			continue
+		}
+
+		r.pending[link] = true
+		go r.resolve(link)
+	}
+}
+
+/*
+ * Returns the resolved stream url of a link, or the link itself if it wasn't
+ * resolved ahead of time
+ */
+func (r *streamResolver) lookup(link string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.fresh(link) {
+		return link
+	}
+
+	url := r.resolved[link].url
+	delete(r.resolved, link)
+	return url
+}
+
+/*
+ * Returns true if the link has a resolved url that can still be trusted.
+ * Assumes the caller holds the lock.
+ */
+func (r *streamResolver) fresh(link string) bool {
+	stream, exists := r.resolved[link]
+	return exists && time.Since(stream.resolvedAt) < resolvedTTL
+}
+
+/*
+ * Resolve the stream url of a single link
+ */
+func (r *streamResolver) resolve(link string) {
+	r.lock.Lock()
+	format := r.format
+	r.lock.Unlock()
+
+	out, err := exec.Command(resolverCommand, "--quiet", "--no-playlist", "--format", format,
+		"--get-url", link).Output()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.pending, link)
+
+	// the quality changed while the stream was being resolved
+	if format != r.format {
+		return
+	}
+
+	// formats that need separate video and audio streams resolve to more than
+	// one url, which mpv can't play from a single link
+	urls := strings.Fields(string(out))
+	if err != nil || len(urls) != 1 {
+		fmt.Fprintf(os.Stderr, "Failed to resolve %s: %v\n", link, err)
+		return
+	}
+
+	r.evict()
+	r.resolved[link] = &resolvedStream{url: urls[0], resolvedAt: time.Now()}
+}
+
+/*
+ * Drop expired streams, and the oldest ones if there are still too many.
+ * Assumes the caller holds the lock.
+ */
+func (r *streamResolver) evict() {
+	var oldest string
+	for link, stream := range r.resolved {
+		if time.Since(stream.resolvedAt) >= resolvedTTL {
+			delete(r.resolved, link)
+		} else if oldest == "" || stream.resolvedAt.Before(r.resolved[oldest].resolvedAt) {
+			oldest = link
+		}
+	}
+
+	if len(r.resolved) >= maxResolved {
+		delete(r.resolved, oldest)
+	}
+}
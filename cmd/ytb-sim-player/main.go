@@ -0,0 +1,70 @@
+/*
+ * A stand-in for ytb-player that plays songs by waiting out their length, for
+ * developing against the backend without mpv or access to YouTube
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"google.golang.org/grpc"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	"github.com/nguyenmq/ytbox-go/simplayer"
+)
+
+/*
+ * Command line arguments
+ */
+var (
+	app         = kingpin.New("ytb-sim-player", "Simulated player that plays songs in the ytb-be queue by waiting out their length")
+	remoteHost  = app.Flag("host", "Address of remote ytb-be service").Default("127.0.0.1").Short('h').String()
+	remotePort  = app.Flag("port", "Port of remote ytb-be service").Default("9009").Short('p').String()
+	token       = app.Flag("token", "Access token to send to the ytb-be service").String()
+	playerToken = app.Flag("player", "Token the player was registered with on the ytb-be service").String()
+	zone        = app.Flag("zone", "Name of the zone to play songs for. Uses the default zone if not set").Short('z').String()
+	speed       = app.Flag("speed", "Play songs this many times faster than real time").Default("1").Float64()
+)
+
+func main() {
+	kingpin.Version("0.1")
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock(), grpc.FailOnNonTempDialError(true)}
+	opts = append(opts, common.TokenDialOptions(*token)...)
+	opts = append(opts, common.PlayerDialOptions(*playerToken)...)
+
+	conn, err := grpc.Dial(*remoteHost+":"+*remotePort, opts...)
+	if err != nil {
+		fmt.Printf("failed to dial server: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	// stop playing on ctrl-c
+	ctx, cancel := context.WithCancel(context.Background())
+	halt := make(chan os.Signal, 1)
+	signal.Notify(halt, os.Interrupt)
+	go func() {
+		<-halt
+		cancel()
+	}()
+
+	stream, err := bepb.NewYtbBePlayerClient(conn).SongPlayer(ctx)
+	if err != nil {
+		fmt.Printf("Failed to connect: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = simplayer.New(*zone, *speed).Run(ctx, stream); err != nil {
+		fmt.Printf("Disconnected: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("end")
+}
@@ -3,11 +3,32 @@
 package common
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
+/*
+ * How much is logged. Messages logged with log.Printf are always written;
+ * Debugf messages are only written at the debug level.
+ */
+type LogLevel int32
+
+const (
+	InfoLevel  LogLevel = iota // what the program does
+	DebugLevel                 // along with the detail needed to diagnose it
+)
+
+/*
+ * Log levels by the names they're set with
+ */
+var LogLevels = map[string]LogLevel{
+	"info":  InfoLevel,
+	"debug": DebugLevel,
+}
+
 const (
 	// base path of the log file
 	baseLogPath string = "logs"
@@ -18,6 +39,7 @@ const (
 )
 
 var logger io.Writer = os.Stdout
+var logLevel int32 = int32(InfoLevel)
 
 /*
  * Initialize the standard logging in a common way across all yt_box.
@@ -55,3 +77,41 @@ func InitLogger(prefix string, useStdOut bool) *os.File {
 func GetLogger() io.Writer {
 	return logger
 }
+
+/*
+ * Change how much is logged. Safe to call while other goroutines log.
+ * Returns the level logged at before.
+ */
+func SetLogLevel(level LogLevel) LogLevel {
+	return LogLevel(atomic.SwapInt32(&logLevel, int32(level)))
+}
+
+/*
+ * Returns how much is logged
+ */
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+/*
+ * Log a message only when logging at the debug level. The arguments aren't
+ * formatted otherwise, so it's cheap to call often.
+ */
+func Debugf(format string, v ...interface{}) {
+	if GetLogLevel() >= DebugLevel {
+		log.Output(2, "DEBUG "+fmt.Sprintf(format, v...))
+	}
+}
+
+/*
+ * Returns the name of the level
+ */
+func (level LogLevel) String() string {
+	for name, value := range LogLevels {
+		if value == level {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("level %d", int32(level))
+}
@@ -5,6 +5,7 @@
 package database
 
 import (
+	"context"
 	"time"
 
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
@@ -23,6 +24,68 @@ type RoomData struct {
 	LastAccess time.Time
 }
 
+type SongDetailsData struct {
+	Details   bepb.SongDetails
+	FetchDate time.Time
+}
+
+type LyricsData struct {
+	Lyrics    bepb.Lyrics
+	FetchDate time.Time
+}
+
+type ZoneData struct {
+	Zone       bepb.Zone
+	CreateDate time.Time
+}
+
+type FallbackSongData struct {
+	Song       cmpb.Song
+	Channel    string // channel that uploaded the song. Empty if unknown
+	LastPlayed time.Time
+}
+
+/*
+ * A song from the history along with when it was submitted
+ */
+type HistoryData struct {
+	Song      cmpb.Song
+	Date      time.Time
+	Skipped   bool
+	Outcome   bepb.SongOutcome // how the song ended
+	SkippedBy string           // who skipped the song directly, if anyone
+	Voters    []uint32         // ids of the users who voted the song off
+}
+
+type AchievementData struct {
+	UserId        uint32
+	AchievementId string
+	EarnDate      time.Time
+}
+
+/*
+ * The song that got the most of one reaction
+ */
+type ReactionHighlightData struct {
+	Emoji     string
+	Song      cmpb.Song
+	Reactions uint32
+}
+
+/*
+ * Totals computed from the song history of one user. A night runs from 6am
+ * local time until 6am the next day.
+ */
+type HistoryTotalsData struct {
+	UserId       uint32
+	Username     string
+	Songs        uint32 // songs submitted
+	Skips        uint32 // submitted songs that were skipped
+	NightOpeners uint32 // nights the user submitted the first song of
+	BestNight    uint32 // most songs submitted in a single night
+	Achievements uint32 // achievements earned
+}
+
 /*
  * Interface for manager the backend database
  */
@@ -39,9 +102,17 @@ type DbManager interface {
 	// Close the database connection
 	Close()
 
+	// Run fn in a transaction. The changes fn makes through the manager it's
+	// given are committed together if fn returns nil and rolled back
+	// otherwise.
+	WithTx(fn func(tx DbManager) error) error
+
 	// Get user by id
 	GetUserById(userId uint32) (*UserData, error)
 
+	// Get the oldest user with the given name in a room
+	GetUserByName(username string, roomId uint32) (*UserData, error)
+
 	// Updates the given user's name
 	UpdateUsername(username string, userId uint32) error
 
@@ -50,4 +121,262 @@ type DbManager interface {
 
 	// Initialize the database interface
 	Init(dbPath string) error
+
+	// Get a previously submitted song by its id
+	GetSongById(songId uint32) (*cmpb.Song, error)
+
+	// Cache the extended metadata of a song
+	AddSongDetails(service cmpb.ServiceType, serviceId string, details *bepb.SongDetails) error
+
+	// Get the cached extended metadata of a song
+	GetSongDetails(service cmpb.ServiceType, serviceId string) (*SongDetailsData, error)
+
+	// Cache the lyrics of a song. Empty lyrics record that none were found.
+	AddLyrics(service cmpb.ServiceType, serviceId string, lyrics *bepb.Lyrics) error
+
+	// Get the cached lyrics of a song
+	GetLyrics(service cmpb.ServiceType, serviceId string) (*LyricsData, error)
+
+	// Add a new player zone
+	AddZone(zoneName string, shared bool) (*ZoneData, error)
+
+	// Remove a player zone
+	RemoveZone(zoneId uint32) error
+
+	// Get all the player zones
+	GetZones() ([]*ZoneData, error)
+
+	// Count the songs ever submitted through each interface
+	GetSourceCounts() (map[cmpb.SubmissionSource]uint32, error)
+
+	// Remove songs submitted before the given time. Returns the number of
+	// songs removed.
+	PruneSongs(before time.Time) (int64, error)
+
+	// Compact the database and refresh its query planner statistics
+	Vacuum() error
+
+	// Save a copy of the songs under a share code
+	AddSharedPlaylist(code string, userId uint32, songs []*cmpb.Song) error
+
+	// Get the songs saved under a share code
+	GetSharedPlaylist(code string) ([]*cmpb.Song, error)
+
+	// Flag a submitted song as skipped
+	MarkSongSkipped(songId uint32) error
+
+	// Compute the history totals of every user who submitted a song
+	GetHistoryTotals() ([]*HistoryTotalsData, error)
+
+	// Record an achievement earned by a user. Returns false if the user had
+	// already earned it.
+	AddAchievement(userId uint32, achievementId string) (bool, error)
+
+	// Get the achievements earned by a user
+	GetAchievements(userId uint32) ([]*AchievementData, error)
+
+	// Exempt a user from the submission limits, or take the exemption away
+	SetUserExempt(userId uint32, exempt bool) error
+
+	// Returns true if the user is exempt from the submission limits
+	IsUserExempt(userId uint32) (bool, error)
+
+	// Get the users exempt from the submission limits
+	GetExemptUsers() ([]*UserData, error)
+
+	// Save the settings a user's submissions get by default
+	SetPreferences(preferences *bepb.Preferences) error
+
+	// Get the preferences of a user, or the defaults if the user never saved
+	// any
+	GetPreferences(userId uint32) (*bepb.Preferences, error)
+
+	// Get songs from the history that weren't played since the given time,
+	// in random order
+	GetFallbackCandidates(playedBefore time.Time, limit int) ([]*FallbackSongData, error)
+
+	// Get songs from a shared playlist that weren't played since the given
+	// time, in random order
+	GetPlaylistFallbackCandidates(code string, playedBefore time.Time, limit int) ([]*FallbackSongData, error)
+
+	// Save a queue preset. Replaces any preset saved under the same name.
+	SavePreset(preset *bepb.Preset) error
+
+	// Get a queue preset by its name
+	GetPreset(name string) (*bepb.Preset, error)
+
+	// Get all the saved queue presets
+	GetPresets() ([]*bepb.Preset, error)
+
+	// Record a user's reaction to a song. Returns false if the user already
+	// reacted to the song with the same emoji.
+	AddReaction(songId uint32, userId uint32, emoji string) (bool, error)
+
+	// Count the reactions to a song, most common first
+	GetReactionCounts(songId uint32) ([]*bepb.ReactionCount, error)
+
+	// Get the song with the most of each reaction tonight
+	GetTopReactions() ([]*ReactionHighlightData, error)
+
+	// Get the most recently submitted songs, newest first
+	GetRecentSongs(limit int) ([]*HistoryData, error)
+
+	// Add a song to the history as submitted at the given time. Returns false
+	// if the user's submission of the song at that time was already recorded.
+	AddHistorySong(song *cmpb.Song, date time.Time, skipped bool) (bool, error)
+
+	// Get all of the rooms
+	GetRooms() ([]*RoomData, error)
+
+	// Get the songs submitted from the start time up to the end time, oldest
+	// first
+	GetSongsBetween(start time.Time, end time.Time) ([]*HistoryData, error)
+
+	// Save the recap of a party and set its id
+	SaveRecap(recap *bepb.PartyRecap) error
+
+	// Get a party recap by its id, or the latest one if the id is zero.
+	// Returns sql.ErrNoRows if there is no such recap.
+	GetRecap(recapId uint32) (*bepb.PartyRecap, error)
+
+	// Save a jingle. Replaces any jingle saved under the same name.
+	SaveJingle(jingle *bepb.Jingle) error
+
+	// Remove a jingle. Returns false if there was no jingle by that name.
+	RemoveJingle(name string) (bool, error)
+
+	// Get all the saved jingles ordered by name
+	GetJingles() ([]*bepb.Jingle, error)
+
+	// Save a registered player. Replaces any player registered under the
+	// same name.
+	SaveRegisteredPlayer(player *bepb.RegisteredPlayer) error
+
+	// Remove a registered player. Returns false if no player was registered
+	// under the name.
+	RemoveRegisteredPlayer(name string) (bool, error)
+
+	// Get the registered player with the token. Returns sql.ErrNoRows if no
+	// player has the token.
+	GetRegisteredPlayerByToken(token string) (*bepb.RegisteredPlayer, error)
+
+	// Get all the registered players ordered by name
+	GetRegisteredPlayers() ([]*bepb.RegisteredPlayer, error)
+
+	// Record that a registered player connected just now
+	TouchRegisteredPlayer(name string) error
+
+	// Mark a room as holding demo data
+	MarkDemoRoom(roomId uint32) error
+
+	// Remove the demo rooms along with their users and everything the users
+	// did. Returns the number of users removed.
+	RemoveDemoData() (int64, error)
+
+	// Block a song from being submitted. Blocking a blocked song does nothing.
+	BlockSong(service cmpb.ServiceType, serviceId string) error
+
+	// Let a blocked song be submitted again. Returns false if the song wasn't
+	// blocked.
+	UnblockSong(service cmpb.ServiceType, serviceId string) (bool, error)
+
+	// Returns true if the song is blocked from being submitted
+	IsSongBlocked(service cmpb.ServiceType, serviceId string) (bool, error)
+
+	// Returns a manager whose queries give up once the context is done, such
+	// as when the deadline of the call they're made for passes. Calls that
+	// give up return the context's error, like context.DeadlineExceeded, and
+	// leave no partial changes behind.
+	WithContext(ctx context.Context) DbManager
+
+	// Save the display settings of a room, or of the whole server for room
+	// id zero. Returns false if the room doesn't exist.
+	SetDisplaySettings(settings *bepb.DisplaySettings) (bool, error)
+
+	// Get the display settings of a room. Rooms without settings of their
+	// own get the whole server's, and a server without any gets the defaults.
+	GetDisplaySettings(roomId uint32) (*bepb.DisplaySettings, error)
+
+	// Save an api key under a hash of the key. Returns false if a key
+	// already has the name.
+	SaveApiKey(key *bepb.ApiKey, keyHash string) (bool, error)
+
+	// Remove an api key. Returns false if no key had the name.
+	RemoveApiKey(name string) (bool, error)
+
+	// Query for the api key saved under the hash. Returns sql.ErrNoRows if
+	// there is none.
+	GetApiKeyByHash(keyHash string) (*bepb.ApiKey, error)
+
+	// Get all the api keys ordered by name, without their hashes
+	GetApiKeys() ([]*bepb.ApiKey, error)
+
+	// Reserve a block of song ids for songs added later with AddReservedSong.
+	// Returns the first id of the block. Songs added with AddSong never get
+	// an id from a reserved block.
+	ReserveSongIds(count uint32) (uint32, error)
+
+	// Add a new song to the database under the id it was given from a
+	// reserved block
+	AddReservedSong(song *cmpb.Song) error
+
+	// Tag a song for a user. Returns false if the user already put the tag
+	// on the song.
+	AddSongTag(service cmpb.ServiceType, serviceId string, tag string, userId uint32) (bool, error)
+
+	// Remove a user's tag from a song. Returns false if the user hadn't put
+	// the tag on the song.
+	RemoveSongTag(service cmpb.ServiceType, serviceId string, tag string, userId uint32) (bool, error)
+
+	// Find the songs with a tag starting with the given one, most recently
+	// played first, along with all of their tags
+	FindTaggedSongs(tag string, limit int) ([]*bepb.TaggedSong, error)
+
+	// Get songs with a tag that weren't played since the given time, in
+	// random order
+	GetTaggedFallbackCandidates(tag string, playedBefore time.Time, limit int) ([]*FallbackSongData, error)
+
+	// Save a song scheduled to play at a set time and set its id
+	SaveScheduledSong(scheduled *bepb.ScheduledSong) error
+
+	// Remove a scheduled song. Returns false if no song was scheduled with
+	// the id.
+	RemoveScheduledSong(scheduledId uint32) (bool, error)
+
+	// Get the scheduled songs, soonest first
+	GetScheduledSongs() ([]*bepb.ScheduledSong, error)
+
+	// Get the songs in the history with the ids, in no particular order.
+	// Songs that don't exist are left out.
+	GetSongsByIds(songIds []uint32) ([]*cmpb.Song, error)
+
+	// Link an account on another service to a user, replacing the link the
+	// account had to any other user
+	LinkIdentity(identity *bepb.ExternalIdentity) error
+
+	// Remove the link of an account on another service. Returns false if the
+	// account wasn't linked.
+	UnlinkIdentity(provider string, externalId string) (bool, error)
+
+	// Query for the link of an account on another service. Returns
+	// sql.ErrNoRows if the account isn't linked.
+	GetIdentity(provider string, externalId string) (*bepb.ExternalIdentity, error)
+
+	// Get the accounts on other services linked to a user, ordered by
+	// provider
+	GetUserIdentities(userId uint32) ([]*bepb.ExternalIdentity, error)
+
+	// Record how a song ended, who skipped it and the users who voted it off
+	SetSongOutcome(songId uint32, outcome bepb.SongOutcome, skippedBy string, voters []uint32) error
+
+	// Get the most recently submitted songs of a user, or of everyone for
+	// user id zero, newest first. A user's anonymous songs are left out.
+	GetHistory(userId uint32, limit int) ([]*HistoryData, error)
+
+	// Count the songs that ended each way, of a user or of everyone for user
+	// id zero
+	GetOutcomeCounts(userId uint32) (map[bepb.SongOutcome]uint32, error)
+
+	// Get who skipped a user's songs, most skips first
+	GetSkippers(userId uint32) ([]*bepb.Skipper, error)
 }
@@ -0,0 +1,54 @@
+/*
+ * The database schema, embedded in the binary so the backend stays a single
+ * file to deploy. The tables in schema/create make up a new database, and the
+ * statements in schema/upgrades add the tables introduced since, run in the
+ * order of their file names. Upgrades must be safe to run against a database
+ * that is already up to date.
+ */
+
+package database
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//go:embed schema
+var schema embed.FS
+
+/*
+ * A statement of the schema along with the name of its file
+ */
+type schemaStatement struct {
+	name      string // name of the file without its order and extension, such as "songs"
+	statement string // sql of the statement
+}
+
+/*
+ * Returns the statements in a directory of the schema, ordered by their file
+ * names
+ */
+func schemaStatements(dir string) ([]schemaStatement, error) {
+	entries, err := fs.ReadDir(schema, path.Join("schema", dir))
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]schemaStatement, 0, len(entries))
+	for _, entry := range entries {
+		data, err := fs.ReadFile(schema, path.Join("schema", dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		if i := strings.Index(name, "_"); i >= 0 {
+			name = name[i+1:]
+		}
+		statements = append(statements, schemaStatement{name: name, statement: string(data)})
+	}
+
+	return statements, nil
+}
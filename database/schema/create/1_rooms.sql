@@ -0,0 +1,5 @@
+CREATE TABLE rooms (
+	room_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_name TEXT,
+	create_date DATETIME NOT NULL,
+	last_access DATETIME NOT NULL);
@@ -0,0 +1,7 @@
+CREATE TABLE users (
+	user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT,
+	room_id INTEGER NOT NULL,
+	logged_in BOOLEAN NOT NULL,
+	last_access DATETIME NOT NULL,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id));
@@ -0,0 +1,10 @@
+CREATE TABLE songs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	date DATETIME NOT NULL,
+	user_id INTEGER NOT NULL,
+	room_id INTEGER NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id));
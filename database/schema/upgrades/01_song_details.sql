@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS song_details (
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	description TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	view_count INTEGER NOT NULL,
+	fetch_date DATETIME NOT NULL,
+	PRIMARY KEY (service, service_id));
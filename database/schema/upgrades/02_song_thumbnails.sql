@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS song_thumbnails (
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	quality TEXT NOT NULL,
+	url TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	FOREIGN KEY (service, service_id) REFERENCES song_details(service, service_id)
+		ON DELETE CASCADE);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS zones (
+	zone_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	zone_name TEXT NOT NULL UNIQUE,
+	shared BOOLEAN NOT NULL,
+	create_date DATETIME NOT NULL);
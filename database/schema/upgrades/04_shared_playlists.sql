@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS shared_playlists (
+	code TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	create_date DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
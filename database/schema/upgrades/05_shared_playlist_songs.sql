@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS shared_playlist_songs (
+	code TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	thumbnail TEXT NOT NULL,
+	duration TEXT NOT NULL,
+	FOREIGN KEY (code) REFERENCES shared_playlists(code) ON DELETE CASCADE);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_achievements (
+	user_id INTEGER NOT NULL,
+	achievement TEXT NOT NULL,
+	earn_date DATETIME NOT NULL,
+	PRIMARY KEY (user_id, achievement),
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS song_reactions (
+	song_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	emoji TEXT NOT NULL,
+	react_date DATETIME NOT NULL,
+	PRIMARY KEY (song_id, user_id, emoji),
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
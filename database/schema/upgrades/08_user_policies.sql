@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_policies (
+	user_id INTEGER PRIMARY KEY,
+	exempt INTEGER NOT NULL DEFAULT 0,
+	update_date DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
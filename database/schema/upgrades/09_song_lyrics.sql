@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS song_lyrics (
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	provider TEXT NOT NULL,
+	plain TEXT NOT NULL,
+	synced TEXT NOT NULL,
+	fetch_date DATETIME NOT NULL,
+	PRIMARY KEY (service, service_id));
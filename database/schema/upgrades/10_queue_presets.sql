@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS queue_presets (
+	name TEXT PRIMARY KEY,
+	algorithm INTEGER NOT NULL,
+	max_minutes INTEGER NOT NULL,
+	submission_window INTEGER NOT NULL,
+	fallback_code TEXT NOT NULL,
+	open_at TEXT NOT NULL,
+	close_at TEXT NOT NULL,
+	update_date DATETIME NOT NULL);
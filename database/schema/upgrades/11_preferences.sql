@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS preferences (
+	user_id INTEGER PRIMARY KEY,
+	audio_only INTEGER NOT NULL DEFAULT 0,
+	start_behavior INTEGER NOT NULL DEFAULT 0,
+	notify INTEGER NOT NULL DEFAULT 0,
+	update_date DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
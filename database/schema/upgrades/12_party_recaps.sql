@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS party_recaps (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	start_date DATETIME NOT NULL,
+	end_date DATETIME NOT NULL,
+	recap BLOB NOT NULL);
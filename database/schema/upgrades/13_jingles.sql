@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS jingles (
+	name TEXT PRIMARY KEY,
+	link TEXT NOT NULL,
+	update_date DATETIME NOT NULL);
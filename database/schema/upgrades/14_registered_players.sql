@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS registered_players (
+	name TEXT PRIMARY KEY,
+	token TEXT NOT NULL UNIQUE,
+	zone TEXT NOT NULL,
+	output_device TEXT NOT NULL,
+	last_seen DATETIME,
+	update_date DATETIME NOT NULL);
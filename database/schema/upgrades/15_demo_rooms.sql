@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS demo_rooms (
+	room_id INTEGER PRIMARY KEY,
+	FOREIGN KEY (room_id) REFERENCES rooms(room_id) ON DELETE CASCADE);
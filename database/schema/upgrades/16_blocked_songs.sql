@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS blocked_songs (
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	block_date DATETIME NOT NULL,
+	PRIMARY KEY (service, service_id));
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS display_settings (
+	room_id INTEGER PRIMARY KEY,
+	party_name TEXT NOT NULL,
+	theme_color TEXT NOT NULL DEFAULT '',
+	banner TEXT NOT NULL DEFAULT '',
+	show_submitter INTEGER NOT NULL DEFAULT 1,
+	update_date DATETIME NOT NULL);
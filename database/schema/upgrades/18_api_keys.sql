@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	name TEXT PRIMARY KEY,
+	key_hash TEXT NOT NULL UNIQUE,
+	scope INTEGER NOT NULL,
+	created DATETIME NOT NULL);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS song_tags (
+	service TEXT NOT NULL,
+	service_id TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	tag_date DATETIME NOT NULL,
+	PRIMARY KEY (service, service_id, tag, user_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS scheduled_songs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	play_at DATETIME NOT NULL,
+	schedule BLOB NOT NULL);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS external_identities (
+	provider TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	link_date DATETIME NOT NULL,
+	PRIMARY KEY (provider, external_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS skip_votes (
+	song_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY (song_id, user_id),
+	FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id));
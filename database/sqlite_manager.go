@@ -5,55 +5,409 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	_ "github.com/mattn/go-sqlite3"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
 const (
-	createUsersTable = `
-		CREATE TABLE users (
-			user_id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT,
-			room_id INTEGER NOT NULL,
-			logged_in BOOLEAN NOT NULL,
-			last_access DATETIME NOT NULL,
-			FOREIGN KEY (room_id) REFERENCES rooms(room_id));`
-
-	createRoomsTable = `
-		CREATE TABLE rooms (
-			room_id INTEGER PRIMARY KEY AUTOINCREMENT,
-			room_name TEXT,
-			create_date DATETIME NOT NULL,
-			last_access DATETIME NOT NULL);`
-
-	createSongsTable = `
-		CREATE TABLE songs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			service TEXT NOT NULL,
-			service_id TEXT NOT NULL,
-			date DATETIME NOT NULL,
-			user_id INTEGER NOT NULL,
-			room_id INTEGER NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(user_id),
-			FOREIGN KEY (room_id) REFERENCES rooms(room_id));`
-
 	enableForeignKeySupport = `
 		PRAGMA foreign_keys = ON;`
 
+	checkIntegrity = `
+		PRAGMA quick_check;`
+
+	copyDatabase = `
+		VACUUM INTO ?;`
+
+	selectTableNames = `
+		SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;`
+
 	insertRoom = `
 		INSERT INTO rooms VALUES
 		(NULL, ?, datetime('now'), datetime('now'));`
 
 	insertSong = `
-		INSERT INTO songs VALUES
-		(NULL, ?, ?, ?, datetime('now'), ?, ?);`
+		INSERT INTO songs (title, service, service_id, date, user_id, room_id, source, raw_title, clean_title,
+			duration, anonymous, start_at, end_at, chapter, romanized_title, language) VALUES
+		(?, ?, ?, datetime('now'), ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?);`
+
+	insertReservedSong = `
+		INSERT INTO songs (id, title, service, service_id, date, user_id, room_id, source, raw_title, clean_title,
+			duration, anonymous, start_at, end_at, chapter, romanized_title, language) VALUES
+		(?, ?, ?, ?, datetime('now'), ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?);`
+
+	selectSongSequence = `
+		SELECT MAX(COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'songs'), 0),
+			COALESCE((SELECT MAX(id) FROM songs), 0));`
+
+	updateSongSequence = `
+		UPDATE sqlite_sequence SET seq = ? WHERE name = 'songs';`
+
+	insertSongSequence = `
+		INSERT INTO sqlite_sequence (name, seq) VALUES ('songs', ?);`
+
+	insertSongDetails = `
+		INSERT OR REPLACE INTO song_details VALUES
+		(?, ?, ?, ?, ?, datetime('now'));`
+
+	insertSongLyrics = `
+		INSERT OR REPLACE INTO song_lyrics VALUES
+		(?, ?, ?, ?, ?, datetime('now'));`
+
+	insertSongThumbnail = `
+		INSERT INTO song_thumbnails VALUES
+		(?, ?, ?, ?, ?, ?);`
+
+	deleteSongThumbnails = `
+		DELETE FROM song_thumbnails WHERE service = ? AND service_id = ?;`
+
+	insertZone = `
+		INSERT INTO zones VALUES
+		(NULL, ?, ?, datetime('now'));`
+
+	updateSongSkipped = `
+		UPDATE songs SET skipped = 1 WHERE id = ?;`
+
+	insertAchievement = `
+		INSERT OR IGNORE INTO user_achievements VALUES
+		(?, ?, datetime('now'));`
+
+	upsertUserExempt = `
+		INSERT INTO user_policies (user_id, exempt, update_date) VALUES
+		(?, ?, datetime('now'))
+		ON CONFLICT (user_id) DO UPDATE SET exempt = excluded.exempt, update_date = excluded.update_date;`
+
+	insertQueuePreset = `
+		INSERT OR REPLACE INTO queue_presets VALUES
+		(?, ?, ?, ?, ?, ?, ?, datetime('now'));`
+
+	queryQueuePreset = `
+		SELECT name, algorithm, max_minutes, submission_window, fallback_code, open_at, close_at
+		FROM queue_presets WHERE name = ?;`
+
+	queryQueuePresets = `
+		SELECT name, algorithm, max_minutes, submission_window, fallback_code, open_at, close_at
+		FROM queue_presets ORDER BY name;`
+
+	queryPlaylistFallbackCandidates = `
+		SELECT shared_playlist_songs.title, shared_playlist_songs.service, shared_playlist_songs.service_id,
+			shared_playlists.user_id, users.username, users.room_id, COALESCE(song_details.channel, ''),
+			COALESCE((SELECT MAX(songs.date) FROM songs WHERE songs.service = shared_playlist_songs.service
+				AND songs.service_id = shared_playlist_songs.service_id), '') AS last_played
+		FROM shared_playlist_songs
+		JOIN shared_playlists ON shared_playlists.code = shared_playlist_songs.code
+		JOIN users ON users.user_id = shared_playlists.user_id
+		LEFT JOIN song_details ON song_details.service = shared_playlist_songs.service
+			AND song_details.service_id = shared_playlist_songs.service_id
+		WHERE shared_playlist_songs.code = ? AND last_played < ? AND NOT EXISTS (SELECT 1 FROM blocked_songs
+			WHERE blocked_songs.service = shared_playlist_songs.service
+			AND blocked_songs.service_id = shared_playlist_songs.service_id)
+		ORDER BY RANDOM() LIMIT ?;`
+
+	upsertPreferences = `
+		INSERT INTO preferences (user_id, audio_only, start_behavior, notify, update_date) VALUES
+		(?, ?, ?, ?, datetime('now'))
+		ON CONFLICT (user_id) DO UPDATE SET audio_only = excluded.audio_only,
+		start_behavior = excluded.start_behavior, notify = excluded.notify, update_date = excluded.update_date;`
+
+	queryPreferences = `
+		SELECT audio_only, start_behavior, notify FROM preferences WHERE user_id = ?;`
+
+	upsertDisplaySettings = `
+		INSERT INTO display_settings (room_id, party_name, theme_color, banner, show_submitter, romanized_titles,
+			update_date)
+		VALUES (?, ?, ?, ?, ?, ?, datetime('now'))
+		ON CONFLICT (room_id) DO UPDATE SET party_name = excluded.party_name,
+		theme_color = excluded.theme_color, banner = excluded.banner, show_submitter = excluded.show_submitter,
+		romanized_titles = excluded.romanized_titles, update_date = excluded.update_date;`
+
+	queryDisplaySettings = `
+		SELECT room_id, party_name, theme_color, banner, show_submitter, romanized_titles FROM display_settings
+		WHERE room_id IN (?, 0) ORDER BY room_id DESC LIMIT 1;`
+
+	queryRoomExists = `
+		SELECT COUNT(*) FROM rooms WHERE room_id = ?;`
+
+	queryUserExempt = `
+		SELECT exempt FROM user_policies WHERE user_id = ?;`
+
+	queryExemptUsers = `
+		SELECT users.* FROM user_policies
+		JOIN users ON users.user_id = user_policies.user_id
+		WHERE user_policies.exempt = 1 ORDER BY users.user_id;`
+
+	queryAchievements = `
+		SELECT user_id, achievement, earn_date FROM user_achievements
+		WHERE user_id = ? ORDER BY earn_date, achievement;`
+
+	queryHistoryTotals = `
+		WITH history AS (
+			SELECT * FROM songs WHERE source != ?),
+		nights AS (
+			SELECT user_id, date, date(date, 'localtime', '-6 hours') AS night FROM history),
+		totals AS (
+			SELECT user_id, COUNT(*) AS songs, SUM(skipped) AS skips FROM history GROUP BY user_id),
+		openers AS (
+			SELECT user_id, COUNT(*) AS opened FROM
+				(SELECT user_id, MIN(date) FROM nights GROUP BY night)
+			GROUP BY user_id),
+		best_nights AS (
+			SELECT user_id, MAX(night_songs) AS best FROM
+				(SELECT user_id, COUNT(*) AS night_songs FROM nights GROUP BY user_id, night)
+			GROUP BY user_id),
+		earned AS (
+			SELECT user_id, COUNT(*) AS achievements FROM user_achievements GROUP BY user_id)
+		SELECT totals.user_id, users.username, totals.songs, totals.skips,
+			COALESCE(openers.opened, 0), COALESCE(best_nights.best, 0),
+			COALESCE(earned.achievements, 0)
+		FROM totals
+		JOIN users ON users.user_id = totals.user_id
+		LEFT JOIN openers ON openers.user_id = totals.user_id
+		LEFT JOIN best_nights ON best_nights.user_id = totals.user_id
+		LEFT JOIN earned ON earned.user_id = totals.user_id
+		ORDER BY totals.songs DESC, totals.user_id;`
+
+	queryFallbackCandidates = `
+		SELECT songs.title, songs.service, songs.service_id, songs.user_id, users.username,
+			songs.room_id, COALESCE(song_details.channel, ''), MAX(songs.date) AS last_played
+		FROM songs
+		JOIN users ON songs.user_id = users.user_id
+		LEFT JOIN song_details ON song_details.service = songs.service
+			AND song_details.service_id = songs.service_id
+		GROUP BY songs.service, songs.service_id
+		HAVING last_played < ? AND NOT EXISTS (SELECT 1 FROM blocked_songs
+			WHERE blocked_songs.service = songs.service AND blocked_songs.service_id = songs.service_id)
+		ORDER BY RANDOM() LIMIT ?;`
+
+	insertReaction = `
+		INSERT OR IGNORE INTO song_reactions VALUES
+		(?, ?, ?, datetime('now'));`
+
+	queryReactionCounts = `
+		SELECT emoji, COUNT(*) AS reactions FROM song_reactions
+		WHERE song_id = ? GROUP BY emoji ORDER BY reactions DESC, emoji;`
+
+	queryTopReactions = `
+		WITH tonight AS (
+			SELECT song_id, emoji, COUNT(*) AS reactions FROM song_reactions
+			WHERE date(react_date, 'localtime', '-6 hours') = date('now', 'localtime', '-6 hours')
+			GROUP BY song_id, emoji)
+		SELECT tonight.emoji, songs.id, songs.title, songs.service, songs.service_id,
+			songs.user_id, users.username, songs.room_id, songs.source, songs.anonymous, tonight.reactions
+		FROM tonight
+		JOIN songs ON songs.id = tonight.song_id
+		JOIN users ON users.user_id = songs.user_id
+		ORDER BY tonight.reactions DESC, songs.id, tonight.emoji;`
+
+	queryRecentSongs = `
+		SELECT songs.id, songs.title, songs.service, songs.service_id,
+			songs.user_id, users.username, songs.room_id, songs.source,
+			COALESCE(songs.raw_title, ''), COALESCE(songs.clean_title, ''), songs.anonymous, songs.date, songs.skipped,
+			songs.outcome, songs.skipped_by, songs.romanized_title, songs.language,
+			COALESCE((SELECT GROUP_CONCAT(skip_votes.user_id) FROM skip_votes WHERE skip_votes.song_id = songs.id), '')
+		FROM songs JOIN users ON songs.user_id = users.user_id
+		WHERE ? = 0 OR (songs.user_id = ? AND songs.anonymous = 0)
+		ORDER BY songs.date DESC, songs.id DESC LIMIT ?;`
+
+	insertHistorySong = `
+		INSERT INTO songs (title, service, service_id, date, user_id, room_id, source, raw_title, clean_title,
+			duration, anonymous, skipped, start_at, end_at, chapter, romanized_title, language)
+		SELECT ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM songs WHERE service = ? AND service_id = ? AND user_id = ? AND date = ?);`
+
+	querySongsBetween = `
+		SELECT songs.id, songs.title, songs.service, songs.service_id,
+			songs.user_id, users.username, songs.room_id, songs.source,
+			COALESCE(songs.raw_title, ''), COALESCE(songs.clean_title, ''), COALESCE(songs.duration, ''),
+			songs.anonymous, songs.date, songs.skipped
+		FROM songs JOIN users ON songs.user_id = users.user_id
+		WHERE songs.date >= ? AND songs.date < ?
+		ORDER BY songs.date, songs.id;`
+
+	insertPartyRecap = `
+		INSERT INTO party_recaps (start_date, end_date, recap) VALUES (?, ?, ?);`
+
+	queryPartyRecap = `
+		SELECT id, recap FROM party_recaps WHERE id = ?;`
+
+	queryLatestPartyRecap = `
+		SELECT id, recap FROM party_recaps ORDER BY id DESC LIMIT 1;`
+
+	insertJingle = `
+		INSERT OR REPLACE INTO jingles VALUES (?, ?, datetime('now'));`
+
+	deleteJingle = `
+		DELETE FROM jingles WHERE name = ?;`
+
+	insertBlockedSong = `
+		INSERT OR IGNORE INTO blocked_songs VALUES (?, ?, datetime('now'));`
+
+	deleteBlockedSong = `
+		DELETE FROM blocked_songs WHERE service = ? AND service_id = ?;`
+
+	queryBlockedSong = `
+		SELECT COUNT(*) FROM blocked_songs WHERE service = ? AND service_id = ?;`
+
+	insertSongTag = `
+		INSERT OR IGNORE INTO song_tags VALUES (?, ?, ?, ?, datetime('now'));`
+
+	deleteSongTag = `
+		DELETE FROM song_tags WHERE service = ? AND service_id = ? AND tag = ? AND user_id = ?;`
+
+	queryTaggedSongs = `
+		SELECT songs.id, songs.title, songs.service, songs.service_id, songs.user_id, users.username,
+			songs.room_id, MAX(songs.date) AS last_played,
+			(SELECT GROUP_CONCAT(DISTINCT all_tags.tag) FROM song_tags AS all_tags
+				WHERE all_tags.service = songs.service AND all_tags.service_id = songs.service_id)
+		FROM songs
+		JOIN users ON songs.user_id = users.user_id
+		WHERE EXISTS (SELECT 1 FROM song_tags WHERE song_tags.service = songs.service
+			AND song_tags.service_id = songs.service_id AND song_tags.tag LIKE ? || '%')
+		GROUP BY songs.service, songs.service_id
+		ORDER BY last_played DESC LIMIT ?;`
+
+	insertScheduledSong = `
+		INSERT INTO scheduled_songs (play_at, schedule) VALUES (?, ?);`
+
+	deleteScheduledSong = `
+		DELETE FROM scheduled_songs WHERE id = ?;`
+
+	queryScheduledSongs = `
+		SELECT id, schedule FROM scheduled_songs ORDER BY play_at, id;`
+
+	queryTaggedFallbackCandidates = `
+		SELECT songs.title, songs.service, songs.service_id, songs.user_id, users.username,
+			songs.room_id, COALESCE(song_details.channel, ''), MAX(songs.date) AS last_played
+		FROM songs
+		JOIN users ON songs.user_id = users.user_id
+		LEFT JOIN song_details ON song_details.service = songs.service
+			AND song_details.service_id = songs.service_id
+		WHERE EXISTS (SELECT 1 FROM song_tags WHERE song_tags.service = songs.service
+			AND song_tags.service_id = songs.service_id AND song_tags.tag = ?)
+		GROUP BY songs.service, songs.service_id
+		HAVING last_played < ? AND NOT EXISTS (SELECT 1 FROM blocked_songs
+			WHERE blocked_songs.service = songs.service AND blocked_songs.service_id = songs.service_id)
+		ORDER BY RANDOM() LIMIT ?;`
+
+	queryJingles = `
+		SELECT name, link FROM jingles ORDER BY name;`
+
+	insertRegisteredPlayer = `
+		INSERT OR REPLACE INTO registered_players VALUES (?, ?, ?, ?, NULL, datetime('now'));`
+
+	deleteRegisteredPlayer = `
+		DELETE FROM registered_players WHERE name = ?;`
+
+	queryRegisteredPlayerByToken = `
+		SELECT name, token, zone, output_device, last_seen FROM registered_players WHERE token = ?;`
+
+	queryRegisteredPlayers = `
+		SELECT name, token, zone, output_device, last_seen FROM registered_players ORDER BY name;`
+
+	updateRegisteredPlayerSeen = `
+		UPDATE registered_players SET last_seen = datetime('now') WHERE name = ?;`
+
+	insertApiKey = `
+		INSERT OR IGNORE INTO api_keys VALUES (?, ?, ?, datetime('now'));`
+
+	deleteApiKey = `
+		DELETE FROM api_keys WHERE name = ?;`
+
+	queryApiKeyByHash = `
+		SELECT name, scope, created FROM api_keys WHERE key_hash = ?;`
+
+	queryApiKeys = `
+		SELECT name, scope, created FROM api_keys ORDER BY name;`
+
+	queryRooms = `
+		SELECT * FROM rooms ORDER BY room_id;`
+
+	deleteSongsBefore = `
+		DELETE FROM songs WHERE date < ?;`
+
+	insertDemoRoom = `
+		INSERT OR IGNORE INTO demo_rooms VALUES (?);`
+
+	demoUserIds = `
+		SELECT user_id FROM users WHERE room_id IN (SELECT room_id FROM demo_rooms)`
+
+	deleteDemoReactions = `
+		DELETE FROM song_reactions WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoSharedPlaylists = `
+		DELETE FROM shared_playlists WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoAchievements = `
+		DELETE FROM user_achievements WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoPolicies = `
+		DELETE FROM user_policies WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoPreferences = `
+		DELETE FROM preferences WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoIdentities = `
+		DELETE FROM external_identities WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoSkipVotes = `
+		DELETE FROM skip_votes WHERE user_id IN (` + demoUserIds + `)
+		OR song_id IN (SELECT id FROM songs WHERE user_id IN (` + demoUserIds + `));`
+
+	deleteDemoSongs = `
+		DELETE FROM songs WHERE user_id IN (` + demoUserIds + `);`
+
+	deleteDemoUsers = `
+		DELETE FROM users WHERE room_id IN (SELECT room_id FROM demo_rooms);`
+
+	deleteDemoDisplaySettings = `
+		DELETE FROM display_settings WHERE room_id IN (SELECT room_id FROM demo_rooms);`
+
+	deleteDemoRooms = `
+		DELETE FROM rooms WHERE room_id IN (SELECT room_id FROM demo_rooms);`
+
+	vacuumDatabase = `
+		VACUUM;`
+
+	analyzeDatabase = `
+		ANALYZE;`
+
+	insertSharedPlaylist = `
+		INSERT INTO shared_playlists VALUES
+		(?, ?, datetime('now'));`
+
+	insertSharedPlaylistSong = `
+		INSERT INTO shared_playlist_songs VALUES
+		(?, ?, ?, ?, ?, ?, ?);`
+
+	querySharedPlaylistSongs = `
+		SELECT title, service, service_id, thumbnail, duration FROM shared_playlist_songs
+		WHERE code = ? ORDER BY position;`
+
+	querySharedPlaylistExists = `
+		SELECT COUNT(*) FROM shared_playlists WHERE code = ?;`
+
+	deleteZone = `
+		DELETE FROM zones WHERE zone_id = ?;`
+
+	queryZones = `
+		SELECT * FROM zones ORDER BY zone_id;`
+
+	queryZoneById = `
+		SELECT * FROM zones WHERE zone_id = ?;`
 
 	insertUser = `
 		INSERT INTO users VALUES
@@ -62,243 +416,2755 @@ const (
 	queryUserById = `
 		SELECT * FROM users WHERE user_id = ?;`
 
-	queryRoomByName = `
-		SELECT * FROM rooms where room_name = ?;`
+	queryUserByName = `
+		SELECT * FROM users WHERE username = ? AND room_id = ?
+		ORDER BY user_id LIMIT 1;`
+
+	querySongById = `
+		SELECT songs.id, songs.title, songs.service, songs.service_id,
+			songs.user_id, users.username, songs.room_id, songs.source,
+			COALESCE(songs.raw_title, ''), COALESCE(songs.clean_title, ''), songs.anonymous,
+			songs.start_at, songs.end_at, songs.chapter, songs.romanized_title, songs.language
+		FROM songs JOIN users ON songs.user_id = users.user_id
+		WHERE songs.id = ?;`
+
+	querySongsByIds = `
+		SELECT songs.id, songs.title, songs.service, songs.service_id,
+			songs.user_id, users.username, songs.room_id, songs.source,
+			COALESCE(songs.raw_title, ''), COALESCE(songs.clean_title, ''), songs.anonymous,
+			songs.start_at, songs.end_at, songs.chapter, songs.romanized_title, songs.language
+		FROM songs JOIN users ON songs.user_id = users.user_id
+		WHERE songs.id IN (%s);`
+
+	insertIdentity = `
+		INSERT OR REPLACE INTO external_identities VALUES (?, ?, ?, datetime('now'));`
+
+	deleteIdentity = `
+		DELETE FROM external_identities WHERE provider = ? AND external_id = ?;`
+
+	queryIdentity = `
+		SELECT provider, external_id, user_id, link_date FROM external_identities
+		WHERE provider = ? AND external_id = ?;`
+
+	queryUserIdentities = `
+		SELECT provider, external_id, user_id, link_date FROM external_identities
+		WHERE user_id = ? ORDER BY provider, external_id;`
+
+	updateSongOutcome = `
+		UPDATE songs SET outcome = ?, skipped_by = ? WHERE id = ?;`
+
+	insertSkipVote = `
+		INSERT OR IGNORE INTO skip_votes VALUES (?, ?);`
+
+	queryOutcomeCounts = `
+		SELECT outcome, COUNT(*) FROM songs WHERE ? = 0 OR user_id = ? GROUP BY outcome;`
+
+	querySkippers = `
+		SELECT skipper_id, name, COUNT(*) AS skips FROM (
+			SELECT skip_votes.user_id AS skipper_id, users.username AS name FROM skip_votes
+			JOIN songs ON songs.id = skip_votes.song_id
+			JOIN users ON users.user_id = skip_votes.user_id
+			WHERE songs.user_id = ?
+			UNION ALL
+			SELECT 0, skipped_by FROM songs WHERE user_id = ? AND skipped_by != '')
+		GROUP BY skipper_id, name
+		ORDER BY skips DESC, name;`
+
+	querySongDetails = `
+		SELECT description, channel, view_count, fetch_date FROM song_details
+		WHERE service = ? AND service_id = ?;`
+
+	querySongLyrics = `
+		SELECT provider, plain, synced, fetch_date FROM song_lyrics
+		WHERE service = ? AND service_id = ?;`
+
+	querySongThumbnails = `
+		SELECT quality, url, width, height FROM song_thumbnails
+		WHERE service = ? AND service_id = ?;`
+
+	querySourceCounts = `
+		SELECT source, COUNT(*) FROM songs GROUP BY source;`
+
+	queryRoomByName = `
+		SELECT * FROM rooms where room_name = ?;`
+
+	updateUsername = `
+		UPDATE users SET username=?
+		WHERE user_id=?;`
+)
+
+const (
+	// layout of the timestamps written by sqlite's datetime('now')
+	sqliteTimeFormat = "2006-01-02 15:04:05"
+
+	// path of a database kept in memory, such as for tests. It's gone once
+	// the manager is closed.
+	InMemory = ":memory:"
+
+	// milliseconds a read on the replica waits for a write to finish before
+	// giving up
+	replicaBusyTimeout = 5000
+
+	// name of the party shown on screens until one is set
+	defaultPartyName = "yt-box"
+)
+
+type SqliteManager struct {
+	db      sqlConn         // runs the queries. Either the database or a transaction
+	root    *sql.DB         // the open database
+	tx      *sql.Tx         // transaction the manager runs in. Nil outside of one
+	lock    rwLocker        // lock on the database
+	replica *sql.DB         // read-only connection for statistics and history. Nil if none was opened
+	ctx     context.Context // context the queries give up with. Nil if they run to the end
+}
+
+/*
+ * Runs queries against the database or within a transaction
+ */
+type sqlConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+/*
+ * Runs queries with or without a context
+ */
+type contextConn interface {
+	sqlConn
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+/*
+ * Runs queries that give up once a context is done
+ */
+type ctxConn struct {
+	ctx  context.Context // context the queries give up with
+	conn contextConn     // the database or transaction running the queries
+}
+
+func (c ctxConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	res, err := c.conn.ExecContext(c.ctx, query, args...)
+	return res, contextErr(c.ctx, err)
+}
+
+func (c ctxConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.conn.QueryContext(c.ctx, query, args...)
+	return rows, contextErr(c.ctx, err)
+}
+
+func (c ctxConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c ctxConn) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.conn.PrepareContext(c.ctx, query)
+	return stmt, contextErr(c.ctx, err)
+}
+
+/*
+ * A transaction that's rolled back once a context is done
+ */
+type ctxTx struct {
+	ctxConn
+	tx *sql.Tx // the transaction
+}
+
+func (t ctxTx) Commit() error   { return contextErr(t.ctx, t.tx.Commit()) }
+func (t ctxTx) Rollback() error { return t.tx.Rollback() }
+
+/*
+ * A transaction started by a manager method
+ */
+type sqlTx interface {
+	sqlConn
+	Commit() error
+	Rollback() error
+}
+
+/*
+ * Lock on the database. Managers running in a transaction don't lock, since
+ * the manager that started the transaction holds the lock until it ends.
+ */
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+/*
+ * A transaction nested in the transaction of a WithTx call. Committing and
+ * rolling back are left to the outer transaction.
+ */
+type nestedTx struct {
+	sqlConn
+}
+
+func (nestedTx) Commit() error   { return nil }
+func (nestedTx) Rollback() error { return nil }
+
+/*
+ * Clean up resources used by the database manager
+ */
+func (mgr *SqliteManager) Close() {
+	if mgr.replica != nil {
+		mgr.replica.Close()
+	}
+	mgr.root.Close()
+}
+
+/*
+ * Returns a manager whose queries give up once the context is done. It shares
+ * the database, lock and any transaction with this manager. A method making
+ * several changes runs them in a transaction, which is rolled back as a whole
+ * if the context ends before it's committed.
+ */
+func (mgr *SqliteManager) WithContext(ctx context.Context) DbManager {
+	scoped := *mgr
+	scoped.ctx = ctx
+	if mgr.tx != nil {
+		scoped.db = scoped.conn(mgr.tx)
+	} else {
+		scoped.db = scoped.conn(mgr.root)
+	}
+
+	return &scoped
+}
+
+/*
+ * Returns the connection with queries that give up with the manager's
+ * context, if it has one
+ */
+func (mgr *SqliteManager) conn(conn contextConn) sqlConn {
+	if mgr.ctx == nil {
+		return conn
+	}
+
+	return ctxConn{ctx: mgr.ctx, conn: conn}
+}
+
+/*
+ * Returns the context transactions are started with
+ */
+func (mgr *SqliteManager) context() context.Context {
+	if mgr.ctx == nil {
+		return context.Background()
+	}
+
+	return mgr.ctx
+}
+
+/*
+ * Run fn in a transaction. The changes fn makes through the manager it's
+ * given are committed together if fn returns nil and rolled back otherwise.
+ * The database is locked until fn returns, so fn must not use this manager.
+ */
+func (mgr *SqliteManager) WithTx(fn func(tx DbManager) error) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	// already running in a transaction
+	if mgr.tx != nil {
+		return fn(mgr)
+	}
+
+	tx, err := mgr.root.BeginTx(mgr.context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return contextErr(mgr.ctx, err)
+	}
+
+	inner := &SqliteManager{root: mgr.root, tx: tx, lock: noLock{}, ctx: mgr.ctx}
+	inner.db = inner.conn(tx)
+	if err = fn(inner); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return contextErr(mgr.ctx, tx.Commit())
+}
+
+/*
+ * Start a transaction for a method that makes several changes. Within a
+ * WithTx call the changes become part of the outer transaction.
+ */
+func (mgr *SqliteManager) begin() (sqlTx, error) {
+	if mgr.tx != nil {
+		return nestedTx{mgr.db}, nil
+	}
+
+	tx, err := mgr.root.BeginTx(mgr.context(), nil)
+	if err != nil || mgr.ctx == nil {
+		return tx, contextErr(mgr.ctx, err)
+	}
+
+	return ctxTx{ctxConn: ctxConn{ctx: mgr.ctx, conn: tx}, tx: tx}, nil
+}
+
+/*
+ * Returns the context's error in place of the error a call failed with if the
+ * context ended, since that's why it failed. A transaction rolled back by its
+ * context otherwise only reports that it's already done.
+ */
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+/*
+ * Initialize the sqlite database
+ */
+func (mgr *SqliteManager) Init(dbPath string) error {
+	fil, err := os.Open(dbPath)
+	shouldFound := err != nil
+
+	mgr.root, err = sql.Open("sqlite3", dbPath)
+	mgr.db = mgr.root
+	if err != nil {
+		log.Fatalf("Failed to open database connect with error: %v", err)
+		return err
+	}
+
+	// every connection to an in-memory database opens an empty one of its
+	// own, so all of the queries have to share a single connection
+	if dbPath == InMemory {
+		mgr.root.SetMaxOpenConns(1)
+	}
+
+	_, err = mgr.root.Exec(enableForeignKeySupport)
+	if err != nil {
+		log.Fatalf("Error enabling foreign key support: %v", err)
+		return err
+	}
+
+	if shouldFound {
+		if err = foundDatabase(mgr.root); err != nil {
+			return err
+		}
+	} else {
+		fil.Close()
+	}
+
+	if err = upgradeDatabase(mgr.root); err != nil {
+		return err
+	}
+
+	mgr.lock = new(sync.RWMutex)
+	return nil
+}
+
+/*
+ * Open a read-only connection for the heavy reads: statistics, history and
+ * the auto dj's picks. The path can be a replica of the database, which may
+ * lag behind it, or the database itself for a second connection. Reads on it
+ * don't wait on submissions being written.
+ */
+func (mgr *SqliteManager) OpenReadReplica(path string) error {
+	if path == InMemory {
+		return errors.New("An in-memory database can't be read from a second connection.")
+	}
+
+	replica, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout="+strconv.Itoa(replicaBusyTimeout))
+	if err != nil {
+		log.Printf("Failed to open read replica %s with error: %v", path, err)
+		return err
+	}
+
+	if err = replica.Ping(); err != nil {
+		log.Printf("Failed to connect to read replica %s with error: %v", path, err)
+		replica.Close()
+		return err
+	}
+
+	mgr.replica = replica
+	return nil
+}
+
+/*
+ * Returns the connection to run a heavy read on and the lock to hold while
+ * running it. Reads go to the read replica without locking out writes when
+ * one is open, unless the manager runs in a transaction that has to see its
+ * own changes.
+ */
+func (mgr *SqliteManager) reader() (sqlConn, rwLocker) {
+	if mgr.replica != nil && mgr.tx == nil {
+		return mgr.conn(mgr.replica), noLock{}
+	}
+
+	return mgr.db, mgr.lock
+}
+
+/*
+ * Check that the database at the path opens and isn't corrupt without
+ * changing it, so it can be checked while a running server has it open. A
+ * missing database passes, since it's created on start up.
+ */
+func CheckDatabase(dbPath string) error {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	root, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var result string
+	if err = root.QueryRow(checkIntegrity).Scan(&result); err != nil {
+		return err
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	return nil
+}
+
+/*
+ * Returns the version of the schema this build creates, which is the number
+ * of upgrades made to it since the first release
+ */
+func SchemaVersion() (int, error) {
+	upgrades, err := schemaStatements("upgrades")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(upgrades), nil
+}
+
+/*
+ * Copy the database at the path to a new file at the destination. The copy is
+ * consistent even while a running server writes to the database.
+ */
+func CopyDatabase(dbPath string, dest string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+
+	root, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	_, err = root.Exec(copyDatabase, dest)
+	return err
+}
+
+/*
+ * Returns the number of rows in each table of the database at the path
+ */
+func CountRows(dbPath string) (map[string]int64, error) {
+	root, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	rows, err := root.Query(selectTableNames)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		var count int64
+		if err = root.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s;", table)).Scan(&count); err != nil {
+			return nil, err
+		}
+		counts[table] = count
+	}
+
+	return counts, nil
+}
+
+/*
+ * Add a new song to the database
+ */
+func (mgr *SqliteManager) AddSong(song *cmpb.Song) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(insertSong)
+	if err != nil {
+		log.Printf("Error preparing add song statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(mgr.context(), song.Title, song.Service, song.ServiceId, song.UserId, song.RoomId,
+		song.Source, song.RawTitle, song.CleanTitle, song.GetMetadata().GetDuration(), song.Anonymous, song.StartAt,
+		song.EndAt, song.Chapter, song.RomanizedTitle, song.Language)
+	if err != nil {
+		log.Printf("Error adding new song: %v", err)
+		log.Printf("Attempted to add song: %v", song)
+		return err
+	}
+
+	songId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of new song: %v", err)
+		return err
+	}
+	song.SongId = uint32(songId)
+	log.Printf("Added new song to db: { %v}", song)
+
+	return nil
+}
+
+/*
+ * Add a new user to the database
+ */
+func (mgr *SqliteManager) AddUser(username string, roomId uint32) (*UserData, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(insertUser)
+	if err != nil {
+		log.Printf("Error preparing add user statement: %v", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(mgr.context(), username, roomId)
+	if err != nil {
+		log.Printf("Error adding new user: %v", err)
+		return nil, err
+	}
+
+	userId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of new user: %v", err)
+		return nil, err
+	}
+	log.Printf("Added new user: {name: %s, id: %d, room: %d}", username, userId, roomId)
+
+	// todo: fix this. If there's an error in the retrieval, then the user gets
+	// created, but we return an error.
+	return mgr.unsyncGetUserById(uint32(userId))
+}
+
+/*
+ * Query for the user data of the given user id
+ */
+func (mgr *SqliteManager) GetUserById(userId uint32) (*UserData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	return mgr.unsyncGetUserById(userId)
+}
+
+/*
+ * Query for the user data of the given user id. This is a helper method that
+ * relies on other callers to already have the mutex lock.
+ */
+func (mgr *SqliteManager) unsyncGetUserById(userId uint32) (*UserData, error) {
+	userData := new(UserData)
+
+	err := mgr.db.QueryRow(queryUserById, userId).Scan(&userData.User.UserId,
+		&userData.User.Username, &userData.User.RoomId, &userData.LoggedIn, &userData.LastAccess)
+
+	// if an error occurred or there was no result, then return nil
+	if err != nil {
+		userData = nil
+		return nil, err
+	}
+
+	return userData, nil
+}
+
+/*
+ * Query for the oldest user with the given name in a room
+ */
+func (mgr *SqliteManager) GetUserByName(username string, roomId uint32) (*UserData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	userData := new(UserData)
+	err := mgr.db.QueryRow(queryUserByName, username, roomId).Scan(&userData.User.UserId,
+		&userData.User.Username, &userData.User.RoomId, &userData.LoggedIn, &userData.LastAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return userData, nil
+}
+
+/*
+ * Updates the username of an existing user.
+ */
+func (mgr *SqliteManager) UpdateUsername(username string, userId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(updateUsername)
+	if err != nil {
+		log.Printf("Error preparing update username statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(mgr.context(), username, userId)
+	if err != nil {
+		log.Printf("Error updating username: %v", err)
+		return err
+	}
+
+	log.Printf("Updated username: {name: %s, id: %d}", username, userId)
+
+	return nil
+}
+
+/*
+ * Adds a new room with given name
+ */
+func (mgr *SqliteManager) AddRoom(roomName string) (*RoomData, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	stmt, err := mgr.db.Prepare(insertRoom)
+	if err != nil {
+		log.Printf("Error preparing add room statement: %v", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(mgr.context(), roomName)
+	if err != nil {
+		log.Printf("Error adding new room: %v", err)
+		return nil, err
+	}
+
+	roomId, err := res.LastInsertId()
+	log.Printf("Added new room: {name: %s, id: %d}", roomName, roomId)
+
+	return mgr.unsyncGetRoomByName(roomName)
+}
+
+func (mgr *SqliteManager) GetRoomByName(roomName string) (*RoomData, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	return mgr.unsyncGetRoomByName(roomName)
+}
+
+func (mgr *SqliteManager) unsyncGetRoomByName(roomName string) (*RoomData, error) {
+	roomData := new(RoomData)
+
+	err := mgr.db.QueryRow(queryRoomByName, roomName).Scan(&roomData.Room.Id,
+		&roomData.Room.Name, &roomData.CreateDate, &roomData.LastAccess)
+
+	if err != nil {
+		roomData = nil
+		return nil, err
+	}
+
+	roomData.Room.Err = &bepb.Error{Success: true}
+	return roomData, nil
+}
+
+/*
+ * Query for a previously submitted song by its id
+ */
+func (mgr *SqliteManager) GetSongById(songId uint32) (*cmpb.Song, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	song := new(cmpb.Song)
+	var service int32
+	var source int32
+
+	err := mgr.db.QueryRow(querySongById, songId).Scan(&song.SongId, &song.Title, &service,
+		&song.ServiceId, &song.UserId, &song.Username, &song.RoomId, &source, &song.RawTitle, &song.CleanTitle,
+		&song.Anonymous, &song.StartAt, &song.EndAt, &song.Chapter, &song.RomanizedTitle, &song.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	song.Service = cmpb.ServiceType(service)
+	song.Source = cmpb.SubmissionSource(source)
+	return song, nil
+}
+
+/*
+ * Count the songs ever submitted through each interface
+ */
+func (mgr *SqliteManager) GetSourceCounts() (map[cmpb.SubmissionSource]uint32, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(querySourceCounts)
+	if err != nil {
+		log.Printf("Error querying submission sources: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[cmpb.SubmissionSource]uint32)
+	for rows.Next() {
+		var source int32
+		var count uint32
+
+		if err = rows.Scan(&source, &count); err != nil {
+			log.Printf("Error reading submission source count: %v", err)
+			return nil, err
+		}
+		counts[cmpb.SubmissionSource(source)] = count
+	}
+
+	return counts, rows.Err()
+}
+
+/*
+ * Cache the extended metadata of a song. Replaces any details that were
+ * previously cached for the song.
+ */
+func (mgr *SqliteManager) AddSongDetails(service cmpb.ServiceType, serviceId string, details *bepb.SongDetails) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.begin()
+	if err != nil {
+		log.Printf("Error starting song details transaction: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(insertSongDetails, service, serviceId, details.Description, details.Channel,
+		details.ViewCount)
+	if err != nil {
+		log.Printf("Error adding song details: %v", err)
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(deleteSongThumbnails, service, serviceId)
+	if err != nil {
+		log.Printf("Error clearing old song thumbnails: %v", err)
+		tx.Rollback()
+		return err
+	}
+
+	for _, thumbnail := range details.Thumbnails {
+		_, err = tx.Exec(insertSongThumbnail, service, serviceId, thumbnail.Quality, thumbnail.Url,
+			thumbnail.Width, thumbnail.Height)
+		if err != nil {
+			log.Printf("Error adding song thumbnail: %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Query for the cached extended metadata of a song
+ */
+func (mgr *SqliteManager) GetSongDetails(service cmpb.ServiceType, serviceId string) (*SongDetailsData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	data := new(SongDetailsData)
+	err := mgr.db.QueryRow(querySongDetails, service, serviceId).Scan(&data.Details.Description,
+		&data.Details.Channel, &data.Details.ViewCount, &data.FetchDate)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := mgr.db.Query(querySongThumbnails, service, serviceId)
+	if err != nil {
+		log.Printf("Error querying song thumbnails: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		thumbnail := new(bepb.Thumbnail)
+		err = rows.Scan(&thumbnail.Quality, &thumbnail.Url, &thumbnail.Width, &thumbnail.Height)
+		if err != nil {
+			log.Printf("Error reading song thumbnail: %v", err)
+			return nil, err
+		}
+		data.Details.Thumbnails = append(data.Details.Thumbnails, thumbnail)
+	}
+
+	return data, rows.Err()
+}
+
+/*
+ * Cache the lyrics of a song. Replaces any lyrics that were previously cached
+ * for the song.
+ */
+func (mgr *SqliteManager) AddLyrics(service cmpb.ServiceType, serviceId string, lyrics *bepb.Lyrics) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(insertSongLyrics, service, serviceId, lyrics.Provider, lyrics.Plain, lyrics.Synced)
+	if err != nil {
+		log.Printf("Error adding song lyrics: %v", err)
+	}
+
+	return err
+}
+
+/*
+ * Query for the cached lyrics of a song
+ */
+func (mgr *SqliteManager) GetLyrics(service cmpb.ServiceType, serviceId string) (*LyricsData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	data := new(LyricsData)
+	err := mgr.db.QueryRow(querySongLyrics, service, serviceId).Scan(&data.Lyrics.Provider,
+		&data.Lyrics.Plain, &data.Lyrics.Synced, &data.FetchDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+/*
+ * Adds a new player zone with the given name
+ */
+func (mgr *SqliteManager) AddZone(zoneName string, shared bool) (*ZoneData, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(insertZone, zoneName, shared)
+	if err != nil {
+		log.Printf("Error adding new zone: %v", err)
+		return nil, err
+	}
+
+	zoneId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of new zone: %v", err)
+		return nil, err
+	}
+	log.Printf("Added new zone: {name: %s, id: %d, shared: %t}", zoneName, zoneId, shared)
+
+	zoneData := new(ZoneData)
+	err = mgr.db.QueryRow(queryZoneById, zoneId).Scan(&zoneData.Zone.Id, &zoneData.Zone.Name,
+		&zoneData.Zone.Shared, &zoneData.CreateDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return zoneData, nil
+}
+
+/*
+ * Removes the player zone with the given id
+ */
+func (mgr *SqliteManager) RemoveZone(zoneId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(deleteZone, zoneId)
+	if err != nil {
+		log.Printf("Error removing zone %d: %v", zoneId, err)
+		return err
+	}
+
+	log.Printf("Removed zone: %d", zoneId)
+	return nil
+}
+
+/*
+ * Query for all of the player zones
+ */
+func (mgr *SqliteManager) GetZones() ([]*ZoneData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryZones)
+	if err != nil {
+		log.Printf("Error querying zones: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := make([]*ZoneData, 0)
+	for rows.Next() {
+		zoneData := new(ZoneData)
+		err = rows.Scan(&zoneData.Zone.Id, &zoneData.Zone.Name, &zoneData.Zone.Shared, &zoneData.CreateDate)
+		if err != nil {
+			log.Printf("Error reading zone: %v", err)
+			return nil, err
+		}
+		zones = append(zones, zoneData)
+	}
+
+	return zones, rows.Err()
+}
+
+/*
+ * Get the most recently submitted songs, newest first
+ */
+func (mgr *SqliteManager) GetRecentSongs(limit int) ([]*HistoryData, error) {
+	return mgr.GetHistory(0, limit)
+}
+
+/*
+ * Get the most recently submitted songs of a user, or of everyone for user id
+ * zero, newest first. A user's anonymous songs are left out of their own.
+ */
+func (mgr *SqliteManager) GetHistory(userId uint32, limit int) ([]*HistoryData, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryRecentSongs, userId, userId, limit)
+	if err != nil {
+		log.Printf("Error querying recent songs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]*HistoryData, 0)
+	for rows.Next() {
+		entry := new(HistoryData)
+		var service int32
+		var source int32
+		var outcome int32
+		var voters string
+
+		err = rows.Scan(&entry.Song.SongId, &entry.Song.Title, &service, &entry.Song.ServiceId,
+			&entry.Song.UserId, &entry.Song.Username, &entry.Song.RoomId, &source, &entry.Song.RawTitle,
+			&entry.Song.CleanTitle, &entry.Song.Anonymous, &entry.Date, &entry.Skipped, &outcome, &entry.SkippedBy,
+			&entry.Song.RomanizedTitle, &entry.Song.Language, &voters)
+		if err != nil {
+			log.Printf("Error reading recent song: %v", err)
+			return nil, err
+		}
+
+		entry.Song.Service = cmpb.ServiceType(service)
+		entry.Song.Source = cmpb.SubmissionSource(source)
+		entry.Outcome = bepb.SongOutcome(outcome)
+		for _, voter := range strings.Split(voters, ",") {
+			if voterId, err := strconv.ParseUint(voter, 10, 32); err == nil {
+				entry.Voters = append(entry.Voters, uint32(voterId))
+			}
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+/*
+ * Add a song to the history as submitted at the given time, such as when
+ * restoring a snapshot. Returns false if the user's submission of the song at
+ * that time was already recorded.
+ */
+func (mgr *SqliteManager) AddHistorySong(song *cmpb.Song, date time.Time, skipped bool) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	submitted := date.UTC().Format(sqliteTimeFormat)
+	res, err := mgr.db.Exec(insertHistorySong, song.Title, song.Service, song.ServiceId, submitted, song.UserId,
+		song.RoomId, song.Source, song.RawTitle, song.CleanTitle, song.GetMetadata().GetDuration(), song.Anonymous,
+		skipped, song.StartAt, song.EndAt, song.Chapter, song.RomanizedTitle, song.Language, song.Service,
+		song.ServiceId, song.UserId, submitted)
+	if err != nil {
+		log.Printf("Error adding song to history: %v", err)
+		return false, err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of history songs added: %v", err)
+		return false, err
+	}
+
+	if added == 0 {
+		return false, nil
+	}
+
+	songId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of history song: %v", err)
+		return false, err
+	}
+
+	song.SongId = uint32(songId)
+	return true, nil
+}
+
+/*
+ * Get the songs submitted from the start time up to the end time, oldest
+ * first. The songs' metadata only has their durations.
+ */
+func (mgr *SqliteManager) GetSongsBetween(start time.Time, end time.Time) ([]*HistoryData, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(querySongsBetween, start.UTC().Format(sqliteTimeFormat),
+		end.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		log.Printf("Error querying songs between %v and %v: %v", start, end, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := make([]*HistoryData, 0)
+	for rows.Next() {
+		entry := new(HistoryData)
+		entry.Song.Metadata = new(cmpb.Metadata)
+		var service int32
+		var source int32
+
+		err = rows.Scan(&entry.Song.SongId, &entry.Song.Title, &service, &entry.Song.ServiceId,
+			&entry.Song.UserId, &entry.Song.Username, &entry.Song.RoomId, &source, &entry.Song.RawTitle,
+			&entry.Song.CleanTitle, &entry.Song.Metadata.Duration, &entry.Song.Anonymous, &entry.Date, &entry.Skipped)
+		if err != nil {
+			log.Printf("Error reading song: %v", err)
+			return nil, err
+		}
+
+		entry.Song.Service = cmpb.ServiceType(service)
+		entry.Song.Source = cmpb.SubmissionSource(source)
+		songs = append(songs, entry)
+	}
+
+	return songs, rows.Err()
+}
+
+/*
+ * Save the recap of a party and set its id
+ */
+func (mgr *SqliteManager) SaveRecap(recap *bepb.PartyRecap) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	blob, err := proto.Marshal(recap)
+	if err != nil {
+		log.Printf("Error serializing party recap: %v", err)
+		return err
+	}
+
+	res, err := mgr.db.Exec(insertPartyRecap, time.Unix(recap.StartedAt, 0).UTC().Format(sqliteTimeFormat),
+		time.Unix(recap.EndedAt, 0).UTC().Format(sqliteTimeFormat), blob)
+	if err != nil {
+		log.Printf("Error saving party recap: %v", err)
+		return err
+	}
+
+	recapId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of party recap: %v", err)
+		return err
+	}
+
+	recap.Id = uint32(recapId)
+	log.Printf("Saved party recap: {id: %d, songs: %d}", recap.Id, recap.TotalSongs)
+	return nil
+}
+
+/*
+ * Query for a party recap by its id, or the latest one if the id is zero
+ */
+func (mgr *SqliteManager) GetRecap(recapId uint32) (*bepb.PartyRecap, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var row *sql.Row
+	if recapId == 0 {
+		row = mgr.db.QueryRow(queryLatestPartyRecap)
+	} else {
+		row = mgr.db.QueryRow(queryPartyRecap, recapId)
+	}
+
+	var blob []byte
+	if err := row.Scan(&recapId, &blob); err != nil {
+		return nil, err
+	}
+
+	recap := new(bepb.PartyRecap)
+	if err := proto.Unmarshal(blob, recap); err != nil {
+		log.Printf("Error reading party recap %d: %v", recapId, err)
+		return nil, err
+	}
+
+	recap.Id = recapId
+	return recap, nil
+}
+
+/*
+ * Save a jingle. Replaces any jingle saved under the same name.
+ */
+func (mgr *SqliteManager) SaveJingle(jingle *bepb.Jingle) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(insertJingle, jingle.Name, jingle.Link); err != nil {
+		log.Printf("Error saving jingle %s: %v", jingle.Name, err)
+		return err
+	}
+
+	log.Printf("Saved jingle: {name: %s, link: %s}", jingle.Name, jingle.Link)
+	return nil
+}
+
+/*
+ * Remove a jingle. Returns false if there was no jingle by that name.
+ */
+func (mgr *SqliteManager) RemoveJingle(name string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(deleteJingle, name)
+	if err != nil {
+		log.Printf("Error removing jingle %s: %v", name, err)
+		return false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of jingles removed: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Get all the saved jingles ordered by name
+ */
+func (mgr *SqliteManager) GetJingles() ([]*bepb.Jingle, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryJingles)
+	if err != nil {
+		log.Printf("Error querying jingles: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	jingles := make([]*bepb.Jingle, 0)
+	for rows.Next() {
+		jingle := new(bepb.Jingle)
+		if err = rows.Scan(&jingle.Name, &jingle.Link); err != nil {
+			log.Printf("Error reading jingle: %v", err)
+			return nil, err
+		}
+		jingles = append(jingles, jingle)
+	}
+
+	return jingles, rows.Err()
+}
+
+/*
+ * Save a registered player. Replaces any player registered under the same
+ * name.
+ */
+func (mgr *SqliteManager) SaveRegisteredPlayer(player *bepb.RegisteredPlayer) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(insertRegisteredPlayer, player.Name, player.Token, player.Zone, player.OutputDevice)
+	if err != nil {
+		log.Printf("Error saving registered player %s: %v", player.Name, err)
+		return err
+	}
+
+	log.Printf("Registered player: {name: %s, zone: %s, output device: %s}", player.Name, player.Zone,
+		player.OutputDevice)
+	return nil
+}
+
+/*
+ * Remove a registered player. Returns false if no player was registered
+ * under the name.
+ */
+func (mgr *SqliteManager) RemoveRegisteredPlayer(name string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(deleteRegisteredPlayer, name)
+	if err != nil {
+		log.Printf("Error removing registered player %s: %v", name, err)
+		return false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of registered players removed: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Query for the registered player with the token
+ */
+func (mgr *SqliteManager) GetRegisteredPlayerByToken(token string) (*bepb.RegisteredPlayer, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	return scanRegisteredPlayer(mgr.db.QueryRow(queryRegisteredPlayerByToken, token))
+}
+
+/*
+ * Get all the registered players ordered by name
+ */
+func (mgr *SqliteManager) GetRegisteredPlayers() ([]*bepb.RegisteredPlayer, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryRegisteredPlayers)
+	if err != nil {
+		log.Printf("Error querying registered players: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := make([]*bepb.RegisteredPlayer, 0)
+	for rows.Next() {
+		player, err := scanRegisteredPlayer(rows)
+		if err != nil {
+			log.Printf("Error reading registered player: %v", err)
+			return nil, err
+		}
+		players = append(players, player)
+	}
+
+	return players, rows.Err()
+}
+
+/*
+ * Record that a registered player connected just now
+ */
+func (mgr *SqliteManager) TouchRegisteredPlayer(name string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(updateRegisteredPlayerSeen, name); err != nil {
+		log.Printf("Error updating when player %s was last seen: %v", name, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Read a registered player from a row
+ */
+func scanRegisteredPlayer(row rowScanner) (*bepb.RegisteredPlayer, error) {
+	player := new(bepb.RegisteredPlayer)
+	var lastSeen sql.NullTime
+
+	err := row.Scan(&player.Name, &player.Token, &player.Zone, &player.OutputDevice, &lastSeen)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastSeen.Valid {
+		player.LastSeen = lastSeen.Time.Unix()
+	}
+
+	return player, nil
+}
+
+/*
+ * Save an api key under a hash of the key. Returns false if a key already has
+ * the name.
+ */
+func (mgr *SqliteManager) SaveApiKey(key *bepb.ApiKey, keyHash string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(insertApiKey, key.Name, keyHash, key.Scope)
+	if err != nil {
+		log.Printf("Error saving api key %s: %v", key.Name, err)
+		return false, err
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of api keys saved: %v", err)
+		return false, err
+	}
+
+	if saved > 0 {
+		log.Printf("Saved api key: {name: %s, scope: %v}", key.Name, key.Scope)
+	}
+	return saved > 0, nil
+}
+
+/*
+ * Remove an api key. Returns false if no key had the name.
+ */
+func (mgr *SqliteManager) RemoveApiKey(name string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(deleteApiKey, name)
+	if err != nil {
+		log.Printf("Error removing api key %s: %v", name, err)
+		return false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of api keys removed: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Query for the api key saved under the hash
+ */
+func (mgr *SqliteManager) GetApiKeyByHash(keyHash string) (*bepb.ApiKey, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	return scanApiKey(mgr.db.QueryRow(queryApiKeyByHash, keyHash))
+}
+
+/*
+ * Get all the api keys ordered by name, without their hashes
+ */
+func (mgr *SqliteManager) GetApiKeys() ([]*bepb.ApiKey, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryApiKeys)
+	if err != nil {
+		log.Printf("Error querying api keys: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*bepb.ApiKey, 0)
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			log.Printf("Error reading api key: %v", err)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+/*
+ * Read an api key from a row of name, scope and creation time
+ */
+func scanApiKey(row rowScanner) (*bepb.ApiKey, error) {
+	key := new(bepb.ApiKey)
+	var created time.Time
+
+	if err := row.Scan(&key.Name, &key.Scope, &created); err != nil {
+		return nil, err
+	}
+
+	key.Created = created.Unix()
+	return key, nil
+}
+
+/*
+ * Get all of the rooms
+ */
+func (mgr *SqliteManager) GetRooms() ([]*RoomData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryRooms)
+	if err != nil {
+		log.Printf("Error querying rooms: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]*RoomData, 0)
+	for rows.Next() {
+		roomData := new(RoomData)
+		err = rows.Scan(&roomData.Room.Id, &roomData.Room.Name, &roomData.CreateDate, &roomData.LastAccess)
+		if err != nil {
+			log.Printf("Error reading room: %v", err)
+			return nil, err
+		}
+		rooms = append(rooms, roomData)
+	}
+
+	return rooms, rows.Err()
+}
+
+/*
+ * Remove songs submitted before the given time from the history
+ */
+func (mgr *SqliteManager) PruneSongs(before time.Time) (int64, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(deleteSongsBefore, before.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		log.Printf("Error pruning songs: %v", err)
+		return 0, err
+	}
+
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of pruned songs: %v", err)
+		return 0, err
+	}
+
+	log.Printf("Pruned %d songs submitted before %v", pruned, before)
+	return pruned, nil
+}
+
+/*
+ * Record a user's reaction to a song. Returns false if the user already
+ * reacted to the song with the same emoji.
+ */
+func (mgr *SqliteManager) AddReaction(songId uint32, userId uint32, emoji string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(insertReaction, songId, userId, emoji)
+	if err != nil {
+		log.Printf("Error adding reaction %s to song %d: %v", emoji, songId, err)
+		return false, err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return added > 0, nil
+}
+
+/*
+ * Count the reactions to a song, most common first
+ */
+func (mgr *SqliteManager) GetReactionCounts(songId uint32) ([]*bepb.ReactionCount, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryReactionCounts, songId)
+	if err != nil {
+		log.Printf("Error querying reaction counts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*bepb.ReactionCount, 0)
+	for rows.Next() {
+		count := new(bepb.ReactionCount)
+		if err = rows.Scan(&count.Emoji, &count.Count); err != nil {
+			log.Printf("Error reading reaction count: %v", err)
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+/*
+ * Get the song with the most of each reaction tonight, most reacted first. A
+ * night runs from 6am local time until 6am the next day.
+ */
+func (mgr *SqliteManager) GetTopReactions() ([]*ReactionHighlightData, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryTopReactions)
+	if err != nil {
+		log.Printf("Error querying top reactions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	highlights := make([]*ReactionHighlightData, 0)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var service int32
+		highlight := new(ReactionHighlightData)
+		err = rows.Scan(&highlight.Emoji, &highlight.Song.SongId, &highlight.Song.Title, &service,
+			&highlight.Song.ServiceId, &highlight.Song.UserId, &highlight.Song.Username,
+			&highlight.Song.RoomId, &highlight.Song.Source, &highlight.Song.Anonymous, &highlight.Reactions)
+		if err != nil {
+			log.Printf("Error reading top reaction: %v", err)
+			return nil, err
+		}
+
+		// rows come most reacted first, so the first row of an emoji wins
+		if seen[highlight.Emoji] {
+			continue
+		}
+		seen[highlight.Emoji] = true
+
+		highlight.Song.Service = cmpb.ServiceType(service)
+		highlights = append(highlights, highlight)
+	}
+
+	return highlights, rows.Err()
+}
+
+/*
+ * Mark a room as holding demo data
+ */
+func (mgr *SqliteManager) MarkDemoRoom(roomId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(insertDemoRoom, roomId); err != nil {
+		log.Printf("Error marking room %d as demo data: %v", roomId, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Remove the demo rooms along with their users and everything the users did.
+ * Returns the number of users removed.
+ */
+func (mgr *SqliteManager) RemoveDemoData() (int64, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.begin()
+	if err != nil {
+		log.Printf("Error starting demo data transaction: %v", err)
+		return 0, err
+	}
+
+	// everything pointing at the users goes first, then the users and rooms
+	var removed int64
+	statements := []string{deleteDemoReactions, deleteDemoSharedPlaylists, deleteDemoAchievements,
+		deleteDemoPolicies, deleteDemoPreferences, deleteDemoIdentities, deleteDemoSkipVotes, deleteDemoSongs,
+		deleteDemoUsers, deleteDemoDisplaySettings, deleteDemoRooms}
+	for _, statement := range statements {
+		res, err := tx.Exec(statement)
+		if err != nil {
+			log.Printf("Error removing demo data: %v", err)
+			tx.Rollback()
+			return 0, err
+		}
+
+		if statement == deleteDemoUsers {
+			removed, _ = res.RowsAffected()
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Removed demo data: {users: %d}", removed)
+	return removed, nil
+}
+
+/*
+ * Block a song from being submitted. Blocking a blocked song does nothing.
+ */
+func (mgr *SqliteManager) BlockSong(service cmpb.ServiceType, serviceId string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(insertBlockedSong, service, serviceId); err != nil {
+		log.Printf("Error blocking song %s: %v", serviceId, err)
+		return err
+	}
+
+	log.Printf("Blocked song: {service: %v, id: %s}", service, serviceId)
+	return nil
+}
+
+/*
+ * Let a blocked song be submitted again. Returns false if the song wasn't
+ * blocked.
+ */
+func (mgr *SqliteManager) UnblockSong(service cmpb.ServiceType, serviceId string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(deleteBlockedSong, service, serviceId)
+	if err != nil {
+		log.Printf("Error unblocking song %s: %v", serviceId, err)
+		return false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of songs unblocked: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Returns true if the song is blocked from being submitted
+ */
+func (mgr *SqliteManager) IsSongBlocked(service cmpb.ServiceType, serviceId string) (bool, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var count int
+	if err := mgr.db.QueryRow(queryBlockedSong, service, serviceId).Scan(&count); err != nil {
+		log.Printf("Error checking whether song %s is blocked: %v", serviceId, err)
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+/*
+ * Rebuild the database file to reclaim space left by deleted rows and refresh
+ * the statistics used by the query planner
+ */
+func (mgr *SqliteManager) Vacuum() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(vacuumDatabase); err != nil {
+		log.Printf("Error vacuuming database: %v", err)
+		return err
+	}
+
+	if _, err := mgr.db.Exec(analyzeDatabase); err != nil {
+		log.Printf("Error analyzing database: %v", err)
+		return err
+	}
+
+	log.Println("Vacuumed and analyzed database")
+	return nil
+}
+
+/*
+ * Save a copy of the songs under the given share code. Fails if the code is
+ * already in use.
+ */
+func (mgr *SqliteManager) AddSharedPlaylist(code string, userId uint32, songs []*cmpb.Song) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.begin()
+	if err != nil {
+		log.Printf("Error starting shared playlist transaction: %v", err)
+		return err
+	}
+
+	if _, err = tx.Exec(insertSharedPlaylist, code, userId); err != nil {
+		log.Printf("Error adding shared playlist: %v", err)
+		tx.Rollback()
+		return err
+	}
+
+	for position, song := range songs {
+		thumbnail := song.GetMetadata().GetThumbnail()
+		duration := song.GetMetadata().GetDuration()
+
+		_, err = tx.Exec(insertSharedPlaylistSong, code, position, song.Title, song.Service,
+			song.ServiceId, thumbnail, duration)
+		if err != nil {
+			log.Printf("Error adding shared playlist song: %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Shared playlist: {code: %s, user: %d, songs: %d}", code, userId, len(songs))
+	return nil
+}
+
+/*
+ * Query for the songs saved under a share code. Returns sql.ErrNoRows if the
+ * code doesn't exist.
+ */
+func (mgr *SqliteManager) GetSharedPlaylist(code string) ([]*cmpb.Song, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var count int
+	if err := mgr.db.QueryRow(querySharedPlaylistExists, code).Scan(&count); err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := mgr.db.Query(querySharedPlaylistSongs, code)
+	if err != nil {
+		log.Printf("Error querying shared playlist songs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := make([]*cmpb.Song, 0)
+	for rows.Next() {
+		song := &cmpb.Song{Metadata: new(cmpb.Metadata)}
+		var service int32
+
+		err = rows.Scan(&song.Title, &service, &song.ServiceId, &song.Metadata.Thumbnail, &song.Metadata.Duration)
+		if err != nil {
+			log.Printf("Error reading shared playlist song: %v", err)
+			return nil, err
+		}
+
+		song.Service = cmpb.ServiceType(service)
+		songs = append(songs, song)
+	}
+
+	return songs, rows.Err()
+}
+
+/*
+ * Creates a new database with the necessary tables
+ */
+func foundDatabase(db *sql.DB) error {
+	tables, err := schemaStatements("create")
+	if err != nil {
+		log.Fatalf("Error reading the database schema: %v", err)
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err = db.Exec(table.statement); err != nil {
+			log.Fatalf("Error creating %s table: %v", table.name, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Flag a submitted song as skipped
+ */
+func (mgr *SqliteManager) MarkSongSkipped(songId uint32) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(updateSongSkipped, songId); err != nil {
+		log.Printf("Error marking song %d as skipped: %v", songId, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Compute the history totals of every user who submitted a song, ordered by
+ * the number of songs submitted
+ */
+func (mgr *SqliteManager) GetHistoryTotals() ([]*HistoryTotalsData, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// songs picked by the auto dj don't count towards anyone's history
+	rows, err := reads.Query(queryHistoryTotals, cmpb.SubmissionSource_AutoDj)
+	if err != nil {
+		log.Printf("Error querying history totals: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]*HistoryTotalsData, 0)
+	for rows.Next() {
+		total := new(HistoryTotalsData)
+		err = rows.Scan(&total.UserId, &total.Username, &total.Songs, &total.Skips,
+			&total.NightOpeners, &total.BestNight, &total.Achievements)
+		if err != nil {
+			log.Printf("Error reading history totals: %v", err)
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+
+	return totals, rows.Err()
+}
+
+/*
+ * Record an achievement earned by a user. Returns false if the user had
+ * already earned it.
+ */
+func (mgr *SqliteManager) AddAchievement(userId uint32, achievementId string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(insertAchievement, userId, achievementId)
+	if err != nil {
+		log.Printf("Error adding achievement %s for user %d: %v", achievementId, userId, err)
+		return false, err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return added > 0, nil
+}
+
+/*
+ * Exempt a user from the submission limits, or take the exemption away
+ */
+func (mgr *SqliteManager) SetUserExempt(userId uint32, exempt bool) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if _, err := mgr.db.Exec(upsertUserExempt, userId, exempt); err != nil {
+		log.Printf("Error setting exemption of user %d: %v", userId, err)
+		return err
+	}
+
+	log.Printf("Set user exemption: {user: %d, exempt: %t}", userId, exempt)
+	return nil
+}
+
+/*
+ * Returns true if the user is exempt from the submission limits
+ */
+func (mgr *SqliteManager) IsUserExempt(userId uint32) (bool, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	var exempt bool
+	err := mgr.db.QueryRow(queryUserExempt, userId).Scan(&exempt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return exempt, err
+}
+
+/*
+ * Save the settings a user's submissions get by default
+ */
+func (mgr *SqliteManager) SetPreferences(preferences *bepb.Preferences) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(upsertPreferences, preferences.UserId, preferences.AudioOnly,
+		int32(preferences.StartBehavior), preferences.Notify)
+	if err != nil {
+		log.Printf("Error saving preferences of user %d: %v", preferences.UserId, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Get the preferences of a user. Users who never saved any get the defaults.
+ */
+func (mgr *SqliteManager) GetPreferences(userId uint32) (*bepb.Preferences, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	preferences := &bepb.Preferences{UserId: userId}
+	var startBehavior int32
+	err := mgr.db.QueryRow(queryPreferences, userId).Scan(&preferences.AudioOnly, &startBehavior,
+		&preferences.Notify)
+	if errors.Is(err, sql.ErrNoRows) {
+		return preferences, nil
+	} else if err != nil {
+		log.Printf("Error querying preferences of user %d: %v", userId, err)
+		return nil, err
+	}
+
+	preferences.StartBehavior = bepb.StartBehavior(startBehavior)
+	return preferences, nil
+}
+
+/*
+ * Save the display settings of a room, or of the whole server for room id
+ * zero. Returns false if the room doesn't exist.
+ */
+func (mgr *SqliteManager) SetDisplaySettings(settings *bepb.DisplaySettings) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if settings.RoomId != 0 {
+		var count int
+		if err := mgr.db.QueryRow(queryRoomExists, settings.RoomId).Scan(&count); err != nil {
+			log.Printf("Error checking whether room %d exists: %v", settings.RoomId, err)
+			return false, err
+		} else if count == 0 {
+			return false, nil
+		}
+	}
+
+	_, err := mgr.db.Exec(upsertDisplaySettings, settings.RoomId, settings.PartyName, settings.ThemeColor,
+		settings.Banner, settings.ShowSubmitter, settings.RomanizedTitles)
+	if err != nil {
+		log.Printf("Error saving display settings of room %d: %v", settings.RoomId, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*
+ * Get the display settings of a room. Rooms without settings of their own get
+ * the whole server's, and a server without any gets the defaults.
+ */
+func (mgr *SqliteManager) GetDisplaySettings(roomId uint32) (*bepb.DisplaySettings, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	settings := new(bepb.DisplaySettings)
+	err := mgr.db.QueryRow(queryDisplaySettings, roomId).Scan(&settings.RoomId, &settings.PartyName,
+		&settings.ThemeColor, &settings.Banner, &settings.ShowSubmitter, &settings.RomanizedTitles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &bepb.DisplaySettings{PartyName: defaultPartyName, ShowSubmitter: true}, nil
+	} else if err != nil {
+		log.Printf("Error querying display settings of room %d: %v", roomId, err)
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+/*
+ * Get the users exempt from the submission limits
+ */
+func (mgr *SqliteManager) GetExemptUsers() ([]*UserData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryExemptUsers)
+	if err != nil {
+		log.Printf("Error querying exempt users: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*UserData, 0)
+	for rows.Next() {
+		userData := new(UserData)
+		err = rows.Scan(&userData.User.UserId, &userData.User.Username, &userData.User.RoomId,
+			&userData.LoggedIn, &userData.LastAccess)
+		if err != nil {
+			log.Printf("Error reading exempt user: %v", err)
+			return nil, err
+		}
+		users = append(users, userData)
+	}
+
+	return users, rows.Err()
+}
+
+/*
+ * Get the achievements earned by a user, oldest first
+ */
+func (mgr *SqliteManager) GetAchievements(userId uint32) ([]*AchievementData, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryAchievements, userId)
+	if err != nil {
+		log.Printf("Error querying achievements: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	achievements := make([]*AchievementData, 0)
+	for rows.Next() {
+		achievement := new(AchievementData)
+		err = rows.Scan(&achievement.UserId, &achievement.AchievementId, &achievement.EarnDate)
+		if err != nil {
+			log.Printf("Error reading achievement: %v", err)
+			return nil, err
+		}
+		achievements = append(achievements, achievement)
+	}
+
+	return achievements, rows.Err()
+}
+
+/*
+ * Get songs from the history that weren't played since the given time, in
+ * random order. Each song is returned once no matter how often it was played.
+ */
+func (mgr *SqliteManager) GetFallbackCandidates(playedBefore time.Time, limit int) ([]*FallbackSongData, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryFallbackCandidates, playedBefore.UTC().Format(sqliteTimeFormat), limit)
+	if err != nil {
+		log.Printf("Error querying fallback candidates: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]*FallbackSongData, 0)
+	for rows.Next() {
+		candidate := new(FallbackSongData)
+		var service int32
+		var lastPlayed string
+
+		err = rows.Scan(&candidate.Song.Title, &service, &candidate.Song.ServiceId, &candidate.Song.UserId,
+			&candidate.Song.Username, &candidate.Song.RoomId, &candidate.Channel, &lastPlayed)
+		if err != nil {
+			log.Printf("Error reading fallback candidate: %v", err)
+			return nil, err
+		}
+
+		candidate.Song.Service = cmpb.ServiceType(service)
+		candidate.LastPlayed, _ = time.Parse(sqliteTimeFormat, lastPlayed)
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+/*
+ * Get songs from a shared playlist that weren't played since the given time,
+ * in random order. The songs are credited to the user who shared the playlist.
+ */
+func (mgr *SqliteManager) GetPlaylistFallbackCandidates(code string, playedBefore time.Time,
+	limit int) ([]*FallbackSongData, error) {
+
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryPlaylistFallbackCandidates, code, playedBefore.UTC().Format(sqliteTimeFormat),
+		limit)
+	if err != nil {
+		log.Printf("Error querying playlist fallback candidates: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]*FallbackSongData, 0)
+	for rows.Next() {
+		candidate := new(FallbackSongData)
+		var service int32
+		var lastPlayed string
+
+		err = rows.Scan(&candidate.Song.Title, &service, &candidate.Song.ServiceId, &candidate.Song.UserId,
+			&candidate.Song.Username, &candidate.Song.RoomId, &candidate.Channel, &lastPlayed)
+		if err != nil {
+			log.Printf("Error reading playlist fallback candidate: %v", err)
+			return nil, err
+		}
+
+		candidate.Song.Service = cmpb.ServiceType(service)
+		candidate.LastPlayed, _ = time.Parse(sqliteTimeFormat, lastPlayed)
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+/*
+ * Save a queue preset. Replaces any preset saved under the same name.
+ */
+func (mgr *SqliteManager) SavePreset(preset *bepb.Preset) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.db.Exec(insertQueuePreset, preset.Name, preset.Algorithm, preset.MaxMinutes,
+		preset.SubmissionWindow, preset.FallbackCode, preset.OpenAt, preset.CloseAt)
+	if err != nil {
+		log.Printf("Error saving preset %s: %v", preset.Name, err)
+		return err
+	}
+
+	log.Printf("Saved preset: {name: %s}", preset.Name)
+	return nil
+}
+
+/*
+ * Query for a queue preset by its name
+ */
+func (mgr *SqliteManager) GetPreset(name string) (*bepb.Preset, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	return scanPreset(mgr.db.QueryRow(queryQueuePreset, name))
+}
+
+/*
+ * Get all the saved queue presets ordered by name
+ */
+func (mgr *SqliteManager) GetPresets() ([]*bepb.Preset, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryQueuePresets)
+	if err != nil {
+		log.Printf("Error querying presets: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := make([]*bepb.Preset, 0)
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			log.Printf("Error reading preset: %v", err)
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+
+	return presets, rows.Err()
+}
+
+/*
+ * A single row of a query result, either from QueryRow or Query
+ */
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+/*
+ * Read a queue preset out of a row
+ */
+func scanPreset(row rowScanner) (*bepb.Preset, error) {
+	preset := new(bepb.Preset)
+	var algorithm int32
+
+	err := row.Scan(&preset.Name, &algorithm, &preset.MaxMinutes, &preset.SubmissionWindow,
+		&preset.FallbackCode, &preset.OpenAt, &preset.CloseAt)
+	if err != nil {
+		return nil, err
+	}
+
+	preset.Algorithm = bepb.QueueAlgorithm(algorithm)
+	return preset, nil
+}
+
+/*
+ * Adds the tables introduced after the database was first created, from the
+ * upgrades in the schema, and the columns added to existing tables
+ */
+func upgradeDatabase(db *sql.DB) error {
+	upgrades, err := schemaStatements("upgrades")
+	if err != nil {
+		log.Printf("Error reading the database schema: %v", err)
+		return err
+	}
+
+	for _, upgrade := range upgrades {
+		if _, err := db.Exec(upgrade.statement); err != nil {
+			log.Printf("Error upgrading database with %s: %v", upgrade.name, err)
+			return err
+		}
+	}
+
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"songs", "source", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "skipped", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "raw_title", "TEXT"},
+		{"songs", "clean_title", "TEXT"},
+		{"songs", "duration", "TEXT"},
+		{"songs", "anonymous", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "start_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "end_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "chapter", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "outcome", "INTEGER NOT NULL DEFAULT 0"},
+		{"songs", "skipped_by", "TEXT NOT NULL DEFAULT ''"},
+		{"songs", "romanized_title", "TEXT NOT NULL DEFAULT ''"},
+		{"songs", "language", "TEXT NOT NULL DEFAULT ''"},
+		{"display_settings", "romanized_titles", "INTEGER NOT NULL DEFAULT 0"},
+	}
 
-	updateUsername = `
-		UPDATE users SET username=?
-		WHERE user_id=?;`
-)
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.definition); err != nil {
+			log.Printf("Error adding column %s to table %s: %v", c.column, c.table, err)
+			return err
+		}
+	}
 
-type SqliteManager struct {
-	db   *sql.DB
-	lock *sync.RWMutex
+	return nil
 }
 
 /*
- * Clean up resources used by the database manager
+ * Add a column to an existing table unless the table already has it
  */
-func (mgr *SqliteManager) Close() {
-	mgr.db.Close()
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, colType string
+		var notNull, primaryKey int
+		var defaultValue sql.NullString
+
+		if err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
 }
 
 /*
- * Initialize the sqlite database
+ * Reserve a block of song ids. The songs table's sequence is moved past the
+ * block, so songs added with AddSong are numbered after it.
  */
-func (mgr *SqliteManager) Init(dbPath string) error {
-	fil, err := os.Open(dbPath)
-	shouldFound := err != nil
+func (mgr *SqliteManager) ReserveSongIds(count uint32) (uint32, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
 
-	mgr.db, err = sql.Open("sqlite3", dbPath)
+	tx, err := mgr.begin()
 	if err != nil {
-		log.Fatalf("Failed to open database connect with error: %v", err)
-		return err
+		log.Printf("Error starting reserve song ids transaction: %v", err)
+		return 0, err
 	}
 
-	_, err = mgr.db.Exec(enableForeignKeySupport)
-	if err != nil {
-		log.Fatalf("Error enabling foreign key support: %v", err)
-		return err
+	var last uint32
+	if err = tx.QueryRow(selectSongSequence).Scan(&last); err != nil {
+		log.Printf("Error reading the song id sequence: %v", err)
+		tx.Rollback()
+		return 0, err
 	}
 
-	if shouldFound {
-		if err = foundDatabase(mgr.db); err != nil {
-			return err
+	res, err := tx.Exec(updateSongSequence, last+count)
+	if err == nil {
+		var updated int64
+		if updated, err = res.RowsAffected(); err == nil && updated == 0 {
+			_, err = tx.Exec(insertSongSequence, last+count)
 		}
-	} else {
-		fil.Close()
 	}
 
-	mgr.lock = new(sync.RWMutex)
-	return nil
+	if err != nil {
+		log.Printf("Error moving the song id sequence: %v", err)
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, contextErr(mgr.ctx, err)
+	}
+
+	return last + 1, nil
 }
 
 /*
- * Add a new song to the database
+ * Add a new song to the database under the id it was given from a reserved
+ * block
  */
-func (mgr *SqliteManager) AddSong(song *cmpb.Song) error {
+func (mgr *SqliteManager) AddReservedSong(song *cmpb.Song) error {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
-	stmt, err := mgr.db.Prepare(insertSong)
+	_, err := mgr.db.Exec(insertReservedSong, song.SongId, song.Title, song.Service, song.ServiceId, song.UserId,
+		song.RoomId, song.Source, song.RawTitle, song.CleanTitle, song.GetMetadata().GetDuration(), song.Anonymous,
+		song.StartAt, song.EndAt, song.Chapter, song.RomanizedTitle, song.Language)
 	if err != nil {
-		log.Printf("Error preparing add song statement: %v", err)
+		log.Printf("Error adding reserved song %d: %v", song.SongId, err)
 		return err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.Exec(song.Title, song.Service, song.ServiceId, song.UserId, song.RoomId)
+	return nil
+}
+
+/*
+ * Tag a song for a user. Returns false if the user already put the tag on the
+ * song.
+ */
+func (mgr *SqliteManager) AddSongTag(service cmpb.ServiceType, serviceId string, tag string,
+	userId uint32) (bool, error) {
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	res, err := mgr.db.Exec(insertSongTag, service, serviceId, tag, userId)
 	if err != nil {
-		log.Printf("Error adding new song: %v", err)
-		log.Printf("Attempted to add song: %v", song)
-		return err
+		log.Printf("Error tagging song %s with %s: %v", serviceId, tag, err)
+		return false, err
 	}
 
-	songId, err := res.LastInsertId()
+	added, err := res.RowsAffected()
 	if err != nil {
-		log.Printf("Error getting auto-increment id of new song: %v", err)
-		return err
+		log.Printf("Error getting number of song tags added: %v", err)
+		return false, err
 	}
-	song.SongId = uint32(songId)
-	log.Printf("Added new song to db: { %v}", song)
 
-	return nil
+	return added > 0, nil
 }
 
 /*
- * Add a new user to the database
+ * Remove a user's tag from a song. Returns false if the user hadn't put the
+ * tag on the song.
  */
-func (mgr *SqliteManager) AddUser(username string, roomId uint32) (*UserData, error) {
+func (mgr *SqliteManager) RemoveSongTag(service cmpb.ServiceType, serviceId string, tag string,
+	userId uint32) (bool, error) {
+
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
-	stmt, err := mgr.db.Prepare(insertUser)
+	res, err := mgr.db.Exec(deleteSongTag, service, serviceId, tag, userId)
 	if err != nil {
-		log.Printf("Error preparing add user statement: %v", err)
-		return nil, err
+		log.Printf("Error untagging song %s from %s: %v", serviceId, tag, err)
+		return false, err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.Exec(username, roomId)
+	removed, err := res.RowsAffected()
 	if err != nil {
-		log.Printf("Error adding new user: %v", err)
-		return nil, err
+		log.Printf("Error getting number of song tags removed: %v", err)
+		return false, err
 	}
 
-	userId, err := res.LastInsertId()
+	return removed > 0, nil
+}
+
+/*
+ * Find the songs with a tag starting with the given one, most recently played
+ * first. Each song is returned once, as it was last submitted, along with all
+ * of its tags.
+ */
+func (mgr *SqliteManager) FindTaggedSongs(tag string, limit int) ([]*bepb.TaggedSong, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryTaggedSongs, tag, limit)
 	if err != nil {
-		log.Printf("Error getting auto-increment id of new user: %v", err)
+		log.Printf("Error querying songs tagged %s: %v", tag, err)
 		return nil, err
 	}
-	log.Printf("Added new user: {name: %s, id: %d, room: %d}", username, userId, roomId)
+	defer rows.Close()
 
-	// todo: fix this. If there's an error in the retrieval, then the user gets
-	// created, but we return an error.
-	return mgr.unsyncGetUserById(uint32(userId))
-}
+	songs := make([]*bepb.TaggedSong, 0)
+	for rows.Next() {
+		song := new(cmpb.Song)
+		var service int32
+		var lastPlayed string
+		var tags string
 
-/*
- * Query for the user data of the given user id
- */
-func (mgr *SqliteManager) GetUserById(userId uint32) (*UserData, error) {
-	mgr.lock.RLock()
-	defer mgr.lock.RUnlock()
-	return mgr.unsyncGetUserById(userId)
+		err = rows.Scan(&song.SongId, &song.Title, &service, &song.ServiceId, &song.UserId, &song.Username,
+			&song.RoomId, &lastPlayed, &tags)
+		if err != nil {
+			log.Printf("Error reading tagged song: %v", err)
+			return nil, err
+		}
+
+		song.Service = cmpb.ServiceType(service)
+		tagged := &bepb.TaggedSong{Song: song, Tags: strings.Split(tags, ",")}
+		sort.Strings(tagged.Tags)
+		songs = append(songs, tagged)
+	}
+
+	return songs, rows.Err()
 }
 
 /*
- * Query for the user data of the given user id. This is a helper method that
- * relies on other callers to already have the mutex lock.
+ * Get songs with a tag that weren't played since the given time, in random
+ * order
  */
-func (mgr *SqliteManager) unsyncGetUserById(userId uint32) (*UserData, error) {
-	userData := new(UserData)
+func (mgr *SqliteManager) GetTaggedFallbackCandidates(tag string, playedBefore time.Time,
+	limit int) ([]*FallbackSongData, error) {
 
-	err := mgr.db.QueryRow(queryUserById, userId).Scan(&userData.User.UserId,
-		&userData.User.Username, &userData.User.RoomId, &userData.LoggedIn, &userData.LastAccess)
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
 
-	// if an error occurred or there was no result, then return nil
+	rows, err := reads.Query(queryTaggedFallbackCandidates, tag, playedBefore.UTC().Format(sqliteTimeFormat), limit)
 	if err != nil {
-		userData = nil
+		log.Printf("Error querying fallback candidates tagged %s: %v", tag, err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	return userData, nil
+	candidates := make([]*FallbackSongData, 0)
+	for rows.Next() {
+		candidate := new(FallbackSongData)
+		var service int32
+		var lastPlayed string
+
+		err = rows.Scan(&candidate.Song.Title, &service, &candidate.Song.ServiceId, &candidate.Song.UserId,
+			&candidate.Song.Username, &candidate.Song.RoomId, &candidate.Channel, &lastPlayed)
+		if err != nil {
+			log.Printf("Error reading tagged fallback candidate: %v", err)
+			return nil, err
+		}
+
+		candidate.Song.Service = cmpb.ServiceType(service)
+		candidate.LastPlayed, _ = time.Parse(sqliteTimeFormat, lastPlayed)
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
 }
 
 /*
- * Updates the username of an existing user.
+ * Save a song scheduled to play at a set time and set its id
  */
-func (mgr *SqliteManager) UpdateUsername(username string, userId uint32) error {
+func (mgr *SqliteManager) SaveScheduledSong(scheduled *bepb.ScheduledSong) error {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
-	stmt, err := mgr.db.Prepare(updateUsername)
+	blob, err := proto.Marshal(scheduled)
 	if err != nil {
-		log.Printf("Error preparing update username statement: %v", err)
+		log.Printf("Error serializing scheduled song: %v", err)
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(username, userId)
+	res, err := mgr.db.Exec(insertScheduledSong, time.Unix(scheduled.PlayAt, 0).UTC().Format(sqliteTimeFormat), blob)
 	if err != nil {
-		log.Printf("Error updating username: %v", err)
+		log.Printf("Error saving scheduled song: %v", err)
 		return err
 	}
 
-	log.Printf("Updated username: {name: %s, id: %d}", username, userId)
+	scheduledId, err := res.LastInsertId()
+	if err != nil {
+		log.Printf("Error getting auto-increment id of scheduled song: %v", err)
+		return err
+	}
 
+	scheduled.Id = uint32(scheduledId)
 	return nil
 }
 
 /*
- * Adds a new room with given name
+ * Remove a scheduled song. Returns false if no song was scheduled with the id.
  */
-func (mgr *SqliteManager) AddRoom(roomName string) (*RoomData, error) {
+func (mgr *SqliteManager) RemoveScheduledSong(scheduledId uint32) (bool, error) {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
-	stmt, err := mgr.db.Prepare(insertRoom)
+	res, err := mgr.db.Exec(deleteScheduledSong, scheduledId)
 	if err != nil {
-		log.Printf("Error preparing add room statement: %v", err)
+		log.Printf("Error removing scheduled song %d: %v", scheduledId, err)
+		return false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error getting number of scheduled songs removed: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Get the scheduled songs, soonest first
+ */
+func (mgr *SqliteManager) GetScheduledSongs() ([]*bepb.ScheduledSong, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryScheduledSongs)
+	if err != nil {
+		log.Printf("Error querying scheduled songs: %v", err)
 		return nil, err
 	}
-	defer stmt.Close()
+	defer rows.Close()
+
+	songs := make([]*bepb.ScheduledSong, 0)
+	for rows.Next() {
+		var scheduledId uint32
+		var blob []byte
+		if err = rows.Scan(&scheduledId, &blob); err != nil {
+			log.Printf("Error reading scheduled song: %v", err)
+			return nil, err
+		}
+
+		scheduled := new(bepb.ScheduledSong)
+		if err = proto.Unmarshal(blob, scheduled); err != nil {
+			log.Printf("Error reading scheduled song %d: %v", scheduledId, err)
+			return nil, err
+		}
+
+		scheduled.Id = scheduledId
+		songs = append(songs, scheduled)
+	}
+
+	return songs, rows.Err()
+}
+
+/*
+ * Get the songs in the history with the ids. Ids of songs that don't exist
+ * are left out, and the songs come back in no particular order.
+ */
+func (mgr *SqliteManager) GetSongsByIds(songIds []uint32) ([]*cmpb.Song, error) {
+	songs := make([]*cmpb.Song, 0, len(songIds))
+	if len(songIds) == 0 {
+		return songs, nil
+	}
+
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	args := make([]interface{}, len(songIds))
+	for i, songId := range songIds {
+		args[i] = songId
+	}
 
-	res, err := stmt.Exec(roomName)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(songIds)), ", ")
+	rows, err := mgr.db.Query(fmt.Sprintf(querySongsByIds, placeholders), args...)
 	if err != nil {
-		log.Printf("Error adding new room: %v", err)
+		log.Printf("Error querying songs by id: %v", err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	roomId, err := res.LastInsertId()
-	log.Printf("Added new room: {name: %s, id: %d}", roomName, roomId)
+	for rows.Next() {
+		song := new(cmpb.Song)
+		var service int32
+		var source int32
+		if err = rows.Scan(&song.SongId, &song.Title, &service, &song.ServiceId, &song.UserId, &song.Username,
+			&song.RoomId, &source, &song.RawTitle, &song.CleanTitle, &song.Anonymous, &song.StartAt, &song.EndAt,
+			&song.Chapter, &song.RomanizedTitle, &song.Language); err != nil {
+			log.Printf("Error reading song: %v", err)
+			return nil, err
+		}
 
-	return mgr.unsyncGetRoomByName(roomName)
+		song.Service = cmpb.ServiceType(service)
+		song.Source = cmpb.SubmissionSource(source)
+		songs = append(songs, song)
+	}
+
+	return songs, rows.Err()
 }
 
-func (mgr *SqliteManager) GetRoomByName(roomName string) (*RoomData, error) {
+/*
+ * Link an account on another service to a user, replacing the link the
+ * account had to any other user
+ */
+func (mgr *SqliteManager) LinkIdentity(identity *bepb.ExternalIdentity) error {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
-	return mgr.unsyncGetRoomByName(roomName)
+	_, err := mgr.db.Exec(insertIdentity, identity.Provider, identity.ExternalId, identity.UserId)
+	if err != nil {
+		log.Printf("Error linking %s identity %s: %v", identity.Provider, identity.ExternalId, err)
+		return err
+	}
+
+	return nil
 }
 
-func (mgr *SqliteManager) unsyncGetRoomByName(roomName string) (*RoomData, error) {
-	roomData := new(RoomData)
+/*
+ * Remove the link of an account on another service. Returns false if the
+ * account wasn't linked.
+ */
+func (mgr *SqliteManager) UnlinkIdentity(provider string, externalId string) (bool, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
 
-	err := mgr.db.QueryRow(queryRoomByName, roomName).Scan(&roomData.Room.Id,
-		&roomData.Room.Name, &roomData.CreateDate, &roomData.LastAccess)
+	res, err := mgr.db.Exec(deleteIdentity, provider, externalId)
+	if err != nil {
+		log.Printf("Error unlinking %s identity %s: %v", provider, externalId, err)
+		return false, err
+	}
 
+	removed, err := res.RowsAffected()
 	if err != nil {
-		roomData = nil
+		log.Printf("Error getting number of identities unlinked: %v", err)
+		return false, err
+	}
+
+	return removed > 0, nil
+}
+
+/*
+ * Query for the link of an account on another service
+ */
+func (mgr *SqliteManager) GetIdentity(provider string, externalId string) (*bepb.ExternalIdentity, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	return scanIdentity(mgr.db.QueryRow(queryIdentity, provider, externalId))
+}
+
+/*
+ * Get the accounts on other services linked to a user, ordered by provider
+ */
+func (mgr *SqliteManager) GetUserIdentities(userId uint32) ([]*bepb.ExternalIdentity, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+
+	rows, err := mgr.db.Query(queryUserIdentities, userId)
+	if err != nil {
+		log.Printf("Error querying identities of user %d: %v", userId, err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	roomData.Room.Err = &bepb.Error{Success: true}
-	return roomData, nil
+	identities := make([]*bepb.ExternalIdentity, 0)
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			log.Printf("Error reading identity: %v", err)
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
 }
 
 /*
- * Creates a new database with the necessary tables
+ * Read a linked identity from a row of provider, external id, user id and
+ * link time
  */
-func foundDatabase(db *sql.DB) error {
-	_, err := db.Exec(createRoomsTable)
+func scanIdentity(row rowScanner) (*bepb.ExternalIdentity, error) {
+	identity := new(bepb.ExternalIdentity)
+	var linked time.Time
+
+	if err := row.Scan(&identity.Provider, &identity.ExternalId, &identity.UserId, &linked); err != nil {
+		return nil, err
+	}
+
+	identity.LinkedAt = linked.Unix()
+	return identity, nil
+}
+
+/*
+ * Record how a song ended, who skipped it and the users who voted it off
+ */
+func (mgr *SqliteManager) SetSongOutcome(songId uint32, outcome bepb.SongOutcome, skippedBy string,
+	voters []uint32) error {
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	tx, err := mgr.begin()
 	if err != nil {
-		log.Fatalf("Error creating rooms table: %v", err)
+		log.Printf("Error starting song outcome transaction: %v", err)
 		return err
 	}
 
-	_, err = db.Exec(createUsersTable)
-	if err != nil {
-		log.Fatalf("Error creating users table: %v", err)
+	if _, err = tx.Exec(updateSongOutcome, outcome, skippedBy, songId); err != nil {
+		log.Printf("Error recording the outcome of song %d: %v", songId, err)
+		tx.Rollback()
 		return err
 	}
 
-	_, err = db.Exec(createSongsTable)
+	for _, voter := range voters {
+		if _, err = tx.Exec(insertSkipVote, songId, voter); err != nil {
+			log.Printf("Error recording skip vote of user %d on song %d: %v", voter, songId, err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Count the songs that ended each way, of a user or of everyone for user id
+ * zero
+ */
+func (mgr *SqliteManager) GetOutcomeCounts(userId uint32) (map[bepb.SongOutcome]uint32, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(queryOutcomeCounts, userId, userId)
 	if err != nil {
-		log.Fatalf("Error creating users table: %v", err)
-		return err
+		log.Printf("Error querying song outcomes: %v", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
+	counts := make(map[bepb.SongOutcome]uint32)
+	for rows.Next() {
+		var outcome int32
+		var count uint32
+
+		if err = rows.Scan(&outcome, &count); err != nil {
+			log.Printf("Error reading song outcome count: %v", err)
+			return nil, err
+		}
+		counts[bepb.SongOutcome(outcome)] = count
+	}
+
+	return counts, rows.Err()
+}
+
+/*
+ * Get who skipped a user's songs, most skips first. Users who voted songs off
+ * are listed by name and callers who skipped them directly by who they were.
+ */
+func (mgr *SqliteManager) GetSkippers(userId uint32) ([]*bepb.Skipper, error) {
+	reads, lock := mgr.reader()
+	lock.RLock()
+	defer lock.RUnlock()
+
+	rows, err := reads.Query(querySkippers, userId, userId)
+	if err != nil {
+		log.Printf("Error querying skippers of user %d: %v", userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	skippers := make([]*bepb.Skipper, 0)
+	for rows.Next() {
+		skipper := new(bepb.Skipper)
+		if err = rows.Scan(&skipper.UserId, &skipper.Name, &skipper.Skips); err != nil {
+			log.Printf("Error reading skipper: %v", err)
+			return nil, err
+		}
+		skippers = append(skippers, skipper)
+	}
+
+	return skippers, rows.Err()
 }
@@ -5,12 +5,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	sqlite "github.com/mattn/go-sqlite3"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
@@ -23,7 +28,15 @@ const (
 	testUserName   = "Zedd"
 )
 
-var testSong = cmpb.Song{"Bags!!", 0, testUserName, testUserId, cmpb.ServiceType_Youtube, "0xdeadbeef", testRoomId}
+var testSong = cmpb.Song{
+	Title:     "Bags!!",
+	SongId:    0,
+	Username:  testUserName,
+	UserId:    testUserId,
+	Service:   cmpb.ServiceType_Youtube,
+	ServiceId: "0xdeadbeef",
+	RoomId:    testRoomId,
+}
 
 func initDatabase() (*SqliteManager, error) {
 	dbManager := new(SqliteManager)
@@ -109,6 +122,33 @@ func TestAddUser_when_success(t *testing.T) {
 	cleanUp(dbManager)
 }
 
+func TestGetUserByName_whenInOtherRoom_notFound(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddRoom("upstairs")
+	dbManager.AddUser(testUserName, testRoomId)
+
+	userData, err := dbManager.GetUserByName(testUserName, testRoomId)
+	if err != nil {
+		t.Fatal("Get user by name failed with error:", err)
+	}
+
+	if userData.User.UserId != testUserId {
+		t.Error("User id should be", testUserId, "but was", userData.User.UserId)
+	}
+
+	if _, err = dbManager.GetUserByName(testUserName, testRoomId+1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected no user in the other room, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
 func TestAddSong_when_success(t *testing.T) {
 	dbManager, err := initDatabase()
 
@@ -220,3 +260,1406 @@ func TestGetRoomByName_whenRoomDoesNotExist_returnsNil(t *testing.T) {
 
 	cleanUp(dbManager)
 }
+
+func TestGetSongById_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	addedSong := testSong
+	dbManager.AddSong(&addedSong)
+
+	song, err := dbManager.GetSongById(addedSong.SongId)
+	if err != nil {
+		t.Fatal("Get song by id failed with error:", err)
+	}
+
+	if song.Title != testSong.Title || song.ServiceId != testSong.ServiceId || song.Service != testSong.Service {
+		t.Error("DB manager did not fetch the expected song:", song)
+	}
+
+	if song.Username != testUserName {
+		t.Error("DB manager should return the submitter's name", testUserName, "but was", song.Username)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetSongById_whenTitlesCleaned_returnsBoth(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	addedSong := testSong
+	addedSong.RawTitle = testSong.Title + " (Official Video)"
+	addedSong.CleanTitle = testSong.Title
+	dbManager.AddSong(&addedSong)
+
+	song, err := dbManager.GetSongById(addedSong.SongId)
+	if err != nil {
+		t.Fatal("Get song by id failed with error:", err)
+	}
+
+	if song.RawTitle != addedSong.RawTitle || song.CleanTitle != addedSong.CleanTitle {
+		t.Error("DB manager should return the raw and cleaned titles:", song)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetSongById_whenRomanized_returnsRomanizedTitle(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	addedSong := &cmpb.Song{Title: "강남스타일", RomanizedTitle: "gangnamseutail", Language: "ko",
+		ServiceId: testSong.ServiceId, UserId: testUserId, RoomId: testRoomId}
+	dbManager.AddSong(addedSong)
+
+	song, err := dbManager.GetSongById(addedSong.SongId)
+	if err != nil {
+		t.Fatal("Get song by id failed with error:", err)
+	}
+
+	if song.RomanizedTitle != "gangnamseutail" || song.Language != "ko" {
+		t.Error("DB manager should return the romanized title and language:", song)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddSongDetails_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	details := &bepb.SongDetails{
+		Description: "A song about bags",
+		Channel:     "Bag Channel",
+		ViewCount:   1000,
+		Thumbnails: []*bepb.Thumbnail{
+			{Quality: "default", Url: "https://i.ytimg.com/vi/0xdeadbeef/default.jpg", Width: 120, Height: 90},
+		},
+	}
+
+	// adding the details twice should replace the first copy
+	dbManager.AddSongDetails(testSong.Service, testSong.ServiceId, details)
+	err = dbManager.AddSongDetails(testSong.Service, testSong.ServiceId, details)
+	if err != nil {
+		t.Fatal("Error when adding song details", err)
+	}
+
+	cached, err := dbManager.GetSongDetails(testSong.Service, testSong.ServiceId)
+	if err != nil {
+		t.Fatal("Get song details failed with error:", err)
+	}
+
+	if cached.Details.Channel != details.Channel || cached.Details.ViewCount != details.ViewCount {
+		t.Error("DB manager did not return the cached details:", &cached.Details)
+	}
+
+	if len(cached.Details.Thumbnails) != 1 {
+		t.Error("DB manager should return 1 thumbnail, but returned", len(cached.Details.Thumbnails))
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddLyrics_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	if _, err = dbManager.GetLyrics(testSong.Service, testSong.ServiceId); err == nil {
+		t.Error("DB manager should not return lyrics that were never cached")
+	}
+
+	// adding the lyrics twice should replace the first copy
+	dbManager.AddLyrics(testSong.Service, testSong.ServiceId, &bepb.Lyrics{Provider: "lrclib"})
+	lyrics := &bepb.Lyrics{Provider: "lrclib", Plain: "Bags, bags", Synced: "[00:01.00] Bags, bags"}
+	err = dbManager.AddLyrics(testSong.Service, testSong.ServiceId, lyrics)
+	if err != nil {
+		t.Fatal("Error when adding song lyrics", err)
+	}
+
+	cached, err := dbManager.GetLyrics(testSong.Service, testSong.ServiceId)
+	if err != nil {
+		t.Fatal("Get lyrics failed with error:", err)
+	}
+
+	if cached.Lyrics.Plain != lyrics.Plain || cached.Lyrics.Synced != lyrics.Synced {
+		t.Error("DB manager did not return the cached lyrics:", &cached.Lyrics)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSavePreset_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	// saving a preset under the same name should replace it
+	dbManager.SavePreset(&bepb.Preset{Name: "Friday Standup Tunes"})
+	preset := &bepb.Preset{
+		Name:             "Friday Standup Tunes",
+		Algorithm:        bepb.QueueAlgorithm_Fifo,
+		MaxMinutes:       5,
+		SubmissionWindow: 1800,
+		OpenAt:           "09:00",
+		CloseAt:          "09:30",
+	}
+	if err = dbManager.SavePreset(preset); err != nil {
+		t.Fatal("Error when saving preset", err)
+	}
+	dbManager.SavePreset(&bepb.Preset{Name: "After Party"})
+
+	saved, err := dbManager.GetPreset(preset.Name)
+	if err != nil {
+		t.Fatal("Get preset failed with error:", err)
+	}
+
+	if saved.Algorithm != preset.Algorithm || saved.MaxMinutes != preset.MaxMinutes || saved.CloseAt != preset.CloseAt {
+		t.Error("DB manager did not return the saved preset:", saved)
+	}
+
+	presets, err := dbManager.GetPresets()
+	if err != nil || len(presets) != 2 || presets[0].Name != "After Party" {
+		t.Error("DB manager should return 2 presets by name, but returned", presets, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetPlaylistFallbackCandidates_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	songs := []*cmpb.Song{
+		{Title: "Played", Service: cmpb.ServiceType_Youtube, ServiceId: "played", Metadata: &cmpb.Metadata{}},
+		{Title: "Fresh", Service: cmpb.ServiceType_Youtube, ServiceId: "fresh", Metadata: &cmpb.Metadata{}},
+	}
+	if err = dbManager.AddSharedPlaylist("ABCDEF", testUserId, songs); err != nil {
+		t.Fatal("Error when sharing playlist", err)
+	}
+
+	played := *songs[0]
+	played.UserId = testUserId
+	played.RoomId = testRoomId
+	dbManager.AddSong(&played)
+
+	candidates, err := dbManager.GetPlaylistFallbackCandidates("ABCDEF", time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal("Get playlist fallback candidates failed with error:", err)
+	}
+
+	if len(candidates) != 1 || candidates[0].Song.ServiceId != "fresh" || candidates[0].Song.UserId != testUserId {
+		t.Error("DB manager should only return the song that wasn't played, but returned", candidates)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetSourceCounts_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	sources := []cmpb.SubmissionSource{cmpb.SubmissionSource_WebUi, cmpb.SubmissionSource_WebUi, cmpb.SubmissionSource_Cli}
+	for _, source := range sources {
+		song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+			UserId: testUserId, RoomId: testRoomId, Source: source}
+		if err = dbManager.AddSong(song); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+	}
+
+	counts, err := dbManager.GetSourceCounts()
+	if err != nil {
+		t.Fatal("Get source counts failed with error:", err)
+	}
+
+	if counts[cmpb.SubmissionSource_WebUi] != 2 {
+		t.Error("Expected 2 web ui submissions, but counted", counts[cmpb.SubmissionSource_WebUi])
+	}
+
+	if counts[cmpb.SubmissionSource_Cli] != 1 {
+		t.Error("Expected 1 cli submission, but counted", counts[cmpb.SubmissionSource_Cli])
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestPruneSongs_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	addedSong := testSong
+	dbManager.AddSong(&addedSong)
+
+	pruned, err := dbManager.PruneSongs(time.Now().Add(-time.Hour))
+	if err != nil || pruned != 0 {
+		t.Error("Songs newer than the cutoff should be kept, but pruned", pruned, err)
+	}
+
+	pruned, err = dbManager.PruneSongs(time.Now().Add(time.Hour))
+	if err != nil || pruned != 1 {
+		t.Error("Songs older than the cutoff should be pruned, but pruned", pruned, err)
+	}
+
+	if err = dbManager.Vacuum(); err != nil {
+		t.Error("Vacuum failed with error:", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetHistoryTotals_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	for i := 0; i < 3; i++ {
+		song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+			UserId: testUserId, RoomId: testRoomId}
+		if err = dbManager.AddSong(song); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+
+		if i == 0 {
+			dbManager.MarkSongSkipped(song.SongId)
+		}
+	}
+
+	totals, err := dbManager.GetHistoryTotals()
+	if err != nil {
+		t.Fatal("Get history totals failed with error:", err)
+	}
+
+	if len(totals) != 1 {
+		t.Fatalf("Expected totals for 1 user, but got %d", len(totals))
+	}
+
+	total := totals[0]
+	if total.Songs != 3 || total.Skips != 1 || total.NightOpeners != 1 || total.BestNight != 3 {
+		t.Errorf("Unexpected history totals: %+v", total)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddAchievement_whenAlreadyEarned_returnsFalse(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	added, err := dbManager.AddAchievement(testUserId, "first_song")
+	if err != nil || !added {
+		t.Fatal("First achievement should be added", err)
+	}
+
+	added, err = dbManager.AddAchievement(testUserId, "first_song")
+	if err != nil || added {
+		t.Error("Repeated achievement should not be added again", err)
+	}
+
+	achievements, err := dbManager.GetAchievements(testUserId)
+	if err != nil {
+		t.Fatal("Get achievements failed with error:", err)
+	}
+
+	if len(achievements) != 1 || achievements[0].AchievementId != "first_song" {
+		t.Errorf("Expected the first_song achievement, but got %v", achievements)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetFallbackCandidates_whenPlayedRecently_excluded(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+		UserId: testUserId, RoomId: testRoomId}
+	if err = dbManager.AddSong(song); err != nil {
+		t.Fatal("Error when adding new song", err)
+	}
+
+	candidates, err := dbManager.GetFallbackCandidates(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal("Get fallback candidates failed with error:", err)
+	}
+
+	if len(candidates) != 0 {
+		t.Errorf("Recently played song should be excluded, but got %d candidates", len(candidates))
+	}
+
+	candidates, err = dbManager.GetFallbackCandidates(time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal("Get fallback candidates failed with error:", err)
+	}
+
+	if len(candidates) != 1 || candidates[0].Song.ServiceId != testSong.ServiceId {
+		t.Errorf("Expected the test song as the only candidate, but got %v", candidates)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetHistoryTotals_whenAutoDjSong_notCounted(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	for _, source := range []cmpb.SubmissionSource{cmpb.SubmissionSource_WebUi, cmpb.SubmissionSource_AutoDj} {
+		song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+			UserId: testUserId, RoomId: testRoomId, Source: source}
+		if err = dbManager.AddSong(song); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+	}
+
+	totals, err := dbManager.GetHistoryTotals()
+	if err != nil {
+		t.Fatal("Get history totals failed with error:", err)
+	}
+
+	if len(totals) != 1 || totals[0].Songs != 1 {
+		t.Errorf("Expected 1 song counted for the user, but got %v", totals)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddReaction_whenAlreadyReacted_returnsFalse(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+		UserId: testUserId, RoomId: testRoomId}
+	if err = dbManager.AddSong(song); err != nil {
+		t.Fatal("Error when adding new song", err)
+	}
+
+	for _, emoji := range []string{"🔥", "🔥", "💃"} {
+		dbManager.AddReaction(song.SongId, testUserId, emoji)
+	}
+
+	added, err := dbManager.AddReaction(song.SongId, testUserId, "🔥")
+	if err != nil || added {
+		t.Error("Repeated reaction should not be added again", err)
+	}
+
+	counts, err := dbManager.GetReactionCounts(song.SongId)
+	if err != nil {
+		t.Fatal("Get reaction counts failed with error:", err)
+	}
+
+	if len(counts) != 2 || counts[0].Count != 1 || counts[1].Count != 1 {
+		t.Errorf("Expected one of each reaction, but got %v", counts)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetTopReactions_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	dbManager.AddUser("Richard Cypher", testRoomId)
+
+	var songs []*cmpb.Song
+	for _, serviceId := range []string{"first", "second"} {
+		song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: serviceId,
+			UserId: testUserId, RoomId: testRoomId}
+		if err = dbManager.AddSong(song); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+		songs = append(songs, song)
+	}
+
+	// the second song gets the most fire, the first song the only dancing
+	dbManager.AddReaction(songs[0].SongId, testUserId, "🔥")
+	dbManager.AddReaction(songs[0].SongId, testUserId, "💃")
+	dbManager.AddReaction(songs[1].SongId, testUserId, "🔥")
+	dbManager.AddReaction(songs[1].SongId, testUserId+1, "🔥")
+
+	highlights, err := dbManager.GetTopReactions()
+	if err != nil {
+		t.Fatal("Get top reactions failed with error:", err)
+	}
+
+	if len(highlights) != 2 {
+		t.Fatalf("Expected a highlight for each emoji, but got %d", len(highlights))
+	}
+
+	if highlights[0].Emoji != "🔥" || highlights[0].Song.ServiceId != "second" || highlights[0].Reactions != 2 {
+		t.Errorf("Expected the second song to be the most 🔥, but got %+v", highlights[0])
+	}
+
+	if highlights[1].Emoji != "💃" || highlights[1].Song.ServiceId != "first" || highlights[1].Reactions != 1 {
+		t.Errorf("Expected the first song to be the most 💃, but got %+v", highlights[1])
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestWithTx_whenFnFails_rollsBack(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+
+	failure := errors.New("failed")
+	err = dbManager.WithTx(func(tx DbManager) error {
+		if _, err := tx.AddUser(testUserName, testRoomId); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Expected the error from the transaction, but got %v", err)
+	}
+
+	if _, err = dbManager.GetUserByName(testUserName, testRoomId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("User added in a failed transaction should be rolled back, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestWithContext_whenDeadlinePassed_returnsDeadlineExceeded(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, err = dbManager.WithContext(ctx).AddUser(testUserName, testRoomId); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, but got %v", err)
+	}
+
+	if _, err = dbManager.WithContext(ctx).GetRoomByName(testRoomName); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, but got %v", err)
+	}
+
+	if _, err = dbManager.GetUserByName(testUserName, testRoomId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("User added past the deadline shouldn't be saved, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestWithContext_whenCanceledInTx_rollsBack(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = dbManager.WithContext(ctx).WithTx(func(tx DbManager) error {
+		if _, err := tx.AddUser(testUserName, testRoomId); err != nil {
+			return err
+		}
+
+		// the caller gives up before the transaction is committed
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the transaction to be canceled, but got %v", err)
+	}
+
+	// the canceled transaction is rolled back in the background
+	for start := time.Now(); dbManager.root.Stats().InUse > 0 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err = dbManager.GetUserByName(testUserName, testRoomId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("User added in a canceled transaction should be rolled back, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestWithTx_when_success_commits(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+
+	err = dbManager.WithTx(func(tx DbManager) error {
+		if _, err := tx.AddUser(testUserName, testRoomId); err != nil {
+			return err
+		}
+
+		// shared playlists start their own transaction, which joins this one
+		return tx.AddSharedPlaylist("ABC234", testUserId, []*cmpb.Song{&testSong})
+	})
+	if err != nil {
+		t.Fatal("Transaction failed with error:", err)
+	}
+
+	if _, err = dbManager.GetUserByName(testUserName, testRoomId); err != nil {
+		t.Error("User added in the transaction should be committed", err)
+	}
+
+	if songs, err := dbManager.GetSharedPlaylist("ABC234"); err != nil || len(songs) != 1 {
+		t.Error("Shared playlist added in the transaction should be committed", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSetUserExempt_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	if exempt, err := dbManager.IsUserExempt(testUserId); err != nil || exempt {
+		t.Error("Users should not be exempt by default", err)
+	}
+
+	if err = dbManager.SetUserExempt(testUserId, true); err != nil {
+		t.Fatal("Set user exempt failed with error:", err)
+	}
+
+	users, err := dbManager.GetExemptUsers()
+	if err != nil || len(users) != 1 || users[0].User.Username != testUserName {
+		t.Errorf("Expected the test user to be exempt, but got %v, %v", users, err)
+	}
+
+	if err = dbManager.SetUserExempt(testUserId, false); err != nil {
+		t.Fatal("Set user exempt failed with error:", err)
+	}
+
+	if exempt, err := dbManager.IsUserExempt(testUserId); err != nil || exempt {
+		t.Error("Revoked exemption should not exempt the user", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSetPreferences_when_success(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	preferences, err := dbManager.GetPreferences(testUserId)
+	if err != nil || preferences.AudioOnly || preferences.Notify || preferences.UserId != testUserId {
+		t.Errorf("Expected the default preferences, but got %v, %v", preferences, err)
+	}
+
+	saved := &bepb.Preferences{UserId: testUserId, AudioOnly: true,
+		StartBehavior: bepb.StartBehavior_StartFromBeginning, Notify: true}
+	if err = dbManager.SetPreferences(saved); err != nil {
+		t.Fatal("Set preferences failed with error:", err)
+	}
+
+	preferences, err = dbManager.GetPreferences(testUserId)
+	if err != nil || !preferences.AudioOnly || !preferences.Notify ||
+		preferences.StartBehavior != bepb.StartBehavior_StartFromBeginning {
+		t.Errorf("Expected the saved preferences, but got %v, %v", preferences, err)
+	}
+
+	saved.AudioOnly = false
+	dbManager.SetPreferences(saved)
+	if preferences, _ = dbManager.GetPreferences(testUserId); preferences.AudioOnly {
+		t.Errorf("Expected saving again to replace the preferences, but got %v", preferences)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSetDisplaySettings_roomsFallBackToServer(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+
+	settings, err := dbManager.GetDisplaySettings(testRoomId)
+	if err != nil || settings.PartyName != defaultPartyName || !settings.ShowSubmitter {
+		t.Errorf("Expected the default display settings, but got %v, %v", settings, err)
+	}
+
+	server := &bepb.DisplaySettings{PartyName: "Office Party", ThemeColor: "#ff6600", ShowSubmitter: true}
+	if saved, err := dbManager.SetDisplaySettings(server); !saved || err != nil {
+		t.Fatalf("Failed to save the server's display settings: %t, %v", saved, err)
+	}
+
+	settings, _ = dbManager.GetDisplaySettings(testRoomId)
+	if settings.RoomId != 0 || settings.PartyName != "Office Party" || settings.ThemeColor != "#ff6600" {
+		t.Errorf("Expected the room to get the server's settings, but got %v", settings)
+	}
+
+	room := &bepb.DisplaySettings{RoomId: testRoomId, PartyName: "Keep Party", Banner: "Pizza at 7", RomanizedTitles: true}
+	if saved, err := dbManager.SetDisplaySettings(room); !saved || err != nil {
+		t.Fatalf("Failed to save the room's display settings: %t, %v", saved, err)
+	}
+
+	settings, _ = dbManager.GetDisplaySettings(testRoomId)
+	if settings.PartyName != "Keep Party" || settings.Banner != "Pizza at 7" || settings.ShowSubmitter ||
+		!settings.RomanizedTitles {
+		t.Errorf("Expected the room's own settings, but got %v", settings)
+	}
+
+	if settings, _ = dbManager.GetDisplaySettings(0); settings.PartyName != "Office Party" {
+		t.Errorf("Expected the server's settings to stay, but got %v", settings)
+	}
+
+	if saved, err := dbManager.SetDisplaySettings(&bepb.DisplaySettings{RoomId: 42, PartyName: "Nowhere"}); saved {
+		t.Errorf("Expected settings for a missing room not to be saved, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestAddHistorySong_whenAlreadyRecorded_returnsFalse(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	submitted := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+		UserId: testUserId, RoomId: testRoomId}
+	added, err := dbManager.AddHistorySong(song, submitted, true)
+	if err != nil || !added {
+		t.Fatal("Error when adding history song", err)
+	}
+
+	if added, _ = dbManager.AddHistorySong(song, submitted, true); added {
+		t.Error("DB manager should not add the same submission twice")
+	}
+
+	dbManager.AddSong(&cmpb.Song{Title: "Newer", Service: testSong.Service, ServiceId: "0xfeedface",
+		UserId: testUserId, RoomId: testRoomId})
+
+	history, err := dbManager.GetRecentSongs(10)
+	if err != nil {
+		t.Fatal("Get recent songs failed with error:", err)
+	}
+
+	if len(history) != 2 || history[0].Song.Title != "Newer" || history[1].Song.SongId != song.SongId {
+		t.Fatalf("Expected the newer song first, but got %v", history)
+	}
+
+	if !history[1].Date.Equal(submitted) || !history[1].Skipped || history[1].Song.Username != testUserName {
+		t.Errorf("History song was not restored as submitted: %v", history[1])
+	}
+
+	rooms, err := dbManager.GetRooms()
+	if err != nil || len(rooms) != 1 || rooms[0].Room.Name != testRoomName {
+		t.Errorf("Expected the test room, but got %v with error %v", rooms, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSaveRecap_getsSongsBetweenAndRecaps(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	start := time.Date(2020, time.March, 6, 20, 0, 0, 0, time.UTC)
+	for i, title := range []string{"Before", "First", "Last"} {
+		dbManager.AddHistorySong(&cmpb.Song{Title: title, Service: testSong.Service, ServiceId: title,
+			UserId: testUserId, RoomId: testRoomId, Metadata: &cmpb.Metadata{Duration: "PT3M"}},
+			start.Add(time.Duration(i-1)*time.Hour), false)
+	}
+
+	songs, err := dbManager.GetSongsBetween(start, start.Add(2*time.Hour))
+	if err != nil || len(songs) != 2 || songs[0].Song.Title != "First" || songs[1].Song.Title != "Last" ||
+		songs[0].Song.Metadata.Duration != "PT3M" {
+		t.Fatalf("Expected the first and last songs with their durations, but got %v with error %v", songs, err)
+	}
+
+	if _, err = dbManager.GetRecap(0); err != sql.ErrNoRows {
+		t.Errorf("Expected no recaps yet, but got %v", err)
+	}
+
+	for _, total := range []uint32{2, 5} {
+		recap := &bepb.PartyRecap{StartedAt: start.Unix(), EndedAt: start.Add(2 * time.Hour).Unix(),
+			TotalSongs: total, FirstSong: &songs[0].Song}
+		if err = dbManager.SaveRecap(recap); err != nil || recap.Id == 0 {
+			t.Fatalf("Error when saving the recap: %v", err)
+		}
+	}
+
+	recap, err := dbManager.GetRecap(0)
+	if err != nil || recap.TotalSongs != 5 || recap.Id != 2 {
+		t.Errorf("Expected the latest recap, but got %v with error %v", recap, err)
+	}
+
+	recap, err = dbManager.GetRecap(1)
+	if err != nil || recap.TotalSongs != 2 || recap.FirstSong.Title != "First" {
+		t.Errorf("Expected the first recap, but got %v with error %v", recap, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSaveJingle_replacesByName(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.SaveJingle(&bepb.Jingle{Name: "station", Link: "/srv/station.mp3"})
+	dbManager.SaveJingle(&bepb.Jingle{Name: "ident", Link: "/srv/ident.mp3"})
+	dbManager.SaveJingle(&bepb.Jingle{Name: "station", Link: "/srv/station2.mp3"})
+
+	jingles, err := dbManager.GetJingles()
+	if err != nil || len(jingles) != 2 || jingles[0].Name != "ident" || jingles[1].Link != "/srv/station2.mp3" {
+		t.Fatalf("Expected two jingles by name, but got %v with error %v", jingles, err)
+	}
+
+	if removed, err := dbManager.RemoveJingle("ident"); err != nil || !removed {
+		t.Errorf("Expected the jingle to be removed, but got %v", err)
+	}
+
+	if removed, _ := dbManager.RemoveJingle("ident"); removed {
+		t.Error("Expected nothing to remove the second time")
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetRegisteredPlayerByToken(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.SaveRegisteredPlayer(&bepb.RegisteredPlayer{Name: "living room Pi", Token: "abc", Zone: "default"})
+	dbManager.SaveRegisteredPlayer(&bepb.RegisteredPlayer{Name: "living room Pi", Token: "def", OutputDevice: "hdmi"})
+
+	if _, err = dbManager.GetRegisteredPlayerByToken("abc"); err != sql.ErrNoRows {
+		t.Errorf("Expected the replaced token to be gone, but got %v", err)
+	}
+
+	player, err := dbManager.GetRegisteredPlayerByToken("def")
+	if err != nil || player.OutputDevice != "hdmi" || player.Zone != "" || player.LastSeen != 0 {
+		t.Fatalf("Expected the replaced player, but got %v with error %v", player, err)
+	}
+
+	dbManager.TouchRegisteredPlayer("living room Pi")
+	players, err := dbManager.GetRegisteredPlayers()
+	if err != nil || len(players) != 1 || players[0].LastSeen == 0 {
+		t.Errorf("Expected the player to have been seen, but got %v with error %v", players, err)
+	}
+
+	if removed, err := dbManager.RemoveRegisteredPlayer("living room Pi"); err != nil || !removed {
+		t.Errorf("Expected the player to be removed, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestGetApiKeyByHash(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	key := &bepb.ApiKey{Name: "kiosk", Scope: bepb.ApiKeyScope_PlayerKey}
+	if saved, err := dbManager.SaveApiKey(key, "hash"); err != nil || !saved {
+		t.Fatalf("Expected the key to be saved, but got %v", err)
+	}
+
+	if saved, _ := dbManager.SaveApiKey(key, "other"); saved {
+		t.Errorf("Expected a second key with the name to be turned away")
+	}
+
+	saved, err := dbManager.GetApiKeyByHash("hash")
+	if err != nil || saved.Name != "kiosk" || saved.Scope != bepb.ApiKeyScope_PlayerKey || saved.Created == 0 {
+		t.Fatalf("Expected the kiosk's key, but got %v with error %v", saved, err)
+	}
+
+	if removed, err := dbManager.RemoveApiKey("kiosk"); err != nil || !removed {
+		t.Errorf("Expected the key to be removed, but got %v", err)
+	}
+
+	if _, err = dbManager.GetApiKeyByHash("hash"); err != sql.ErrNoRows {
+		t.Errorf("Expected the removed key to be gone, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestCheckDatabase(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	if err = CheckDatabase(testDbLocation); err != nil {
+		t.Errorf("Expected the database to pass the check, but got %v", err)
+	}
+
+	if err = CheckDatabase(testDbLocation + ".missing"); err != nil {
+		t.Errorf("Expected a missing database to pass the check, but got %v", err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestCopyDatabase(t *testing.T) {
+	dbManager, err := initDatabase()
+	if err != nil {
+		t.Fatal("Error when initializing the database", err)
+	}
+	defer cleanUp(dbManager)
+
+	if _, err = dbManager.AddRoom(testRoomName); err != nil {
+		t.Fatalf("Failed to add a room: %v", err)
+	}
+
+	copied := testDbLocation + ".copy"
+	defer os.Remove(copied)
+	if err = CopyDatabase(testDbLocation, copied); err != nil {
+		t.Fatalf("Failed to copy the database: %v", err)
+	}
+
+	counts, err := CountRows(copied)
+	if err != nil || counts["rooms"] != 1 || counts["songs"] != 0 {
+		t.Errorf("Expected the copy to have the room, got %v and %v", counts, err)
+	}
+
+	if err = CopyDatabase(testDbLocation+".missing", copied+".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing database to fail the copy, but got %v", err)
+	}
+}
+
+func TestReserveSongIds(t *testing.T) {
+	dbManager, err := initDatabase()
+	if err != nil {
+		t.Fatal("Error when initializing the database", err)
+	}
+	defer cleanUp(dbManager)
+
+	room, _ := dbManager.AddRoom(testRoomName)
+	user, _ := dbManager.AddUser(testUserName, room.Room.Id)
+
+	first, err := dbManager.ReserveSongIds(10)
+	if err != nil || first != 1 {
+		t.Fatalf("Expected the block to start at 1, got %d with error %v", first, err)
+	}
+
+	reserved := &cmpb.Song{SongId: first + 3, Title: "reserved", ServiceId: "reserved", UserId: user.User.UserId,
+		RoomId: room.Room.Id}
+	if err = dbManager.AddReservedSong(reserved); err != nil {
+		t.Fatalf("Failed to add the reserved song: %v", err)
+	}
+
+	added := &cmpb.Song{Title: "added", ServiceId: "added", UserId: user.User.UserId, RoomId: room.Room.Id}
+	if err = dbManager.AddSong(added); err != nil || added.SongId != 11 {
+		t.Errorf("Expected the added song numbered after the block, got %d with error %v", added.SongId, err)
+	}
+
+	if next, err := dbManager.ReserveSongIds(10); err != nil || next != 12 {
+		t.Errorf("Expected the next block to start at 12, got %d with error %v", next, err)
+	}
+}
+
+func TestInit_inMemory(t *testing.T) {
+	dbManager := new(SqliteManager)
+	if err := dbManager.Init(InMemory); err != nil {
+		t.Fatalf("Failed to open an in-memory database: %v", err)
+	}
+	defer dbManager.Close()
+
+	room, err := dbManager.AddRoom(testRoomName)
+	if err != nil {
+		t.Fatalf("Failed to add a room: %v", err)
+	}
+
+	// the room is seen by later queries, which share the one connection
+	if _, err = dbManager.AddUser(testUserName, room.Room.Id); err != nil {
+		t.Errorf("Expected the user to join the room, got %v", err)
+	}
+
+	if _, err = os.Stat(InMemory); !os.IsNotExist(err) {
+		t.Errorf("Expected no database file to be created, got %v", err)
+	}
+}
+
+func TestGetRecentSongs_whenAnonymous_keepsSubmitter(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	addedSong := testSong
+	addedSong.Anonymous = true
+	dbManager.AddSong(&addedSong)
+
+	history, err := dbManager.GetRecentSongs(1)
+	if err != nil || len(history) != 1 {
+		t.Fatal("Get recent songs failed with error:", err)
+	}
+
+	// the submitter is still on record for moderation
+	if song := history[0].Song; !song.Anonymous || song.Username != testUserName {
+		t.Error("DB manager should return the submitter of an anonymous song:", song)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestRemoveDemoData_keepsOtherRooms(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	kept := testSong
+	dbManager.AddSong(&kept)
+
+	demoRoom, _ := dbManager.AddRoom("Demo")
+	demoUser, _ := dbManager.AddUser("Alice", demoRoom.Room.Id)
+	dbManager.MarkDemoRoom(demoRoom.Room.Id)
+
+	song := &cmpb.Song{Title: "Demo song", Service: cmpb.ServiceType_Youtube, ServiceId: "dQw4w9WgXcQ",
+		UserId: demoUser.User.UserId, RoomId: demoRoom.Room.Id}
+	dbManager.AddSong(song)
+	dbManager.AddReaction(song.SongId, testUserId, "🔥")
+	dbManager.AddReaction(kept.SongId, demoUser.User.UserId, "🔥")
+	dbManager.AddAchievement(demoUser.User.UserId, "first_song")
+
+	removed, err := dbManager.RemoveDemoData()
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected the demo user removed, got %d and %v", removed, err)
+	}
+
+	if _, err = dbManager.GetRoomByName("Demo"); !errors.Is(err, sql.ErrNoRows) {
+		t.Error("The demo room should be removed:", err)
+	}
+
+	history, _ := dbManager.GetRecentSongs(10)
+	if len(history) != 1 || history[0].Song.SongId != kept.SongId {
+		t.Error("Only the songs outside the demo room should be left:", history)
+	}
+
+	if counts, _ := dbManager.GetReactionCounts(kept.SongId); len(counts) != 0 {
+		t.Error("The demo user's reactions should be removed:", counts)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSchemaStatements_inFileOrder(t *testing.T) {
+	tables, err := schemaStatements("create")
+	if err != nil {
+		t.Fatalf("Failed to read the schema: %v", err)
+	}
+
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = table.name
+	}
+
+	// users and songs refer to the rooms they're in
+	if strings.Join(names, ",") != "rooms,users,songs" {
+		t.Errorf("Expected the rooms, users and songs tables in order, got %v", names)
+	}
+
+	upgrades, err := schemaStatements("upgrades")
+	if err != nil || len(upgrades) == 0 {
+		t.Fatalf("Failed to read the upgrades: %v", err)
+	}
+
+	for _, upgrade := range upgrades {
+		if !strings.Contains(upgrade.statement, "IF NOT EXISTS") {
+			t.Errorf("Expected the %s upgrade to be safe to run again", upgrade.name)
+		}
+	}
+}
+
+func TestOpenReadReplica_readsCommittedSongs(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	if err = dbManager.OpenReadReplica(testDbLocation); err != nil {
+		t.Fatal("Opening the read replica failed with error:", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+	if err = dbManager.AddSong(&testSong); err != nil {
+		t.Fatal("Error when adding new song", err)
+	}
+
+	history, err := dbManager.GetRecentSongs(10)
+	if err != nil || len(history) != 1 || history[0].Song.ServiceId != testSong.ServiceId {
+		t.Errorf("Expected the song read from the replica, but got %v with error %v", history, err)
+	}
+
+	if _, err = dbManager.replica.Exec("DELETE FROM songs"); err == nil {
+		t.Error("Expected the read replica to refuse writes")
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestOpenReadReplica_whenInMemory_fails(t *testing.T) {
+	dbManager := new(SqliteManager)
+	if err := dbManager.Init(InMemory); err != nil {
+		t.Fatal("Error when initializing the database", err)
+	}
+	defer dbManager.Close()
+
+	if err := dbManager.OpenReadReplica(InMemory); err == nil {
+		t.Error("Expected an in-memory database to have no read replica")
+	}
+}
+
+func TestBlockSong_excludesFallbackCandidates(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	song := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+		UserId: testUserId, RoomId: testRoomId}
+	if err = dbManager.AddSong(song); err != nil {
+		t.Fatal("Error when adding new song", err)
+	}
+
+	// blocking twice is harmless
+	for i := 0; i < 2; i++ {
+		if err = dbManager.BlockSong(testSong.Service, testSong.ServiceId); err != nil {
+			t.Fatal("Block song failed with error:", err)
+		}
+	}
+
+	if blocked, err := dbManager.IsSongBlocked(testSong.Service, testSong.ServiceId); err != nil || !blocked {
+		t.Errorf("Expected the song to be blocked, but got %t with error %v", blocked, err)
+	}
+
+	candidates, err := dbManager.GetFallbackCandidates(time.Now().Add(time.Hour), 10)
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("Blocked song should not be a candidate, but got %v with error %v", candidates, err)
+	}
+
+	if unblocked, err := dbManager.UnblockSong(testSong.Service, testSong.ServiceId); err != nil || !unblocked {
+		t.Errorf("Expected the song to be unblocked, but got %t with error %v", unblocked, err)
+	}
+
+	if unblocked, _ := dbManager.UnblockSong(testSong.Service, testSong.ServiceId); unblocked {
+		t.Error("Expected unblocking a song that isn't blocked to return false")
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSongTags_findAndFilterByTag(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	dbManager.AddRoom(testRoomName)
+	dbManager.AddUser(testUserName, testRoomId)
+
+	tagged := &cmpb.Song{Title: testSong.Title, Service: testSong.Service, ServiceId: testSong.ServiceId,
+		UserId: testUserId, RoomId: testRoomId}
+	untagged := &cmpb.Song{Title: "Untagged", Service: testSong.Service, ServiceId: "untagged",
+		UserId: testUserId, RoomId: testRoomId}
+	for _, song := range []*cmpb.Song{tagged, untagged} {
+		if err = dbManager.AddSong(song); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+	}
+
+	for _, tag := range []string{"chill", "throwback", "chill"} {
+		dbManager.AddSongTag(tagged.Service, tagged.ServiceId, tag, testUserId)
+	}
+
+	if added, _ := dbManager.AddSongTag(tagged.Service, tagged.ServiceId, "chill", testUserId); added {
+		t.Error("Expected tagging a song twice with the same tag to return false")
+	}
+
+	songs, err := dbManager.FindTaggedSongs("chi", 10)
+	if err != nil || len(songs) != 1 {
+		t.Fatalf("Expected the tagged song to be found, got %v with error %v", songs, err)
+	} else if songs[0].Song.ServiceId != tagged.ServiceId || strings.Join(songs[0].Tags, ",") != "chill,throwback" {
+		t.Errorf("Expected the song with all its tags, got %v", songs[0])
+	}
+
+	candidates, err := dbManager.GetTaggedFallbackCandidates("chill", time.Now().Add(time.Hour), 10)
+	if err != nil || len(candidates) != 1 || candidates[0].Song.ServiceId != tagged.ServiceId {
+		t.Errorf("Expected only the tagged song to be a candidate, got %v with error %v", candidates, err)
+	}
+
+	if removed, err := dbManager.RemoveSongTag(tagged.Service, tagged.ServiceId, "chill", testUserId); err != nil || !removed {
+		t.Errorf("Expected the tag to be removed, got %t with error %v", removed, err)
+	}
+
+	if songs, _ = dbManager.FindTaggedSongs("chill", 10); len(songs) != 0 {
+		t.Errorf("Expected no songs tagged chill once the tag is removed, got %v", songs)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestScheduledSongs_soonestFirst(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	now := time.Now()
+	for _, scheduled := range []*bepb.ScheduledSong{
+		{Link: "later", PlayAt: now.Add(2 * time.Hour).Unix()},
+		{Link: "sooner", PlayAt: now.Add(time.Hour).Unix(), Interrupt: true},
+	} {
+		if err = dbManager.SaveScheduledSong(scheduled); err != nil || scheduled.Id == 0 {
+			t.Fatalf("Expected the song to be scheduled with an id, got %v with error %v", scheduled, err)
+		}
+	}
+
+	songs, err := dbManager.GetScheduledSongs()
+	if err != nil || len(songs) != 2 || songs[0].Link != "sooner" || !songs[0].Interrupt {
+		t.Fatalf("Expected the sooner song first, got %v with error %v", songs, err)
+	}
+
+	if removed, err := dbManager.RemoveScheduledSong(songs[0].Id); err != nil || !removed {
+		t.Errorf("Expected the scheduled song to be removed, got %t with error %v", removed, err)
+	}
+
+	if removed, _ := dbManager.RemoveScheduledSong(songs[0].Id); removed {
+		t.Error("Expected removing it again to return false")
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestLinkIdentity_replacesTheLink(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	room, _ := dbManager.AddRoom(testRoomName)
+	zedd, _ := dbManager.AddUser(testUserName, room.Room.Id)
+	kahlan, _ := dbManager.AddUser("Kahlan", room.Room.Id)
+
+	if _, err = dbManager.GetIdentity("discord", "1234"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected no link before linking, got %v", err)
+	}
+
+	for _, userId := range []uint32{zedd.User.UserId, kahlan.User.UserId} {
+		if err = dbManager.LinkIdentity(&bepb.ExternalIdentity{Provider: "discord", ExternalId: "1234",
+			UserId: userId}); err != nil {
+			t.Fatalf("Failed to link the identity: %v", err)
+		}
+	}
+
+	linked, err := dbManager.GetIdentity("discord", "1234")
+	if err != nil || linked.UserId != kahlan.User.UserId || linked.LinkedAt == 0 {
+		t.Errorf("Expected the identity to be linked to the last user, got %v with error %v", linked, err)
+	}
+
+	if identities, _ := dbManager.GetUserIdentities(zedd.User.UserId); len(identities) != 0 {
+		t.Errorf("Expected the first user to lose the link, got %v", identities)
+	}
+
+	if removed, err := dbManager.UnlinkIdentity("discord", "1234"); err != nil || !removed {
+		t.Errorf("Expected the identity to be unlinked, got %t with error %v", removed, err)
+	}
+
+	cleanUp(dbManager)
+}
+
+func TestSetSongOutcome_attributesSkips(t *testing.T) {
+	dbManager, err := initDatabase()
+
+	if err != nil {
+		t.Error("Error when initializing the database", err)
+	}
+
+	room, _ := dbManager.AddRoom(testRoomName)
+	zedd, _ := dbManager.AddUser(testUserName, room.Room.Id)
+	kahlan, _ := dbManager.AddUser("Kahlan", room.Room.Id)
+	richard, _ := dbManager.AddUser("Richard", room.Room.Id)
+
+	songs := make([]*cmpb.Song, 3)
+	for i := range songs {
+		songs[i] = &cmpb.Song{Title: fmt.Sprintf("Song %d", i), ServiceId: fmt.Sprintf("id%d", i),
+			UserId: zedd.User.UserId, RoomId: room.Room.Id}
+		if err = dbManager.AddSong(songs[i]); err != nil {
+			t.Fatal("Error when adding new song", err)
+		}
+	}
+
+	voters := []uint32{kahlan.User.UserId, richard.User.UserId}
+	if err = dbManager.SetSongOutcome(songs[0].SongId, bepb.SongOutcome_SkippedByVote, "", voters); err != nil {
+		t.Fatal("Set song outcome failed with error:", err)
+	}
+	dbManager.SetSongOutcome(songs[1].SongId, bepb.SongOutcome_SkippedByVote, "", voters[:1])
+	dbManager.SetSongOutcome(songs[2].SongId, bepb.SongOutcome_SkippedByAdmin, "admin", nil)
+
+	history, err := dbManager.GetHistory(zedd.User.UserId, 10)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("Expected the user's 3 songs in the history, got %d with error %v", len(history), err)
+	}
+
+	first := history[2]
+	if first.Outcome != bepb.SongOutcome_SkippedByVote || len(first.Voters) != 2 {
+		t.Errorf("Expected the first song voted off by two users, got %v by %v", first.Outcome, first.Voters)
+	}
+
+	if history[0].SkippedBy != "admin" || len(history[0].Voters) != 0 {
+		t.Errorf("Expected the last song skipped by the admin, got %q and %v", history[0].SkippedBy, history[0].Voters)
+	}
+
+	if others, _ := dbManager.GetHistory(kahlan.User.UserId, 10); len(others) != 0 {
+		t.Errorf("Expected no songs of another user, got %d", len(others))
+	}
+
+	counts, err := dbManager.GetOutcomeCounts(zedd.User.UserId)
+	if err != nil || counts[bepb.SongOutcome_SkippedByVote] != 2 || counts[bepb.SongOutcome_SkippedByAdmin] != 1 {
+		t.Errorf("Expected 2 songs voted off and 1 skipped, got %v with error %v", counts, err)
+	}
+
+	skippers, err := dbManager.GetSkippers(zedd.User.UserId)
+	if err != nil || len(skippers) != 3 {
+		t.Fatalf("Expected 3 skippers, got %v with error %v", skippers, err)
+	}
+
+	if skippers[0].UserId != kahlan.User.UserId || skippers[0].Skips != 2 {
+		t.Errorf("Expected Kahlan to have skipped the most, got %v", skippers[0])
+	}
+
+	// ties are listed by name
+	if skippers[2].UserId != 0 || skippers[2].Name != "admin" || skippers[2].Skips != 1 {
+		t.Errorf("Expected the admin to be listed after Richard, got %v", skippers[2])
+	}
+
+	cleanUp(dbManager)
+}
@@ -0,0 +1,34 @@
+//go:build cgo
+
+/*
+ * Tells errors worth retrying apart from the rest. The sqlite driver only
+ * reports its result codes when it's built with cgo, so binaries built
+ * without it, which can't open a database anyway, get a stand in.
+ */
+
+package database
+
+import (
+	"errors"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+/*
+ * Returns true if an error is one a query may not run into if it's tried
+ * again, like the database being locked by another connection or the disk
+ * failing to keep up
+ */
+func IsTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrIoErr, sqlite3.ErrFull, sqlite3.ErrCantOpen:
+		return true
+	}
+
+	return false
+}
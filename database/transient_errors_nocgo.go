@@ -0,0 +1,16 @@
+//go:build !cgo
+
+/*
+ * Stands in for telling errors worth retrying apart in binaries built without
+ * cgo, where the sqlite driver has no result codes and can't open a database.
+ */
+
+package database
+
+/*
+ * Returns false, since no error from a driver that can't run goes away if
+ * it's tried again
+ */
+func IsTransient(err error) bool {
+	return false
+}
@@ -0,0 +1,53 @@
+/*
+ * The web UI's static files and views, embedded in the binary so the frontend
+ * runs from any directory as a single file. In debug mode they're read from
+ * the frontend directory instead, so edits show up without a rebuild.
+ */
+
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/foolin/goview"
+	"github.com/foolin/goview/supports/ginview"
+)
+
+//go:embed static views
+var assets embed.FS
+
+/*
+ * Returns the embedded static files, rooted at the static directory
+ */
+func staticFiles() http.FileSystem {
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.FS(static)
+}
+
+/*
+ * Read a view out of the embedded views
+ */
+func embeddedView(config goview.Config, name string) (string, error) {
+	data, err := fs.ReadFile(assets, path.Join(config.Root, name+config.Extension))
+	return string(data), err
+}
+
+/*
+ * Returns the view engine, reading the views from the binary unless
+ * debugging
+ */
+func newViewEngine(config goview.Config, isDebug bool) *ginview.ViewEngine {
+	engine := ginview.New(config)
+	if !isDebug {
+		engine.SetFileHandler(embeddedView)
+	}
+
+	return engine
+}
@@ -7,25 +7,33 @@ package frontend
 import (
 	"errors"
 	"log"
+	"sync"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/nguyenmq/ytbox-go/common"
 	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
 type BackendClient struct {
-	connection *grpc.ClientConn      // grpc connection
-	be_client  bepb.YtbBackendClient // backend client
+	connection   *grpc.ClientConn      // grpc connection
+	be_client    bepb.YtbBackendClient // backend client
+	playlist     *bepb.Playlist        // last playlist fetched, reused until the queue changes
+	playlistLock sync.Mutex            // lock on the last playlist
 }
 
-func (c *BackendClient) Connect(host string, port string) error {
+func (c *BackendClient) Connect(host string, port string, token string, partyPassword string) error {
 	var err error
 	var opts []grpc.DialOption
 	opts = append(opts, grpc.WithInsecure())
 	opts = append(opts, grpc.WithBlock())
 	opts = append(opts, grpc.FailOnNonTempDialError(true))
+	opts = append(opts, common.TokenDialOptions(token)...)
+	opts = append(opts, common.PartyPasswordDialOptions(partyPassword)...)
 
 	c.connection, err = grpc.Dial(host+":"+port, opts...)
 	if err != nil {
@@ -38,26 +46,44 @@ func (c *BackendClient) Connect(host string, port string) error {
 	return err
 }
 
+/*
+ * Get the playlist. Only asks for the songs if the queue changed since the
+ * last time, since browsers poll it every few seconds.
+ */
 func (c *BackendClient) GetPlaylist() (*bepb.Playlist, error) {
-	playlist, err := c.be_client.GetPlaylist(context.Background(), &cmpb.Empty{})
+	c.playlistLock.Lock()
+	defer c.playlistLock.Unlock()
+
+	request := &bepb.PlaylistRequest{SinceGeneration: c.playlist.GetGeneration()}
+	playlist, err := c.be_client.GetPlaylistIfChanged(context.Background(), request)
 
 	if err != nil {
 		log.Printf("Failed to fetch playlist with error: %v\n", err)
+		return playlist, err
+	}
+
+	if !playlist.NotModified {
+		c.playlist = playlist
 	}
 
-	return playlist, err
+	return c.playlist, nil
 }
 
-func (c *BackendClient) SendNewSong(link string, user_id uint32) (*bepb.Error, error) {
+func (c *BackendClient) SendNewSong(link string, user_id uint32, for_username string,
+	anonymous bool) (*bepb.Error, error) {
 	var submission = bepb.Submission{
-		Link:   link,
-		UserId: user_id,
+		Link:        link,
+		UserId:      user_id,
+		Source:      cmpb.SubmissionSource_WebUi,
+		ForUsername: for_username,
+		Anonymous:   anonymous,
 	}
 
 	response, err := c.be_client.SendSong(context.Background(), &submission)
 
 	if err != nil {
 		log.Printf("Failed to send new song with error: %v\n", err)
+		return nil, rpcError(err)
 	}
 
 	if !response.Success {
@@ -92,34 +118,139 @@ func (c *BackendClient) RemoveSong(song_id uint32, user_id uint32) (*bepb.Error,
 	return response, err
 }
 
-func (c *BackendClient) LoginNewUser(userName string, roomName string) (*bepb.User, error) {
+/*
+ * Returns the ids of the songs the user has waiting in the queue, including
+ * the ones they submitted anonymously, which the playlist doesn't tie to them
+ */
+func (c *BackendClient) GetQueuedSongIds(user_id uint32) map[uint32]bool {
+	ids := make(map[uint32]bool)
+	queue, err := c.be_client.ListQueuedByUser(context.Background(), &bepb.UserQueueRequest{UserId: user_id})
+	if err != nil {
+		log.Printf("Failed to list the user's songs with error: %v\n", err)
+		return ids
+	}
+
+	for _, queued := range queue.Songs {
+		ids[queued.Song.SongId] = true
+	}
+	return ids
+}
+
+func (c *BackendClient) LoginNewUser(userName string, roomName string, password string) (*bepb.User, error) {
 	roomRequest := bepb.Room{Name: roomName}
 
 	room, err := c.be_client.GetRoom(context.Background(), &roomRequest)
 	if err != nil {
 		log.Printf("Failed to describe room with error: %v\n", err)
-		return nil, err
+		return nil, rpcError(err)
 	}
 	if !room.Err.Success {
 		log.Printf("Requested room %s does not exist\n", roomName)
 		return nil, ErrRoomNotFound
 	}
 
-	userRequest := bepb.User{Username: userName, RoomId: room.Id}
+	userRequest := bepb.User{Username: userName, RoomId: room.Id, PartyPassword: password}
 	user, err := c.be_client.LoginUser(context.Background(), &userRequest)
 	if err != nil {
 		log.Printf("Failed to login user with error: %v\n", err)
-		return nil, err
+		return nil, rpcError(err)
 	}
 
 	if user.UserId == 0 {
-		log.Printf("Failed to login")
+		log.Printf("Failed to login: %s", user.Err.GetMessage())
+		if user.Err.GetMessage() != "" {
+			return nil, errors.New(user.Err.GetMessage())
+		}
 		return nil, ErrFailedLogin
 	}
 
 	return user, err
 }
 
+func (c *BackendClient) CreateLoginCode() (*bepb.LoginCode, error) {
+	code, err := c.be_client.CreateLoginCode(context.Background(), &cmpb.Empty{})
+	if err != nil {
+		log.Printf("Failed to create login code with error: %v\n", err)
+		return nil, rpcError(err)
+	}
+
+	if !code.Err.Success {
+		return nil, errors.New(code.Err.Message)
+	}
+
+	return code, nil
+}
+
+func (c *BackendClient) ConfirmLoginCode(user_id uint32, code string) error {
+	request := bepb.LoginCodeConfirmation{UserId: user_id, Code: code}
+	response, err := c.be_client.ConfirmLoginCode(context.Background(), &request)
+	if err != nil {
+		log.Printf("Failed to confirm login code with error: %v\n", err)
+		return rpcError(err)
+	}
+
+	if !response.Success {
+		return errors.New(response.Message)
+	}
+
+	return nil
+}
+
+func (c *BackendClient) RedeemLoginCode(code string, secret string) (*bepb.User, error) {
+	user, err := c.be_client.RedeemLoginCode(context.Background(), &bepb.LoginCode{Code: code, Secret: secret})
+	if err != nil {
+		log.Printf("Failed to redeem login code with error: %v\n", err)
+		return nil, rpcError(err)
+	}
+
+	if !user.Err.Success {
+		return nil, errors.New(user.Err.Message)
+	}
+
+	return user, nil
+}
+
+func (c *BackendClient) Heartbeat(user_id uint32) (*bepb.Error, error) {
+	response, err := c.be_client.Heartbeat(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to send heartbeat with error: %v\n", err)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) GetServerInfo(user_id uint32) (*bepb.ServerInfo, error) {
+	response, err := c.be_client.GetServerInfo(context.Background(), &bepb.User{UserId: user_id})
+
+	if err != nil {
+		log.Printf("Failed to get server info with error: %v\n", err)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) GetJoinCode(room string, size uint32, baseUrl string) (*bepb.JoinCode, error) {
+	request := &bepb.JoinCodeRequest{Room: room, Size: size, BaseUrl: baseUrl}
+	response, err := c.be_client.GetJoinCode(context.Background(), request)
+
+	if err != nil {
+		log.Printf("Failed to get join code with error: %v\n", err)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) StreamEvents(ctx context.Context, resumeAfter uint64) (bepb.YtbBackend_EventsClient, error) {
+	stream, err := c.be_client.Events(ctx, &bepb.EventsRequest{ResumeAfter: resumeAfter})
+
+	if err != nil {
+		log.Printf("Failed to stream events with error: %v\n", err)
+	}
+
+	return stream, err
+}
+
 func (c *BackendClient) NextSong() (*bepb.Error, error) {
 	response, err := c.be_client.NextSong(context.Background(), &cmpb.Empty{})
 
@@ -129,3 +260,84 @@ func (c *BackendClient) NextSong() (*bepb.Error, error) {
 
 	return response, err
 }
+
+func (c *BackendClient) GetBluetoothDevices() (*bepb.BluetoothList, error) {
+	response, err := c.be_client.ListBluetoothDevices(context.Background(), &bepb.Zone{})
+
+	if err != nil {
+		log.Printf("Failed to list bluetooth speakers with error: %v\n", err)
+		return nil, err
+	}
+
+	if !response.Err.Success {
+		err = errors.New(response.Err.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) ScanBluetooth() (*bepb.Error, error) {
+	response, err := c.be_client.ScanBluetooth(context.Background(), &bepb.BluetoothRequest{})
+
+	if err != nil {
+		log.Printf("Failed to scan for bluetooth speakers with error: %v\n", err)
+		return nil, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) PairBluetooth(player_id uint32, address string) (*bepb.Error, error) {
+	var request = bepb.BluetoothRequest{
+		PlayerId: player_id,
+		Address:  address,
+	}
+
+	response, err := c.be_client.PairBluetooth(context.Background(), &request)
+
+	if err != nil {
+		log.Printf("Failed to pair bluetooth speaker with error: %v\n", err)
+		return nil, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+func (c *BackendClient) ConnectBluetooth(player_id uint32, address string) (*bepb.Error, error) {
+	var request = bepb.BluetoothRequest{
+		PlayerId: player_id,
+		Address:  address,
+	}
+
+	response, err := c.be_client.ConnectBluetooth(context.Background(), &request)
+
+	if err != nil {
+		log.Printf("Failed to connect bluetooth speaker with error: %v\n", err)
+		return nil, err
+	}
+
+	if !response.Success {
+		err = errors.New(response.Message)
+	}
+
+	return response, err
+}
+
+/*
+ * Returns an error that can be shown to the user. Requests the backend
+ * rejected as invalid are reported with the backend's message.
+ */
+func rpcError(err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.InvalidArgument {
+		return errors.New(st.Message())
+	}
+	return err
+}
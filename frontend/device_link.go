@@ -0,0 +1,169 @@
+/*
+ * Signs in new devices, like a TV, without typing a username on them. The new
+ * device shows a short code and a QR code linking to the confirmation page.
+ * Once the user confirms the code on a device they're signed in on, the new
+ * device picks up the session on its next poll.
+ */
+
+package frontend
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var ErrMissingLoginCode = errors.New("Missing the code shown on the other device.")
+
+const (
+	linkCookieName = "ytbox_link"    // cookie holding the code and secret of the device being linked
+	qrCodeSize     = 256             // width and height of the QR code in pixels
+	linkPollTime   = 2 * time.Second // time between the link page's checks for confirmation
+)
+
+/*
+ * Show a code for signing this device in from another one
+ */
+func (s *FrontendServer) HandleLinkPage(context *gin.Context) {
+	code, err := s.client.CreateLoginCode()
+	if err != nil {
+		buildLoginErrorPage(context, "", "", err)
+		return
+	}
+
+	value := map[string]string{"code": code.Code, "secret": code.Secret}
+	encoded, err := s.cookie.Encode(linkCookieName, value)
+	if err != nil {
+		buildLoginErrorPage(context, "", "", err)
+		return
+	}
+	http.SetCookie(context.Writer, &http.Cookie{
+		Name:   linkCookieName,
+		Value:  encoded,
+		Path:   "/",
+		MaxAge: int(code.ExpiresIn),
+	})
+
+	// the QR code is only a shortcut, so the page still works without it
+	confirmUrl := linkConfirmUrl(context.Request, code.Code)
+	var qrImage template.URL
+	if png, err := qrcode.Encode(confirmUrl, qrcode.Medium, qrCodeSize); err == nil {
+		qrImage = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	context.HTML(http.StatusOK, "link", s.brand(invalidUserId, "Sign in with another device", gin.H{
+		"code":        code.Code,
+		"qr_image":    qrImage,
+		"confirm_url": confirmUrl,
+		"expires_in":  code.ExpiresIn,
+		"poll_ms":     linkPollTime.Milliseconds(),
+	}))
+}
+
+/*
+ * Check whether the code shown on this device was confirmed, and if so sign
+ * this device in. Polled by the link page.
+ */
+func (s *FrontendServer) HandleLinkStatus(context *gin.Context) {
+	cookie, err := context.Request.Cookie(linkCookieName)
+	value := make(map[string]string)
+	if err == nil {
+		err = s.cookie.Decode(linkCookieName, cookie.Value, &value)
+	}
+
+	if err != nil {
+		context.JSON(http.StatusOK, gin.H{"linked": false, "message": ErrMissingLoginCode.Error()})
+		return
+	}
+
+	user, err := s.client.RedeemLoginCode(value["code"], value["secret"])
+	if err != nil {
+		context.JSON(http.StatusOK, gin.H{"linked": false, "message": err.Error()})
+		return
+	}
+
+	if err = s.setUserIdCookie(context, user.UserId); err != nil {
+		context.JSON(http.StatusOK, gin.H{"linked": false, "message": err.Error()})
+		return
+	}
+
+	http.SetCookie(context.Writer, &http.Cookie{Name: linkCookieName, Path: "/", MaxAge: -1})
+	context.JSON(http.StatusOK, gin.H{"linked": true})
+}
+
+/*
+ * Show the form for confirming a code shown on another device
+ */
+func (s *FrontendServer) HandleLinkConfirmPage(context *gin.Context) {
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		context.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+
+	context.HTML(http.StatusOK, "link_confirm", s.brand(userId, "Sign in another device", gin.H{
+		"code": context.Query("code"),
+	}))
+}
+
+/*
+ * Confirm a code shown on another device, signing that device in as the user
+ */
+func (s *FrontendServer) HandleLinkConfirmPost(context *gin.Context) {
+	userId, err := s.getUserIdCookie(context)
+	if err != nil {
+		context.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	code, _ := context.GetPostForm("code_box")
+	if len(code) == 0 {
+		err = ErrMissingLoginCode
+	} else {
+		err = s.client.ConfirmLoginCode(userId, code)
+	}
+
+	view := s.brand(userId, "Sign in another device", gin.H{
+		"code":      code,
+		"has_alert": true,
+	})
+
+	if err != nil {
+		view["alert_emph"] = AlertEmphError
+		view["alert_type"] = AlertError
+		view["alert_msg"] = err.Error()
+		context.HTML(http.StatusBadRequest, "link_confirm", view)
+		return
+	}
+
+	view["code"] = ""
+	view["alert_emph"] = AlertEmphInfo
+	view["alert_type"] = AlertSuccess
+	view["alert_msg"] = "The other device will be signed in as you in a moment."
+	context.HTML(http.StatusOK, "link_confirm", view)
+}
+
+/*
+ * Returns the address of the page confirming the code, as reached from the
+ * same host the new device is using
+ */
+func linkConfirmUrl(request *http.Request, code string) string {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+
+	confirm := url.URL{
+		Scheme:   scheme,
+		Host:     request.Host,
+		Path:     "/link/confirm",
+		RawQuery: url.Values{"code": []string{code}}.Encode(),
+	}
+	return confirm.String()
+}
@@ -0,0 +1,262 @@
+/*
+ * Streams changes to the queue and the now playing song to browsers as
+ * server-sent events. SSE is plain http, so static pages can follow along with
+ * an EventSource and the stream makes it through proxies that get in the way
+ * of grpc. The frontend keeps a single event stream open to the backend and
+ * fans it out to every browser. When the stream breaks, the frontend resumes
+ * after the last event it got. If events were missed anyway, or the whole
+ * queue was reordered, browsers are sent a fresh snapshot.
+ */
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+const (
+	sseBufferSize     = 16               // events buffered for each browser
+	sseKeepalive      = 15 * time.Second // time between comments that keep idle connections open
+	sseRetry          = 5 * time.Second  // how long browsers wait before reconnecting
+	sseReconnectDelay = 5 * time.Second  // how long to wait before reconnecting to the backend
+)
+
+/*
+ * Events that reorder the whole queue, such as timing out a user moving their
+ * songs to the end. Browsers get a fresh snapshot for them.
+ */
+var reorderEvents = map[bepb.EventType]bool{
+	bepb.EventType_TimeOutStarted: true,
+	bepb.EventType_TimeOutEnded:   true,
+}
+
+/*
+ * An event as sent to browsers
+ */
+type sseEvent struct {
+	name string      // name browsers listen for
+	data interface{} // encoded as json
+}
+
+/*
+ * A change to the queue or the now playing song of a zone
+ */
+type songDelta struct {
+	ZoneId uint32      `json:"zone_id"`
+	Song   *publicSong `json:"song"`
+}
+
+/*
+ * Fans out the backend's events to the browsers streaming them. Browsers that
+ * fall behind miss events instead of holding up the others.
+ */
+type eventHub struct {
+	client      *BackendClient          // backend to stream events from
+	public      *publicCache            // public view, which says whether to show submitters
+	subscribers map[chan *sseEvent]bool // channels of the browsers
+	lock        sync.Mutex              // lock on the subscribers
+	cancel      context.CancelFunc      // stops streaming from the backend
+	sequence    uint64                  // sequence of the last event from the backend. Only used by run
+}
+
+/*
+ * Initialize the hub
+ */
+func (h *eventHub) init(client *BackendClient, public *publicCache) {
+	h.client = client
+	h.public = public
+	h.subscribers = make(map[chan *sseEvent]bool)
+}
+
+/*
+ * Start streaming events from the backend
+ */
+func (h *eventHub) start() {
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	go h.run(ctx)
+}
+
+/*
+ * Stop streaming events from the backend
+ */
+func (h *eventHub) stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+/*
+ * Stream events from the backend until stopped, reconnecting whenever the
+ * stream breaks
+ */
+func (h *eventHub) run(ctx context.Context) {
+	for {
+		stream, err := h.client.StreamEvents(ctx, h.sequence)
+		for err == nil {
+			var event *bepb.Event
+			if event, err = stream.Recv(); err == nil {
+				h.forward(event)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sseReconnectDelay):
+			log.Printf("Reconnecting to the backend's events after: %v", err)
+		}
+	}
+}
+
+/*
+ * Pass an event from the backend on to the browsers. Browsers get a fresh
+ * snapshot instead when events were missed before it or it reordered the
+ * queue, which already has the event's change.
+ */
+func (h *eventHub) forward(event *bepb.Event) {
+	missed := event.Type == bepb.EventType_EventsMissed ||
+		(h.sequence != 0 && event.Sequence != h.sequence+1)
+	h.sequence = event.Sequence
+
+	if missed || reorderEvents[event.Type] {
+		h.public.invalidate()
+		if snapshot, err := h.public.get(time.Now()); err == nil {
+			h.publish(&sseEvent{name: "snapshot", data: string(snapshot)})
+		}
+		return
+	}
+
+	if browserEvent := toSseEvent(event, h.public.showsSubmitter()); browserEvent != nil {
+		h.publish(browserEvent)
+	}
+}
+
+/*
+ * Subscribe to the events. The returned channel must be unsubscribed when the
+ * browser goes away.
+ */
+func (h *eventHub) subscribe() chan *sseEvent {
+	events := make(chan *sseEvent, sseBufferSize)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.subscribers[events] = true
+	return events
+}
+
+/*
+ * Stop sending events to a browser
+ */
+func (h *eventHub) unsubscribe(events chan *sseEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.subscribers, events)
+}
+
+/*
+ * Send an event to every browser. Browsers with a full buffer miss the event.
+ */
+func (h *eventHub) publish(event *sseEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for events := range h.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+/*
+ * Convert a backend event into the event sent to browsers. Returns nil for
+ * events that don't change the queue or the now playing song. Removed songs
+ * only have their ids set.
+ */
+func toSseEvent(event *bepb.Event, showSubmitter bool) *sseEvent {
+	var name string
+	switch event.Type {
+	case bepb.EventType_SongQueued:
+		name = "queued"
+	case bepb.EventType_SongPlaying:
+		name = "playing"
+	case bepb.EventType_SongSkipped:
+		name = "skipped"
+	case bepb.EventType_PlayNextMoved:
+		name = "moved"
+	case bepb.EventType_SongReturned:
+		name = "returned"
+	case bepb.EventType_SongRemoved:
+		name = "removed"
+	default:
+		return nil
+	}
+
+	delta := songDelta{ZoneId: event.ZoneId}
+	if event.Song != nil && event.Song.SongId != 0 {
+		delta.Song = toPublicSong(event.Song, showSubmitter)
+	}
+
+	return &sseEvent{name: name, data: delta}
+}
+
+/*
+ * Stream changes to the queue and the now playing song as server-sent events.
+ * The stream starts with a snapshot of the public view so browsers have
+ * something to apply the changes to.
+ */
+func (s *FrontendServer) HandleEventStream(context *gin.Context) {
+	now := time.Now()
+	context.Header("Access-Control-Allow-Origin", "*")
+
+	if !s.limiter.allow(context.ClientIP(), now) {
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(1/publicRate))))
+		context.JSON(http.StatusTooManyRequests, gin.H{"error": ErrRateLimited.Error()})
+		return
+	}
+
+	snapshot, err := s.public.get(now)
+	if err != nil {
+		context.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrPublicUnavailable.Error()})
+		return
+	}
+
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	context.Header("Cache-Control", "no-cache")
+	context.Header("X-Accel-Buffering", "no")
+	context.Writer.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(context.Writer, "retry: %d\n\n", sseRetry.Milliseconds())
+	context.SSEvent("snapshot", string(snapshot))
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+
+	context.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			context.SSEvent(event.name, event.data)
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case <-context.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,93 @@
+package frontend
+
+import (
+	"testing"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestToSseEvent(t *testing.T) {
+	song := &cmpb.Song{SongId: 7, Title: "Everybody Wants to Rule the World", Username: "Bob", ForUsername: "Alice"}
+
+	tests := []struct {
+		eventType bepb.EventType
+		song      *cmpb.Song
+		name      string // name of the event sent to browsers. Empty if none is sent
+	}{
+		{bepb.EventType_SongQueued, song, "queued"},
+		{bepb.EventType_SongPlaying, song, "playing"},
+		{bepb.EventType_SongSkipped, song, "skipped"},
+		{bepb.EventType_PlayNextMoved, song, "moved"},
+		{bepb.EventType_SongReturned, song, "returned"},
+		{bepb.EventType_SongRemoved, &cmpb.Song{SongId: 7}, "removed"},
+		{bepb.EventType_SongReaction, song, ""},
+		{bepb.EventType_PlayerJoined, nil, ""},
+		{bepb.EventType_DecksTaken, nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.eventType.String(), func(t *testing.T) {
+			event := toSseEvent(&bepb.Event{Type: test.eventType, ZoneId: 2, Song: test.song}, true)
+			if test.name == "" {
+				if event != nil {
+					t.Errorf("Expected no event for browsers, got %v", event)
+				}
+				return
+			}
+
+			if event == nil || event.name != test.name {
+				t.Fatalf("Expected a %s event, got %v", test.name, event)
+			}
+
+			delta := event.data.(songDelta)
+			if delta.ZoneId != 2 || delta.Song == nil || delta.Song.Id != 7 {
+				t.Errorf("Expected song 7 in zone 2, got %+v", delta)
+			}
+		})
+	}
+}
+
+func TestToSseEvent_hidesSubmitter(t *testing.T) {
+	song := &cmpb.Song{SongId: 7, Title: "Shout", Username: "Bob", ForUsername: "Alice"}
+
+	tests := []struct {
+		showSubmitter bool
+		username      string
+		forUsername   string
+	}{
+		{true, "Bob", "Alice"},
+		{false, "", ""},
+	}
+
+	for _, test := range tests {
+		event := toSseEvent(&bepb.Event{Type: bepb.EventType_SongQueued, Song: song}, test.showSubmitter)
+		shown := event.data.(songDelta).Song
+		if shown.Username != test.username || shown.For != test.forUsername || shown.Title != "Shout" {
+			t.Errorf("Expected %q for %q when showing submitters is %t, got %+v", test.username, test.forUsername,
+				test.showSubmitter, shown)
+		}
+	}
+}
+
+func TestEventHub_forward_reorderSendsSnapshot(t *testing.T) {
+	fetches := 0
+	hub := new(eventHub)
+	hub.init(nil, &publicCache{fetch: func() ([]byte, bool, error) {
+		fetches++
+		return []byte("{}"), false, nil
+	}})
+
+	events := hub.subscribe()
+	defer hub.unsubscribe(events)
+
+	hub.forward(&bepb.Event{Type: bepb.EventType_SongQueued, Sequence: 1, Song: &cmpb.Song{SongId: 7}})
+	if event := <-events; event.name != "queued" {
+		t.Errorf("Expected the queued song to be passed on, got %v", event)
+	}
+
+	hub.forward(&bepb.Event{Type: bepb.EventType_TimeOutStarted, Sequence: 2, UserId: 3})
+	if event := <-events; event.name != "snapshot" || fetches != 1 {
+		t.Errorf("Expected a fresh snapshot after the queue was reordered, got %v after %d fetches", event, fetches)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Read-only view of the queue for public display screens. The view needs no
+ * login and only shows what's playing and what's coming up. Responses are
+ * cached so any number of screens only ask the backend once every few seconds,
+ * and each client address is rate limited.
+ */
+
+package frontend
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+var ErrPublicUnavailable = errors.New("Queue is unavailable right now.")
+var ErrRateLimited = errors.New("Too many requests. Please slow down.")
+
+const (
+	publicCacheTTL  = 5 * time.Second // how long a public view is served before asking the backend again
+	publicRate      = 1.0             // requests per second allowed from each client
+	publicBurst     = 10.0            // requests a client can make at once
+	publicSweepTime = time.Minute     // time between clearing out idle clients
+)
+
+/*
+ * A song as shown on the public view
+ */
+type publicSong struct {
+	Id        uint32 `json:"id"`
+	Title     string `json:"title"`
+	Romanized string `json:"romanized_title,omitempty"`
+	Username  string `json:"username"`
+	For       string `json:"for,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+}
+
+/*
+ * How the party is shown on the public view
+ */
+type publicDisplay struct {
+	PartyName  string `json:"party_name"`
+	ThemeColor string `json:"theme_color,omitempty"`
+	Banner     string `json:"banner,omitempty"`
+	Romanized  bool   `json:"romanized_titles,omitempty"` // true to show romanized titles where songs have them
+	Dj         string `json:"dj,omitempty"`               // name of the dj who has the decks, if anyone
+}
+
+/*
+ * The now playing song and the queue as shown on the public view
+ */
+type publicView struct {
+	Display    *publicDisplay `json:"display"`
+	NowPlaying *publicSong    `json:"now_playing"`
+	Queue      []*publicSong  `json:"queue"`
+}
+
+/*
+ * Caches the encoded public view
+ */
+type publicCache struct {
+	fetch   func() ([]byte, bool, error) // gets the encoded view and whether it hides submitters
+	body    []byte                       // encoded view. Nil until the first fetch
+	expires time.Time                    // when the view should be fetched again
+	hidden  bool                         // true if the last view left out who submitted the songs
+	lock    sync.Mutex                   // only one fetch at a time
+}
+
+/*
+ * Returns a cache of the public view fetched from the backend
+ */
+func newPublicCache(client *BackendClient) *publicCache {
+	return &publicCache{fetch: func() ([]byte, bool, error) { return fetchPublicView(client) }}
+}
+
+/*
+ * Returns the encoded public view, fetching it from the backend if the cached
+ * one expired. Falls back to the stale view if the backend can't be reached.
+ */
+func (c *publicCache) get(now time.Time) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.body != nil && now.Before(c.expires) {
+		return c.body, nil
+	}
+
+	body, hidden, err := c.fetch()
+	if err != nil {
+		if c.body != nil {
+			return c.body, nil
+		}
+		return nil, err
+	}
+
+	c.body = body
+	c.hidden = hidden
+	c.expires = now.Add(publicCacheTTL)
+	return c.body, nil
+}
+
+/*
+ * Fetch the view from the backend the next time it's asked for, such as after
+ * missing changes to the queue
+ */
+func (c *publicCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.expires = time.Time{}
+}
+
+/*
+ * Returns true if the public view shows who submitted the songs, going by the
+ * last time it was fetched
+ */
+func (c *publicCache) showsSubmitter() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return !c.hidden
+}
+
+/*
+ * Fetch the now playing song, queue and the whole server's display settings
+ * from the backend and encode them. Also returns true if who submitted the
+ * songs was left out.
+ */
+func fetchPublicView(client *BackendClient) ([]byte, bool, error) {
+	nowPlaying, err := client.GetNowPlaying()
+	if err != nil {
+		return nil, false, err
+	}
+
+	playlist, err := client.GetPlaylist()
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err := client.GetServerInfo(invalidUserId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	display := info.GetDisplay()
+	view := publicView{
+		Display: &publicDisplay{
+			PartyName:  display.GetPartyName(),
+			ThemeColor: display.GetThemeColor(),
+			Banner:     display.GetBanner(),
+			Romanized:  display.GetRomanizedTitles(),
+			Dj:         info.GetDecks().GetUsername(),
+		},
+		Queue: make([]*publicSong, 0, len(playlist.Songs)),
+	}
+
+	if nowPlaying.SongId != 0 {
+		view.NowPlaying = toPublicSong(nowPlaying, display.GetShowSubmitter())
+	}
+
+	for _, song := range playlist.Songs {
+		view.Queue = append(view.Queue, toPublicSong(song, display.GetShowSubmitter()))
+	}
+
+	body, err := json.Marshal(view)
+	return body, !display.GetShowSubmitter(), err
+}
+
+/*
+ * Keep only the parts of a song that are fine to show publicly. Who submitted
+ * it is left out if the party hides submitters.
+ */
+func toPublicSong(song *cmpb.Song, showSubmitter bool) *publicSong {
+	public := &publicSong{
+		Id:        song.SongId,
+		Title:     song.Title,
+		Romanized: song.RomanizedTitle,
+		Thumbnail: song.GetMetadata().GetThumbnail(),
+		Duration:  song.GetMetadata().GetDuration(),
+	}
+
+	if showSubmitter {
+		public.Username = song.Username
+		public.For = song.ForUsername
+	}
+
+	return public
+}
+
+/*
+ * Token bucket of a single client
+ */
+type tokenBucket struct {
+	tokens float64   // requests the client can still make
+	last   time.Time // when the tokens were last refilled
+}
+
+/*
+ * Limits how often each client can make requests
+ */
+type rateLimiter struct {
+	rate      float64                 // tokens added per second
+	burst     float64                 // most tokens a bucket holds
+	buckets   map[string]*tokenBucket // buckets by client address
+	lastSweep time.Time               // when idle buckets were last cleared out
+	lock      sync.Mutex              // lock on the buckets
+}
+
+/*
+ * Initialize the rate limiter
+ */
+func (l *rateLimiter) init(rate float64, burst float64) {
+	l.rate = rate
+	l.burst = burst
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+/*
+ * Take a token from the client's bucket. Returns false if the client is out
+ * of tokens.
+ */
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.sweep(now)
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+/*
+ * Forget clients whose buckets have refilled, since they're the same as new
+ * ones. Assumes the caller holds the lock.
+ */
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < publicSweepTime {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+/*
+ * Serve the public view of the queue as json
+ */
+func (s *FrontendServer) HandlePublicQueue(context *gin.Context) {
+	now := time.Now()
+	context.Header("Access-Control-Allow-Origin", "*")
+
+	if !s.limiter.allow(context.ClientIP(), now) {
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(1/publicRate))))
+		context.JSON(http.StatusTooManyRequests, gin.H{"error": ErrRateLimited.Error()})
+		return
+	}
+
+	body, err := s.public.get(now)
+	if err != nil {
+		context.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrPublicUnavailable.Error()})
+		return
+	}
+
+	context.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(publicCacheTTL.Seconds())))
+	context.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+/*
+ * Serve a QR code of the link guests join the party at, for hosts to print or
+ * show on a screen. Takes the room to log guests into and the size in pixels
+ * from the query, like /join.png?room=Kitchen&size=512. The link points at
+ * the address the backend was given, or else the one this frontend was.
+ */
+func (s *FrontendServer) HandleJoinCode(context *gin.Context) {
+	if !s.limiter.allow(context.ClientIP(), time.Now()) {
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(1/publicRate))))
+		context.JSON(http.StatusTooManyRequests, gin.H{"error": ErrRateLimited.Error()})
+		return
+	}
+
+	// the link never comes from the request's host, which the client picks and
+	// which would end up in the publicly cached code other guests scan
+	size, _ := strconv.ParseUint(context.Query("size"), 10, 32)
+	code, err := s.client.GetJoinCode(context.Query("room"), uint32(size), s.publicUrl)
+	if err != nil {
+		context.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrPublicUnavailable.Error()})
+		return
+	}
+
+	if !code.Err.Success {
+		context.JSON(http.StatusBadRequest, gin.H{"error": code.Err.Message})
+		return
+	}
+
+	context.Header("Cache-Control", "public, max-age=3600")
+	context.Data(http.StatusOK, "image/png", code.Png)
+}
@@ -0,0 +1,150 @@
+package frontend
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type limiterRequest struct {
+	client string
+	after  time.Duration // time since the first request
+	allow  bool
+}
+
+func TestRateLimiter_allow(t *testing.T) {
+	burst := func(client string, count int, after time.Duration) []limiterRequest {
+		requests := make([]limiterRequest, count)
+		for i := range requests {
+			requests[i] = limiterRequest{client, after, true}
+		}
+		return requests
+	}
+
+	tests := []struct {
+		name     string
+		requests []limiterRequest
+	}{
+		{"burst then denied", append(burst("a", 10, 0),
+			limiterRequest{"a", 0, false})},
+		{"refills a token a second", append(burst("a", 10, 0),
+			limiterRequest{"a", 500 * time.Millisecond, false},
+			limiterRequest{"a", time.Second, true},
+			limiterRequest{"a", time.Second, false},
+			limiterRequest{"a", 3 * time.Second, true},
+			limiterRequest{"a", 3 * time.Second, true},
+			limiterRequest{"a", 3 * time.Second, false})},
+		{"refill capped at the burst", append(append(burst("a", 10, 0),
+			burst("a", 10, time.Hour)...),
+			limiterRequest{"a", time.Hour, false})},
+		{"clients have their own buckets", append(append(burst("a", 10, 0),
+			burst("b", 10, 0)...),
+			limiterRequest{"a", 0, false},
+			limiterRequest{"b", 0, false})},
+	}
+
+	start := time.Date(2024, 6, 7, 20, 0, 0, 0, time.Local)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := new(rateLimiter)
+			limiter.init(publicRate, publicBurst)
+
+			for i, request := range test.requests {
+				if allowed := limiter.allow(request.client, start.Add(request.after)); allowed != request.allow {
+					t.Fatalf("Expected request %d from %s at %v to be allowed %t, but got %t",
+						i, request.client, request.after, request.allow, allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_sweep(t *testing.T) {
+	start := time.Date(2024, 6, 7, 20, 0, 0, 0, time.Local)
+	limiter := new(rateLimiter)
+	limiter.init(publicRate, publicBurst)
+	limiter.lastSweep = start
+
+	limiter.allow("idle", start)
+	limiter.allow("busy", start.Add(publicSweepTime-time.Second))
+	limiter.allow("busy", start.Add(publicSweepTime))
+
+	if _, exists := limiter.buckets["idle"]; exists {
+		t.Errorf("Expected the idle client's refilled bucket to be swept")
+	}
+	if _, exists := limiter.buckets["busy"]; !exists {
+		t.Errorf("Expected the busy client's bucket to be kept")
+	}
+}
+
+func TestPublicCache_get(t *testing.T) {
+	errBackend := errors.New("backend down")
+
+	tests := []struct {
+		name    string
+		after   time.Duration // time since the first get
+		fails   bool          // true if the backend can't be reached
+		body    string
+		err     error
+		fetches int
+	}{
+		{"served from the cache", publicCacheTTL - time.Second, false, "view 1", nil, 1},
+		{"refreshed once expired", publicCacheTTL, false, "view 2", nil, 2},
+		{"stale view when the backend fails", publicCacheTTL, true, "view 1", nil, 2},
+	}
+
+	start := time.Date(2024, 6, 7, 20, 0, 0, 0, time.Local)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetches := 0
+			cache := &publicCache{fetch: func() ([]byte, bool, error) {
+				fetches++
+				if fetches > 1 && test.fails {
+					return nil, false, errBackend
+				}
+				return []byte("view " + string(rune('0'+fetches))), false, nil
+			}}
+
+			if body, err := cache.get(start); err != nil || string(body) != "view 1" {
+				t.Fatalf("Expected the first get to fetch view 1, but got %q, %v", body, err)
+			}
+
+			body, err := cache.get(start.Add(test.after))
+			if string(body) != test.body || err != test.err {
+				t.Errorf("Expected %q, %v, but got %q, %v", test.body, test.err, body, err)
+			}
+			if fetches != test.fetches {
+				t.Errorf("Expected %d fetches, but got %d", test.fetches, fetches)
+			}
+		})
+	}
+}
+
+func TestPublicCache_get_failsWithNothingCached(t *testing.T) {
+	errBackend := errors.New("backend down")
+	cache := &publicCache{fetch: func() ([]byte, bool, error) { return nil, false, errBackend }}
+
+	if body, err := cache.get(time.Now()); body != nil || err != errBackend {
+		t.Errorf("Expected the backend's error with nothing cached, but got %q, %v", body, err)
+	}
+}
+
+func TestPublicCache_invalidate(t *testing.T) {
+	fetches := 0
+	cache := &publicCache{fetch: func() ([]byte, bool, error) {
+		fetches++
+		return []byte("view"), fetches > 1, nil
+	}}
+
+	start := time.Date(2024, 6, 7, 20, 0, 0, 0, time.Local)
+	cache.get(start)
+	cache.invalidate()
+	cache.get(start.Add(time.Second))
+
+	if fetches != 2 {
+		t.Errorf("Expected the view to be fetched again after invalidating, but got %d fetches", fetches)
+	}
+	if cache.showsSubmitter() {
+		t.Errorf("Expected the refetched view to hide submitters")
+	}
+}
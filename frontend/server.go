@@ -13,11 +13,11 @@ import (
 	"strconv"
 
 	"github.com/foolin/goview"
-	"github.com/foolin/goview/supports/ginview"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
 
 	"github.com/nguyenmq/ytbox-go/common"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
 	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
 )
 
@@ -29,6 +29,7 @@ var ErrMissingSessionToken = errors.New("Missing session token. Please log back
 var ErrMissingLink = errors.New("Missing song link.")
 var ErrRemoveMissingSong = errors.New("Did not supply a song to remove.")
 var ErrFailedToProcessSong = errors.New("Could not process your submission. Please check your link.")
+var ErrMissingSpeaker = errors.New("Did not supply a speaker.")
 
 const (
 	LogPrefix      string = "ytb-fe" // logging prefix name
@@ -42,6 +43,7 @@ const (
 	AlertEmphInfo         = "Info"
 	invalidUserId         = 0
 	cookieName            = "ytbox_cookie"
+	defaultParty          = "yt-box" // party name shown if the backend can't be reached
 )
 
 type FrontendServer struct {
@@ -50,11 +52,20 @@ type FrontendServer struct {
 	router *gin.Engine                // gin router
 	server *http.Server               // http server
 	cookie *securecookie.SecureCookie // secure cookie provider
+
+	publicUrl string // address guests reach the frontend at, for join QR codes. Empty leaves it to the backend
+
+	public  *publicCache // cached view of the queue for public display screens
+	limiter *rateLimiter // rate limits the public view
+	events  *eventHub    // streams queue changes to browsers
 }
 
-func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *FrontendServer {
+func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool, backendToken string,
+	partyPassword string, publicUrl string) *FrontendServer {
+
 	frontend := new(FrontendServer)
 	frontend.addr = addr
+	frontend.publicUrl = publicUrl
 	frontend.cookie = securecookie.New(hashKey, blockKey)
 
 	gin.DefaultWriter = common.GetLogger()
@@ -68,9 +79,14 @@ func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *Fron
 
 	// set up gin router
 	frontend.router = gin.Default()
-	frontend.router.HTMLRender = ginview.New(htmlConfig)
-	frontend.router.Static("/static", "./static")
-	frontend.router.StaticFile("/favicon.ico", "./static/img/favicon.ico")
+	frontend.router.HTMLRender = newViewEngine(htmlConfig, isDebug)
+	if isDebug {
+		frontend.router.Static("/static", "./static")
+		frontend.router.StaticFile("/favicon.ico", "./static/img/favicon.ico")
+	} else {
+		frontend.router.StaticFS("/static", staticFiles())
+		frontend.router.StaticFileFS("/favicon.ico", "img/favicon.ico", staticFiles())
+	}
 
 	// set up the http server
 	frontend.server = new(http.Server)
@@ -79,10 +95,20 @@ func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *Fron
 
 	// connect to the song queue backend
 	frontend.client = new(BackendClient)
-	if err := frontend.client.Connect("127.0.0.1", "9009"); err != nil {
+	if err := frontend.client.Connect("127.0.0.1", "9009", backendToken, partyPassword); err != nil {
 		os.Exit(1)
 	}
 
+	// set up the public view of the queue
+	frontend.public = newPublicCache(frontend.client)
+	frontend.limiter = new(rateLimiter)
+	frontend.limiter.init(publicRate, publicBurst)
+
+	// stream queue changes from the backend to browsers
+	frontend.events = new(eventHub)
+	frontend.events.init(frontend.client, frontend.public)
+	frontend.events.start()
+
 	// configure routes
 	frontend.router.GET("/", frontend.HandleIndex)
 	frontend.router.GET("/playlist", frontend.HandlePlaylist)
@@ -91,7 +117,18 @@ func NewServer(addr string, hashKey []byte, blockKey []byte, isDebug bool) *Fron
 	frontend.router.POST("/remove", frontend.HandleRemove)
 	frontend.router.GET("/login", frontend.HandleLoginPage)
 	frontend.router.POST("/login", frontend.HandleLoginPost)
+	frontend.router.GET("/link", frontend.HandleLinkPage)
+	frontend.router.GET("/link/status", frontend.HandleLinkStatus)
+	frontend.router.GET("/link/confirm", frontend.HandleLinkConfirmPage)
+	frontend.router.POST("/link/confirm", frontend.HandleLinkConfirmPost)
 	frontend.router.GET("/next", frontend.HandleNextSong)
+	frontend.router.GET("/speakers", frontend.HandleSpeakers)
+	frontend.router.POST("/speakers/scan", frontend.HandleSpeakerScan)
+	frontend.router.POST("/speakers/pair", frontend.HandleSpeakerPair)
+	frontend.router.POST("/speakers/connect", frontend.HandleSpeakerConnect)
+	frontend.router.GET("/public/queue", frontend.HandlePublicQueue)
+	frontend.router.GET("/public/events", frontend.HandleEventStream)
+	frontend.router.GET("/join.png", frontend.HandleJoinCode)
 	frontend.router.GET("/ping", func(context *gin.Context) {
 		context.String(http.StatusOK, "pong")
 	})
@@ -108,6 +145,7 @@ func (s *FrontendServer) Start() {
 }
 
 func (s *FrontendServer) Stop() {
+	s.events.stop()
 	s.server.Close()
 }
 
@@ -118,18 +156,18 @@ func (s *FrontendServer) HandleIndex(context *gin.Context) {
 		context.Redirect(http.StatusTemporaryRedirect, "/login")
 	} else {
 		title := "No song is currently playing"
+		display := s.displaySettings(userId)
 
 		current_song, err := s.client.GetNowPlaying()
 		has_song_playing := current_song.SongId != 0
 
 		if err == nil && has_song_playing {
-			title = truncate_song_title(current_song.Title, titleMaxLength)
+			title = truncate_song_title(shownTitle(current_song, display), titleMaxLength)
 		}
 
 		playlist, err := s.client.GetPlaylist()
 
-		context.HTML(http.StatusOK, "index", gin.H{
-			"title":                "yt-box: Song Queue",
+		context.HTML(http.StatusOK, "index", s.brand(userId, "Song Queue", gin.H{
 			"now_playing":          title,
 			"has_song_playing":     has_song_playing,
 			"song":                 current_song,
@@ -140,7 +178,9 @@ func (s *FrontendServer) HandleIndex(context *gin.Context) {
 			"transform_thumbnail":  s.transformThumbnailLink,
 			"transform_user_name":  s.transformUsername,
 			"matches_session_user": s.matchesSessionUser,
-		})
+			"can_remove":           s.removable(playlist, userId),
+			"song_title":           func(song *cmpb.Song) string { return shownTitle(song, display) },
+		}))
 	}
 }
 
@@ -155,6 +195,7 @@ func (s *FrontendServer) HandlePlaylist(context *gin.Context) {
 	if err != nil {
 		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
 	} else {
+		display := s.displaySettings(userId)
 		context.HTML(http.StatusOK, "layouts/queue.html", gin.H{
 			"song_count":           len(playlist.Songs),
 			"queue":                playlist.Songs,
@@ -163,12 +204,17 @@ func (s *FrontendServer) HandlePlaylist(context *gin.Context) {
 			"transform_thumbnail":  s.transformThumbnailLink,
 			"transform_user_name":  s.transformUsername,
 			"matches_session_user": s.matchesSessionUser,
+			"can_remove":           s.removable(playlist, userId),
+			"show_submitter":       display.ShowSubmitter,
+			"song_title":           func(song *cmpb.Song) string { return shownTitle(song, display) },
 		})
 	}
 }
 
 func (s *FrontendServer) HandleNewSong(context *gin.Context) {
 	link, _ := context.GetPostForm("submit_box")
+	forUsername, _ := context.GetPostForm("for_box")
+	_, anonymous := context.GetPostForm("anon_box")
 
 	if len(link) == 0 {
 		buildErrorResponse(context, http.StatusBadRequest, ErrMissingLink)
@@ -181,7 +227,7 @@ func (s *FrontendServer) HandleNewSong(context *gin.Context) {
 		return
 	}
 
-	_, err = s.client.SendNewSong(link, userId)
+	_, err = s.client.SendNewSong(link, userId, forUsername, anonymous)
 	if err != nil {
 		buildErrorResponse(context, http.StatusInternalServerError, err)
 	} else {
@@ -191,19 +237,21 @@ func (s *FrontendServer) HandleNewSong(context *gin.Context) {
 
 func (s *FrontendServer) HandleNowPlaying(context *gin.Context) {
 	title := "No song is currently playing"
+	userId, cookieErr := s.getUserIdCookie(context)
+	display := s.displaySettings(userId)
 
 	current_song, err := s.client.GetNowPlaying()
 	has_song_playing := current_song.SongId != 0
 
 	if err == nil && has_song_playing {
-		title = truncate_song_title(current_song.Title, titleMaxLength)
+		title = truncate_song_title(shownTitle(current_song, display), titleMaxLength)
 	}
 
-	userId, err := s.getUserIdCookie(context)
-
-	if err != nil {
+	if cookieErr != nil {
 		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
 	} else {
+		// refreshing the page keeps the user active
+		s.client.Heartbeat(userId)
 		context.HTML(http.StatusOK, "layouts/now_playing.html", gin.H{
 			"now_playing":          title,
 			"has_song_playing":     has_song_playing,
@@ -211,6 +259,7 @@ func (s *FrontendServer) HandleNowPlaying(context *gin.Context) {
 			"song":                 current_song,
 			"transform_user_name":  s.transformUsername,
 			"matches_session_user": s.matchesSessionUser,
+			"show_submitter":       display.ShowSubmitter,
 		})
 	}
 }
@@ -242,15 +291,15 @@ func (s *FrontendServer) HandleRemove(context *gin.Context) {
 
 func (s *FrontendServer) HandleLoginPage(context *gin.Context) {
 	// todo: check for cookie and redirect if already have cookie
-	context.HTML(http.StatusOK, "login", gin.H{
-		"title":     "yt-box: Login",
+	context.HTML(http.StatusOK, "login", s.brand(invalidUserId, "Login", gin.H{
 		"room_name": context.Query("room"),
-	})
+	}))
 }
 
 func (s *FrontendServer) HandleLoginPost(context *gin.Context) {
 	userName, _ := context.GetPostForm("user_name_box")
 	roomName, _ := context.GetPostForm("room_name_box")
+	password, _ := context.GetPostForm("party_password_box")
 
 	if len(userName) == 0 {
 		buildLoginErrorPage(context, userName, roomName, ErrMissingUserName)
@@ -262,7 +311,7 @@ func (s *FrontendServer) HandleLoginPost(context *gin.Context) {
 		return
 	}
 
-	user, err := s.client.LoginNewUser(userName, roomName)
+	user, err := s.client.LoginNewUser(userName, roomName, password)
 	if err != nil {
 		buildLoginErrorPage(context, userName, roomName, err)
 		return
@@ -292,11 +341,82 @@ func (s *FrontendServer) HandleNextSong(context *gin.Context) {
 	context.Status(http.StatusOK)
 }
 
+func (s *FrontendServer) HandleSpeakers(context *gin.Context) {
+	if _, err := s.getUserIdCookie(context); err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	speakers, err := s.client.GetBluetoothDevices()
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+		return
+	}
+
+	context.HTML(http.StatusOK, "layouts/speakers.html", gin.H{
+		"players": speakers.Players,
+	})
+}
+
+func (s *FrontendServer) HandleSpeakerScan(context *gin.Context) {
+	if _, err := s.getUserIdCookie(context); err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	_, err := s.client.ScanBluetooth()
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
+func (s *FrontendServer) HandleSpeakerPair(context *gin.Context) {
+	s.handleSpeakerCommand(context, s.client.PairBluetooth)
+}
+
+func (s *FrontendServer) HandleSpeakerConnect(context *gin.Context) {
+	s.handleSpeakerCommand(context, s.client.ConnectBluetooth)
+}
+
+/*
+ * Forward a pair or connect request for the posted speaker to the backend
+ */
+func (s *FrontendServer) handleSpeakerCommand(context *gin.Context,
+	command func(uint32, string) (*bepb.Error, error)) {
+	if _, err := s.getUserIdCookie(context); err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSessionToken)
+		return
+	}
+
+	address, _ := context.GetPostForm("address")
+	player_id, err := strconv.ParseUint(context.PostForm("player_id"), 10, 32)
+	if len(address) == 0 || err != nil {
+		buildErrorResponse(context, http.StatusBadRequest, ErrMissingSpeaker)
+		return
+	}
+
+	_, err = command(uint32(player_id), address)
+	if err != nil {
+		buildErrorResponse(context, http.StatusInternalServerError, err)
+	} else {
+		context.Status(http.StatusOK)
+	}
+}
+
 func (s *FrontendServer) transformUsername(song *cmpb.Song, session_user_id uint32) string {
+	submitter := song.Username
 	if song.UserId == session_user_id {
-		return "You"
+		submitter = "You"
+	}
+
+	if song.ForUserId == 0 {
+		return submitter
+	} else if song.ForUserId == session_user_id {
+		return fmt.Sprintf("%s for you", submitter)
 	} else {
-		return song.Username
+		return fmt.Sprintf("%s for %s", submitter, song.ForUsername)
 	}
 }
 
@@ -312,18 +432,88 @@ func (s *FrontendServer) matchesSessionUser(user_id uint32, session_user_id uint
 	return user_id == session_user_id
 }
 
+/*
+ * Songs can be removed by the user who submitted them or the user they were
+ * queued for
+ */
+func (s *FrontendServer) canRemove(song *cmpb.Song, session_user_id uint32) bool {
+	return song.UserId == session_user_id || (song.ForUserId != 0 && song.ForUserId == session_user_id)
+}
+
+/*
+ * Returns the check for whether the user can remove a song in the playlist.
+ * The playlist doesn't say who submitted anonymous songs, so if there are any
+ * the backend is asked which songs are the user's.
+ */
+func (s *FrontendServer) removable(playlist *bepb.Playlist, userId uint32) func(*cmpb.Song, uint32) bool {
+	queued := make(map[uint32]bool)
+	for _, song := range playlist.GetSongs() {
+		if song.Anonymous {
+			queued = s.client.GetQueuedSongIds(userId)
+			break
+		}
+	}
+
+	return func(song *cmpb.Song, session_user_id uint32) bool {
+		return queued[song.SongId] || s.canRemove(song, session_user_id)
+	}
+}
+
+/*
+ * Returns how screens show the party in the user's room, or the whole
+ * server's for an invalid user id. Falls back to the defaults if the backend
+ * can't be reached.
+ */
+func (s *FrontendServer) displaySettings(userId uint32) *bepb.DisplaySettings {
+	info, err := s.client.GetServerInfo(userId)
+	if err != nil || !info.GetErr().GetSuccess() {
+		return &bepb.DisplaySettings{PartyName: defaultParty, ShowSubmitter: true}
+	}
+
+	return info.Display
+}
+
+/*
+ * Adds the party's name, theme and banner to the values a page is rendered
+ * with
+ */
+func (s *FrontendServer) brand(userId uint32, page string, values gin.H) gin.H {
+	display := s.displaySettings(userId)
+	values["title"] = fmt.Sprintf("%s: %s", display.PartyName, page)
+	values["theme_color"] = display.ThemeColor
+	values["banner"] = display.Banner
+	values["show_submitter"] = display.ShowSubmitter
+	return values
+}
+
 func increment_index(index int) int {
 	return index + 1
 }
 
+/*
+ * Cut a title down to length. Titles are cut between characters rather than
+ * bytes, so titles in other scripts don't end in a broken character.
+ */
 func truncate_song_title(title string, length int) string {
-	if len(title) > length {
-		return fmt.Sprintf("%s…", title[0:length])
+	if runes := []rune(title); len(runes) > length {
+		return fmt.Sprintf("%s…", string(runes[:length]))
 	}
 
 	return title
 }
 
+/*
+ * Returns the title a song is shown under: spelled out in Latin letters if the
+ * party asks for romanized titles and the song has one
+ */
+func shownTitle(song *cmpb.Song, display *bepb.DisplaySettings) string {
+	if display.GetRomanizedTitles() && song.GetRomanizedTitle() != "" {
+		return song.RomanizedTitle
+	}
+
+	return song.GetTitle()
+}
+
 func buildLoginErrorPage(context *gin.Context, userName string, roomName string, err error) {
 	context.HTML(http.StatusBadRequest, "login", gin.H{
 		"title":      "yt-box: Login",
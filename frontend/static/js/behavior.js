@@ -26,6 +26,8 @@ $(document).ready(function(){
             },
             success: function(data, textStatus, errorThrown) {
                 $("#submit_box").val("");
+                $("#for_box").val("");
+                $("#anon_box").prop("checked", false);
                 refresh_elements();
                 this.always()
             },
@@ -135,6 +137,83 @@ $(document).ready(function(){
         });
     };
 
+    /*----------------------------------------------------------------
+    Report a failed request in the alert area
+    ----------------------------------------------------------------*/
+    function show_error(jqXHR, textStatus, errorThrown) {
+        if(jqXHR.status == 500 || jqXHR.status == 400) {
+            $("#alert_area").empty();
+            $("#alert_area").append(jqXHR.responseText);
+        } else {
+            alert("Failed to contact server");
+        }
+    };
+
+    /*----------------------------------------------------------------
+    Refresh the bluetooth speakers known to the players
+    ----------------------------------------------------------------*/
+    function refresh_speakers() {
+        $.ajax({
+            url: "/speakers",
+            type: "GET",
+            dataType: "html",
+            error: show_error,
+            success: function(data, textStatus, errorThrown) {
+                $("#speakers_container").empty();
+                $("#speakers_container").append(data);
+                $("#speakers_button").click(refresh_speakers);
+                $("#speakers_scan").click(scan_speakers);
+                $(".speaker_pair").click(function(event) {
+                    speaker_command("/speakers/pair", event);
+                });
+                $(".speaker_connect").click(function(event) {
+                    speaker_command("/speakers/connect", event);
+                });
+            }
+        });
+    };
+
+    /*----------------------------------------------------------------
+    Ask the players to scan for nearby speakers. The players report
+    back once the scan completes, so refresh after a delay.
+    ----------------------------------------------------------------*/
+    function scan_speakers(event) {
+        $("#speakers_scan").prop("disabled", true);
+        $("#speakers_scan").text("Scanning");
+
+        $.ajax({
+            url: "/speakers/scan",
+            type: "POST",
+            error: show_error,
+            success: function(data, textStatus, errorThrown) {
+                setTimeout(refresh_speakers, 12000);
+            }
+        });
+    };
+
+    /*----------------------------------------------------------------
+    Pair or connect the selected speaker
+    ----------------------------------------------------------------*/
+    function speaker_command(url, event) {
+        $(event.currentTarget).prop("disabled", true);
+
+        $.ajax({
+            url: url,
+            type: "POST",
+            data: {
+                'player_id' : $(event.currentTarget).data("player"),
+                'address' : $(event.currentTarget).data("address")
+            },
+            error: show_error,
+            success: function(data, textStatus, errorThrown) {
+                setTimeout(refresh_speakers, 5000);
+            }
+        });
+    };
+
+    // Register handler to show the speakers
+    $("#speakers_show").click(refresh_speakers);
+
     // Register handler on queue items to remove song
     $(".queue_rm").click(remove_song);
 
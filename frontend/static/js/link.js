@@ -0,0 +1,33 @@
+$(document).ready(function(){
+    /*----------------------------------------------------------------
+    Poll until the code is confirmed on another device, then go to the
+    queue signed in
+    ----------------------------------------------------------------*/
+    var poll = parseInt($("#link_code").data("poll"), 10) || 2000;
+    var expires = Date.now() + (parseInt($("#link_code").data("expires"), 10) || 300) * 1000;
+
+    function check_link() {
+        if (Date.now() > expires) {
+            $("#link_status").text("The code expired. Reload the page for a new one.");
+            return;
+        }
+
+        $.ajax({
+            url: "/link/status",
+            type: "GET",
+            dataType: "json",
+            success: function(data) {
+                if (data.linked) {
+                    window.location.href = "/";
+                } else {
+                    setTimeout(check_link, poll);
+                }
+            },
+            error: function() {
+                setTimeout(check_link, poll);
+            }
+        });
+    }
+
+    setTimeout(check_link, poll);
+});
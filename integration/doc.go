@@ -0,0 +1,11 @@
+/*
+ * End-to-end tests that run the backend with an in-memory database and a
+ * simulated player, and drive it through its grpc clients the way the
+ * frontend, the CLI and ytb-player do. The tests live in the package's test
+ * files and need neither network access nor mpv, so they run with the rest of
+ * the tests:
+ *
+ *     go test ./integration/
+ */
+
+package integration
@@ -0,0 +1,235 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/nguyenmq/ytbox-go/backend"
+	db "github.com/nguyenmq/ytbox-go/database"
+	"github.com/nguyenmq/ytbox-go/links"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+	"github.com/nguyenmq/ytbox-go/simplayer"
+)
+
+const (
+	playerSpeed = 100             // how many times faster than real time the simulated player plays
+	waitTimeout = 5 * time.Second // how long to wait for the backend to get where a test expects
+)
+
+/*
+ * Answers metadata lookups for YouTube links without calling YouTube. Every
+ * video is ten seconds long unless listed in lengths.
+ */
+type fakeFetcher struct {
+	lengths map[string]string // video id -> ISO 8601 duration
+}
+
+func (f *fakeFetcher) FetchSongData(link string, song *cmpb.Song) error {
+	videoId := links.Parse(link).VideoId
+	if videoId == "" {
+		return fmt.Errorf("not a video: %s", link)
+	}
+
+	duration, exists := f.lengths[videoId]
+	if !exists {
+		duration = "PT10S"
+	}
+
+	song.Title = "Video " + videoId
+	song.Service = cmpb.ServiceType_Youtube
+	song.ServiceId = videoId
+	song.Metadata = &cmpb.Metadata{Duration: duration}
+	return nil
+}
+
+/*
+ * A running backend and the clients connected to it
+ */
+type harness struct {
+	t       *testing.T
+	server  *backend.BackendServer
+	conn    *grpc.ClientConn
+	client  bepb.YtbBackendClient
+	fetcher *fakeFetcher
+	rooms   map[string]uint32 // room name -> id of the rooms created
+	player  *simplayer.Player
+	ctx     context.Context
+	cancel  context.CancelFunc
+	served  chan error
+	played  chan error
+}
+
+/*
+ * Start a backend with an in-memory database and connect a client to it.
+ * Callers must close the harness.
+ */
+func newHarness(t *testing.T, config *backend.ServerConfig) *harness {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{t: t, fetcher: &fakeFetcher{lengths: make(map[string]string)}, rooms: make(map[string]uint32)}
+	if config.DbPath == "" {
+		config.DbPath = db.InMemory
+	}
+
+	h.server, err = backend.New(config, backend.WithListener(listener),
+		backend.WithMetadataFetcher(backend.ServiceYoutube, h.fetcher))
+	if err != nil {
+		listener.Close()
+		t.Fatalf("Failed to start the backend: %v", err)
+	}
+
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.served = make(chan error, 1)
+	go func() { h.served <- h.server.Run(h.ctx) }()
+
+	h.conn, err = grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.client = bepb.NewYtbBackendClient(h.conn)
+	return h
+}
+
+/*
+ * Disconnect the player and the client, then stop the backend
+ */
+func (h *harness) close() {
+	h.cancel()
+	if h.player != nil {
+		if err := <-h.played; err != nil {
+			h.t.Errorf("The player stopped with an error: %v", err)
+		}
+	}
+
+	h.conn.Close()
+	if err := <-h.served; err != nil {
+		h.t.Errorf("The backend stopped with an error: %v", err)
+	}
+}
+
+/*
+ * Connect a simulated player for the default zone
+ */
+func (h *harness) startPlayer() *simplayer.Player {
+	h.t.Helper()
+
+	stream, err := bepb.NewYtbBePlayerClient(h.conn).SongPlayer(h.ctx)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	h.player = simplayer.New("", playerSpeed)
+	h.played = make(chan error, 1)
+	go func() { h.played <- h.player.Run(h.ctx, stream) }()
+	return h.player
+}
+
+/*
+ * Log a user into a room, creating the room the first time. Returns the
+ * user's id.
+ */
+func (h *harness) login(roomName, username string) uint32 {
+	h.t.Helper()
+
+	roomId, exists := h.rooms[roomName]
+	if !exists {
+		room, err := h.client.CreateRoom(h.ctx, &bepb.Room{Name: roomName})
+		if err != nil || !room.Err.Success {
+			h.t.Fatalf("Failed to create room %s: %v %v", roomName, room, err)
+		}
+		roomId = room.Id
+		h.rooms[roomName] = roomId
+	}
+
+	user, err := h.client.LoginUser(h.ctx, &bepb.User{Username: username, RoomId: roomId})
+	if err != nil || !user.Err.Success {
+		h.t.Fatalf("Failed to log in %s: %v %v", username, user, err)
+	}
+
+	return user.UserId
+}
+
+/*
+ * Submit a YouTube video for a user and fail the test if it isn't queued
+ */
+func (h *harness) submit(userId uint32, videoId string) {
+	h.t.Helper()
+
+	response, err := h.client.SendSong(h.ctx, &bepb.Submission{
+		UserId: userId,
+		Link:   "https://www.youtube.com/watch?v=" + videoId,
+	})
+	if err != nil || !response.Success {
+		h.t.Fatalf("Failed to submit %s for user %d: %v %v", videoId, userId, response, err)
+	}
+}
+
+/*
+ * Returns the video ids of the songs in the queue, in order
+ */
+func (h *harness) queue() []string {
+	h.t.Helper()
+
+	playlist, err := h.client.GetPlaylist(h.ctx, &cmpb.Empty{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	ids := make([]string, 0, len(playlist.Songs))
+	for _, song := range playlist.Songs {
+		ids = append(ids, song.ServiceId)
+	}
+	return ids
+}
+
+/*
+ * Wait until the condition holds, failing the test with the description if it
+ * doesn't in time
+ */
+func (h *harness) eventually(description string, condition func() bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/*
+ * Returns the video ids of the songs the player started, in order
+ */
+func playedIds(player *simplayer.Player) []string {
+	played := player.Played()
+	ids := make([]string, 0, len(played))
+	for _, song := range played {
+		ids = append(ids, song.ServiceId)
+	}
+	return ids
+}
+
+func equalIds(actual []string, expected ...string) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+
+	for i := range actual {
+		if actual[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nguyenmq/ytbox-go/backend"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+func TestPlayer_whenSongFails_playsItAgain(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{})
+	defer h.close()
+
+	alice := h.login("Kitchen", "Alice")
+	player := h.startPlayer()
+	player.FailPlays(1)
+
+	h.submit(alice, "aaaaaaaaaa1")
+	h.submit(alice, "aaaaaaaaaa2")
+	h.eventually("the queue to play out", func() bool {
+		return len(player.Played()) == 2 && player.NowPlaying() == nil
+	})
+
+	if played := playedIds(player); !equalIds(played, "aaaaaaaaaa1", "aaaaaaaaaa2") {
+		t.Errorf("Expected the failed song to play on its second go, got %v", played)
+	}
+}
+
+func TestPlayer_whenSongFailsTwice_skipsIt(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{})
+	defer h.close()
+
+	alice := h.login("Kitchen", "Alice")
+	player := h.startPlayer()
+	player.FailPlays(2)
+
+	h.submit(alice, "aaaaaaaaaa1")
+	h.submit(alice, "aaaaaaaaaa2")
+	h.eventually("the queue to play out", func() bool {
+		return len(player.Played()) == 1 && player.NowPlaying() == nil && len(h.queue()) == 0
+	})
+
+	if played := playedIds(player); !equalIds(played, "aaaaaaaaaa2") {
+		t.Errorf("Expected the song failing twice to be skipped, got %v", played)
+	}
+}
+
+func TestListClients_showsPlayersAndWatchers(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{})
+	defer h.close()
+
+	h.startPlayer()
+	watchCtx, stopWatching := context.WithCancel(h.ctx)
+	if _, err := h.client.Events(watchCtx, &bepb.EventsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var clients []*bepb.ConnectedClient
+	h.eventually("the player and the watcher to be listed", func() bool {
+		list, err := h.client.ListClients(h.ctx, &cmpb.Empty{})
+		clients = list.GetClients()
+		return err == nil && len(clients) == 2 && clients[0].Kind != clients[1].Kind
+	})
+
+	for _, client := range clients {
+		if client.Peer == "" || client.ConnectedAt == 0 || client.Identity != "admin" {
+			t.Errorf("Expected the client's address, connect time and role, got %v", client)
+		}
+	}
+
+	stopWatching()
+	h.eventually("the watcher to be dropped", func() bool {
+		list, err := h.client.ListClients(h.ctx, &cmpb.Empty{})
+		return err == nil && len(list.Clients) == 1 && list.Clients[0].Kind == bepb.ClientKind_PlayerClient
+	})
+}
@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nguyenmq/ytbox-go/backend"
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+)
+
+func TestQueue_takesTurnsBetweenUsers(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{})
+	defer h.close()
+
+	alice := h.login("Kitchen", "Alice")
+	bob := h.login("Kitchen", "Bob")
+
+	// Alice queues everything first, but Bob's songs still get a turn
+	h.submit(alice, "aaaaaaaaaa1")
+	h.submit(alice, "aaaaaaaaaa2")
+	h.submit(alice, "aaaaaaaaaa3")
+	h.submit(bob, "bbbbbbbbbb1")
+	h.submit(bob, "bbbbbbbbbb2")
+
+	expected := []string{"aaaaaaaaaa1", "bbbbbbbbbb1", "aaaaaaaaaa2", "bbbbbbbbbb2", "aaaaaaaaaa3"}
+	if queue := h.queue(); !equalIds(queue, expected...) {
+		t.Fatalf("Expected the queue %v, got %v", expected, queue)
+	}
+
+	// the player plays them in the same order
+	player := h.startPlayer()
+	h.eventually("the queue to play out", func() bool {
+		return len(player.Played()) == len(expected) && player.NowPlaying() == nil
+	})
+
+	if played := playedIds(player); !equalIds(played, expected...) {
+		t.Errorf("Expected the songs played in the order %v, got %v", expected, played)
+	}
+
+	if queue := h.queue(); len(queue) != 0 {
+		t.Errorf("Expected the queue to be empty, got %v", queue)
+	}
+}
+
+func TestQueue_fairnessReport(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{})
+	defer h.close()
+
+	alice := h.login("Kitchen", "Alice")
+	bob := h.login("Kitchen", "Bob")
+
+	h.submit(alice, "aaaaaaaaaa1")
+	h.submit(alice, "aaaaaaaaaa2")
+	h.submit(bob, "bbbbbbbbbb1")
+
+	report, err := h.client.GetFairnessReport(h.ctx, &bepb.Zone{})
+	if err != nil || !report.Err.Success {
+		t.Fatalf("Failed to get the fairness report: %v %v", report, err)
+	}
+
+	if !report.Rotates || len(report.Users) != 2 {
+		t.Fatalf("Expected a rotating queue with 2 users, got %v", report)
+	}
+
+	// the report is ordered by user id and Alice logged in first
+	if user := report.Users[0]; user.UserId != alice || user.Pending != 2 || user.NextPosition != 1 {
+		t.Errorf("Expected Alice to have 2 songs waiting with the next one first, got %v", user)
+	}
+
+	if user := report.Users[1]; user.UserId != bob || user.Pending != 1 || user.NextPosition != 2 {
+		t.Errorf("Expected Bob to have 1 song waiting second in line, got %v", user)
+	}
+
+	// once the songs play, both users are credited with play time
+	player := h.startPlayer()
+	h.eventually("the queue to play out", func() bool {
+		return len(player.Played()) == 3 && player.NowPlaying() == nil
+	})
+
+	report, _ = h.client.GetFairnessReport(h.ctx, &bepb.Zone{})
+	for _, user := range report.Users {
+		if user.Pending != 0 || user.PlayedSeconds <= 0 {
+			t.Errorf("Expected user %d to have played with nothing waiting, got %v", user.UserId, user)
+		}
+	}
+}
+
+func TestQueue_skipVotes(t *testing.T) {
+	h := newHarness(t, &backend.ServerConfig{SkipVoteShare: 1})
+	defer h.close()
+
+	alice := h.login("Kitchen", "Alice")
+	bob := h.login("Kitchen", "Bob")
+
+	// Alice's song would play for hours at the player's speed
+	h.fetcher.lengths["aaaaaaaaaa1"] = "PT10H"
+	h.submit(alice, "aaaaaaaaaa1")
+	h.submit(bob, "bbbbbbbbbb1")
+
+	player := h.startPlayer()
+	h.eventually("Alice's song to play", func() bool {
+		return player.NowPlaying() != nil && player.NowPlaying().ServiceId == "aaaaaaaaaa1"
+	})
+
+	// both active users have to vote to skip it
+	result, err := h.client.VoteSkip(h.ctx, &bepb.SkipVote{UserId: bob})
+	if err != nil || !result.Err.Success || result.Skipped || result.Needed != 2 {
+		t.Fatalf("Expected Bob's vote not to skip the song alone, got %v %v", result, err)
+	}
+
+	result, err = h.client.VoteSkip(h.ctx, &bepb.SkipVote{UserId: alice})
+	if err != nil || !result.Err.Success || !result.Skipped {
+		t.Fatalf("Expected Alice's vote to skip the song, got %v %v", result, err)
+	}
+
+	h.eventually("the queue to play out after the skip", func() bool {
+		return len(player.Played()) == 2 && player.NowPlaying() == nil
+	})
+
+	if played := playedIds(player); !equalIds(played, "aaaaaaaaaa1", "bbbbbbbbbb1") {
+		t.Errorf("Expected Bob's song to play after the skip, got %v", played)
+	}
+}
+
+func TestSnapshot_roundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytbox_integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "party.snapshot")
+
+	original := newHarness(t, &backend.ServerConfig{})
+	alice := original.login("Kitchen", "Alice")
+	bob := original.login("Kitchen", "Bob")
+	original.submit(alice, "aaaaaaaaaa1")
+	original.submit(alice, "aaaaaaaaaa2")
+	original.submit(bob, "bbbbbbbbbb1")
+	expected := original.queue()
+
+	response, err := original.client.SavePlaylist(original.ctx, &bepb.FilePath{Path: path, WithHistory: true})
+	if err != nil || !response.Success {
+		t.Fatalf("Failed to save the snapshot: %v %v", response, err)
+	}
+	original.close()
+
+	// a new backend picks up where the old one left off
+	restored := newHarness(t, &backend.ServerConfig{})
+	defer restored.close()
+
+	response, err = restored.client.RestorePlaylist(restored.ctx, &bepb.FilePath{Path: path})
+	if err != nil || !response.Success {
+		t.Fatalf("Failed to restore the snapshot: %v %v", response, err)
+	}
+
+	if queue := restored.queue(); !equalIds(queue, expected...) {
+		t.Errorf("Expected the restored queue %v, got %v", expected, queue)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Parses the links submitted to yt_box. A submission is either a YouTube
+ * link, a path to an audio file on the backend's disk, a link to a page on
+ * another site or text to search YouTube for. The package has no other yt_box
+ * dependencies, so tools like chat bots and gateways can sort submissions the
+ * same way the backend does before sending them on.
+ */
+
+package links
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+/*
+ * Kinds of submissions
+ */
+type Kind int
+
+const (
+	Unknown   Kind = iota // not something the backend can play
+	Search                // text to search YouTube for
+	Youtube               // a YouTube video
+	LocalFile             // an audio file on the backend's disk
+	Web                   // a page on any other site
+)
+
+const videoIdLength = 11 // length of every YouTube video id
+
+/*
+ * Hosts of YouTube links once any "www.", "m." or "music." is taken off
+ */
+var youtubeHosts = map[string]bool{
+	"youtube.com":          true,
+	"youtu.be":             true,
+	"youtube-nocookie.com": true,
+}
+
+/*
+ * Paths of YouTube links that have the video id as the next path segment
+ */
+var videoPathPrefixes = map[string]bool{
+	"shorts": true,
+	"embed":  true,
+	"v":      true,
+	"e":      true,
+	"live":   true,
+}
+
+/*
+ * Audio files the backend can read the tags of
+ */
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+}
+
+/*
+ * A parsed submission
+ */
+type Link struct {
+	Kind    Kind   // what was submitted
+	Raw     string // the submission with surrounding space trimmed
+	VideoId string // id of the video for YouTube links. Empty if the link doesn't name a video
+	StartAt int    // seconds into the video a YouTube link starts at. Zero if it doesn't say
+}
+
+/*
+ * Parse a submission. Anything that isn't a link or path is a search, except
+ * for links with schemes other than http and https, which are Unknown.
+ */
+func Parse(submission string) Link {
+	link := Link{Raw: strings.TrimSpace(submission)}
+
+	switch {
+	case link.Raw == "":
+		return link
+	case strings.HasPrefix(link.Raw, "/"):
+		if audioExtensions[strings.ToLower(path.Ext(link.Raw))] {
+			link.Kind = LocalFile
+		}
+		return link
+	}
+
+	hasScheme := strings.Contains(link.Raw, "://")
+	parsed, ok := parseWebLink(link.Raw, hasScheme)
+	switch {
+	case ok && isYoutubeHost(parsed.Hostname()):
+		link.Kind = Youtube
+		link.VideoId = youtubeVideoId(parsed)
+		if link.VideoId != "" {
+			link.StartAt = youtubeStartAt(parsed)
+		}
+	case ok && hasScheme:
+		link.Kind = Web
+	case !hasScheme:
+		link.Kind = Search
+	}
+
+	return link
+}
+
+/*
+ * Returns true if the submission should be searched for instead of being
+ * treated as a link
+ */
+func IsSearchQuery(submission string) bool {
+	return Parse(submission).Kind == Search
+}
+
+/*
+ * Returns the id of the video a YouTube link points to. Empty if the
+ * submission isn't a YouTube link to a video.
+ */
+func VideoId(submission string) string {
+	return Parse(submission).VideoId
+}
+
+/*
+ * Returns true if the id has the length and characters of a YouTube video id
+ */
+func ValidVideoId(id string) bool {
+	if len(id) != videoIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
+		if !valid {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * Returns the link to watch a YouTube video
+ */
+func WatchLink(videoId string) string {
+	return "https://www.youtube.com/watch?v=" + videoId
+}
+
+/*
+ * Parse an http or https link. Links without a scheme are only accepted if
+ * they have a path, like "youtu.be/dQw4w9WgXcQ", so plain words aren't
+ * mistaken for host names.
+ */
+func parseWebLink(raw string, hasScheme bool) (*url.URL, bool) {
+	if !hasScheme {
+		if !strings.Contains(raw, "/") || strings.ContainsAny(raw, " \t\n") {
+			return nil, false
+		}
+		raw = "https://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return nil, false
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	return parsed, scheme == "http" || scheme == "https"
+}
+
+/*
+ * Returns true if the host belongs to YouTube
+ */
+func isYoutubeHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, prefix := range []string{"www.", "m.", "music."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+
+	return youtubeHosts[host]
+}
+
+/*
+ * Returns the id of the video a YouTube link points to, or an empty string if
+ * it doesn't point to one, such as a link to a channel
+ */
+func youtubeVideoId(link *url.URL) string {
+	segments := strings.Split(strings.Trim(link.Path, "/"), "/")
+
+	var id string
+	switch {
+	case strings.HasSuffix(strings.ToLower(link.Hostname()), "youtu.be"):
+		id = segments[0]
+	case segments[0] == "watch":
+		id = link.Query().Get("v")
+	case segments[0] == "attribution_link":
+		// the video is in a relative link in the u parameter
+		if inner, err := url.Parse(link.Query().Get("u")); err == nil && inner.Path == "/watch" {
+			id = inner.Query().Get("v")
+		}
+	case videoPathPrefixes[segments[0]] && len(segments) > 1:
+		id = segments[1]
+	}
+
+	if !ValidVideoId(id) {
+		return ""
+	}
+
+	return id
+}
+
+/*
+ * Returns the seconds into the video a YouTube link starts at, from its t or
+ * start parameter or a "#t=" fragment
+ */
+func youtubeStartAt(link *url.URL) int {
+	query := link.Query()
+	for _, value := range []string{query.Get("t"), query.Get("start"), strings.TrimPrefix(link.Fragment, "t=")} {
+		if seconds, ok := parseTimestamp(value); ok {
+			return seconds
+		}
+	}
+
+	return 0
+}
+
+/*
+ * Parse a YouTube timestamp: plain seconds like "90" or "90s", or hours,
+ * minutes and seconds like "1h2m3s"
+ */
+func parseTimestamp(timestamp string) (int, bool) {
+	if timestamp == "" {
+		return 0, false
+	}
+
+	total, number, digits := 0, 0, 0
+	for _, c := range timestamp {
+		switch {
+		case c >= '0' && c <= '9' && digits < 6:
+			number = number*10 + int(c-'0')
+			digits++
+		case c == 'h' && digits > 0:
+			total, number, digits = total+number*3600, 0, 0
+		case c == 'm' && digits > 0:
+			total, number, digits = total+number*60, 0, 0
+		case c == 's' && digits > 0:
+			total, number, digits = total+number, 0, 0
+		default:
+			return 0, false
+		}
+	}
+
+	return total + number, true
+}
@@ -0,0 +1,131 @@
+package links
+
+import (
+	"testing"
+)
+
+/*
+ * Submissions and how they should parse
+ */
+var parseCorpus = []struct {
+	submission string
+	kind       Kind
+	videoId    string
+}{
+	{"https://www.youtube.com/watch?v=SilKjJ0S904", Youtube, "SilKjJ0S904"},
+	{"https://www.youtube.com/watch?v=cHkDZ1ekB9U&list=RDcHkDZ1ekB9U&start_radio=1", Youtube, "cHkDZ1ekB9U"},
+	{"https://www.youtube.com/watch?feature=share&v=_sV0S8qWSy0", Youtube, "_sV0S8qWSy0"},
+	{"http://youtube.com/watch?v=lMinM-FphYQ#t=30", Youtube, "lMinM-FphYQ"},
+	{"https://m.youtube.com/watch?v=VQa9Q5_Dcck", Youtube, "VQa9Q5_Dcck"},
+	{"https://www.youtube.com/watch?v=vjAxLbmy83E", Youtube, "vjAxLbmy83E"},
+	{"https://music.youtube.com/watch?v=vjAxLbmy83E&si=abc", Youtube, "vjAxLbmy83E"},
+	{"HTTPS://WWW.YOUTUBE.COM/watch?v=aatr_2MstrI", Youtube, "aatr_2MstrI"},
+	{"  https://youtu.be/ed0CcFcBBMI  ", Youtube, "ed0CcFcBBMI"},
+	{"https://youtu.be/bL_NcoCJgzo?si=Hq3&t=42", Youtube, "bL_NcoCJgzo"},
+	{"youtu.be/A1oxh8Z-2ko", Youtube, "A1oxh8Z-2ko"},
+	{"youtu.be/ed0CcFcBBMI", Youtube, "ed0CcFcBBMI"},
+	{"www.youtube.com/watch?v=dQw4w9WgXcQ", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/shorts/dQw4w9WgXcQ", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/embed/dQw4w9WgXcQ?autoplay=1", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/live/dQw4w9WgXcQ", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/v/dQw4w9WgXcQ", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/attribution_link?u=/watch%3Fv%3DdQw4w9WgXcQ%26feature%3Dshare", Youtube, "dQw4w9WgXcQ"},
+	{"https://www.youtube.com/watch?v=short", Youtube, ""},
+	{"https://www.youtube.com/watch?v=dQw4w9WgXcQ<script>", Youtube, ""},
+	{"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", Youtube, ""},
+	{"https://youtu.be/", Youtube, ""},
+	{"https://google.com", Web, ""},
+	{"https://soundcloud.com/artist/track", Web, ""},
+	{"https://youtube.com.evil.example/watch?v=dQw4w9WgXcQ", Web, ""},
+	{"/music/song.mp3", LocalFile, ""},
+	{"/music/Song.FLAC", LocalFile, ""},
+	{"/music/notes.txt", Unknown, ""},
+	{"ftp://example.com/song.mp3", Unknown, ""},
+	{"javascript://alert(1)", Unknown, ""},
+	{"https://", Unknown, ""},
+	{"", Unknown, ""},
+	{"   ", Unknown, ""},
+	{"daft punk around the world", Search, ""},
+	{"darude sandstorm", Search, ""},
+	{"AC/DC thunderstruck", Search, ""},
+	{"ac/dc", Search, ""},
+	{"youtube.com", Search, ""},
+}
+
+func TestParse_corpus(t *testing.T) {
+	for _, test := range parseCorpus {
+		link := Parse(test.submission)
+		if link.Kind != test.kind || link.VideoId != test.videoId {
+			t.Errorf("Parse(%q) = {kind: %d, id: %q}, expected {kind: %d, id: %q}", test.submission,
+				link.Kind, link.VideoId, test.kind, test.videoId)
+		}
+	}
+}
+
+func TestParse_startAt(t *testing.T) {
+	starts := map[string]int{
+		"https://youtu.be/bL_NcoCJgzo?si=Hq3&t=42":                     42,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1m30s":          90,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1h2m3s":         3723,
+		"https://www.youtube.com/embed/dQw4w9WgXcQ?start=15":           15,
+		"http://youtube.com/watch?v=lMinM-FphYQ#t=30":                  30,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ":                  0,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=soon":           0,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=99999999999":    0,
+		"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw?t=5": 0,
+	}
+
+	for link, expected := range starts {
+		if start := Parse(link).StartAt; start != expected {
+			t.Errorf("Parse(%q) starts at %d, expected %d", link, start, expected)
+		}
+	}
+}
+
+func TestValidVideoId(t *testing.T) {
+	ids := map[string]bool{
+		"dQw4w9WgXcQ":  true,
+		"_-_-_-_-_-_":  true,
+		"dQw4w9WgXc":   false,
+		"dQw4w9WgXcQQ": false,
+		"dQw4w9WgXc!":  false,
+		"dQw4w9WgXcé":  false,
+	}
+
+	for id, expected := range ids {
+		if valid := ValidVideoId(id); valid != expected {
+			t.Errorf("ValidVideoId(%q) = %t, expected %t", id, valid, expected)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, test := range parseCorpus {
+		f.Add(test.submission)
+	}
+
+	f.Fuzz(func(t *testing.T, submission string) {
+		link := Parse(submission)
+
+		if link.VideoId != "" && (link.Kind != Youtube || !ValidVideoId(link.VideoId)) {
+			t.Fatalf("Parse(%q) returned invalid video id %q", submission, link.VideoId)
+		}
+
+		if again := Parse(link.Raw); again != link {
+			t.Fatalf("Parse(%q) = %v, but parsing its raw text gave %v", submission, link, again)
+		}
+
+		if IsSearchQuery(submission) != (link.Kind == Search) {
+			t.Fatalf("IsSearchQuery(%q) disagrees with Parse", submission)
+		}
+
+		if link.StartAt < 0 || (link.StartAt > 0 && link.VideoId == "") {
+			t.Fatalf("Parse(%q) returned invalid start %d", submission, link.StartAt)
+		}
+
+		if link.VideoId != "" && VideoId(WatchLink(link.VideoId)) != link.VideoId {
+			t.Fatalf("Watch link of %q doesn't parse back to the same id", link.VideoId)
+		}
+	})
+}
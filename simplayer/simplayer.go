@@ -0,0 +1,440 @@
+/*
+ * A simulated player that "plays" songs by waiting out their length and
+ * reports its status to the backend like ytb-player does, so the backend's
+ * whole play, skip and status pipeline can be exercised without mpv or
+ * access to YouTube. It runs in-process, such as in tests, or as the
+ * ytb-sim-player binary.
+ */
+
+package simplayer
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rickb777/date/period"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+const (
+	DefaultSongLength = 3 * time.Minute // how long songs without a known length play
+	positionInterval  = 5 * time.Second // time between reports of the playback position
+	deviceName        = "simulated"     // name of the player's only audio output device
+)
+
+/*
+ * Plays the songs the backend sends it by waiting out their length
+ */
+type Player struct {
+	zone  string  // zone the player joins. Empty joins the default zone
+	speed float64 // how many times faster than real time songs play
+
+	song     *cmpb.Song      // song playing. Nil when idle
+	played   []*cmpb.Song    // songs played, in the order they started
+	position time.Duration   // how far into the song playback was when it last started or paused
+	resumed  time.Time       // when playback last started or resumed
+	paused   bool            // true while the song is paused
+	ended    *time.Timer     // fires when the song ends. Nil when idle or paused
+	device   string          // audio output device the backend picked
+	failures int             // songs left to fail instead of playing
+	finished chan *cmpb.Song // receives the songs whose timers ran out
+	done     chan struct{}   // closed once the player stops running
+	lock     sync.Mutex      // lock on the playback state
+}
+
+/*
+ * Create a player for a zone that plays songs speed times faster than real
+ * time. A speed of zero or less plays them in real time.
+ */
+func New(zone string, speed float64) *Player {
+	player := new(Player)
+	player.zone = zone
+	player.speed = speed
+	if player.speed <= 0 {
+		player.speed = 1
+	}
+	player.device = deviceName
+	return player
+}
+
+/*
+ * Play the songs sent on the stream until it ends or the context is
+ * cancelled. Returns nil if the backend or the context ended the stream.
+ */
+func (p *Player) Run(ctx context.Context, stream bepb.YtbBePlayer_SongPlayerClient) error {
+	controls := make(chan *bepb.PlayerControl)
+	received := make(chan error, 1)
+	go func() {
+		for {
+			control, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+
+			select {
+			case controls <- control:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	p.finished = make(chan *cmpb.Song)
+	p.done = make(chan struct{})
+	positionTicker := time.NewTicker(positionInterval)
+	defer positionTicker.Stop()
+	defer p.stop()
+
+	// tell the backend the player is ready and which zone it belongs to
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready, Zone: p.zone, SupportsVolume: true})
+	stream.Send(p.devices())
+
+	for {
+		select {
+		case control := <-controls:
+			p.handle(control, stream)
+
+		case song := <-p.finished:
+			// timers can fire after the song they were set for was replaced
+			if p.finish(song) {
+				stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+			}
+
+		case <-positionTicker.C:
+			if status := p.positionStatus(time.Now()); status != nil {
+				stream.Send(status)
+			}
+
+		case err := <-received:
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+
+		case <-ctx.Done():
+			stream.CloseSend()
+			return nil
+		}
+	}
+}
+
+/*
+ * Make the next songs the backend sends fail to play, like a stream that
+ * couldn't be extracted
+ */
+func (p *Player) FailPlays(songs int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.failures = songs
+}
+
+/*
+ * Returns the songs the player started playing, in order
+ */
+func (p *Player) Played() []*cmpb.Song {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*cmpb.Song(nil), p.played...)
+}
+
+/*
+ * Returns the song playing, or nil if the player is idle
+ */
+func (p *Player) NowPlaying() *cmpb.Song {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.song
+}
+
+/*
+ * Act on a command from the backend
+ */
+func (p *Player) handle(control *bepb.PlayerControl, stream bepb.YtbBePlayer_SongPlayerClient) {
+	log.Printf("Received: %v", control.GetCommand())
+
+	switch control.GetCommand() {
+	case bepb.CommandType_Play:
+		if control.GetSong() != nil {
+			p.start(control.GetSong(), stream)
+		}
+
+	case bepb.CommandType_Next:
+		// going past the last song leaves the player idle, like mpv
+		if control.GetSong() != nil {
+			p.start(control.GetSong(), stream)
+		} else if p.finish(p.NowPlaying()) {
+			stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+		}
+
+	case bepb.CommandType_Previous:
+		if current := p.NowPlaying(); current != nil && current.GetSongId() == control.GetSong().GetSongId() {
+			p.restart(time.Now())
+		} else if control.GetSong() != nil {
+			p.start(control.GetSong(), stream)
+		}
+
+	case bepb.CommandType_Stop:
+		if p.finish(p.NowPlaying()) {
+			stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+		}
+
+	case bepb.CommandType_Pause:
+		p.togglePause(time.Now())
+
+	case bepb.CommandType_SetOutputDevice:
+		p.lock.Lock()
+		p.device = control.GetOutputDevice()
+		p.lock.Unlock()
+		stream.Send(p.devices())
+
+	case bepb.CommandType_Duck:
+		log.Printf("Ducked to %d%% of the volume", control.GetDuckVolume())
+
+	case bepb.CommandType_Unduck:
+		log.Printf("Volume restored")
+
+	case bepb.CommandType_FadeOut:
+		log.Printf("Fading out over %.1f seconds", control.GetFadeSeconds())
+
+	case bepb.CommandType_FadeIn:
+		log.Printf("Fading in over %.1f seconds", control.GetFadeSeconds())
+
+	case bepb.CommandType_SetQuality:
+		log.Printf("Playing songs at %v", control.GetQuality())
+	}
+}
+
+/*
+ * Play a song sent by the backend and confirm it started, or fail to play it
+ * if the player was told to
+ */
+func (p *Player) start(song *cmpb.Song, stream bepb.YtbBePlayer_SongPlayerClient) {
+	if p.fail(song, stream) {
+		return
+	}
+
+	p.play(song)
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Playing, SongId: song.GetSongId()})
+}
+
+/*
+ * Start playing a song from where its submitter wants it to start, replacing
+ * the song playing
+ */
+func (p *Player) play(song *cmpb.Song) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.ended != nil {
+		p.ended.Stop()
+	}
+
+	log.Printf("Playing: %s", song.GetTitle())
+	p.song = song
+	p.played = append(p.played, song)
+	p.position = time.Duration(song.GetStartAt()) * time.Second
+	p.paused = false
+	p.resumed = time.Now()
+	p.startTimer()
+}
+
+/*
+ * Seek back to the start of the song playing, like ytb-player does when told
+ * to go back to the song it's playing
+ */
+func (p *Player) restart(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.song == nil {
+		return
+	}
+
+	log.Printf("Restarted: %s", p.song.GetTitle())
+	p.position = 0
+	p.resumed = now
+	if !p.paused {
+		if p.ended != nil {
+			p.ended.Stop()
+		}
+		p.startTimer()
+	}
+}
+
+/*
+ * Fail to play the song if the player was told to, reporting the failure and
+ * going idle like ytb-player does. Returns false if the song should play.
+ */
+func (p *Player) fail(song *cmpb.Song, stream bepb.YtbBePlayer_SongPlayerClient) bool {
+	p.lock.Lock()
+	if p.failures == 0 {
+		p.lock.Unlock()
+		return false
+	}
+
+	p.failures--
+	if p.ended != nil {
+		p.ended.Stop()
+		p.ended = nil
+	}
+	p.song = nil
+	p.lock.Unlock()
+
+	log.Printf("Failed: %s", song.GetTitle())
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Failed, SongId: song.GetSongId(),
+		PlayError: "simulated failure"})
+	stream.Send(&bepb.PlayerStatus{Command: bepb.CommandType_Ready})
+	return true
+}
+
+/*
+ * Pause the song or resume it where it was paused
+ */
+func (p *Player) togglePause(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.song == nil {
+		return
+	}
+
+	if p.paused {
+		p.paused = false
+		p.resumed = now
+		p.startTimer()
+		return
+	}
+
+	p.position = p.elapsed(now)
+	p.paused = true
+	if p.ended != nil {
+		p.ended.Stop()
+		p.ended = nil
+	}
+}
+
+/*
+ * Start the timer that ends the song once the rest of it plays. Must be
+ * called under the lock.
+ */
+func (p *Player) startTimer() {
+	song := p.song
+	left := songLength(song) - p.position
+	if left < 0 {
+		left = 0
+	}
+
+	finished, done := p.finished, p.done
+	p.ended = time.AfterFunc(time.Duration(float64(left)/p.speed), func() {
+		select {
+		case finished <- song:
+		case <-done:
+		}
+	})
+}
+
+/*
+ * Stop playing the song if it's still the one playing. Returns false if it
+ * was already replaced or stopped.
+ */
+func (p *Player) finish(song *cmpb.Song) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if song == nil || p.song != song {
+		return false
+	}
+
+	if p.ended != nil {
+		p.ended.Stop()
+		p.ended = nil
+	}
+
+	log.Printf("Finished: %s", song.GetTitle())
+	p.song = nil
+	return true
+}
+
+/*
+ * Stop the song's timer, such as when the player leaves
+ */
+func (p *Player) stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	close(p.done)
+	if p.ended != nil {
+		p.ended.Stop()
+		p.ended = nil
+	}
+}
+
+/*
+ * Returns how far into the song playback is. Must be called under the lock.
+ */
+func (p *Player) elapsed(now time.Time) time.Duration {
+	if p.paused {
+		return p.position
+	}
+
+	return p.position + time.Duration(float64(now.Sub(p.resumed))*p.speed)
+}
+
+/*
+ * Returns the report of how far along the playing song is, or nil if the
+ * player is idle
+ */
+func (p *Player) positionStatus(now time.Time) *bepb.PlayerStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.song == nil {
+		return nil
+	}
+
+	return &bepb.PlayerStatus{
+		Command:      bepb.CommandType_Position,
+		SongId:       p.song.GetSongId(),
+		Position:     p.elapsed(now).Seconds(),
+		Paused:       p.paused,
+		PositionTime: now.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+/*
+ * Returns the report of the player's audio output devices
+ */
+func (p *Player) devices() *bepb.PlayerStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return &bepb.PlayerStatus{
+		Command:      bepb.CommandType_Devices,
+		Devices:      []*bepb.AudioDevice{{Name: deviceName, Description: "Simulated output"}},
+		OutputDevice: p.device,
+	}
+}
+
+/*
+ * Returns how far into its video a song stops playing: at its end offset if it
+ * has one, like a chapter, or else at the end of the video. Falls back to a
+ * default if its length isn't known.
+ */
+func songLength(song *cmpb.Song) time.Duration {
+	if song.GetEndAt() > 0 {
+		return time.Duration(song.GetEndAt()) * time.Second
+	}
+
+	duration, err := period.Parse(song.GetMetadata().GetDuration())
+	if err != nil || duration.IsZero() {
+		return DefaultSongLength
+	}
+
+	return duration.DurationApprox()
+}
@@ -0,0 +1,131 @@
+package simplayer
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	bepb "github.com/nguyenmq/ytbox-go/proto/backend"
+	cmpb "github.com/nguyenmq/ytbox-go/proto/common"
+)
+
+/*
+ * The player's end of a stream, driven by the test in place of the backend
+ */
+type fakeStream struct {
+	grpc.ClientStream
+	controls chan *bepb.PlayerControl
+	statuses chan *bepb.PlayerStatus
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{
+		controls: make(chan *bepb.PlayerControl),
+		statuses: make(chan *bepb.PlayerStatus, 16),
+	}
+}
+
+func (f *fakeStream) Send(status *bepb.PlayerStatus) error {
+	f.statuses <- status
+	return nil
+}
+
+func (f *fakeStream) Recv() (*bepb.PlayerControl, error) {
+	control, ok := <-f.controls
+	if !ok {
+		return nil, io.EOF
+	}
+	return control, nil
+}
+
+func (f *fakeStream) CloseSend() error {
+	return nil
+}
+
+/*
+ * Returns the next status the player sent other than its position and devices
+ */
+func (f *fakeStream) nextStatus(t *testing.T) *bepb.PlayerStatus {
+	for {
+		select {
+		case status := <-f.statuses:
+			if status.Command != bepb.CommandType_Position && status.Command != bepb.CommandType_Devices {
+				return status
+			}
+		case <-time.After(time.Second):
+			t.Fatal("The player didn't send a status in time")
+			return nil
+		}
+	}
+}
+
+func song(songId uint32, duration string) *cmpb.Song {
+	return &cmpb.Song{SongId: songId, Metadata: &cmpb.Metadata{Duration: duration}}
+}
+
+func TestPlayer_playsSongsForTheirLength(t *testing.T) {
+	stream := newFakeStream()
+	player := New("kitchen", 100)
+
+	done := make(chan error)
+	go func() { done <- player.Run(context.Background(), stream) }()
+
+	if ready := stream.nextStatus(t); ready.Command != bepb.CommandType_Ready || ready.Zone != "kitchen" {
+		t.Fatalf("Expected the player to join the kitchen, got %v", ready)
+	}
+
+	// a ten second song takes a tenth of a second at a hundred times the speed
+	start := time.Now()
+	stream.controls <- &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: song(1, "PT10S")}
+	if playing := stream.nextStatus(t); playing.Command != bepb.CommandType_Playing || playing.SongId != 1 {
+		t.Fatalf("Expected the player to confirm the song started, got %v", playing)
+	}
+
+	if ready := stream.nextStatus(t); ready.Command != bepb.CommandType_Ready {
+		t.Fatalf("Expected the player to be ready once the song ended, got %v", ready)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the song to play for its length, but it ended after %v", elapsed)
+	}
+
+	// skipping past the last song leaves the player idle
+	stream.controls <- &bepb.PlayerControl{Command: bepb.CommandType_Play, Song: song(2, "PT10M")}
+	stream.nextStatus(t)
+	stream.controls <- &bepb.PlayerControl{Command: bepb.CommandType_Next}
+	if ready := stream.nextStatus(t); ready.Command != bepb.CommandType_Ready || player.NowPlaying() != nil {
+		t.Fatalf("Expected the player to be idle after the skip, got %v", ready)
+	}
+
+	if played := player.Played(); len(played) != 2 || played[0].SongId != 1 || played[1].SongId != 2 {
+		t.Errorf("Expected both songs played, got %v", played)
+	}
+
+	close(stream.controls)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the player to stop cleanly, got %v", err)
+	}
+}
+
+func TestPlayer_togglePause_holdsThePosition(t *testing.T) {
+	player := New("", 1)
+	player.done = make(chan struct{})
+	defer player.stop()
+
+	now := time.Now()
+	player.play(song(1, "PT3M"))
+	player.resumed = now
+
+	player.togglePause(now.Add(30 * time.Second))
+	if status := player.positionStatus(now.Add(time.Minute)); !status.Paused || status.Position != 30 {
+		t.Errorf("Expected the song paused at 30 seconds, got %v", status)
+	}
+
+	player.togglePause(now.Add(time.Minute))
+	if status := player.positionStatus(now.Add(70 * time.Second)); status.Paused || status.Position != 40 {
+		t.Errorf("Expected the song to resume from 30 seconds, got %v", status)
+	}
+}